@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+// batchOp is a lifecycle operation run on many VMs at once. Every phase
+// starts its task on all VMs still in the running, then waits for the tasks
+// together. A VM that fails a phase is skipped in the next ones, the others
+// carry on.
+type batchOp struct {
+	// Verb names the operation in errors, e.g. "stop".
+	Verb    string
+	Timeout time.Duration
+
+	// Pre, if set, runs first, e.g. to stop a VM before deleting it. It may
+	// return a nil task when there is nothing to do.
+	Pre func(ctx context.Context, vm *proxmox.VirtualMachine) (*proxmox.Task, error)
+	// Run starts the operation itself.
+	Run func(ctx context.Context, vm *proxmox.VirtualMachine) (*proxmox.Task, error)
+	// Done, if set, is called for every VM the operation succeeded on.
+	Done func(vm *proxmox.VirtualMachine)
+}
+
+// batchTargets are the flags selecting the VMs of a batch command, on top of
+// the name-or-id arguments.
+type batchTargets struct {
+	node     *string
+	all      *bool
+	tag      *string
+	nameGlob *string
+	status   *string
+}
+
+// addBatchFlags adds --node, --tag, --name-glob and --status, and --all if
+// allowAll is set, to cmd.
+func addBatchFlags(cmd *cobra.Command, allowAll bool) *batchTargets {
+	t := &batchTargets{
+		node:     cmd.PersistentFlags().String("node", "", "limit VM lookup to a specific node"),
+		tag:      cmd.PersistentFlags().String("tag", "", "also act on every VM with this tag"),
+		nameGlob: cmd.PersistentFlags().String("name-glob", "", "also act on every VM whose name matches this pattern, e.g. 'ci-*'"),
+		status:   cmd.PersistentFlags().String("status", "", "only act on the selected VMs with this status, e.g. running or stopped"),
+	}
+	if allowAll {
+		t.all = cmd.PersistentFlags().Bool("all", false, "act on every VM, templates excepted")
+	} else {
+		t.all = new(bool)
+	}
+	return t
+}
+
+// resolve returns the VMs selected by args and the flags.
+func (t *batchTargets) resolve(ctx context.Context, sess *session, args []string) ([]*proxmox.VirtualMachine, error) {
+	queries := append([]string{}, args...)
+	if *t.tag != "" {
+		queries = append(queries, "tag:"+*t.tag)
+	}
+	if t.nameGlob != nil && *t.nameGlob != "" {
+		queries = append(queries, "glob:"+*t.nameGlob)
+	}
+	if *t.all {
+		resources, err := sess.Resources(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			if r.Type == "qemu" && r.Template == 0 {
+				queries = append(queries, fmt.Sprintf("%d", r.VMID))
+			}
+		}
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no VMs selected, pass names or ids, --tag, --name-glob or --all")
+	}
+	vms, err := sess.ResolveVMs(ctx, queries, *t.node)
+	if err != nil || t.status == nil || *t.status == "" {
+		return vms, err
+	}
+	return filterVMStatus(vms, *t.status), nil
+}
+
+// filterVMStatus returns the VMs of vms with status, such as "running".
+func filterVMStatus(vms []*proxmox.VirtualMachine, status string) []*proxmox.VirtualMachine {
+	var matched []*proxmox.VirtualMachine
+	for _, vm := range vms {
+		if vm.Status == status {
+			matched = append(matched, vm)
+		}
+	}
+	return matched
+}
+
+// runBatch runs op on vms concurrently. It returns an error listing every VM
+// the operation failed on.
+func runBatch(ctx context.Context, vms []*proxmox.VirtualMachine, op batchOp) error {
+	errs := make([]error, len(vms))
+	if op.Pre != nil {
+		runBatchPhase(ctx, vms, errs, op.Timeout, op.Pre)
+	}
+	runBatchPhase(ctx, vms, errs, op.Timeout, op.Run)
+
+	var failed []error
+	for i, vm := range vms {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("vm %d (%s): %w", vm.VMID, vm.Name, errs[i]))
+		} else if op.Done != nil {
+			op.Done(vm)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s failed for %d of %d VMs:\n%w", op.Verb, len(failed), len(vms), errors.Join(failed...))
+	}
+	return nil
+}
+
+func runBatchPhase(ctx context.Context, vms []*proxmox.VirtualMachine, errs []error, timeout time.Duration, start func(context.Context, *proxmox.VirtualMachine) (*proxmox.Task, error)) {
+	tasks := make([]*proxmox.Task, len(vms))
+	for i, vm := range vms {
+		if errs[i] != nil {
+			continue
+		}
+		task, err := start(ctx, vm)
+		if err != nil {
+			errs[i] = fmt.Errorf("starting task gave err: %w", err)
+			continue
+		}
+		tasks[i] = task
+	}
+	for i, err := range waitOnTasks(ctx, tasks, time.Second, timeout) {
+		if err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+	}
+}
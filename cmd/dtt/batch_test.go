@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+	"github.com/luthermonson/go-proxmox"
+)
+
+func TestRunBatch(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web-1", Status: "running", Tags: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "web-2", Status: "running", Tags: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "db", Status: "running"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 103, Name: "base", Template: true})
+	server.Fail(http.MethodPost, "/nodes/pve/qemu/101/status/stop", http.StatusInternalServerError, "VM is locked (backup)")
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	all, tag := true, "web"
+	targets := &batchTargets{node: new(string), all: &all, tag: new(string)}
+	vms, err := targets.resolve(ctx, sess, nil)
+	if err != nil {
+		t.Fatalf("resolve(--all) gave err: %v", err)
+	}
+	if len(vms) != 3 {
+		t.Fatalf("Expected --all to select the 3 VMs that are no template, got %d", len(vms))
+	}
+
+	targets = &batchTargets{node: new(string), all: new(bool), tag: &tag}
+	vms, err = targets.resolve(ctx, sess, []string{"db"})
+	if err != nil {
+		t.Fatalf("resolve(db, --tag web) gave err: %v", err)
+	}
+
+	var done []int
+	err = runBatch(ctx, vms, batchOp{
+		Verb:    "stop",
+		Timeout: time.Minute,
+		Run: func(ctx context.Context, vm *proxmox.VirtualMachine) (*proxmox.Task, error) {
+			return vm.Stop(ctx)
+		},
+		Done: func(vm *proxmox.VirtualMachine) { done = append(done, int(vm.VMID)) },
+	})
+	if err == nil || !strings.Contains(err.Error(), "stop failed for 1 of 3 VMs") || !strings.Contains(err.Error(), "vm 101 (web-2)") {
+		t.Errorf("Expected the failure of VM 101 to be reported, got %v", err)
+	}
+	if len(done) != 2 || done[0] != 102 || done[1] != 100 {
+		t.Errorf("Expected VMs 102 and 100 to be stopped, got %v", done)
+	}
+	for vmid, want := range map[uint64]string{100: "stopped", 101: "running", 102: "stopped"} {
+		if got := server.VM(vmid).Status; got != want {
+			t.Errorf("VM %d is %s, want %s", vmid, got, want)
+		}
+	}
+
+	if _, err := (&batchTargets{node: new(string), all: new(bool), tag: new(string)}).resolve(ctx, sess, nil); err == nil {
+		t.Error("Expected an error when no VMs are selected")
+	}
+}
+
+func TestBatchTargetsSelectors(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "ci-1", Status: "running", Tags: "dtt;dtt-run"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "ci-2", Status: "stopped", Tags: "dtt;dtt-run"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "web", Status: "running"})
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	glob, status := "ci-*", "running"
+	targets := &batchTargets{node: new(string), all: new(bool), tag: new(string), nameGlob: &glob, status: new(string)}
+	vms, err := targets.resolve(ctx, sess, nil)
+	if err != nil {
+		t.Fatalf("resolve(--name-glob ci-*) gave err: %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("Expected --name-glob ci-* to select the 2 ci VMs, got %d", len(vms))
+	}
+
+	targets.status = &status
+	vms, err = targets.resolve(ctx, sess, []string{"web"})
+	if err != nil {
+		t.Fatalf("resolve(web, --name-glob ci-*, --status running) gave err: %v", err)
+	}
+	if len(vms) != 2 || vms[0].VMID != 102 || vms[1].VMID != 100 {
+		t.Errorf("Expected --status running to leave web and ci-1, got %d VMs", len(vms))
+	}
+
+	for _, tt := range []struct {
+		tag, glob, status string
+		want              bool
+	}{
+		{"dtt-run", "", "", true},
+		{"dtt-run", "ci-*", "running", true},
+		{"", "web*", "", false},
+		{"", "", "stopped", false},
+	} {
+		r := &proxmox.ClusterResource{Name: "ci-1", Status: "running", Tags: "dtt;dtt-run"}
+		if got := vmListSelects(r, tt.tag, tt.glob, tt.status); got != tt.want {
+			t.Errorf("vmListSelects(--tag %q --name-glob %q --status %q) = %v, want %v", tt.tag, tt.glob, tt.status, got, tt.want)
+		}
+	}
+}
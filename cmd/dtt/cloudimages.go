@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// cloudImage is where a release of a distro publishes its cloud image.
+type cloudImage struct {
+	URL string
+	// Format is the disk format of the image, qcow2 or raw. Proxmox goes by
+	// the file extension, which not every distro uses for it.
+	Format string
+}
+
+// importFilename returns the name to download the image to in the import
+// content of a storage, with the extension of its format.
+func (img cloudImage) importFilename() (string, error) {
+	name, err := extractFn(img.URL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + "." + img.Format, nil
+}
+
+// The releases of the distros that need more than the version to find their
+// image.
+var (
+	// fedoraComposes maps Fedora releases onto the compose of their cloud
+	// image, which is part of its name.
+	fedoraComposes = map[string]string{
+		"41": "1.4",
+		"42": "1.1",
+	}
+	// alpineReleases maps Alpine releases onto the point release their
+	// cloud image was last built for.
+	alpineReleases = map[string]string{
+		"3.20": "3.20.3",
+		"3.21": "3.21.0",
+	}
+	// enterpriseReleases are the major releases of Rocky Linux and AlmaLinux
+	// with a GenericCloud image.
+	enterpriseReleases = map[string]bool{"8": true, "9": true, "10": true}
+)
+
+// cloudImageFor returns the cloud image of version of distro, as parsed from
+// release.
+func cloudImageFor(distro string, version string, release string) (cloudImage, error) {
+	switch distro {
+	case "ubuntu":
+		// Ubuntu calls its qcow2 images .img.
+		return cloudImage{
+			URL:    fmt.Sprintf("https://cloud-images.ubuntu.com/minimal/daily/%s/current/%s-minimal-cloudimg-amd64.img", version, version),
+			Format: "qcow2",
+		}, nil
+	case "debian":
+		debRelease, ok := distro_versions["debian"][version]
+		if !ok {
+			return cloudImage{}, fmt.Errorf("unknown debian release %q in release specifier %q", version, release)
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://cdimage.debian.org/images/cloud/%s/latest/debian-%s-generic-amd64.qcow2", version, debRelease),
+			Format: "qcow2",
+		}, nil
+	case "fedora":
+		compose, ok := fedoraComposes[version]
+		if !ok {
+			return cloudImage{}, fmt.Errorf("unknown fedora release %q in release specifier %q (can be %s)", version, release, releaseNames(fedoraComposes))
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://download.fedoraproject.org/pub/fedora/linux/releases/%s/Cloud/x86_64/images/Fedora-Cloud-Base-Generic-%s-%s.x86_64.qcow2", version, version, compose),
+			Format: "qcow2",
+		}, nil
+	case "rocky":
+		if !enterpriseReleases[version] {
+			return cloudImage{}, fmt.Errorf("unknown rocky release %q in release specifier %q (can be 8, 9, 10)", version, release)
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://dl.rockylinux.org/pub/rocky/%s/images/x86_64/Rocky-%s-GenericCloud-Base.latest.x86_64.qcow2", version, version),
+			Format: "qcow2",
+		}, nil
+	case "alma", "almalinux":
+		if !enterpriseReleases[version] {
+			return cloudImage{}, fmt.Errorf("unknown almalinux release %q in release specifier %q (can be 8, 9, 10)", version, release)
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://repo.almalinux.org/almalinux/%s/cloud/x86_64/images/AlmaLinux-%s-GenericCloud-latest.x86_64.qcow2", version, version),
+			Format: "qcow2",
+		}, nil
+	case "opensuse":
+		if version == "tumbleweed" {
+			return cloudImage{
+				URL:    "https://download.opensuse.org/tumbleweed/appliances/openSUSE-Tumbleweed-Minimal-VM.x86_64-Cloud.qcow2",
+				Format: "qcow2",
+			}, nil
+		}
+		leap, ok := distro_versions["opensuse"][version]
+		if !ok {
+			return cloudImage{}, fmt.Errorf("unknown opensuse release %q in release specifier %q (can be %s)", version, release, releaseNames(distro_versions["opensuse"]))
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://download.opensuse.org/distribution/leap/%s/appliances/openSUSE-Leap-%s-Minimal-VM.x86_64-Cloud.qcow2", leap, leap),
+			Format: "qcow2",
+		}, nil
+	case "alpine":
+		point, ok := alpineReleases[version]
+		if !ok {
+			return cloudImage{}, fmt.Errorf("unknown alpine release %q in release specifier %q (can be %s)", version, release, releaseNames(alpineReleases))
+		}
+		return cloudImage{
+			URL:    fmt.Sprintf("https://dl-cdn.alpinelinux.org/alpine/v%s/releases/cloud/nocloud_alpine-%s-x86_64-bios-cloudinit-r0.qcow2", version, point),
+			Format: "qcow2",
+		}, nil
+	case "arch":
+		// Arch is rolling, there only is the latest image.
+		if version != "latest" {
+			return cloudImage{}, fmt.Errorf("unknown arch release %q in release specifier %q (can be latest)", version, release)
+		}
+		return cloudImage{
+			URL:    "https://geo.mirror.pkgbuild.com/images/latest/Arch-Linux-x86_64-cloudimg.qcow2",
+			Format: "qcow2",
+		}, nil
+	default:
+		return cloudImage{}, fmt.Errorf("can't recognize distro (ubuntu, debian, fedora, rocky, almalinux, opensuse, alpine or arch) in %q from %q", distro, release)
+	}
+}
+
+// releaseNames returns the keys of releases, sorted, for error messages.
+func releaseNames[V any](releases map[string]V) string {
+	names := make([]string, 0, len(releases))
+	for name := range releases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// knownReleases returns the release specifiers of every cloud image
+// cloudImageFor knows, sorted.
+func knownReleases() []string {
+	var releases []string
+	for _, distro := range []string{"ubuntu", "debian", "opensuse"} {
+		for name := range distro_versions[distro] {
+			releases = append(releases, distro+":"+name)
+		}
+	}
+	releases = append(releases, "opensuse:tumbleweed", "arch:latest")
+	for version := range fedoraComposes {
+		releases = append(releases, "fedora:"+version)
+	}
+	for version := range alpineReleases {
+		releases = append(releases, "alpine:"+version)
+	}
+	for version := range enterpriseReleases {
+		releases = append(releases, "rocky:"+version, "almalinux:"+version)
+	}
+	sort.Strings(releases)
+	return releases
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCloudImageFor(t *testing.T) {
+	for _, tc := range []struct {
+		release  string
+		url      string
+		filename string
+	}{
+		{"ubuntu:24.04", "https://cloud-images.ubuntu.com/minimal/daily/noble/current/noble-minimal-cloudimg-amd64.img", "noble-minimal-cloudimg-amd64.qcow2"},
+		{"debian:12", "https://cdimage.debian.org/images/cloud/bookworm/latest/debian-12-generic-amd64.qcow2", "debian-12-generic-amd64.qcow2"},
+		{"fedora:42", "https://download.fedoraproject.org/pub/fedora/linux/releases/42/Cloud/x86_64/images/Fedora-Cloud-Base-Generic-42-1.1.x86_64.qcow2", "Fedora-Cloud-Base-Generic-42-1.1.x86_64.qcow2"},
+		{"rocky:9", "https://dl.rockylinux.org/pub/rocky/9/images/x86_64/Rocky-9-GenericCloud-Base.latest.x86_64.qcow2", "Rocky-9-GenericCloud-Base.latest.x86_64.qcow2"},
+		{"alma:9", "https://repo.almalinux.org/almalinux/9/cloud/x86_64/images/AlmaLinux-9-GenericCloud-latest.x86_64.qcow2", "AlmaLinux-9-GenericCloud-latest.x86_64.qcow2"},
+		{"opensuse:15.6", "https://download.opensuse.org/distribution/leap/15.6/appliances/openSUSE-Leap-15.6-Minimal-VM.x86_64-Cloud.qcow2", "openSUSE-Leap-15.6-Minimal-VM.x86_64-Cloud.qcow2"},
+		{"opensuse:tumbleweed", "https://download.opensuse.org/tumbleweed/appliances/openSUSE-Tumbleweed-Minimal-VM.x86_64-Cloud.qcow2", "openSUSE-Tumbleweed-Minimal-VM.x86_64-Cloud.qcow2"},
+		{"alpine:3.20", "https://dl-cdn.alpinelinux.org/alpine/v3.20/releases/cloud/nocloud_alpine-3.20.3-x86_64-bios-cloudinit-r0.qcow2", "nocloud_alpine-3.20.3-x86_64-bios-cloudinit-r0.qcow2"},
+		{"arch:latest", "https://geo.mirror.pkgbuild.com/images/latest/Arch-Linux-x86_64-cloudimg.qcow2", "Arch-Linux-x86_64-cloudimg.qcow2"},
+	} {
+		distro, version, err := extractDistroVersionFromRelease(tc.release)
+		if err != nil {
+			t.Errorf("extractDistroVersionFromRelease(%q) gave err: %v", tc.release, err)
+			continue
+		}
+		img, err := cloudImageFor(distro, version, tc.release)
+		if err != nil {
+			t.Errorf("cloudImageFor(%q) gave err: %v", tc.release, err)
+			continue
+		}
+		if img.URL != tc.url {
+			t.Errorf("cloudImageFor(%q) = %s, want %s", tc.release, img.URL, tc.url)
+		}
+		if name, err := img.importFilename(); err != nil || name != tc.filename {
+			t.Errorf("importFilename of %q = %q, %v, want %q", tc.release, name, err, tc.filename)
+		}
+	}
+
+	for _, release := range []string{"fedora:30", "rocky:7", "opensuse:42.3", "alpine:edge", "arch:2024", "gentoo:latest"} {
+		distro, version, err := extractDistroVersionFromRelease(release)
+		if err != nil {
+			continue
+		}
+		if _, err := cloudImageFor(distro, version, release); err == nil {
+			t.Errorf("Expected cloudImageFor(%q) to fail", release)
+		}
+	}
+
+	raw := cloudImage{URL: "https://example.com/images/disk.img", Format: "raw"}
+	if name, _ := raw.importFilename(); name != "disk.raw" {
+		t.Errorf("Expected a raw image to be imported as disk.raw, got %q", name)
+	}
+}
+
+func TestKnownReleases(t *testing.T) {
+	for _, release := range knownReleases() {
+		if _, err := releaseImage(release); err != nil {
+			t.Errorf("releaseImage(%q) of a known release gave err: %v", release, err)
+		}
+	}
+}
+
+func TestRefreshImages(t *testing.T) {
+	images, err := refreshImages([]string{"Ubuntu 24.04 LTS", "debian:12"})
+	if err != nil {
+		t.Fatalf("refreshImages() gave err: %v", err)
+	}
+	if len(images) != 2 || images[0].Name != "Ubuntu 24.04 LTS" || images[1].URL != "https://cdimage.debian.org/images/cloud/bookworm/latest/debian-12-generic-amd64.qcow2" {
+		t.Errorf("refreshImages() = %+v", images)
+	}
+
+	for _, name := range []string{"Gentoo", "fedora:30"} {
+		if _, err := refreshImages([]string{name}); !errors.Is(err, ErrUsage) {
+			t.Errorf("Expected refreshImages(%q) to give a usage error, got %v", name, err)
+		}
+	}
+
+	all, err := refreshImages(nil)
+	if err != nil {
+		t.Fatalf("refreshImages() of every image gave err: %v", err)
+	}
+	if len(all) <= len(knownReleases()) {
+		t.Errorf("Expected the catalog and the releases, got %d images", len(all))
+	}
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/ssh"
 	px "github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
@@ -38,8 +43,16 @@ var (
 	agentExecCommand = &cobra.Command{
 		Use:   "exec <name-or-id> <command> [args...]",
 		Short: "execute a command in guest using qemu guest agent",
-		Args:  cobra.MinimumNArgs(2),
-		RunE:  command_agent_exec,
+		Long: `Execute a command in a VM through its guest agent. With --wait its output
+is printed once it exits, with --stream while it runs. The guest agent only
+hands out the output of exited commands, so --stream sends it to files in
+/tmp of the guest and reads those as they grow. --stream, --env and --cwd
+need sh and env in the guest.
+
+  dtt agent exec web -- uname -a
+  dtt agent exec web --stream --timeout 600 --cwd /srv -- make test`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: command_agent_exec,
 	}
 
 	agentExecStatusCommand = &cobra.Command{
@@ -56,14 +69,42 @@ var (
 		RunE:  command_agent_set_user_password,
 	}
 
+	agentFileReadCommand = &cobra.Command{
+		Use:   "file-read <name-or-id> <guest-path> [local-path]",
+		Short: "copy a file out of a guest using qemu guest agent",
+		Long: `Copy a file out of a VM through its guest agent, without SSH or a network
+path to the VM. It goes to stdout without a local path, or with -.
+
+  dtt agent file-read web /var/log/syslog syslog`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: command_agent_file_read,
+	}
+
+	agentFileWriteCommand = &cobra.Command{
+		Use:   "file-write <name-or-id> <local-path> <guest-path>",
+		Short: "copy a file into a guest using qemu guest agent",
+		Long: `Copy a file into a VM through its guest agent, without SSH or a network
+path to the VM. Use - as the local path to read stdin. Files of more than
+45 KiB, and --mode, need sh, cat and chmod in the guest.
+
+  dtt agent file-write web ./server /usr/local/bin/server --mode 755`,
+		Args: cobra.ExactArgs(3),
+		RunE: command_agent_file_write,
+	}
+
 	FlagAgentNode *string
 
 	FlagAgentExecInput   *string
 	FlagAgentExecWait    *bool
 	FlagAgentExecTimeout *int
+	FlagAgentExecStream  *bool
+	FlagAgentExecEnv     *[]string
+	FlagAgentExecCwd     *string
 
 	FlagAgentSetUserPasswordUsername *string
 	FlagAgentSetUserPasswordPassword *string
+
+	FlagAgentFileWriteMode *string
 )
 
 func init() {
@@ -73,17 +114,24 @@ func init() {
 	agentCommand.AddCommand(agentExecCommand)
 	agentCommand.AddCommand(agentExecStatusCommand)
 	agentCommand.AddCommand(agentSetUserPasswordCommand)
+	agentCommand.AddCommand(agentFileReadCommand)
+	agentCommand.AddCommand(agentFileWriteCommand)
 
 	FlagAgentNode = agentCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
 
 	FlagAgentExecInput = agentExecCommand.Flags().String("input", "", "stdin input passed to agent exec")
 	FlagAgentExecWait = agentExecCommand.Flags().Bool("wait", true, "wait for command completion")
-	FlagAgentExecTimeout = agentExecCommand.Flags().Int("timeout", 30, "seconds to wait when --wait is true")
+	FlagAgentExecTimeout = agentExecCommand.Flags().Int("timeout", 30, "seconds to wait when --wait or --stream is true")
+	FlagAgentExecStream = agentExecCommand.Flags().Bool("stream", false, "print the output of the command while it runs")
+	FlagAgentExecEnv = agentExecCommand.Flags().StringArray("env", nil, "KEY=VALUE to set in the environment of the command, can be repeated")
+	FlagAgentExecCwd = agentExecCommand.Flags().String("cwd", "", "directory in the guest to run the command in")
 
 	FlagAgentSetUserPasswordUsername = agentSetUserPasswordCommand.Flags().String("username", "", "guest username")
 	FlagAgentSetUserPasswordPassword = agentSetUserPasswordCommand.Flags().String("password", "", "new guest password")
 	_ = agentSetUserPasswordCommand.MarkFlagRequired("username")
 	_ = agentSetUserPasswordCommand.MarkFlagRequired("password")
+
+	FlagAgentFileWriteMode = agentFileWriteCommand.Flags().String("mode", "", "octal mode to give the file in the guest, e.g. 755 (default: leave it)")
 }
 
 func command_agent_list(cmd *cobra.Command, args []string) error {
@@ -94,6 +142,8 @@ func command_agent_list(cmd *cobra.Command, args []string) error {
 	fmt.Fprintln(writer, "exec\tExecute command in guest")
 	fmt.Fprintln(writer, "exec-status\tGet status/output for exec pid")
 	fmt.Fprintln(writer, "set-user-password\tUpdate guest user password")
+	fmt.Fprintln(writer, "file-read\tCopy a file out of the guest")
+	fmt.Fprintln(writer, "file-write\tCopy a file into the guest")
 	if err := writer.Flush(); err != nil {
 		return fmt.Errorf("flushing agent list writer gave err: %w", err)
 	}
@@ -168,7 +218,25 @@ func command_agent_exec(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("finding VM for agent exec gave err: %w", err)
 	}
 
-	guestCmd := args[1:]
+	guestCmd, err := guestCommand(args[1:], *FlagAgentExecEnv, *FlagAgentExecCwd)
+	if err != nil {
+		return err
+	}
+
+	if *FlagAgentExecStream {
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(*FlagAgentExecTimeout)*time.Second)
+		defer cancel()
+		client := getSession().Provisioner(vm.Node, "", "")
+		err := client.AgentStream(ctx, int(vm.VMID), guestCmd, *FlagAgentExecInput, cmd.OutOrStdout(), cmd.ErrOrStderr())
+		if code, ok := ssh.ExitCode(err); ok && code != 0 {
+			return fmt.Errorf("agent exec failed: exit code %d", code)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("agent exec still runs after %ds, raise --timeout to wait longer: %w", *FlagAgentExecTimeout, err)
+		}
+		return err
+	}
+
 	pid, err := vm.AgentExec(ctx, guestCmd, *FlagAgentExecInput)
 	if err != nil {
 		return fmt.Errorf("executing agent command gave err: %w", err)
@@ -193,6 +261,23 @@ func command_agent_exec(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// guestCommand returns command wrapped to run in cwd with env added to its
+// environment, unless they are empty.
+func guestCommand(command []string, env []string, cwd string) ([]string, error) {
+	if cwd != "" {
+		command = append([]string{"sh", "-c", `cd "$1" && shift && exec "$@"`, "sh", cwd}, command...)
+	}
+	if len(env) > 0 {
+		for _, kv := range env {
+			if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+				return nil, fmt.Errorf("%w: --env %q is not KEY=VALUE", ErrUsage, kv)
+			}
+		}
+		command = append(append([]string{"env"}, env...), command...)
+	}
+	return command, nil
+}
+
 func command_agent_exec_status(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	vm, err := findQemuVMForAgent(ctx, args[0])
@@ -241,67 +326,99 @@ func command_agent_set_user_password(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func findQemuVMForAgent(ctx context.Context, query string) (*px.VirtualMachine, error) {
-	pac := getPACFromFlags()
-	cluster, err := pac.Cluster(ctx)
+func command_agent_file_read(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	vm, err := findQemuVMForAgent(ctx, args[0])
 	if err != nil {
-		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+		return fmt.Errorf("finding VM for agent file-read gave err: %w", err)
 	}
 
-	resources, err := cluster.Resources(ctx)
+	client := getSession().Provisioner(vm.Node, "", "")
+	data, err := client.AgentReadFile(ctx, int(vm.VMID), args[1])
 	if err != nil {
-		return nil, fmt.Errorf("getting cluster resources gave err: %w", err)
+		return fmt.Errorf("reading guest file gave err: %w", err)
 	}
 
-	type candidate struct {
-		Node string
-		VMID uint64
-		Name string
+	if len(args) < 3 || args[2] == "-" {
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	}
+	if err := os.WriteFile(args[2], data, 0o644); err != nil {
+		return fmt.Errorf("writing %s gave err: %w", args[2], err)
 	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "copied %s (%d bytes) from vm %d to %s\n", args[1], len(data), vm.VMID, args[2])
+	return nil
+}
 
-	vmid, vmidQuery := parseVMIDArg(query)
-	matches := make([]candidate, 0, 1)
+func command_agent_file_write(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
 
-	for _, r := range resources {
-		if r.Type != "qemu" {
-			continue
-		}
-		if strings.TrimSpace(*FlagAgentNode) != "" && r.Node != *FlagAgentNode {
-			continue
+	var mode os.FileMode
+	if *FlagAgentFileWriteMode != "" {
+		m, err := strconv.ParseUint(*FlagAgentFileWriteMode, 8, 32)
+		if err != nil || m == 0 || m > 0o7777 {
+			return fmt.Errorf("%w: --mode %q is no octal file mode such as 755", ErrUsage, *FlagAgentFileWriteMode)
 		}
+		mode = os.FileMode(m)
+	}
 
-		if vmidQuery {
-			if r.VMID != vmid {
-				continue
-			}
-		} else if r.Name != query {
-			continue
-		}
+	var data []byte
+	var err error
+	if args[1] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s gave err: %w", args[1], err)
+	}
 
-		matches = append(matches, candidate{Node: r.Node, VMID: r.VMID, Name: r.Name})
+	vm, err := findQemuVMForAgent(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("finding VM for agent file-write gave err: %w", err)
 	}
 
-	if len(matches) == 0 {
-		if strings.TrimSpace(*FlagAgentNode) != "" {
-			return nil, fmt.Errorf("vm %q not found on node %q", query, *FlagAgentNode)
-		}
-		return nil, fmt.Errorf("vm %q not found", query)
+	client := getSession().Provisioner(vm.Node, "", "")
+	if err := client.AgentWriteFile(ctx, int(vm.VMID), args[2], data, mode); err != nil {
+		return fmt.Errorf("writing guest file gave err: %w", err)
 	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "copied %s (%d bytes) to %s on vm %d\n", args[1], len(data), args[2], vm.VMID)
+	return nil
+}
 
-	if len(matches) > 1 {
-		conflicts := make([]string, 0, len(matches))
-		for _, m := range matches {
-			conflicts = append(conflicts, fmt.Sprintf("%s/%d(%s)", m.Node, m.VMID, m.Name))
-		}
-		return nil, fmt.Errorf("multiple VMs matched %q: %s; pass VMID or --node", query, strings.Join(conflicts, ", "))
+func findQemuVMForAgent(ctx context.Context, query string) (*px.VirtualMachine, error) {
+	return findQemuVM(ctx, getSession().pac, query, *FlagAgentNode)
+}
+
+// findQemuVM resolves a VM query, see dttproxmox.MatchVMs, to a single VM,
+// optionally limited to one node. It fails if nothing matches or if the query
+// is ambiguous.
+func findQemuVM(ctx context.Context, pac dttproxmox.ProxmoxAPI, query string, nodeFilter string) (*px.VirtualMachine, error) {
+	cluster, err := pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", dttproxmox.WrapError(err))
 	}
 
-	node, err := pac.Node(ctx, matches[0].Node)
+	resources, err := cluster.Resources(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getting node %s gave err: %w", matches[0].Node, err)
+		return nil, fmt.Errorf("getting cluster resources gave err: %w", dttproxmox.WrapError(err))
 	}
 
-	return node.VirtualMachine(ctx, int(matches[0].VMID))
+	r, err := dttproxmox.MatchVM(resources, query, nodeFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := pac.Node(ctx, r.Node)
+	if err != nil {
+		return nil, fmt.Errorf("getting node %s gave err: %w", r.Node, err)
+	}
+
+	vm, err := node.VirtualMachine(ctx, int(r.VMID))
+	if err != nil {
+		return nil, dttproxmox.WrapError(err)
+	}
+	return vm, nil
 }
 
 func writeAgentExecOutputs(status *px.AgentExecStatus) {
@@ -331,4 +448,4 @@ func decodeAgentExecData(s string) string {
 		return s
 	}
 	return string(decoded)
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestFindQemuVM(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve1")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve1", VMID: 100, Name: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve1", VMID: 101, Name: "db"})
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 102, Name: "db"})
+
+	pac := server.Client()
+
+	tests := []struct {
+		name     string
+		query    string
+		node     string
+		wantVMID uint64
+		wantErr  error
+	}{
+		{name: "by name", query: "web", wantVMID: 100},
+		{name: "by vmid", query: "102", wantVMID: 102},
+		{name: "ambiguous name", query: "db", wantErr: dttproxmox.ErrAmbiguousName},
+		{name: "ambiguous name with node", query: "db", node: "pve2", wantVMID: 102},
+		{name: "not found", query: "nope", wantErr: dttproxmox.ErrVMNotFound},
+		{name: "not on node", query: "web", node: "pve2", wantErr: dttproxmox.ErrVMNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, err := findQemuVM(context.Background(), pac, tt.query, tt.node)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findQemuVM(%q) gave err: %v", tt.query, err)
+			}
+			if uint64(vm.VMID) != tt.wantVMID {
+				t.Errorf("Expected VMID %d, got %d", tt.wantVMID, vm.VMID)
+			}
+		})
+	}
+}
+
+func TestGuestCommand(t *testing.T) {
+	tests := []struct {
+		env  []string
+		cwd  string
+		want string
+	}{
+		{want: "make test"},
+		{cwd: "/srv", want: `sh -c cd "$1" && shift && exec "$@" sh /srv make test`},
+		{env: []string{"A=1", "B="}, cwd: "/srv", want: `env A=1 B= sh -c cd "$1" && shift && exec "$@" sh /srv make test`},
+	}
+	for _, tt := range tests {
+		got, err := guestCommand([]string{"make", "test"}, tt.env, tt.cwd)
+		if err != nil {
+			t.Errorf("guestCommand(%v, %q) gave err: %v", tt.env, tt.cwd, err)
+			continue
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("guestCommand(%v, %q) = %q, want %q", tt.env, tt.cwd, got, tt.want)
+		}
+	}
+
+	if _, err := guestCommand([]string{"true"}, []string{"NOVALUE"}, ""); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected an --env without = to give a usage error, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	apiCommand = &cobra.Command{
+		Use:   "api",
+		Short: "serve a REST API that creates VMs and runs binaries",
+		Long: `Serve a REST API that runs the provisioning and run operations as
+background jobs, so CI systems and web UIs can use dtt without holding the
+Proxmox credentials themselves:
+
+  POST   /v1/runs             run a binary (multipart: "binary" file, "options" JSON)
+  POST   /v1/vms              create a VM from JSON VM options
+  DELETE /v1/vms/{vmid}       destroy a VM tagged dtt
+  POST   /v1/gc               delete the VMs whose TTL ran out
+  GET    /v1/jobs             list jobs
+  GET    /v1/jobs/{id}        get a job with its status, log and output
+  POST   /v1/jobs/{id}/cancel cancel a job
+  GET    /v1/images           list the images
+
+Requests that start a job answer 202 with the job, poll it for the outcome.
+Creating requests take a TTL for the VM as a query parameter, e.g. ?ttl=2h.
+Clients authenticate with "Authorization: Bearer <token>".`,
+		Args: cobra.NoArgs,
+		RunE: command_api,
+	}
+
+	FlagAPIListen       *string
+	FlagAPIToken        *string
+	FlagAPINode         *string
+	FlagAPIImageStorage *string
+	FlagAPIDiskStorage  *string
+	FlagAPIParallel     *int
+	FlagAPIGCInterval   *time.Duration
+	FlagAPIJobTTL       *time.Duration
+)
+
+func init() {
+	FlagAPIListen = apiCommand.PersistentFlags().String("listen", ":8080", "address to serve the API on")
+	FlagAPIToken = apiCommand.PersistentFlags().String("token", "", "bearer token clients have to send (or set DTT_API_TOKEN)")
+	FlagAPINode = apiCommand.PersistentFlags().String("node", "pve", "which node to create VMs on")
+	FlagAPIImageStorage = apiCommand.PersistentFlags().String("image-storage", "local", "storage for cloud images (needs import content) and the cloud-init drive")
+	FlagAPIDiskStorage = apiCommand.PersistentFlags().String("disk-storage", "local-lvm", "storage for VM disks")
+	FlagAPIParallel = apiCommand.PersistentFlags().Int("parallel", 4, "how many jobs run at the same time")
+	FlagAPIGCInterval = apiCommand.PersistentFlags().Duration("gc-interval", 0, "delete expired dtt VMs this often, e.g. 10m (default: only on POST /v1/gc)")
+
+	FlagAPIJobTTL = apiCommand.PersistentFlags().Duration("job-ttl", 24*time.Hour, "how long finished jobs can be polled")
+
+	rootCmd.AddCommand(apiCommand)
+}
+
+func command_api(cmd *cobra.Command, args []string) error {
+	token := flagOrEnv(*FlagAPIToken, "DTT_API_TOKEN")
+	if token == "" {
+		slog.Warn("no --token or DTT_API_TOKEN set, the API is open to anyone who can reach it")
+	}
+
+	api := dtt.NewServer(dtt.ServerConfig{
+		Proxmox: dttproxmox.ClientConfig{
+			Node:         *FlagAPINode,
+			ImageStorage: *FlagAPIImageStorage,
+			DiskStorage:  *FlagAPIDiskStorage,
+		},
+		API:        getSession().pac,
+		Token:      token,
+		Parallel:   *FlagAPIParallel,
+		GCInterval: *FlagAPIGCInterval,
+		JobTTL:     *FlagAPIJobTTL,
+	})
+	defer api.Close()
+
+	server := &http.Server{
+		Addr:              *FlagAPIListen,
+		Handler:           api,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("serving the dtt API", "address", *FlagAPIListen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving the API on %s gave err: %w", *FlagAPIListen, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyCommand = &cobra.Command{
+		Use:   "apply [-f manifest]",
+		Short: "create and update VMs to match a manifest",
+		Long: `Reconcile the node with a YAML manifest declaring VMs, dtt.yaml in the
+current directory unless -f names another:
+
+  machines:
+    - name: web
+      release: ubuntu-24.04
+      memory: 2048
+      cores: 2
+      networks:
+        - virtio,bridge=vmbr0
+        - virtio,bridge=vmbr1,tag=20
+      cloud_init:
+        user: admin
+        ssh_public_key: ssh-ed25519 AAAA...
+      files:
+        - source: ./web.service
+          destination: /tmp/web.service
+          mode: "0644"
+      commands:
+        - sudo mv /tmp/web.service /etc/systemd/system/
+
+Machines are matched to VMs by name, or by vmid when set. Missing machines are
+created, then get their files uploaded and their commands run. Machines whose
+memory, sockets or cores drifted are updated, running VMs apply the update on
+their next boot.`,
+		Args: cobra.NoArgs,
+		RunE: command_apply,
+	}
+
+	destroyCommand = &cobra.Command{
+		Use:   "destroy [-f manifest]",
+		Short: "delete the VMs of a manifest",
+		Long: `Stop and delete the VMs of a manifest, dtt.yaml in the current directory
+unless -f names another. Machines without a VM are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: command_destroy,
+	}
+
+	FlagApplyFile         *string
+	FlagApplyNode         *string
+	FlagApplyImageStorage *string
+	FlagApplyDiskStorage  *string
+	FlagApplyDryRun       *bool
+
+	FlagDestroyFile *string
+	FlagDestroyNode *string
+)
+
+// defaultManifest is the manifest apply and destroy read without -f, like
+// docker compose reads compose.yaml.
+const defaultManifest = "dtt.yaml"
+
+func init() {
+	FlagApplyFile = applyCommand.PersistentFlags().StringP("file", "f", defaultManifest, "manifest to apply")
+	FlagApplyNode = applyCommand.PersistentFlags().String("node", "pve", "which node the VMs live on")
+	FlagApplyImageStorage = applyCommand.PersistentFlags().String("image-storage", "local", "storage for cloud images (needs import content) and the cloud-init drive")
+	FlagApplyDiskStorage = applyCommand.PersistentFlags().String("disk-storage", "local-lvm", "storage for VM disks")
+	FlagApplyDryRun = applyCommand.PersistentFlags().Bool("dry-run", false, "only show what would change")
+
+	FlagDestroyFile = destroyCommand.PersistentFlags().StringP("file", "f", defaultManifest, "manifest whose VMs to delete")
+	FlagDestroyNode = destroyCommand.PersistentFlags().String("node", "pve", "which node the VMs live on")
+
+	rootCmd.AddCommand(applyCommand)
+	rootCmd.AddCommand(destroyCommand)
+}
+
+func command_apply(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if *FlagApplyFile == "" {
+		return missingInput("-f <manifest> must not be empty")
+	}
+	manifest, err := dtt.LoadManifest(*FlagApplyFile)
+	if err != nil {
+		return err
+	}
+
+	sess := getSession()
+	client := dtt.NewWithAPI(dttproxmox.ClientConfig{
+		Node:         *FlagApplyNode,
+		ImageStorage: *FlagApplyImageStorage,
+		DiskStorage:  *FlagApplyDiskStorage,
+		Progress:     progressOutput(),
+		TaskLog:      sess.taskLog,
+	}, sess.pac)
+
+	if *FlagApplyDryRun {
+		actions, err := client.Plan(ctx, manifest)
+		if err != nil {
+			return fmt.Errorf("planning %s gave err: %w", *FlagApplyFile, err)
+		}
+		printActions(actions)
+		return nil
+	}
+
+	actions, err := client.Apply(ctx, manifest)
+	printActions(actions)
+	if err != nil {
+		return fmt.Errorf("applying %s gave err: %w", *FlagApplyFile, err)
+	}
+	return nil
+}
+
+func command_destroy(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if *FlagDestroyFile == "" {
+		return missingInput("-f <manifest> must not be empty")
+	}
+	manifest, err := dtt.LoadManifest(*FlagDestroyFile)
+	if err != nil {
+		return err
+	}
+
+	sess := getSession()
+	client := dtt.NewWithAPI(dttproxmox.ClientConfig{
+		Node:     *FlagDestroyNode,
+		Progress: progressOutput(),
+		TaskLog:  sess.taskLog,
+	}, sess.pac)
+
+	actions, err := client.Destroy(ctx, manifest)
+	printActions(actions)
+	for _, a := range actions {
+		if a.Kind == dtt.ActionDestroy {
+			sess.cache.forgetVM(*FlagDestroyNode, a.VMID)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("destroying %s gave err: %w", *FlagDestroyFile, err)
+	}
+	return nil
+}
+
+func printActions(actions []dtt.Action) {
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	configCommand = &cobra.Command{
+		Use:   "config",
+		Short: "manage connection profiles",
+		Long: `Manage the connection profiles in ~/.config/dtt/config.yaml, or the file
+DTT_CONFIG names. Commands connect with the current profile, or the one
+--profile or DTT_PROFILE names, for the flags not given on the command line.`,
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	configSetCommand = &cobra.Command{
+		Use:   "set <profile>",
+		Short: "create or update a profile from the connection flags given",
+		Long: `Create or update a profile from the --proxmox-* connection flags given,
+and --node, --storage, --ssh-user and --ssh-private-key. Flags left out keep their value in the profile. The
+first profile becomes the current one.
+
+  dtt config set lab --proxmox-host pve.lab.example.com \
+    --proxmox-token-id 'dtt@pve!cli' --proxmox-token-secret "$SECRET" --node pve2`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_config_set,
+	}
+
+	configListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list the profiles, marking the current one",
+		Args:  cobra.NoArgs,
+		RunE:  command_config_list,
+	}
+
+	configUseCommand = &cobra.Command{
+		Use:   "use <profile>",
+		Short: "switch the current profile",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_config_use,
+	}
+
+	FlagConfigSetNode    *string
+	FlagConfigSetStorage *string
+	FlagConfigSetSSHUser *string
+	FlagConfigSetSSHKey  *string
+	FlagConfigListOutput *string
+)
+
+func init() {
+	FlagConfigSetNode = configSetCommand.PersistentFlags().String("node", "", "default node for commands taking --node")
+	FlagConfigSetStorage = configSetCommand.PersistentFlags().String("storage", "", "default storage for commands taking --storage")
+	FlagConfigSetSSHUser = configSetCommand.PersistentFlags().String("ssh-user", "", "user dtt vm ssh logs in to VMs as")
+	FlagConfigSetSSHKey = configSetCommand.PersistentFlags().String("ssh-private-key", "", "private key dtt vm ssh logs in to VMs with")
+	FlagConfigListOutput = addOutputFlag(configListCommand)
+
+	configCommand.AddCommand(configSetCommand)
+	configCommand.AddCommand(configListCommand)
+	configCommand.AddCommand(configUseCommand)
+	rootCmd.AddCommand(configCommand)
+}
+
+func command_config_set(cmd *cobra.Command, args []string) error {
+	flags := rootCmd.PersistentFlags()
+	if flags.Changed("proxmox-password") {
+		return fmt.Errorf("%w: profiles don't keep passwords, use a token or DTT_PROXMOX_PASSWORD", ErrUsage)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	name := args[0]
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		p = &profile{}
+		cfg.Profiles[name] = p
+	}
+	if flags.Changed("proxmox-host") {
+		p.Host = *FlagHost
+	}
+	if flags.Changed("proxmox-port") {
+		p.Port = *FlagPort
+	}
+	if flags.Changed("proxmox-user") {
+		p.User = *FlagUserName
+	}
+	if flags.Changed("proxmox-token-id") {
+		p.TokenID = *FlagTokenID
+	}
+	if flags.Changed("proxmox-token-secret") {
+		p.TokenSecret = *FlagTokenSecret
+	}
+	if flags.Changed("proxmox-insecure") {
+		insecure := *FlagInsecure
+		p.Insecure = &insecure
+	}
+	if cmd.Flags().Changed("node") {
+		p.Node = *FlagConfigSetNode
+	}
+	if cmd.Flags().Changed("storage") {
+		p.Storage = *FlagConfigSetStorage
+	}
+	if cmd.Flags().Changed("ssh-user") {
+		p.SSHUser = *FlagConfigSetSSHUser
+	}
+	if cmd.Flags().Changed("ssh-private-key") {
+		p.SSHPrivateKey = *FlagConfigSetSSHKey
+	}
+	if cfg.Current == "" {
+		cfg.Current = name
+	}
+
+	if err := saveConfig(path, cfg); err != nil {
+		return fmt.Errorf("writing %s gave err: %w", path, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "saved profile %s to %s\n", name, path)
+	return nil
+}
+
+// configListRow is a profile as config list shows it, without the token
+// secret.
+type configListRow struct {
+	Name    string `json:"name" yaml:"name"`
+	Current bool   `json:"current" yaml:"current"`
+	Host    string `json:"host,omitempty" yaml:"host,omitempty"`
+	Port    int    `json:"port,omitempty" yaml:"port,omitempty"`
+	User    string `json:"user,omitempty" yaml:"user,omitempty"`
+	TokenID string `json:"token_id,omitempty" yaml:"token_id,omitempty"`
+	Node    string `json:"node,omitempty" yaml:"node,omitempty"`
+	Storage string `json:"storage,omitempty" yaml:"storage,omitempty"`
+	SSHUser string `json:"ssh_user,omitempty" yaml:"ssh_user,omitempty"`
+	SSHKey  string `json:"ssh_private_key,omitempty" yaml:"ssh_private_key,omitempty"`
+}
+
+func command_config_list(cmd *cobra.Command, args []string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]configListRow, 0, len(cfg.Profiles))
+	for _, name := range cfg.profileNames() {
+		p := cfg.Profiles[name]
+		rows = append(rows, configListRow{
+			Name:    name,
+			Current: name == cfg.Current,
+			Host:    p.Host,
+			Port:    p.Port,
+			User:    p.User,
+			TokenID: p.TokenID,
+			Node:    p.Node,
+			Storage: p.Storage,
+			SSHUser: p.SSHUser,
+			SSHKey:  p.SSHPrivateKey,
+		})
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagConfigListOutput, rows, func(out io.Writer) error {
+		if len(rows) == 0 {
+			fmt.Fprintf(out, "No profiles in %s, add one with dtt config set.\n", path)
+			return nil
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CURRENT\tNAME\tHOST\tAUTH\tNODE\tSTORAGE")
+		for _, row := range rows {
+			current := ""
+			if row.Current {
+				current = "*"
+			}
+			auth := row.TokenID
+			if auth == "" {
+				auth = row.User
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", current, row.Name, orDash(row.Host), orDash(auth), orDash(row.Node), orDash(row.Storage))
+		}
+		return w.Flush()
+	})
+}
+
+func command_config_use(cmd *cobra.Command, args []string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[args[0]]; !ok {
+		return fmt.Errorf("%w: no profile %q in %s", ErrUsage, args[0], path)
+	}
+	cfg.Current = args[0]
+	if err := saveConfig(path, cfg); err != nil {
+		return fmt.Errorf("writing %s gave err: %w", path, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "switched to profile %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var ctCommand = &cobra.Command{
+	Use:   "ct",
+	Short: "LXC container commands",
+	Long: `LXC container commands.
+
+Containers are created from templates, see dtt ct template. Commands taking a
+<name-or-id> accept the same queries as the vm commands: a VMID, an exact
+hostname, name:<name>, tag:<tag> or re:<regex>.`,
+}
+
+func init() {
+	rootCmd.AddCommand(ctCommand)
+}
+
+// addCtTargetFlags adds --node and --tag to a ct command acting on several
+// containers.
+func addCtTargetFlags(cmd *cobra.Command) *batchTargets {
+	return &batchTargets{
+		node: cmd.PersistentFlags().String("node", "", "limit container lookup to a specific node"),
+		tag:  cmd.PersistentFlags().String("tag", "", "also act on every container with this tag"),
+		all:  new(bool),
+	}
+}
+
+// resolveContainers returns the containers selected by args and the flags.
+func (t *batchTargets) resolveContainers(ctx context.Context, sess *session, args []string) ([]*proxmox.Container, error) {
+	queries := append([]string{}, args...)
+	if *t.tag != "" {
+		queries = append(queries, "tag:"+*t.tag)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: no containers selected, pass names or ids, or --tag", ErrUsage)
+	}
+	return sess.ResolveContainers(ctx, queries, *t.node)
+}
+
+// runContainerTasks starts a task on every container and waits for them
+// together, like runBatch does for VMs. A nil task means there was nothing
+// to do. It returns an error listing every container the task failed on.
+func runContainerTasks(ctx context.Context, cts []*proxmox.Container, verb string, timeout time.Duration, start func(context.Context, *proxmox.Container) (*proxmox.Task, error)) error {
+	errs := make([]error, len(cts))
+	tasks := make([]*proxmox.Task, len(cts))
+	for i, ct := range cts {
+		task, err := start(ctx, ct)
+		if err != nil {
+			errs[i] = fmt.Errorf("starting task gave err: %w", err)
+			continue
+		}
+		tasks[i] = task
+	}
+	for i, err := range waitOnTasks(ctx, tasks, time.Second, timeout) {
+		if err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+	}
+
+	var failed []error
+	for i, ct := range cts {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("ct %d (%s): %w", uint64(ct.VMID), ct.Name, errs[i]))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s failed for %d of %d containers:\n%w", verb, len(failed), len(cts), errors.Join(failed...))
+	}
+	return nil
+}
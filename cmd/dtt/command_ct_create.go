@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctCreateCommand = &cobra.Command{
+		Use:   "create",
+		Short: "create a container from a template",
+		Long: `Create an LXC container from a template and start it. The template is a
+volume ID, a template file name or the start of one, e.g. debian-12, which
+picks the newest matching one. Templates not on --template-storage yet are
+downloaded from the Proxmox appliance index first.
+
+  dtt ct create --template debian-12 --hostname build --ssh-public-key ~/.ssh/id_ed25519.pub`,
+		Args: cobra.NoArgs,
+		RunE: command_ct_create,
+	}
+
+	FlagCtCreateNode            *string
+	FlagCtCreateHostname        *string
+	FlagCtCreateTemplate        *string
+	FlagCtCreateTemplateStorage *string
+	FlagCtCreateStorage         *string
+	FlagCtCreateDiskSize        *int
+	FlagCtCreateMemory          *int
+	FlagCtCreateSwap            *int
+	FlagCtCreateCores           *int
+	FlagCtCreateBridge          *string
+	FlagCtCreateIP              *string
+	FlagCtCreateGateway         *string
+	FlagCtCreatePassword        *string
+	FlagCtCreateSSHPublicKey    *string
+	FlagCtCreatePrivileged      *bool
+	FlagCtCreateNesting         *bool
+	FlagCtCreateNoStart         *bool
+	FlagCtCreateDesc            *string
+	FlagCtCreateTTL             *time.Duration
+)
+
+func init() {
+	FlagCtCreateNode = ctCreateCommand.PersistentFlags().String("node", "pve", "which node to create the container on")
+	FlagCtCreateHostname = ctCreateCommand.PersistentFlags().String("hostname", "", "hostname of the container (default: dtt-ct-<id>)")
+	FlagCtCreateTemplate = ctCreateCommand.PersistentFlags().String("template", "", "container template, see dtt ct template list")
+	FlagCtCreateTemplateStorage = ctCreateCommand.PersistentFlags().String("template-storage", "local", "storage holding the templates, they are downloaded to it")
+	FlagCtCreateStorage = ctCreateCommand.PersistentFlags().String("storage", "local-lvm", "storage for the root filesystem")
+	FlagCtCreateDiskSize = ctCreateCommand.PersistentFlags().Int("disk-size", 8, "root filesystem size in GB")
+	FlagCtCreateMemory = ctCreateCommand.PersistentFlags().Int("memory", 512, "memory in MB")
+	FlagCtCreateSwap = ctCreateCommand.PersistentFlags().Int("swap", 512, "swap in MB")
+	FlagCtCreateCores = ctCreateCommand.PersistentFlags().Int("cores", 1, "number of CPU cores")
+	FlagCtCreateBridge = ctCreateCommand.PersistentFlags().String("bridge", "vmbr0", "bridge of the network interface")
+	FlagCtCreateIP = ctCreateCommand.PersistentFlags().String("ip", "dhcp", "IPv4 address in CIDR notation, or dhcp")
+	FlagCtCreateGateway = ctCreateCommand.PersistentFlags().String("gateway", "", "IPv4 gateway, for a static --ip")
+	FlagCtCreatePassword = ctCreateCommand.PersistentFlags().String("password", "", "root password of the container (or set DTT_CT_PASSWORD)")
+	FlagCtCreateSSHPublicKey = ctCreateCommand.PersistentFlags().String("ssh-public-key", "", "file with SSH public keys allowed to log in as root")
+	FlagCtCreatePrivileged = ctCreateCommand.PersistentFlags().Bool("privileged", false, "create a privileged container instead of an unprivileged one")
+	FlagCtCreateNesting = ctCreateCommand.PersistentFlags().Bool("nesting", false, "allow nested containers, e.g. to run docker inside")
+	FlagCtCreateNoStart = ctCreateCommand.PersistentFlags().Bool("no-start", false, "leave the container stopped after creating it")
+	FlagCtCreateDesc = ctCreateCommand.PersistentFlags().String("description", "", "description (notes) for the container, dtt adds its provenance below it")
+	FlagCtCreateTTL = ctCreateCommand.PersistentFlags().Duration("ttl", 0, "record in the provenance that the container expires after this long, e.g. 2h (default: never)")
+
+	ctCommand.AddCommand(ctCreateCommand)
+}
+
+// containerSpec describes a container to create.
+type containerSpec struct {
+	Node            string
+	Hostname        string // dtt-ct-<id> unless set
+	Template        string
+	TemplateStorage string
+	Storage         string
+	DiskSize        int // GB
+	Memory          int // MB
+	Swap            int // MB
+	Cores           int
+	Bridge          string
+	IP              string
+	Gateway         string
+	Password        string
+	SSHPublicKeys   string
+	Privileged      bool
+	Nesting         bool
+	NoStart         bool
+	Description     string
+	TTL             time.Duration
+}
+
+// options returns the API options creating the container from the template
+// at volid.
+func (spec containerSpec) options(vmid int, volid string) []px.ContainerOption {
+	hostname := spec.Hostname
+	if hostname == "" {
+		hostname = fmt.Sprintf("dtt-ct-%d", vmid)
+	}
+	net := fmt.Sprintf("name=eth0,bridge=%s,ip=%s", spec.Bridge, spec.IP)
+	if spec.Gateway != "" {
+		net += ",gw=" + spec.Gateway
+	}
+	unprivileged, start := 1, 1
+	if spec.Privileged {
+		unprivileged = 0
+	}
+	if spec.NoStart {
+		start = 0
+	}
+
+	opts := []px.ContainerOption{
+		{Name: "ostemplate", Value: volid},
+		{Name: "hostname", Value: hostname},
+		{Name: "memory", Value: spec.Memory},
+		{Name: "swap", Value: spec.Swap},
+		{Name: "cores", Value: spec.Cores},
+		{Name: "rootfs", Value: fmt.Sprintf("%s:%d", spec.Storage, spec.DiskSize)},
+		{Name: "net0", Value: net},
+		{Name: "unprivileged", Value: unprivileged},
+		{Name: "start", Value: start},
+	}
+	if spec.Nesting {
+		opts = append(opts, px.ContainerOption{Name: "features", Value: "nesting=1"})
+	}
+	if spec.Password != "" {
+		opts = append(opts, px.ContainerOption{Name: "password", Value: spec.Password})
+	}
+	if spec.SSHPublicKeys != "" {
+		opts = append(opts, px.ContainerOption{Name: "ssh-public-keys", Value: spec.SSHPublicKeys})
+	}
+	for _, o := range managedOptions(spec.Description, "", "ct "+path.Base(volid), "root", spec.TTL, "dtt-ct") {
+		opts = append(opts, px.ContainerOption(o))
+	}
+	return opts
+}
+
+// createContainer creates the container of spec and returns its VMID.
+func createContainer(ctx context.Context, sess *session, spec containerSpec) (int, error) {
+	if spec.Template == "" {
+		return 0, missingInput("--template is required, dtt ct template list shows them")
+	}
+	node, err := sess.Node(ctx, spec.Node)
+	if err != nil {
+		return 0, fmt.Errorf("getting node %s gave err: %w", spec.Node, err)
+	}
+	volid, err := resolveTemplate(ctx, sess, node, spec.TemplateStorage, spec.Template)
+	if err != nil {
+		return 0, err
+	}
+
+	cluster, err := sess.pac.Cluster(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	vmid, err := cluster.NextID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting next VM ID gave err: %w", err)
+	}
+
+	task, err := node.NewContainer(ctx, vmid, spec.options(vmid, volid)...)
+	if err != nil {
+		return 0, fmt.Errorf("creating container %d gave err: %w", vmid, err)
+	}
+	if err := waitTask(ctx, task, time.Second, 5*time.Minute); err != nil {
+		return 0, fmt.Errorf("waiting for container creation gave err: %w", err)
+	}
+	return vmid, nil
+}
+
+func command_ct_create(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	keys := ""
+	if *FlagCtCreateSSHPublicKey != "" {
+		data, err := os.ReadFile(*FlagCtCreateSSHPublicKey)
+		if err != nil {
+			return fmt.Errorf("reading --ssh-public-key gave err: %w", err)
+		}
+		keys = strings.TrimSpace(string(data))
+	}
+
+	spec := containerSpec{
+		Node:            *FlagCtCreateNode,
+		Hostname:        *FlagCtCreateHostname,
+		Template:        *FlagCtCreateTemplate,
+		TemplateStorage: *FlagCtCreateTemplateStorage,
+		Storage:         *FlagCtCreateStorage,
+		DiskSize:        *FlagCtCreateDiskSize,
+		Memory:          *FlagCtCreateMemory,
+		Swap:            *FlagCtCreateSwap,
+		Cores:           *FlagCtCreateCores,
+		Bridge:          *FlagCtCreateBridge,
+		IP:              *FlagCtCreateIP,
+		Gateway:         *FlagCtCreateGateway,
+		Password:        flagOrEnv(*FlagCtCreatePassword, "DTT_CT_PASSWORD"),
+		SSHPublicKeys:   keys,
+		Privileged:      *FlagCtCreatePrivileged,
+		Nesting:         *FlagCtCreateNesting,
+		NoStart:         *FlagCtCreateNoStart,
+		Description:     *FlagCtCreateDesc,
+		TTL:             *FlagCtCreateTTL,
+	}
+
+	vmid, err := createContainer(ctx, getSession(), spec)
+	if err != nil {
+		return err
+	}
+	state := "created and started"
+	if spec.NoStart {
+		state = "created"
+	}
+	fmt.Printf("%s container %d on node %s\n", state, vmid, spec.Node)
+	return nil
+}
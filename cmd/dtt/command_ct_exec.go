@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctExecCommand = &cobra.Command{
+		Use:   "exec [flags] <name-or-id> <command> [args...]",
+		Short: "run a command in a container with pct exec",
+		Long: `Run a command in a running container. Proxmox has no API for it, so dtt logs
+in to the node of the container with SSH and runs pct exec there, streaming
+the output back. The node is reached at the address cluster status reports
+for it, or at --proxmox-host for single nodes.
+
+  dtt ct exec build apt-get install -y make`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: command_ct_exec,
+	}
+
+	FlagCtExecNode        *string
+	FlagCtExecSSHHost     *string
+	FlagCtExecSSHUser     *string
+	FlagCtExecSSHPassword *string
+	FlagCtExecSSHKey      *string
+	FlagCtExecKnownHosts  *string
+)
+
+func init() {
+	FlagCtExecNode = ctExecCommand.PersistentFlags().String("node", "", "limit container lookup to a specific node")
+	FlagCtExecSSHHost = ctExecCommand.PersistentFlags().String("ssh-host", "", "address to reach the node with SSH (default: from cluster status, or --proxmox-host)")
+	FlagCtExecSSHUser = ctExecCommand.PersistentFlags().String("ssh-user", "root", "SSH user on the node, other users than root run pct with sudo")
+	FlagCtExecSSHPassword = ctExecCommand.PersistentFlags().String("ssh-password", "", "SSH password on the node (or set DTT_NODE_SSH_PASSWORD)")
+	FlagCtExecSSHKey = ctExecCommand.PersistentFlags().String("ssh-private-key", "", "SSH private key file for the node, instead of a password")
+	FlagCtExecKnownHosts = ctExecCommand.PersistentFlags().String("ssh-known-hosts", "~/.ssh/known_hosts", "known_hosts file to check the host key of the node against, trusting it on first use (\"\" to not check)")
+	// Everything after the container belongs to the command.
+	ctExecCommand.Flags().SetInterspersed(false)
+
+	ctCommand.AddCommand(ctExecCommand)
+}
+
+// pctExecCommand returns the shell command running args in container vmid on
+// its node, as user.
+func pctExecCommand(vmid uint64, user string, args []string) string {
+	cmd := []string{"pct", "exec", fmt.Sprint(vmid), "--"}
+	if user != "root" {
+		cmd = append([]string{"sudo"}, cmd...)
+	}
+	for _, arg := range args {
+		cmd = append(cmd, ssh.ShellQuote(arg))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// nodeAddress returns the address cluster status reports for node, or the
+// Proxmox host when it reports none, as for a node outside a cluster.
+func nodeAddress(ctx context.Context, sess *session, node string) (string, error) {
+	cluster, err := sess.pac.Cluster(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	for _, n := range cluster.Nodes {
+		if n.Name == node && n.IP != "" {
+			return n.IP, nil
+		}
+	}
+	if *FlagHost == "" {
+		return "", missingInput("no address for node %s, pass --ssh-host", node)
+	}
+	return *FlagHost, nil
+}
+
+func command_ct_exec(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sess := getSession()
+	ct, err := sess.ResolveContainer(ctx, args[0], *FlagCtExecNode)
+	if err != nil {
+		return err
+	}
+	if ct.Status != "running" {
+		return fmt.Errorf("container %q (ID %d) is %s, start it with dtt ct start", ct.Name, uint64(ct.VMID), ct.Status)
+	}
+
+	host := *FlagCtExecSSHHost
+	if host == "" {
+		if host, err = nodeAddress(ctx, sess, ct.Node); err != nil {
+			return err
+		}
+	}
+	config := ssh.Config{
+		Host:       host,
+		Username:   *FlagCtExecSSHUser,
+		Password:   flagOrEnv(*FlagCtExecSSHPassword, "DTT_NODE_SSH_PASSWORD"),
+		PrivateKey: *FlagCtExecSSHKey,
+	}
+	if *FlagCtExecKnownHosts != "" {
+		path, err := expandHome(*FlagCtExecKnownHosts)
+		if err != nil {
+			return err
+		}
+		config.HostKeyCallback = ssh.TrustOnFirstUse(path)
+	} else {
+		config.InsecureIgnoreHostKey = true
+	}
+	client := ssh.NewClient(config)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("connecting to node %s at %s gave err: %w", ct.Node, host, err)
+	}
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Stream(pctExecCommand(uint64(ct.VMID), *FlagCtExecSSHUser, args[1:]), cmd.OutOrStdout(), cmd.ErrOrStderr())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("running %q in container %d gave err: %w", strings.Join(args[1:], " "), uint64(ct.VMID), err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
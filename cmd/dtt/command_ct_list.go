@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list containers",
+		RunE:  command_ct_list,
+	}
+
+	FlagCtListOutput *string
+)
+
+func init() {
+	FlagCtListOutput = addOutputFlag(ctListCommand)
+	ctCommand.AddCommand(ctListCommand)
+}
+
+func command_ct_list(cmd *cobra.Command, args []string) error {
+	resources, err := getSession().Resources(context.Background())
+	if err != nil {
+		return err
+	}
+
+	rows := make([]statusVMRow, 0, len(resources))
+	for _, r := range resources {
+		if r.Type == "lxc" {
+			rows = append(rows, statusVMRow{
+				Node:    r.Node,
+				VMID:    r.VMID,
+				Name:    r.Name,
+				Status:  r.Status,
+				CPU:     r.CPU,
+				Mem:     r.Mem,
+				MaxMem:  r.MaxMem,
+				Disk:    r.Disk,
+				MaxDisk: r.MaxDisk,
+				Uptime:  r.Uptime,
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Node == rows[j].Node {
+			return rows[i].VMID < rows[j].VMID
+		}
+		return rows[i].Node < rows[j].Node
+	})
+
+	return writeOutput(cmd.OutOrStdout(), *FlagCtListOutput, rows, func(w io.Writer) error {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Containers")
+		return writeVMRows(w, rows)
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctRmCommand = &cobra.Command{
+		Use:     "rm <name-or-id>...",
+		Aliases: []string{"delete"},
+		Short:   "remove containers",
+		Args:    cobra.ArbitraryArgs,
+		RunE:    command_ct_rm,
+	}
+
+	FlagCtRmStop *bool
+	ctRmTargets  *batchTargets
+)
+
+func init() {
+	FlagCtRmStop = ctRmCommand.PersistentFlags().Bool("stop", false, "stop containers before removing them")
+	ctRmTargets = addCtTargetFlags(ctRmCommand)
+	ctCommand.AddCommand(ctRmCommand)
+}
+
+func command_ct_rm(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cts, err := ctRmTargets.resolveContainers(ctx, getSession(), args)
+	if err != nil {
+		return err
+	}
+
+	var running []*proxmox.Container
+	for _, ct := range cts {
+		if ct.Status == "stopped" {
+			continue
+		}
+		if !*FlagCtRmStop {
+			return fmt.Errorf("container %q (ID %d) is %s, stop it first or pass --stop", ct.Name, uint64(ct.VMID), ct.Status)
+		}
+		slog.Info("stopping container before removing it", "vmid", uint64(ct.VMID), "name", ct.Name)
+		running = append(running, ct)
+	}
+	if err := runContainerTasks(ctx, running, "stop", 2*time.Minute, func(ctx context.Context, ct *proxmox.Container) (*proxmox.Task, error) {
+		return ct.Stop(ctx)
+	}); err != nil {
+		return err
+	}
+
+	return runContainerTasks(ctx, cts, "rm", 2*time.Minute, func(ctx context.Context, ct *proxmox.Container) (*proxmox.Task, error) {
+		return ct.Delete(ctx)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctStartCommand = &cobra.Command{
+		Use:   "start <name-or-id>...",
+		Short: "start containers",
+		Args:  cobra.ArbitraryArgs,
+		RunE:  command_ct_start,
+	}
+
+	ctStartTargets *batchTargets
+)
+
+func init() {
+	ctStartTargets = addCtTargetFlags(ctStartCommand)
+	ctCommand.AddCommand(ctStartCommand)
+}
+
+func command_ct_start(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cts, err := ctStartTargets.resolveContainers(ctx, getSession(), args)
+	if err != nil {
+		return err
+	}
+
+	return runContainerTasks(ctx, cts, "start", 2*time.Minute, func(ctx context.Context, ct *proxmox.Container) (*proxmox.Task, error) {
+		if ct.Status == "running" {
+			return nil, nil
+		}
+		return ct.Start(ctx)
+	})
+}
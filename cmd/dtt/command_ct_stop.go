@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctStopCommand = &cobra.Command{
+		Use:   "stop <name-or-id>...",
+		Short: "stop containers",
+		Args:  cobra.ArbitraryArgs,
+		RunE:  command_ct_stop,
+	}
+
+	ctStopTargets *batchTargets
+)
+
+func init() {
+	ctStopTargets = addCtTargetFlags(ctStopCommand)
+	ctCommand.AddCommand(ctStopCommand)
+}
+
+func command_ct_stop(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cts, err := ctStopTargets.resolveContainers(ctx, getSession(), args)
+	if err != nil {
+		return err
+	}
+
+	return runContainerTasks(ctx, cts, "stop", 2*time.Minute, func(ctx context.Context, ct *proxmox.Container) (*proxmox.Task, error) {
+		if ct.Status == "stopped" {
+			return nil, nil
+		}
+		return ct.Stop(ctx)
+	})
+}
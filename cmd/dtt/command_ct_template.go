@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctTemplateCommand = &cobra.Command{
+		Use:   "template",
+		Short: "container template commands",
+	}
+
+	ctTemplateListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list the container templates available for download and those already downloaded",
+		Args:  cobra.NoArgs,
+		RunE:  command_ct_template_list,
+	}
+
+	ctTemplateDownloadCommand = &cobra.Command{
+		Use:   "download <template>...",
+		Short: "download container templates from the Proxmox appliance index",
+		Long: `Download container templates from the Proxmox appliance index onto a storage.
+A template is named by its file name or the start of it, e.g. debian-12, which
+picks the newest matching one. Templates already on the storage are skipped.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: command_ct_template_download,
+	}
+
+	FlagCtTemplateListNode        *string
+	FlagCtTemplateListStorage     *string
+	FlagCtTemplateListOutput      *string
+	FlagCtTemplateDownloadNode    *string
+	FlagCtTemplateDownloadStorage *string
+)
+
+func init() {
+	FlagCtTemplateListNode = ctTemplateListCommand.PersistentFlags().String("node", "pve", "node to list the templates of")
+	FlagCtTemplateListStorage = ctTemplateListCommand.PersistentFlags().String("storage", "local", "storage the templates are downloaded to")
+	FlagCtTemplateListOutput = addOutputFlag(ctTemplateListCommand)
+	FlagCtTemplateDownloadNode = ctTemplateDownloadCommand.PersistentFlags().String("node", "pve", "node to download the templates on")
+	FlagCtTemplateDownloadStorage = ctTemplateDownloadCommand.PersistentFlags().String("storage", "local", "storage to download the templates to, it needs vztmpl content")
+
+	ctTemplateCommand.AddCommand(ctTemplateListCommand)
+	ctTemplateCommand.AddCommand(ctTemplateDownloadCommand)
+	ctCommand.AddCommand(ctTemplateCommand)
+}
+
+// templateRow is a container template as ct template list shows it. VolID is
+// empty for templates that are not downloaded.
+type templateRow struct {
+	Template string `json:"template" yaml:"template"`
+	Section  string `json:"section,omitempty" yaml:"section,omitempty"`
+	VolID    string `json:"volid,omitempty" yaml:"volid,omitempty"`
+	Size     uint64 `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// downloadedTemplates returns the volume IDs of the container templates on
+// storage, by template file name.
+func downloadedTemplates(ctx context.Context, node *px.Node, storage string) (map[string]*px.VzTmpl, error) {
+	tmpls, err := node.VzTmpls(ctx, storage)
+	if err != nil {
+		return nil, fmt.Errorf("listing templates on %s gave err: %w", storage, err)
+	}
+	downloaded := map[string]*px.VzTmpl{}
+	for _, t := range tmpls {
+		if strings.Contains(t.VolID, ":vztmpl/") {
+			downloaded[path.Base(t.VolID)] = t
+		}
+	}
+	return downloaded, nil
+}
+
+// matchTemplate returns the newest of names that is name or starts with it.
+func matchTemplate(names []string, name string) (string, bool) {
+	var matched []string
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+		if strings.HasPrefix(n, name) {
+			matched = append(matched, n)
+		}
+	}
+	if len(matched) == 0 {
+		return "", false
+	}
+	// Versions follow the distribution name, the last sorts newest.
+	sort.Strings(matched)
+	return matched[len(matched)-1], true
+}
+
+// resolveTemplate returns the volume ID of the container template name on
+// storage, downloading it from the appliance index first when it is not
+// there yet. name is a volume ID, a template file name or the start of one.
+func resolveTemplate(ctx context.Context, sess *session, node *px.Node, storage, name string) (string, error) {
+	if strings.Contains(name, ":vztmpl/") {
+		return name, nil
+	}
+	downloaded, err := downloadedTemplates(ctx, node, storage)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(downloaded))
+	for n := range downloaded {
+		names = append(names, n)
+	}
+	if n, ok := matchTemplate(names, name); ok {
+		return downloaded[n].VolID, nil
+	}
+	return downloadTemplate(ctx, sess, node, storage, name)
+}
+
+// downloadTemplate downloads the template of the appliance index matching
+// name to storage and returns its volume ID.
+func downloadTemplate(ctx context.Context, sess *session, node *px.Node, storage, name string) (string, error) {
+	appliances, err := node.Appliances(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting the appliance index gave err: %w", err)
+	}
+	names := make([]string, 0, len(appliances))
+	for _, a := range appliances {
+		names = append(names, a.Template)
+	}
+	template, ok := matchTemplate(names, name)
+	if !ok {
+		return "", fmt.Errorf("%w: no container template %q, dtt ct template list shows them", ErrUsage, name)
+	}
+
+	slog.Info("downloading template", "template", template, "storage", storage, "node", node.Name)
+	upid, err := node.DownloadAppliance(ctx, template, storage)
+	if err != nil {
+		return "", fmt.Errorf("downloading template %s gave err: %w", template, err)
+	}
+	task, err := dttproxmox.NewTask(sess.pac, upid)
+	if err != nil {
+		return "", err
+	}
+	if err := waitTask(ctx, task, 2*time.Second, 15*time.Minute); err != nil {
+		return "", fmt.Errorf("waiting for the download of %s gave err: %w", template, err)
+	}
+	return fmt.Sprintf("%s:vztmpl/%s", storage, template), nil
+}
+
+func command_ct_template_list(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	node, err := getSession().Node(ctx, *FlagCtTemplateListNode)
+	if err != nil {
+		return fmt.Errorf("getting node %s gave err: %w", *FlagCtTemplateListNode, err)
+	}
+	downloaded, err := downloadedTemplates(ctx, node, *FlagCtTemplateListStorage)
+	if err != nil {
+		return err
+	}
+	appliances, err := node.Appliances(ctx)
+	if err != nil {
+		return fmt.Errorf("getting the appliance index gave err: %w", err)
+	}
+
+	rows := []templateRow{}
+	for _, a := range appliances {
+		if a.Type != "" && a.Type != "lxc" {
+			continue
+		}
+		row := templateRow{Template: a.Template, Section: a.Section}
+		if t, ok := downloaded[a.Template]; ok {
+			row.VolID, row.Size = t.VolID, uint64(t.Size)
+			delete(downloaded, a.Template)
+		}
+		rows = append(rows, row)
+	}
+	// Templates uploaded by hand are not in the index.
+	for name, t := range downloaded {
+		rows = append(rows, templateRow{Template: name, VolID: t.VolID, Size: uint64(t.Size)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Template < rows[j].Template })
+
+	return writeOutput(cmd.OutOrStdout(), *FlagCtTemplateListOutput, rows, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TEMPLATE\tSECTION\tDOWNLOADED")
+		for _, row := range rows {
+			state := "-"
+			if row.VolID != "" {
+				state = fmt.Sprintf("%s (%s)", row.VolID, formatBytes(row.Size))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", row.Template, orDash(row.Section), state)
+		}
+		return tw.Flush()
+	})
+}
+
+func command_ct_template_download(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	sess := getSession()
+	node, err := sess.Node(ctx, *FlagCtTemplateDownloadNode)
+	if err != nil {
+		return fmt.Errorf("getting node %s gave err: %w", *FlagCtTemplateDownloadNode, err)
+	}
+	for _, name := range args {
+		volid, err := resolveTemplate(ctx, sess, node, *FlagCtTemplateDownloadStorage, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("template %s is %s\n", name, volid)
+	}
+	return nil
+}
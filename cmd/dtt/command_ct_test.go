@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+	px "github.com/luthermonson/go-proxmox"
+)
+
+func TestCreateContainer(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	node := server.AddNode("pve")
+	node.Appliances = []string{"debian-11-standard_11.7-1_amd64.tar.zst", "debian-12-standard_12.2-1_amd64.tar.zst", "debian-12-standard_12.7-1_amd64.tar.zst"}
+	sess := newSession(server.Client(), newAPICache(0, true))
+
+	spec := containerSpec{Node: "pve", Template: "debian-12", TemplateStorage: "local", Storage: "local-lvm", DiskSize: 8, Memory: 512, Cores: 1, Bridge: "vmbr0", IP: "dhcp", Nesting: true}
+	vmid, err := createContainer(context.Background(), sess, spec)
+	if err != nil {
+		t.Fatalf("createContainer() gave err: %v", err)
+	}
+
+	ct := server.VM(uint64(vmid))
+	if ct == nil || !ct.LXC || ct.Status != "running" || ct.Name != fmt.Sprintf("dtt-ct-%d", vmid) {
+		t.Fatalf("Expected a running container named dtt-ct-%d, got %+v", vmid, ct)
+	}
+	for key, want := range map[string]string{
+		"ostemplate": "local:vztmpl/debian-12-standard_12.7-1_amd64.tar.zst",
+		"rootfs":     "local-lvm:8",
+		"net0":       "name=eth0,bridge=vmbr0,ip=dhcp",
+		"features":   "nesting=1",
+		"tags":       "dtt;dtt-ct",
+	} {
+		if got := fmt.Sprint(ct.Config[key]); got != want {
+			t.Errorf("Expected %s %q, got %q", key, want, got)
+		}
+	}
+
+	// The template is downloaded once, the second container reuses it.
+	if _, err := createContainer(context.Background(), sess, spec); err != nil {
+		t.Fatalf("createContainer() gave err: %v", err)
+	}
+	downloads := 0
+	for _, task := range server.Tasks() {
+		if task.Type == "download" {
+			downloads++
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("Expected the template to be downloaded once, got %d downloads", downloads)
+	}
+
+	spec.Template = "alpine"
+	if _, err := createContainer(context.Background(), sess, spec); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for an unknown template, got %v", err)
+	}
+}
+
+func TestContainerTargets(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, LXC: true, Name: "web", Status: "running", Tags: "dtt"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, LXC: true, Name: "cache", Tags: "dtt"})
+	sess := newSession(server.Client(), newAPICache(0, true))
+
+	tag := "dtt"
+	targets := &batchTargets{node: new(string), tag: &tag, all: new(bool)}
+	cts, err := targets.resolveContainers(context.Background(), sess, []string{"web"})
+	if err != nil || len(cts) != 2 || uint64(cts[0].VMID) != 101 || uint64(cts[1].VMID) != 102 {
+		t.Fatalf("Expected containers 101 and 102, got %v, %v", cts, err)
+	}
+
+	err = runContainerTasks(context.Background(), cts, "stop", time.Minute, func(ctx context.Context, ct *px.Container) (*px.Task, error) {
+		return ct.Stop(ctx)
+	})
+	if err != nil {
+		t.Fatalf("runContainerTasks() gave err: %v", err)
+	}
+	if server.VM(101).Status != "stopped" || server.VM(100) == nil {
+		t.Errorf("Expected container 101 to be stopped and VM 100 untouched")
+	}
+}
+
+func TestPctExecCommand(t *testing.T) {
+	if got, want := pctExecCommand(101, "root", []string{"sh", "-c", "echo it's"}), `pct exec 101 -- 'sh' '-c' 'echo it'\''s'`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if got := pctExecCommand(101, "admin", []string{"true"}); got != "sudo pct exec 101 -- 'true'" {
+		t.Errorf("Expected pct to run with sudo for other users than root, got %s", got)
+	}
+}
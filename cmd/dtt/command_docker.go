@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dockerCommand = &cobra.Command{
+		Use:   "docker",
+		Short: "docker commands",
+	}
+
+	dockerRunCommand = &cobra.Command{
+		Use:   "run [flags] <image> [command [args...]]",
+		Short: "run a docker image on a new Proxmox VM",
+		Long: `Run a docker image on a new Proxmox VM. The VM is created from a cloud image
+with cloud-init, docker is installed on it from the distribution packages,
+the image is pulled and the container run as "dtt", with its output streamed
+back until it exits. dtt fails when the container does.
+
+Flags go before the image, what follows it is the command of the container.
+The host side of --volume is a path on the VM. An --env without a value takes
+it from the local environment.
+
+  dtt docker run -p 8080:80 -e GREETING=hi --detach nginx:1.27
+  dtt docker run --delete alpine:3 sh -c 'uname -a'`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: command_docker_run,
+	}
+
+	FlagDockerRunPublish      *[]string
+	FlagDockerRunEnv          *[]string
+	FlagDockerRunVolume       *[]string
+	FlagDockerRunDetach       *bool
+	FlagDockerRunDelete       *bool
+	FlagDockerRunNode         *string
+	FlagDockerRunImageStorage *string
+	FlagDockerRunDiskStorage  *string
+	FlagDockerRunHostname     *string
+	FlagDockerRunVMImage      *string
+	FlagDockerRunMemory       *int
+	FlagDockerRunCores        *int
+	FlagDockerRunDiskSize     *int
+	FlagDockerRunUsername     *string
+	FlagDockerRunSSHPassword  *string
+	FlagDockerRunTTL          *time.Duration
+)
+
+func init() {
+	FlagDockerRunPublish = dockerRunCommand.PersistentFlags().StringArrayP("publish", "p", nil, "publish a container port on the VM, as docker run -p takes it, e.g. 8080:80 (can be repeated)")
+	FlagDockerRunEnv = dockerRunCommand.PersistentFlags().StringArrayP("env", "e", nil, "set an environment variable, KEY=VALUE, or KEY to pass on the local value (can be repeated)")
+	FlagDockerRunVolume = dockerRunCommand.PersistentFlags().StringArrayP("volume", "v", nil, "bind mount a path on the VM, as docker run -v takes it (can be repeated)")
+	FlagDockerRunDetach = dockerRunCommand.PersistentFlags().BoolP("detach", "d", false, "leave the container running in the background instead of streaming its output")
+	FlagDockerRunDelete = dockerRunCommand.PersistentFlags().Bool("delete", false, "delete the VM once the container exited, also when it failed")
+	FlagDockerRunNode = dockerRunCommand.PersistentFlags().String("node", "pve", "which node to create the vm on")
+	FlagDockerRunImageStorage = dockerRunCommand.PersistentFlags().String("image-storage", "local", "storage for cloud images (needs import content) and the cloud-init drive")
+	FlagDockerRunDiskStorage = dockerRunCommand.PersistentFlags().String("disk-storage", "local-lvm", "storage for VM disks")
+	FlagDockerRunHostname = dockerRunCommand.PersistentFlags().String("hostname", "", "VM hostname (default: dtt-<vmid>)")
+	FlagDockerRunVMImage = dockerRunCommand.PersistentFlags().String("vm-image", dtt.DefaultImage, "cloud image of the VM (debian-11, debian-13, ubuntu-24.04)")
+	FlagDockerRunMemory = dockerRunCommand.PersistentFlags().Int("memory", dtt.DefaultDockerMemory, "memory in MB")
+	FlagDockerRunCores = dockerRunCommand.PersistentFlags().Int("cores", 1, "number of cores")
+	FlagDockerRunDiskSize = dockerRunCommand.PersistentFlags().Int("disk-size", 0, "disk size in GB (default: the size of the cloud image plus 10 GB)")
+	FlagDockerRunUsername = dockerRunCommand.PersistentFlags().String("username", "dtt", "cloud-init username")
+	FlagDockerRunSSHPassword = dockerRunCommand.PersistentFlags().String("ssh-password", "", "cloud-init and SSH password (or set DTT_SSH_PASSWORD, default: dtt)")
+	FlagDockerRunTTL = dockerRunCommand.PersistentFlags().Duration("ttl", 0, "delete the VM with dtt gc once it is this old, e.g. 2h (default: keep)")
+	// Everything after the image belongs to the container.
+	dockerRunCommand.Flags().SetInterspersed(false)
+
+	dockerCommand.AddCommand(dockerRunCommand)
+	rootCmd.AddCommand(dockerCommand)
+}
+
+// dockerEnv returns env with the local value filled in for variables given
+// without one, like docker run -e does on the docker host.
+func dockerEnv(env []string, lookup func(string) (string, bool)) ([]string, error) {
+	resolved := make([]string, 0, len(env))
+	for _, e := range env {
+		if !strings.Contains(e, "=") {
+			value, ok := lookup(e)
+			if !ok {
+				return nil, fmt.Errorf("%w: --env %s has no value and is not set locally", ErrUsage, e)
+			}
+			e += "=" + value
+		}
+		resolved = append(resolved, e)
+	}
+	return resolved, nil
+}
+
+func command_docker_run(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	env, err := dockerEnv(*FlagDockerRunEnv, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	image := args[0]
+
+	opts := dtt.DockerOptions{
+		RunOptions: dtt.RunOptions{
+			VMOptions: dtt.VMOptions{
+				Name:     *FlagDockerRunHostname,
+				Image:    *FlagDockerRunVMImage,
+				Memory:   *FlagDockerRunMemory,
+				Cores:    *FlagDockerRunCores,
+				DiskSize: *FlagDockerRunDiskSize,
+				Username: *FlagDockerRunUsername,
+				Password: flagOrEnv(*FlagDockerRunSSHPassword, "DTT_SSH_PASSWORD"),
+				TTL:      *FlagDockerRunTTL,
+			},
+			Keep: !*FlagDockerRunDelete,
+		},
+		Ports:   *FlagDockerRunPublish,
+		Env:     env,
+		Volumes: *FlagDockerRunVolume,
+		Args:    args[1:],
+		Detach:  *FlagDockerRunDetach,
+		Logs:    cmd.OutOrStdout(),
+	}
+
+	sess := getSession()
+	client := dtt.NewWithAPI(dttproxmox.ClientConfig{
+		Node:         *FlagDockerRunNode,
+		ImageStorage: *FlagDockerRunImageStorage,
+		DiskStorage:  *FlagDockerRunDiskStorage,
+		Progress:     progressOutput(),
+		TaskLog:      sess.taskLog,
+	}, sess.pac)
+
+	result, err := client.RunContainer(ctx, image, opts)
+	if err != nil {
+		if result != nil && opts.Keep {
+			fmt.Printf("VM %d (%s) is kept for inspection\n", result.VM.ID, result.VM.IP)
+		}
+		return err
+	}
+
+	vm := result.VM
+	if !opts.Detach {
+		fmt.Printf("Container %s exited successfully on VM %d (%s)\n", image, vm.ID, vm.IP)
+		return nil
+	}
+	fmt.Printf("Container %s runs on VM %d (%s) as %s, id %s\n", image, vm.ID, vm.IP, dtt.ContainerName, strings.TrimSpace(result.Output))
+	for _, port := range opts.Ports {
+		fmt.Printf("  published %s\n", publishedAddress(vm.IP, port))
+	}
+	fmt.Printf("follow its logs with: %s sudo docker logs -f %s\n", sshCommandLine(*FlagDockerRunUsername, vm.IP, ""), dtt.ContainerName)
+	return nil
+}
+
+// publishedAddress returns where a port published with --publish port is
+// reachable on the VM at ip.
+func publishedAddress(ip, port string) string {
+	parts := strings.Split(port, ":")
+	if len(parts) == 1 {
+		// Only the container port, docker picks the host port.
+		return fmt.Sprintf("container port %s on a port docker picked", port)
+	}
+	hostPort := parts[len(parts)-2]
+	if _, err := strconv.Atoi(strings.Split(hostPort, "-")[0]); err != nil {
+		return port
+	}
+	if len(parts) == 3 && parts[0] != "" && parts[0] != "0.0.0.0" {
+		// Bound to one address of the VM only.
+		ip = parts[0]
+	}
+	return fmt.Sprintf("%s:%s -> %s", ip, hostPort, parts[len(parts)-1])
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDockerEnv(t *testing.T) {
+	lookup := func(key string) (string, bool) {
+		if key == "TOKEN" {
+			return "s3cret", true
+		}
+		return "", false
+	}
+
+	got, err := dockerEnv([]string{"A=1", "TOKEN", "EMPTY="}, lookup)
+	if err != nil {
+		t.Fatalf("dockerEnv() gave err: %v", err)
+	}
+	if len(got) != 3 || got[0] != "A=1" || got[1] != "TOKEN=s3cret" || got[2] != "EMPTY=" {
+		t.Errorf("Expected the local value of TOKEN to be filled in, got %q", got)
+	}
+
+	if _, err := dockerEnv([]string{"MISSING"}, lookup); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for a variable that is not set locally, got %v", err)
+	}
+}
+
+func TestPublishedAddress(t *testing.T) {
+	for port, want := range map[string]string{
+		"8080:80":               "10.0.0.5:8080 -> 80",
+		"127.0.0.1:8080:80/udp": "127.0.0.1:8080 -> 80/udp",
+		"0.0.0.0:8443:443":      "10.0.0.5:8443 -> 443",
+		"80":                    "container port 80 on a port docker picked",
+	} {
+		if got := publishedAddress("10.0.0.5", port); got != want {
+			t.Errorf("publishedAddress(%q) = %q, want %q", port, got, want)
+		}
+	}
+}
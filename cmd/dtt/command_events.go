@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventsCommand = &cobra.Command{
+		Use:   "events",
+		Short: "show recent cluster tasks and log entries, optionally following new ones",
+		Args:  cobra.NoArgs,
+		RunE:  command_events,
+	}
+
+	FlagEventsFollow   *bool
+	FlagEventsInterval *time.Duration
+	FlagEventsSince    *time.Duration
+	FlagEventsMax      *int
+)
+
+func init() {
+	rootCmd.AddCommand(eventsCommand)
+
+	FlagEventsFollow = eventsCommand.PersistentFlags().BoolP("follow", "f", false, "keep polling and print new events as they happen")
+	FlagEventsInterval = eventsCommand.PersistentFlags().Duration("interval", 2*time.Second, "how often to poll the cluster when following")
+	FlagEventsSince = eventsCommand.PersistentFlags().Duration("since", 10*time.Minute, "only show events newer than this on the first poll")
+	FlagEventsMax = eventsCommand.PersistentFlags().Int("max", 100, "maximum number of cluster log entries to fetch per poll")
+}
+
+// clusterLogEntry is a single entry of /cluster/log, which go-proxmox does not
+// model.
+type clusterLogEntry struct {
+	UID  int    `json:"uid"`
+	Time int64  `json:"time"`
+	Node string `json:"node"`
+	Tag  string `json:"tag"`
+	User string `json:"user"`
+	Msg  string `json:"msg"`
+}
+
+// clusterEvent is a task state change or a cluster log entry, ready to print.
+type clusterEvent struct {
+	Time   time.Time
+	Node   string
+	Kind   string
+	Source string
+	User   string
+	Text   string
+}
+
+// eventWatcher remembers what was already printed so repeated polls of the
+// cluster task list and log only produce new events.
+type eventWatcher struct {
+	pac       dttproxmox.ProxmoxAPI
+	max       int
+	cutoff    time.Time
+	seenTasks map[px.UPID]bool // value is whether the task was seen finished
+	seenLog   map[string]bool
+}
+
+func newEventWatcher(pac dttproxmox.ProxmoxAPI, since time.Duration, max int) *eventWatcher {
+	return &eventWatcher{
+		pac:       pac,
+		max:       max,
+		cutoff:    time.Now().Add(-since),
+		seenTasks: map[px.UPID]bool{},
+		seenLog:   map[string]bool{},
+	}
+}
+
+func command_events(cmd *cobra.Command, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if *FlagEventsInterval <= 0 {
+		return fmt.Errorf("--interval must be positive, got %s", *FlagEventsInterval)
+	}
+
+	watcher := newEventWatcher(getSession().pac, *FlagEventsSince, *FlagEventsMax)
+
+	events, err := watcher.poll(ctx)
+	if err != nil {
+		return err
+	}
+	writeClusterEvents(os.Stdout, events)
+
+	if !*FlagEventsFollow {
+		return nil
+	}
+
+	ticker := time.NewTicker(*FlagEventsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		events, err := watcher.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// A single failed poll shouldn't end a long-running watch.
+			fmt.Fprintf(os.Stderr, "polling cluster events gave err: %v\n", err)
+			continue
+		}
+		writeClusterEvents(os.Stdout, events)
+	}
+}
+
+// poll fetches the cluster tasks and log and returns the events not returned
+// by earlier polls, oldest first.
+func (w *eventWatcher) poll(ctx context.Context) ([]clusterEvent, error) {
+	cluster, err := w.pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+
+	tasks, err := cluster.Tasks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster tasks gave err: %w", err)
+	}
+
+	entries, err := getClusterLog(ctx, w.pac, w.max)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster log gave err: %w", err)
+	}
+
+	var events []clusterEvent
+	for _, t := range tasks {
+		if t == nil {
+			continue
+		}
+		events = append(events, w.taskEvents(t)...)
+	}
+	for _, e := range entries {
+		key := e.Node + "/" + strconv.Itoa(e.UID)
+		if w.seenLog[key] {
+			continue
+		}
+		w.seenLog[key] = true
+
+		at := time.Unix(e.Time, 0)
+		if at.Before(w.cutoff) {
+			continue
+		}
+		events = append(events, clusterEvent{Time: at, Node: e.Node, Kind: "log", Source: e.Tag, User: e.User, Text: e.Msg})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// taskEvents returns a "started" and/or "finished" event for tasks that have
+// not been reported in that state yet.
+func (w *eventWatcher) taskEvents(t *px.Task) []clusterEvent {
+	finished := !t.EndTime.IsZero()
+	seenFinished, seen := w.seenTasks[t.UPID]
+	if seen && (seenFinished || !finished) {
+		return nil
+	}
+	w.seenTasks[t.UPID] = finished
+
+	source := t.Type
+	if t.ID != "" {
+		source = fmt.Sprintf("%s %s", t.Type, t.ID)
+	}
+
+	var events []clusterEvent
+	if !seen && !t.StartTime.Before(w.cutoff) {
+		events = append(events, clusterEvent{Time: t.StartTime, Node: t.Node, Kind: "task", Source: source, User: t.User, Text: "started"})
+	}
+	if finished && !t.EndTime.Before(w.cutoff) {
+		status := t.Status
+		if status == "" {
+			status = "unknown"
+		}
+		events = append(events, clusterEvent{
+			Time:   t.EndTime,
+			Node:   t.Node,
+			Kind:   "task",
+			Source: source,
+			User:   t.User,
+			Text:   fmt.Sprintf("finished %s after %s", status, t.EndTime.Sub(t.StartTime)),
+		})
+	}
+	return events
+}
+
+func getClusterLog(ctx context.Context, pac dttproxmox.ProxmoxAPI, max int) ([]*clusterLogEntry, error) {
+	params := url.Values{}
+	if max > 0 {
+		params.Add("max", strconv.Itoa(max))
+	}
+	u := url.URL{Path: "/cluster/log", RawQuery: params.Encode()}
+
+	var entries []*clusterLogEntry
+	if err := pac.Get(ctx, u.String(), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func writeClusterEvents(w io.Writer, events []clusterEvent) {
+	for _, e := range events {
+		fmt.Fprintf(w, "%s  %-10s %-4s  %-24s %-16s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Node, e.Kind, e.Source, e.User, e.Text)
+	}
+}
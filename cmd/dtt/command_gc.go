@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcCommand = &cobra.Command{
+		Use:   "gc",
+		Short: "stop and delete the dtt vms whose --ttl ran out",
+		Long: `Stop and delete the VMs dtt created with a --ttl (or a manifest ttl) that
+is over. Only VMs tagged "dtt" with an expiry in their description are
+touched. Run it from cron, or let "dtt api --gc-interval" do it.`,
+		Args: cobra.NoArgs,
+		RunE: command_gc,
+	}
+
+	FlagGcDryRun *bool
+)
+
+func init() {
+	FlagGcDryRun = gcCommand.PersistentFlags().Bool("dry-run", false, "only list the expired VMs")
+
+	rootCmd.AddCommand(gcCommand)
+}
+
+func command_gc(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	sess := getSession()
+	expired, err := sess.Provisioner("", "", "").CollectGarbage(ctx, time.Now(), *FlagGcDryRun)
+	for _, vm := range expired {
+		if *FlagGcDryRun {
+			fmt.Printf("would delete %s, expired %s\n", vm, vm.Provenance.Expires.Local().Format(time.RFC3339))
+			continue
+		}
+		sess.cache.forgetVM(vm.Node, vm.VMID)
+		fmt.Printf("deleted %s, expired %s\n", vm, vm.Provenance.Expires.Local().Format(time.RFC3339))
+	}
+	if err != nil {
+		return fmt.Errorf("collecting expired VMs gave err: %w", err)
+	}
+	if len(expired) == 0 {
+		fmt.Println("no expired VMs")
+	}
+	return nil
+}
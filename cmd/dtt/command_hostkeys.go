@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// defaultKnownHosts is what --write-known-hosts writes to without a path.
+const defaultKnownHosts = "~/.ssh/known_hosts"
+
+var (
+	hostkeysCommand = &cobra.Command{
+		Use:         "hostkeys",
+		Short:       "work with the SSH host keys VMs print at boot",
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	hostkeysExportCommand = &cobra.Command{
+		Use:   "export [file]",
+		Short: "print known_hosts entries for the host keys in a serial console log",
+		Long: `Print known_hosts entries for the SSH host keys cloud-init printed on the
+serial console, for the hostname and addresses of the VM, so the first SSH to
+it is verified. Reads stdin when no file or "-" is given.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: command_hostkeys_export,
+	}
+
+	FlagHostkeysExportWrite *string
+)
+
+func init() {
+	FlagHostkeysExportWrite = hostkeysExportCommand.PersistentFlags().String("write-known-hosts", "", "append the entries to this known_hosts file instead of printing them, "+defaultKnownHosts+" when given without =path")
+	hostkeysExportCommand.PersistentFlags().Lookup("write-known-hosts").NoOptDefVal = defaultKnownHosts
+
+	hostkeysCommand.AddCommand(hostkeysExportCommand)
+	rootCmd.AddCommand(hostkeysCommand)
+}
+
+func command_hostkeys_export(cmd *cobra.Command, args []string) error {
+	parsed, err := parseLogArg(args)
+	if err != nil {
+		return err
+	}
+
+	if *FlagHostkeysExportWrite != "" {
+		path, err := writeKnownHosts(*FlagHostkeysExportWrite, parsed)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "added the host keys of %s to %s\n", parsed.Hostname, path)
+		return nil
+	}
+
+	lines, err := knownHostsEntries(parsed)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Fprintln(cmd.OutOrStdout(), line)
+	}
+	return nil
+}
+
+// vmAddresses returns the addresses in parsed without their prefix length.
+// Link-local addresses are left out, they can't be connected to without a
+// zone.
+func vmAddresses(parsed parseCloudInitLog.CloudInitData) []string {
+	var addrs []string
+	for _, ip := range parsed.IPs {
+		addr, _, _ := strings.Cut(strings.TrimSpace(ip), "/")
+		if parsedIP := net.ParseIP(addr); parsedIP == nil || parsedIP.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// knownHostsEntries returns known_hosts lines for the host keys in parsed,
+// for its hostname and addresses and the other names and addresses in extra.
+func knownHostsEntries(parsed parseCloudInitLog.CloudInitData, extra ...string) ([]string, error) {
+	if len(parsed.HostKeys) == 0 {
+		return nil, fmt.Errorf("no host keys found in the console output")
+	}
+	var hosts []string
+	if parsed.Hostname != "" {
+		hosts = append(hosts, parsed.Hostname)
+	}
+	for _, host := range append(vmAddresses(parsed), extra...) {
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return ssh.KnownHostsLines(hosts, parsed.HostKeys)
+}
+
+// expandHome replaces a leading ~/ in path with the home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the home directory gave err: %w", err)
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// writeKnownHosts appends the known_hosts entries for parsed and extra to
+// the file at path, which may start with ~/. It returns the expanded path.
+func writeKnownHosts(path string, parsed parseCloudInitLog.CloudInitData, extra ...string) (string, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return "", err
+	}
+	lines, err := knownHostsEntries(parsed, extra...)
+	if err != nil {
+		return "", err
+	}
+	if err := ssh.AppendKnownHosts(path, lines); err != nil {
+		return "", fmt.Errorf("writing %s gave err: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/spf13/cobra"
+)
+
+func TestHostkeysExport(t *testing.T) {
+	const log = "../../parseCloudInitLog/testdata/dtt-debian-11-104-cloudinit.serial.txt"
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	if err := command_hostkeys_export(cmd, []string{log}); err != nil {
+		t.Fatalf("hostkeys export gave err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a line per host key, got %q", out.String())
+	}
+	for _, line := range lines {
+		hosts := strings.Fields(line)[0]
+		if hosts != "dtt-debian-11-104,192.168.1.191,2a02:aa14:4582:1100:be24:11ff:feb7:e9c1" {
+			t.Errorf("Expected the hostname and global addresses, got %q", hosts)
+		}
+	}
+
+	saved := *FlagHostkeysExportWrite
+	t.Cleanup(func() { *FlagHostkeysExportWrite = saved })
+	*FlagHostkeysExportWrite = filepath.Join(t.TempDir(), "known_hosts")
+	cmd.SetErr(&bytes.Buffer{})
+	if err := command_hostkeys_export(cmd, []string{log}); err != nil {
+		t.Fatalf("hostkeys export --write-known-hosts gave err: %v", err)
+	}
+	written, err := os.ReadFile(*FlagHostkeysExportWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != out.String() {
+		t.Errorf("Expected the printed entries in the file, got %q", written)
+	}
+
+	*FlagHostkeysExportWrite = ""
+	if err := command_hostkeys_export(cmd, []string{"../../parseCloudInitLog/testdata/dtt-alpine-3.20-112-cloudinit.serial.txt"}); err == nil {
+		t.Error("Expected an error for a log without host keys")
+	}
+}
+
+func TestKnownHostsEntriesExtraHosts(t *testing.T) {
+	data, err := os.ReadFile("../../parseCloudInitLog/testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := parseCloudInitLog.ParseCloudInit(data)
+
+	lines, err := knownHostsEntries(parsed, "web", "192.168.1.191", "", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("knownHostsEntries gave err: %v", err)
+	}
+	for _, line := range lines {
+		hosts := strings.Fields(line)[0]
+		if hosts != "dtt-debian-11-104,192.168.1.191,2a02:aa14:4582:1100:be24:11ff:feb7:e9c1,web,10.0.0.5" {
+			t.Errorf("Expected the extra hosts once after those on the console, got %q", hosts)
+		}
+	}
+}
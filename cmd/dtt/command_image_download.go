@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageDownloadCommand = &cobra.Command{
+		Use:   "download <image-name>",
+		Short: "download one of the known images to a Proxmox storage",
+		Long:  "Download one of the images listed by image list --catalog into the import content of a Proxmox storage, for faster provisioning.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_image_download,
+	}
+
+	FlagImageDownloadNode    *string
+	FlagImageDownloadStorage *string
+)
+
+func init() {
+	FlagImageDownloadNode = imageDownloadCommand.PersistentFlags().String("node", "pve", "which node to download the image to")
+	FlagImageDownloadStorage = imageDownloadCommand.PersistentFlags().String("storage", "local", "storage to download the image to (needs import content)")
+	imageCommand.AddCommand(imageDownloadCommand)
+}
+
+func command_image_download(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	var names []string
+	for _, image := range dttproxmox.DefaultImages() {
+		if image.Name != args[0] {
+			names = append(names, image.Name)
+			continue
+		}
+
+		slog.Info("downloading image", "image", image.Name, "url", image.URL)
+		client := getSession().Provisioner(*FlagImageDownloadNode, *FlagImageDownloadStorage, "")
+		if err := client.DownloadImage(ctx, image, *FlagImageDownloadStorage); err != nil {
+			return fmt.Errorf("downloading image %s gave err: %w", image.Name, err)
+		}
+		fmt.Printf("image %s downloaded\n", image.Name)
+		return nil
+	}
+	return fmt.Errorf("unknown image %q, known images are %s", args[0], strings.Join(names, ", "))
+}
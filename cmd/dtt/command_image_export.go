@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageExportCommand = &cobra.Command{
+		Use:   "export [flags] <volid> [local-file] | --vm <name-or-id> [local-file]",
+		Short: "download an image or VM disk from Proxmox storage to a local file",
+		Long: `Download a volume from Proxmox storage, such as an image in import content or
+the disk of a VM, to a local qcow2 file, or raw or vmdk with --format. That
+backs up golden images, and moves them to other clusters with dtt image
+import.
+
+Proxmox has no API to download volumes, so dtt logs in to the node with SSH,
+converts the volume with qemu-img into --tmp-dir there and downloads the
+result, which is removed afterwards. The node is reached as for dtt ct exec.
+
+--vm exports the disk of a VM, the only one or the one --disk names. Stop the
+VM first, the disk of a running VM may be caught halfway through a write. The
+local file defaults to the name of the volume.
+
+  dtt image export local:import/golden.qcow2
+  dtt image export --node pve2 local-lvm:vm-9000-disk-0 ./golden.qcow2
+  dtt image export --vm build-base --disk scsi0 --format raw`,
+		Args: cobra.RangeArgs(0, 2),
+		RunE: command_image_export,
+	}
+
+	FlagImageExportVM          *string
+	FlagImageExportDisk        *string
+	FlagImageExportNode        *string
+	FlagImageExportFormat      *string
+	FlagImageExportTmpDir      *string
+	FlagImageExportSSHHost     *string
+	FlagImageExportSSHUser     *string
+	FlagImageExportSSHPassword *string
+	FlagImageExportSSHKey      *string
+	FlagImageExportKnownHosts  *string
+)
+
+func init() {
+	FlagImageExportVM = imageExportCommand.PersistentFlags().String("vm", "", "VM to export a disk of, instead of a volume")
+	FlagImageExportDisk = imageExportCommand.PersistentFlags().String("disk", "", "disk of --vm to export, such as scsi0 (default: its only disk)")
+	FlagImageExportNode = imageExportCommand.PersistentFlags().String("node", "pve", "node the volume is on, or to limit --vm lookup to")
+	FlagImageExportFormat = imageExportCommand.PersistentFlags().String("format", dttproxmox.FormatQcow2, "format of the local file: "+strings.Join(dttproxmox.ImportFormats, ", "))
+	FlagImageExportTmpDir = imageExportCommand.PersistentFlags().String("tmp-dir", "/var/tmp", "directory on the node to convert the volume in, it needs room for it")
+	FlagImageExportSSHHost = imageExportCommand.PersistentFlags().String("ssh-host", "", "address to reach the node with SSH (default: from cluster status, or --proxmox-host)")
+	FlagImageExportSSHUser = imageExportCommand.PersistentFlags().String("ssh-user", "root", "SSH user on the node, other users than root run qemu-img with sudo")
+	FlagImageExportSSHPassword = imageExportCommand.PersistentFlags().String("ssh-password", "", "SSH password on the node (or set DTT_NODE_SSH_PASSWORD)")
+	FlagImageExportSSHKey = imageExportCommand.PersistentFlags().String("ssh-private-key", "", "SSH private key file for the node, instead of a password")
+	FlagImageExportKnownHosts = imageExportCommand.PersistentFlags().String("ssh-known-hosts", "~/.ssh/known_hosts", "known_hosts file to check the host key of the node against, trusting it on first use (\"\" to not check)")
+
+	imageCommand.AddCommand(imageExportCommand)
+}
+
+// vmDiskVolume returns the volume ID of disk in config, or of its only disk
+// when disk is empty. CD-ROMs and cloud-init drives are not disks.
+func vmDiskVolume(config *proxmox.VirtualMachineConfig, disk string) (string, error) {
+	disks := map[string]string{}
+	for name, value := range config.MergeDisks() {
+		volID, _, _ := strings.Cut(value, ",")
+		if volID == "" || volID == "none" || strings.Contains(value, "media=cdrom") || strings.Contains(volID, "cloudinit") {
+			continue
+		}
+		disks[name] = volID
+	}
+	names := make([]string, 0, len(disks))
+	for name := range disks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if disk != "" {
+		volID, ok := disks[disk]
+		if !ok {
+			return "", fmt.Errorf("%w: the VM has no disk %s, it has %q", ErrUsage, disk, names)
+		}
+		return volID, nil
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("the VM has no disks")
+	case 1:
+		return disks[names[0]], nil
+	default:
+		return "", fmt.Errorf("%w: the VM has disks %s, pick one with --disk", ErrUsage, strings.Join(names, ", "))
+	}
+}
+
+func command_image_export(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !slices.Contains(dttproxmox.ImportFormats, *FlagImageExportFormat) {
+		return fmt.Errorf("%w: --format %q is not one of %s", ErrUsage, *FlagImageExportFormat, strings.Join(dttproxmox.ImportFormats, ", "))
+	}
+
+	sess := getSession()
+	var volID, node, localPath string
+	if *FlagImageExportVM != "" {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: --vm takes only the local file as argument", ErrUsage)
+		}
+		lookup := ""
+		if cmd.Flags().Changed("node") {
+			lookup = *FlagImageExportNode
+		}
+		vm, err := sess.ResolveVM(ctx, *FlagImageExportVM, lookup)
+		if err != nil {
+			return err
+		}
+		if vm.VirtualMachineConfig == nil {
+			return fmt.Errorf("getting the config of VM %d gave no config", uint64(vm.VMID))
+		}
+		if volID, err = vmDiskVolume(vm.VirtualMachineConfig, *FlagImageExportDisk); err != nil {
+			return fmt.Errorf("VM %q (ID %d): %w", vm.Name, uint64(vm.VMID), err)
+		}
+		if vm.Status == "running" {
+			slog.Warn("the VM is running, its disk may be exported halfway through a write, stop it for a consistent image", "vm", vm.Name, "vmid", uint64(vm.VMID))
+		}
+		node = vm.Node
+		if len(args) == 1 {
+			localPath = args[0]
+		}
+	} else {
+		if len(args) == 0 {
+			return missingInput("pass the volume to export, or --vm")
+		}
+		if *FlagImageExportDisk != "" {
+			return fmt.Errorf("%w: --disk needs --vm", ErrUsage)
+		}
+		volID, node = args[0], *FlagImageExportNode
+		if len(args) == 2 {
+			localPath = args[1]
+		}
+	}
+	if localPath == "" {
+		localPath = dttproxmox.ExportFilename(volID, *FlagImageExportFormat)
+	}
+
+	host := *FlagImageExportSSHHost
+	if host == "" {
+		var err error
+		if host, err = nodeAddress(ctx, sess, node); err != nil {
+			return err
+		}
+	}
+	config := ssh.Config{
+		Host:       host,
+		Username:   *FlagImageExportSSHUser,
+		Password:   flagOrEnv(*FlagImageExportSSHPassword, "DTT_NODE_SSH_PASSWORD"),
+		PrivateKey: *FlagImageExportSSHKey,
+	}
+	if *FlagImageExportKnownHosts != "" {
+		path, err := expandHome(*FlagImageExportKnownHosts)
+		if err != nil {
+			return err
+		}
+		config.HostKeyCallback = ssh.TrustOnFirstUse(path)
+	} else {
+		config.InsecureIgnoreHostKey = true
+	}
+	client := ssh.NewClient(config)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("connecting to node %s at %s gave err: %w", node, host, err)
+	}
+	defer client.Close()
+
+	slog.Info("exporting volume", "volid", volID, "node", node, "format", *FlagImageExportFormat, "to", localPath)
+	err := dttproxmox.ExportVolume(ctx, client, volID, localPath, dttproxmox.ExportOptions{
+		Format:   *FlagImageExportFormat,
+		TmpDir:   *FlagImageExportTmpDir,
+		Sudo:     *FlagImageExportSSHUser != "root",
+		Progress: progressOutput(),
+	})
+	if err != nil {
+		return fmt.Errorf("exporting %s from node %s gave err: %w", volID, node, err)
+	}
+
+	fmt.Printf("exported %s from %s to %s\n", volID, node, localPath)
+	return nil
+}
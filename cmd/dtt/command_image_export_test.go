@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+func TestVMDiskVolume(t *testing.T) {
+	config := &proxmox.VirtualMachineConfig{
+		SCSI0: "local-lvm:vm-100-disk-0,iothread=1,size=8G",
+		IDE2:  "local:iso/debian-12.iso,media=cdrom",
+		IDE0:  "local-lvm:vm-100-cloudinit,media=cdrom",
+	}
+	if got, err := vmDiskVolume(config, ""); err != nil || got != "local-lvm:vm-100-disk-0" {
+		t.Errorf("Expected the only disk, got %q, %v", got, err)
+	}
+
+	config = &proxmox.VirtualMachineConfig{
+		SCSI0:   "local-lvm:vm-100-disk-0,size=8G",
+		VirtIO1: "ceph:vm-100-disk-1,size=32G",
+	}
+	if _, err := vmDiskVolume(config, ""); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for a VM with two disks, got %v", err)
+	}
+	if got, err := vmDiskVolume(config, "virtio1"); err != nil || got != "ceph:vm-100-disk-1" {
+		t.Errorf("Expected the disk --disk names, got %q, %v", got, err)
+	}
+	if _, err := vmDiskVolume(config, "ide2"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for a disk the VM doesn't have, got %v", err)
+	}
+	if _, err := vmDiskVolume(&proxmox.VirtualMachineConfig{}, ""); err == nil {
+		t.Error("Expected an error for a VM without disks")
+	}
+}
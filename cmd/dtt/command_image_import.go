@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageImportCommand = &cobra.Command{
+		Use:   "import <local-image-file>",
+		Short: "convert a local disk image if needed and upload it for import",
+		Long: `Upload a local raw, qcow2 or vmdk disk image to the import content of a
+Proxmox storage, to create VMs from. The format is read from the header of the
+file. An image in another format than --format, including vhdx and vdi, is
+converted with qemu-img first, which has to be installed. Compressed images
+have to be decompressed first.
+
+The volume is named after the file with the extension of the format, or
+--name. --sha256 checks the local file before anything is uploaded, and
+Proxmox checks the checksum of what it received, as with dtt image upload.
+
+  dtt image import ./disk.img
+  dtt image import --format raw --name appliance ./appliance.vmdk
+  dtt image import --sha256 9f86d0... ./debian.qcow2`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_image_import,
+	}
+
+	FlagImageImportNode     *string
+	FlagImageImportStorage  *string
+	FlagImageImportFormat   *string
+	FlagImageImportName     *string
+	FlagImageImportSHA256   *string
+	FlagImageImportRetries  *int
+	FlagImageImportNoVerify *bool
+)
+
+func init() {
+	FlagImageImportNode = imageImportCommand.PersistentFlags().String("node", "pve", "which node to import the image to")
+	FlagImageImportStorage = imageImportCommand.PersistentFlags().String("storage", "local", "which storage to import the image to (needs import content)")
+	FlagImageImportFormat = imageImportCommand.PersistentFlags().String("format", dttproxmox.FormatQcow2, "format to store the image in: "+strings.Join(dttproxmox.ImportFormats, ", "))
+	FlagImageImportName = imageImportCommand.PersistentFlags().String("name", "", "file name of the volume (default: the name of the local file)")
+	FlagImageImportSHA256 = imageImportCommand.PersistentFlags().String("sha256", "", "SHA-256 checksum the local file must have")
+	FlagImageImportRetries = imageImportCommand.PersistentFlags().Int("retries", 3, "how many times to try the upload before giving up")
+	FlagImageImportNoVerify = imageImportCommand.PersistentFlags().Bool("no-verify", false, "skip the checksum and size verification of the upload")
+
+	imageCommand.AddCommand(imageImportCommand)
+}
+
+func command_image_import(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if !slices.Contains(dttproxmox.ImportFormats, *FlagImageImportFormat) {
+		return fmt.Errorf("%w: --format %q is not one of %s", ErrUsage, *FlagImageImportFormat, strings.Join(dttproxmox.ImportFormats, ", "))
+	}
+	imageFile := args[0]
+	format, err := dttproxmox.DetectImageFormat(imageFile)
+	if err != nil {
+		return err
+	}
+	slog.Info("importing image", "image", imageFile, "format", format, "to", *FlagImageImportFormat, "node", *FlagImageImportNode, "storage", *FlagImageImportStorage)
+
+	sess := getSession()
+	volID, err := dttproxmox.ImportImage(ctx, sess.pac, *FlagImageImportNode, *FlagImageImportStorage, imageFile, dttproxmox.ImportOptions{
+		UploadOptions: dttproxmox.UploadOptions{
+			Attempts: *FlagImageImportRetries,
+			Backoff:  5 * time.Second,
+			NoVerify: *FlagImageImportNoVerify,
+			Progress: progressOutput(),
+			TaskLog:  sess.taskLog,
+		},
+		Format:        *FlagImageImportFormat,
+		Name:          *FlagImageImportName,
+		SHA256:        *FlagImageImportSHA256,
+		ConvertOutput: os.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("importing image %s to %s/%s gave err: %w", imageFile, *FlagImageImportNode, *FlagImageImportStorage, err)
+	}
+
+	fmt.Printf("imported image %s to %s/%s as %s\n", imageFile, *FlagImageImportNode, *FlagImageImportStorage, volID)
+	return nil
+}
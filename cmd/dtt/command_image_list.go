@@ -3,11 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"sort"
 	"strings"
 	"text/tabwriter"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
 	"github.com/spf13/cobra"
 )
 
@@ -20,18 +21,42 @@ var (
 
 	FlagImageListNode    *string
 	FlagImageListStorage *string
+	FlagImageListCatalog *bool
+	FlagImageListOutput  *string
 )
 
+// imageRow is an import image on a storage.
+type imageRow struct {
+	Name   string `json:"name" yaml:"name"`
+	Format string `json:"format" yaml:"format"`
+	Size   uint64 `json:"size" yaml:"size"`
+	VolID  string `json:"volid" yaml:"volid"`
+}
+
 func init() {
 	FlagImageListNode = imageListCommand.PersistentFlags().String("node", "pve", "which node to list images from")
 	FlagImageListStorage = imageListCommand.PersistentFlags().String("storage", "local", "which storage to list images from")
+	FlagImageListCatalog = imageListCommand.PersistentFlags().Bool("catalog", false, "list the images dtt run and image download know instead")
+	FlagImageListOutput = addOutputFlag(imageListCommand)
 	imageCommand.AddCommand(imageListCommand)
 }
 
 func command_image_list(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	if *FlagImageListCatalog {
+		images := dttproxmox.DefaultImages()
+		return writeOutput(cmd.OutOrStdout(), *FlagImageListOutput, images, func(w io.Writer) error {
+			writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "NAME\tOS\tVERSION\tURL")
+			for _, img := range images {
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", img.Name, img.OS, img.Version, img.URL)
+			}
+			return writer.Flush()
+		})
+	}
+
+	pac := getSession().pac
 
 	node, err := pac.Node(ctx, *FlagImageListNode)
 	if err != nil {
@@ -48,12 +73,7 @@ func command_image_list(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("getting storage content gave err: %w", err)
 	}
 
-	imageRows := make([]struct {
-		Name   string
-		Format string
-		Size   uint64
-		VolID  string
-	}, 0, len(content))
+	imageRows := make([]imageRow, 0, len(content))
 
 	prefix := *FlagImageListStorage + ":import/"
 	for _, c := range content {
@@ -68,12 +88,7 @@ func command_image_list(cmd *cobra.Command, args []string) error {
 			}
 		}
 
-		imageRows = append(imageRows, struct {
-			Name   string
-			Format string
-			Size   uint64
-			VolID  string
-		}{
+		imageRows = append(imageRows, imageRow{
 			Name:   name,
 			Format: c.Format,
 			Size:   c.Size,
@@ -88,20 +103,21 @@ func command_image_list(cmd *cobra.Command, args []string) error {
 		return imageRows[i].Name < imageRows[j].Name
 	})
 
-	fmt.Printf("Images on %s/%s\n", *FlagImageListNode, *FlagImageListStorage)
-	if len(imageRows) == 0 {
-		fmt.Println("No import images found.")
-		return nil
-	}
-
-	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	fmt.Fprintln(writer, "NAME\tFORMAT\tSIZE\tVOLID")
-	for _, row := range imageRows {
-		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", row.Name, row.Format, formatBytes(row.Size), row.VolID)
-	}
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("flushing image list writer gave err: %w", err)
-	}
+	return writeOutput(cmd.OutOrStdout(), *FlagImageListOutput, imageRows, func(w io.Writer) error {
+		fmt.Fprintf(w, "Images on %s/%s\n", *FlagImageListNode, *FlagImageListStorage)
+		if len(imageRows) == 0 {
+			fmt.Fprintln(w, "No import images found.")
+			return nil
+		}
 
-	return nil
-}
\ No newline at end of file
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tFORMAT\tSIZE\tVOLID")
+		for _, row := range imageRows {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", row.Name, row.Format, formatBytes(row.Size), row.VolID)
+		}
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("flushing image list writer gave err: %w", err)
+		}
+		return nil
+	})
+}
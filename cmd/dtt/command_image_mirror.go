@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageMirrorCommand = &cobra.Command{
+		Use:   "mirror <image-name-or-release...>",
+		Short: "download images to a storage of every node",
+		Long: `Download images into the import content of a storage on every online node
+of the cluster, or on the nodes --nodes names, so VMs can be created from them
+on any node. Nodes that have an image already are left alone. The nodes
+download in parallel, each from the URL of the image, and a summary of what
+happened on each node is printed once they are done. A shared storage is
+downloaded to once.
+
+Name images as image list --catalog does, or as releases such as ubuntu:noble.
+
+  dtt image mirror debian-11
+  dtt image mirror ubuntu:noble debian:12 --storage images --nodes pve2,pve3`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: command_image_mirror,
+	}
+
+	FlagImageMirrorStorage *string
+	FlagImageMirrorNodes   *[]string
+	FlagImageMirrorOutput  *string
+)
+
+func init() {
+	FlagImageMirrorStorage = imageMirrorCommand.PersistentFlags().String("storage", "local", "storage to download the images to on every node (needs import content)")
+	FlagImageMirrorNodes = imageMirrorCommand.PersistentFlags().StringSlice("nodes", nil, "nodes to download the images to (default: every online node)")
+	FlagImageMirrorOutput = addOutputFlag(imageMirrorCommand)
+	imageCommand.AddCommand(imageMirrorCommand)
+}
+
+// How mirroring an image went on a node, see mirrorRow.
+const (
+	mirrorPresent    = "present"
+	mirrorDownloaded = "downloaded"
+	mirrorShared     = "shared"
+	mirrorSkipped    = "skipped"
+	mirrorFailed     = "failed"
+)
+
+// mirrorRow is how mirroring an image to a node went.
+type mirrorRow struct {
+	Image    string `json:"image" yaml:"image"`
+	Node     string `json:"node" yaml:"node"`
+	VolID    string `json:"volid" yaml:"volid"`
+	Status   string `json:"status" yaml:"status"`
+	Duration string `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Note says why a node was skipped or failed, or which node downloaded
+	// to a shared storage.
+	Note string `json:"note,omitempty" yaml:"note,omitempty"`
+}
+
+// mirrorImages downloads images to storage on nodes, every online node when
+// empty, in parallel per node. It returns a row per node and image, sorted
+// by node, and an error only when the nodes can't be listed.
+func mirrorImages(ctx context.Context, sess *session, images []dttproxmox.Image, storage string, nodes []string) ([]mirrorRow, error) {
+	storages, err := listStorages(ctx, sess, "", "")
+	if err != nil {
+		return nil, err
+	}
+	var online []string
+	for _, s := range storages {
+		if !slices.Contains(online, s.Node) {
+			online = append(online, s.Node)
+		}
+	}
+	for _, node := range nodes {
+		if !slices.Contains(online, node) {
+			return nil, fmt.Errorf("%w: node %s is not an online node of the cluster", ErrUsage, node)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = online
+	}
+	slices.Sort(nodes)
+
+	// Different releases can share an image, such as ubuntu:noble and
+	// ubuntu:24.04.
+	var unique []dttproxmox.Image
+	for _, image := range images {
+		if !slices.ContainsFunc(unique, func(i dttproxmox.Image) bool { return i.URL == image.URL }) {
+			unique = append(unique, image)
+		}
+	}
+
+	rows := make([]mirrorRow, 0, len(nodes)*len(unique))
+	var downloads []int // the first row of every node that downloads
+	sharedBy := ""
+	for _, node := range nodes {
+		idx := slices.IndexFunc(storages, func(s storageRow) bool { return s.Node == node && s.Name == storage })
+		var status, note string
+		switch {
+		case idx < 0:
+			status, note = mirrorSkipped, fmt.Sprintf("no storage %s", storage)
+		case !slices.Contains(storages[idx].Content, "import"):
+			status, note = mirrorSkipped, fmt.Sprintf("storage %s has no import content", storage)
+		case storages[idx].Shared && sharedBy != "":
+			status, note = mirrorShared, "downloaded on "+sharedBy
+		case storages[idx].Shared:
+			sharedBy = node
+		}
+		if status == "" {
+			downloads = append(downloads, len(rows))
+		}
+		for _, image := range unique {
+			volID, err := dttproxmox.ImageVolID(image, storage)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, mirrorRow{Image: image.Name, Node: node, VolID: volID, Status: status, Note: note})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, first := range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mirrorNode(ctx, sess, unique, storage, rows[first:first+len(unique)])
+		}()
+	}
+	wg.Wait()
+	return rows, nil
+}
+
+// mirrorNode downloads the images to storage on the node of rows, one after
+// the other, filling in how it went in rows.
+func mirrorNode(ctx context.Context, sess *session, images []dttproxmox.Image, storage string, rows []mirrorRow) {
+	node := rows[0].Node
+	// The bar of progressOutput is for one node at a time.
+	var progress dttproxmox.ProgressFunc
+	if !*FlagQuiet {
+		progress = dttproxmox.LogProgress(slog.Default().With("node", node))
+	}
+	client := dttproxmox.NewClientWithAPI(dttproxmox.ClientConfig{
+		Node:         node,
+		ImageStorage: storage,
+		Progress:     progress,
+		TaskLog:      sess.taskLog,
+	}, sess.pac)
+
+	volumes, err := listStorageContent(ctx, sess, node, storage, "import")
+	for i, image := range images {
+		row := &rows[i]
+		if err != nil {
+			row.Status, row.Note = mirrorFailed, err.Error()
+			continue
+		}
+		if slices.ContainsFunc(volumes, func(v storageVolume) bool { return v.VolID == row.VolID }) {
+			row.Status = mirrorPresent
+			continue
+		}
+		start := time.Now()
+		if downloadErr := client.DownloadImage(ctx, image, storage); downloadErr != nil {
+			row.Status, row.Note = mirrorFailed, downloadErr.Error()
+		} else {
+			row.Status = mirrorDownloaded
+		}
+		row.Duration = time.Since(start).Round(time.Second).String()
+	}
+}
+
+func command_image_mirror(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	images, err := refreshImages(args)
+	if err != nil {
+		return err
+	}
+	rows, err := mirrorImages(ctx, getSession(), images, *FlagImageMirrorStorage, *FlagImageMirrorNodes)
+	if err != nil {
+		return err
+	}
+	err = writeOutput(cmd.OutOrStdout(), *FlagImageMirrorOutput, rows, func(w io.Writer) error {
+		return writeMirrorRows(w, rows)
+	})
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, row := range rows {
+		if row.Status == mirrorFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("mirroring failed for %d image and node pairs", failed)
+	}
+	return nil
+}
+
+// writeMirrorRows writes the summary table of image mirror.
+func writeMirrorRows(w io.Writer, rows []mirrorRow) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "IMAGE\tNODE\tVOLID\tSTATUS\tDURATION\tNOTE")
+	for _, r := range rows {
+		duration, note := r.Duration, r.Note
+		if duration == "" {
+			duration = "-"
+		}
+		if note == "" {
+			note = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Image, r.Node, r.VolID, r.Status, duration, note)
+	}
+	return writer.Flush()
+}
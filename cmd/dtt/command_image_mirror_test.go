@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestMirrorImages(t *testing.T) {
+	image := dttproxmox.DefaultImages()[0]
+	volID, err := dttproxmox.ImageVolID(image, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve").Storage["local"] = []string{volID}
+	pve2 := server.AddNode("pve2")
+	server.AddNode("pve3").StorageContent["local"] = "iso,vztmpl"
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+	rows, err := mirrorImages(ctx, sess, []dttproxmox.Image{image, image}, "local", nil)
+	if err != nil {
+		t.Fatalf("mirrorImages() gave err: %v", err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.Node+" "+r.Status)
+	}
+	want := []string{"pve present", "pve2 downloaded", "pve3 skipped"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected a row per node, got %q, want %q", got, want)
+	}
+	if !slices.Contains(pve2.Storage["local"], volID) {
+		t.Errorf("Expected %s to be downloaded on pve2, got %q", volID, pve2.Storage["local"])
+	}
+	if rows[1].VolID != volID || rows[1].Duration == "" || rows[2].Note == "" {
+		t.Errorf("Expected the volume, duration and why a node was skipped, got %+v", rows)
+	}
+
+	rows, err = mirrorImages(ctx, sess, []dttproxmox.Image{image}, "local", []string{"pve2"})
+	if err != nil || len(rows) != 1 || rows[0].Status != mirrorPresent {
+		t.Errorf("Expected the image to be present on pve2 now, got %+v, %v", rows, err)
+	}
+	if _, err := mirrorImages(ctx, sess, []dttproxmox.Image{image}, "local", []string{"pve9"}); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for an unknown node, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeMirrorRows(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "pve2  "+volID+"  present  -") {
+		t.Errorf("Expected a row for pve2, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageRefreshCommand = &cobra.Command{
+		Use:   "refresh [image-name-or-release...]",
+		Short: "download cloud images again when upstream has a newer one",
+		Long: `Compare images in the import content of a storage with the ones at their
+URL, and download those again that upstream modified since, or whose size
+changed. Images are found once and kept: without a refresh a VM of a "latest"
+or daily image boots the one of the day it was first used.
+
+Name images as image list --catalog does, or as releases such as ubuntu:noble.
+Without names, every image dtt knows that is on the storage is checked.
+
+  dtt image refresh
+  dtt image refresh ubuntu:noble debian:12
+  dtt image refresh --check`,
+		RunE: command_image_refresh,
+	}
+
+	FlagImageRefreshNode    *string
+	FlagImageRefreshStorage *string
+	FlagImageRefreshCheck   *bool
+)
+
+func init() {
+	FlagImageRefreshNode = imageRefreshCommand.PersistentFlags().String("node", "pve", "which node to refresh the images on")
+	FlagImageRefreshStorage = imageRefreshCommand.PersistentFlags().String("storage", "local", "storage with the images (needs import content)")
+	FlagImageRefreshCheck = imageRefreshCommand.PersistentFlags().Bool("check", false, "only report which images are stale, don't download them")
+	imageCommand.AddCommand(imageRefreshCommand)
+}
+
+// releaseImage returns the image of release, such as ubuntu:noble.
+func releaseImage(release string) (dttproxmox.Image, error) {
+	distro, version, err := extractDistroVersionFromRelease(release)
+	if err != nil {
+		return dttproxmox.Image{}, err
+	}
+	if distro == "" {
+		return dttproxmox.Image{}, fmt.Errorf("%q is no release such as ubuntu:noble", release)
+	}
+	image, err := cloudImageFor(distro, version, release)
+	if err != nil {
+		return dttproxmox.Image{}, err
+	}
+	return dttproxmox.Image{Name: release, OS: distro, Version: version, URL: image.URL}, nil
+}
+
+// refreshImages returns the images names names, the catalog images first and
+// then releases. Without names it returns every image dtt knows.
+func refreshImages(names []string) ([]dttproxmox.Image, error) {
+	catalog := dttproxmox.DefaultImages()
+	if len(names) == 0 {
+		images := catalog
+		for _, release := range knownReleases() {
+			image, err := releaseImage(release)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, image)
+		}
+		return images, nil
+	}
+
+	var images []dttproxmox.Image
+names:
+	for _, name := range names {
+		for _, image := range catalog {
+			if image.Name == name {
+				images = append(images, image)
+				continue names
+			}
+		}
+		if !strings.Contains(name, ":") {
+			var known []string
+			for _, image := range catalog {
+				known = append(known, image.Name)
+			}
+			return nil, fmt.Errorf("%w: unknown image %q, known images are %s, or use a release such as ubuntu:noble", ErrUsage, name, strings.Join(known, ", "))
+		}
+		image, err := releaseImage(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUsage, err)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func command_image_refresh(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	images, err := refreshImages(args)
+	if err != nil {
+		return err
+	}
+	client := getSession().Provisioner(*FlagImageRefreshNode, *FlagImageRefreshStorage, "")
+
+	// Different releases can share an image, such as ubuntu:noble and
+	// ubuntu:24.04.
+	seen := map[string]bool{}
+	stale := 0
+	for _, image := range images {
+		if seen[image.URL] {
+			continue
+		}
+		seen[image.URL] = true
+
+		check, err := client.CheckImage(ctx, image, *FlagImageRefreshStorage)
+		if err != nil {
+			return fmt.Errorf("checking image %s gave err: %w", image.Name, err)
+		}
+		switch {
+		case !check.Present && len(args) == 0:
+			continue
+		case check.Present && !check.Stale:
+			fmt.Printf("%s: %s is up to date\n", image.Name, check.VolID)
+			continue
+		case check.Present:
+			stale++
+			fmt.Printf("%s: %s is stale, %s\n", image.Name, check.VolID, check.Reason)
+		default:
+			fmt.Printf("%s: %s is missing\n", image.Name, check.VolID)
+		}
+		if *FlagImageRefreshCheck {
+			continue
+		}
+		if _, err := client.RefreshImage(ctx, image, *FlagImageRefreshStorage); err != nil {
+			return fmt.Errorf("refreshing image %s gave err: %w", image.Name, err)
+		}
+		fmt.Printf("%s: downloaded %s\n", image.Name, image.URL)
+	}
+	if *FlagImageRefreshCheck && stale > 0 {
+		fmt.Printf("%d stale images, run dtt image refresh to download them again\n", stale)
+	}
+	return nil
+}
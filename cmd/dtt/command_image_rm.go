@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -30,7 +31,7 @@ func init() {
 func command_image_rm(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	pac := getSession().pac
 
 	if len(args) != 1 {
 		return fmt.Errorf("usage: dtt image rm <image-name>")
@@ -49,14 +50,14 @@ func command_image_rm(cmd *cobra.Command, args []string) error {
 	}
 
 	volid := fmt.Sprintf("%s:import/%s", *FlagImageRmStorage, imageName)
-	fmt.Printf("removing image %s from %s/%s\n", imageName, *FlagImageRmNode, *FlagImageRmStorage)
+	slog.Info("removing image", "image", imageName, "node", *FlagImageRmNode, "storage", *FlagImageRmStorage)
 
 	task, err := storage.DeleteContent(ctx, volid)
 	if err != nil {
 		return fmt.Errorf("deleting image %s gave err: %w", volid, err)
 	}
 
-	if err := task.Wait(ctx, time.Second, 2*time.Minute); err != nil {
+	if err := waitTask(ctx, task, time.Second, 2*time.Minute); err != nil {
 		return fmt.Errorf("waiting for delete task gave err: %w", err)
 	}
 
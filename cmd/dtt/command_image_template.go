@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -20,6 +21,12 @@ Supported releases:
           or ubuntu:24.04, ubuntu:22.04, ubuntu:20.04, ubuntu:18.04, ubuntu:16.04
   Debian: debian:trixie, debian:bookworm, debian:bullseye, debian:buster
           or debian:13, debian:12, debian:11, debian:10
+  Fedora: fedora:42, fedora:41
+  Rocky Linux, AlmaLinux: rocky:10, rocky:9, rocky:8, almalinux:10, almalinux:9, almalinux:8
+  openSUSE: opensuse:tumbleweed, opensuse:leap-15.6, opensuse:leap-15.5
+          or opensuse:15.6, opensuse:15.5
+  Alpine: alpine:3.21, alpine:3.20
+  Arch:   arch:latest
 
 Examples:
   dtt image download-template ubuntu:24.04
@@ -49,7 +56,7 @@ func init() {
 
 func command_image_template(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	pac := getPACFromFlags()
+	pac := getSession().pac
 
 	release := strings.TrimSpace(args[0])
 	if release == "" {
@@ -65,19 +72,17 @@ func command_image_template(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid release format %q, expected format: distro:version (e.g., ubuntu:24.04)", release)
 	}
 
-	cloudImageURL, err := getFnFromCloudImageURL(distro, version, release)
+	image, err := cloudImageFor(distro, version, release)
 	if err != nil {
 		return fmt.Errorf("failed to get cloud image URL: %w", err)
 	}
+	cloudImageURL := image.URL
 
-	qcow2Name, err := extractFn(cloudImageURL)
+	qcow2Name, err := image.importFilename()
 	if err != nil {
 		return fmt.Errorf("failed to extract filename from URL %q", cloudImageURL)
 	}
 
-	// Needed for ubuntu minimal cloud images (they use .img extension but are qcow2)
-	qcow2Name = strings.ReplaceAll(qcow2Name, ".img", ".qcow2")
-
 	node, err := pac.Node(ctx, *FlagImageTemplateNode)
 	if err != nil {
 		return fmt.Errorf("getting node %s: %w", *FlagImageTemplateNode, err)
@@ -102,15 +107,14 @@ func command_image_template(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	fmt.Printf("downloading %s (%s) to %s/%s...\n", release, qcow2Name, *FlagImageTemplateNode, *FlagImageTemplateStorage)
-	fmt.Printf("source: %s\n", cloudImageURL)
+	slog.Info("downloading image", "release", release, "image", qcow2Name, "node", *FlagImageTemplateNode, "storage", *FlagImageTemplateStorage, "url", cloudImageURL)
 
 	task, err := storage.DownloadURL(ctx, "import", qcow2Name, cloudImageURL)
 	if err != nil {
 		return fmt.Errorf("downloading image: %w", err)
 	}
 
-	if err := task.Wait(ctx, time.Second, 30*time.Minute); err != nil {
+	if err := waitTask(ctx, task, time.Second, 30*time.Minute); err != nil {
 		return fmt.Errorf("waiting for download: %w", err)
 	}
 
@@ -134,6 +138,26 @@ func command_image_list_templates(cmd *cobra.Command, args []string) error {
 	fmt.Println("  debian:bullseye  (11)")
 	fmt.Println("  debian:buster    (10)")
 	fmt.Println()
+	fmt.Println("Fedora (Cloud Base Generic):")
+	fmt.Println("  fedora:42")
+	fmt.Println("  fedora:41")
+	fmt.Println()
+	fmt.Println("Rocky Linux and AlmaLinux (GenericCloud):")
+	fmt.Println("  rocky:10, rocky:9, rocky:8")
+	fmt.Println("  almalinux:10, almalinux:9, almalinux:8")
+	fmt.Println()
+	fmt.Println("openSUSE (Minimal VM Cloud):")
+	fmt.Println("  opensuse:tumbleweed")
+	fmt.Println("  opensuse:leap-15.6  (15.6)")
+	fmt.Println("  opensuse:leap-15.5  (15.5)")
+	fmt.Println()
+	fmt.Println("Alpine (nocloud, BIOS):")
+	fmt.Println("  alpine:3.21")
+	fmt.Println("  alpine:3.20")
+	fmt.Println()
+	fmt.Println("Arch Linux (rolling):")
+	fmt.Println("  arch:latest")
+	fmt.Println()
 	fmt.Println("You can also use version numbers: ubuntu:24.04, debian:12, etc.")
 	fmt.Println()
 	fmt.Println("Example: dtt image download-template ubuntu:noble")
@@ -3,9 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
-
+	"log/slog"
 	"time"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
 	"github.com/spf13/cobra"
 )
 
@@ -13,17 +14,27 @@ var (
 	imageUploadCommand = &cobra.Command{
 		Use:   "upload",
 		Short: "upload a VM image",
-		Args:  cobra.ExactArgs(1),
-		RunE:  command_image_upload,
+		Long: `Upload a local VM image to the import content of a Proxmox storage.
+
+Proxmox takes an upload in a single request and discards partial uploads, so a
+failed upload is retried from the start. Proxmox checks the SHA-256 checksum
+of the received file, and dtt compares the size of the stored volume with the
+local file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_image_upload,
 	}
 
-	FlagImageUploadNode    *string
-	FlagImageUploadStorage *string
+	FlagImageUploadNode     *string
+	FlagImageUploadStorage  *string
+	FlagImageUploadRetries  *int
+	FlagImageUploadNoVerify *bool
 )
 
 func init() {
 	FlagImageUploadNode = imageUploadCommand.PersistentFlags().String("node", "pve", "which node to upload the image to")
 	FlagImageUploadStorage = imageUploadCommand.PersistentFlags().String("storage", "local", "which storage to upload the image to")
+	FlagImageUploadRetries = imageUploadCommand.PersistentFlags().Int("retries", 3, "how many times to try the upload before giving up")
+	FlagImageUploadNoVerify = imageUploadCommand.PersistentFlags().Bool("no-verify", false, "skip the checksum and size verification")
 
 	imageCommand.AddCommand(imageUploadCommand)
 }
@@ -31,7 +42,7 @@ func init() {
 func command_image_upload(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	pac := getSession().pac
 
 	if len(args) != 1 {
 		return fmt.Errorf("usage: dtt image upload <local-image-file>")
@@ -39,26 +50,18 @@ func command_image_upload(cmd *cobra.Command, args []string) error {
 
 	imageFile := args[0]
 
-	node, err := pac.Node(ctx, *FlagImageUploadNode)
-	if err != nil {
-		return fmt.Errorf("getting node %s gave err: %w", *FlagImageUploadNode, err)
-	}
-
-	storage, err := node.Storage(ctx, *FlagImageUploadStorage)
-	if err != nil {
-		return fmt.Errorf("getting storage %s on node %s gave err: %w", *FlagImageUploadStorage, *FlagImageUploadNode, err)
-	}
-
-	fmt.Printf("uploading image %s to %s/%s\n", imageFile, *FlagImageUploadNode, *FlagImageUploadStorage)
-	task, err := storage.Upload("import", imageFile)
+	slog.Info("uploading image", "image", imageFile, "node", *FlagImageUploadNode, "storage", *FlagImageUploadStorage)
+	volID, err := dttproxmox.UploadImage(ctx, pac, *FlagImageUploadNode, *FlagImageUploadStorage, imageFile, dttproxmox.UploadOptions{
+		Attempts: *FlagImageUploadRetries,
+		Backoff:  5 * time.Second,
+		NoVerify: *FlagImageUploadNoVerify,
+		Progress: progressOutput(),
+		TaskLog:  getSession().taskLog,
+	})
 	if err != nil {
 		return fmt.Errorf("uploading image %s to %s/%s gave err: %w", imageFile, *FlagImageUploadNode, *FlagImageUploadStorage, err)
 	}
 
-	if err := task.Wait(ctx, time.Second, 30*time.Minute); err != nil {
-		return fmt.Errorf("waiting for upload task gave err: %w", err)
-	}
-
-	fmt.Printf("uploaded image %s to %s/%s\n", imageFile, *FlagImageUploadNode, *FlagImageUploadStorage)
+	fmt.Printf("uploaded image %s to %s/%s as %s\n", imageFile, *FlagImageUploadNode, *FlagImageUploadStorage, volID)
 	return nil
-}
\ No newline at end of file
+}
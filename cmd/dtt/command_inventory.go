@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inventoryCommand = &cobra.Command{
+		Use:   "inventory",
+		Short: "print the dtt vms as an inventory for other tools",
+		Long: `Print the VMs dtt created as an inventory. The ansible format is the JSON of
+an Ansible dynamic inventory script: every VM is in the group "dtt", in
+"tag_<tag>" for each of its tags and in "pool_<pool>" for its pool, with
+ansible_host set to the guest agent IP and ansible_user to its cloud-init
+user. Use it from a script like
+
+  #!/bin/sh
+  exec dtt inventory --format ansible "$@"
+
+which Ansible calls with --list, or with --host <name>.`,
+		Args: cobra.NoArgs,
+		RunE: command_inventory,
+	}
+
+	FlagInventoryFormat     *string
+	FlagInventoryNode       *string
+	FlagInventoryList       *bool
+	FlagInventoryHost       *string
+	FlagInventoryUser       *string
+	FlagInventoryPrivateKey *string
+	FlagInventoryPython     *string
+	FlagInventorySSHArgs    *string
+)
+
+func init() {
+	FlagInventoryFormat = inventoryCommand.PersistentFlags().String("format", "ansible", "inventory format, only ansible is supported")
+	FlagInventoryNode = inventoryCommand.PersistentFlags().String("node", "", "only include VMs on this node")
+	FlagInventoryList = inventoryCommand.PersistentFlags().Bool("list", false, "print the whole inventory, the default (for Ansible)")
+	FlagInventoryHost = inventoryCommand.PersistentFlags().String("host", "", "print the variables of one host (for Ansible)")
+	FlagInventoryUser = inventoryCommand.PersistentFlags().String("user", "", "ansible_user of all hosts (default: the cloud-init user of each VM)")
+	FlagInventoryPrivateKey = inventoryCommand.PersistentFlags().String("ssh-private-key", "", "ansible_ssh_private_key_file of all hosts")
+	FlagInventoryPython = inventoryCommand.PersistentFlags().String("python-interpreter", "/usr/bin/python3", "ansible_python_interpreter of all hosts, empty to let Ansible discover it")
+	FlagInventorySSHArgs = inventoryCommand.PersistentFlags().String("ssh-common-args", "", "ansible_ssh_common_args of all hosts, e.g. \"-o StrictHostKeyChecking=no\"")
+
+	rootCmd.AddCommand(inventoryCommand)
+}
+
+// inventoryVars are the connection variables set on every host.
+type inventoryVars struct {
+	User       string
+	PrivateKey string
+	Python     string
+	SSHArgs    string
+}
+
+var groupNameReplacer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ansibleGroup turns a tag or pool into a valid Ansible group name.
+func ansibleGroup(prefix, name string) string {
+	return prefix + "_" + groupNameReplacer.ReplaceAllString(name, "_")
+}
+
+// ansibleInventory returns the dynamic inventory of vms and, for --host, the
+// variables of each host. Hosts are named after their VM, with the VMID
+// appended when several VMs share a name.
+func ansibleInventory(vms []managedVM, vars inventoryVars) (map[string]interface{}, map[string]map[string]interface{}) {
+	names := map[string]int{}
+	for _, vm := range vms {
+		names[vm.Name]++
+	}
+
+	hostvars := map[string]map[string]interface{}{}
+	groups := map[string][]string{}
+	for _, vm := range vms {
+		host := vm.Name
+		if host == "" || names[vm.Name] > 1 {
+			host = strings.TrimPrefix(fmt.Sprintf("%s-%d", vm.Name, vm.VMID), "-")
+		}
+
+		hv := map[string]interface{}{
+			"dtt_vmid":   vm.VMID,
+			"dtt_node":   vm.Node,
+			"dtt_status": vm.Status,
+		}
+		if vm.IP != "" {
+			hv["ansible_host"] = vm.IP
+		}
+		if user := firstNonEmpty(vars.User, vm.Provenance.User); user != "" {
+			hv["ansible_user"] = user
+		}
+		if vars.PrivateKey != "" {
+			hv["ansible_ssh_private_key_file"] = vars.PrivateKey
+		}
+		if vars.Python != "" {
+			hv["ansible_python_interpreter"] = vars.Python
+		}
+		if vars.SSHArgs != "" {
+			hv["ansible_ssh_common_args"] = vars.SSHArgs
+		}
+		if vm.Provenance.Purpose != "" {
+			hv["dtt_purpose"] = vm.Provenance.Purpose
+		}
+		hostvars[host] = hv
+
+		groups["dtt"] = append(groups["dtt"], host)
+		for _, tag := range vm.Tags {
+			if tag != dttproxmox.ManagedTag {
+				groups[ansibleGroup("tag", tag)] = append(groups[ansibleGroup("tag", tag)], host)
+			}
+		}
+		if vm.Pool != "" {
+			groups[ansibleGroup("pool", vm.Pool)] = append(groups[ansibleGroup("pool", vm.Pool)], host)
+		}
+	}
+
+	inventory := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+	children := []string{}
+	for name, hosts := range groups {
+		inventory[name] = map[string]interface{}{"hosts": hosts}
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	inventory["all"] = map[string]interface{}{"children": children}
+	return inventory, hostvars
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func command_inventory(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if *FlagInventoryFormat != "ansible" {
+		return fmt.Errorf("unknown inventory format %q, only ansible is supported", *FlagInventoryFormat)
+	}
+
+	vms, err := listManagedVMs(ctx, getSession(), *FlagInventoryNode)
+	if err != nil {
+		return fmt.Errorf("listing dtt VMs gave err: %w", err)
+	}
+	inventory, hostvars := ansibleInventory(vms, inventoryVars{
+		User:       *FlagInventoryUser,
+		PrivateKey: *FlagInventoryPrivateKey,
+		Python:     *FlagInventoryPython,
+		SSHArgs:    *FlagInventorySSHArgs,
+	})
+
+	var out interface{} = inventory
+	if *FlagInventoryHost != "" {
+		vars, ok := hostvars[*FlagInventoryHost]
+		if !ok {
+			vars = map[string]interface{}{}
+		}
+		out = vars
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return fmt.Errorf("writing inventory gave err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+)
+
+func TestAnsibleInventory(t *testing.T) {
+	vms := []managedVM{
+		{Node: "pve", VMID: 100, Name: "web", Status: "running", IP: "10.0.0.5", Tags: []string{"dtt", "k8s-node"}, Pool: "ci",
+			Provenance: dttproxmox.Provenance{User: "dtt", Purpose: "run hello"}},
+		{Node: "pve", VMID: 101, Name: "db", Status: "running", Tags: []string{"dtt"}, Provenance: dttproxmox.Provenance{User: "admin"}},
+		{Node: "pve2", VMID: 102, Name: "db", Status: "stopped", Tags: []string{"dtt"}},
+	}
+
+	inventory, hostvars := ansibleInventory(vms, inventoryVars{PrivateKey: "~/.ssh/dtt", Python: "/usr/bin/python3"})
+
+	if got := inventory["dtt"].(map[string]interface{})["hosts"]; !reflect.DeepEqual(got, []string{"web", "db-101", "db-102"}) {
+		t.Errorf("Expected every VM in group dtt, with the VMID for duplicate names, got %v", got)
+	}
+	if got := inventory["tag_k8s_node"].(map[string]interface{})["hosts"]; !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("Expected web in group tag_k8s_node, got %v", got)
+	}
+	if got := inventory["pool_ci"].(map[string]interface{})["hosts"]; !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("Expected web in group pool_ci, got %v", got)
+	}
+	if _, ok := inventory["tag_dtt"]; ok {
+		t.Error("Expected no group for the dtt tag itself")
+	}
+	if got := inventory["all"].(map[string]interface{})["children"]; !reflect.DeepEqual(got, []string{"dtt", "pool_ci", "tag_k8s_node"}) {
+		t.Errorf("Expected all groups as children of all, got %v", got)
+	}
+
+	web := hostvars["web"]
+	if web["ansible_host"] != "10.0.0.5" || web["ansible_user"] != "dtt" || web["ansible_ssh_private_key_file"] != "~/.ssh/dtt" || web["dtt_vmid"] != uint64(100) {
+		t.Errorf("Unexpected host vars of web: %v", web)
+	}
+	if _, ok := hostvars["db-102"]["ansible_host"]; ok {
+		t.Error("Expected no ansible_host for a VM without an IP")
+	}
+
+	_, hostvars = ansibleInventory(vms, inventoryVars{User: "root"})
+	if hostvars["db-101"]["ansible_user"] != "root" {
+		t.Errorf("Expected --user to override the cloud-init user, got %v", hostvars["db-101"])
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeGetCommand = &cobra.Command{
+		Use:   "get <node>",
+		Short: "show the CPU, kernel, Proxmox version, subscription and bridges of a node",
+		Long: `Show the CPU, kernel, Proxmox VE version, subscription and network bridges
+of a node. --bridge fails unless the node has the bridges, to check them
+before creating VMs on it:
+
+  dtt node get pve -o json
+  dtt node get pve --bridge vmbr0 --bridge vmbr1`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_node_get,
+	}
+
+	FlagNodeGetBridges *[]string
+	FlagNodeGetOutput  *string
+)
+
+func init() {
+	FlagNodeGetBridges = nodeGetCommand.PersistentFlags().StringSlice("bridge", nil, "fail unless the node has this bridge (can be repeated)")
+	FlagNodeGetOutput = addOutputFlag(nodeGetCommand)
+	nodeCommand.AddCommand(nodeGetCommand)
+}
+
+// nodeBridge is a network bridge of a node.
+type nodeBridge struct {
+	Name   string `json:"name" yaml:"name"`
+	CIDR   string `json:"cidr,omitempty" yaml:"cidr,omitempty"`
+	Ports  string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Active bool   `json:"active" yaml:"active"`
+}
+
+// nodeDetails is a node as node get shows it.
+type nodeDetails struct {
+	Name         string       `json:"name" yaml:"name"`
+	CPUModel     string       `json:"cpu_model" yaml:"cpu_model"`
+	CPUs         int          `json:"cpus" yaml:"cpus"`
+	Sockets      int          `json:"sockets" yaml:"sockets"`
+	Kernel       string       `json:"kernel" yaml:"kernel"`
+	PVEVersion   string       `json:"pve_version" yaml:"pve_version"`
+	Mem          uint64       `json:"mem" yaml:"mem"`
+	MaxMem       uint64       `json:"max_mem" yaml:"max_mem"`
+	Uptime       uint64       `json:"uptime" yaml:"uptime"`
+	Subscription string       `json:"subscription" yaml:"subscription"`
+	Bridges      []nodeBridge `json:"bridges" yaml:"bridges"`
+}
+
+// nodeSubscription is /nodes/{node}/subscription, which go-proxmox does not
+// model.
+type nodeSubscription struct {
+	Status      string `json:"status"`
+	ProductName string `json:"productname"`
+}
+
+// getNodeDetails fetches the details of node name.
+func getNodeDetails(ctx context.Context, sess *session, name string) (*nodeDetails, error) {
+	node, err := sess.Node(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("getting node %s gave err: %w", name, dttproxmox.WrapError(err))
+	}
+	details := &nodeDetails{
+		Name:       node.Name,
+		CPUModel:   node.CPUInfo.Model,
+		CPUs:       node.CPUInfo.CPUs,
+		Sockets:    node.CPUInfo.Sockets,
+		Kernel:     node.Kversion,
+		PVEVersion: node.PVEVersion,
+		Mem:        node.Memory.Used,
+		MaxMem:     node.Memory.Total,
+		Uptime:     node.Uptime,
+		Bridges:    []nodeBridge{},
+	}
+
+	// Reading the subscription takes Sys.Audit, which the token may lack.
+	var subscription nodeSubscription
+	if err := sess.pac.Get(ctx, fmt.Sprintf("/nodes/%s/subscription", name), &subscription); err != nil {
+		slog.Warn("could not read the subscription of the node", "node", name, "err", dttproxmox.WrapError(err))
+		details.Subscription = "unknown"
+	} else {
+		details.Subscription = subscription.Status
+		if subscription.ProductName != "" {
+			details.Subscription += " (" + subscription.ProductName + ")"
+		}
+	}
+
+	networks, err := node.Networks(ctx, "any_bridge")
+	if err != nil {
+		return nil, fmt.Errorf("listing bridges on node %s gave err: %w", name, dttproxmox.WrapError(err))
+	}
+	for _, n := range networks {
+		ports := n.BridgePorts
+		if ports == "" {
+			ports = n.OVSPorts
+		}
+		details.Bridges = append(details.Bridges, nodeBridge{Name: n.Iface, CIDR: n.CIDR, Ports: ports, Active: n.Active == 1})
+	}
+	slices.SortFunc(details.Bridges, func(a, b nodeBridge) int { return strings.Compare(a.Name, b.Name) })
+	return details, nil
+}
+
+// missingBridges returns the bridges of want that the node does not have.
+func (d *nodeDetails) missingBridges(want []string) []string {
+	var missing []string
+	for _, bridge := range want {
+		if !slices.ContainsFunc(d.Bridges, func(b nodeBridge) bool { return b.Name == bridge }) {
+			missing = append(missing, bridge)
+		}
+	}
+	return missing
+}
+
+func command_node_get(cmd *cobra.Command, args []string) error {
+	details, err := getNodeDetails(context.Background(), getSession(), args[0])
+	if err != nil {
+		return err
+	}
+	if missing := details.missingBridges(*FlagNodeGetBridges); len(missing) > 0 {
+		return fmt.Errorf("node %s has no bridge %s", details.Name, strings.Join(missing, ", "))
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagNodeGetOutput, details, func(w io.Writer) error {
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "Node:\t%s\n", details.Name)
+		fmt.Fprintf(writer, "CPU:\t%s, %d CPUs in %d sockets\n", details.CPUModel, details.CPUs, details.Sockets)
+		fmt.Fprintf(writer, "Memory:\t%s/%s (%s)\n", formatBytes(details.Mem), formatBytes(details.MaxMem), formatPercent(details.Mem, details.MaxMem))
+		fmt.Fprintf(writer, "Kernel:\t%s\n", details.Kernel)
+		fmt.Fprintf(writer, "Proxmox VE:\t%s\n", details.PVEVersion)
+		fmt.Fprintf(writer, "Subscription:\t%s\n", details.Subscription)
+		fmt.Fprintf(writer, "Uptime:\t%s\n", formatUptime(details.Uptime))
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("flushing node writer gave err: %w", err)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Bridges")
+		writer = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tCIDR\tPORTS\tACTIVE")
+		for _, b := range details.Bridges {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%t\n", b.Name, b.CIDR, b.Ports, b.Active)
+		}
+		return writer.Flush()
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list the nodes of the cluster with their status and load",
+		Args:  cobra.NoArgs,
+		RunE:  command_node_list,
+	}
+
+	FlagNodeListOutput *string
+)
+
+func init() {
+	FlagNodeListOutput = addOutputFlag(nodeListCommand)
+	nodeCommand.AddCommand(nodeListCommand)
+}
+
+func command_node_list(cmd *cobra.Command, args []string) error {
+	nodes, err := getSession().pac.Nodes(context.Background())
+	if err != nil {
+		return fmt.Errorf("getting nodes gave err: %w", err)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+
+	rows := nodeRows(nodes)
+	return writeOutput(cmd.OutOrStdout(), *FlagNodeListOutput, rows, func(w io.Writer) error {
+		return writeNodeRows(w, rows)
+	})
+}
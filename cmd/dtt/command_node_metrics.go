@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeMetricsCommand = &cobra.Command{
+		Use:   "metrics <node>",
+		Short: "show load, iowait, memory and swap history for a node",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_node_metrics,
+	}
+
+	FlagNodeMetricsTimeframe *string
+	FlagNodeMetricsCF        *string
+	FlagNodeMetricsChart     *bool
+)
+
+func init() {
+	nodeCommand.AddCommand(nodeMetricsCommand)
+
+	FlagNodeMetricsTimeframe = nodeMetricsCommand.PersistentFlags().String("timeframe", "hour", "history to show: hour, day, week, month or year")
+	FlagNodeMetricsCF = nodeMetricsCommand.PersistentFlags().String("cf", "AVERAGE", "consolidation function: AVERAGE or MAX")
+	FlagNodeMetricsChart = nodeMetricsCommand.PersistentFlags().Bool("chart", false, "render sparkline charts instead of a table")
+}
+
+// nodeRRDData is a single sample of /nodes/{node}/rrddata. go-proxmox only
+// models the guest variant of rrddata, so the host fields are declared here.
+type nodeRRDData struct {
+	Time      uint64  `json:"time"`
+	CPU       float64 `json:"cpu"`
+	MaxCPU    float64 `json:"maxcpu"`
+	LoadAvg   float64 `json:"loadavg"`
+	IOWait    float64 `json:"iowait"`
+	MemUsed   float64 `json:"memused"`
+	MemTotal  float64 `json:"memtotal"`
+	SwapUsed  float64 `json:"swapused"`
+	SwapTotal float64 `json:"swaptotal"`
+	RootUsed  float64 `json:"rootused"`
+	RootTotal float64 `json:"roottotal"`
+	NetIn     float64 `json:"netin"`
+	NetOut    float64 `json:"netout"`
+}
+
+func command_node_metrics(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	timeframe, cf, err := parseRRDFlags(*FlagNodeMetricsTimeframe, *FlagNodeMetricsCF)
+	if err != nil {
+		return err
+	}
+
+	nodeName := args[0]
+	pac := getSession().pac
+
+	rrd, err := getNodeRRDData(ctx, pac, nodeName, timeframe, cf)
+	if err != nil {
+		return fmt.Errorf("getting rrddata for node %s gave err: %w", nodeName, err)
+	}
+	if len(rrd) == 0 {
+		fmt.Printf("no metrics recorded for node %s in the last %s\n", nodeName, timeframe)
+		return nil
+	}
+
+	fmt.Printf("Node %s, last %s (%s)\n\n", nodeName, timeframe, cf)
+
+	if *FlagNodeMetricsChart {
+		return writeNodeMetricsChart(os.Stdout, rrd)
+	}
+	return writeNodeMetricsTable(os.Stdout, rrd)
+}
+
+func getNodeRRDData(ctx context.Context, pac dttproxmox.ProxmoxAPI, node string, timeframe px.Timeframe, cf px.ConsolidationFunction) ([]*nodeRRDData, error) {
+	params := url.Values{}
+	params.Add("timeframe", string(timeframe))
+	params.Add("cf", string(cf))
+	u := url.URL{Path: fmt.Sprintf("/nodes/%s/rrddata", node), RawQuery: params.Encode()}
+
+	var rrd []*nodeRRDData
+	if err := pac.Get(ctx, u.String(), &rrd); err != nil {
+		return nil, err
+	}
+	return rrd, nil
+}
+
+func writeNodeMetricsTable(w io.Writer, rrd []*nodeRRDData) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tCPU\tLOAD\tIOWAIT\tMEM\tSWAP\tNET IN\tNET OUT")
+	for _, r := range rrd {
+		fmt.Fprintf(
+			writer,
+			"%s\t%.1f%%\t%.2f\t%.1f%%\t%s/%s (%s)\t%s/%s (%s)\t%s\t%s\n",
+			formatRRDTime(r.Time),
+			r.CPU*100.0,
+			r.LoadAvg,
+			r.IOWait*100.0,
+			formatBytes(uint64(r.MemUsed)),
+			formatBytes(uint64(r.MemTotal)),
+			formatPercent(uint64(r.MemUsed), uint64(r.MemTotal)),
+			formatBytes(uint64(r.SwapUsed)),
+			formatBytes(uint64(r.SwapTotal)),
+			formatPercent(uint64(r.SwapUsed), uint64(r.SwapTotal)),
+			formatRate(r.NetIn),
+			formatRate(r.NetOut),
+		)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flushing node metrics writer gave err: %w", err)
+	}
+	return nil
+}
+
+func writeNodeMetricsChart(w io.Writer, rrd []*nodeRRDData) error {
+	percent := func(v float64) string { return fmt.Sprintf("%.1f%%", v) }
+	bytes := func(v float64) string { return formatBytes(uint64(v)) }
+
+	series := []struct {
+		name   string
+		value  func(r *nodeRRDData) float64
+		format func(v float64) string
+	}{
+		{"cpu", func(r *nodeRRDData) float64 { return r.CPU * 100.0 }, percent},
+		{"load", func(r *nodeRRDData) float64 { return r.LoadAvg }, func(v float64) string { return fmt.Sprintf("%.2f", v) }},
+		{"iowait", func(r *nodeRRDData) float64 { return r.IOWait * 100.0 }, percent},
+		{"mem", func(r *nodeRRDData) float64 { return r.MemUsed }, bytes},
+		{"swap", func(r *nodeRRDData) float64 { return r.SwapUsed }, bytes},
+		{"net in", func(r *nodeRRDData) float64 { return r.NetIn }, formatRate},
+		{"net out", func(r *nodeRRDData) float64 { return r.NetOut }, formatRate},
+	}
+
+	last := rrd[len(rrd)-1]
+	fmt.Fprintf(w, "%s .. %s (mem total %s, swap total %s)\n", formatRRDTime(rrd[0].Time), formatRRDTime(last.Time), formatBytes(uint64(last.MemTotal)), formatBytes(uint64(last.SwapTotal)))
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "METRIC\tHISTORY\tMIN\tMAX\tLAST")
+	for _, s := range series {
+		values := make([]float64, 0, len(rrd))
+		for _, r := range rrd {
+			values = append(values, s.value(r))
+		}
+		lo, hi := minMax(values)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", s.name, sparkline(values), s.format(lo), s.format(hi), s.format(values[len(values)-1]))
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flushing node metrics writer gave err: %w", err)
+	}
+	return nil
+}
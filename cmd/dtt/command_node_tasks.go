@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeTasksCommand = &cobra.Command{
+		Use:   "tasks <node>",
+		Short: "list the recent tasks of a node, newest first",
+		Long:  `List the recent tasks of a node, newest first, like dtt task list --node.`,
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_node_tasks,
+	}
+
+	FlagNodeTasksRunning *bool
+	FlagNodeTasksOutput  *string
+)
+
+func init() {
+	FlagNodeTasksRunning = nodeTasksCommand.PersistentFlags().Bool("running", false, "only list the tasks still running")
+	FlagNodeTasksOutput = addOutputFlag(nodeTasksCommand)
+	nodeCommand.AddCommand(nodeTasksCommand)
+}
+
+func command_node_tasks(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	sess := getSession()
+
+	// The cluster task list is filtered by node, so check the node exists
+	// rather than printing no tasks for a typo.
+	if _, err := sess.Node(ctx, args[0]); err != nil {
+		return fmt.Errorf("getting node %s gave err: %w", args[0], dttproxmox.WrapError(err))
+	}
+	rows, err := listTasks(ctx, sess, args[0], *FlagNodeTasksRunning)
+	if err != nil {
+		return err
+	}
+	return writeTaskRows(cmd.OutOrStdout(), *FlagNodeTasksOutput, rows)
+}
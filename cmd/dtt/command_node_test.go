@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestGetNodeDetails(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve").Bridges = []string{"vmbr1", "vmbr0"}
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	details, err := getNodeDetails(context.Background(), sess, "pve")
+	if err != nil {
+		t.Fatalf("getNodeDetails() gave err: %v", err)
+	}
+	if details.CPUs != 8 || !strings.HasPrefix(details.CPUModel, "Fake CPU") {
+		t.Errorf("Expected the 8 fake CPUs, got %q with %d", details.CPUModel, details.CPUs)
+	}
+	if !strings.HasPrefix(details.PVEVersion, "pve-manager/8.2.4") || !strings.HasPrefix(details.Kernel, "Linux 6.8") {
+		t.Errorf("Expected the versions of the node, got %q and kernel %q", details.PVEVersion, details.Kernel)
+	}
+	if details.Subscription != "notfound" {
+		t.Errorf("Expected no subscription, got %q", details.Subscription)
+	}
+	if len(details.Bridges) != 2 || details.Bridges[0].Name != "vmbr0" || !details.Bridges[0].Active {
+		t.Errorf("Expected the active bridges vmbr0 and vmbr1, got %+v", details.Bridges)
+	}
+
+	if missing := details.missingBridges([]string{"vmbr0", "vmbr2", "vmbr1", "vmbr3"}); !slices.Equal(missing, []string{"vmbr2", "vmbr3"}) {
+		t.Errorf("Expected vmbr2 and vmbr3 to be missing, got %q", missing)
+	}
+
+	if _, err := getNodeDetails(context.Background(), sess, "nope"); err == nil {
+		t.Error("Expected an error for a node that does not exist")
+	}
+}
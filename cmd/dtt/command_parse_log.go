@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	parseLogCommand = &cobra.Command{
+		Use:   "parse-log [file]",
+		Short: "parse a saved serial console log",
+		Long: `Parse serial console output, such as the file vm cloudinit --monitorfile
+writes, and print the hostname, IPs, host keys, authorized keys, cloud-init
+errors and boot timing found in it. Reads stdin when no file or "-" is given.`,
+		Args:        cobra.MaximumNArgs(1),
+		RunE:        command_parse_log,
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	FlagParseLogOutput *string
+)
+
+func init() {
+	FlagParseLogOutput = addOutputFlag(parseLogCommand)
+
+	rootCmd.AddCommand(parseLogCommand)
+}
+
+func command_parse_log(cmd *cobra.Command, args []string) error {
+	data, err := parseLogArg(args)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagParseLogOutput, data, func(out io.Writer) error {
+		writeCloudInitData(out, data)
+		writeCloudInitProblems(out, data)
+		return nil
+	})
+}
+
+// parseLogArg parses the log file named in args, or stdin when none or "-"
+// is given, for commands taking an optional [file] argument.
+func parseLogArg(args []string) (parseCloudInitLog.CloudInitData, error) {
+	var in io.Reader = os.Stdin
+	name := "stdin"
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return parseCloudInitLog.CloudInitData{}, err
+		}
+		defer f.Close()
+		in, name = f, args[0]
+	} else if isTerminal(os.Stdin) {
+		return parseCloudInitLog.CloudInitData{}, missingInput("give a log file, or pipe one to stdin")
+	}
+
+	data, err := parseCloudInitLog.ParseCloudInitStream(in, parseCloudInitLog.StreamCallbacks{})
+	if err != nil {
+		return data, fmt.Errorf("reading %s gave err: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/spf13/cobra"
+)
+
+func TestParseLogJSON(t *testing.T) {
+	saved := *FlagParseLogOutput
+	t.Cleanup(func() { *FlagParseLogOutput = saved })
+	*FlagParseLogOutput = "json"
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	if err := command_parse_log(cmd, []string{"../../parseCloudInitLog/testdata/dtt-debian-11-104-cloudinit.serial.txt"}); err != nil {
+		t.Fatalf("parse-log gave err: %v", err)
+	}
+
+	var data parseCloudInitLog.CloudInitData
+	if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+		t.Fatalf("Expected JSON, got %q: %v", out.String(), err)
+	}
+	if data.Hostname != "dtt-debian-11-104" || len(data.IPs) != 3 || data.Timing.Finished == 0 {
+		t.Errorf("Unexpected parse-log result %+v", data)
+	}
+
+	*FlagParseLogOutput = "xml"
+	if err := command_parse_log(cmd, []string{"../../parseCloudInitLog/testdata/dtt-debian-11-104-cloudinit.serial.txt"}); err == nil {
+		t.Error("Expected an unknown output format to fail")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	preflightCommand = &cobra.Command{
+		Use:   "preflight",
+		Short: "check that dtt vm cloudinit can create VMs on a node, without creating any",
+		Long: `Check what dtt vm cloudinit checks before it creates anything: that the node
+is there, that the storage takes import and images content and has room for
+the disks, that the bridges of --net exist and that the cluster has a free
+VM ID. Every problem is reported at once.
+
+  dtt preflight --node pve2 --storage local-zfs --net virtio,bridge=vmbr1 --count 5`,
+		Args: cobra.NoArgs,
+		RunE: command_preflight,
+	}
+
+	FlagPreflightNode     *string
+	FlagPreflightStorage  *string
+	FlagPreflightNetwork  *[]string
+	FlagPreflightMemory   *int
+	FlagPreflightCores    *int
+	FlagPreflightDiskSize *string
+	FlagPreflightCount    *int
+)
+
+func init() {
+	FlagPreflightNode = preflightCommand.PersistentFlags().String("node", "pve", "node to create the VMs on")
+	FlagPreflightStorage = preflightCommand.PersistentFlags().String("storage", "local", "storage for the imported disks and cloud-init drives")
+	FlagPreflightNetwork = preflightCommand.PersistentFlags().StringArray("net", []string{"virtio,bridge=vmbr0"}, "network device options, as vm cloudinit takes them")
+	FlagPreflightMemory = preflightCommand.PersistentFlags().Int("memory", 2048, "memory in MB")
+	FlagPreflightCores = preflightCommand.PersistentFlags().Int("cores", 2, "number of CPU cores")
+	FlagPreflightDiskSize = preflightCommand.PersistentFlags().String("disk-size", "+10G", "boot disk size or growth, e.g. 32G or +10G")
+	FlagPreflightCount = preflightCommand.PersistentFlags().Int("count", 1, "number of VMs to make room for")
+	rootCmd.AddCommand(preflightCommand)
+}
+
+// preflightSpec is what vm cloudinit is about to create.
+type preflightSpec struct {
+	Node     string
+	Storage  string
+	Networks []string
+	Names    []string
+	Memory   int
+	Cores    int
+	DiskSize string
+	Count    int
+}
+
+// preflightResult is what preflight learned about the cluster.
+type preflightResult struct {
+	// NeedBytes is the least room the disks take on the storage.
+	NeedBytes uint64
+	NextVMID  int
+}
+
+// preflight checks spec, and that the node, storage, bridges, disk space and
+// a VM ID it needs are there, before anything is created. It reports every
+// problem at once.
+func preflight(ctx context.Context, sess *session, spec preflightSpec) (preflightResult, error) {
+	var v dttproxmox.Validation
+	var result preflightResult
+	for _, name := range spec.Names {
+		v.Name(name)
+	}
+	v.Memory(spec.Memory)
+	v.Cores(spec.Cores)
+	v.DiskSize(spec.DiskSize)
+	for _, netdev := range spec.Networks {
+		v.Network(netdev)
+	}
+	// A growth like +10G is on top of the image, so this is a lower bound.
+	if size, err := dttproxmox.DiskSizeBytes(spec.DiskSize); err == nil {
+		result.NeedBytes = size * uint64(max(spec.Count, 1))
+	}
+
+	node, err := sess.Node(ctx, spec.Node)
+	if err != nil {
+		v.Addf("node %q is not available: %v", spec.Node, err)
+	} else {
+		v.Storage(ctx, node, spec.Storage, "import", "images")
+		v.FreeSpace(ctx, node, spec.Storage, result.NeedBytes)
+		v.Bridges(ctx, node, spec.Networks...)
+	}
+	result.NextVMID = v.NextVMID(ctx, sess.pac)
+	return result, v.Err()
+}
+
+func command_preflight(cmd *cobra.Command, args []string) error {
+	if *FlagPreflightCount < 1 {
+		return fmt.Errorf("%w: --count must be at least 1, got %d", ErrUsage, *FlagPreflightCount)
+	}
+	spec := preflightSpec{
+		Node:     *FlagPreflightNode,
+		Storage:  *FlagPreflightStorage,
+		Networks: *FlagPreflightNetwork,
+		Memory:   *FlagPreflightMemory,
+		Cores:    *FlagPreflightCores,
+		DiskSize: *FlagPreflightDiskSize,
+		Count:    *FlagPreflightCount,
+	}
+	result, err := preflight(context.Background(), getSession(), spec)
+	if err != nil {
+		return err
+	}
+
+	var bridges []string
+	for _, netdev := range spec.Networks {
+		bridges = append(bridges, dttproxmox.NetworkBridge(netdev))
+	}
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "node %s: ok\n", spec.Node)
+	fmt.Fprintf(w, "storage %s: takes import and images content, has room for %s of disks\n", spec.Storage, formatBytes(result.NeedBytes))
+	if len(bridges) > 0 {
+		fmt.Fprintf(w, "bridges: %s\n", strings.Join(bridges, ", "))
+	}
+	fmt.Fprintf(w, "next VM ID: %d\n", result.NextVMID)
+	return nil
+}
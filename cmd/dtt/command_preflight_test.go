@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestPreflight(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve").StorageContent["local-lvm"] = "images,rootdir"
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 104, Name: "web"})
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	spec := preflightSpec{Node: "pve", Storage: "local", Networks: []string{"virtio,bridge=vmbr0"}, Memory: 2048, Cores: 2, DiskSize: "+10G", Count: 3}
+	result, err := preflight(context.Background(), sess, spec)
+	if err != nil {
+		t.Fatalf("preflight() gave err: %v", err)
+	}
+	if result.NeedBytes != 30<<30 || result.NextVMID != 105 {
+		t.Errorf("Expected room for 30 GiB and VM ID 105, got %+v", result)
+	}
+
+	spec.Storage, spec.Networks, spec.DiskSize, spec.Count = "local-lvm", []string{"virtio,bridge=vmbr7"}, "60G", 2
+	spec.Names = []string{"bad_name"}
+	_, err = preflight(context.Background(), sess, spec)
+	var verr *dttproxmox.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("preflight() = %v, want a ValidationError", err)
+	}
+	want := []string{"not a valid hostname", `does not allow import`, "need at least 120.0 GiB", `bridge "vmbr7" does not exist`}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("preflight() found %q, want %d problems", verr.Problems, len(want))
+	}
+	for i, w := range want {
+		if !strings.Contains(verr.Problems[i], w) {
+			t.Errorf("problem %d = %q, want it to mention %q", i, verr.Problems[i], w)
+		}
+	}
+
+	spec = preflightSpec{Node: "nope", Storage: "local", Memory: 2048, Cores: 2, DiskSize: "+10G", Count: 1}
+	if _, err := preflight(context.Background(), sess, spec); err == nil || !strings.Contains(err.Error(), `node "nope" is not available`) {
+		t.Errorf("Expected the missing node to be reported, got %v", err)
+	}
+}
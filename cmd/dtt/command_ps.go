@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	psCommand = &cobra.Command{
+		Use:   "ps",
+		Short: "list the vms dtt created, with their guest IPs",
+		Long: `List the VMs dtt created. dtt tags the VMs it creates with "dtt" and
+records the image, purpose, cloud-init user, creator, creation time and,
+with --ttl, expiry time in the VM description, this lists the tagged VMs
+with those details. Guest IPs come from the qemu guest agent of running VMs.`,
+		Args: cobra.NoArgs,
+		RunE: command_ps,
+	}
+
+	FlagPsNode   *string
+	FlagPsOutput *string
+)
+
+func init() {
+	FlagPsNode = psCommand.PersistentFlags().String("node", "", "only list VMs on this node")
+	FlagPsOutput = addOutputFlag(psCommand)
+
+	rootCmd.AddCommand(psCommand)
+}
+
+// managedVM is a VM dtt created.
+type managedVM struct {
+	Node       string                `json:"node" yaml:"node"`
+	VMID       uint64                `json:"vmid" yaml:"vmid"`
+	Name       string                `json:"name" yaml:"name"`
+	Status     string                `json:"status" yaml:"status"`
+	Tags       []string              `json:"tags" yaml:"tags"`
+	Pool       string                `json:"pool,omitempty" yaml:"pool,omitempty"`
+	IP         string                `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Provenance dttproxmox.Provenance `json:"provenance" yaml:"provenance"`
+}
+
+// listManagedVMs returns the VMs tagged with dttproxmox.ManagedTag, on node
+// unless it is empty, sorted by node and VMID.
+func listManagedVMs(ctx context.Context, sess *session, node string) ([]managedVM, error) {
+	resources, err := sess.Resources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vms := []managedVM{}
+	for _, r := range resources {
+		if r.Type != "qemu" || r.Template == 1 || !dttproxmox.HasTag(r.Tags, dttproxmox.ManagedTag) {
+			continue
+		}
+		if node != "" && r.Node != node {
+			continue
+		}
+		vms = append(vms, managedVM{
+			Node:   r.Node,
+			VMID:   r.VMID,
+			Name:   r.Name,
+			Status: r.Status,
+			Tags:   dttproxmox.ResourceTags(r.Tags),
+			Pool:   r.Pool,
+		})
+	}
+
+	// Guest agents that don't answer take a while, ask them all at once.
+	var wg sync.WaitGroup
+	for i := range vms {
+		wg.Add(1)
+		go func(m *managedVM) {
+			defer wg.Done()
+			n, err := sess.Node(ctx, m.Node)
+			if err != nil {
+				return
+			}
+			vm, err := sess.VM(ctx, n, int(m.VMID))
+			if err != nil {
+				return
+			}
+			if vm.VirtualMachineConfig != nil {
+				m.Provenance, _ = dttproxmox.ParseProvenance(vm.VirtualMachineConfig.Description)
+			}
+			if m.Status == "running" {
+				agentCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				m.IP, _ = GetIPFor(agentCtx, vm, 1, 0)
+			}
+		}(&vms[i])
+	}
+	wg.Wait()
+
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].Node == vms[j].Node {
+			return vms[i].VMID < vms[j].VMID
+		}
+		return vms[i].Node < vms[j].Node
+	})
+	return vms, nil
+}
+
+func command_ps(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	vms, err := listManagedVMs(ctx, getSession(), *FlagPsNode)
+	if err != nil {
+		return fmt.Errorf("listing dtt VMs gave err: %w", err)
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagPsOutput, vms, func(out io.Writer) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tVMID\tNAME\tSTATUS\tIP\tIMAGE\tPURPOSE\tUSER\tCREATED\tEXPIRES")
+		for _, vm := range vms {
+			p := vm.Provenance
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				vm.Node, vm.VMID, vm.Name, vm.Status,
+				orDash(vm.IP), orDash(path.Base(p.Image)), orDash(p.Purpose), orDash(p.User),
+				formatPsTime(p.Created), formatPsTime(p.Expires))
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("flushing VM list writer gave err: %w", err)
+		}
+		return nil
+	})
+}
+
+func formatPsTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func orDash(s string) string {
+	if s == "" || s == "." {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestListManagedVMs(t *testing.T) {
+	provenance := dttproxmox.NewProvenance("v1", "https://example.com/noble.img", "run hello", "dtt").String()
+
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "dtt-101", Status: "stopped", Tags: "dtt",
+		Config: map[string]interface{}{"description": "notes\n\n" + provenance}})
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 100, Name: "web", Status: "stopped", Tags: "prod;dtt"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "db", Status: "running", Tags: "prod"})
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	vms, err := listManagedVMs(context.Background(), sess, "")
+	if err != nil {
+		t.Fatalf("listManagedVMs() gave err: %v", err)
+	}
+	if len(vms) != 2 || vms[0].VMID != 101 || vms[1].VMID != 100 {
+		t.Fatalf("Expected the dtt VMs 101 and 100, got %+v", vms)
+	}
+	if p := vms[0].Provenance; p.Purpose != "run hello" || p.User != "dtt" || p.Image != "https://example.com/noble.img" {
+		t.Errorf("Expected the provenance of VM 101, got %+v", p)
+	}
+
+	vms, err = listManagedVMs(context.Background(), sess, "pve2")
+	if err != nil || len(vms) != 1 || vms[0].Name != "web" {
+		t.Errorf("Expected only web on pve2, got %+v, %v", vms, err)
+	}
+}
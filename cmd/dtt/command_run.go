@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/binary"
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runCommand = &cobra.Command{
+		Use:   "run <binary|dir|tarball> [vm-id]",
+		Short: "run a Linux binary on a new Proxmox VM",
+		Long: `Run a Linux binary on a Proxmox VM. The VM is created from a cloud image
+with cloud-init, the binary is uploaded over SSH and executed.
+
+A directory or .tar.gz is run too, for programs with configuration files,
+assets or shared libraries. It is extracted into --remote-path, /tmp/dtt-run
+unless given, and --entrypoint, relative to its top, is run there:
+
+  dtt run --rm --entrypoint bin/server ./dist
+  dtt run --rm --entrypoint ./server release.tar.gz
+
+With --go, a Go package is cross-compiled for the architecture of the image,
+without cgo, and run instead of a binary:
+
+  dtt run --rm --go ./cmd/foo
+  dtt run --go . 105
+
+With --bundle manifest.yaml, no binary is given. The files the manifest lists
+are laid out on the VM with their owners and modes instead, checked, and its
+run command is executed.
+
+--collect downloads the files the binary left on the VM, such as reports,
+logs or core dumps, before the VM is removed, also when it failed:
+
+  dtt run --rm --collect '/tmp/report-*.xml:./reports' ./mytool
+
+--arg, --env and --workdir set the arguments, environment and working
+directory of the binary, quoted for the shell on the VM, and --stdin-file
+feeds it a local file on its standard input:
+
+  dtt run --rm --arg=--verbose --arg 'two words' --env MODE=fast \
+    --workdir /tmp --stdin-file input.json ./mytool
+
+Before creating the VM, dtt checks that an ELF binary is built for the
+architecture of the image and needs no newer glibc than it has, and once the
+binary is uploaded that ldd finds its shared libraries.
+--skip-binary-check runs it anyway.
+
+--on runs the binary on existing VMs instead, selected by tag=, name=, glob=,
+re= or id=, or a VM name or id, --parallel at a time, and prints the output
+of each with a table of how it went, or a report with --output json:
+
+  dtt run --on tag=web --parallel 8 ./mytool
+  dtt run --on id=101,102 -o json ./mytool
+
+dtt exits with the exit status of the binary, or of the run command of the
+bundle. With --rm the VM is deleted once it exited, also when something
+failed, for one-off runs:
+
+  dtt run --rm ./mytool
+
+Without a vm-id the next free VMID of the cluster is used.`,
+		Args: cobra.RangeArgs(0, 2),
+		RunE: command_run,
+	}
+
+	FlagRunNode         *string
+	FlagRunImageStorage *string
+	FlagRunDiskStorage  *string
+	FlagRunHostname     *string
+	FlagRunImage        *string
+	FlagRunMemory       *int
+	FlagRunCPU          *int
+	FlagRunCores        *int
+	FlagRunUsername     *string
+	FlagRunSSHPassword  *string
+	FlagRunRemotePath   *string
+	FlagRunVMIP         *string
+	FlagRunTTL          *time.Duration
+	FlagRunBundle       *string
+	FlagRunRm           *bool
+	FlagRunCollect      *[]string
+	FlagRunSSHImport    *bool
+	FlagRunNodeSSHUser  *string
+	FlagRunNodeSSHKey   *string
+	FlagRunVia          *string
+	FlagRunGenerateKey  *bool
+	FlagRunArgs         *[]string
+	FlagRunEnv          *[]string
+	FlagRunWorkdir      *string
+	FlagRunStdinFile    *string
+	FlagRunEntrypoint   *string
+	FlagRunSkipCheck    *bool
+	FlagRunGo           *string
+	FlagRunOn           *[]string
+	FlagRunParallel     *int
+	FlagRunOutput       *string
+)
+
+func init() {
+	FlagRunNode = runCommand.PersistentFlags().String("node", "pve", "which node to create the vm on, or with --on to limit VM lookup to")
+	FlagRunImageStorage = runCommand.PersistentFlags().String("image-storage", "local", "storage for cloud images (needs import content) and the cloud-init drive")
+	FlagRunDiskStorage = runCommand.PersistentFlags().String("disk-storage", "local-lvm", "storage for VM disks")
+	FlagRunHostname = runCommand.PersistentFlags().String("hostname", "dtt-vm", "VM hostname")
+	FlagRunImage = runCommand.PersistentFlags().String("image", dtt.DefaultImage, "image to use (debian-11, debian-13, ubuntu-24.04)")
+	FlagRunMemory = runCommand.PersistentFlags().Int("memory", 512, "memory in MB")
+	FlagRunCPU = runCommand.PersistentFlags().Int("cpu", 1, "number of CPU sockets")
+	FlagRunCores = runCommand.PersistentFlags().Int("cores", 1, "cores per CPU socket")
+	FlagRunUsername = runCommand.PersistentFlags().String("username", "dtt", "cloud-init username")
+	FlagRunSSHPassword = runCommand.PersistentFlags().String("ssh-password", "", "cloud-init and SSH password (or set DTT_SSH_PASSWORD, default: dtt)")
+	FlagRunRemotePath = runCommand.PersistentFlags().String("remote-path", "/tmp/binary", "path to place the binary on the VM, or to extract a directory or tarball into, /tmp/dtt-run for those unless set")
+	FlagRunVMIP = runCommand.PersistentFlags().String("vm-ip", "", "VM IP address for the SSH connection (default: ask the qemu agent)")
+	FlagRunTTL = runCommand.PersistentFlags().Duration("ttl", 0, "delete the VM with dtt gc once it is this old, e.g. 2h (default: keep)")
+	FlagRunBundle = runCommand.PersistentFlags().String("bundle", "", "YAML manifest of the files to lay out on the VM, instead of a binary")
+	FlagRunRm = runCommand.PersistentFlags().Bool("rm", false, "delete the VM once the binary exited, also when something failed")
+	FlagRunSSHImport = runCommand.PersistentFlags().Bool("import-over-ssh", false, "download and import the cloud image with qm over SSH to the Proxmox host, for image storages without import content")
+	FlagRunNodeSSHUser = runCommand.PersistentFlags().String("node-ssh-user", "root", "SSH user on the Proxmox host for --import-over-ssh")
+	FlagRunNodeSSHKey = runCommand.PersistentFlags().String("node-ssh-private-key", "", "SSH private key file for the Proxmox host, instead of DTT_NODE_SSH_PASSWORD or ssh-agent")
+	FlagRunVia = runCommand.PersistentFlags().String("via", dtt.ViaSSH, "how to upload and run the binary: ssh, or agent for the qemu guest agent, for VMs without a network path to here")
+	FlagRunGenerateKey = runCommand.PersistentFlags().Bool("generate-sshkey", false, "log in to the VM with a new ed25519 key pair, kept in ~/.local/share/dtt/keys/<vmid> for dtt vm ssh unless --rm")
+	FlagRunArgs = runCommand.PersistentFlags().StringArray("arg", nil, "argument to run the binary with (repeatable, in order)")
+	FlagRunEnv = runCommand.PersistentFlags().StringArray("env", nil, "environment variable to run the binary with, as KEY=VALUE (repeatable)")
+	FlagRunWorkdir = runCommand.PersistentFlags().String("workdir", "", "directory on the VM to run the binary in (default: the home directory of the user)")
+	FlagRunStdinFile = runCommand.PersistentFlags().String("stdin-file", "", "local file to upload and feed the binary on its standard input")
+	FlagRunEntrypoint = runCommand.PersistentFlags().String("entrypoint", "", "executable to run, relative to the top of the directory or tarball given instead of a binary")
+	FlagRunSkipCheck = runCommand.PersistentFlags().Bool("skip-binary-check", false, "run the binary even when its architecture, glibc version or shared libraries don't match the VM")
+	FlagRunGo = runCommand.PersistentFlags().String("go", "", "Go package to cross-compile for the VM and run, such as . or ./cmd/foo, instead of a binary")
+	FlagRunCollect = runCommand.PersistentFlags().StringArray("collect", nil, "download the files matching <remote-glob> to <local-dir> once the binary exited, as <remote-glob>:<local-dir> (repeatable)")
+
+	FlagRunOn = runCommand.PersistentFlags().StringArray("on", nil, "run on the existing VMs selected by tag=<tag>, name=<names>, glob=<pattern>, re=<regexp>, id=<ids> or a VM name or id, instead of a new VM (repeatable)")
+	FlagRunParallel = runCommand.PersistentFlags().Int("parallel", 4, "with --on, how many VMs to run the binary on at once, 0 for all")
+	FlagRunOutput = addOutputFlag(runCommand)
+
+	runCommand.MarkFlagsMutuallyExclusive("go", "bundle")
+	runCommand.MarkFlagsMutuallyExclusive("go", "entrypoint")
+
+	for _, name := range runOnExclusive {
+		runCommand.MarkFlagsMutuallyExclusive("on", name)
+	}
+
+	rootCmd.AddCommand(runCommand)
+}
+
+// parseCollect parses a --collect value, <remote-glob>:<local-dir>. It splits
+// at the first colon, the local directory may have one of its own.
+func parseCollect(spec string) (dtt.Collect, error) {
+	remote, local, ok := strings.Cut(spec, ":")
+	if !ok || remote == "" || local == "" {
+		return dtt.Collect{}, fmt.Errorf("%w: --collect %q is not <remote-glob>:<local-dir>", ErrUsage, spec)
+	}
+	return dtt.Collect{Remote: remote, Local: local}, nil
+}
+
+// isArchive reports whether path is a directory or tarball to run with
+// --entrypoint rather than a binary.
+func isArchive(path string) bool {
+	if binary.IsTarball(path) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func command_run(cmd *cobra.Command, args []string) error {
+	// An interrupt kills the binary, and with --rm still deletes the VM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch *FlagRunVia {
+	case dtt.ViaSSH, dtt.ViaAgent:
+	default:
+		return fmt.Errorf("%w: --via %q is not ssh or agent", ErrUsage, *FlagRunVia)
+	}
+	if *FlagRunBundle != "" && *FlagRunVia == dtt.ViaAgent {
+		return fmt.Errorf("%w: --bundle needs SSH, it can't be used with --via agent", ErrUsage)
+	}
+	if *FlagRunBundle != "" && (len(*FlagRunArgs) > 0 || len(*FlagRunEnv) > 0 || *FlagRunWorkdir != "" || *FlagRunStdinFile != "") {
+		return fmt.Errorf("%w: --arg, --env, --workdir and --stdin-file are for a binary, a bundle has its own run command", ErrUsage)
+	}
+	if len(*FlagRunOn) == 0 && (cmd.Flags().Changed("output") || cmd.Flags().Changed("parallel")) {
+		return fmt.Errorf("%w: --output and --parallel are for running on the VMs of --on", ErrUsage)
+	}
+	for _, env := range *FlagRunEnv {
+		if key, _, ok := strings.Cut(env, "="); !ok || key == "" {
+			return fmt.Errorf("%w: --env %q is not KEY=VALUE", ErrUsage, env)
+		}
+	}
+
+	var bundle *binary.Bundle
+	var binaryPath string
+	if *FlagRunBundle != "" {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: --bundle takes the place of the binary, only a vm-id can be given", ErrUsage)
+		}
+		var err error
+		if bundle, err = binary.LoadBundle(*FlagRunBundle); err != nil {
+			return fmt.Errorf("failed to validate bundle: %w", err)
+		}
+		for _, f := range bundle.Files {
+			slog.Info("bundle file", "source", f.Source, "destination", f.Destination)
+		}
+	} else if *FlagRunGo != "" {
+		if len(args) > 1 {
+			return fmt.Errorf("%w: --go takes the place of the binary, only a vm-id can be given", ErrUsage)
+		}
+		image, err := dtt.ResolveImage(*FlagRunImage)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUsage, err)
+		}
+		goarch := image.Arch
+		if goarch == "" {
+			goarch = "amd64"
+		}
+		slog.Info("building", "package", *FlagRunGo, "goos", "linux", "goarch", goarch)
+		var cleanup func()
+		if binaryPath, cleanup, err = buildGo(ctx, *FlagRunGo, goarch, os.Stderr); err != nil {
+			return err
+		}
+		defer cleanup()
+	} else {
+		if len(args) == 0 {
+			return missingInput("give a binary to run, --go or --bundle")
+		}
+		binaryPath, args = args[0], args[1:]
+		if len(*FlagRunOn) > 0 && isArchive(binaryPath) {
+			return fmt.Errorf("%w: --on runs a binary, not a directory or tarball", ErrUsage)
+		}
+		if isArchive(binaryPath) {
+			if *FlagRunEntrypoint == "" {
+				return fmt.Errorf("%w: give the --entrypoint to run in %s", ErrUsage, binaryPath)
+			}
+			if *FlagRunVia == dtt.ViaAgent {
+				return fmt.Errorf("%w: running a directory or tarball needs SSH, it can't be used with --via agent", ErrUsage)
+			}
+			if err := binary.CheckEntrypoint(binaryPath, *FlagRunEntrypoint); err != nil {
+				return fmt.Errorf("failed to validate %s: %w", binaryPath, err)
+			}
+			slog.Info("program", "path", binaryPath, "entrypoint", *FlagRunEntrypoint)
+		} else {
+			if *FlagRunEntrypoint != "" {
+				return fmt.Errorf("%w: --entrypoint is for a directory or tarball, %s is neither", ErrUsage, binaryPath)
+			}
+			binInfo, err := binary.GetBinaryInfo(binaryPath)
+			if err != nil {
+				return fmt.Errorf("failed to validate binary: %w", err)
+			}
+			slog.Info("binary", "name", binInfo.Name, "bytes", binInfo.Size, "sha256", binInfo.SHA256Hash)
+			if elfInfo, err := binary.InspectELF(binaryPath); err == nil {
+				slog.Info("elf", "arch", elfInfo.Arch, "static", elfInfo.Static, "interpreter", elfInfo.Interpreter, "glibc", elfInfo.GLIBC, "libraries", strings.Join(elfInfo.Libraries, ","))
+			}
+		}
+	}
+
+	sshPassword := flagOrEnv(*FlagRunSSHPassword, "DTT_SSH_PASSWORD")
+
+	opts := dtt.RunOptions{
+		VMOptions: dtt.VMOptions{
+			Name:     *FlagRunHostname,
+			Image:    *FlagRunImage,
+			Memory:   *FlagRunMemory,
+			Sockets:  *FlagRunCPU,
+			Cores:    *FlagRunCores,
+			Username: *FlagRunUsername,
+			Password: sshPassword,
+			TTL:      *FlagRunTTL,
+		},
+		RemotePath: *FlagRunRemotePath,
+		IP:         *FlagRunVMIP,
+		Keep:       !*FlagRunRm,
+		Via:        *FlagRunVia,
+		Args:       *FlagRunArgs,
+		Env:        *FlagRunEnv,
+		Workdir:    *FlagRunWorkdir,
+		StdinFile:  *FlagRunStdinFile,
+
+		SkipBinaryCheck: *FlagRunSkipCheck,
+		// Show the output of the binary as it runs.
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if isArchive(binaryPath) && !cmd.Flags().Changed("remote-path") {
+		// Extract into dtt.DefaultRemoteDir rather than the binary's path.
+		opts.RemotePath = ""
+	}
+	for _, spec := range *FlagRunCollect {
+		c, err := parseCollect(spec)
+		if err != nil {
+			return err
+		}
+		opts.Collect = append(opts.Collect, c)
+	}
+	if len(*FlagRunOn) > 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: --on selects the VMs, no vm-id can be given", ErrUsage)
+		}
+		if !cmd.Flags().Changed("image") {
+			// Only check the binary against the image the VMs are said to run.
+			opts.Image = ""
+		}
+		return command_run_on(ctx, cmd, binaryPath, opts)
+	}
+	if len(args) > 0 {
+		var err error
+		if opts.VMID, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid vm-id %q: %w", args[0], err)
+		}
+	}
+
+	var generatedKey, generatedPublicKey string
+	if *FlagRunGenerateKey {
+		publicKey, privateKeyPath, cleanup, err := generateSSHKeyPair()
+		if err != nil {
+			return fmt.Errorf("generating SSH key pair: %w", err)
+		}
+		defer cleanup()
+		generatedKey, generatedPublicKey = privateKeyPath, publicKey
+		opts.SSHPublicKey = publicKey
+	}
+
+	sess := getSession()
+	config := dttproxmox.ClientConfig{
+		Node:         *FlagRunNode,
+		ImageStorage: *FlagRunImageStorage,
+		DiskStorage:  *FlagRunDiskStorage,
+		Progress:     progressOutput(),
+		TaskLog:      sess.taskLog,
+
+		VMSSHPrivateKey: generatedKey,
+	}
+	if *FlagRunSSHImport {
+		config.ImportOverSSH = true
+		config.Host = *FlagHost
+		config.SSHUser = *FlagRunNodeSSHUser
+		config.SSHPassword = os.Getenv("DTT_NODE_SSH_PASSWORD")
+		config.SSHPrivateKey = *FlagRunNodeSSHKey
+		knownHosts, err := expandHome("~/.ssh/known_hosts")
+		if err != nil {
+			return err
+		}
+		config.SSHHostKeyCallback = ssh.TrustOnFirstUse(knownHosts)
+	}
+	client := dtt.NewWithAPI(config, sess.pac)
+
+	var result *dtt.Result
+	var err error
+	if bundle != nil {
+		result, err = client.RunBundle(ctx, bundle, opts)
+	} else if isArchive(binaryPath) {
+		result, err = client.RunArchive(ctx, binaryPath, *FlagRunEntrypoint, opts)
+	} else {
+		result, err = client.Run(ctx, binaryPath, opts)
+	}
+	if result != nil && result.Output != "" {
+		fmt.Printf("Output:\n%s\n", result.Output)
+	}
+	if generatedKey != "" && !*FlagRunRm && result != nil && result.VM != nil {
+		if _, err := storeVMKey(result.VM.ID, generatedKey, generatedPublicKey); err != nil {
+			slog.Warn("keeping the SSH key of the VM failed", "vmid", result.VM.ID, "err", err)
+		}
+	}
+	if result != nil {
+		for _, f := range result.Collected {
+			fmt.Printf("Collected %s\n", f)
+		}
+	}
+	if errors.Is(err, dtt.ErrIncompatibleBinary) {
+		err = fmt.Errorf("%w, --skip-binary-check runs it anyway", err)
+	}
+	if err != nil {
+		if result != nil && result.ExitCode > 0 {
+			return &exitStatusError{err: err, code: result.ExitCode}
+		}
+		return err
+	}
+	what := "Binary executed"
+	if bundle != nil {
+		what = "Bundle laid out"
+	}
+	if *FlagRunRm {
+		fmt.Printf("%s successfully, removed VM %d\n", what, result.VM.ID)
+		return nil
+	}
+	fmt.Printf("%s successfully on VM %d (%s)\n", what, result.VM.ID, result.VM.IP)
+	return nil
+}
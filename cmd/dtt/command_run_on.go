@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+// runOnExclusive are the flags of dtt run for creating or removing the VM,
+// which --on doesn't do.
+var runOnExclusive = []string{"bundle", "entrypoint", "rm", "vm-ip", "hostname", "memory", "cpu", "cores", "ttl",
+	"image-storage", "disk-storage", "import-over-ssh", "node-ssh-user", "node-ssh-private-key", "generate-sshkey"}
+
+// onQueries turns --on selectors into VM queries, see dttproxmox.MatchVMs.
+// A selector is tag=<tag>, name=<names>, glob=<pattern>, re=<regexp> or
+// id=<ids>, with comma separated names and ids, or a VM query itself.
+func onQueries(selectors []string) ([]string, error) {
+	var queries []string
+	for _, sel := range selectors {
+		key, value, ok := strings.Cut(sel, "=")
+		if !ok {
+			queries = append(queries, sel)
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%w: --on %q selects nothing", ErrUsage, sel)
+		}
+		switch key {
+		case "tag", "glob", "re":
+			queries = append(queries, key+":"+value)
+		case "name":
+			for _, name := range strings.Split(value, ",") {
+				queries = append(queries, "name:"+name)
+			}
+		case "id":
+			for _, id := range strings.Split(value, ",") {
+				if _, err := strconv.Atoi(id); err != nil {
+					return nil, fmt.Errorf("%w: --on %q: %q is not a VM ID", ErrUsage, sel, id)
+				}
+				queries = append(queries, id)
+			}
+		default:
+			return nil, fmt.Errorf("%w: --on %q: unknown selector %q, want tag=, name=, glob=, re= or id=", ErrUsage, sel, key)
+		}
+	}
+	return queries, nil
+}
+
+// runOnRow is the outcome of dtt run --on on one VM.
+type runOnRow struct {
+	VMID      int      `json:"vmid" yaml:"vmid"`
+	Name      string   `json:"name" yaml:"name"`
+	Node      string   `json:"node" yaml:"node"`
+	ExitCode  int      `json:"exit_code" yaml:"exit_code"` // -1 when the binary didn't get to run
+	Duration  string   `json:"duration" yaml:"duration"`
+	Output    string   `json:"output" yaml:"output"`
+	Error     string   `json:"error,omitempty" yaml:"error,omitempty"`
+	Collected []string `json:"collected,omitempty" yaml:"collected,omitempty"`
+}
+
+// command_run_on runs the binary on the existing VMs --on selects, with
+// opts, and reports how it went on each of them.
+func command_run_on(ctx context.Context, cmd *cobra.Command, binaryPath string, opts dtt.RunOptions) error {
+	queries, err := onQueries(*FlagRunOn)
+	if err != nil {
+		return err
+	}
+	node := ""
+	if cmd.Flags().Changed("node") {
+		node = *FlagRunNode
+	}
+	sess := getSession()
+	targets, err := sess.ResolveVMs(ctx, queries, node)
+	if err != nil {
+		return err
+	}
+
+	// The bar of progressOutput is for one VM at a time.
+	var progress dttproxmox.ProgressFunc
+	if !*FlagQuiet {
+		progress = dttproxmox.LogProgress(slog.Default())
+	}
+	clients := map[string]*dtt.Client{}
+	vms := make([]*dtt.VM, 0, len(targets))
+	for _, target := range targets {
+		client := clients[target.Node]
+		if client == nil {
+			client = dtt.NewWithAPI(dttproxmox.ClientConfig{Node: target.Node, Progress: progress, TaskLog: sess.taskLog}, sess.pac)
+			clients[target.Node] = client
+		}
+		vms = append(vms, client.VM(int(target.VMID), target.Name, opts.Username, opts.Password))
+	}
+
+	results, err := dtt.RunBatch(ctx, vms, binaryPath, opts, *FlagRunParallel)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]runOnRow, len(results))
+	failed := 0
+	for i, r := range results {
+		rows[i] = runOnRow{
+			VMID:      r.VM.ID,
+			Name:      r.VM.Name,
+			Node:      targets[i].Node,
+			ExitCode:  r.ExitCode,
+			Duration:  r.Duration.Round(time.Millisecond).String(),
+			Output:    r.Output,
+			Collected: r.Collected,
+		}
+		if r.Err != nil {
+			rows[i].Error = r.Err.Error()
+			failed++
+		}
+	}
+	err = writeOutput(cmd.OutOrStdout(), *FlagRunOutput, rows, func(w io.Writer) error {
+		return writeRunOnRows(w, rows)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("the binary failed on %d of %d VMs", failed, len(rows))
+	}
+	return nil
+}
+
+// writeRunOnRows writes the output of every VM under a header, then a table
+// of how it went on each.
+func writeRunOnRows(w io.Writer, rows []runOnRow) error {
+	for _, row := range rows {
+		if row.Output == "" {
+			continue
+		}
+		fmt.Fprintf(w, "==> VM %d (%s) <==\n%s", row.VMID, row.Name, row.Output)
+		if !strings.HasSuffix(row.Output, "\n") {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w)
+	}
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "VMID\tNAME\tNODE\tEXIT\tDURATION\tERROR")
+	for _, row := range rows {
+		exit, msg := strconv.Itoa(row.ExitCode), "-"
+		if row.ExitCode < 0 {
+			exit = "-"
+		}
+		if row.Error != "" {
+			msg, _, _ = strings.Cut(row.Error, "\n")
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", row.VMID, row.Name, row.Node, exit, row.Duration, msg)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for _, f := range row.Collected {
+			fmt.Fprintf(w, "Collected %s\n", f)
+		}
+	}
+	return nil
+}
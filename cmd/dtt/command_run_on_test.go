@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnQueries(t *testing.T) {
+	got, err := onQueries([]string{"tag=prod", "glob=web-*", "name=db,cache", "id=101,102", "re=^ci-", "web-1", "tag:lab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"tag:prod", "glob:web-*", "name:db", "name:cache", "101", "102", "re:^ci-", "web-1", "tag:lab"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("onQueries() = %q, want %q", got, want)
+	}
+	for _, sel := range []string{"tag=", "id=101,web", "pool=prod"} {
+		if _, err := onQueries([]string{sel}); !errors.Is(err, ErrUsage) {
+			t.Errorf("onQueries(%q) = %v, want a usage error", sel, err)
+		}
+	}
+}
+
+func TestWriteRunOnRows(t *testing.T) {
+	rows := []runOnRow{
+		{VMID: 101, Name: "web-1", Node: "pve", ExitCode: 0, Duration: "1.5s", Output: "ok\n"},
+		{VMID: 102, Name: "web-2", Node: "pve2", ExitCode: -1, Duration: "0s", Error: "VM 102 did not become ready: timeout\nmore"},
+	}
+	var out bytes.Buffer
+	if err := writeRunOnRows(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"==> VM 101 (web-1) <==\nok\n",
+		"VMID  NAME   NODE  EXIT  DURATION  ERROR",
+		"101   web-1  pve   0     1.5s      -",
+		"102   web-2  pve2  -     0s        VM 102 did not become ready: timeout\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "web-2) <==") {
+		t.Error("Expected no output header for a VM without output")
+	}
+}
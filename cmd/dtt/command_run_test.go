@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+)
+
+func TestParseCollect(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want dtt.Collect
+	}{
+		{"/tmp/report-*.xml:./reports", dtt.Collect{Remote: "/tmp/report-*.xml", Local: "./reports"}},
+		{"/var/crash/core.*:C:\\dumps", dtt.Collect{Remote: "/var/crash/core.*", Local: "C:\\dumps"}},
+	} {
+		got, err := parseCollect(tc.spec)
+		if err != nil || got != tc.want {
+			t.Errorf("parseCollect(%q) = %+v, %v, want %+v", tc.spec, got, err, tc.want)
+		}
+	}
+	for _, spec := range []string{"/tmp/report.xml", ":./reports", "/tmp/report.xml:"} {
+		if _, err := parseCollect(spec); !errors.Is(err, ErrUsage) {
+			t.Errorf("Expected parseCollect(%q) to be invalid usage, got %v", spec, err)
+		}
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "mytool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		dir:                     true,
+		"release.tar.gz":        true,
+		"release.tgz":           true,
+		tool:                    false,
+		filepath.Join(dir, "x"): false,
+	} {
+		if got := isArchive(path); got != want {
+			t.Errorf("isArchive(%s) = %v, expected %v", path, got, want)
+		}
+	}
+}
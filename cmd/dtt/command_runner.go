@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runnerCreateCommand = &cobra.Command{
+		Use:   "create",
+		Short: "create vms running a GitHub Actions runner",
+		Long: `Create VMs and install and register the GitHub Actions runner on each, as a
+service. Get a registration token from the Actions runner settings of the
+repository or organization, or from the API:
+
+  gh api -X POST repos/<org>/<repo>/actions/runners/registration-token --jq .token
+
+The runners are named after their VM, <name-prefix>-<vmid>, and tagged
+dtt-runner. With --ephemeral a runner takes one job and unregisters, pair it
+with --ttl so dtt gc deletes the idle VM afterwards.`,
+		Args: cobra.NoArgs,
+		RunE: command_runner_create,
+	}
+
+	runnerRmCommand = &cobra.Command{
+		Use:   "rm [name-or-id...]",
+		Short: "unregister runners and delete their vms",
+		Long: `Unregister GitHub Actions runners and delete their VMs. Select the runner VMs
+by name or ID, or with --repo or --all. With a removal token (from the
+runner settings, or repos/<org>/<repo>/actions/runners/remove-token) the
+runners are unregistered first, otherwise they stay listed on GitHub as
+offline until removed there.`,
+		RunE: command_runner_rm,
+	}
+
+	FlagRunnerCreateRepo         *string
+	FlagRunnerCreateToken        *string
+	FlagRunnerCreateCount        *int
+	FlagRunnerCreateLabels       *[]string
+	FlagRunnerCreateEphemeral    *bool
+	FlagRunnerCreateVersion      *string
+	FlagRunnerCreateNamePrefix   *string
+	FlagRunnerCreateNode         *string
+	FlagRunnerCreateImageStorage *string
+	FlagRunnerCreateDiskStorage  *string
+	FlagRunnerCreateImage        *string
+	FlagRunnerCreateMemory       *int
+	FlagRunnerCreateCores        *int
+	FlagRunnerCreateDiskSize     *int
+	FlagRunnerCreateUsername     *string
+	FlagRunnerCreateSSHPassword  *string
+	FlagRunnerCreateTTL          *time.Duration
+
+	FlagRunnerRmRepo        *string
+	FlagRunnerRmAll         *bool
+	FlagRunnerRmToken       *string
+	FlagRunnerRmNode        *string
+	FlagRunnerRmUsername    *string
+	FlagRunnerRmSSHPassword *string
+)
+
+func init() {
+	FlagRunnerCreateRepo = runnerCreateCommand.PersistentFlags().String("repo", "", "repository (org/repo) or organization (org) to register the runners with")
+	FlagRunnerCreateToken = runnerCreateCommand.PersistentFlags().String("token", "", "runner registration token (or set DTT_GITHUB_RUNNER_TOKEN)")
+	FlagRunnerCreateCount = runnerCreateCommand.PersistentFlags().Int("count", 1, "how many runners to create")
+	FlagRunnerCreateLabels = runnerCreateCommand.PersistentFlags().StringSlice("labels", nil, "extra runner labels, next to self-hosted, linux and x64")
+	FlagRunnerCreateEphemeral = runnerCreateCommand.PersistentFlags().Bool("ephemeral", false, "let each runner take one job only")
+	FlagRunnerCreateVersion = runnerCreateCommand.PersistentFlags().String("version", dtt.DefaultRunnerVersion, "GitHub Actions runner release to install")
+	FlagRunnerCreateNamePrefix = runnerCreateCommand.PersistentFlags().String("name-prefix", "gh-runner", "VM and runner names are <name-prefix>-<vmid>")
+	FlagRunnerCreateNode = runnerCreateCommand.PersistentFlags().String("node", "pve", "which node to create the VMs on")
+	FlagRunnerCreateImageStorage = runnerCreateCommand.PersistentFlags().String("image-storage", "local", "storage for cloud images (needs import content) and the cloud-init drive")
+	FlagRunnerCreateDiskStorage = runnerCreateCommand.PersistentFlags().String("disk-storage", "local-lvm", "storage for VM disks")
+	FlagRunnerCreateImage = runnerCreateCommand.PersistentFlags().String("image", "ubuntu-24.04", "image to use")
+	FlagRunnerCreateMemory = runnerCreateCommand.PersistentFlags().Int("memory", 4096, "memory in MB")
+	FlagRunnerCreateCores = runnerCreateCommand.PersistentFlags().Int("cores", 2, "number of cores")
+	FlagRunnerCreateDiskSize = runnerCreateCommand.PersistentFlags().Int("disk-size", 32, "disk size in GB")
+	FlagRunnerCreateUsername = runnerCreateCommand.PersistentFlags().String("username", "dtt", "cloud-init user the runner runs as")
+	FlagRunnerCreateSSHPassword = runnerCreateCommand.PersistentFlags().String("ssh-password", "", "cloud-init and SSH password (or set DTT_SSH_PASSWORD, default: dtt)")
+	FlagRunnerCreateTTL = runnerCreateCommand.PersistentFlags().Duration("ttl", 0, "delete the VMs with dtt gc once they are this old, e.g. 8h (default: keep)")
+
+	FlagRunnerRmRepo = runnerRmCommand.PersistentFlags().String("repo", "", "remove all runners created for this repository or organization")
+	FlagRunnerRmAll = runnerRmCommand.PersistentFlags().Bool("all", false, "remove all runners dtt created")
+	FlagRunnerRmToken = runnerRmCommand.PersistentFlags().String("token", "", "runner removal token to unregister the runners (or set DTT_GITHUB_RUNNER_REMOVE_TOKEN)")
+	FlagRunnerRmNode = runnerRmCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagRunnerRmUsername = runnerRmCommand.PersistentFlags().String("username", "", "SSH user to unregister with (default: the cloud-init user of the VM)")
+	FlagRunnerRmSSHPassword = runnerRmCommand.PersistentFlags().String("ssh-password", "", "SSH password to unregister with (or set DTT_SSH_PASSWORD, default: dtt)")
+
+	runnerCommand.AddCommand(runnerCreateCommand)
+	runnerCommand.AddCommand(runnerRmCommand)
+}
+
+func command_runner_create(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	token := flagOrEnv(*FlagRunnerCreateToken, "DTT_GITHUB_RUNNER_TOKEN")
+	if *FlagRunnerCreateRepo == "" || token == "" {
+		return missingInput("--repo and --token (or DTT_GITHUB_RUNNER_TOKEN) are required")
+	}
+	if *FlagRunnerCreateCount < 1 {
+		return fmt.Errorf("%w: --count must be at least 1, got %d", ErrUsage, *FlagRunnerCreateCount)
+	}
+
+	sess := getSession()
+	client := dtt.NewWithAPI(dttproxmox.ClientConfig{
+		Node:         *FlagRunnerCreateNode,
+		ImageStorage: *FlagRunnerCreateImageStorage,
+		DiskStorage:  *FlagRunnerCreateDiskStorage,
+		Progress:     progressOutput(),
+		TaskLog:      sess.taskLog,
+	}, sess.pac)
+
+	// One at a time, the next free VMID only moves on once a VM exists.
+	var errs []error
+	for i := 0; i < *FlagRunnerCreateCount; i++ {
+		vm, err := client.CreateRunner(ctx, dtt.RunnerOptions{
+			VMOptions: dtt.VMOptions{
+				Image:    *FlagRunnerCreateImage,
+				Memory:   *FlagRunnerCreateMemory,
+				Cores:    *FlagRunnerCreateCores,
+				DiskSize: *FlagRunnerCreateDiskSize,
+				Username: *FlagRunnerCreateUsername,
+				Password: flagOrEnv(*FlagRunnerCreateSSHPassword, "DTT_SSH_PASSWORD"),
+				TTL:      *FlagRunnerCreateTTL,
+			},
+			Repo:       *FlagRunnerCreateRepo,
+			Token:      token,
+			Labels:     *FlagRunnerCreateLabels,
+			Ephemeral:  *FlagRunnerCreateEphemeral,
+			Version:    *FlagRunnerCreateVersion,
+			NamePrefix: *FlagRunnerCreateNamePrefix,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("runner %d of %d: %w", i+1, *FlagRunnerCreateCount, err))
+			continue
+		}
+		fmt.Printf("runner %s registered with %s (vm %d, %s)\n", vm.Name, *FlagRunnerCreateRepo, vm.ID, vm.IP)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("creating runners failed for %d of %d:\n%w", len(errs), *FlagRunnerCreateCount, errors.Join(errs...))
+	}
+	return nil
+}
+
+func command_runner_rm(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if len(args) == 0 && !*FlagRunnerRmAll && *FlagRunnerRmRepo == "" {
+		return missingInput("name the runner VMs, or pass --repo or --all")
+	}
+
+	sess := getSession()
+	var runners []managedVM
+	if len(args) > 0 {
+		vms, err := sess.ResolveVMs(ctx, args, *FlagRunnerRmNode)
+		if err != nil {
+			return fmt.Errorf("finding VMs gave err: %w", err)
+		}
+		for _, vm := range vms {
+			m := managedVM{Node: vm.Node, VMID: uint64(vm.VMID), Name: vm.Name, Status: vm.Status}
+			if vm.VirtualMachineConfig != nil {
+				m.Tags = dttproxmox.ResourceTags(vm.VirtualMachineConfig.Tags)
+				m.Provenance, _ = dttproxmox.ParseProvenance(vm.VirtualMachineConfig.Description)
+			}
+			if !hasString(m.Tags, dtt.RunnerTag) {
+				return fmt.Errorf("vm %d (%s) is no dtt runner", m.VMID, m.Name)
+			}
+			runners = append(runners, m)
+		}
+	} else {
+		vms, err := listManagedVMs(ctx, sess, *FlagRunnerRmNode)
+		if err != nil {
+			return fmt.Errorf("listing dtt VMs gave err: %w", err)
+		}
+		for _, vm := range vms {
+			if !hasString(vm.Tags, dtt.RunnerTag) {
+				continue
+			}
+			if *FlagRunnerRmRepo != "" && vm.Provenance.Purpose != "runner "+*FlagRunnerRmRepo {
+				continue
+			}
+			runners = append(runners, vm)
+		}
+	}
+	if len(runners) == 0 {
+		fmt.Println("no runners to remove")
+		return nil
+	}
+
+	token := flagOrEnv(*FlagRunnerRmToken, "DTT_GITHUB_RUNNER_REMOVE_TOKEN")
+	password := flagOrEnv(*FlagRunnerRmSSHPassword, "DTT_SSH_PASSWORD")
+	var errs []error
+	for _, r := range runners {
+		client := dtt.NewWithAPI(dttproxmox.ClientConfig{
+			Node:     r.Node,
+			Progress: progressOutput(),
+			TaskLog:  sess.taskLog,
+		}, sess.pac)
+		vm := client.VM(int(r.VMID), r.Name, firstNonEmpty(*FlagRunnerRmUsername, r.Provenance.User), password)
+		vm.IP = r.IP
+		if err := vm.RemoveRunner(ctx, token); err != nil {
+			errs = append(errs, fmt.Errorf("vm %d (%s): %w", r.VMID, r.Name, err))
+			continue
+		}
+		sess.cache.forgetVM(r.Node, int(r.VMID))
+		fmt.Printf("removed runner %s (vm %d)\n", r.Name, r.VMID)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("removing runners failed for %d of %d:\n%w", len(errs), len(runners), errors.Join(errs...))
+	}
+	return nil
+}
+
+func hasString(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
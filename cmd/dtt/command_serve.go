@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveCommand = &cobra.Command{
+		Use:   "serve",
+		Short: "run a long-lived process exporting cluster, node and vm metrics for Prometheus",
+		Args:  cobra.NoArgs,
+		RunE:  command_serve,
+	}
+
+	FlagServeMetrics       *string
+	FlagServeScrapeTimeout *time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(serveCommand)
+
+	FlagServeMetrics = serveCommand.PersistentFlags().String("metrics", ":9100", "address to serve Prometheus metrics on (path /metrics)")
+	FlagServeScrapeTimeout = serveCommand.PersistentFlags().Duration("scrape-timeout", 30*time.Second, "maximum time to spend querying Proxmox per scrape")
+}
+
+func command_serve(cmd *cobra.Command, args []string) error {
+	pac := getSession().pac
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), *FlagServeScrapeTimeout)
+		defer cancel()
+
+		var buf bytes.Buffer
+		writeMetrics(ctx, &buf, pac)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			slog.Error("writing metrics response failed", "err", err)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><head><title>dtt exporter</title></head><body><a href="/metrics">metrics</a></body></html>`)
+	})
+
+	server := &http.Server{
+		Addr:              *FlagServeMetrics,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	slog.Info("serving metrics", "address", *FlagServeMetrics+"/metrics")
+	if err := server.ListenAndServe(); err != nil {
+		return fmt.Errorf("serving metrics on %s gave err: %w", *FlagServeMetrics, err)
+	}
+	return nil
+}
+
+// writeMetrics gathers a fresh cluster snapshot and writes it in the
+// Prometheus text exposition format. Failures are reported through dtt_up
+// rather than an HTTP error so that Prometheus keeps the series it did get.
+func writeMetrics(ctx context.Context, buf *bytes.Buffer, pac dttproxmox.ProxmoxAPI) {
+	m := &metricsWriter{buf: buf}
+	start := time.Now()
+
+	status, err := gatherClusterStatus(ctx, pac)
+	if err != nil {
+		slog.Error("gathering cluster status failed", "err", err)
+		m.gauge("dtt_up", "Whether the last query of the Proxmox API succeeded.", 0)
+		m.gauge("dtt_scrape_duration_seconds", "Time spent querying the Proxmox API.", time.Since(start).Seconds())
+		return
+	}
+
+	tasks, err := getClusterTasks(ctx, pac)
+	if err != nil {
+		// Task history is only used for the dtt_* timings, keep the rest.
+		slog.Error("getting cluster tasks failed", "err", err)
+	}
+
+	m.gauge("dtt_up", "Whether the last query of the Proxmox API succeeded.", 1)
+	m.info("pve_version_info", "Proxmox VE version.", "version", status.Version.Version, "release", status.Version.Release, "repoid", status.Version.RepoID)
+
+	writeNodeMetrics(m, status.Nodes)
+	writeStorageMetrics(m, status.Storage)
+	writeVMMetrics(m, status.VMs)
+	writeDttMetrics(m, status.VMs, tasks)
+
+	m.gauge("dtt_scrape_duration_seconds", "Time spent querying the Proxmox API.", time.Since(start).Seconds())
+}
+
+func getClusterTasks(ctx context.Context, pac dttproxmox.ProxmoxAPI) (px.Tasks, error) {
+	cluster, err := pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	return cluster.Tasks(ctx)
+}
+
+func writeNodeMetrics(m *metricsWriter, nodes px.NodeStatuses) {
+	m.header("pve_node_up", "gauge", "Whether the node is online.")
+	for _, n := range nodes {
+		up := 0.0
+		if n.Status == "online" {
+			up = 1
+		}
+		m.sample("pve_node_up", up, "node", n.Node)
+	}
+
+	m.header("pve_node_cpu_ratio", "gauge", "CPU utilisation of the node between 0 and 1.")
+	for _, n := range nodes {
+		m.sample("pve_node_cpu_ratio", n.CPU, "node", n.Node)
+	}
+	m.header("pve_node_cpus", "gauge", "Number of CPUs of the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_cpus", float64(n.MaxCPU), "node", n.Node)
+	}
+	m.header("pve_node_memory_used_bytes", "gauge", "Memory in use on the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_memory_used_bytes", float64(n.Mem), "node", n.Node)
+	}
+	m.header("pve_node_memory_total_bytes", "gauge", "Memory installed in the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_memory_total_bytes", float64(n.MaxMem), "node", n.Node)
+	}
+	m.header("pve_node_disk_used_bytes", "gauge", "Root filesystem usage of the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_disk_used_bytes", float64(n.Disk), "node", n.Node)
+	}
+	m.header("pve_node_disk_total_bytes", "gauge", "Root filesystem size of the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_disk_total_bytes", float64(n.MaxDisk), "node", n.Node)
+	}
+	m.header("pve_node_uptime_seconds", "gauge", "Uptime of the node.")
+	for _, n := range nodes {
+		m.sample("pve_node_uptime_seconds", float64(n.Uptime), "node", n.Node)
+	}
+}
+
+func writeStorageMetrics(m *metricsWriter, storage []statusStorageRow) {
+	m.header("pve_storage_up", "gauge", "Whether the storage is available.")
+	for _, s := range storage {
+		up := 0.0
+		if s.Status == "available" {
+			up = 1
+		}
+		m.sample("pve_storage_up", up, "node", s.Node, "storage", s.Name, "type", s.Type)
+	}
+	m.header("pve_storage_used_bytes", "gauge", "Used space on the storage.")
+	for _, s := range storage {
+		m.sample("pve_storage_used_bytes", float64(s.Used), "node", s.Node, "storage", s.Name)
+	}
+	m.header("pve_storage_total_bytes", "gauge", "Size of the storage.")
+	for _, s := range storage {
+		m.sample("pve_storage_total_bytes", float64(s.Total), "node", s.Node, "storage", s.Name)
+	}
+}
+
+func writeVMMetrics(m *metricsWriter, vms []statusVMRow) {
+	labels := func(vm statusVMRow) []string {
+		return []string{"node", vm.Node, "vmid", strconv.FormatUint(vm.VMID, 10), "name", vm.Name}
+	}
+
+	m.header("pve_vm_up", "gauge", "Whether the vm is running.")
+	for _, vm := range vms {
+		up := 0.0
+		if vm.Status == "running" {
+			up = 1
+		}
+		m.sample("pve_vm_up", up, labels(vm)...)
+	}
+	m.header("pve_vm_cpu_ratio", "gauge", "CPU utilisation of the vm between 0 and 1.")
+	for _, vm := range vms {
+		m.sample("pve_vm_cpu_ratio", vm.CPU, labels(vm)...)
+	}
+	m.header("pve_vm_memory_used_bytes", "gauge", "Memory in use by the vm.")
+	for _, vm := range vms {
+		m.sample("pve_vm_memory_used_bytes", float64(vm.Mem), labels(vm)...)
+	}
+	m.header("pve_vm_memory_total_bytes", "gauge", "Memory assigned to the vm.")
+	for _, vm := range vms {
+		m.sample("pve_vm_memory_total_bytes", float64(vm.MaxMem), labels(vm)...)
+	}
+	m.header("pve_vm_disk_total_bytes", "gauge", "Size of the vm boot disk.")
+	for _, vm := range vms {
+		m.sample("pve_vm_disk_total_bytes", float64(vm.MaxDisk), labels(vm)...)
+	}
+	m.header("pve_vm_uptime_seconds", "gauge", "Uptime of the vm.")
+	for _, vm := range vms {
+		m.sample("pve_vm_uptime_seconds", float64(vm.Uptime), labels(vm)...)
+	}
+}
+
+// writeDttMetrics writes metrics about the VMs dtt manages and how long the
+// Proxmox tasks it triggers take, based on the cluster task history.
+func writeDttMetrics(m *metricsWriter, vms []statusVMRow, tasks px.Tasks) {
+	type nodeStatus struct{ node, status string }
+	managed := map[nodeStatus]int{}
+	managedByID := map[uint64]statusVMRow{}
+	for _, vm := range vms {
+		if !dttproxmox.HasTag(vm.Tags, dttproxmox.ManagedTag) {
+			continue
+		}
+		managed[nodeStatus{vm.Node, vm.Status}]++
+		managedByID[vm.VMID] = vm
+	}
+
+	keys := make([]nodeStatus, 0, len(managed))
+	for k := range managed {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].node == keys[j].node {
+			return keys[i].status < keys[j].status
+		}
+		return keys[i].node < keys[j].node
+	})
+	m.header("dtt_managed_vms", "gauge", "Number of vms created by dtt, by node and status.")
+	for _, k := range keys {
+		m.sample("dtt_managed_vms", float64(managed[k]), "node", k.node, "status", k.status)
+	}
+
+	// Task durations per task type, only counting finished tasks.
+	type typeTotals struct {
+		sum   float64
+		count int
+	}
+	totals := map[string]*typeTotals{}
+	// Provisioning is measured from the start of qmcreate until the end of
+	// the first qmstart that followed it.
+	created := map[uint64]time.Time{}
+	provisioned := map[uint64]time.Duration{}
+
+	sorted := make(px.Tasks, 0, len(tasks))
+	for _, t := range tasks {
+		if t == nil || t.EndTime.IsZero() {
+			continue
+		}
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	for _, t := range sorted {
+		tt, ok := totals[t.Type]
+		if !ok {
+			tt = &typeTotals{}
+			totals[t.Type] = tt
+		}
+		tt.sum += t.EndTime.Sub(t.StartTime).Seconds()
+		tt.count++
+
+		vmid, err := strconv.ParseUint(t.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := managedByID[vmid]; !ok {
+			continue
+		}
+		switch t.Type {
+		case "qmcreate":
+			created[vmid] = t.StartTime
+			delete(provisioned, vmid)
+		case "qmstart":
+			if start, ok := created[vmid]; ok {
+				if _, done := provisioned[vmid]; !done {
+					provisioned[vmid] = t.EndTime.Sub(start)
+				}
+			}
+		}
+	}
+
+	types := make([]string, 0, len(totals))
+	for k := range totals {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	m.header("dtt_task_duration_seconds", "summary", "Duration of finished Proxmox tasks in the cluster task history, by task type.")
+	for _, k := range types {
+		m.sample("dtt_task_duration_seconds_sum", totals[k].sum, "type", k)
+		m.sample("dtt_task_duration_seconds_count", float64(totals[k].count), "type", k)
+	}
+
+	ids := make([]uint64, 0, len(provisioned))
+	for id := range provisioned {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	m.header("dtt_vm_provision_duration_seconds", "gauge", "Time from creating a dtt vm until its first start completed.")
+	for _, id := range ids {
+		vm := managedByID[id]
+		m.sample("dtt_vm_provision_duration_seconds", provisioned[id].Seconds(), "node", vm.Node, "vmid", strconv.FormatUint(id, 10), "name", vm.Name)
+	}
+}
+
+// metricsWriter writes samples in the Prometheus text exposition format.
+type metricsWriter struct {
+	buf *bytes.Buffer
+}
+
+func (m *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a single sample. labels are given as alternating names and
+// values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(m.buf, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+func (m *metricsWriter) gauge(name, help string, value float64) {
+	m.header(name, "gauge", help)
+	m.sample(name, value)
+}
+
+func (m *metricsWriter) info(name, help string, labels ...string) {
+	m.header(name, "gauge", help)
+	m.sample(name, 1, labels...)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
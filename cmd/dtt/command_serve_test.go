@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDttMetricsCountTaggedVMs(t *testing.T) {
+	vms := []statusVMRow{
+		{Node: "pve", VMID: 100, Name: "web", Status: "running", Tags: "dtt;dtt-docker"},
+		{Node: "pve", VMID: 101, Name: "dtt-by-hand", Status: "running"},
+		{Node: "pve", VMID: 102, Name: "db", Status: "stopped", Tags: "dtt"},
+	}
+	var buf bytes.Buffer
+	writeDttMetrics(&metricsWriter{buf: &buf}, vms, nil)
+
+	out := buf.String()
+	for _, want := range []string{
+		`dtt_managed_vms{node="pve",status="running"} 1`,
+		`dtt_managed_vms{node="pve",status="stopped"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"sort"
 	"text/tabwriter"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
 
@@ -16,9 +18,12 @@ var (
 		Short: "Show the status of the Proxmox installation",
 		RunE:  command_status,
 	}
+
+	FlagStatusOutput *string
 )
 
 func init() {
+	FlagStatusOutput = addOutputFlag(statusCommand)
 	rootCmd.AddCommand(statusCommand)
 }
 
@@ -65,90 +70,74 @@ func formatUptime(seconds uint64) string {
 	return fmt.Sprintf("%dm", minutes)
 }
 
-func command_status(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+// statusStorageRow is a storage entry from the cluster resources.
+type statusStorageRow struct {
+	Node   string `json:"node" yaml:"node"`
+	Name   string `json:"name" yaml:"name"`
+	Type   string `json:"type" yaml:"type"`
+	Status string `json:"status" yaml:"status"`
+	Used   uint64 `json:"used" yaml:"used"`
+	Total  uint64 `json:"total" yaml:"total"`
+}
 
-	// Get Proxmox proxmox_client
-	pac := getPACFromFlags()
+// statusVMRow is a qemu VM entry from the cluster resources.
+type statusVMRow struct {
+	Node    string  `json:"node" yaml:"node"`
+	VMID    uint64  `json:"vmid" yaml:"vmid"`
+	Name    string  `json:"name" yaml:"name"`
+	Status  string  `json:"status" yaml:"status"`
+	CPU     float64 `json:"cpu" yaml:"cpu"`
+	Mem     uint64  `json:"mem" yaml:"mem"`
+	MaxMem  uint64  `json:"max_mem" yaml:"max_mem"`
+	Disk    uint64  `json:"disk" yaml:"disk"`
+	MaxDisk uint64  `json:"max_disk" yaml:"max_disk"`
+	Uptime  uint64  `json:"uptime" yaml:"uptime"`
+	Tags    string  `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// clusterStatus is a snapshot of the cluster as shown by `dtt status`. Nodes,
+// storage and VMs are sorted by node and then by name or VMID.
+type clusterStatus struct {
+	Version *px.Version
+	Nodes   px.NodeStatuses
+	Storage []statusStorageRow
+	VMs     []statusVMRow
+}
 
+// gatherClusterStatus fetches the version, nodes and cluster resources.
+func gatherClusterStatus(ctx context.Context, pac dttproxmox.ProxmoxAPI) (*clusterStatus, error) {
 	version, err := pac.Version(ctx)
 	if err != nil {
-		return fmt.Errorf("getting version gave err: %w", err)
+		return nil, fmt.Errorf("getting version gave err: %w", err)
 	}
-	fmt.Printf("Version: %s\n  version details: release %q version %q repoID %q\n\n", version.Version, version.Release, version.Version, version.RepoID)
 
 	nodes, err := pac.Nodes(ctx)
 	if err != nil {
-		return fmt.Errorf("getting nodes gave err: %w", err)
+		return nil, fmt.Errorf("getting nodes gave err: %w", err)
 	}
-
-	fmt.Println("Nodes")
-	nodeWriter := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	fmt.Fprintln(nodeWriter, "NODE\tSTATUS\tCPU\tMEM\tDISK\tUPTIME")
 	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
-	for _, n := range nodes {
-		fmt.Fprintf(
-			nodeWriter,
-			"%s\t%s\t%.1f%%\t%s/%s (%s)\t%s/%s (%s)\t%s\n",
-			n.Node,
-			n.Status,
-			n.CPU*100.0,
-			formatBytes(n.Mem),
-			formatBytes(n.MaxMem),
-			formatPercent(n.Mem, n.MaxMem),
-			formatBytes(n.Disk),
-			formatBytes(n.MaxDisk),
-			formatPercent(n.Disk, n.MaxDisk),
-			formatUptime(n.Uptime),
-		)
-	}
-	if err := nodeWriter.Flush(); err != nil {
-		return fmt.Errorf("flushing node writer gave err: %w", err)
-	}
 
 	cluster, err := pac.Cluster(ctx)
 	if err != nil {
-		return fmt.Errorf("getting cluster gave err: %w", err)
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
 	}
 
 	resources, err := cluster.Resources(ctx)
 	if err != nil {
-		return fmt.Errorf("getting cluster resources gave err: %w", err)
-	}
-
-	storageRows := make([]struct {
-		Node   string
-		Name   string
-		Type   string
-		Status string
-		Used   uint64
-		Total  uint64
-	}, 0, len(resources))
-
-	vmRows := make([]struct {
-		Node    string
-		VMID    uint64
-		Name    string
-		Status  string
-		CPU     float64
-		Mem     uint64
-		MaxMem  uint64
-		Disk    uint64
-		MaxDisk uint64
-		Uptime  uint64
-	}, 0, len(resources))
+		return nil, fmt.Errorf("getting cluster resources gave err: %w", err)
+	}
+
+	status := &clusterStatus{
+		Version: version,
+		Nodes:   nodes,
+		Storage: make([]statusStorageRow, 0, len(resources)),
+		VMs:     make([]statusVMRow, 0, len(resources)),
+	}
 
 	for _, r := range resources {
 		switch r.Type {
 		case "storage":
-			storageRows = append(storageRows, struct {
-				Node   string
-				Name   string
-				Type   string
-				Status string
-				Used   uint64
-				Total  uint64
-			}{
+			status.Storage = append(status.Storage, statusStorageRow{
 				Node:   r.Node,
 				Name:   r.Storage,
 				Type:   r.PluginType,
@@ -157,18 +146,7 @@ func command_status(cmd *cobra.Command, args []string) error {
 				Total:  r.MaxDisk,
 			})
 		case "qemu":
-			vmRows = append(vmRows, struct {
-				Node    string
-				VMID    uint64
-				Name    string
-				Status  string
-				CPU     float64
-				Mem     uint64
-				MaxMem  uint64
-				Disk    uint64
-				MaxDisk uint64
-				Uptime  uint64
-			}{
+			status.VMs = append(status.VMs, statusVMRow{
 				Node:    r.Node,
 				VMID:    r.VMID,
 				Name:    r.Name,
@@ -179,28 +157,110 @@ func command_status(cmd *cobra.Command, args []string) error {
 				Disk:    r.Disk,
 				MaxDisk: r.MaxDisk,
 				Uptime:  r.Uptime,
+				Tags:    r.Tags,
 			})
 		}
 	}
 
-	sort.Slice(storageRows, func(i, j int) bool {
-		if storageRows[i].Node == storageRows[j].Node {
-			return storageRows[i].Name < storageRows[j].Name
+	sort.Slice(status.Storage, func(i, j int) bool {
+		if status.Storage[i].Node == status.Storage[j].Node {
+			return status.Storage[i].Name < status.Storage[j].Name
 		}
-		return storageRows[i].Node < storageRows[j].Node
+		return status.Storage[i].Node < status.Storage[j].Node
 	})
-	sort.Slice(vmRows, func(i, j int) bool {
-		if vmRows[i].Node == vmRows[j].Node {
-			return vmRows[i].VMID < vmRows[j].VMID
+	sort.Slice(status.VMs, func(i, j int) bool {
+		if status.VMs[i].Node == status.VMs[j].Node {
+			return status.VMs[i].VMID < status.VMs[j].VMID
 		}
-		return vmRows[i].Node < vmRows[j].Node
+		return status.VMs[i].Node < status.VMs[j].Node
+	})
+
+	return status, nil
+}
+
+// statusNodeRow is a node as `dtt status` shows it.
+type statusNodeRow struct {
+	Node    string  `json:"node" yaml:"node"`
+	Status  string  `json:"status" yaml:"status"`
+	CPU     float64 `json:"cpu" yaml:"cpu"`
+	Mem     uint64  `json:"mem" yaml:"mem"`
+	MaxMem  uint64  `json:"max_mem" yaml:"max_mem"`
+	Disk    uint64  `json:"disk" yaml:"disk"`
+	MaxDisk uint64  `json:"max_disk" yaml:"max_disk"`
+	Uptime  uint64  `json:"uptime" yaml:"uptime"`
+}
+
+// statusOutput is what `dtt status -o json|yaml` prints.
+type statusOutput struct {
+	Version string             `json:"version" yaml:"version"`
+	Release string             `json:"release" yaml:"release"`
+	RepoID  string             `json:"repo_id" yaml:"repo_id"`
+	Nodes   []statusNodeRow    `json:"nodes" yaml:"nodes"`
+	Storage []statusStorageRow `json:"storage" yaml:"storage"`
+	VMs     []statusVMRow      `json:"vms" yaml:"vms"`
+}
+
+// output returns the status for printing as JSON or YAML.
+func (s *clusterStatus) output() statusOutput {
+	return statusOutput{
+		Version: s.Version.Version,
+		Release: s.Version.Release,
+		RepoID:  s.Version.RepoID,
+		Nodes:   nodeRows(s.Nodes),
+		Storage: s.Storage,
+		VMs:     s.VMs,
+	}
+}
+
+// nodeRows returns the rows of nodes, in the same order.
+func nodeRows(nodes px.NodeStatuses) []statusNodeRow {
+	rows := make([]statusNodeRow, 0, len(nodes))
+	for _, n := range nodes {
+		rows = append(rows, statusNodeRow{
+			Node:    n.Node,
+			Status:  n.Status,
+			CPU:     n.CPU,
+			Mem:     n.Mem,
+			MaxMem:  n.MaxMem,
+			Disk:    n.Disk,
+			MaxDisk: n.MaxDisk,
+			Uptime:  n.Uptime,
+		})
+	}
+	return rows
+}
+
+func command_status(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	// Get Proxmox proxmox_client
+	pac := getSession().pac
+
+	status, err := gatherClusterStatus(ctx, pac)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagStatusOutput, status.output(), func(w io.Writer) error {
+		return writeClusterStatus(w, status)
 	})
+}
+
+// writeClusterStatus writes the version, node, storage and VM tables.
+func writeClusterStatus(w io.Writer, status *clusterStatus) error {
+	version := status.Version
+	fmt.Fprintf(w, "Version: %s\n  version details: release %q version %q repoID %q\n\n", version.Version, version.Release, version.Version, version.RepoID)
+
+	fmt.Fprintln(w, "Nodes")
+	if err := writeNodeRows(w, nodeRows(status.Nodes)); err != nil {
+		return err
+	}
 
-	fmt.Println()
-	fmt.Println("Storage")
-	storageWriter := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Storage")
+	storageWriter := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 	fmt.Fprintln(storageWriter, "NODE\tSTORAGE\tTYPE\tSTATUS\tUSED\tTOTAL\tUSE%")
-	for _, s := range storageRows {
+	for _, s := range status.Storage {
 		fmt.Fprintf(
 			storageWriter,
 			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
@@ -217,31 +277,33 @@ func command_status(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("flushing storage writer gave err: %w", err)
 	}
 
-	fmt.Println()
-	fmt.Println("VMs")
-	vmWriter := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	fmt.Fprintln(vmWriter, "NODE\tVMID\tNAME\tSTATUS\tCPU\tMEM\tDISK\tUPTIME")
-	for _, vm := range vmRows {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "VMs")
+	return writeVMRows(w, status.VMs)
+}
+
+// writeNodeRows writes the node table of dtt status and dtt node list.
+func writeNodeRows(w io.Writer, rows []statusNodeRow) error {
+	nodeWriter := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(nodeWriter, "NODE\tSTATUS\tCPU\tMEM\tDISK\tUPTIME")
+	for _, n := range rows {
 		fmt.Fprintf(
-			vmWriter,
-			"%s\t%d\t%s\t%s\t%.1f%%\t%s/%s (%s)\t%s/%s (%s)\t%s\n",
-			vm.Node,
-			vm.VMID,
-			vm.Name,
-			vm.Status,
-			vm.CPU*100.0,
-			formatBytes(vm.Mem),
-			formatBytes(vm.MaxMem),
-			formatPercent(vm.Mem, vm.MaxMem),
-			formatBytes(vm.Disk),
-			formatBytes(vm.MaxDisk),
-			formatPercent(vm.Disk, vm.MaxDisk),
-			formatUptime(vm.Uptime),
+			nodeWriter,
+			"%s\t%s\t%.1f%%\t%s/%s (%s)\t%s/%s (%s)\t%s\n",
+			n.Node,
+			n.Status,
+			n.CPU*100.0,
+			formatBytes(n.Mem),
+			formatBytes(n.MaxMem),
+			formatPercent(n.Mem, n.MaxMem),
+			formatBytes(n.Disk),
+			formatBytes(n.MaxDisk),
+			formatPercent(n.Disk, n.MaxDisk),
+			formatUptime(n.Uptime),
 		)
 	}
-	if err := vmWriter.Flush(); err != nil {
-		return fmt.Errorf("flushing vm writer gave err: %w", err)
+	if err := nodeWriter.Flush(); err != nil {
+		return fmt.Errorf("flushing node writer gave err: %w", err)
 	}
-
 	return nil
-}
\ No newline at end of file
+}
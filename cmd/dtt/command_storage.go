@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var storageCommand = &cobra.Command{
+	Use:   "storage",
+	Short: "storage commands",
+	Long: `Inspect the storages of the nodes, e.g. to find one that takes the import
+content of cloud images and the images content of VM disks before dtt vm
+cloudinit fails halfway:
+
+  dtt storage list --content import
+  dtt storage get local --node pve
+  dtt storage content local --content import`,
+}
+
+func init() {
+	rootCmd.AddCommand(storageCommand)
+}
+
+// storageRow is a storage of a node.
+type storageRow struct {
+	Node    string   `json:"node" yaml:"node"`
+	Name    string   `json:"name" yaml:"name"`
+	Type    string   `json:"type" yaml:"type"`
+	Content []string `json:"content" yaml:"content"`
+	Shared  bool     `json:"shared" yaml:"shared"`
+	Active  bool     `json:"active" yaml:"active"`
+	Avail   uint64   `json:"avail" yaml:"avail"`
+	Used    uint64   `json:"used" yaml:"used"`
+	Total   uint64   `json:"total" yaml:"total"`
+}
+
+// listStorages returns the storages of the online nodes, of node unless it
+// is empty and only those named name unless it is empty, sorted by node and
+// name.
+func listStorages(ctx context.Context, sess *session, node, name string) ([]storageRow, error) {
+	nodes, err := sess.pac.Nodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting nodes gave err: %w", err)
+	}
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if node != "" && n.Node != node {
+			continue
+		}
+		if n.Status != "online" {
+			slog.Warn("skipping node that is not online", "node", n.Node, "status", n.Status)
+			continue
+		}
+		names = append(names, n.Node)
+	}
+	if node != "" && len(names) == 0 {
+		return nil, fmt.Errorf("node %s is not an online node of the cluster", node)
+	}
+	sort.Strings(names)
+
+	var rows []storageRow
+	for _, nodeName := range names {
+		n, err := sess.Node(ctx, nodeName)
+		if err != nil {
+			return nil, fmt.Errorf("getting node %s gave err: %w", nodeName, dttproxmox.WrapError(err))
+		}
+		storages, err := n.Storages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting the storages of node %s gave err: %w", nodeName, dttproxmox.WrapError(err))
+		}
+		for _, s := range storages {
+			if name != "" && s.Name != name {
+				continue
+			}
+			row := storageRow{Node: nodeName, Name: s.Name, Type: s.Type, Content: []string{}, Shared: s.Shared == 1, Active: s.Active == 1, Avail: s.Avail, Used: s.Used, Total: s.Total}
+			if s.Content != "" {
+				row.Content = strings.Split(s.Content, ",")
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// writeStorageRows writes the storage table of storage list.
+func writeStorageRows(w io.Writer, rows []storageRow) error {
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "No storages found.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NODE\tSTORAGE\tTYPE\tCONTENT\tSHARED\tACTIVE\tFREE\tTOTAL\tUSE%")
+	for _, r := range rows {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\t%t\t%s\t%s\t%s\n", r.Node, r.Name, r.Type, strings.Join(r.Content, ","), r.Shared, r.Active, formatBytes(r.Avail), formatBytes(r.Total), formatPercent(r.Used, r.Total))
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flushing storage writer gave err: %w", err)
+	}
+	return nil
+}
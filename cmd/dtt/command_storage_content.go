@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storageContentCommand = &cobra.Command{
+		Use:   "content <storage>",
+		Short: "list the volumes on a storage",
+		Long: `List the volumes on a storage: disk images, imported cloud images, ISOs
+and container templates. --node is needed for a storage that several nodes
+have but do not share.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_storage_content,
+	}
+
+	FlagStorageContentNode    *string
+	FlagStorageContentContent *string
+	FlagStorageContentOutput  *string
+)
+
+func init() {
+	FlagStorageContentNode = storageContentCommand.PersistentFlags().String("node", "", "the node to list the storage of")
+	FlagStorageContentContent = storageContentCommand.PersistentFlags().String("content", "", "only list volumes of this content type, e.g. import or images")
+	FlagStorageContentOutput = addOutputFlag(storageContentCommand)
+	storageCommand.AddCommand(storageContentCommand)
+}
+
+// storageVolume is a volume on a storage. go-proxmox leaves out its content
+// type.
+type storageVolume struct {
+	VolID   string `json:"volid" yaml:"volid"`
+	Content string `json:"content" yaml:"content"`
+	Format  string `json:"format" yaml:"format"`
+	Size    uint64 `json:"size" yaml:"size"`
+	VMID    uint64 `json:"vmid,omitempty" yaml:"vmid,omitempty"`
+	CTime   int64  `json:"ctime,omitempty" yaml:"ctime,omitempty"`
+}
+
+// storageNode returns the node to list storage on, node if it is set.
+func storageNode(ctx context.Context, sess *session, storage, node string) (string, error) {
+	if node != "" {
+		return node, nil
+	}
+	rows, err := listStorages(ctx, sess, "", storage)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(rows) == 0:
+		return "", fmt.Errorf("no node has a storage %s, see dtt storage list", storage)
+	case len(rows) == 1 || rows[0].Shared:
+		return rows[0].Node, nil
+	}
+	nodes := make([]string, 0, len(rows))
+	for _, r := range rows {
+		nodes = append(nodes, r.Node)
+	}
+	return "", fmt.Errorf("%w: storage %s is on nodes %v, pick one with --node", ErrUsage, storage, nodes)
+}
+
+// listStorageContent returns the volumes on storage of node, only those of
+// content unless it is empty, sorted by volume ID.
+func listStorageContent(ctx context.Context, sess *session, node, storage, content string) ([]storageVolume, error) {
+	params := url.Values{}
+	if content != "" {
+		params.Add("content", content)
+	}
+	u := url.URL{Path: fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage), RawQuery: params.Encode()}
+
+	volumes := []storageVolume{}
+	if err := sess.pac.Get(ctx, u.String(), &volumes); err != nil {
+		return nil, fmt.Errorf("listing storage %s on node %s gave err: %w", storage, node, dttproxmox.WrapError(err))
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].VolID < volumes[j].VolID })
+	return volumes, nil
+}
+
+func command_storage_content(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	sess := getSession()
+
+	node, err := storageNode(ctx, sess, args[0], *FlagStorageContentNode)
+	if err != nil {
+		return err
+	}
+	volumes, err := listStorageContent(ctx, sess, node, args[0], *FlagStorageContentContent)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagStorageContentOutput, volumes, func(w io.Writer) error {
+		fmt.Fprintf(w, "Volumes on %s/%s\n", node, args[0])
+		if len(volumes) == 0 {
+			fmt.Fprintln(w, "No volumes found.")
+			return nil
+		}
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "VOLID\tCONTENT\tFORMAT\tSIZE\tVMID\tCREATED")
+		for _, v := range volumes {
+			vmid, created := "", ""
+			if v.VMID != 0 {
+				vmid = fmt.Sprint(v.VMID)
+			}
+			if v.CTime != 0 {
+				created = time.Unix(v.CTime, 0).Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", v.VolID, v.Content, v.Format, formatBytes(v.Size), vmid, created)
+		}
+		return writer.Flush()
+	})
+}
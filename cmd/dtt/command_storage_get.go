@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	storageGetCommand = &cobra.Command{
+		Use:   "get <storage>",
+		Short: "show the type, content types and free space of a storage",
+		Long: `Show the type, content types and free space of a storage, on every node
+that has it unless --node picks one.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_storage_get,
+	}
+
+	FlagStorageGetNode   *string
+	FlagStorageGetOutput *string
+)
+
+func init() {
+	FlagStorageGetNode = storageGetCommand.PersistentFlags().String("node", "", "only show the storage on this node")
+	FlagStorageGetOutput = addOutputFlag(storageGetCommand)
+	storageCommand.AddCommand(storageGetCommand)
+}
+
+func command_storage_get(cmd *cobra.Command, args []string) error {
+	rows, err := listStorages(context.Background(), getSession(), *FlagStorageGetNode, args[0])
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no node has a storage %s, see dtt storage list", args[0])
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagStorageGetOutput, rows, func(w io.Writer) error {
+		for i, r := range rows {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(writer, "Storage:\t%s on %s\n", r.Name, r.Node)
+			fmt.Fprintf(writer, "Type:\t%s\n", r.Type)
+			fmt.Fprintf(writer, "Content:\t%s\n", strings.Join(r.Content, ", "))
+			fmt.Fprintf(writer, "Shared:\t%t\n", r.Shared)
+			fmt.Fprintf(writer, "Active:\t%t\n", r.Active)
+			fmt.Fprintf(writer, "Free:\t%s of %s (%s used)\n", formatBytes(r.Avail), formatBytes(r.Total), formatPercent(r.Used, r.Total))
+			if err := writer.Flush(); err != nil {
+				return fmt.Errorf("flushing storage writer gave err: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	storageListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list the storages of the nodes with their content types and free space",
+		Args:  cobra.NoArgs,
+		RunE:  command_storage_list,
+	}
+
+	FlagStorageListNode    *string
+	FlagStorageListContent *string
+	FlagStorageListOutput  *string
+)
+
+func init() {
+	FlagStorageListNode = storageListCommand.PersistentFlags().String("node", "", "only list the storages of this node")
+	FlagStorageListContent = storageListCommand.PersistentFlags().String("content", "", "only list the storages taking this content type, e.g. import or images")
+	FlagStorageListOutput = addOutputFlag(storageListCommand)
+	storageCommand.AddCommand(storageListCommand)
+}
+
+func command_storage_list(cmd *cobra.Command, args []string) error {
+	rows, err := listStorages(context.Background(), getSession(), *FlagStorageListNode, "")
+	if err != nil {
+		return err
+	}
+	if *FlagStorageListContent != "" {
+		rows = slices.DeleteFunc(rows, func(r storageRow) bool { return !slices.Contains(r.Content, *FlagStorageListContent) })
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagStorageListOutput, rows, func(w io.Writer) error {
+		return writeStorageRows(w, rows)
+	})
+}
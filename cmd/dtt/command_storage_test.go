@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestListStorages(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	pve := server.AddNode("pve")
+	pve.Storage["local"] = []string{"local:import/noble.img", "local:iso/alpine.iso"}
+	pve.StorageContent["local-lvm"] = "images,rootdir"
+	server.AddNode("pve2")
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	rows, err := listStorages(ctx, sess, "", "")
+	if err != nil {
+		t.Fatalf("listStorages() gave err: %v", err)
+	}
+	if len(rows) != 4 || rows[0].Node != "pve" || rows[0].Name != "local" || rows[1].Name != "local-lvm" || rows[2].Node != "pve2" {
+		t.Fatalf("Expected local and local-lvm of pve and pve2, got %+v", rows)
+	}
+	if !slices.Equal(rows[1].Content, []string{"images", "rootdir"}) || rows[0].Used != 2<<20 || rows[0].Avail+rows[0].Used != rows[0].Total {
+		t.Errorf("Expected the content types and space of the storages, got %+v", rows[:2])
+	}
+
+	rows, err = listStorages(ctx, sess, "pve", "local")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("Expected local of pve, got %+v, %v", rows, err)
+	}
+
+	if _, err := storageNode(ctx, sess, "local", ""); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for a storage on two nodes without --node, got %v", err)
+	}
+	if _, err := storageNode(ctx, sess, "nfs", ""); err == nil {
+		t.Error("Expected an error for a storage no node has")
+	}
+
+	volumes, err := listStorageContent(ctx, sess, "pve", "local", "import")
+	if err != nil {
+		t.Fatalf("listStorageContent() gave err: %v", err)
+	}
+	if len(volumes) != 1 || volumes[0].VolID != "local:import/noble.img" || volumes[0].Content != "import" {
+		t.Errorf("Expected only the imported image, got %+v", volumes)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var taskCommand = &cobra.Command{
+	Use:   "task",
+	Short: "Proxmox task commands",
+	Long: `Proxmox task commands, to find out why a task dtt started failed without
+the web UI.
+
+Tasks are named by their UPID, as dtt task list prints them:
+
+  dtt task list --running
+  dtt task log UPID:pve:0001A2B3:0C4D5E6F:66A1B2C3:qmcreate:100:root@pam: --follow
+  dtt task stop UPID:pve:0001A2B3:0C4D5E6F:66A1B2C3:qmcreate:100:root@pam:`,
+}
+
+func init() {
+	rootCmd.AddCommand(taskCommand)
+}
+
+// taskRow is a cluster task as task list prints it.
+type taskRow struct {
+	UPID   string     `json:"upid" yaml:"upid"`
+	Node   string     `json:"node" yaml:"node"`
+	Type   string     `json:"type" yaml:"type"`
+	ID     string     `json:"id" yaml:"id"`
+	User   string     `json:"user" yaml:"user"`
+	Status string     `json:"status" yaml:"status"`
+	Start  time.Time  `json:"start" yaml:"start"`
+	End    *time.Time `json:"end,omitempty" yaml:"end,omitempty"`
+}
+
+// listTasks returns the recent tasks of the cluster, newest first, only
+// those of node unless it is empty and only the running ones if running.
+func listTasks(ctx context.Context, sess *session, node string, running bool) ([]taskRow, error) {
+	cluster, err := sess.pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	tasks, err := cluster.Tasks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster tasks gave err: %w", err)
+	}
+
+	var rows []taskRow
+	for _, t := range tasks {
+		if t == nil || (node != "" && t.Node != node) {
+			continue
+		}
+		row := taskRow{UPID: string(t.UPID), Node: t.Node, Type: t.Type, ID: t.ID, User: t.User, Status: t.Status, Start: t.StartTime}
+		// Running tasks have neither an end time nor a status yet.
+		if t.EndTime.IsZero() {
+			row.Status = proxmox.TaskRunning
+		} else {
+			end := t.EndTime
+			row.End = &end
+		}
+		if running && row.End != nil {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// getTask returns the task named by upid with its current status.
+func getTask(ctx context.Context, sess *session, upid string) (*proxmox.Task, error) {
+	// UPID:node:pid:pstart:starttime:type:id:user: and go-proxmox reads the
+	// fields without checking there are enough.
+	if fields := strings.Split(upid, ":"); len(fields) < 8 || fields[0] != "UPID" || fields[1] == "" {
+		return nil, fmt.Errorf("%w: %q is no task UPID, see dtt task list", ErrUsage, upid)
+	}
+	task, err := dttproxmox.NewTask(sess.pac, upid)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("getting the status of task %s gave err: %w", upid, dttproxmox.WrapError(err))
+	}
+	return task, nil
+}
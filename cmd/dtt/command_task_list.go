@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	taskListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "list recent cluster tasks, newest first",
+		Args:  cobra.NoArgs,
+		RunE:  command_task_list,
+	}
+
+	FlagTaskListNode    *string
+	FlagTaskListRunning *bool
+	FlagTaskListOutput  *string
+)
+
+func init() {
+	FlagTaskListNode = taskListCommand.PersistentFlags().String("node", "", "only list the tasks of this node")
+	FlagTaskListRunning = taskListCommand.PersistentFlags().Bool("running", false, "only list the tasks still running")
+	FlagTaskListOutput = addOutputFlag(taskListCommand)
+	taskCommand.AddCommand(taskListCommand)
+}
+
+func command_task_list(cmd *cobra.Command, args []string) error {
+	rows, err := listTasks(context.Background(), getSession(), *FlagTaskListNode, *FlagTaskListRunning)
+	if err != nil {
+		return err
+	}
+
+	return writeTaskRows(cmd.OutOrStdout(), *FlagTaskListOutput, rows)
+}
+
+// writeTaskRows writes tasks in format, see writeOutput.
+func writeTaskRows(w io.Writer, format string, rows []taskRow) error {
+	return writeOutput(w, format, rows, func(w io.Writer) error {
+		if len(rows) == 0 {
+			fmt.Fprintln(w, "No tasks found.")
+			return nil
+		}
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "STARTED\tNODE\tTYPE\tID\tUSER\tSTATUS\tUPID")
+		for _, row := range rows {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.Start.Local().Format("2006-01-02 15:04:05"), row.Node, row.Type, row.ID, row.User, row.Status, row.UPID)
+		}
+		return writer.Flush()
+	})
+}
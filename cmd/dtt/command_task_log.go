@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	taskLogCommand = &cobra.Command{
+		Use:   "log <upid>",
+		Short: "print the log of a task, optionally following it until the task ends",
+		Long: `Print the log of a task. With --follow, keep printing new lines until the
+task ends, and fail when the task does.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_task_log,
+	}
+
+	FlagTaskLogFollow   *bool
+	FlagTaskLogInterval *time.Duration
+)
+
+func init() {
+	FlagTaskLogFollow = taskLogCommand.PersistentFlags().BoolP("follow", "f", false, "keep printing new lines until the task ends")
+	FlagTaskLogInterval = taskLogCommand.PersistentFlags().Duration("interval", 2*time.Second, "how often to poll the task when following")
+	taskCommand.AddCommand(taskLogCommand)
+}
+
+func command_task_log(cmd *cobra.Command, args []string) error {
+	if *FlagTaskLogInterval <= 0 {
+		return fmt.Errorf("%w: --interval must be positive, got %s", ErrUsage, *FlagTaskLogInterval)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	task, err := getTask(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+
+	w := cmd.OutOrStdout()
+	printLine := func(task *proxmox.Task, line string) { fmt.Fprintln(w, line) }
+	if !*FlagTaskLogFollow {
+		if err := dttproxmox.ReadTaskLog(ctx, task, printLine); err != nil {
+			return fmt.Errorf("reading the log of task %s gave err: %w", args[0], err)
+		}
+		return nil
+	}
+	return dttproxmox.FollowTaskLog(ctx, task, *FlagTaskLogInterval, printLine)
+}
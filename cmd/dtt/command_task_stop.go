@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var taskStopCommand = &cobra.Command{
+	Use:   "stop <upid>",
+	Short: "stop a running task",
+	Args:  cobra.ExactArgs(1),
+	RunE:  command_task_stop,
+}
+
+func init() {
+	taskCommand.AddCommand(taskStopCommand)
+}
+
+func command_task_stop(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	task, err := getTask(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+	if task.IsCompleted {
+		return fmt.Errorf("task %s is not running, it ended with %s", task.UPID, task.ExitStatus)
+	}
+	if err := task.Stop(ctx); err != nil {
+		return fmt.Errorf("stopping task %s gave err: %w", task.UPID, dttproxmox.WrapError(err))
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "stopped task %s\n", task.UPID)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestListTasks(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 101, Name: "db"})
+	server.RunTasks("qmstart")
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+	for _, query := range []string{"web", "db"} {
+		vm, err := sess.ResolveVM(ctx, query, "")
+		if err != nil {
+			t.Fatalf("ResolveVM(%s) gave err: %v", query, err)
+		}
+		if _, err := vm.Start(ctx); err != nil {
+			t.Fatalf("starting %s gave err: %v", query, err)
+		}
+	}
+	var upid string
+	if err := sess.pac.Post(ctx, "/nodes/pve/qemu/100/config", map[string]string{"name": "web"}, &upid); err != nil {
+		t.Fatalf("configuring web gave err: %v", err)
+	}
+
+	rows, err := listTasks(ctx, sess, "", false)
+	if err != nil {
+		t.Fatalf("listTasks() gave err: %v", err)
+	}
+	if len(rows) != 3 || rows[0].Type != "qmconfig" || rows[0].Status != "OK" || rows[0].End == nil {
+		t.Fatalf("Expected the finished qmconfig task first of 3, got %+v", rows)
+	}
+
+	rows, err = listTasks(ctx, sess, "pve", true)
+	if err != nil {
+		t.Fatalf("listTasks(pve, running) gave err: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Type != "qmstart" || rows[0].ID != "100" || rows[0].Status != "running" || rows[0].End != nil {
+		t.Fatalf("Expected the running start of web, got %+v", rows)
+	}
+
+	task, err := getTask(ctx, sess, rows[0].UPID)
+	if err != nil {
+		t.Fatalf("getTask() gave err: %v", err)
+	}
+	if !task.IsRunning || task.Node != "pve" {
+		t.Errorf("Expected the running task on pve, got %+v", task)
+	}
+	if err := task.Stop(ctx); err != nil {
+		t.Fatalf("Stop() gave err: %v", err)
+	}
+	if rows, _ := listTasks(ctx, sess, "pve", true); len(rows) != 0 {
+		t.Errorf("Expected no running tasks on pve after stopping, got %+v", rows)
+	}
+
+	for _, upid := range []string{"100", "UPID:pve:1", "UPID::0:0:0:qmstart:100:root@pam:"} {
+		if _, err := getTask(ctx, sess, upid); !errors.Is(err, ErrUsage) {
+			t.Errorf("getTask(%q) gave err %v, want ErrUsage", upid, err)
+		}
+	}
+}
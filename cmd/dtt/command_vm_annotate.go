@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmAnnotateCommand = &cobra.Command{
+		Use:   "annotate <name-or-id> <text>",
+		Short: "set the description (notes) of a vm, keeping the dtt provenance",
+		Args:  cobra.ExactArgs(2),
+		RunE:  command_vm_annotate,
+	}
+
+	FlagVmAnnotateNode   *string
+	FlagVmAnnotateAppend *bool
+)
+
+func init() {
+	vmCommand.AddCommand(vmAnnotateCommand)
+
+	FlagVmAnnotateNode = vmAnnotateCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmAnnotateAppend = vmAnnotateCommand.PersistentFlags().Bool("append", false, "append the text to the existing description instead of replacing it")
+}
+
+// newVMProvenance describes a VM created now by the current user from
+// imageURL, which may be empty for VMs without an image.
+func newVMProvenance(imageURL, purpose, user string) dttproxmox.Provenance {
+	p := dttproxmox.NewProvenance(version, imageURL, purpose, user)
+	p.Creator = currentCreator()
+	return p
+}
+
+// currentCreator returns local-user@host, followed by the Proxmox identity
+// used for the API calls when one is configured.
+func currentCreator() string {
+	name := dttproxmox.LocalCreator()
+	switch {
+	case *FlagTokenID != "":
+		name += fmt.Sprintf(" (proxmox %s)", *FlagTokenID)
+	case *FlagUserName != "":
+		name += fmt.Sprintf(" (proxmox %s)", *FlagUserName)
+	}
+	return name
+}
+
+// managedOptions returns the VM options marking a newly created VM as dtt's:
+// the --description flag text followed by the provenance, which includes
+// when the VM expires unless ttl is 0, the dtt tag and tag, which tells the
+// command that created it, e.g. dtt-cloudinit.
+func managedOptions(text, imageURL, purpose, user string, ttl time.Duration, tag string) []px.VirtualMachineOption {
+	provenance := newVMProvenance(imageURL, purpose, user).WithTTL(ttl)
+	return []px.VirtualMachineOption{
+		{Name: "description", Value: dttproxmox.JoinDescription(text, provenance.String())},
+		{Name: "tags", Value: dttproxmox.ManagedTag + ";" + tag},
+	}
+}
+
+func getVMDescription(ctx context.Context, pac dttproxmox.ProxmoxAPI, node string, vmid uint64) (string, error) {
+	var config struct {
+		Description string `json:"description"`
+	}
+	if err := pac.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid), &config); err != nil {
+		return "", fmt.Errorf("getting VM config gave err: %w", err)
+	}
+	return strings.TrimSpace(config.Description), nil
+}
+
+func command_vm_annotate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	pac := getSession().pac
+
+	vm, err := findQemuVM(ctx, pac, args[0], *FlagVmAnnotateNode)
+	if err != nil {
+		return fmt.Errorf("finding VM gave err: %w", err)
+	}
+
+	existing := ""
+	if vm.VirtualMachineConfig != nil {
+		existing = vm.VirtualMachineConfig.Description
+	}
+	text, provenance := dttproxmox.SplitDescription(existing)
+
+	if *FlagVmAnnotateAppend && text != "" {
+		text = text + "\n\n" + args[1]
+	} else {
+		text = args[1]
+	}
+
+	task, err := vm.Config(ctx, px.VirtualMachineOption{Name: "description", Value: dttproxmox.JoinDescription(text, provenance)})
+	if err != nil {
+		return fmt.Errorf("setting description of VM %d gave err: %w", vm.VMID, err)
+	}
+	if err := waitTask(ctx, task, time.Second, 30*time.Second); err != nil {
+		return fmt.Errorf("waiting for VM %d config update gave err: %w", vm.VMID, err)
+	}
+
+	fmt.Printf("updated description of vm %d (%s)\n", vm.VMID, vm.Name)
+	return nil
+}
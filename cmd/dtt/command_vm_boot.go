@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmBootCommand = &cobra.Command{
+		Use:   "boot",
+		Short: "vm boot order commands",
+	}
+
+	vmBootSetCommand = &cobra.Command{
+		Use:   "set <name-or-id>",
+		Short: "set the boot order of a vm",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_vm_boot_set,
+	}
+
+	FlagVmBootSetNode  *string
+	FlagVmBootSetOrder *string
+)
+
+func init() {
+	vmCommand.AddCommand(vmBootCommand)
+	vmBootCommand.AddCommand(vmBootSetCommand)
+
+	FlagVmBootSetNode = vmBootSetCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmBootSetOrder = vmBootSetCommand.PersistentFlags().String("order", "", "comma separated boot devices, for example scsi0,ide2,net0")
+	vmBootSetCommand.MarkPersistentFlagRequired("order")
+}
+
+// bootableDevice matches the config keys of devices Proxmox can boot from.
+var bootableDevice = regexp.MustCompile(`^(ide|sata|scsi|virtio|net|usb|hostpci)\d+$`)
+
+// vmBootConfig is the boot relevant part of a VM config.
+type vmBootConfig struct {
+	// Boot is the raw boot option, for example "order=scsi0;ide2;net0".
+	Boot string `json:"boot" yaml:"boot"`
+	// Devices maps attached bootable devices to their config value.
+	Devices map[string]string `json:"devices" yaml:"devices"`
+}
+
+func getVMBootConfig(ctx context.Context, pac dttproxmox.ProxmoxAPI, node string, vmid uint64) (*vmBootConfig, error) {
+	var config map[string]interface{}
+	if err := pac.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid), &config); err != nil {
+		return nil, fmt.Errorf("getting VM config gave err: %w", err)
+	}
+
+	bc := &vmBootConfig{Devices: map[string]string{}}
+	for k, v := range config {
+		if k == "boot" {
+			bc.Boot = fmt.Sprint(v)
+			continue
+		}
+		if bootableDevice.MatchString(k) {
+			bc.Devices[k] = fmt.Sprint(v)
+		}
+	}
+	return bc, nil
+}
+
+// Order returns the devices in the configured boot order. The legacy "cdn"
+// style boot option is not translated, it yields nil.
+func (bc *vmBootConfig) Order() []string {
+	for _, part := range strings.Split(bc.Boot, ",") {
+		order, ok := strings.CutPrefix(strings.TrimSpace(part), "order=")
+		if !ok {
+			continue
+		}
+		var devices []string
+		for _, d := range strings.Split(order, ";") {
+			if d = strings.TrimSpace(d); d != "" {
+				devices = append(devices, d)
+			}
+		}
+		return devices
+	}
+	return nil
+}
+
+// parseBootOrder validates a comma separated --order flag against the devices
+// attached to the VM.
+func parseBootOrder(order string, bc *vmBootConfig) ([]string, error) {
+	var devices []string
+	seen := map[string]bool{}
+	for _, d := range strings.Split(order, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if !bootableDevice.MatchString(d) {
+			return nil, fmt.Errorf("%q is not a bootable device, expected e.g. scsi0, virtio0, ide2 or net0", d)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("device %q listed more than once", d)
+		}
+		if _, ok := bc.Devices[d]; !ok {
+			return nil, fmt.Errorf("device %q is not attached to the vm (attached: %s)", d, strings.Join(bc.attached(), ", "))
+		}
+		seen[d] = true
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("--order needs at least one device")
+	}
+	return devices, nil
+}
+
+func (bc *vmBootConfig) attached() []string {
+	names := make([]string, 0, len(bc.Devices))
+	for k := range bc.Devices {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeVMBootConfig writes the boot order as a vm get field, flagging devices
+// in the order that are no longer attached.
+func writeVMBootConfig(w io.Writer, bc *vmBootConfig) {
+	order := bc.Order()
+	if len(order) == 0 {
+		if bc.Boot == "" {
+			fmt.Fprintln(w, "boot order\t(proxmox default)")
+		} else {
+			fmt.Fprintf(w, "boot order\t%s (legacy format)\n", bc.Boot)
+		}
+		return
+	}
+
+	var missing []string
+	for _, d := range order {
+		if _, ok := bc.Devices[d]; !ok {
+			missing = append(missing, d)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(w, "boot order\t%s (not attached: %s)\n", strings.Join(order, ", "), strings.Join(missing, ", "))
+		return
+	}
+	fmt.Fprintf(w, "boot order\t%s\n", strings.Join(order, ", "))
+}
+
+func command_vm_boot_set(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	pac := getSession().pac
+
+	vm, err := findQemuVM(ctx, pac, args[0], *FlagVmBootSetNode)
+	if err != nil {
+		return fmt.Errorf("finding VM gave err: %w", err)
+	}
+
+	bc, err := getVMBootConfig(ctx, pac, vm.Node, uint64(vm.VMID))
+	if err != nil {
+		return fmt.Errorf("getting boot config for VM %d gave err: %w", vm.VMID, err)
+	}
+
+	devices, err := parseBootOrder(*FlagVmBootSetOrder, bc)
+	if err != nil {
+		return err
+	}
+
+	boot := "order=" + strings.Join(devices, ";")
+	task, err := vm.Config(ctx, px.VirtualMachineOption{Name: "boot", Value: boot})
+	if err != nil {
+		return fmt.Errorf("setting boot order of VM %d gave err: %w", vm.VMID, err)
+	}
+	if err := waitTask(ctx, task, time.Second, 30*time.Second); err != nil {
+		return fmt.Errorf("waiting for VM %d config update gave err: %w", vm.VMID, err)
+	}
+
+	fmt.Printf("set boot order of vm %d (%s) to %s\n", vm.VMID, vm.Name, strings.Join(devices, ", "))
+	if vm.IsRunning() {
+		fmt.Println("the new boot order applies from the next vm start")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmCloneCommand = &cobra.Command{
+		Use:   "clone <source>",
+		Short: "clone a vm or template",
+		Long: `Clone a VM or template into a new VM. Templates are cloned linked unless
+--full is given, which takes seconds as the disks are shared with the
+template. VMs can only be cloned in full.
+
+  dtt vm cloudinit --name debian-base --release debian:bookworm
+  dtt vm template debian-base --stop
+  dtt vm clone debian-base --name build-1 --start`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_clone,
+	}
+
+	FlagVmCloneNode    *string
+	FlagVmCloneName    *string
+	FlagVmCloneFull    *bool
+	FlagVmCloneLinked  *bool
+	FlagVmCloneTarget  *string
+	FlagVmCloneStorage *string
+	FlagVmCloneStart   *bool
+	FlagVmCloneDesc    *string
+	FlagVmCloneTTL     *time.Duration
+)
+
+func init() {
+	FlagVmCloneNode = vmCloneCommand.PersistentFlags().String("node", "", "limit source lookup to a specific node")
+	FlagVmCloneName = vmCloneCommand.PersistentFlags().String("name", "", "name of the new vm (default: dtt-vm-<id>)")
+	FlagVmCloneFull = vmCloneCommand.PersistentFlags().Bool("full", false, "copy the disks instead of sharing them with the template")
+	FlagVmCloneLinked = vmCloneCommand.PersistentFlags().Bool("linked", false, "share the disks with the source, which has to be a template (default for templates)")
+	FlagVmCloneTarget = vmCloneCommand.PersistentFlags().String("target", "", "node to create the clone on (default: the node of the source)")
+	FlagVmCloneStorage = vmCloneCommand.PersistentFlags().String("storage", "", "storage for the disks of a full clone (default: the storage of the source)")
+	FlagVmCloneStart = vmCloneCommand.PersistentFlags().Bool("start", false, "start the clone once it is created")
+	FlagVmCloneDesc = vmCloneCommand.PersistentFlags().String("description", "", "description (notes) for the clone, dtt adds its provenance below it")
+	FlagVmCloneTTL = vmCloneCommand.PersistentFlags().Duration("ttl", 0, "delete the clone with dtt gc once it is this old, e.g. 2h (default: keep)")
+	vmCloneCommand.MarkFlagsMutuallyExclusive("full", "linked")
+
+	vmCommand.AddCommand(vmCloneCommand)
+}
+
+// cloneOptions describe a clone made by cloneVM.
+type cloneOptions struct {
+	Name        string // dtt-vm-<id> unless set
+	Full        bool
+	Linked      bool
+	Target      string
+	Storage     string
+	Description string
+	TTL         time.Duration
+}
+
+// cloneVM clones source and marks the clone as dtt's. It returns the clone.
+func cloneVM(ctx context.Context, sess *session, source *proxmox.VirtualMachine, opts cloneOptions) (*proxmox.VirtualMachine, error) {
+	template := bool(source.Template)
+	if opts.Linked && !template {
+		return nil, fmt.Errorf("%w: vm %d is no template, only templates can be cloned linked, see dtt vm template", ErrUsage, source.VMID)
+	}
+	full := opts.Full || !template
+	if opts.Storage != "" && !full {
+		return nil, fmt.Errorf("%w: --storage needs a full clone", ErrUsage)
+	}
+
+	cluster, err := sess.pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	newid, err := cluster.NextID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting next VM ID gave err: %w", err)
+	}
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("dtt-vm-%d", newid)
+	}
+
+	params := &proxmox.VirtualMachineCloneOptions{
+		NewID:   newid,
+		Name:    name,
+		Target:  opts.Target,
+		Storage: opts.Storage,
+	}
+	if full {
+		params.Full = 1
+	}
+	_, task, err := source.Clone(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("cloning VM %d gave err: %w", source.VMID, err)
+	}
+	// Full clones copy every disk.
+	if err := waitTask(ctx, task, 2*time.Second, 30*time.Minute); err != nil {
+		return nil, fmt.Errorf("waiting for the clone gave err: %w", err)
+	}
+
+	nodeName := source.Node
+	if opts.Target != "" {
+		nodeName = opts.Target
+	}
+	node, err := sess.Node(ctx, nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("getting node %s gave err: %w", nodeName, err)
+	}
+	clone, err := node.VirtualMachine(ctx, newid)
+	if err != nil {
+		return nil, fmt.Errorf("getting VM %d gave err: %w", newid, err)
+	}
+
+	// The clone copies the description and tags of the source, replace them
+	// with its own provenance.
+	configTask, err := clone.Config(ctx, managedOptions(opts.Description, "", fmt.Sprintf("clone of %s", source.Name), "", opts.TTL, "dtt-clone")...)
+	if err != nil {
+		return nil, fmt.Errorf("marking VM %d as dtt's gave err: %w", newid, err)
+	}
+	if err := waitTask(ctx, configTask, time.Second, time.Minute); err != nil {
+		return nil, fmt.Errorf("waiting for the config of VM %d gave err: %w", newid, err)
+	}
+	return clone, nil
+}
+
+func command_vm_clone(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	sess := getSession()
+	source, err := sess.ResolveVM(ctx, args[0], *FlagVmCloneNode)
+	if err != nil {
+		return err
+	}
+
+	clone, err := cloneVM(ctx, sess, source, cloneOptions{
+		Name:        *FlagVmCloneName,
+		Full:        *FlagVmCloneFull,
+		Linked:      *FlagVmCloneLinked,
+		Target:      *FlagVmCloneTarget,
+		Storage:     *FlagVmCloneStorage,
+		Description: *FlagVmCloneDesc,
+		TTL:         *FlagVmCloneTTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *FlagVmCloneStart {
+		task, err := clone.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("starting VM %d gave err: %w", clone.VMID, err)
+		}
+		if err := waitTask(ctx, task, time.Second, 2*time.Minute); err != nil {
+			return fmt.Errorf("waiting for VM start gave err: %w", err)
+		}
+		fmt.Printf("cloned %s into vm %d (%s) on node %s and started it\n", source.Name, clone.VMID, clone.Name, clone.Node)
+		return nil
+	}
+	fmt.Printf("cloned %s into vm %d (%s) on node %s\n", source.Name, clone.VMID, clone.Name, clone.Node)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestCloneVM(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "base", Status: "running", Tags: "golden",
+		Config: map[string]interface{}{"description": "the base image", "memory": 2048}})
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	base, err := sess.ResolveVM(ctx, "base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cloneVM(ctx, sess, base, cloneOptions{Linked: true}); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a linked clone of a VM to be refused, got %v", err)
+	}
+	if err := convertToTemplate(ctx, base, false); err == nil {
+		t.Error("Expected converting a running VM without --stop to fail")
+	}
+	if err := convertToTemplate(ctx, base, true); err != nil {
+		t.Fatalf("convertToTemplate() gave err: %v", err)
+	}
+	if !server.VM(100).Template {
+		t.Fatal("Expected VM 100 to be a template")
+	}
+
+	sess = newSession(server.Client(), newAPICache(0, true))
+	template, err := sess.ResolveVM(ctx, "base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone, err := cloneVM(ctx, sess, template, cloneOptions{Name: "build-1", Target: "pve2"})
+	if err != nil {
+		t.Fatalf("cloneVM() gave err: %v", err)
+	}
+	got := server.VM(uint64(clone.VMID))
+	if got == nil || got.Name != "build-1" || got.Node != "pve2" || fmt.Sprint(got.Config["memory"]) != "2048" {
+		t.Fatalf("Expected build-1 on pve2 with the memory of the template, got %+v", got)
+	}
+	if got.Tags != "dtt;dtt-clone" || !strings.Contains(got.Config["description"].(string), "clone of base") {
+		t.Errorf("Expected the clone to carry its own provenance, got tags %q and description %q", got.Tags, got.Config["description"])
+	}
+	if _, err := cloneVM(ctx, sess, template, cloneOptions{Storage: "local-lvm"}); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected --storage on a linked clone to be refused, got %v", err)
+	}
+}
@@ -5,20 +5,23 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
-	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math/big"
-	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/cdevr/dtt/pkg/cloudconfig"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
 	"github.com/cdevr/dtt/pkg/ssh"
 	"github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
@@ -29,21 +32,38 @@ var (
 	vmCloudInitCommand = &cobra.Command{
 		Use:   "cloudinit",
 		Short: "create a VM from Ubuntu minimal cloud image with cloud-init and start it",
+		Args:  cobra.NoArgs,
 		RunE:  command_vm_cloudinit,
 	}
 
 	FlagVmCloudInitNode           *string
+	FlagVmCloudInitPlacement      *string
 	FlagVmCloudInitName           *string
 	FlagVmCloudInitMemory         *int
 	FlagVmCloudInitCores          *int
+	FlagVmCloudInitBalloonMin     *int
+	FlagVmCloudInitShares         *int
 	FlagVmCloudInitStorage        *string
 	FlagVmCloudInitRelease        *string
 	FlagVmCloudInitDiskSize       *string
 	FlagVmCloudInitUsername       *string
 	FlagVmCloudInitPassword       *string
 	FlagVmCloudInitSSHKey         *string
+	FlagVmCloudInitGenerateSSHKey *bool
 	FlagVmCloudInitPool           *string
 	FlagVmCloudInitNetworkDevice  *[]string
+	FlagVmCloudInitIP             *[]string
+	FlagVmCloudInitGateway        *[]string
+	FlagVmCloudInitIP6            *[]string
+	FlagVmCloudInitDNS            *[]string
+	FlagVmCloudInitSearchDomain   *[]string
+	FlagVmCloudInitUserData       *string
+	FlagVmCloudInitVendorData     *string
+	FlagVmCloudInitSnippetStorage *string
+	FlagVmCloudInitPackage        *[]string
+	FlagVmCloudInitRunCmd         *[]string
+	FlagVmCloudInitWriteFile      *[]string
+	FlagVmCloudInitDataDisk       *[]string
 	FlagVmCloudInitLogMonitorFile *string
 	FlagVmCloudInitBinary         *string
 	FlagVmCloudInitRemotePath     *string
@@ -51,23 +71,49 @@ var (
 	FlagVmCloudInitSSHPrivateKey  *string
 	FlagVmCloudInitVerboseBoot    *bool
 	FlagVmCloudInitDelete         *bool
+	FlagVmCloudInitDescription    *string
+	FlagVmCloudInitTTL            *time.Duration
+	FlagVmCloudInitCount          *int
+	FlagVmCloudInitNamePrefix     *string
+	FlagVmCloudInitParallel       *int
+	FlagVmCloudInitKnownHosts     *string
+	FlagVmCloudInitWaitSSH        *time.Duration
+	FlagVmCloudInitSSHConfig      *string
+	FlagVmCloudInitIfExists       *string
+	FlagVmCloudInitForceRefresh   *bool
 )
 
 func init() {
 	vmCommand.AddCommand(vmCloudInitCommand)
 
-	FlagVmCloudInitNode = vmCloudInitCommand.PersistentFlags().String("node", "pve", "which node to create the vm on")
+	FlagVmCloudInitNode = vmCloudInitCommand.PersistentFlags().String("node", "pve", "which node to create the vm on, auto picks one by --placement")
+	FlagVmCloudInitPlacement = addPlacementFlag(vmCloudInitCommand)
 	FlagVmCloudInitName = vmCloudInitCommand.PersistentFlags().String("name", "", "name of vm to create (default: dtt-ubuntu-<release>-<id>)")
 	FlagVmCloudInitMemory = vmCloudInitCommand.PersistentFlags().Int("memory", 2048, "memory in MB")
 	FlagVmCloudInitCores = vmCloudInitCommand.PersistentFlags().Int("cores", 2, "number of CPU cores")
+	FlagVmCloudInitBalloonMin = vmCloudInitCommand.PersistentFlags().Int("balloon-min", 0, "minimum memory in MB the balloon driver may shrink the VM to (the Proxmox default is kept unless set, set 0 to disable ballooning)")
+	FlagVmCloudInitShares = vmCloudInitCommand.PersistentFlags().Int("shares", 1000, "memory shares for auto-ballooning, relative to other VMs (0 disables auto-ballooning)")
 	FlagVmCloudInitStorage = vmCloudInitCommand.PersistentFlags().String("storage", "local", "storage for imported disk and cloud-init drive")
-	FlagVmCloudInitRelease = vmCloudInitCommand.PersistentFlags().String("release", "ubuntu:noble", "the version you want, default is ubuntu:noble (can be bionic, focal, jammy, noble, plucky, questing, xenial, 22.04, 20.04), can also be debian:bullseye (can be buster, bullseye, bookworm, trixie, 11, 13)")
+	FlagVmCloudInitRelease = vmCloudInitCommand.PersistentFlags().String("release", "ubuntu:noble", "the version you want, default is ubuntu:noble (can be bionic, focal, jammy, noble, plucky, questing, xenial, 22.04, 20.04), can also be debian:bullseye (can be buster, bullseye, bookworm, trixie, 11, 13), fedora:42, rocky:9, almalinux:9, opensuse:leap-15.6 or opensuse:tumbleweed, alpine:3.21 or arch:latest")
 	FlagVmCloudInitDiskSize = vmCloudInitCommand.PersistentFlags().String("disk-size", "+10G", "additional size for boot disk resize (e.g. +10G)")
 	FlagVmCloudInitUsername = vmCloudInitCommand.PersistentFlags().String("username", "dtt", "cloud-init username")
 	FlagVmCloudInitPassword = vmCloudInitCommand.PersistentFlags().String("password", "", "cloud-init password")
-	FlagVmCloudInitSSHKey = vmCloudInitCommand.PersistentFlags().String("sshkey", "generate", "cloud-init SSH public key (use 'generate' to auto-generate a key pair)")
+	FlagVmCloudInitSSHKey = vmCloudInitCommand.PersistentFlags().String("sshkey", "", "cloud-init SSH public key (default: a key pair made for this run only, see --generate-sshkey to keep it)")
+	FlagVmCloudInitGenerateSSHKey = vmCloudInitCommand.PersistentFlags().Bool("generate-sshkey", false, "log in with a new ed25519 key pair instead of a password, and keep its private key in ~/.local/share/dtt/keys/<vmid> for dtt vm ssh and dtt run (can't be combined with --sshkey, --password or --ssh-private-key)")
 	FlagVmCloudInitPool = vmCloudInitCommand.PersistentFlags().String("pool", "", "resource pool to create the node in")
 	FlagVmCloudInitNetworkDevice = vmCloudInitCommand.PersistentFlags().StringArray("net", []string{"virtio,bridge=vmbr0"}, "network device options, for example you can add tag= for a VLAN tag. You can add none of these, or many")
+	FlagVmCloudInitIP = vmCloudInitCommand.PersistentFlags().StringArray("ip", nil, "IPv4 address of a --net device, dhcp or a CIDR like 192.168.1.10/24, once per device in order (default: dhcp for the first)")
+	FlagVmCloudInitGateway = vmCloudInitCommand.PersistentFlags().StringArray("gateway", nil, "IPv4 gateway of a --net device with a static --ip, once per device in order, empty for none")
+	FlagVmCloudInitIP6 = vmCloudInitCommand.PersistentFlags().StringArray("ip6", nil, "IPv6 address of a --net device, auto, dhcp or a CIDR like 2001:db8::10/64, once per device in order (default: auto for the first)")
+	FlagVmCloudInitDNS = vmCloudInitCommand.PersistentFlags().StringArray("dns", nil, "DNS server for the VM (can be repeated, default: the one of the node)")
+	FlagVmCloudInitSearchDomain = vmCloudInitCommand.PersistentFlags().StringArray("searchdomain", nil, "DNS search domain for the VM (can be repeated, default: the one of the node)")
+	FlagVmCloudInitUserData = vmCloudInitCommand.PersistentFlags().String("user-data", "", "cloud-init user-data file to use instead of the one Proxmox generates, it has to create the user and SSH key dtt logs in with itself")
+	FlagVmCloudInitVendorData = vmCloudInitCommand.PersistentFlags().String("vendor-data", "", "cloud-init vendor-data file, merged with the user-data, for packages, runcmd or write_files")
+	FlagVmCloudInitSnippetStorage = vmCloudInitCommand.PersistentFlags().String("snippet-storage", "local", "storage with snippets content to upload --user-data and --vendor-data to")
+	FlagVmCloudInitPackage = vmCloudInitCommand.PersistentFlags().StringArray("package", nil, "package for cloud-init to install on first boot (can be repeated)")
+	FlagVmCloudInitRunCmd = vmCloudInitCommand.PersistentFlags().StringArray("run-cmd", nil, "shell command for cloud-init to run on first boot, after installing the packages (can be repeated)")
+	FlagVmCloudInitWriteFile = vmCloudInitCommand.PersistentFlags().StringArray("write-file", nil, "remote-path=local-path of a file for cloud-init to write on first boot, with the mode of the local file (can be repeated)")
+	FlagVmCloudInitDataDisk = vmCloudInitCommand.PersistentFlags().StringArray("data-disk", nil, "extra disk for cloud-init to format and mount, like size=50G,mount=/data with optional fs=ext4 (or xfs, btrfs) and storage= (default: --storage) (can be repeated)")
 	FlagVmCloudInitLogMonitorFile = vmCloudInitCommand.PersistentFlags().String("monitorfile", "", "log VM monitor data to file")
 	FlagVmCloudInitBinary = vmCloudInitCommand.PersistentFlags().String("binary", "", "local binary to upload and execute on the VM")
 	FlagVmCloudInitRemotePath = vmCloudInitCommand.PersistentFlags().String("remote-path", "/tmp", "remote path to upload the binary to")
@@ -75,6 +121,18 @@ func init() {
 	FlagVmCloudInitSSHPrivateKey = vmCloudInitCommand.PersistentFlags().String("ssh-private-key", "", "path to SSH private key for connecting to the VM (uses password auth if not specified)")
 	FlagVmCloudInitVerboseBoot = vmCloudInitCommand.PersistentFlags().Bool("verbose-boot", false, "print VM boot console output in real-time")
 	FlagVmCloudInitDelete = vmCloudInitCommand.PersistentFlags().Bool("delete", false, "delete the VM after completion (success or failure)")
+	FlagVmCloudInitDescription = vmCloudInitCommand.PersistentFlags().String("description", "", "description (notes) for the vm, dtt adds its provenance below it")
+	FlagVmCloudInitTTL = vmCloudInitCommand.PersistentFlags().Duration("ttl", 0, "delete the vm with dtt gc once it is this old, e.g. 2h (default: keep)")
+	FlagVmCloudInitCount = vmCloudInitCommand.PersistentFlags().Int("count", 1, "number of VMs to create, they get sequential VMIDs")
+	FlagVmCloudInitNamePrefix = vmCloudInitCommand.PersistentFlags().String("name-prefix", "", "with --count, name the VMs <prefix>-1 to <prefix>-N (default: dtt-<release>-<id>)")
+	FlagVmCloudInitParallel = vmCloudInitCommand.PersistentFlags().Int("parallel", 4, "with --count, how many VMs to provision at the same time")
+	FlagVmCloudInitKnownHosts = vmCloudInitCommand.PersistentFlags().String("write-known-hosts", "", "append the host keys the VM prints to this known_hosts file, "+defaultKnownHosts+" when given without =path")
+	vmCloudInitCommand.PersistentFlags().Lookup("write-known-hosts").NoOptDefVal = defaultKnownHosts
+	FlagVmCloudInitWaitSSH = vmCloudInitCommand.PersistentFlags().Duration("wait-ssh", 0, "wait up to this long for SSH to answer with the host key the VM printed, e.g. 2m (default: don't wait)")
+	FlagVmCloudInitSSHConfig = vmCloudInitCommand.PersistentFlags().String("ssh-config", "", "append a Host block for the VM to this ssh_config file, "+defaultSSHConfig+" when given without =path")
+	vmCloudInitCommand.PersistentFlags().Lookup("ssh-config").NoOptDefVal = defaultSSHConfig
+	FlagVmCloudInitForceRefresh = vmCloudInitCommand.PersistentFlags().Bool("force-refresh", false, "download the cloud image again when upstream has a newer one, see dtt image refresh")
+	FlagVmCloudInitIfExists = vmCloudInitCommand.PersistentFlags().String("if-exists", "fail", "what to do when --name matches a dtt VM: reuse it, recreate it, or fail")
 }
 
 var (
@@ -90,21 +148,390 @@ var (
 			"focal":  "20.04",
 			"jammy":  "22.04",
 			"noble":  "24.04",
+		}, "opensuse": map[string]string{
+			"leap-15.5": "15.5",
+			"leap-15.6": "15.6",
 		},
+		// These go by version only, see cloudImageFor.
+		"fedora":    map[string]string{},
+		"rocky":     map[string]string{},
+		"alma":      map[string]string{},
+		"almalinux": map[string]string{},
+		"alpine":    map[string]string{},
+		"arch":      map[string]string{},
 	}
 )
 
+// validateCloudInitFlags runs the preflight checks on the flags before
+// anything is created.
+func validateCloudInitFlags(ctx context.Context, count int) error {
+	spec := preflightSpec{
+		Node:     *FlagVmCloudInitNode,
+		Storage:  *FlagVmCloudInitStorage,
+		Networks: *FlagVmCloudInitNetworkDevice,
+		Memory:   *FlagVmCloudInitMemory,
+		Cores:    *FlagVmCloudInitCores,
+		DiskSize: *FlagVmCloudInitDiskSize,
+		Count:    count,
+	}
+	if *FlagVmCloudInitName != "" {
+		spec.Names = append(spec.Names, *FlagVmCloudInitName)
+	}
+	if *FlagVmCloudInitNamePrefix != "" {
+		spec.Names = append(spec.Names, fmt.Sprintf("%s-%d", *FlagVmCloudInitNamePrefix, count))
+	}
+	_, err := preflight(ctx, getSession(), spec)
+	return err
+}
+
+// cloudInitNetworkOptions returns the ipconfigN options of nets network
+// devices and the DNS options, from the --ip, --gateway, --ip6, --dns and
+// --searchdomain flags. The first device uses DHCP and SLAAC unless told
+// otherwise, the others are left unconfigured.
+func cloudInitNetworkOptions(nets int, ips, gateways, ip6s, dns, searchDomains []string) ([]proxmox.VirtualMachineOption, error) {
+	for flag, values := range map[string][]string{"--ip": ips, "--gateway": gateways, "--ip6": ip6s} {
+		if len(values) > nets {
+			return nil, fmt.Errorf("%w: %d %s for %d --net devices, give one per device", ErrUsage, len(values), flag, nets)
+		}
+	}
+
+	var opts []proxmox.VirtualMachineOption
+	for i := 0; i < nets; i++ {
+		ip, gateway, ip6 := "", "", ""
+		if i == 0 {
+			ip, ip6 = "dhcp", "auto"
+		}
+		if i < len(ips) {
+			ip = ips[i]
+		}
+		if i < len(gateways) {
+			gateway = gateways[i]
+		}
+		if i < len(ip6s) {
+			ip6 = ip6s[i]
+		}
+
+		var parts []string
+		if ip != "" {
+			if prefix, err := netip.ParsePrefix(ip); ip != "dhcp" && (err != nil || !prefix.Addr().Is4()) {
+				return nil, fmt.Errorf("%w: --ip %q of net%d is not dhcp or an IPv4 address like 192.168.1.10/24", ErrUsage, ip, i)
+			}
+			parts = append(parts, "ip="+ip)
+		}
+		if gateway != "" {
+			if addr, err := netip.ParseAddr(gateway); err != nil || !addr.Is4() {
+				return nil, fmt.Errorf("%w: --gateway %q of net%d is not an IPv4 address", ErrUsage, gateway, i)
+			}
+			if ip == "" || ip == "dhcp" {
+				return nil, fmt.Errorf("%w: --gateway %s of net%d needs a static --ip, DHCP brings its own", ErrUsage, gateway, i)
+			}
+			parts = append(parts, "gw="+gateway)
+		}
+		if ip6 != "" {
+			if prefix, err := netip.ParsePrefix(ip6); ip6 != "auto" && ip6 != "dhcp" && (err != nil || !prefix.Addr().Is6()) {
+				return nil, fmt.Errorf("%w: --ip6 %q of net%d is not auto, dhcp or an IPv6 address like 2001:db8::10/64", ErrUsage, ip6, i)
+			}
+			parts = append(parts, "ip6="+ip6)
+		}
+		if len(parts) > 0 {
+			opts = append(opts, proxmox.VirtualMachineOption{Name: fmt.Sprintf("ipconfig%d", i), Value: strings.Join(parts, ",")})
+		}
+	}
+
+	for _, server := range dns {
+		if _, err := netip.ParseAddr(server); err != nil {
+			return nil, fmt.Errorf("%w: --dns %q is not an IP address", ErrUsage, server)
+		}
+	}
+	if len(dns) > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "nameserver", Value: strings.Join(dns, " ")})
+	}
+	if len(searchDomains) > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "searchdomain", Value: strings.Join(searchDomains, " ")})
+	}
+	return opts, nil
+}
+
+// dataDisk is an extra disk of a --data-disk flag.
+type dataDisk struct {
+	SizeGiB    uint64
+	Mount      string
+	Filesystem string
+	Storage    string
+}
+
+// dataDiskFilesystems are the filesystems --data-disk can make.
+var dataDiskFilesystems = []string{"ext4", "xfs", "btrfs"}
+
+// parseDataDisks parses --data-disk flags like size=50G,mount=/data, with
+// the disks on storage unless they say otherwise.
+func parseDataDisks(specs []string, storage string) ([]dataDisk, error) {
+	var disks []dataDisk
+	mounts := map[string]bool{}
+	for _, spec := range specs {
+		disk := dataDisk{Filesystem: "ext4", Storage: storage}
+		var size string
+		for _, field := range strings.Split(spec, ",") {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "size":
+				size = value
+			case "mount":
+				disk.Mount = value
+			case "fs":
+				disk.Filesystem = value
+			case "storage":
+				disk.Storage = value
+			default:
+				return nil, fmt.Errorf("%w: --data-disk %q has unknown option %q, want size, mount, fs or storage", ErrUsage, spec, key)
+			}
+		}
+		bytes, err := dttproxmox.DiskSizeBytes(size)
+		if err != nil || strings.HasPrefix(size, "+") || bytes == 0 {
+			return nil, fmt.Errorf("%w: --data-disk %q needs a size like size=50G", ErrUsage, spec)
+		}
+		disk.SizeGiB = (bytes + 1<<30 - 1) >> 30
+		if !path.IsAbs(disk.Mount) || disk.Mount == "/" {
+			return nil, fmt.Errorf("%w: --data-disk %q needs a mount point like mount=/data", ErrUsage, spec)
+		}
+		if mounts[disk.Mount] {
+			return nil, fmt.Errorf("%w: two --data-disk mount on %s", ErrUsage, disk.Mount)
+		}
+		mounts[disk.Mount] = true
+		if !slices.Contains(dataDiskFilesystems, disk.Filesystem) {
+			return nil, fmt.Errorf("%w: --data-disk %q has fs %q, want one of %s", ErrUsage, spec, disk.Filesystem, strings.Join(dataDiskFilesystems, ", "))
+		}
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// dataDiskOptions returns the options adding disks after the boot disk
+// scsi0, as scsi1 and on.
+func dataDiskOptions(disks []dataDisk) []proxmox.VirtualMachineOption {
+	var opts []proxmox.VirtualMachineOption
+	for i, disk := range disks {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: fmt.Sprintf("scsi%d", i+1), Value: fmt.Sprintf("%s:%d", disk.Storage, disk.SizeGiB)})
+	}
+	return opts
+}
+
+// dataDiskDevice returns the device of the i-th data disk in the VM, by the
+// drive name QEMU gives it, which unlike /dev/sdX doesn't depend on the order
+// the disks are found in.
+func dataDiskDevice(i int) string {
+	return fmt.Sprintf("/dev/disk/by-id/scsi-0QEMU_QEMU_HARDDISK_drive-scsi%d", i+1)
+}
+
+// cloudInitVendorData returns the cloud-config installing packages, running
+// runCmds, writing the remote=local files of writeFiles and formatting and
+// mounting disks, "" when there is nothing to do. It is vendor-data rather
+// than user-data, so Proxmox still generates the user, password, SSH key and
+// hostname of each VM.
+func cloudInitVendorData(packages, runCmds, writeFiles []string, disks []dataDisk) (string, error) {
+	if len(packages) == 0 && len(runCmds) == 0 && len(writeFiles) == 0 && len(disks) == 0 {
+		return "", nil
+	}
+	b := cloudconfig.NewBuilder()
+	for i, disk := range disks {
+		b.WithDataDisk(dataDiskDevice(i), disk.Filesystem, fmt.Sprintf("data%d", i+1), disk.Mount)
+	}
+	for _, pkg := range packages {
+		b.WithPackage(pkg)
+	}
+	for _, cmd := range runCmds {
+		b.WithRunCommand(cmd)
+	}
+	for _, f := range writeFiles {
+		remote, local, ok := strings.Cut(f, "=")
+		if !ok || !path.IsAbs(remote) || local == "" {
+			return "", fmt.Errorf("%w: --write-file %q, want an absolute remote path=local path, like /etc/motd=./motd", ErrUsage, f)
+		}
+		info, err := os.Stat(local)
+		if err != nil {
+			return "", fmt.Errorf("%w: --write-file %s: %v", ErrUsage, remote, err)
+		}
+		content, err := os.ReadFile(local)
+		if err != nil {
+			return "", fmt.Errorf("reading %s gave err: %w", local, err)
+		}
+		b.WithFile(cloudconfig.File{Path: remote, Content: string(content), Permissions: fmt.Sprintf("%#o", info.Mode().Perm())})
+	}
+	data, err := b.Build().Generate()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	return data, nil
+}
+
+// uploadCloudInitSnippets uploads the user-data and vendor-data files, those
+// that are given, as snippets to storage on node and returns the cicustom
+// value using them, "" when neither is given.
+func uploadCloudInitSnippets(ctx context.Context, sess *session, node, storage, userData, vendorData string) (string, error) {
+	var parts []string
+	for _, f := range []struct{ kind, path string }{{"user", userData}, {"vendor", vendorData}} {
+		if f.path == "" {
+			continue
+		}
+		volID, err := dttproxmox.UploadSnippet(ctx, sess.pac, node, storage, f.path, dttproxmox.UploadOptions{Progress: progressOutput()})
+		if err != nil {
+			return "", fmt.Errorf("uploading %s-data %s gave err: %w", f.kind, f.path, err)
+		}
+		parts = append(parts, f.kind+"="+volID)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// staticIPs reports whether any of ips is a static address rather than dhcp.
+func staticIPs(ips []string) bool {
+	for _, ip := range ips {
+		if ip != "dhcp" && ip != "auto" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleExistingCloudInitVM applies --if-exists to the dtt VM called name,
+// if there is one. It reports whether that VM is reused, printing how to
+// connect to it, in which case nothing is to be created.
+func handleExistingCloudInitVM(ctx context.Context, sess *session, w io.Writer, name string) (bool, error) {
+	managed, err := listManagedVMs(ctx, sess, "")
+	if err != nil {
+		return false, fmt.Errorf("listing dtt VMs gave err: %w", err)
+	}
+	var matches []managedVM
+	for _, m := range managed {
+		if m.Name == name {
+			matches = append(matches, m)
+		}
+	}
+	if len(matches) == 0 {
+		return false, nil
+	}
+	if len(matches) > 1 {
+		return false, fmt.Errorf("%w: %d dtt VMs are called %q", dttproxmox.ErrAmbiguousName, len(matches), name)
+	}
+	m := matches[0]
+
+	if *FlagVmCloudInitIfExists == "fail" {
+		return false, fmt.Errorf("dtt VM %q already exists as %d on node %s, pass --if-exists reuse or recreate", name, m.VMID, m.Node)
+	}
+	node, err := sess.Node(ctx, m.Node)
+	if err != nil {
+		return false, err
+	}
+	vm, err := sess.VM(ctx, node, int(m.VMID))
+	if err != nil {
+		return false, err
+	}
+	if *FlagVmCloudInitIfExists == "recreate" {
+		deleteCloudInitVM(ctx, vm)
+		sess.cache.forgetVM(m.Node, int(m.VMID))
+		return false, nil
+	}
+
+	if m.Status != "running" {
+		task, err := vm.Start(ctx)
+		if err != nil {
+			return false, fmt.Errorf("starting VM %d gave err: %w", m.VMID, err)
+		}
+		if err := waitTask(ctx, task, time.Second, 2*time.Minute); err != nil {
+			return false, fmt.Errorf("waiting for VM %d to start gave err: %w", m.VMID, err)
+		}
+	}
+	user := *FlagVmCloudInitUsername
+	if vm.VirtualMachineConfig != nil && vm.VirtualMachineConfig.CIUser != "" {
+		user = vm.VirtualMachineConfig.CIUser
+	}
+
+	fmt.Fprintf(w, "reusing VM %d (%s) on node %s\n", m.VMID, name, m.Node)
+	if m.IP != "" {
+		identity := *FlagVmCloudInitSSHPrivateKey
+		if identity == "" {
+			identity = storedVMKey(int(m.VMID))
+		}
+		fmt.Fprintf(w, "log in with: %s\n", sshCommandLine(user, m.IP, identity))
+	}
+	return true, nil
+}
+
 func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	pac := getPACFromFlags()
+	pac := getSession().pac
+
+	count := *FlagVmCloudInitCount
+	if count < 1 {
+		return fmt.Errorf("--count must be at least 1, got %d", count)
+	}
+	if count > 1 {
+		if *FlagVmCloudInitName != "" {
+			return fmt.Errorf("--name names a single VM, use --name-prefix with --count")
+		}
+		if *FlagVmCloudInitBinary != "" || *FlagVmCloudInitLogMonitorFile != "" {
+			return fmt.Errorf("--binary and --monitorfile can't be combined with --count")
+		}
+	}
+	networkOpts, err := cloudInitNetworkOptions(len(*FlagVmCloudInitNetworkDevice), *FlagVmCloudInitIP, *FlagVmCloudInitGateway, *FlagVmCloudInitIP6, *FlagVmCloudInitDNS, *FlagVmCloudInitSearchDomain)
+	if err != nil {
+		return err
+	}
+	if count > 1 && (staticIPs(*FlagVmCloudInitIP) || staticIPs(*FlagVmCloudInitIP6)) {
+		return fmt.Errorf("%w: a static --ip or --ip6 would be shared by the %d VMs of --count", ErrUsage, count)
+	}
+	for _, path := range []string{*FlagVmCloudInitUserData, *FlagVmCloudInitVendorData} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%w: %v", ErrUsage, err)
+		}
+	}
+	sshPublicKey, err := cloudInitSSHKey(*FlagVmCloudInitSSHKey, *FlagVmCloudInitGenerateSSHKey, *FlagVmCloudInitPassword, *FlagVmCloudInitSSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	dataDisks, err := parseDataDisks(*FlagVmCloudInitDataDisk, *FlagVmCloudInitStorage)
+	if err != nil {
+		return err
+	}
+	vendorData, err := cloudInitVendorData(*FlagVmCloudInitPackage, *FlagVmCloudInitRunCmd, *FlagVmCloudInitWriteFile, dataDisks)
+	if err != nil {
+		return err
+	}
+	if vendorData != "" && *FlagVmCloudInitVendorData != "" {
+		return fmt.Errorf("%w: --package, --run-cmd, --write-file and --data-disk make the vendor-data, they can't be combined with --vendor-data", ErrUsage)
+	}
+	if *FlagVmCloudInitUserData != "" && (*FlagVmCloudInitBinary != "" || *FlagVmCloudInitWaitSSH > 0) {
+		slog.Warn("--user-data replaces the user, password and SSH key dtt sets, dtt can only log in if it sets them the same", "username", *FlagVmCloudInitUsername)
+	}
+	switch *FlagVmCloudInitIfExists {
+	case "reuse", "recreate", "fail":
+	default:
+		return fmt.Errorf("%w: --if-exists must be reuse, recreate or fail, got %q", ErrUsage, *FlagVmCloudInitIfExists)
+	}
+	if err := placeNode(ctx, getSession(), FlagVmCloudInitNode, *FlagVmCloudInitPlacement, *FlagVmCloudInitMemory*count); err != nil {
+		return err
+	}
+	if err := validateCloudInitFlags(ctx, count); err != nil {
+		return err
+	}
+	if name := *FlagVmCloudInitName; name != "" || (count == 1 && *FlagVmCloudInitNamePrefix != "") {
+		if name == "" {
+			name = *FlagVmCloudInitNamePrefix
+		}
+		reused, err := handleExistingCloudInitVM(ctx, getSession(), cmd.OutOrStdout(), name)
+		if err != nil || reused {
+			return err
+		}
+	}
 
 	// Handle SSH key generation
-	sshPublicKey := *FlagVmCloudInitSSHKey
 	sshPrivateKeyPath := *FlagVmCloudInitSSHPrivateKey
 	var sshKeyCleanup func()
 
-	if sshPublicKey == "generate" {
-		fmt.Println("generating SSH key pair...")
+	if sshPublicKey == "" {
+		slog.Info("generating SSH key pair")
 		pubKey, privKeyPath, cleanup, err := generateSSHKeyPair()
 		if err != nil {
 			return fmt.Errorf("generating SSH key pair: %w", err)
@@ -112,7 +539,7 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		sshKeyCleanup = cleanup
 		sshPublicKey = pubKey
 		sshPrivateKeyPath = privKeyPath
-		log.Printf("generated SSH key pair (private key: %s)", privKeyPath)
+		slog.Info("generated SSH key pair", "private_key", privKeyPath)
 	}
 	if sshKeyCleanup != nil {
 		defer sshKeyCleanup()
@@ -143,19 +570,17 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	cloudImageURL, err := getFnFromCloudImageURL(distro, version, release)
+	image, err := cloudImageFor(distro, version, release)
 	if err != nil {
 		return fmt.Errorf("Failed to get cloudImageURL: %w", err)
 	}
-	log.Printf("constructed cloudImageURL: %q", cloudImageURL)
+	cloudImageURL := image.URL
+	slog.Debug("cloud image", "url", cloudImageURL)
 
-	qcow2Name, err := extractFn(cloudImageURL)
+	qcow2Name, err := image.importFilename()
 	if err != nil {
 		return fmt.Errorf("failed to extract filename from URL %q", cloudImageURL)
 	}
-
-	// Needed for ubuntu minimal cloud images.
-	qcow2Name = strings.ReplaceAll(qcow2Name, ".img", ".qcow2")
 	importVolID := fmt.Sprintf("%s:import/%s", *FlagVmCloudInitStorage, qcow2Name)
 
 	storage, err := node.Storage(ctx, *FlagVmCloudInitStorage)
@@ -163,125 +588,151 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("getting storage %s on node %s gave err: %w", *FlagVmCloudInitStorage, *FlagVmCloudInitNode, err)
 	}
 
-	if err := ensureImportImage(ctx, storage, qcow2Name, cloudImageURL); err != nil {
-		return fmt.Errorf("importing cloud image gave err: %w", err)
+	if *FlagVmCloudInitForceRefresh {
+		provisioner := getSession().Provisioner(*FlagVmCloudInitNode, *FlagVmCloudInitStorage, "")
+		check, err := provisioner.RefreshImage(ctx, dttproxmox.Image{Name: release, URL: cloudImageURL}, *FlagVmCloudInitStorage)
+		if err != nil {
+			return fmt.Errorf("refreshing cloud image gave err: %w", err)
+		}
+		if check.Stale {
+			slog.Info("downloaded stale image again", "volume", importVolID, "reason", check.Reason)
+		}
 	}
-
-	vmName := fmt.Sprintf("dtt-%s-%d", strings.Replace(release, ":", "-", -1), vmID)
-	if *FlagVmCloudInitName != "" {
-		vmName = *FlagVmCloudInitName
+	if err := ensureImportImage(ctx, storage, qcow2Name, cloudImageURL, progressOutput()); err != nil {
+		return fmt.Errorf("importing cloud image gave err: %w", err)
 	}
 
-	opts := []proxmox.VirtualMachineOption{
-		proxmox.VirtualMachineOption{Name: "name", Value: vmName},
-		proxmox.VirtualMachineOption{Name: "memory", Value: *FlagVmCloudInitMemory},
-		proxmox.VirtualMachineOption{Name: "cores", Value: *FlagVmCloudInitCores},
-		proxmox.VirtualMachineOption{Name: "sockets", Value: 1},
-		proxmox.VirtualMachineOption{Name: "ostype", Value: "l26"},
-		proxmox.VirtualMachineOption{Name: "scsihw", Value: "virtio-scsi-pci"},
-		proxmox.VirtualMachineOption{Name: "serial0", Value: "socket"},
-		proxmox.VirtualMachineOption{Name: "vga", Value: "serial0"},
-		proxmox.VirtualMachineOption{Name: "agent", Value: "enabled=1"},
-	}
-	for i, netdev := range *FlagVmCloudInitNetworkDevice {
-		opts = append(opts, proxmox.VirtualMachineOption{Name: fmt.Sprintf("net%d", i), Value: netdev})
+	vendorDataPath := *FlagVmCloudInitVendorData
+	if vendorData != "" {
+		dir, err := os.MkdirTemp("", "dtt-vendor-data")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		vendorDataPath = filepath.Join(dir, "vendor-data.yaml")
+		if err := os.WriteFile(vendorDataPath, []byte(vendorData), 0o600); err != nil {
+			return err
+		}
 	}
-	if *FlagVmCloudInitPool != "" {
-		opts = append(opts, proxmox.VirtualMachineOption{"pool", *FlagVmCloudInitPool})
+	ciCustom, err := uploadCloudInitSnippets(ctx, getSession(), *FlagVmCloudInitNode, *FlagVmCloudInitSnippetStorage, *FlagVmCloudInitUserData, vendorDataPath)
+	if err != nil {
+		return err
 	}
-	log.Printf("creating VM with ID %d and params: %v", vmID, opts)
 
-	createTask, err := node.NewVirtualMachine(
-		ctx,
-		vmID,
-		opts...,
-	)
+	balloonOpts, err := memoryBalloonOptions(cmd, *FlagVmCloudInitMemory, *FlagVmCloudInitBalloonMin, *FlagVmCloudInitShares)
 	if err != nil {
-		return fmt.Errorf("creating cloud-init VM %d gave err: %w", vmID, err)
+		return err
 	}
-	if err := createTask.Wait(ctx, time.Second, 2*time.Minute); err != nil {
-		return fmt.Errorf("waiting for cloud-init VM creation gave err: %w", err)
+
+	vmIDs := []int{vmID}
+	if count > 1 {
+		resources, err := cluster.Resources(ctx)
+		if err != nil {
+			return fmt.Errorf("getting cluster resources gave err: %w", err)
+		}
+		used := map[int]bool{}
+		for _, r := range resources {
+			if r.VMID != 0 {
+				used[int(r.VMID)] = true
+			}
+		}
+		vmIDs = sequentialFreeVMIDs(used, vmID, count)
 	}
 
-	vm, err := node.VirtualMachine(ctx, vmID)
-	if err != nil {
-		return fmt.Errorf("getting cloud-init VM %d gave err: %w", vmID, err)
+	vms := make([]*cloudInitVM, len(vmIDs))
+	for i, id := range vmIDs {
+		ci := &cloudInitVM{
+			VMID:     id,
+			Name:     cloudInitVMName(release, id, i, count),
+			Password: *FlagVmCloudInitPassword,
+		}
+		if strings.TrimSpace(ci.Password) == "" && !*FlagVmCloudInitGenerateSSHKey {
+			ci.Password, err = GenerateEasyPassword(3)
+			if err != nil {
+				return fmt.Errorf("failed to generate easy password: %w", err)
+			}
+			if count == 1 {
+				fmt.Printf("generated cloud-init credentials: username %s password %s\n", *FlagVmCloudInitUsername, ci.Password)
+			}
+		}
+		vms[i] = ci
 	}
 
 	// Set up VM deletion if --delete flag is set
 	if *FlagVmCloudInitDelete {
 		defer func() {
-			fmt.Printf("deleting VM %d...\n", vmID)
-			// Stop the VM first if it's running
-			if stopTask, err := vm.Stop(ctx); err == nil {
-				_ = stopTask.Wait(ctx, time.Second, 30*time.Second)
-			}
-			if deleteTask, err := vm.Delete(ctx); err != nil {
-				fmt.Printf("warning: failed to delete VM %d: %v\n", vmID, err)
-			} else {
-				if err := deleteTask.Wait(ctx, time.Second, 30*time.Second); err != nil {
-					fmt.Printf("warning: failed waiting for VM %d deletion: %v\n", vmID, err)
-				} else {
-					fmt.Printf("VM %d deleted\n", vmID)
+			for _, ci := range vms {
+				if ci.vm != nil {
+					deleteCloudInitVM(ctx, ci.vm)
 				}
 			}
 		}()
 	}
 
-	ciPassword := *FlagVmCloudInitPassword
-	if strings.TrimSpace(ciPassword) == "" {
-		ciPassword, err = GenerateEasyPassword(3)
-		if err != nil {
-			return fmt.Errorf("failed to generate easy password: %w", err)
-		}
-		fmt.Printf("generated cloud-init credentials: username %s password %s\n", *FlagVmCloudInitUsername, ciPassword)
-	}
-
-	log.Printf("configuring VM %q ID %d with boot drive, and cloud init parameters", vm.Name, vm.VMID)
-	configOpts := []proxmox.VirtualMachineOption{
-		proxmox.VirtualMachineOption{Name: "scsi0", Value: fmt.Sprintf("%s:0,import-from=%s", *FlagVmCloudInitStorage, importVolID)},
-		proxmox.VirtualMachineOption{Name: "boot", Value: "order=scsi0"},
-		proxmox.VirtualMachineOption{Name: "ide2", Value: fmt.Sprintf("%s:cloudinit", *FlagVmCloudInitStorage)},
-		proxmox.VirtualMachineOption{Name: "ciuser", Value: *FlagVmCloudInitUsername},
-		proxmox.VirtualMachineOption{Name: "cipassword", Value: ciPassword},
-		proxmox.VirtualMachineOption{Name: "ipconfig0", Value: "ip=dhcp,ip6=auto"},
-	}
-	if sshKey := strings.TrimSpace(sshPublicKey); sshKey != "" && sshKey != "generate" {
-		enc := url.QueryEscape(sshKey)            // makes spaces into +
-		enc = strings.ReplaceAll(enc, "+", "%20") // turn the + encoded spaces into %20
-
-		log.Printf("passing in sshkeys %q", enc)
-
-		configOpts = append(configOpts, proxmox.VirtualMachineOption{Name: "sshkeys", Value: enc})
-	}
-	configTask, err := vm.Config(ctx, configOpts...)
-	if err != nil {
-		return fmt.Errorf("configuring cloud-init VM gave err: %w", err)
+	setup := &cloudInitSetup{
+		Node:          node,
+		CloudImageURL: cloudImageURL,
+		ImportVolID:   importVolID,
+		SSHPublicKey:  sshPublicKey,
+		BalloonOpts:   balloonOpts,
+		NetworkOpts:   networkOpts,
+		DataDiskOpts:  dataDiskOptions(dataDisks),
+		CICustom:      ciCustom,
+		// Interleaved consoles of several VMs would be unreadable.
+		VerboseBoot: *FlagVmCloudInitVerboseBoot && count == 1,
 	}
-	if err := configTask.Wait(ctx, time.Second, 5*time.Minute); err != nil {
-		return fmt.Errorf("waiting for cloud-init config gave err: %w", err)
-	}
-
-	resizeTask, err := vm.ResizeDisk(ctx, "scsi0", *FlagVmCloudInitDiskSize)
-	if err != nil {
-		return fmt.Errorf("resizing cloud-init VM disk gave err: %w", err)
+	provisionCloudInitVMs(ctx, setup, vms, *FlagVmCloudInitParallel)
+
+	// The generated key is removed when dtt exits, only a given one or one
+	// kept with --generate-sshkey can be used to log in later.
+	identity := *FlagVmCloudInitSSHPrivateKey
+	if identity != "" {
+		if abs, err := filepath.Abs(identity); err == nil {
+			identity = abs
+		}
 	}
-	if err := resizeTask.Wait(ctx, time.Second, 2*time.Minute); err != nil {
-		return fmt.Errorf("waiting for disk resize gave err: %w", err)
+	if *FlagVmCloudInitGenerateSSHKey {
+		identity = sshPrivateKeyPath
+		if !*FlagVmCloudInitDelete {
+			for _, ci := range vms {
+				if ci.vm == nil {
+					continue
+				}
+				path, err := storeVMKey(ci.VMID, sshPrivateKeyPath, sshPublicKey)
+				if err != nil {
+					return fmt.Errorf("keeping the SSH key of VM %d gave err: %w", ci.VMID, err)
+				}
+				identity = path
+			}
+		}
 	}
 
-	startTask, err := vm.Start(ctx)
-	if err != nil {
-		return fmt.Errorf("starting cloud-init VM gave err: %w", err)
-	}
-	if err := startTask.Wait(ctx, time.Second, 2*time.Minute); err != nil {
-		return fmt.Errorf("waiting for cloud-init VM start gave err: %w", err)
+	if count > 1 {
+		for _, ci := range vms {
+			if ci.Err == nil && cloudInitFailure(ci.Parsed) == nil {
+				if _, err := connectCloudInitVM(ctx, ci, identity); err != nil {
+					ci.Err = err
+				}
+			}
+		}
+		writeCloudInitSummary(cmd.OutOrStdout(), *FlagVmCloudInitNode, *FlagVmCloudInitUsername, vms)
+		failed := 0
+		for _, ci := range vms {
+			if ci.Err != nil || cloudInitFailure(ci.Parsed) != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d VMs failed", failed, count)
+		}
+		return nil
 	}
 
-	output, err := monitorVMWithOutput(ctx, vm, 3*time.Second, 1*time.Minute, *FlagVmCloudInitVerboseBoot)
-	if err != nil {
-		return fmt.Errorf("failed to get cloudinit output for VM")
+	ci := vms[0]
+	if ci.Err != nil {
+		return ci.Err
 	}
+	vmID, vmName, ciPassword, output := ci.VMID, ci.Name, ci.Password, ci.Output
 	if *FlagVmCloudInitLogMonitorFile != "" {
 		if err := os.WriteFile(*FlagVmCloudInitLogMonitorFile, []byte(output), 0o644); err != nil {
 			return fmt.Errorf("failed to write monitor output to %q: %w", *FlagVmCloudInitLogMonitorFile, err)
@@ -289,54 +740,20 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 	}
 
 	parsedOutput := parseCloudInitLog.ParseCloudInit(output)
-	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "FIELD\tVALUE")
-	fmt.Fprintln(tw, "-----\t-----")
-	fmt.Fprintf(tw, "Hostname\t%s\n", parsedOutput.Hostname)
-	if len(parsedOutput.IPs) == 0 {
-		fmt.Fprintln(tw, "IPs\t(none)")
-	} else {
-		fmt.Fprintf(tw, "IPs\t%s\n", strings.Join(parsedOutput.IPs, ", "))
-	}
-	fmt.Fprintf(tw, "Host Key Hashes\t%d\n", len(parsedOutput.HostKeyHashes))
-	for i, hk := range parsedOutput.HostKeyHashes {
-		fmt.Fprintf(
-			tw,
-			"  [%d] %s\t%s (%s, %s)\n",
-			i+1,
-			hk.KeyType,
-			hk.Fingerprint,
-			hk.Algorithm,
-			hk.Hostname,
-		)
+	writeCloudInitData(cmd.OutOrStdout(), parsedOutput)
+	writeCloudInitProblems(cmd.ErrOrStderr(), parsedOutput)
+
+	fmt.Printf("created and started cloud-init vm %d (%s) on node %s\n", vmID, vmName, *FlagVmCloudInitNode)
+	if err := cloudInitFailure(parsedOutput); err != nil {
+		return fmt.Errorf("VM %d (%s): %w", vmID, vmName, err)
 	}
-	fmt.Fprintf(tw, "Host Keys\t%d\n", len(parsedOutput.HostKeys))
-	for i, key := range parsedOutput.HostKeys {
-		fmt.Fprintf(tw, "  [%d]\t%s\n", i+1, key)
+	addr, err := connectCloudInitVM(ctx, ci, identity)
+	if err != nil {
+		return fmt.Errorf("VM %d (%s): %w", vmID, vmName, err)
 	}
-	fmt.Fprintf(tw, "Authorized SSH Keys\t%d\n", len(parsedOutput.SSHKeyData))
-	if len(parsedOutput.SSHKeyData) == 0 {
-		fmt.Fprintln(tw, "  Users\t(none)")
-	} else {
-		for user, keyData := range parsedOutput.SSHKeyData {
-			fmt.Fprintf(tw, "  User\t%s\n", user)
-			fmt.Fprintf(tw, "    Key Type\t%s\n", keyData.Keytype)
-			fmt.Fprintf(tw, "    Fingerprint\t%s\n", keyData.FingerPrint)
-			if keyData.Options == "" {
-				fmt.Fprintln(tw, "    Options\t(none)")
-			} else {
-				fmt.Fprintf(tw, "    Options\t%s\n", keyData.Options)
-			}
-			if keyData.Comment == "" {
-				fmt.Fprintln(tw, "    Comment\t(none)")
-			} else {
-				fmt.Fprintf(tw, "    Comment\t%s\n", keyData.Comment)
-			}
-		}
+	if addr != "" && !*FlagVmCloudInitDelete {
+		fmt.Printf("log in with: %s\n", sshCommandLine(*FlagVmCloudInitUsername, addr, identity))
 	}
-	_ = tw.Flush()
-
-	log.Printf("created and started cloud-init VM %d (%s) on node %s\n", vmID, vmName, *FlagVmCloudInitNode)
 
 	// If a binary was specified, upload and execute it
 	if binaryPath := strings.TrimSpace(*FlagVmCloudInitBinary); binaryPath != "" {
@@ -360,11 +777,20 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		} else {
 			sshConfig.Password = ciPassword
 		}
+		// Only upload to the VM that printed these host keys.
+		if len(parsedOutput.HostKeys) > 0 {
+			if sshConfig.HostKeyCallback, err = ssh.PinnedHostKeys(parsedOutput.HostKeys); err != nil {
+				return err
+			}
+		} else {
+			slog.Warn("the serial console showed no host keys, trusting the key the VM answers with", "address", vmIP)
+			sshConfig.HostKeyCallback = ssh.TrustFirstKey()
+		}
 
 		sshClient := ssh.NewClient(sshConfig)
 
-		fmt.Printf("waiting for SSH to become available on %s...\n", vmIP)
-		if err := sshClient.WaitForConnection(30, 5*time.Second); err != nil {
+		slog.Info("waiting for SSH", "address", vmIP)
+		if err := sshClient.WaitForConnection(ctx, 30, 5*time.Second); err != nil {
 			return fmt.Errorf("SSH connection failed: %w", err)
 		}
 		defer sshClient.Close()
@@ -375,7 +801,7 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		if !strings.HasSuffix(remotePath, binaryName) {
 			remotePath = filepath.Join(remotePath, binaryName)
 		}
-		fmt.Printf("uploading binary %s to %s:%s...\n", binaryPath, vmIP, remotePath)
+		slog.Info("uploading binary", "path", binaryPath, "address", vmIP, "remote_path", remotePath)
 		if err := sshClient.UploadFile(binaryPath, remotePath); err != nil {
 			return fmt.Errorf("failed to upload binary: %w", err)
 		}
@@ -390,7 +816,7 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 		if args := strings.TrimSpace(*FlagVmCloudInitBinaryArgs); args != "" {
 			execCmd = fmt.Sprintf("%s %s", remotePath, args)
 		}
-		fmt.Printf("executing: %s\n", execCmd)
+		slog.Info("executing binary", "command", execCmd)
 		output, err := sshClient.Execute(execCmd)
 		if err != nil {
 			fmt.Printf("binary execution failed: %v\n", err)
@@ -408,6 +834,115 @@ func command_vm_cloudinit(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// writeCloudInitData prints the data parsed from a serial console as a
+// FIELD/VALUE table.
+func writeCloudInitData(w io.Writer, parsed parseCloudInitLog.CloudInitData) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tVALUE")
+	fmt.Fprintln(tw, "-----\t-----")
+	fmt.Fprintf(tw, "Hostname\t%s\n", parsed.Hostname)
+	if len(parsed.IPs) == 0 {
+		fmt.Fprintln(tw, "IPs\t(none)")
+	} else {
+		fmt.Fprintf(tw, "IPs\t%s\n", strings.Join(parsed.IPs, ", "))
+	}
+	for _, iface := range parsed.Interfaces {
+		value := strings.Join(append([]string{iface.MAC}, iface.Addresses...), " ")
+		if len(iface.Gateways) > 0 {
+			value += " via " + strings.Join(iface.Gateways, ", ")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", iface.Name, strings.TrimSpace(value))
+	}
+	if parsed.Version != "" {
+		fmt.Fprintf(tw, "Cloud-init\t%s\n", parsed.Version)
+	}
+	if parsed.Datasource != "" {
+		fmt.Fprintf(tw, "Datasource\t%s %s\n", parsed.Datasource, parsed.DatasourceDetail)
+	}
+	if parsed.InstanceID != "" {
+		fmt.Fprintf(tw, "Instance ID\t%s\n", parsed.InstanceID)
+	}
+	if len(parsed.Timing.Stages) == 0 {
+		fmt.Fprintln(tw, "Boot Timing\t(none)")
+	} else {
+		fmt.Fprintf(tw, "Boot Timing\t%s\n", parsed.Timing)
+	}
+	if slowest := parsed.Timing.Slowest(5); len(slowest) > 0 {
+		var parts []string
+		for _, m := range slowest {
+			part := fmt.Sprintf("%s %.2fs", m.Name, m.Duration.Seconds())
+			if m.Result != "" && m.Result != "SUCCESS" {
+				part += " (" + m.Result + ")"
+			}
+			parts = append(parts, part)
+		}
+		fmt.Fprintf(tw, "Slowest Modules\t%s\n", strings.Join(parts, ", "))
+	}
+	fmt.Fprintf(tw, "Host Key Hashes\t%d\n", len(parsed.HostKeyHashes))
+	for i, hk := range parsed.HostKeyHashes {
+		fmt.Fprintf(
+			tw,
+			"  [%d] %s\t%s (%s, %s)\n",
+			i+1,
+			hk.KeyType,
+			hk.Fingerprint,
+			hk.Algorithm,
+			hk.Hostname,
+		)
+	}
+	fmt.Fprintf(tw, "Host Keys\t%d\n", len(parsed.HostKeys))
+	for i, key := range parsed.HostKeys {
+		fmt.Fprintf(tw, "  [%d]\t%s\n", i+1, key)
+	}
+	fmt.Fprintf(tw, "Authorized SSH Keys\t%d\n", len(parsed.SSHKeyData))
+	if len(parsed.SSHKeyData) == 0 {
+		fmt.Fprintln(tw, "  Users\t(none)")
+	} else {
+		for user, keyData := range parsed.SSHKeyData {
+			fmt.Fprintf(tw, "  User\t%s\n", user)
+			fmt.Fprintf(tw, "    Key Type\t%s\n", keyData.Keytype)
+			fmt.Fprintf(tw, "    Fingerprint\t%s\n", keyData.FingerPrint)
+			if keyData.Options == "" {
+				fmt.Fprintln(tw, "    Options\t(none)")
+			} else {
+				fmt.Fprintf(tw, "    Options\t%s\n", keyData.Options)
+			}
+			if keyData.Comment == "" {
+				fmt.Fprintln(tw, "    Comment\t(none)")
+			} else {
+				fmt.Fprintf(tw, "    Comment\t%s\n", keyData.Comment)
+			}
+		}
+	}
+	_ = tw.Flush()
+}
+
+// writeCloudInitProblems prints the errors and warnings cloud-init logged,
+// with the traceback of each error.
+func writeCloudInitProblems(w io.Writer, parsed parseCloudInitLog.CloudInitData) {
+	for _, e := range parsed.Errors {
+		fmt.Fprintf(w, "cloud-init error: %s: %s\n", e.Source, e.Message)
+		for _, frame := range e.Traceback {
+			fmt.Fprintf(w, "    %s\n", frame)
+		}
+	}
+	for _, warning := range parsed.Warnings {
+		fmt.Fprintf(w, "cloud-init warning: %s: %s\n", warning.Source, warning.Message)
+	}
+}
+
+// cloudInitFailure returns an error when cloud-init logged errors, so a
+// half set up VM is not reported as a success.
+func cloudInitFailure(parsed parseCloudInitLog.CloudInitData) error {
+	switch len(parsed.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("cloud-init failed: %s: %s", parsed.Errors[0].Source, parsed.Errors[0].Message)
+	}
+	return fmt.Errorf("cloud-init reported %d errors, the first: %s: %s", len(parsed.Errors), parsed.Errors[0].Source, parsed.Errors[0].Message)
+}
+
 func extractDistroVersionFromRelease(release string) (string, string, error) {
 	distro := ""
 	version := ""
@@ -418,7 +953,7 @@ func extractDistroVersionFromRelease(release string) (string, string, error) {
 		}
 		distro = parts[0]
 		version = parts[1]
-		log.Printf("distro: %q version: %q", distro, version)
+		slog.Debug("release", "distro", distro, "version", version)
 
 		// Allow identifying distros by version, e.g. "debian:11"
 		if distro, distroFound := distro_versions[distro]; !distroFound {
@@ -430,68 +965,24 @@ func extractDistroVersionFromRelease(release string) (string, string, error) {
 				}
 			}
 		}
-		log.Printf("distro: %q version: %q", distro, version)
+		slog.Debug("release", "distro", distro, "version", version)
 	}
 	return distro, version, nil
 }
 
+// GetIPFor returns the first IPv4 address the guest agent of vm reports,
+// asking it attempts times delay apart.
 func GetIPFor(ctx context.Context, vm *proxmox.VirtualMachine, attempts int, delay time.Duration) (string, error) {
-	for i := 0; i < attempts; i++ {
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		default:
-		}
-
-		interfaces, err := vm.AgentGetNetworkIFaces(ctx)
-		if err == nil {
-			for _, iface := range interfaces {
-				for _, addr := range iface.IPAddresses {
-					ip := net.ParseIP(addr.IPAddress)
-					if ip == nil {
-						continue
-					}
-
-					// Skip loopback + non-IPv4
-					if ip.IsLoopback() || ip.To4() == nil {
-						continue
-					}
-
-					return ip.String(), nil
-				}
-			}
-		}
-
-		time.Sleep(delay)
-	}
-
-	return "", errors.New("timeout waiting for VM IP address")
-}
-
-func getFnFromCloudImageURL(distro string, version string, release string) (string, error) {
-	switch distro {
-	case "ubuntu":
-		return fmt.Sprintf(
-			"https://cloud-images.ubuntu.com/minimal/daily/%s/current/%s-minimal-cloudimg-amd64.img",
-			version,
-			version,
-		), nil
-	case "debian":
-		debRelease, ok := distro_versions["debian"][version]
-		if !ok {
-			return "", fmt.Errorf("unknown debian release %q in release specifier %q", version, release)
-		}
-		return fmt.Sprintf(
-			"https://cdimage.debian.org/images/cloud/%s/latest/debian-%s-generic-amd64.qcow2",
-			version,
-			debRelease,
-		), nil
-	default:
-		return "", fmt.Errorf("can't recognize distro (ubuntu or debian) in %q from %q", distro, release)
+	addrs, err := waitForAddresses(ctx, vm, 4, attempts, delay)
+	if err != nil {
+		return "", err
 	}
+	return addrs[0], nil
 }
 
-func ensureImportImage(ctx context.Context, storage *proxmox.Storage, filename, imageURL string) error {
+// ensureImportImage downloads imageURL to the import content of storage
+// unless it is already there, reporting download progress to progress.
+func ensureImportImage(ctx context.Context, storage *proxmox.Storage, filename, imageURL string, progress dttproxmox.ProgressFunc) error {
 	content, err := storage.GetContent(ctx)
 	if err != nil {
 		return fmt.Errorf("getting storage content gave err: %w", err)
@@ -502,11 +993,14 @@ func ensureImportImage(ctx context.Context, storage *proxmox.Storage, filename,
 		}
 	}
 
+	if progress != nil {
+		progress(dttproxmox.Progress{Phase: "download", Message: fmt.Sprintf("downloading %s to %s", imageURL, storage.Name), Percent: -1})
+	}
 	task, err := storage.DownloadURL(ctx, "import", filename, imageURL)
 	if err != nil {
 		return fmt.Errorf("downloading image %s gave err: %w", imageURL, err)
 	}
-	if err := task.Wait(ctx, time.Second, 30*time.Minute); err != nil {
+	if err := dttproxmox.WaitTaskLog(ctx, task, time.Second, 30*time.Minute, dttproxmox.MultiTaskLog(getSession().taskLog, dttproxmox.TaskLogProgress("download", progress))); err != nil {
 		return fmt.Errorf("waiting for image download gave err: %w", err)
 	}
 	return nil
@@ -565,6 +1059,21 @@ func extractFn(rawURL string) (string, error) {
 	return path.Base(parsed.Path), nil
 }
 
+// cloudInitSSHKey returns the public key of --sshkey, empty for a key pair
+// made for the run, and checks it against --generate-sshkey, which makes
+// the key pair itself and sets no password.
+func cloudInitSSHKey(sshKey string, generate bool, password, privateKey string) (string, error) {
+	// --sshkey generate asked for the key pair made when --sshkey is unset.
+	if sshKey == "generate" {
+		slog.Warn("--sshkey generate is deprecated, leave --sshkey unset for a key pair for this run, or use --generate-sshkey to keep it")
+		sshKey = ""
+	}
+	if generate && (password != "" || sshKey != "" || privateKey != "") {
+		return "", fmt.Errorf("%w: --generate-sshkey makes the key and sets no password, it can't be combined with --password, --sshkey or --ssh-private-key", ErrUsage)
+	}
+	return sshKey, nil
+}
+
 // generateSSHKeyPair generates an Ed25519 SSH key pair and returns the public key string
 // and the path to the private key file. The private key is written to a temp file.
 func generateSSHKeyPair() (publicKey string, privateKeyPath string, cleanup func(), err error) {
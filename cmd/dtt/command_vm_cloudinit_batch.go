@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/luthermonson/go-proxmox"
+)
+
+// cloudInitSetup is what all VMs of one vm cloudinit invocation share.
+type cloudInitSetup struct {
+	Node          *proxmox.Node
+	CloudImageURL string
+	ImportVolID   string
+	SSHPublicKey  string
+	BalloonOpts   []proxmox.VirtualMachineOption
+	NetworkOpts   []proxmox.VirtualMachineOption
+	DataDiskOpts  []proxmox.VirtualMachineOption
+	CICustom      string // cicustom of the --user-data and --vendor-data snippets
+	VerboseBoot   bool
+}
+
+// cloudInitVM is one VM to provision, and the outcome once provisioned.
+type cloudInitVM struct {
+	VMID     int
+	Name     string
+	Password string
+
+	Output []byte
+	Parsed parseCloudInitLog.CloudInitData
+	Err    error
+
+	// vm is set once the VM exists, even if a later step failed.
+	vm *proxmox.VirtualMachine
+}
+
+// cloudInitVMName names the i-th of count VMs.
+func cloudInitVMName(release string, vmID, i, count int) string {
+	if count == 1 && *FlagVmCloudInitName != "" {
+		return *FlagVmCloudInitName
+	}
+	if *FlagVmCloudInitNamePrefix != "" {
+		if count == 1 {
+			return *FlagVmCloudInitNamePrefix
+		}
+		return fmt.Sprintf("%s-%d", *FlagVmCloudInitNamePrefix, i+1)
+	}
+	return fmt.Sprintf("dtt-%s-%d", strings.Replace(release, ":", "-", -1), vmID)
+}
+
+// sequentialFreeVMIDs returns the first count consecutive VMIDs from start on
+// that are not in used.
+func sequentialFreeVMIDs(used map[int]bool, start, count int) []int {
+	ids := make([]int, 0, count)
+	for id := start; len(ids) < count; id++ {
+		if used[id] {
+			ids = ids[:0]
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// provisionCloudInitVMs provisions vms with at most parallel at a time. The
+// outcome of each is recorded in the VM.
+func provisionCloudInitVMs(ctx context.Context, setup *cloudInitSetup, vms []*cloudInitVM, parallel int) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, ci := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ci.Err = provisionCloudInitVM(ctx, setup, ci)
+			if ci.Err != nil && len(vms) > 1 {
+				slog.Error("provisioning VM failed", "vmid", ci.VMID, "name", ci.Name, "err", ci.Err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// provisionCloudInitVM creates, configures and starts one VM and reads its
+// cloud-init output from the serial console.
+func provisionCloudInitVM(ctx context.Context, setup *cloudInitSetup, ci *cloudInitVM) error {
+	node := setup.Node
+
+	opts := []proxmox.VirtualMachineOption{
+		proxmox.VirtualMachineOption{Name: "name", Value: ci.Name},
+		proxmox.VirtualMachineOption{Name: "memory", Value: *FlagVmCloudInitMemory},
+		proxmox.VirtualMachineOption{Name: "cores", Value: *FlagVmCloudInitCores},
+		proxmox.VirtualMachineOption{Name: "sockets", Value: 1},
+		proxmox.VirtualMachineOption{Name: "ostype", Value: "l26"},
+		proxmox.VirtualMachineOption{Name: "scsihw", Value: "virtio-scsi-pci"},
+		proxmox.VirtualMachineOption{Name: "serial0", Value: "socket"},
+		proxmox.VirtualMachineOption{Name: "vga", Value: "serial0"},
+		proxmox.VirtualMachineOption{Name: "agent", Value: "enabled=1"},
+	}
+	opts = append(opts, managedOptions(*FlagVmCloudInitDescription, setup.CloudImageURL, "cloudinit", *FlagVmCloudInitUsername, *FlagVmCloudInitTTL, "dtt-cloudinit")...)
+	for i, netdev := range *FlagVmCloudInitNetworkDevice {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: fmt.Sprintf("net%d", i), Value: netdev})
+	}
+	if *FlagVmCloudInitPool != "" {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "pool", Value: *FlagVmCloudInitPool})
+	}
+	opts = append(opts, setup.BalloonOpts...)
+	slog.Debug("creating VM", "vmid", ci.VMID, "options", opts)
+
+	createTask, err := node.NewVirtualMachine(
+		ctx,
+		ci.VMID,
+		opts...,
+	)
+	if err != nil {
+		return fmt.Errorf("creating cloud-init VM %d gave err: %w", ci.VMID, err)
+	}
+	if err := waitTask(ctx, createTask, time.Second, 2*time.Minute); err != nil {
+		return fmt.Errorf("waiting for cloud-init VM creation gave err: %w", err)
+	}
+
+	vm, err := node.VirtualMachine(ctx, ci.VMID)
+	if err != nil {
+		return fmt.Errorf("getting cloud-init VM %d gave err: %w", ci.VMID, err)
+	}
+	ci.vm = vm
+
+	slog.Info("configuring boot drive and cloud-init", "vmid", vm.VMID, "name", vm.Name)
+	configOpts := []proxmox.VirtualMachineOption{
+		proxmox.VirtualMachineOption{Name: "scsi0", Value: fmt.Sprintf("%s:0,import-from=%s", *FlagVmCloudInitStorage, setup.ImportVolID)},
+		proxmox.VirtualMachineOption{Name: "boot", Value: "order=scsi0"},
+		proxmox.VirtualMachineOption{Name: "ide2", Value: fmt.Sprintf("%s:cloudinit", *FlagVmCloudInitStorage)},
+		proxmox.VirtualMachineOption{Name: "ciuser", Value: *FlagVmCloudInitUsername},
+	}
+	if ci.Password != "" {
+		configOpts = append(configOpts, proxmox.VirtualMachineOption{Name: "cipassword", Value: ci.Password})
+	}
+	configOpts = append(configOpts, setup.DataDiskOpts...)
+	configOpts = append(configOpts, setup.NetworkOpts...)
+	if setup.CICustom != "" {
+		configOpts = append(configOpts, proxmox.VirtualMachineOption{Name: "cicustom", Value: setup.CICustom})
+	}
+	if sshKey := strings.TrimSpace(setup.SSHPublicKey); sshKey != "" {
+		enc := url.QueryEscape(sshKey)            // makes spaces into +
+		enc = strings.ReplaceAll(enc, "+", "%20") // turn the + encoded spaces into %20
+
+		slog.Debug("passing in sshkeys", "sshkeys", enc)
+
+		configOpts = append(configOpts, proxmox.VirtualMachineOption{Name: "sshkeys", Value: enc})
+	}
+	configTask, err := vm.Config(ctx, configOpts...)
+	if err != nil {
+		return fmt.Errorf("configuring cloud-init VM gave err: %w", err)
+	}
+	if err := waitTask(ctx, configTask, time.Second, 5*time.Minute); err != nil {
+		return fmt.Errorf("waiting for cloud-init config gave err: %w", err)
+	}
+
+	resizeTask, err := vm.ResizeDisk(ctx, "scsi0", *FlagVmCloudInitDiskSize)
+	if err != nil {
+		return fmt.Errorf("resizing cloud-init VM disk gave err: %w", err)
+	}
+	if err := waitTask(ctx, resizeTask, time.Second, 2*time.Minute); err != nil {
+		return fmt.Errorf("waiting for disk resize gave err: %w", err)
+	}
+
+	startTask, err := vm.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("starting cloud-init VM gave err: %w", err)
+	}
+	if err := waitTask(ctx, startTask, time.Second, 2*time.Minute); err != nil {
+		return fmt.Errorf("waiting for cloud-init VM start gave err: %w", err)
+	}
+
+	var bootOutput io.Writer
+	if setup.VerboseBoot {
+		bootOutput = os.Stdout
+	}
+	output, err := monitorVMWithOutput(ctx, vm, 3*time.Second, 1*time.Minute, bootOutput, cloudInitDone)
+	if err != nil {
+		return fmt.Errorf("getting cloud-init output of VM %d gave err: %w", uint64(vm.VMID), err)
+	}
+	ci.Output = output
+	ci.Parsed = parseCloudInitLog.ParseCloudInit(output)
+	return nil
+}
+
+// deleteCloudInitVM stops and deletes a VM, for --delete. Failures are only
+// reported, the command's own result matters more.
+func deleteCloudInitVM(ctx context.Context, vm *proxmox.VirtualMachine) {
+	slog.Info("deleting VM", "vmid", vm.VMID)
+	// Stop the VM first if it's running
+	if stopTask, err := vm.Stop(ctx); err == nil {
+		_ = waitTask(ctx, stopTask, time.Second, 30*time.Second)
+	}
+	if deleteTask, err := vm.Delete(ctx); err != nil {
+		slog.Warn("failed to delete VM", "vmid", vm.VMID, "err", err)
+	} else {
+		if err := waitTask(ctx, deleteTask, time.Second, 30*time.Second); err != nil {
+			slog.Warn("failed waiting for VM deletion", "vmid", vm.VMID, "err", err)
+		} else {
+			slog.Info("deleted VM", "vmid", vm.VMID)
+		}
+	}
+}
+
+// writeCloudInitSummary writes a table of the VMs created with --count.
+func writeCloudInitSummary(w io.Writer, node, username string, vms []*cloudInitVM) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVMID\tNODE\tIP\tUSERNAME\tPASSWORD\tBOOT\tSTATUS")
+	for _, ci := range vms {
+		ip := "-"
+		if len(ci.Parsed.IPs) > 0 {
+			ip = ci.Parsed.IPs[0]
+		}
+		status := "ok"
+		if ci.Err != nil {
+			status = fmt.Sprintf("failed: %v", ci.Err)
+		} else if n := len(ci.Parsed.Errors); n > 0 {
+			status = fmt.Sprintf("cloud-init errors: %d", n)
+		}
+		boot := "-"
+		if finished := ci.Parsed.Timing.Finished; finished > 0 {
+			boot = fmt.Sprintf("%.1fs", finished.Seconds())
+		}
+		password := ci.Password
+		if password == "" {
+			password = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", ci.Name, ci.VMID, node, ip, username, password, boot, status)
+	}
+	_ = tw.Flush()
+}
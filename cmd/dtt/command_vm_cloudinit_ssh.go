@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/ssh"
+)
+
+// defaultSSHConfig is what --ssh-config writes to without a path.
+const defaultSSHConfig = "~/.ssh/config"
+
+// sshAddress returns the address to SSH to a VM on, preferring IPv4. It
+// returns "" when the VM has no usable address.
+func sshAddress(addrs []string) string {
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// sshCommandLine returns the ssh command to log in to a VM with.
+func sshCommandLine(user, addr, identity string) string {
+	if identity != "" {
+		return fmt.Sprintf("ssh -i %s %s@%s", identity, user, addr)
+	}
+	return fmt.Sprintf("ssh %s@%s", user, addr)
+}
+
+// sshConfigBlock returns an ssh_config Host block, so ssh <name> logs in to
+// a VM.
+func sshConfigBlock(name, user, addr, identity string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", name)
+	fmt.Fprintf(&b, "    HostName %s\n", addr)
+	fmt.Fprintf(&b, "    User %s\n", user)
+	if identity != "" {
+		fmt.Fprintf(&b, "    IdentityFile %s\n", identity)
+	}
+	return b.String()
+}
+
+// appendSSHConfig appends block to the ssh_config file at path, which may
+// start with ~/, after a blank line. It returns the expanded path.
+func appendSSHConfig(path, block string) (string, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "\n%s", block); err != nil {
+		f.Close()
+		return "", fmt.Errorf("writing %s gave err: %w", path, err)
+	}
+	return path, f.Close()
+}
+
+// connectCloudInitVM does what the flags ask for once ci is up: writing its
+// host keys to known_hosts, waiting for SSH to answer with them and adding a
+// Host block for it to an ssh_config file. It returns the address to SSH to,
+// "" when the VM has none.
+func connectCloudInitVM(ctx context.Context, ci *cloudInitVM, identity string) (string, error) {
+	if *FlagVmCloudInitKnownHosts != "" {
+		path, err := writeKnownHosts(*FlagVmCloudInitKnownHosts, ci.Parsed)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("added the host keys of VM %d to %s\n", ci.VMID, path)
+	}
+
+	addr := sshAddress(vmAddresses(ci.Parsed))
+	if addr == "" {
+		if *FlagVmCloudInitWaitSSH > 0 || *FlagVmCloudInitSSHConfig != "" {
+			return "", fmt.Errorf("no address found for VM %d to SSH to", ci.VMID)
+		}
+		return "", nil
+	}
+
+	if wait := *FlagVmCloudInitWaitSSH; wait > 0 {
+		slog.Info("waiting for SSH", "address", addr)
+		waitCtx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		if err := ssh.WaitForHostKey(waitCtx, net.JoinHostPort(addr, "22"), ci.Parsed.HostKeys, 2*time.Second); err != nil {
+			return "", err
+		}
+	}
+
+	if *FlagVmCloudInitSSHConfig != "" {
+		path, err := appendSSHConfig(*FlagVmCloudInitSSHConfig, sshConfigBlock(ci.Name, *FlagVmCloudInitUsername, addr, identity))
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("added Host %s to %s\n", ci.Name, path)
+	}
+	return addr, nil
+}
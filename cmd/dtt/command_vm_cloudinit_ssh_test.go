@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSSHSummary(t *testing.T) {
+	if got := sshAddress([]string{"2a02:aa14::1", "192.168.1.191"}); got != "192.168.1.191" {
+		t.Errorf("Expected the IPv4 address to be preferred, got %q", got)
+	}
+	if got := sshAddress([]string{"2a02:aa14::1"}); got != "2a02:aa14::1" {
+		t.Errorf("Expected the IPv6 address without IPv4, got %q", got)
+	}
+	if got := sshCommandLine("dtt", "192.168.1.191", "/home/me/.ssh/id"); got != "ssh -i /home/me/.ssh/id dtt@192.168.1.191" {
+		t.Errorf("Unexpected ssh command line %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("Host *\n    ServerAliveInterval 30\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendSSHConfig(path, sshConfigBlock("vm1", "dtt", "192.168.1.191", "")); err != nil {
+		t.Fatalf("appendSSHConfig gave err: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Host *\n    ServerAliveInterval 30\n\nHost vm1\n    HostName 192.168.1.191\n    User dtt\n"
+	if string(got) != want {
+		t.Errorf("ssh_config = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestSequentialFreeVMIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		used  []int
+		start int
+		count int
+		want  []int
+	}{
+		{name: "nothing used", start: 100, count: 3, want: []int{100, 101, 102}},
+		{name: "gap too small", used: []int{102}, start: 100, count: 3, want: []int{103, 104, 105}},
+		{name: "gap big enough", used: []int{104}, start: 100, count: 3, want: []int{100, 101, 102}},
+		{name: "start used", used: []int{100, 101}, start: 100, count: 2, want: []int{102, 103}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := map[int]bool{}
+			for _, id := range tt.used {
+				used[id] = true
+			}
+			if got := sequentialFreeVMIDs(used, tt.start, tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sequentialFreeVMIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudInitFailure(t *testing.T) {
+	if err := cloudInitFailure(parseCloudInitLog.CloudInitData{}); err != nil {
+		t.Errorf("Expected no failure without errors, got %v", err)
+	}
+
+	parsed := parseCloudInitLog.CloudInitData{
+		Errors: []parseCloudInitLog.CloudInitError{
+			{Source: "scripts-user", Message: "failed", Traceback: []string{`File "x.py", line 1`}},
+			{Source: "status", Message: "status: error"},
+		},
+		Warnings: []parseCloudInitLog.CloudInitError{{Source: "ug_util.py", Message: "DEPRECATED"}},
+	}
+	err := cloudInitFailure(parsed)
+	if err == nil || !strings.Contains(err.Error(), "2 errors") || !strings.Contains(err.Error(), "scripts-user") {
+		t.Errorf("Expected a failure naming the first error, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeCloudInitProblems(&buf, parsed)
+	want := "cloud-init error: scripts-user: failed\n    File \"x.py\", line 1\ncloud-init error: status: status: error\ncloud-init warning: ug_util.py: DEPRECATED\n"
+	if buf.String() != want {
+		t.Errorf("writeCloudInitProblems wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestHandleExistingCloudInitVM(t *testing.T) {
+	saved := *FlagVmCloudInitIfExists
+	t.Cleanup(func() { *FlagVmCloudInitIfExists = saved })
+	ctx := context.Background()
+
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "build", Status: "stopped", Tags: "dtt",
+		Config: map[string]interface{}{"ciuser": "ci"}})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "db", Status: "running", Tags: "prod"})
+	sess := newSession(server.Client(), newAPICache(0, true))
+
+	*FlagVmCloudInitIfExists = "fail"
+	if reused, err := handleExistingCloudInitVM(ctx, sess, &bytes.Buffer{}, "db"); reused || err != nil {
+		t.Errorf("Expected a VM dtt doesn't manage to be ignored, got %v, %v", reused, err)
+	}
+	if _, err := handleExistingCloudInitVM(ctx, sess, &bytes.Buffer{}, "build"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected --if-exists fail to fail, got %v", err)
+	}
+
+	*FlagVmCloudInitIfExists = "reuse"
+	var out bytes.Buffer
+	reused, err := handleExistingCloudInitVM(ctx, sess, &out, "build")
+	if !reused || err != nil {
+		t.Fatalf("Expected the VM to be reused, got %v, %v", reused, err)
+	}
+	if !strings.Contains(out.String(), "reusing VM 101") || server.VM(101).Status != "running" {
+		t.Errorf("Expected the stopped VM to be started and reused, got %q, status %s", out.String(), server.VM(101).Status)
+	}
+
+	*FlagVmCloudInitIfExists = "recreate"
+	sess = newSession(server.Client(), newAPICache(0, true))
+	if reused, err := handleExistingCloudInitVM(ctx, sess, &bytes.Buffer{}, "build"); reused || err != nil {
+		t.Fatalf("Expected the VM to be deleted for recreation, got %v, %v", reused, err)
+	}
+	if server.VM(101) != nil {
+		t.Error("Expected VM 101 to be deleted")
+	}
+}
+
+func TestCloudInitNetworkOptions(t *testing.T) {
+	tests := []struct {
+		name                                    string
+		nets                                    int
+		ips, gateways, ip6s, dns, searchDomains []string
+		want                                    map[string]string
+		wantErr                                 string
+	}{
+		{name: "default", nets: 1, want: map[string]string{"ipconfig0": "ip=dhcp,ip6=auto"}},
+		{name: "second device unconfigured", nets: 2, want: map[string]string{"ipconfig0": "ip=dhcp,ip6=auto"}},
+		{
+			name: "static", nets: 2,
+			ips: []string{"192.168.1.10/24", "10.0.0.5/8"}, gateways: []string{"192.168.1.1"}, ip6s: []string{"2001:db8::10/64"},
+			dns: []string{"1.1.1.1", "2606:4700:4700::1111"}, searchDomains: []string{"lab.example", "example"},
+			want: map[string]string{
+				"ipconfig0":    "ip=192.168.1.10/24,gw=192.168.1.1,ip6=2001:db8::10/64",
+				"ipconfig1":    "ip=10.0.0.5/8",
+				"nameserver":   "1.1.1.1 2606:4700:4700::1111",
+				"searchdomain": "lab.example example",
+			},
+		},
+		{name: "too many ips", nets: 1, ips: []string{"dhcp", "dhcp"}, wantErr: "2 --ip for 1 --net devices"},
+		{name: "bad ip", nets: 1, ips: []string{"192.168.1.10"}, wantErr: "not dhcp or an IPv4 address"},
+		{name: "ipv6 as ip", nets: 1, ips: []string{"2001:db8::10/64"}, wantErr: "not dhcp or an IPv4 address"},
+		{name: "gateway with dhcp", nets: 1, gateways: []string{"192.168.1.1"}, wantErr: "needs a static --ip"},
+		{name: "bad ip6", nets: 1, ip6s: []string{"192.168.1.10/24"}, wantErr: "not auto, dhcp or an IPv6 address"},
+		{name: "bad dns", nets: 1, dns: []string{"dns.example"}, wantErr: "--dns \"dns.example\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := cloudInitNetworkOptions(tt.nets, tt.ips, tt.gateways, tt.ip6s, tt.dns, tt.searchDomains)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("cloudInitNetworkOptions gave err %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cloudInitNetworkOptions gave err: %v", err)
+			}
+			got := map[string]string{}
+			for _, opt := range opts {
+				got[opt.Name] = opt.Value.(string)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cloudInitNetworkOptions gave %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadCloudInitSnippets(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve").StorageContent["local"] = "iso,snippets"
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	if ciCustom, err := uploadCloudInitSnippets(ctx, sess, "pve", "local", "", ""); ciCustom != "" || err != nil {
+		t.Errorf("Expected no cicustom without files, got %q, %v", ciCustom, err)
+	}
+
+	vendorData := filepath.Join(t.TempDir(), "vendor.yaml")
+	if err := os.WriteFile(vendorData, []byte("#cloud-config\nruncmd: [true]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ciCustom, err := uploadCloudInitSnippets(ctx, sess, "pve", "local", "", vendorData)
+	if err != nil {
+		t.Fatalf("uploadCloudInitSnippets gave err: %v", err)
+	}
+	if !strings.HasPrefix(ciCustom, "vendor=local:snippets/dtt-") || strings.Contains(ciCustom, "user=") {
+		t.Errorf("Expected only a vendor snippet, got %q", ciCustom)
+	}
+
+	if _, err := uploadCloudInitSnippets(ctx, sess, "pve", "local-lvm", vendorData, ""); err == nil || !strings.Contains(err.Error(), "uploading user-data") {
+		t.Errorf("Expected a storage without snippets to fail the user-data, got %v", err)
+	}
+}
+
+func TestCloudInitVendorData(t *testing.T) {
+	if data, err := cloudInitVendorData(nil, nil, nil, nil); data != "" || err != nil {
+		t.Errorf("Expected no vendor-data without flags, got %q, %v", data, err)
+	}
+
+	local := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(local, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cloudInitVendorData([]string{"make"}, []string{"/opt/run.sh"}, []string{"/opt/run.sh=" + local}, nil)
+	if err != nil {
+		t.Fatalf("cloudInitVendorData gave err: %v", err)
+	}
+	for _, want := range []string{"#cloud-config\n", "packages:\n  - make\n", "runcmd:\n  - /opt/run.sh\n", "  - path: /opt/run.sh\n", "    permissions: \"0755\"\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("Expected the vendor-data to contain %q, got:\n%s", want, data)
+		}
+	}
+	if strings.Contains(data, "users:") {
+		t.Errorf("Expected no users in the vendor-data, got:\n%s", data)
+	}
+
+	for _, f := range []string{"run.sh", "opt/run.sh=" + local, "/opt/run.sh=", "/opt/run.sh=" + local + ".missing"} {
+		if _, err := cloudInitVendorData(nil, nil, []string{f}, nil); !errors.Is(err, ErrUsage) {
+			t.Errorf("Expected --write-file %q to be a usage error, got %v", f, err)
+		}
+	}
+	if _, err := cloudInitVendorData([]string{"curl wget"}, nil, nil, nil); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected an invalid package to be a usage error, got %v", err)
+	}
+}
+
+func TestParseDataDisks(t *testing.T) {
+	disks, err := parseDataDisks([]string{"size=50G,mount=/data", "size=1500M,mount=/srv/db,fs=xfs,storage=fast"}, "local-lvm")
+	if err != nil {
+		t.Fatalf("parseDataDisks gave err: %v", err)
+	}
+	want := []dataDisk{
+		{SizeGiB: 50, Mount: "/data", Filesystem: "ext4", Storage: "local-lvm"},
+		{SizeGiB: 2, Mount: "/srv/db", Filesystem: "xfs", Storage: "fast"},
+	}
+	if !reflect.DeepEqual(disks, want) {
+		t.Errorf("parseDataDisks gave %+v, want %+v", disks, want)
+	}
+
+	opts := dataDiskOptions(disks)
+	if len(opts) != 2 || opts[0].Name != "scsi1" || opts[0].Value != "local-lvm:50" || opts[1].Name != "scsi2" || opts[1].Value != "fast:2" {
+		t.Errorf("dataDiskOptions gave %+v", opts)
+	}
+
+	data, err := cloudInitVendorData(nil, nil, nil, disks)
+	if err != nil {
+		t.Fatalf("cloudInitVendorData gave err: %v", err)
+	}
+	for _, want := range []string{"  /dev/disk/by-id/scsi-0QEMU_QEMU_HARDDISK_drive-scsi2:\n", "  - - LABEL=data1\n    - /data\n", "    filesystem: xfs\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("Expected the vendor-data to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	for _, spec := range []string{"mount=/data", "size=+10G,mount=/data", "size=10G", "size=10G,mount=/", "size=10G,mount=/data,fs=ntfs", "size=10G,mount=/data,type=ssd"} {
+		if _, err := parseDataDisks([]string{spec}, "local"); !errors.Is(err, ErrUsage) {
+			t.Errorf("Expected --data-disk %q to be a usage error, got %v", spec, err)
+		}
+	}
+	if _, err := parseDataDisks([]string{"size=1G,mount=/data", "size=2G,mount=/data"}, "local"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected two disks on one mount point to be a usage error, got %v", err)
+	}
+}
+
+func TestCloudInitSSHKey(t *testing.T) {
+	for _, tt := range []struct {
+		sshKey             string
+		generate           bool
+		password, identity string
+		want               string
+		wantErr            bool
+	}{
+		{sshKey: "", want: ""},
+		{sshKey: "generate", want: ""},
+		{sshKey: "ssh-ed25519 AAAA", want: "ssh-ed25519 AAAA"},
+		{sshKey: "", generate: true, want: ""},
+		{sshKey: "generate", generate: true, want: ""},
+		{sshKey: "ssh-ed25519 AAAA", generate: true, wantErr: true},
+		{generate: true, password: "secret", wantErr: true},
+		{generate: true, identity: "~/.ssh/id_ed25519", wantErr: true},
+	} {
+		got, err := cloudInitSSHKey(tt.sshKey, tt.generate, tt.password, tt.identity)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUsage) {
+				t.Errorf("cloudInitSSHKey(%+v) gave %v, want a usage error", tt, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("cloudInitSSHKey(%+v) = %q, %v, want %q", tt, got, err, tt.want)
+		}
+	}
+}
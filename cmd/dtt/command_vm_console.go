@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmConsoleCommand = &cobra.Command{
+		Use:   "console <name-or-id>",
+		Short: "attach to the serial console of a vm",
+		Long: `Attach the terminal to the serial console of a VM, to log in or watch it
+boot. Unlike dtt vm monitor, what is typed goes to the VM, Ctrl-C included.
+Press the --escape key, Ctrl-] unless set, to detach; the VM keeps running.
+
+  dtt vm console web
+  dtt vm console web --escape ^A`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_console,
+	}
+
+	FlagVmConsoleNode   *string
+	FlagVmConsoleEscape *string
+)
+
+func init() {
+	FlagVmConsoleNode = vmConsoleCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmConsoleEscape = vmConsoleCommand.PersistentFlags().String("escape", "^]", "key to detach with, as ^ and a letter or one of @[\\]^_")
+
+	vmCommand.AddCommand(vmConsoleCommand)
+}
+
+// parseEscapeKey returns the control character s names, such as 0x1d for
+// "^]".
+func parseEscapeKey(s string) (byte, error) {
+	if len(s) != 2 || s[0] != '^' {
+		return 0, fmt.Errorf("%w: --escape %q is not ^ and a key, such as ^]", ErrUsage, s)
+	}
+	c := s[1]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c < '@' || c > '_' {
+		return 0, fmt.Errorf("%w: --escape %q is no control key, use ^ and a letter or one of @[\\]^_", ErrUsage, s)
+	}
+	return c & 0x1f, nil
+}
+
+// The termproxy behind the serial console frames what is typed as
+// 0:<length>:<data>, the terminal size as 1:<columns>:<rows>: and a
+// keepalive as 2. Output comes unframed.
+func termInput(p []byte) []byte {
+	return append([]byte(fmt.Sprintf("0:%d:", len(p))), p...)
+}
+
+func termResize(cols, rows int) []byte {
+	return []byte(fmt.Sprintf("1:%d:%d:", cols, rows))
+}
+
+// consoleConn is the part of the console websocket attachConsole uses.
+type consoleConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+}
+
+// openConsole opens the serial console of vm and logs in to its termproxy.
+func openConsole(ctx context.Context, vm *proxmox.VirtualMachine) (*websocket.Conn, error) {
+	term, err := vm.TermProxy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating terminal proxy gave err: %w", err)
+	}
+	conn, err := vm.TermWebSocketConn(term)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the serial console gave err: %w", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte(term.User+":"+term.Ticket+"\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("logging in to the serial console gave err: %w", err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "OK" {
+		conn.Close()
+		if err == nil {
+			err = fmt.Errorf("termproxy answered %q", msg)
+		}
+		return nil, fmt.Errorf("logging in to the serial console gave err: %w", err)
+	}
+	return conn, nil
+}
+
+// errDetached is returned by attachConsole when the escape key was typed.
+var errDetached = errors.New("detached")
+
+// attachConsole copies the output of conn to out and what is read from in to
+// conn, sending the terminal size first unless cols is 0 and a keepalive
+// every keepalive. It returns errDetached when escape is read, nil when in
+// ends, and the error of conn when the console goes away. The caller closes
+// conn to stop the goroutine reading it.
+func attachConsole(conn consoleConn, in io.Reader, out io.Writer, escape byte, cols, rows int, keepalive time.Duration) error {
+	if cols > 0 && rows > 0 {
+		if err := conn.WriteMessage(websocket.BinaryMessage, termResize(cols, rows)); err != nil {
+			return err
+		}
+	}
+
+	output := make(chan error, 1)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err == nil {
+				_, err = out.Write(msg)
+			}
+			if err != nil {
+				output <- err
+				return
+			}
+		}
+	}()
+
+	// Reading in can't be interrupted, the goroutine ends with the process
+	// when it still waits for input.
+	input := make(chan []byte)
+	inputErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				input <- bytes.Clone(buf[:n])
+			}
+			if err != nil {
+				inputErr <- err
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-output:
+			return fmt.Errorf("console closed: %w", err)
+		case p := <-input:
+			i := bytes.IndexByte(p, escape)
+			if i >= 0 {
+				p = p[:i]
+			}
+			if len(p) > 0 {
+				if err := conn.WriteMessage(websocket.BinaryMessage, termInput(p)); err != nil {
+					return err
+				}
+			}
+			if i >= 0 {
+				return errDetached
+			}
+		case err := <-inputErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte("2")); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stty runs stty on the terminal of stdin.
+func stty(args ...string) (string, error) {
+	c := exec.Command("stty", args...)
+	c.Stdin = os.Stdin
+	out, err := c.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// rawTerminal puts the terminal of stdin in raw mode, so keys such as Ctrl-C
+// go to the VM instead of to dtt, and returns a func restoring it.
+func rawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("saving the terminal settings with stty gave err: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("switching the terminal to raw mode with stty gave err: %w", err)
+	}
+	return func() { stty(saved) }, nil
+}
+
+// terminalSize returns the columns and rows of the terminal of stdin, 0 when
+// it can't tell.
+func terminalSize() (cols, rows int) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0
+	}
+	if _, err := fmt.Sscan(out, &rows, &cols); err != nil {
+		return 0, 0
+	}
+	return cols, rows
+}
+
+func command_vm_console(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	escape, err := parseEscapeKey(*FlagVmConsoleEscape)
+	if err != nil {
+		return err
+	}
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmConsoleNode)
+	if err != nil {
+		return err
+	}
+	if !vm.IsRunning() {
+		return fmt.Errorf("vm %d (%s) is %s, start it with dtt vm start", vm.VMID, vm.Name, vm.Status)
+	}
+
+	conn, err := openConsole(ctx, vm)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var cols, rows int
+	if isTerminal(os.Stdin) {
+		restore, err := rawTerminal()
+		if err != nil {
+			return err
+		}
+		defer restore()
+		cols, rows = terminalSize()
+		// Raw mode needs the carriage return.
+		fmt.Fprintf(cmd.ErrOrStderr(), "attached to the console of vm %d (%s), press %s to detach\r\n", vm.VMID, vm.Name, *FlagVmConsoleEscape)
+	}
+
+	err = attachConsole(conn, os.Stdin, cmd.OutOrStdout(), escape, cols, rows, 30*time.Second)
+	if errors.Is(err, errDetached) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "\r\ndetached from the console of vm %d\r\n", vm.VMID)
+		return nil
+	}
+	return err
+}
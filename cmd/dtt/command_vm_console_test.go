@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseEscapeKey(t *testing.T) {
+	for s, want := range map[string]byte{"^]": 0x1d, "^A": 0x01, "^a": 0x01, "^@": 0x00, "^_": 0x1f} {
+		if got, err := parseEscapeKey(s); err != nil || got != want {
+			t.Errorf("parseEscapeKey(%q) = %#x, %v, want %#x", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "]", "^", "^1", "^]x", "C-a"} {
+		if _, err := parseEscapeKey(s); !errors.Is(err, ErrUsage) {
+			t.Errorf("Expected parseEscapeKey(%q) to be invalid usage, got %v", s, err)
+		}
+	}
+}
+
+// fakeTermProxy serves console output and records what is written to it.
+type fakeTermProxy struct {
+	output chan []byte
+
+	mu      sync.Mutex
+	written []string
+}
+
+func (c *fakeTermProxy) ReadMessage() (int, []byte, error) {
+	msg, ok := <-c.output
+	if !ok {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 2, msg, nil
+}
+
+func (c *fakeTermProxy) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, string(data))
+	return nil
+}
+
+func TestAttachConsole(t *testing.T) {
+	conn := &fakeTermProxy{output: make(chan []byte)}
+	defer close(conn.output)
+
+	err := attachConsole(conn, strings.NewReader("ls\n\x1dignored"), io.Discard, 0x1d, 80, 24, time.Minute)
+	if !errors.Is(err, errDetached) {
+		t.Fatalf("Expected to detach on the escape key, got %v", err)
+	}
+	want := []string{"1:80:24:", "0:3:ls\n"}
+	if strings.Join(conn.written, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected the size and the input before the escape key, got %q", conn.written)
+	}
+}
+
+func TestAttachConsoleClosed(t *testing.T) {
+	conn := &fakeTermProxy{output: make(chan []byte, 1)}
+	conn.output <- []byte("login: ")
+	close(conn.output)
+
+	var out strings.Builder
+	in, _ := io.Pipe()
+	if err := attachConsole(conn, in, &out, 0x1d, 0, 0, time.Minute); err == nil {
+		t.Error("Expected an error once the console goes away")
+	}
+	if out.String() != "login: " {
+		t.Errorf("Expected the output of the console, got %q", out.String())
+	}
+}
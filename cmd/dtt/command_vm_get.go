@@ -3,10 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
-	"strconv"
+	"io"
 	"text/tabwriter"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
 
@@ -17,98 +18,89 @@ var (
 		Args:  cobra.ExactArgs(1),
 		RunE:  command_vm_get,
 	}
+
+	FlagVmGetNode   *string
+	FlagVmGetOutput *string
 )
 
 func init() {
+	FlagVmGetNode = vmGetCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmGetOutput = addOutputFlag(vmGetCommand)
 	vmCommand.AddCommand(vmGetCommand)
 }
 
+// vmDetails is what vm get shows about a VM.
+type vmDetails struct {
+	ID          string          `json:"id" yaml:"id"`
+	Node        string          `json:"node" yaml:"node"`
+	VMID        uint64          `json:"vmid" yaml:"vmid"`
+	Name        string          `json:"name" yaml:"name"`
+	Status      string          `json:"status" yaml:"status"`
+	CPU         float64         `json:"cpu" yaml:"cpu"`
+	Mem         uint64          `json:"mem" yaml:"mem"`
+	MaxMem      uint64          `json:"max_mem" yaml:"max_mem"`
+	Disk        uint64          `json:"disk" yaml:"disk"`
+	MaxDisk     uint64          `json:"max_disk" yaml:"max_disk"`
+	Uptime      uint64          `json:"uptime" yaml:"uptime"`
+	Template    bool            `json:"template" yaml:"template"`
+	Pool        string          `json:"pool,omitempty" yaml:"pool,omitempty"`
+	Tags        string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Balloon     *vmBalloonState `json:"balloon" yaml:"balloon"`
+	Boot        *vmBootConfig   `json:"boot" yaml:"boot"`
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
 func command_vm_get(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	pac := getSession().pac
 
-	cluster, err := pac.Cluster(ctx)
+	vm, err := getSession().ResolveVMResource(ctx, args[0], *FlagVmGetNode)
 	if err != nil {
-		return fmt.Errorf("getting cluster gave err: %w", err)
+		return err
 	}
 
-	resources, err := cluster.Resources(ctx)
-	if err != nil {
-		return fmt.Errorf("getting cluster resources gave err: %w", err)
-	}
-
-	query := args[0]
-	vmid, vmidQuery := parseVMIDArg(query)
-
-	type vmResource struct {
-		ID       string
-		Node     string
-		VMID     uint64
-		Name     string
-		Status   string
-		CPU      float64
-		Mem      uint64
-		MaxMem   uint64
-		Disk     uint64
-		MaxDisk  uint64
-		Uptime   uint64
-		Template uint64
-		Tags     string
-		Pool     string
-	}
-
-	vmMatches := make([]vmResource, 0, 1)
-	for _, r := range resources {
-		if r.Type != "qemu" {
-			continue
-		}
-
-		if vmidQuery {
-			if r.VMID != vmid {
-				continue
-			}
-		} else if r.Name != query {
-			continue
-		}
-
-		vmMatches = append(vmMatches, vmResource{
-			ID:       r.ID,
-			Node:     r.Node,
-			VMID:     r.VMID,
-			Name:     r.Name,
-			Status:   r.Status,
-			CPU:      r.CPU,
-			Mem:      r.Mem,
-			MaxMem:   r.MaxMem,
-			Disk:     r.Disk,
-			MaxDisk:  r.MaxDisk,
-			Uptime:   r.Uptime,
-			Template: r.Template,
-			Tags:     r.Tags,
-			Pool:     r.Pool,
-		})
+	details := &vmDetails{
+		ID:       vm.ID,
+		Node:     vm.Node,
+		VMID:     vm.VMID,
+		Name:     vm.Name,
+		Status:   vm.Status,
+		CPU:      vm.CPU,
+		Mem:      vm.Mem,
+		MaxMem:   vm.MaxMem,
+		Disk:     vm.Disk,
+		MaxDisk:  vm.MaxDisk,
+		Uptime:   vm.Uptime,
+		Template: vm.Template == 1,
+		Pool:     vm.Pool,
+		Tags:     vm.Tags,
 	}
 
-	if len(vmMatches) == 0 {
-		return fmt.Errorf("vm %q not found", query)
+	details.Balloon, err = getVMBalloonState(ctx, pac, vm.Node, vm.VMID)
+	if err != nil {
+		return fmt.Errorf("getting balloon state for VM %d gave err: %w", vm.VMID, err)
 	}
 
-	if !vmidQuery && len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
+	details.Boot, err = getVMBootConfig(ctx, pac, vm.Node, vm.VMID)
+	if err != nil {
+		return fmt.Errorf("getting boot config for VM %d gave err: %w", vm.VMID, err)
 	}
 
-	if !vmidQuery && len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
+	details.Description, err = getVMDescription(ctx, pac, vm.Node, vm.VMID)
+	if err != nil {
+		return fmt.Errorf("getting description for VM %d gave err: %w", vm.VMID, err)
 	}
 
-	vm := vmMatches[0]
-
-	if len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
-	}
+	return writeOutput(cmd.OutOrStdout(), *FlagVmGetOutput, details, func(w io.Writer) error {
+		return writeVMDetails(w, details)
+	})
+}
 
-	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+// writeVMDetails writes the FIELD/VALUE table vm get shows, followed by the
+// description.
+func writeVMDetails(w io.Writer, vm *vmDetails) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 	fmt.Fprintln(writer, "FIELD\tVALUE")
 	fmt.Fprintf(writer, "id\t%s\n", vm.ID)
 	fmt.Fprintf(writer, "node\t%s\n", vm.Node)
@@ -119,24 +111,96 @@ func command_vm_get(cmd *cobra.Command, args []string) error {
 	fmt.Fprintf(writer, "memory\t%s / %s (%s)\n", formatBytes(vm.Mem), formatBytes(vm.MaxMem), formatPercent(vm.Mem, vm.MaxMem))
 	fmt.Fprintf(writer, "disk\t%s / %s (%s)\n", formatBytes(vm.Disk), formatBytes(vm.MaxDisk), formatPercent(vm.Disk, vm.MaxDisk))
 	fmt.Fprintf(writer, "uptime\t%s\n", formatUptime(vm.Uptime))
-	fmt.Fprintf(writer, "template\t%t\n", vm.Template == 1)
+	fmt.Fprintf(writer, "template\t%t\n", vm.Template)
 	if vm.Pool != "" {
 		fmt.Fprintf(writer, "pool\t%s\n", vm.Pool)
 	}
 	if vm.Tags != "" {
 		fmt.Fprintf(writer, "tags\t%s\n", vm.Tags)
 	}
+	writeVMBalloonState(writer, vm.Balloon)
+	writeVMBootConfig(writer, vm.Boot)
 
 	if err := writer.Flush(); err != nil {
 		return fmt.Errorf("flushing vm details writer gave err: %w", err)
 	}
+
+	if vm.Description != "" {
+		fmt.Fprintf(w, "\nDescription:\n%s\n", vm.Description)
+	}
 	return nil
 }
 
-func parseVMIDArg(s string) (uint64, bool) {
-	vmid, err := strconv.ParseUint(s, 10, 64)
-	if err != nil {
-		return 0, false
+// vmBalloonState combines the configured ballooning limits with the live
+// balloon statistics reported by the guest's virtio balloon driver.
+type vmBalloonState struct {
+	// Configured values, from the VM config.
+	MemoryMB     int  `json:"memory_mb" yaml:"memory_mb"`
+	BalloonMinMB *int `json:"balloon_min_mb" yaml:"balloon_min_mb"`
+	Shares       *int `json:"shares" yaml:"shares"`
+
+	// Runtime values, from the VM status. Only set when the VM is running and
+	// the balloon driver reports statistics.
+	Target uint64         `json:"target,omitempty" yaml:"target,omitempty"`
+	Info   *vmBalloonInfo `json:"info,omitempty" yaml:"info,omitempty"`
+}
+
+type vmBalloonInfo struct {
+	Actual   uint64 `json:"actual" yaml:"actual"`
+	MaxMem   uint64 `json:"max_mem" yaml:"max_mem"`
+	FreeMem  uint64 `json:"free_mem" yaml:"free_mem"`
+	TotalMem uint64 `json:"total_mem" yaml:"total_mem"`
+}
+
+func getVMBalloonState(ctx context.Context, pac dttproxmox.ProxmoxAPI, node string, vmid uint64) (*vmBalloonState, error) {
+	var config struct {
+		Memory  px.StringOrInt `json:"memory"`
+		Balloon *int           `json:"balloon"`
+		Shares  *int           `json:"shares"`
 	}
-	return vmid, true
-}
\ No newline at end of file
+	if err := pac.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", node, vmid), &config); err != nil {
+		return nil, fmt.Errorf("getting VM config gave err: %w", err)
+	}
+
+	var status struct {
+		Balloon     uint64         `json:"balloon"`
+		BalloonInfo *vmBalloonInfo `json:"ballooninfo"`
+	}
+	if err := pac.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/status/current", node, vmid), &status); err != nil {
+		return nil, fmt.Errorf("getting VM status gave err: %w", err)
+	}
+
+	return &vmBalloonState{
+		MemoryMB:     int(config.Memory),
+		BalloonMinMB: config.Balloon,
+		Shares:       config.Shares,
+		Target:       status.Balloon,
+		Info:         status.BalloonInfo,
+	}, nil
+}
+
+func writeVMBalloonState(w io.Writer, b *vmBalloonState) {
+	switch {
+	case b.BalloonMinMB == nil:
+		fmt.Fprintf(w, "balloon\tenabled (min %d MiB, default)\n", b.MemoryMB)
+	case *b.BalloonMinMB == 0:
+		fmt.Fprintln(w, "balloon\tdisabled")
+	default:
+		fmt.Fprintf(w, "balloon\tenabled (min %d MiB, max %d MiB)\n", *b.BalloonMinMB, b.MemoryMB)
+	}
+
+	if b.Shares != nil {
+		fmt.Fprintf(w, "balloon shares\t%d\n", *b.Shares)
+	} else {
+		fmt.Fprintln(w, "balloon shares\t1000 (default)")
+	}
+
+	if b.Info != nil && b.Info.MaxMem > 0 {
+		fmt.Fprintf(w, "balloon actual\t%s / %s (%s)\n", formatBytes(b.Info.Actual), formatBytes(b.Info.MaxMem), formatPercent(b.Info.Actual, b.Info.MaxMem))
+		if b.Info.TotalMem > 0 {
+			fmt.Fprintf(w, "guest free memory\t%s / %s\n", formatBytes(b.Info.FreeMem), formatBytes(b.Info.TotalMem))
+		}
+	} else if b.Target > 0 {
+		fmt.Fprintf(w, "balloon target\t%s\n", formatBytes(b.Target))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmHibernateCommand = &cobra.Command{
+		Use:   "hibernate <name-or-id>...",
+		Short: "suspend vms to disk, freeing their memory until they are resumed",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  command_vm_hibernate,
+	}
+
+	FlagVmHibernateNode    *string
+	FlagVmHibernateTimeout *time.Duration
+)
+
+func init() {
+	vmCommand.AddCommand(vmHibernateCommand)
+
+	FlagVmHibernateNode = vmHibernateCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmHibernateTimeout = vmHibernateCommand.PersistentFlags().Duration("timeout", 10*time.Minute, "how long to wait for the memory to be written to disk")
+}
+
+func command_vm_hibernate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	pac := getSession().pac
+
+	vms := []*proxmox.VirtualMachine{}
+	for _, query := range args {
+		vm, err := findQemuVM(ctx, pac, query, *FlagVmHibernateNode)
+		if err != nil {
+			return fmt.Errorf("finding VM gave err: %w", err)
+		}
+		if vm.IsHibernated() {
+			fmt.Printf("vm %d (%s) is already hibernated\n", vm.VMID, vm.Name)
+			continue
+		}
+		if !vm.IsRunning() {
+			return fmt.Errorf("vm %d (%s) is %s, only running vms can be hibernated", vm.VMID, vm.Name, vm.Status)
+		}
+		vms = append(vms, vm)
+	}
+
+	tasks := []*proxmox.Task{}
+	for _, vm := range vms {
+		task, err := vm.Hibernate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to start hibernate task for VM %d: %w", vm.VMID, err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	for i, task := range tasks {
+		if err := waitTask(ctx, task, 2*time.Second, *FlagVmHibernateTimeout); err != nil {
+			return fmt.Errorf("waiting for hibernate of VM %d failed: %w", vms[i].VMID, err)
+		}
+		fmt.Printf("hibernated vm %d (%s)\n", vms[i].VMID, vms[i].Name)
+	}
+	return nil
+}
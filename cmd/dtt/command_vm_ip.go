@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmIPCommand = &cobra.Command{
+		Use:   "ip <name-or-id>",
+		Short: "print the ip address of a vm as its guest agent reports it",
+		Long: `Print the IP address of a running VM as its guest agent reports it, IPv4
+before IPv6. Loopback and link-local addresses are left out. With --wait it
+waits for the guest agent to report one, as it only does once the VM has
+booted.
+
+  ssh dtt@$(dtt vm ip web --wait)
+  dtt vm ip web -6 --all`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_ip,
+	}
+
+	FlagVmIPNode    *string
+	FlagVmIPWait    *bool
+	FlagVmIPTimeout *time.Duration
+	FlagVmIPv4      *bool
+	FlagVmIPv6      *bool
+	FlagVmIPAll     *bool
+)
+
+func init() {
+	FlagVmIPNode = vmIPCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmIPWait = vmIPCommand.PersistentFlags().Bool("wait", false, "wait for the guest agent to report an address")
+	FlagVmIPTimeout = vmIPCommand.PersistentFlags().Duration("timeout", 2*time.Minute, "how long --wait waits")
+	FlagVmIPv4 = vmIPCommand.PersistentFlags().BoolP("ipv4", "4", false, "only IPv4 addresses")
+	FlagVmIPv6 = vmIPCommand.PersistentFlags().BoolP("ipv6", "6", false, "only IPv6 addresses")
+	FlagVmIPAll = vmIPCommand.PersistentFlags().Bool("all", false, "print all addresses, one per line, instead of the first")
+	vmIPCommand.MarkFlagsMutuallyExclusive("ipv4", "ipv6")
+
+	vmCommand.AddCommand(vmIPCommand)
+}
+
+// ipFamily selects addresses by family: 4, 6 or 0 for both.
+type ipFamily int
+
+// agentAddresses returns the addresses in ifaces of family, IPv4 first.
+// Loopback and link-local addresses are left out, they can't be connected to
+// from outside the VM.
+func agentAddresses(ifaces []*proxmox.AgentNetworkIface, family ipFamily) []string {
+	var v4, v6 []string
+	for _, iface := range ifaces {
+		if iface == nil {
+			continue
+		}
+		for _, addr := range iface.IPAddresses {
+			if addr == nil {
+				continue
+			}
+			ip := net.ParseIP(addr.IPAddress)
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip.To4() != nil {
+				v4 = append(v4, ip.String())
+			} else {
+				v6 = append(v6, ip.String())
+			}
+		}
+	}
+	switch family {
+	case 4:
+		return v4
+	case 6:
+		return v6
+	}
+	return append(v4, v6...)
+}
+
+// waitForAddresses asks the guest agent of vm for its addresses of family
+// until it reports some, trying attempts times delay apart.
+func waitForAddresses(ctx context.Context, vm *proxmox.VirtualMachine, family ipFamily, attempts int, delay time.Duration) ([]string, error) {
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		ifaces, err := vm.AgentGetNetworkIFaces(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if addrs := agentAddresses(ifaces, family); len(addrs) > 0 {
+			return addrs, nil
+		}
+		lastErr = nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("asking the guest agent gave err: %w", lastErr)
+	}
+	return nil, fmt.Errorf("the guest agent reports no address")
+}
+
+func command_vm_ip(cmd *cobra.Command, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmIPNode)
+	if err != nil {
+		return err
+	}
+	if !vm.IsRunning() {
+		return fmt.Errorf("vm %d (%s) is %s, it has no address", vm.VMID, vm.Name, vm.Status)
+	}
+
+	var family ipFamily
+	switch {
+	case *FlagVmIPv4:
+		family = 4
+	case *FlagVmIPv6:
+		family = 6
+	}
+	attempts, delay := 1, 2*time.Second
+	if *FlagVmIPWait {
+		attempts = int(*FlagVmIPTimeout/delay) + 1
+	}
+	addrs, err := waitForAddresses(ctx, vm, family, attempts, delay)
+	if err != nil {
+		return fmt.Errorf("getting the address of VM %d gave err: %w", vm.VMID, err)
+	}
+
+	if !*FlagVmIPAll {
+		addrs = addrs[:1]
+	}
+	for _, addr := range addrs {
+		fmt.Fprintln(cmd.OutOrStdout(), addr)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+func TestAgentAddresses(t *testing.T) {
+	ifaces := []*proxmox.AgentNetworkIface{
+		{Name: "lo", IPAddresses: []*proxmox.AgentNetworkIPAddress{
+			{IPAddressType: "ipv4", IPAddress: "127.0.0.1"},
+			{IPAddressType: "ipv6", IPAddress: "::1"},
+		}},
+		{Name: "eth0", IPAddresses: []*proxmox.AgentNetworkIPAddress{
+			{IPAddressType: "ipv6", IPAddress: "2a02:aa14::1"},
+			{IPAddressType: "ipv6", IPAddress: "fe80::be24:11ff:fe5a:1"},
+			{IPAddressType: "ipv4", IPAddress: "192.168.1.191"},
+		}},
+		{Name: "docker0", IPAddresses: []*proxmox.AgentNetworkIPAddress{
+			{IPAddressType: "ipv4", IPAddress: "172.17.0.1"},
+		}},
+	}
+
+	for _, tc := range []struct {
+		family ipFamily
+		want   []string
+	}{
+		{0, []string{"192.168.1.191", "172.17.0.1", "2a02:aa14::1"}},
+		{4, []string{"192.168.1.191", "172.17.0.1"}},
+		{6, []string{"2a02:aa14::1"}},
+	} {
+		if got := agentAddresses(ifaces, tc.family); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("agentAddresses(%d) = %q, want %q", tc.family, got, tc.want)
+		}
+	}
+	if got := agentAddresses(nil, 0); len(got) != 0 {
+		t.Errorf("Expected no addresses without interfaces, got %q", got)
+	}
+}
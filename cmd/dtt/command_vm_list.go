@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
+	"path"
 	"sort"
 	"text/tabwriter"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
 
@@ -16,16 +19,39 @@ var (
 		Short: "list vms",
 		RunE:  command_vm_list,
 	}
+
+	FlagVmListOutput   *string
+	FlagVmListTag      *string
+	FlagVmListNameGlob *string
+	FlagVmListStatus   *string
 )
 
 func init() {
+	FlagVmListOutput = addOutputFlag(vmListCommand)
+	FlagVmListTag = vmListCommand.PersistentFlags().String("tag", "", "only list VMs with this tag")
+	FlagVmListNameGlob = vmListCommand.PersistentFlags().String("name-glob", "", "only list VMs whose name matches this pattern, e.g. 'ci-*'")
+	FlagVmListStatus = vmListCommand.PersistentFlags().String("status", "", "only list VMs with this status, e.g. running or stopped")
 	vmCommand.AddCommand(vmListCommand)
 }
 
+// vmListSelects reports whether vm list shows r: it needs tag, a name
+// matching nameGlob and status, each unless empty.
+func vmListSelects(r *proxmox.ClusterResource, tag, nameGlob, status string) bool {
+	if tag != "" && !dttproxmox.HasTag(r.Tags, tag) {
+		return false
+	}
+	if nameGlob != "" {
+		if ok, _ := path.Match(nameGlob, r.Name); !ok {
+			return false
+		}
+	}
+	return status == "" || r.Status == status
+}
+
 func command_vm_list(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	pac := getSession().pac
 
 	cluster, err := pac.Cluster(ctx)
 	if err != nil {
@@ -37,34 +63,14 @@ func command_vm_list(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("getting cluster resources gave err: %w", err)
 	}
 
-	vmRows := make([]struct {
-		Node    string
-		VMID    uint64
-		Name    string
-		Status  string
-		CPU     float64
-		Mem     uint64
-		MaxMem  uint64
-		Disk    uint64
-		MaxDisk uint64
-		Uptime  uint64
-	}, 0, len(resources))
+	if _, err := path.Match(*FlagVmListNameGlob, ""); err != nil {
+		return fmt.Errorf("%w: --name-glob %q: %w", ErrUsage, *FlagVmListNameGlob, err)
+	}
 
+	vmRows := make([]statusVMRow, 0, len(resources))
 	for _, r := range resources {
-		switch r.Type {
-		case "qemu":
-			vmRows = append(vmRows, struct {
-				Node    string
-				VMID    uint64
-				Name    string
-				Status  string
-				CPU     float64
-				Mem     uint64
-				MaxMem  uint64
-				Disk    uint64
-				MaxDisk uint64
-				Uptime  uint64
-			}{
+		if r.Type == "qemu" && vmListSelects(r, *FlagVmListTag, *FlagVmListNameGlob, *FlagVmListStatus) {
+			vmRows = append(vmRows, statusVMRow{
 				Node:    r.Node,
 				VMID:    r.VMID,
 				Name:    r.Name,
@@ -75,6 +81,7 @@ func command_vm_list(cmd *cobra.Command, args []string) error {
 				Disk:    r.Disk,
 				MaxDisk: r.MaxDisk,
 				Uptime:  r.Uptime,
+				Tags:    r.Tags,
 			})
 		}
 	}
@@ -86,11 +93,18 @@ func command_vm_list(cmd *cobra.Command, args []string) error {
 		return vmRows[i].Node < vmRows[j].Node
 	})
 
-	fmt.Println()
-	fmt.Println("VMs")
-	vmWriter := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	return writeOutput(cmd.OutOrStdout(), *FlagVmListOutput, vmRows, func(w io.Writer) error {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "VMs")
+		return writeVMRows(w, vmRows)
+	})
+}
+
+// writeVMRows writes a table of VMs, as vm list and status show them.
+func writeVMRows(w io.Writer, vms []statusVMRow) error {
+	vmWriter := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
 	fmt.Fprintln(vmWriter, "NODE\tVMID\tNAME\tSTATUS\tCPU\tMEM\tDISK\tUPTIME")
-	for _, vm := range vmRows {
+	for _, vm := range vms {
 		fmt.Fprintf(
 			vmWriter,
 			"%s\t%d\t%s\t%s\t%.1f%%\t%s/%s (%s)\t%s/%s (%s)\t%s\n",
@@ -109,8 +123,7 @@ func command_vm_list(cmd *cobra.Command, args []string) error {
 		)
 	}
 	if err := vmWriter.Flush(); err != nil {
-		return fmt.Errorf("flushing VM list writer gave err: %w", err)
+		return fmt.Errorf("flushing VM writer gave err: %w", err)
 	}
-
 	return nil
-}
\ No newline at end of file
+}
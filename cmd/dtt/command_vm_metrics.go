@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	px "github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmMetricsCommand = &cobra.Command{
+		Use:   "metrics <name-or-id>",
+		Short: "show CPU, memory, disk and network history for a vm",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_vm_metrics,
+	}
+
+	FlagVmMetricsNode      *string
+	FlagVmMetricsTimeframe *string
+	FlagVmMetricsCF        *string
+	FlagVmMetricsChart     *bool
+)
+
+func init() {
+	vmCommand.AddCommand(vmMetricsCommand)
+
+	FlagVmMetricsNode = vmMetricsCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmMetricsTimeframe = vmMetricsCommand.PersistentFlags().String("timeframe", "hour", "history to show: hour, day, week, month or year")
+	FlagVmMetricsCF = vmMetricsCommand.PersistentFlags().String("cf", "AVERAGE", "consolidation function: AVERAGE or MAX")
+	FlagVmMetricsChart = vmMetricsCommand.PersistentFlags().Bool("chart", false, "render sparkline charts instead of a table")
+}
+
+func command_vm_metrics(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	timeframe, cf, err := parseRRDFlags(*FlagVmMetricsTimeframe, *FlagVmMetricsCF)
+	if err != nil {
+		return err
+	}
+
+	pac := getSession().pac
+
+	vm, err := findQemuVM(ctx, pac, args[0], *FlagVmMetricsNode)
+	if err != nil {
+		return fmt.Errorf("finding VM gave err: %w", err)
+	}
+
+	rrd, err := vm.RRDData(ctx, timeframe, cf)
+	if err != nil {
+		return fmt.Errorf("getting rrddata for VM %d gave err: %w", vm.VMID, err)
+	}
+	if len(rrd) == 0 {
+		fmt.Printf("no metrics recorded for VM %d (%s) in the last %s\n", vm.VMID, vm.Name, timeframe)
+		return nil
+	}
+
+	fmt.Printf("VM %d (%s) on %s, last %s (%s)\n\n", vm.VMID, vm.Name, vm.Node, timeframe, cf)
+
+	if *FlagVmMetricsChart {
+		return writeVMMetricsChart(os.Stdout, rrd)
+	}
+	return writeVMMetricsTable(os.Stdout, rrd)
+}
+
+// parseRRDFlags validates the --timeframe and --cf flags shared by the metrics
+// commands.
+func parseRRDFlags(timeframe, cf string) (px.Timeframe, px.ConsolidationFunction, error) {
+	var tf px.Timeframe
+	switch strings.ToLower(strings.TrimSpace(timeframe)) {
+	case "hour":
+		tf = px.TimeframeHour
+	case "day":
+		tf = px.TimeframeDay
+	case "week":
+		tf = px.TimeframeWeek
+	case "month":
+		tf = px.TimeframeMonth
+	case "year":
+		tf = px.TimeframeYear
+	default:
+		return "", "", fmt.Errorf("invalid --timeframe %q, expected hour, day, week, month or year", timeframe)
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(cf)) {
+	case "AVERAGE":
+		return tf, px.AVERAGE, nil
+	case "MAX":
+		return tf, px.MAX, nil
+	default:
+		return "", "", fmt.Errorf("invalid --cf %q, expected AVERAGE or MAX", cf)
+	}
+}
+
+func writeVMMetricsTable(w io.Writer, rrd []*px.RRDData) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tCPU\tMEM\tDISK READ\tDISK WRITE\tNET IN\tNET OUT")
+	for _, r := range rrd {
+		fmt.Fprintf(
+			writer,
+			"%s\t%.1f%%\t%s (%s)\t%s\t%s\t%s\t%s\n",
+			formatRRDTime(r.Time),
+			r.CPU*100.0,
+			formatBytes(uint64(r.Mem)),
+			formatPercent(uint64(r.Mem), r.MaxMem),
+			formatRate(r.DiskRead),
+			formatRate(r.DiskWrite),
+			formatRate(r.NetIn),
+			formatRate(r.NetOut),
+		)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flushing vm metrics writer gave err: %w", err)
+	}
+	return nil
+}
+
+func writeVMMetricsChart(w io.Writer, rrd []*px.RRDData) error {
+	series := []struct {
+		name   string
+		value  func(r *px.RRDData) float64
+		format func(v float64) string
+	}{
+		{"cpu", func(r *px.RRDData) float64 { return r.CPU * 100.0 }, func(v float64) string { return fmt.Sprintf("%.1f%%", v) }},
+		{"mem", func(r *px.RRDData) float64 { return r.Mem }, func(v float64) string { return formatBytes(uint64(v)) }},
+		{"disk read", func(r *px.RRDData) float64 { return r.DiskRead }, formatRate},
+		{"disk write", func(r *px.RRDData) float64 { return r.DiskWrite }, formatRate},
+		{"net in", func(r *px.RRDData) float64 { return r.NetIn }, formatRate},
+		{"net out", func(r *px.RRDData) float64 { return r.NetOut }, formatRate},
+	}
+
+	fmt.Fprintf(w, "%s .. %s\n", formatRRDTime(rrd[0].Time), formatRRDTime(rrd[len(rrd)-1].Time))
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "METRIC\tHISTORY\tMIN\tMAX\tLAST")
+	for _, s := range series {
+		values := make([]float64, 0, len(rrd))
+		for _, r := range rrd {
+			values = append(values, s.value(r))
+		}
+		lo, hi := minMax(values)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", s.name, sparkline(values), s.format(lo), s.format(hi), s.format(values[len(values)-1]))
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flushing vm metrics writer gave err: %w", err)
+	}
+	return nil
+}
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a single line of block characters scaled
+// between the smallest and largest value. NaN values render as blanks.
+func sparkline(values []float64) string {
+	lo, hi := minMax(values)
+
+	var sb strings.Builder
+	for _, v := range values {
+		if math.IsNaN(v) {
+			sb.WriteRune(' ')
+			continue
+		}
+		idx := 0
+		if hi > lo {
+			idx = int((v - lo) / (hi - lo) * float64(len(sparkTicks)-1))
+		}
+		sb.WriteRune(sparkTicks[idx])
+	}
+	return sb.String()
+}
+
+func minMax(values []float64) (float64, float64) {
+	lo, hi := math.NaN(), math.NaN()
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if math.IsNaN(lo) || v < lo {
+			lo = v
+		}
+		if math.IsNaN(hi) || v > hi {
+			hi = v
+		}
+	}
+	return lo, hi
+}
+
+func formatRate(bytesPerSecond float64) string {
+	if math.IsNaN(bytesPerSecond) || bytesPerSecond < 0 {
+		return "n/a"
+	}
+	return formatBytes(uint64(bytesPerSecond)) + "/s"
+}
+
+func formatRRDTime(ts uint64) string {
+	return time.Unix(int64(ts), 0).Local().Format("2006-01-02 15:04")
+}
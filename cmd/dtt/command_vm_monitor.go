@@ -5,9 +5,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/signal"
 	"time"
 
+	"github.com/cdevr/dtt/parseCloudInitLog"
 	"github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
@@ -16,29 +20,59 @@ var (
 	vmMonitorCommand = &cobra.Command{
 		Use:   "monitor <name-or-id>",
 		Short: "monitor VM serial console output",
-		Args:  cobra.ExactArgs(1),
-		RunE:  command_vm_monitor,
+		Long: `Stream the serial console of a VM to stdout until it has been quiet for
+--quiet or --max-duration has passed, or with --follow until interrupted.
+--timestamps puts the time each line arrived in front of it on stdout, the
+--output file stays as the console wrote it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_monitor,
 	}
 
-	FlagVmMonitorNode  *string
-	FlagVmMonitorQuiet *time.Duration
-	FlagVmMonitorMax   *time.Duration
+	FlagVmMonitorNode   *string
+	FlagVmMonitorQuiet  *time.Duration
+	FlagVmMonitorMax    *time.Duration
+	FlagVmMonitorFollow *bool
+	FlagVmMonitorOutput *string
+	FlagVmMonitorParse  *bool
+	FlagVmMonitorTime   *bool
 )
 
 func init() {
 	FlagVmMonitorNode = vmMonitorCommand.PersistentFlags().String("node", "", "which node the VM is on")
 	FlagVmMonitorQuiet = vmMonitorCommand.PersistentFlags().Duration("quiet", 3*time.Second, "stop after no websocket output for this duration")
 	FlagVmMonitorMax = vmMonitorCommand.PersistentFlags().Duration("max-duration", 30*time.Second, "maximum time to monitor websocket output")
+	FlagVmMonitorFollow = vmMonitorCommand.PersistentFlags().BoolP("follow", "f", false, "keep streaming until interrupted, ignoring --quiet and --max-duration")
+	FlagVmMonitorOutput = vmMonitorCommand.PersistentFlags().StringP("output", "o", "", "also write the console output to this file")
+	FlagVmMonitorParse = vmMonitorCommand.PersistentFlags().Bool("parse", false, "print what parse-log finds in the output at the end")
+	FlagVmMonitorTime = vmMonitorCommand.PersistentFlags().BoolP("timestamps", "t", false, "put the time each line arrived in front of it")
 	vmCommand.AddCommand(vmMonitorCommand)
 }
 
-func monitorVM(ctx context.Context, vm *proxmox.VirtualMachine, maxSilence, timeout time.Duration) ([]byte, error) {
-	return monitorVMWithOutput(ctx, vm, maxSilence, timeout, false)
+// monitorCondition reports whether the serial output parsed so far shows
+// what the caller waits for, ending monitoring before the quiet period or
+// timeout would.
+type monitorCondition func(p *parseCloudInitLog.Parser) bool
+
+// cloudInitDone is met once cloud-init reports it has finished, or once the
+// login prompt shows with the IPs and host keys already parsed, for images
+// that print the prompt last.
+func cloudInitDone(p *parseCloudInitLog.Parser) bool {
+	data := p.Data()
+	if data.Timing.Finished > 0 {
+		return true
+	}
+	return p.LoginSeen() && len(data.IPs) > 0 && len(data.HostKeys) > 0
 }
 
-func monitorVMWithOutput(ctx context.Context, vm *proxmox.VirtualMachine, maxSilence, timeout time.Duration, printOutput bool) ([]byte, error) {
-	var result bytes.Buffer
+func monitorVM(ctx context.Context, vm *proxmox.VirtualMachine, maxSilence, timeout time.Duration) ([]byte, error) {
+	return monitorVMWithOutput(ctx, vm, maxSilence, timeout, nil, nil)
+}
 
+// monitorVMWithOutput reads the serial console of vm until done is met, it
+// is quiet for maxSilence, timeout passes or ctx is done, copying the output
+// to out when it is not nil. A nil done is never met, and a zero maxSilence
+// or timeout means no limit.
+func monitorVMWithOutput(ctx context.Context, vm *proxmox.VirtualMachine, maxSilence, timeout time.Duration, out io.Writer, done monitorCondition) ([]byte, error) {
 	term, err := vm.TermProxy(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("creating terminal proxy gave err: %w", err)
@@ -50,136 +84,144 @@ func monitorVMWithOutput(ctx context.Context, vm *proxmox.VirtualMachine, maxSil
 	}
 	defer wsConn.Close()
 
-	totalDeadline := time.Now().Add(timeout)
+	// Closing the connection ends the read waiting for output.
+	stop := context.AfterFunc(ctx, func() { wsConn.Close() })
+	defer stop()
+
+	output, err := readSerial(wsConn, maxSilence, timeout, out, done)
+	if err != nil && ctx.Err() != nil {
+		return output, nil
+	}
+	return output, err
+}
+
+// messageReader is the part of the console websocket readSerial uses.
+type messageReader interface {
+	SetReadDeadline(t time.Time) error
+	ReadMessage() (messageType int, p []byte, err error)
+}
+
+// readSerial reads messages from conn as monitorVMWithOutput describes. On
+// an error it returns the output read so far with it.
+func readSerial(conn messageReader, maxSilence, timeout time.Duration, out io.Writer, done monitorCondition) ([]byte, error) {
+	var result bytes.Buffer
+	var parser *parseCloudInitLog.Parser
+	if done != nil {
+		parser = parseCloudInitLog.NewParser(parseCloudInitLog.StreamCallbacks{})
+	}
+
+	var totalDeadline time.Time
+	if timeout > 0 {
+		totalDeadline = time.Now().Add(timeout)
+	}
 	for {
-		remaining := time.Until(totalDeadline)
-		if remaining <= 0 {
-			break
+		// The zero time means no deadline.
+		var deadline time.Time
+		if maxSilence > 0 {
+			deadline = time.Now().Add(maxSilence)
 		}
-
-		readWait := maxSilence
-		if readWait <= 0 || readWait > remaining {
-			readWait = remaining
+		if !totalDeadline.IsZero() {
+			if time.Until(totalDeadline) <= 0 {
+				break
+			}
+			if deadline.IsZero() || deadline.After(totalDeadline) {
+				deadline = totalDeadline
+			}
 		}
 
-		if err := wsConn.SetReadDeadline(time.Now().Add(readWait)); err != nil {
-			return nil, fmt.Errorf("failed to set websocket read deadline: %w", err)
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return result.Bytes(), fmt.Errorf("failed to set websocket read deadline: %w", err)
 		}
 
-		_, msg, err := wsConn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			var netErr net.Error
 			if errors.As(err, &netErr) && netErr.Timeout() {
 				break
 			}
-			return nil, fmt.Errorf("error from websocket: %w", err)
+			return result.Bytes(), fmt.Errorf("error from websocket: %w", err)
 		}
 
 		result.Write(msg)
-		if printOutput {
-			fmt.Print(string(msg))
+		if out != nil {
+			out.Write(msg)
+		}
+		if parser != nil {
+			parser.Write(msg)
+			if done(parser) {
+				break
+			}
 		}
 	}
 
 	return result.Bytes(), nil
 }
 
-func command_vm_monitor(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
-
-	query := args[0]
-	vmid, vmidQuery := parseVMIDArg(query)
-
-	pac := getPACFromFlags()
-
-	cluster, err := pac.Cluster(ctx)
-	if err != nil {
-		return fmt.Errorf("getting cluster gave err: %w", err)
-	}
+// timestampWriter writes to w with the time in front of every line.
+type timestampWriter struct {
+	w   io.Writer
+	now func() time.Time
+	// mid is set while a line is written without its newline yet.
+	mid bool
+}
 
-	resources, err := cluster.Resources(ctx)
-	if err != nil {
-		return fmt.Errorf("getting cluster resources gave err: %w", err)
-	}
-
-	type vmResource struct {
-		ID       string
-		Node     string
-		VMID     uint64
-		Name     string
-		Status   string
-		CPU      float64
-		Mem      uint64
-		MaxMem   uint64
-		Disk     uint64
-		MaxDisk  uint64
-		Uptime   uint64
-		Template uint64
-		Tags     string
-		Pool     string
-	}
-
-	vmMatches := make([]vmResource, 0, 1)
-	for _, r := range resources {
-		if r.Type != "qemu" {
-			continue
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		if !t.mid {
+			buf.WriteString(t.now().Format("2006-01-02 15:04:05.000 "))
+			t.mid = true
 		}
-
-		if vmidQuery {
-			if r.VMID != vmid {
-				continue
-			}
-		} else if r.Name != query {
-			continue
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+			t.mid = false
 		}
-
-		vmMatches = append(vmMatches, vmResource{
-			ID:       r.ID,
-			Node:     r.Node,
-			VMID:     r.VMID,
-			Name:     r.Name,
-			Status:   r.Status,
-			CPU:      r.CPU,
-			Mem:      r.Mem,
-			MaxMem:   r.MaxMem,
-			Disk:     r.Disk,
-			MaxDisk:  r.MaxDisk,
-			Uptime:   r.Uptime,
-			Template: r.Template,
-			Tags:     r.Tags,
-			Pool:     r.Pool,
-		})
+		buf.Write(line)
+		rest = rest[len(line):]
 	}
-
-	if len(vmMatches) == 0 {
-		return fmt.Errorf("vm %q not found", query)
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
 	}
+	return len(p), nil
+}
 
-	if !vmidQuery && len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
-	}
+func command_vm_monitor(cmd *cobra.Command, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
 
-	if !vmidQuery && len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmMonitorNode)
+	if err != nil {
+		return err
 	}
 
-	fVM := vmMatches[0]
-
-	if len(vmMatches) > 1 {
-		return fmt.Errorf("multiple VMs found named %q; use vm id instead", query)
+	out := cmd.OutOrStdout()
+	if *FlagVmMonitorTime {
+		out = &timestampWriter{w: out, now: time.Now}
 	}
-
-	node, err := pac.Node(ctx, fVM.Node)
-	if err != nil {
-		return fmt.Errorf("error getting node %q for VM %q (ID %s): %w", fVM.Node, fVM.Name, fVM.ID, err)
+	if *FlagVmMonitorOutput != "" {
+		f, err := os.Create(*FlagVmMonitorOutput)
+		if err != nil {
+			return fmt.Errorf("creating %q gave err: %w", *FlagVmMonitorOutput, err)
+		}
+		defer f.Close()
+		out = io.MultiWriter(out, f)
 	}
 
-	vm, err := node.VirtualMachine(ctx, int(fVM.VMID))
+	quiet, limit := *FlagVmMonitorQuiet, *FlagVmMonitorMax
+	if *FlagVmMonitorFollow {
+		quiet, limit = 0, 0
+	}
+	output, err := monitorVMWithOutput(ctx, vm, quiet, limit, out, nil)
 	if err != nil {
-		return fmt.Errorf("getting VM gave err: %w", err)
+		return fmt.Errorf("monitoring VM %d gave err: %w", vm.VMID, err)
 	}
 
-	_ = vm
-
+	if *FlagVmMonitorParse {
+		parsed := parseCloudInitLog.ParseCloudInit(output)
+		fmt.Fprintln(cmd.OutOrStdout())
+		writeCloudInitData(cmd.OutOrStdout(), parsed)
+		writeCloudInitProblems(cmd.ErrOrStderr(), parsed)
+	}
 	return nil
 }
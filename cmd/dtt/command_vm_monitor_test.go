@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeConsole hands out a captured serial log in chunks, and times out
+// once it is exhausted like a quiet console does.
+type fakeConsole struct {
+	b        []byte
+	n        int
+	deadline time.Time
+}
+
+func (c *fakeConsole) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeConsole) ReadMessage() (int, []byte, error) {
+	if len(c.b) == 0 {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	n := min(c.n, len(c.b))
+	msg := c.b[:n]
+	c.b = c.b[n:]
+	return 1, msg, nil
+}
+
+func TestReadSerialStopsWhenCloudInitIsDone(t *testing.T) {
+	content, err := os.ReadFile("../../parseCloudInitLog/testdata/dtt-ubuntu-jammy-107-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the console talking after cloud-init finished.
+	content = append(content, bytes.Repeat([]byte("[  OK  ] Reached target Timers.\r\n"), 100)...)
+
+	var out bytes.Buffer
+	got, err := readSerial(&fakeConsole{b: content, n: 64}, time.Second, time.Minute, &out, cloudInitDone)
+	if err != nil {
+		t.Fatalf("readSerial gave err: %v", err)
+	}
+	if !bytes.Contains(got, []byte("finished at")) {
+		t.Errorf("Expected output up to the cloud-init finished message, got %d bytes", len(got))
+	}
+	if bytes.Contains(got, []byte("Reached target Timers")) {
+		t.Errorf("Expected reading to stop once cloud-init finished")
+	}
+	if !bytes.Equal(out.Bytes(), got) {
+		t.Errorf("Expected the output to be copied to out")
+	}
+
+	got, err = readSerial(&fakeConsole{b: content, n: 64}, time.Second, time.Minute, nil, nil)
+	if err != nil {
+		t.Fatalf("readSerial gave err: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Expected all output without a condition, got %d of %d bytes", len(got), len(content))
+	}
+}
+
+func TestCloudInitDoneOnLoginPrompt(t *testing.T) {
+	// Without the final message, cloud-init is done once the login prompt
+	// shows with the IPs and host keys parsed.
+	content, err := os.ReadFile("../../parseCloudInitLog/testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.ReplaceAll(content, []byte("finished at"), []byte("is done at"))
+	content = append(content, bytes.Repeat([]byte("\r\n[  OK  ] Reached target Timers."), 100)...)
+
+	got, err := readSerial(&fakeConsole{b: content, n: 64}, time.Second, time.Minute, nil, cloudInitDone)
+	if err != nil {
+		t.Fatalf("readSerial gave err: %v", err)
+	}
+	if !bytes.Contains(got, []byte("login: ")) {
+		t.Errorf("Expected output up to the login prompt, got %d bytes", len(got))
+	}
+	if bytes.Contains(got, []byte("Reached target Timers")) {
+		t.Errorf("Expected reading to stop at the login prompt")
+	}
+}
+
+func TestReadSerialFollow(t *testing.T) {
+	console := &fakeConsole{b: []byte("Booting\r\n"), n: 64}
+	got, err := readSerial(console, 0, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("readSerial gave err: %v", err)
+	}
+	if string(got) != "Booting\r\n" {
+		t.Errorf("Expected the console output, got %q", got)
+	}
+	if !console.deadline.IsZero() {
+		t.Errorf("Expected no read deadline without limits, got %v", console.deadline)
+	}
+}
+
+func TestTimestampWriter(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	w := &timestampWriter{w: &out, now: func() time.Time { return now }}
+
+	for _, chunk := range []string{"Booting", " Linux\r\n[  OK  ] Started", " ssh.service\r\nlog", "in: "} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+		now = now.Add(time.Second)
+	}
+	want := "2026-10-17 09:30:00.000 Booting Linux\r\n" +
+		"2026-10-17 09:30:01.000 [  OK  ] Started ssh.service\r\n" +
+		"2026-10-17 09:30:02.000 login: "
+	if out.String() != want {
+		t.Errorf("Expected every line to start with its time, got %q", out.String())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmResumeCommand = &cobra.Command{
+		Use:   "resume <name-or-id>...",
+		Short: "resume hibernated or paused vms",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  command_vm_resume,
+	}
+
+	FlagVmResumeNode    *string
+	FlagVmResumeTimeout *time.Duration
+)
+
+func init() {
+	vmCommand.AddCommand(vmResumeCommand)
+
+	FlagVmResumeNode = vmResumeCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmResumeTimeout = vmResumeCommand.PersistentFlags().Duration("timeout", 10*time.Minute, "how long to wait for the memory to be restored")
+}
+
+func command_vm_resume(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	pac := getSession().pac
+
+	vms := []*proxmox.VirtualMachine{}
+	tasks := []*proxmox.Task{}
+	for _, query := range args {
+		vm, err := findQemuVM(ctx, pac, query, *FlagVmResumeNode)
+		if err != nil {
+			return fmt.Errorf("finding VM gave err: %w", err)
+		}
+
+		var task *proxmox.Task
+		switch {
+		case vm.IsHibernated():
+			// A hibernated VM is stopped with a suspended lock, starting it
+			// restores the saved state.
+			task, err = vm.Start(ctx)
+		case vm.IsPaused():
+			task, err = vm.Resume(ctx)
+		default:
+			return fmt.Errorf("vm %d (%s) is %s and neither hibernated nor paused", vm.VMID, vm.Name, vm.Status)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to start resume task for VM %d: %w", vm.VMID, err)
+		}
+		vms = append(vms, vm)
+		tasks = append(tasks, task)
+	}
+
+	for i, task := range tasks {
+		if err := waitTask(ctx, task, 2*time.Second, *FlagVmResumeTimeout); err != nil {
+			return fmt.Errorf("waiting for resume of VM %d failed: %w", vms[i].VMID, err)
+		}
+		fmt.Printf("resumed vm %d (%s)\n", vms[i].VMID, vms[i].Name)
+	}
+	return nil
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -13,174 +13,81 @@ import (
 
 var (
 	vmRmCommand = &cobra.Command{
-		Use:   "rm <name-or-id>",
-		Short: "remove vm",
-		Args:  cobra.MinimumNArgs(1),
-		RunE:  command_vm_rm,
+		Use:     "rm <name-or-id>...",
+		Aliases: []string{"delete"},
+		Short:   "remove vm",
+		Args:    cobra.ArbitraryArgs,
+		RunE:    command_vm_rm,
 	}
 
 	FlagVmRmStop *bool
+	vmRmTargets  *batchTargets
 )
 
 func init() {
 	vmCommand.AddCommand(vmRmCommand)
 
 	FlagVmRmStop = vmRmCommand.PersistentFlags().Bool("stop", false, "stop VMs before removing them")
+	vmRmTargets = addBatchFlags(vmRmCommand, false)
 }
 
-var (
-	nodeCache = map[string]*proxmox.Node{}
-	vmCache   = map[string]*proxmox.VirtualMachine{}
-)
-
+// WaitOnManyTasks waits for tasks concurrently and returns the errors of all
+// that failed.
 func WaitOnManyTasks(ctx context.Context, tasks []*proxmox.Task, pollInterval time.Duration, timeout time.Duration) error {
-	if len(tasks) == 0 {
-		return nil
-	}
+	return errors.Join(waitOnTasks(ctx, tasks, pollInterval, timeout)...)
+}
 
-	errCh := make(chan error, len(tasks))
+// waitOnTasks waits for tasks concurrently and returns the error of each, in
+// the order of tasks. Nil tasks are skipped.
+func waitOnTasks(ctx context.Context, tasks []*proxmox.Task, pollInterval time.Duration, timeout time.Duration) []error {
+	errs := make([]error, len(tasks))
 	var wg sync.WaitGroup
-	wg.Add(len(tasks))
-
-	for _, task := range tasks {
-		task := task
+	for i, task := range tasks {
+		if task == nil {
+			continue
+		}
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := task.Wait(ctx, pollInterval, timeout); err != nil {
-				errCh <- err
-			}
+			errs[i] = waitTask(ctx, task, pollInterval, timeout)
 		}()
 	}
-
 	wg.Wait()
-	close(errCh)
-
-	if err, ok := <-errCh; ok {
-		return err
-	}
-
-	return nil
-}
-
-func getNodeCached(ctx context.Context, pac *proxmox.Client, node string) (*proxmox.Node, error) {
-	if node, ok := nodeCache[node]; ok {
-		return node, nil
-	}
-	result, err := pac.Node(ctx, node)
-	if err != nil {
-		return nil, err
-	}
-	nodeCache[node] = result
-	return result, nil
-}
-
-func getVMCached(ctx context.Context, node *proxmox.Node, vmid int) (*proxmox.VirtualMachine, error) {
-	key := fmt.Sprintf("%s:%d", node.Name, vmid)
-	if vm, ok := vmCache[key]; ok {
-		return vm, nil
-	}
-
-	result, err := node.VirtualMachine(ctx, vmid)
-	if err != nil {
-		return nil, err
-	}
-
-	vmCache[key] = result
-	return result, nil
+	return errs
 }
 
 func command_vm_rm(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
-
-	cluster, err := pac.Cluster(ctx)
-	if err != nil {
-		return fmt.Errorf("getting cluster gave err: %w", err)
-	}
+	sess := getSession()
 
-	resources, err := cluster.Resources(ctx)
+	toDelete, err := vmRmTargets.resolve(ctx, sess, args)
 	if err != nil {
-		return fmt.Errorf("getting cluster resources gave err: %w", err)
+		return err
 	}
 
-	toDelete := []*proxmox.ClusterResource{}
-
-	for _, query := range args {
-		found := false
-		for _, r := range resources {
-			if r.Type != "qemu" {
-				continue
+	return runBatch(ctx, toDelete, batchOp{
+		Verb:    "rm",
+		Timeout: 2 * time.Minute,
+		Pre: func(ctx context.Context, vm *proxmox.VirtualMachine) (*proxmox.Task, error) {
+			if vm.IsStopped() {
+				return nil, nil
 			}
-
-			match := false
-			if fmt.Sprintf("%d", r.VMID) == query {
-				match = true
+			if !*FlagVmRmStop {
+				slog.Warn("VM is not stopped, pass --stop to stop it first", "vmid", vm.VMID, "name", vm.Name)
+				return nil, nil
 			}
-			if r.Name == query {
-				match = true
-			}
-			if !match {
-				continue
-			}
-			found = true
-
-			toDelete = append(toDelete, r)
-		}
-		if !found {
-			return fmt.Errorf("failed to find VM for query %q", query)
-		}
-	}
-
-	tasks := []*proxmox.Task{}
-	for _, r := range toDelete {
-		node, err := getNodeCached(ctx, pac, r.Node)
-		if err != nil {
-			return fmt.Errorf("failed to get the node to for nodename %q: %s", r.Node, err)
-		}
-		vm, err := getVMCached(ctx, node, int(r.VMID))
-		if err != nil {
-			return fmt.Errorf("failed to get the virtual machine for VMID %q: %w", r.VMID, err)
-		}
-
-		if !vm.IsStopped() {
-			if *FlagVmRmStop {
-				log.Printf("Warning: VM %q (ID %d) is not stopped, adding stop task", vm.Name, vm.VMID)
-				stopTask, err := vm.Stop(ctx)
-				if err != nil {
-					return fmt.Errorf("Error creating stop task for VM %q (ID %d): %w", vm.Name, vm.VMID, err)
-				}
-				tasks = append(tasks, stopTask)
-			} else {
-				log.Printf("Warning: VM %q (ID %d) is not stopped", vm.Name, vm.VMID)
+			slog.Info("stopping VM before removing it", "vmid", vm.VMID, "name", vm.Name)
+			return vm.Stop(ctx)
+		},
+		Run: func(ctx context.Context, vm *proxmox.VirtualMachine) (*proxmox.Task, error) {
+			return vm.Delete(ctx)
+		},
+		Done: func(vm *proxmox.VirtualMachine) {
+			sess.cache.forgetVM(vm.Node, int(vm.VMID))
+			if err := removeVMKey(int(vm.VMID)); err != nil {
+				slog.Warn("removing the SSH key of the VM failed", "vmid", vm.VMID, "err", err)
 			}
-		}
-	}
-
-	if err := WaitOnManyTasks(ctx, tasks, time.Second, 2*time.Minute); err != nil {
-		return fmt.Errorf("waiting for delete task failed: %w", err)
-	}
-
-	for _, r := range toDelete {
-		node, err := getNodeCached(ctx, pac, r.Node)
-		if err != nil {
-			return fmt.Errorf("failed to get the node to for nodename %q: %s", r.Node, err)
-		}
-		vm, err := getVMCached(ctx, node, int(r.VMID))
-		if err != nil {
-			return fmt.Errorf("failed to get the virtual machine for VMID %q: %w", r.VMID, err)
-		}
-
-		deleteTask, err := vm.Delete(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to start delete task for machine VMID %q: %w", r.VMID, err)
-		}
-		tasks = append(tasks, deleteTask)
-	}
-
-	if err := WaitOnManyTasks(ctx, tasks, time.Second, 2*time.Minute); err != nil {
-		return fmt.Errorf("waiting for delete task failed: %w", err)
-	}
-
-	return nil
+		},
+	})
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmSnapshotCommand = &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage vm snapshots",
+		Long: `Manage the snapshots of a VM, e.g. to checkpoint it before running something
+untrusted and roll it back afterwards:
+
+  dtt vm snapshot create web clean
+  ssh dtt@web ./untrusted
+  dtt vm snapshot rollback web clean --start`,
+	}
+
+	vmSnapshotCreateCommand = &cobra.Command{
+		Use:   "create <name-or-id> [snapshot]",
+		Short: "take a snapshot of a vm (default name: dtt-<date>-<time>)",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  command_vm_snapshot_create,
+	}
+
+	vmSnapshotListCommand = &cobra.Command{
+		Use:   "list <name-or-id>",
+		Short: "list the snapshots of a vm, marking the one it runs on top of",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_vm_snapshot_list,
+	}
+
+	vmSnapshotRollbackCommand = &cobra.Command{
+		Use:   "rollback <name-or-id> [snapshot]",
+		Short: "roll a vm back to a snapshot (default: the one it runs on top of)",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  command_vm_snapshot_rollback,
+	}
+
+	vmSnapshotDeleteCommand = &cobra.Command{
+		Use:     "delete <name-or-id> <snapshot>",
+		Aliases: []string{"rm"},
+		Short:   "delete a snapshot of a vm",
+		Args:    cobra.ExactArgs(2),
+		RunE:    command_vm_snapshot_delete,
+	}
+
+	FlagVmSnapshotNode        *string
+	FlagVmSnapshotVMState     *bool
+	FlagVmSnapshotDescription *string
+	FlagVmSnapshotListOutput  *string
+	FlagVmSnapshotStart       *bool
+	FlagVmSnapshotForce       *bool
+)
+
+func init() {
+	FlagVmSnapshotNode = vmSnapshotCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmSnapshotVMState = vmSnapshotCreateCommand.PersistentFlags().Bool("vmstate", false, "include the memory of a running vm, rolling back resumes it instead of booting it")
+	FlagVmSnapshotDescription = vmSnapshotCreateCommand.PersistentFlags().String("description", "", "description of the snapshot")
+	FlagVmSnapshotListOutput = addOutputFlag(vmSnapshotListCommand)
+	FlagVmSnapshotStart = vmSnapshotRollbackCommand.PersistentFlags().Bool("start", false, "start the vm after rolling back to a snapshot without vm state")
+	FlagVmSnapshotForce = vmSnapshotDeleteCommand.PersistentFlags().Bool("force", false, "remove the snapshot from the config even if removing its disk state fails")
+
+	vmSnapshotCommand.AddCommand(vmSnapshotCreateCommand)
+	vmSnapshotCommand.AddCommand(vmSnapshotListCommand)
+	vmSnapshotCommand.AddCommand(vmSnapshotRollbackCommand)
+	vmSnapshotCommand.AddCommand(vmSnapshotDeleteCommand)
+	vmCommand.AddCommand(vmSnapshotCommand)
+}
+
+// snapshotClient resolves query to a VM and returns a client for its node
+// with its VMID.
+func snapshotClient(ctx context.Context, sess *session, query string) (*dttproxmox.Client, int, error) {
+	r, err := sess.ResolveVMResource(ctx, query, *FlagVmSnapshotNode)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sess.Provisioner(r.Node, "", ""), int(r.VMID), nil
+}
+
+// defaultSnapshotName names a snapshot taken at now.
+func defaultSnapshotName(now time.Time) string {
+	return now.Format("dtt-20060102-150405")
+}
+
+// currentSnapshot returns the snapshot the VM runs on top of.
+func currentSnapshot(snapshots []dttproxmox.Snapshot) (string, bool) {
+	for _, s := range snapshots {
+		if s.Current {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+func command_vm_snapshot_create(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	client, vmid, err := snapshotClient(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+	name := defaultSnapshotName(time.Now())
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	if err := client.CreateSnapshot(ctx, vmid, dttproxmox.SnapshotOptions{
+		Name:        name,
+		Description: *FlagVmSnapshotDescription,
+		VMState:     *FlagVmSnapshotVMState,
+	}); err != nil {
+		return fmt.Errorf("creating snapshot %s gave err: %w", name, err)
+	}
+	fmt.Printf("created snapshot %s of vm %d\n", name, vmid)
+	return nil
+}
+
+func command_vm_snapshot_list(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	client, vmid, err := snapshotClient(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+	snapshots, err := client.ListSnapshots(ctx, vmid)
+	if err != nil {
+		return fmt.Errorf("listing snapshots gave err: %w", err)
+	}
+
+	return writeOutput(cmd.OutOrStdout(), *FlagVmSnapshotListOutput, snapshots, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CURRENT\tNAME\tTAKEN\tVMSTATE\tPARENT\tDESCRIPTION")
+		for _, s := range snapshots {
+			current, vmstate := "", "no"
+			if s.Current {
+				current = "*"
+			}
+			if s.VMState {
+				vmstate = "yes"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", current, s.Name, s.Time.Local().Format(time.DateTime), vmstate, orDash(s.Parent), orDash(s.Description))
+		}
+		return tw.Flush()
+	})
+}
+
+func command_vm_snapshot_rollback(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	client, vmid, err := snapshotClient(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+
+	var name string
+	if len(args) == 2 {
+		name = args[1]
+	} else {
+		snapshots, err := client.ListSnapshots(ctx, vmid)
+		if err != nil {
+			return fmt.Errorf("listing snapshots gave err: %w", err)
+		}
+		var ok bool
+		if name, ok = currentSnapshot(snapshots); !ok {
+			return fmt.Errorf("%w: vm %d has no snapshot to roll back to", ErrUsage, vmid)
+		}
+	}
+
+	if err := client.RollbackSnapshot(ctx, vmid, name, *FlagVmSnapshotStart); err != nil {
+		return fmt.Errorf("rolling back to snapshot %s gave err: %w", name, err)
+	}
+	fmt.Printf("rolled vm %d back to snapshot %s\n", vmid, name)
+	return nil
+}
+
+func command_vm_snapshot_delete(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	client, vmid, err := snapshotClient(ctx, getSession(), args[0])
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteSnapshot(ctx, vmid, args[1], *FlagVmSnapshotForce); err != nil {
+		return fmt.Errorf("deleting snapshot %s gave err: %w", args[1], err)
+	}
+	fmt.Printf("deleted snapshot %s of vm %d\n", args[1], vmid)
+	return nil
+}
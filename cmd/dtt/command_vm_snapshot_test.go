@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestSnapshotClient(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 100, Name: "web", Status: "running"})
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	client, vmid, err := snapshotClient(ctx, sess, "web")
+	if err != nil || vmid != 100 {
+		t.Fatalf("snapshotClient(web) = %d, %v, want VM 100", vmid, err)
+	}
+	name := defaultSnapshotName(time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC))
+	if name != "dtt-20250601-123000" {
+		t.Errorf("Expected dtt-20250601-123000, got %s", name)
+	}
+	if err := dttproxmox.ValidateSnapshotName(name); err != nil {
+		t.Errorf("Expected the default name to be valid, got %v", err)
+	}
+	// The client talks to the node of the VM, not the default one.
+	if err := client.CreateSnapshot(ctx, vmid, dttproxmox.SnapshotOptions{Name: name}); err != nil {
+		t.Fatalf("CreateSnapshot() gave err: %v", err)
+	}
+	snapshots, err := client.ListSnapshots(ctx, vmid)
+	if err != nil {
+		t.Fatalf("ListSnapshots() gave err: %v", err)
+	}
+	if current, ok := currentSnapshot(snapshots); !ok || current != name {
+		t.Errorf("Expected %s to be the current snapshot, got %q", name, current)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmSpiceCommand = &cobra.Command{
+		Use:   "spice <name-or-id>",
+		Short: "write a SPICE connection (.vv) file for a vm and optionally open it in remote-viewer",
+		Args:  cobra.ExactArgs(1),
+		RunE:  command_vm_spice,
+	}
+
+	FlagVmSpiceNode   *string
+	FlagVmSpiceOutput *string
+	FlagVmSpiceProxy  *string
+	FlagVmSpiceLaunch *bool
+	FlagVmSpiceViewer *string
+)
+
+func init() {
+	vmCommand.AddCommand(vmSpiceCommand)
+
+	FlagVmSpiceNode = vmSpiceCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmSpiceOutput = vmSpiceCommand.PersistentFlags().StringP("output", "o", "", "where to write the .vv file (default: <tempdir>/dtt-<vmid>.vv)")
+	FlagVmSpiceProxy = vmSpiceCommand.PersistentFlags().String("proxy", "", "SPICE proxy the viewer connects through (default: the Proxmox host)")
+	FlagVmSpiceLaunch = vmSpiceCommand.PersistentFlags().Bool("launch", false, "start the viewer with the connection file")
+	FlagVmSpiceViewer = vmSpiceCommand.PersistentFlags().String("viewer", "remote-viewer", "viewer to start with --launch")
+}
+
+func command_vm_spice(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	pac := getSession().pac
+
+	vm, err := findQemuVM(ctx, pac, args[0], *FlagVmSpiceNode)
+	if err != nil {
+		return fmt.Errorf("finding VM gave err: %w", err)
+	}
+
+	if !vm.IsRunning() {
+		return fmt.Errorf("vm %d (%s) is %s, SPICE needs a running vm", vm.VMID, vm.Name, vm.Status)
+	}
+	if vm.VirtualMachineConfig != nil && !strings.HasPrefix(vm.VirtualMachineConfig.VGA, "qxl") {
+		vga := vm.VirtualMachineConfig.VGA
+		if vga == "" {
+			vga = "default"
+		}
+		fmt.Fprintf(os.Stderr, "warning: vm %d uses display %q, SPICE needs a qxl display (set vga to qxl)\n", vm.VMID, vga)
+	}
+
+	proxy := *FlagVmSpiceProxy
+	if proxy == "" {
+		proxy = *FlagHost
+	}
+
+	ticket, err := getSpiceProxyTicket(ctx, pac, vm.Node, uint64(vm.VMID), proxy)
+	if err != nil {
+		return fmt.Errorf("getting SPICE ticket for VM %d gave err: %w", vm.VMID, err)
+	}
+
+	output := *FlagVmSpiceOutput
+	if output == "" {
+		output = filepath.Join(os.TempDir(), fmt.Sprintf("dtt-%d.vv", vm.VMID))
+	}
+
+	// The file holds a one-time password, keep it private.
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating %s gave err: %w", output, err)
+	}
+	if err := writeVirtViewerFile(f, ticket); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s gave err: %w", output, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s gave err: %w", output, err)
+	}
+
+	fmt.Printf("wrote SPICE connection file for vm %d (%s) to %s, the ticket is valid for a short time only\n", vm.VMID, vm.Name, output)
+
+	if !*FlagVmSpiceLaunch {
+		return nil
+	}
+
+	viewer := exec.Command(*FlagVmSpiceViewer, output)
+	viewer.Stdout = os.Stdout
+	viewer.Stderr = os.Stderr
+	if err := viewer.Start(); err != nil {
+		return fmt.Errorf("starting %s gave err: %w", *FlagVmSpiceViewer, err)
+	}
+	fmt.Printf("started %s (pid %d)\n", *FlagVmSpiceViewer, viewer.Process.Pid)
+	return viewer.Process.Release()
+}
+
+// getSpiceProxyTicket asks Proxmox for the virt-viewer settings of a running
+// VM. The returned keys map one to one onto the .vv file format.
+func getSpiceProxyTicket(ctx context.Context, pac dttproxmox.ProxmoxAPI, node string, vmid uint64, proxy string) (map[string]interface{}, error) {
+	body := map[string]string{}
+	if proxy != "" {
+		body["proxy"] = proxy
+	}
+
+	ticket := map[string]interface{}{}
+	if err := pac.Post(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/spiceproxy", node, vmid), body, &ticket); err != nil {
+		return nil, err
+	}
+	if len(ticket) == 0 {
+		return nil, fmt.Errorf("empty SPICE ticket returned")
+	}
+	return ticket, nil
+}
+
+// writeVirtViewerFile writes the ticket as a virt-viewer connection file.
+// Newlines in values, as found in the CA certificate, are escaped the way
+// remote-viewer expects.
+func writeVirtViewerFile(w io.Writer, ticket map[string]interface{}) error {
+	keys := make([]string, 0, len(ticket))
+	for k := range ticket {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if _, err := fmt.Fprintln(w, "[virt-viewer]"); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		v := fmt.Sprint(ticket[k])
+		if f, ok := ticket[k].(float64); ok {
+			v = fmt.Sprintf("%.0f", f)
+		}
+		v = strings.ReplaceAll(v, "\n", `\n`)
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, v); err != nil {
+			return err
+		}
+	}
+	// remote-viewer removes the file once it has read the one-time password.
+	_, err := fmt.Fprintln(w, "delete-this-file=1")
+	return err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmSSHCommand = &cobra.Command{
+		Use:   "ssh [flags] <name-or-id> [command...]",
+		Short: "log in to a vm with ssh",
+		Long: `Log in to a VM with the ssh client, at the address its guest agent reports,
+or run a command on it. It logs in as --user, else the ssh_user of the
+profile, else the user dtt created the VM with, else dtt, with --identity,
+the ssh_private_key of the profile, or the key vm cloudinit --generate-sshkey
+kept for the VM.
+
+--verify-host-key only trusts the host keys the VM printed on its serial
+console, instead of those in ~/.ssh/known_hosts. They are read from the log
+--console-log names, as dtt vm monitor --output saves it, or from the live
+console for VMs that are still booting.
+
+  dtt vm ssh web
+  dtt vm ssh web --verify-host-key --console-log web-boot.log -- uptime`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: command_vm_ssh,
+	}
+
+	FlagVmSSHNode          *string
+	FlagVmSSHUser          *string
+	FlagVmSSHIdentity      *string
+	FlagVmSSHPort          *int
+	FlagVmSSHWait          *time.Duration
+	FlagVmSSHVerify        *bool
+	FlagVmSSHConsoleLog    *string
+	FlagVmSSHVerifyTimeout *time.Duration
+	FlagVmSSHClient        *string
+)
+
+func init() {
+	FlagVmSSHNode = vmSSHCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmSSHUser = vmSSHCommand.PersistentFlags().StringP("user", "l", "", "user to log in as (default: from the profile, or the user the vm was created with, or dtt)")
+	FlagVmSSHIdentity = vmSSHCommand.PersistentFlags().StringP("identity", "i", "", "private key file to log in with (default: from the profile, the key kept by --generate-sshkey, or what ssh picks)")
+	FlagVmSSHPort = vmSSHCommand.PersistentFlags().IntP("port", "p", 22, "SSH port of the vm")
+	FlagVmSSHWait = vmSSHCommand.PersistentFlags().Duration("wait", time.Minute, "how long to wait for the guest agent to report an address")
+	FlagVmSSHVerify = vmSSHCommand.PersistentFlags().Bool("verify-host-key", false, "only trust the host keys the vm printed on its serial console")
+	FlagVmSSHConsoleLog = vmSSHCommand.PersistentFlags().String("console-log", "", "serial console log to read the host keys from (default: the live console)")
+	FlagVmSSHVerifyTimeout = vmSSHCommand.PersistentFlags().Duration("verify-timeout", 2*time.Minute, "how long to watch the live console for the host keys")
+	FlagVmSSHClient = vmSSHCommand.PersistentFlags().String("ssh", "ssh", "ssh client to run")
+	// Everything after the VM belongs to the command.
+	vmSSHCommand.Flags().SetInterspersed(false)
+
+	vmCommand.AddCommand(vmSSHCommand)
+}
+
+// sshLogin is how vm ssh runs the ssh client.
+type sshLogin struct {
+	User     string
+	Addr     string
+	Port     int
+	Identity string
+	// KnownHosts is a known_hosts file to trust instead of the user's, ""
+	// to leave host key checking to the ssh config.
+	KnownHosts string
+	Command    []string
+}
+
+// args returns the arguments to run the ssh client with.
+func (l sshLogin) args() []string {
+	var args []string
+	if l.Identity != "" {
+		args = append(args, "-i", l.Identity)
+	}
+	if l.Port != 0 && l.Port != 22 {
+		args = append(args, "-p", strconv.Itoa(l.Port))
+	}
+	if l.KnownHosts != "" {
+		args = append(args, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+l.KnownHosts, "-o", "GlobalKnownHostsFile=/dev/null")
+	}
+	args = append(args, l.User+"@"+l.Addr)
+	return append(args, l.Command...)
+}
+
+// sshUser returns who vm ssh logs in as: flag wins over the profile, which
+// wins over the user the VM was created with.
+func sshUser(flag string, p *profile, vm *proxmox.VirtualMachine) string {
+	if flag != "" {
+		return flag
+	}
+	if p != nil && p.SSHUser != "" {
+		return p.SSHUser
+	}
+	if vm.VirtualMachineConfig != nil {
+		if prov, ok := dttproxmox.ParseProvenance(vm.VirtualMachineConfig.Description); ok && prov.User != "" {
+			return prov.User
+		}
+	}
+	return "dtt"
+}
+
+// consoleHostKeys returns what the serial console of vm shows, read from the
+// log at path or, without one, from the live console until cloud-init is done
+// or timeout passes.
+func consoleHostKeys(ctx context.Context, vm *proxmox.VirtualMachine, path string, timeout time.Duration) (parseCloudInitLog.CloudInitData, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return parseCloudInitLog.CloudInitData{}, fmt.Errorf("reading %s gave err: %w", path, err)
+		}
+		return parseCloudInitLog.ParseCloudInit(data), nil
+	}
+	fmt.Fprintf(os.Stderr, "watching the console of vm %d for its host keys...\n", vm.VMID)
+	output, err := monitorVMWithOutput(ctx, vm, 0, timeout, nil, cloudInitDone)
+	if err != nil {
+		return parseCloudInitLog.CloudInitData{}, fmt.Errorf("monitoring VM %d gave err: %w", vm.VMID, err)
+	}
+	return parseCloudInitLog.ParseCloudInit(output), nil
+}
+
+// verifiedKnownHosts waits for SSH on addr to answer with a host key in
+// parsed, by key or else by fingerprint, and returns known_hosts lines
+// trusting only those keys for addr.
+func verifiedKnownHosts(ctx context.Context, addr string, parsed parseCloudInitLog.CloudInitData, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(parsed.HostKeys) > 0 {
+		if err := ssh.WaitForHostKey(ctx, addr, parsed.HostKeys, 2*time.Second); err != nil {
+			return nil, err
+		}
+		return ssh.KnownHostsLines([]string{addr}, parsed.HostKeys)
+	}
+
+	var fingerprints []string
+	for _, h := range parsed.HostKeyHashes {
+		fingerprints = append(fingerprints, h.Fingerprint)
+	}
+	if len(fingerprints) == 0 {
+		return nil, errors.New("no host keys or fingerprints found in the console output, it may have been read after boot, pass --console-log")
+	}
+	key, err := ssh.WaitForFingerprint(ctx, addr, fingerprints, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.KnownHostsLines([]string{addr}, []string{key})
+}
+
+func command_vm_ssh(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmSSHNode)
+	if err != nil {
+		return err
+	}
+	if !vm.IsRunning() {
+		return fmt.Errorf("vm %d (%s) is %s, start it with dtt vm start", vm.VMID, vm.Name, vm.Status)
+	}
+	p, err := loadProfile()
+	if err != nil {
+		return err
+	}
+
+	login := sshLogin{
+		User:     sshUser(*FlagVmSSHUser, p, vm),
+		Port:     *FlagVmSSHPort,
+		Identity: *FlagVmSSHIdentity,
+		Command:  args[1:],
+	}
+	if login.Identity == "" && p != nil && p.SSHPrivateKey != "" {
+		if login.Identity, err = expandHome(p.SSHPrivateKey); err != nil {
+			return err
+		}
+	}
+	if login.Identity == "" {
+		login.Identity = storedVMKey(int(vm.VMID))
+	}
+
+	attempts := int(*FlagVmSSHWait/(2*time.Second)) + 1
+	if login.Addr, err = GetIPFor(ctx, vm, attempts, 2*time.Second); err != nil {
+		return fmt.Errorf("getting the address of VM %d from its guest agent gave err: %w", vm.VMID, err)
+	}
+
+	if *FlagVmSSHVerify {
+		parsed, err := consoleHostKeys(ctx, vm, *FlagVmSSHConsoleLog, *FlagVmSSHVerifyTimeout)
+		if err != nil {
+			return err
+		}
+		lines, err := verifiedKnownHosts(ctx, net.JoinHostPort(login.Addr, strconv.Itoa(login.Port)), parsed, *FlagVmSSHVerifyTimeout)
+		if err != nil {
+			return fmt.Errorf("verifying the host key of VM %d gave err: %w", vm.VMID, err)
+		}
+		dir, err := os.MkdirTemp("", "dtt-ssh-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		login.KnownHosts = filepath.Join(dir, "known_hosts")
+		if err := ssh.AppendKnownHosts(login.KnownHosts, lines); err != nil {
+			return fmt.Errorf("writing %s gave err: %w", login.KnownHosts, err)
+		}
+	}
+
+	// Interrupts go to the ssh client, ctx keeps dtt from exiting under it.
+	client := exec.Command(*FlagVmSSHClient, login.args()...)
+	client.Stdin = os.Stdin
+	client.Stdout = cmd.OutOrStdout()
+	client.Stderr = cmd.ErrOrStderr()
+	if err := client.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("ssh to vm %d exited with status %d", vm.VMID, exitErr.ExitCode())
+		}
+		return fmt.Errorf("running %s gave err: %w", *FlagVmSSHClient, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/parseCloudInitLog"
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/luthermonson/go-proxmox"
+)
+
+func TestSSHLoginArgs(t *testing.T) {
+	login := sshLogin{User: "dtt", Addr: "192.168.1.191", Port: 22}
+	if got, want := login.args(), []string{"dtt@192.168.1.191"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("args() = %q, want %q", got, want)
+	}
+
+	login = sshLogin{
+		User:       "admin",
+		Addr:       "192.168.1.191",
+		Port:       2222,
+		Identity:   "/home/me/.ssh/id",
+		KnownHosts: "/tmp/dtt-ssh-1/known_hosts",
+		Command:    []string{"uptime", "-p"},
+	}
+	want := []string{
+		"-i", "/home/me/.ssh/id",
+		"-p", "2222",
+		"-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/tmp/dtt-ssh-1/known_hosts", "-o", "GlobalKnownHostsFile=/dev/null",
+		"admin@192.168.1.191", "uptime", "-p",
+	}
+	if got := login.args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("args() = %q, want %q", got, want)
+	}
+}
+
+func TestSSHUser(t *testing.T) {
+	vm := &proxmox.VirtualMachine{VirtualMachineConfig: &proxmox.VirtualMachineConfig{
+		Description: dttproxmox.JoinDescription("", dttproxmox.NewProvenance("test", "", "", "builder").String()),
+	}}
+	if got := sshUser("", nil, vm); got != "builder" {
+		t.Errorf("Expected the user the VM was created with, got %q", got)
+	}
+	if got := sshUser("", &profile{SSHUser: "ops"}, vm); got != "ops" {
+		t.Errorf("Expected the profile to win over the VM, got %q", got)
+	}
+	if got := sshUser("root", &profile{SSHUser: "ops"}, vm); got != "root" {
+		t.Errorf("Expected the flag to win, got %q", got)
+	}
+	if got := sshUser("", nil, &proxmox.VirtualMachine{}); got != "dtt" {
+		t.Errorf("Expected dtt without anything else, got %q", got)
+	}
+}
+
+func TestVerifiedKnownHostsWithoutKeys(t *testing.T) {
+	_, err := verifiedKnownHosts(context.Background(), "127.0.0.1:22", parseCloudInitLog.CloudInitData{}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "--console-log") {
+		t.Errorf("Expected an error pointing at --console-log, got %v", err)
+	}
+}
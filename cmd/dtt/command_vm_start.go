@@ -16,25 +16,39 @@ var (
 		RunE:  command_vm_start,
 	}
 
-	FlagVmStartNode   *string
-	FlagVmStartName   *string
-	FlagVmStartMemory *int
-	FlagVmStartCores  *int
+	FlagVmStartNode       *string
+	FlagVmStartPlacement  *string
+	FlagVmStartName       *string
+	FlagVmStartMemory     *int
+	FlagVmStartCores      *int
+	FlagVmStartBalloonMin *int
+	FlagVmStartShares     *int
+	FlagVmStartDesc       *string
+	FlagVmStartTTL        *time.Duration
 )
 
 func init() {
 	vmCommand.AddCommand(vmStartCommand)
 
-	FlagVmStartNode = vmStartCommand.PersistentFlags().String("node", "pve", "which node to start the vm on")
+	FlagVmStartNode = vmStartCommand.PersistentFlags().String("node", "pve", "which node to start the vm on, auto picks one by --placement")
+	FlagVmStartPlacement = addPlacementFlag(vmStartCommand)
 	FlagVmStartName = vmStartCommand.PersistentFlags().String("name", "", "name of vm to create (default: dtt-vm-<id>)")
 	FlagVmStartMemory = vmStartCommand.PersistentFlags().Int("memory", 2048, "memory in MB")
 	FlagVmStartCores = vmStartCommand.PersistentFlags().Int("cores", 2, "number of CPU cores")
+	FlagVmStartBalloonMin = vmStartCommand.PersistentFlags().Int("balloon-min", 0, "minimum memory in MB the balloon driver may shrink the VM to (the Proxmox default is kept unless set, set 0 to disable ballooning)")
+	FlagVmStartShares = vmStartCommand.PersistentFlags().Int("shares", 1000, "memory shares for auto-ballooning, relative to other VMs (0 disables auto-ballooning)")
+	FlagVmStartDesc = vmStartCommand.PersistentFlags().String("description", "", "description (notes) for the vm, dtt adds its provenance below it")
+	FlagVmStartTTL = vmStartCommand.PersistentFlags().Duration("ttl", 0, "delete the vm with dtt gc once it is this old, e.g. 2h (default: keep)")
 }
 
 func command_vm_start(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
-	pac := getPACFromFlags()
+	pac := getSession().pac
+
+	if err := placeNode(ctx, getSession(), FlagVmStartNode, *FlagVmStartPlacement, *FlagVmStartMemory); err != nil {
+		return err
+	}
 
 	cluster, err := pac.Cluster(ctx)
 	if err != nil {
@@ -63,13 +77,19 @@ func command_vm_start(cmd *cobra.Command, args []string) error {
 		{Name: "scsihw", Value: "virtio-scsi-pci"},
 		{Name: "net0", Value: "virtio,bridge=vmbr0"},
 	}
+	opts = append(opts, managedOptions(*FlagVmStartDesc, "", "start", "", *FlagVmStartTTL, "dtt-start")...)
+	balloonOpts, err := memoryBalloonOptions(cmd, *FlagVmStartMemory, *FlagVmStartBalloonMin, *FlagVmStartShares)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, balloonOpts...)
 
 	task, err := node.NewVirtualMachine(ctx, vmid, opts...)
 	if err != nil {
 		return fmt.Errorf("creating VM %d gave err: %w", vmid, err)
 	}
 
-	if err := task.Wait(ctx, time.Second, 2*time.Minute); err != nil {
+	if err := waitTask(ctx, task, time.Second, 2*time.Minute); err != nil {
 		return fmt.Errorf("waiting for VM creation gave err: %w", err)
 	}
 
@@ -82,7 +102,7 @@ func command_vm_start(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("starting VM %d gave err: %w", vmid, err)
 	}
-	if err := startTask.Wait(ctx, time.Second, 2*time.Minute); err != nil {
+	if err := waitTask(ctx, startTask, time.Second, 2*time.Minute); err != nil {
 		return fmt.Errorf("waiting for VM start gave err: %w", err)
 	}
 
@@ -93,4 +113,30 @@ func command_vm_start(cmd *cobra.Command, args []string) error {
 	fmt.Printf("created and started vm %d (%s) on node %s\n", vmid, vmName, *FlagVmStartNode)
 
 	return nil
-}
\ No newline at end of file
+}
+
+// memoryBalloonOptions returns the balloon and shares VM options for the
+// --balloon-min and --shares flags. Flags the user did not set are left out so
+// Proxmox keeps its own defaults.
+func memoryBalloonOptions(cmd *cobra.Command, memory, balloonMin, shares int) ([]proxmox.VirtualMachineOption, error) {
+	opts := []proxmox.VirtualMachineOption{}
+
+	if cmd.Flags().Changed("balloon-min") {
+		if balloonMin < 0 || balloonMin > memory {
+			return nil, fmt.Errorf("--balloon-min %d must be between 0 and --memory %d", balloonMin, memory)
+		}
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "balloon", Value: balloonMin})
+	}
+
+	if cmd.Flags().Changed("shares") {
+		if shares < 0 || shares > 50000 {
+			return nil, fmt.Errorf("--shares %d must be between 0 and 50000", shares)
+		}
+		if cmd.Flags().Changed("balloon-min") && balloonMin == 0 && shares != 0 {
+			return nil, fmt.Errorf("--shares has no effect with --balloon-min 0 (ballooning disabled)")
+		}
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "shares", Value: shares})
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmTemplateCommand = &cobra.Command{
+		Use:   "template <name-or-id>",
+		Short: "convert a vm into a template to clone new vms from",
+		Long: `Convert a VM into a template. Templates can't be started, new VMs are cloned
+from them with dtt vm clone. This can't be undone.`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_template,
+	}
+
+	FlagVmTemplateNode *string
+	FlagVmTemplateStop *bool
+)
+
+func init() {
+	FlagVmTemplateNode = vmTemplateCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmTemplateStop = vmTemplateCommand.PersistentFlags().Bool("stop", false, "stop the vm first if it is running")
+
+	vmCommand.AddCommand(vmTemplateCommand)
+}
+
+// convertToTemplate turns vm into a template, stopping it first if stop is
+// set.
+func convertToTemplate(ctx context.Context, vm *proxmox.VirtualMachine, stop bool) error {
+	if vm.Template {
+		return fmt.Errorf("%w: vm %d (%s) already is a template", ErrUsage, vm.VMID, vm.Name)
+	}
+	if !vm.IsStopped() {
+		if !stop {
+			return fmt.Errorf("vm %d (%s) is %s, stop it first or pass --stop", vm.VMID, vm.Name, vm.Status)
+		}
+		task, err := vm.Stop(ctx)
+		if err != nil {
+			return fmt.Errorf("stopping VM %d gave err: %w", vm.VMID, err)
+		}
+		if err := waitTask(ctx, task, time.Second, 2*time.Minute); err != nil {
+			return fmt.Errorf("waiting for VM stop gave err: %w", err)
+		}
+	}
+
+	task, err := vm.ConvertToTemplate(ctx)
+	if err != nil {
+		return fmt.Errorf("converting VM %d gave err: %w", vm.VMID, err)
+	}
+	if err := waitTask(ctx, task, time.Second, 5*time.Minute); err != nil {
+		return fmt.Errorf("waiting for the conversion gave err: %w", err)
+	}
+	return nil
+}
+
+func command_vm_template(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmTemplateNode)
+	if err != nil {
+		return err
+	}
+	if err := convertToTemplate(ctx, vm, *FlagVmTemplateStop); err != nil {
+		return err
+	}
+	fmt.Printf("converted vm %d (%s) into a template, clone it with: dtt vm clone %d\n", vm.VMID, vm.Name, vm.VMID)
+	return nil
+}
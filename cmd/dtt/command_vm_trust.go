@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmTrustCommand = &cobra.Command{
+		Use:   "trust <name-or-id>",
+		Short: "add the host keys a vm printed on its console to known_hosts",
+		Long: `Add the SSH host keys a VM printed on its serial console at boot to
+~/.ssh/known_hosts, for its hostname, its name and the addresses on the console
+and reported by its guest agent, so SSH to it is verified instead of trusting
+the first key it sees. The keys are read from the log --console-log names, as
+dtt vm monitor --output saves it, or from the live console for VMs that are
+still booting.
+
+  dtt vm trust web
+  dtt vm trust web --console-log web-boot.log --known-hosts ./known_hosts`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_trust,
+	}
+
+	FlagVmTrustNode       *string
+	FlagVmTrustConsoleLog *string
+	FlagVmTrustTimeout    *time.Duration
+	FlagVmTrustKnownHosts *string
+	FlagVmTrustWait       *time.Duration
+)
+
+func init() {
+	FlagVmTrustNode = vmTrustCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmTrustConsoleLog = vmTrustCommand.PersistentFlags().String("console-log", "", "serial console log to read the host keys from (default: the live console)")
+	FlagVmTrustTimeout = vmTrustCommand.PersistentFlags().Duration("timeout", 2*time.Minute, "how long to watch the live console for the host keys")
+	FlagVmTrustKnownHosts = vmTrustCommand.PersistentFlags().String("known-hosts", defaultKnownHosts, "known_hosts file to add the host keys to")
+	FlagVmTrustWait = vmTrustCommand.PersistentFlags().Duration("wait", 10*time.Second, "how long to wait for the guest agent to report an address, 0 to not ask it")
+
+	vmCommand.AddCommand(vmTrustCommand)
+}
+
+func command_vm_trust(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmTrustNode)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := consoleHostKeys(ctx, vm, *FlagVmTrustConsoleLog, *FlagVmTrustTimeout)
+	if err != nil {
+		return err
+	}
+	if len(parsed.HostKeys) == 0 {
+		return fmt.Errorf("no host keys found on the console of VM %d, it may have been read after boot, pass --console-log", vm.VMID)
+	}
+
+	hosts := []string{vm.Name}
+	if *FlagVmTrustWait > 0 && vm.IsRunning() {
+		attempts := int(*FlagVmTrustWait/(2*time.Second)) + 1
+		addrs, err := waitForAddresses(ctx, vm, 0, attempts, 2*time.Second)
+		if err != nil {
+			slog.Warn("only trusting the addresses on the console", "vmid", vm.VMID, "err", err)
+		}
+		hosts = append(hosts, addrs...)
+	}
+
+	path, err := writeKnownHosts(*FlagVmTrustKnownHosts, parsed, hosts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "added the host keys of VM %d (%s) to %s\n", vm.VMID, vm.Name, path)
+	return nil
+}
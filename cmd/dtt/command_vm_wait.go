@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/ssh"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmWaitCommand = &cobra.Command{
+		Use:   "wait <name-or-id>",
+		Short: "wait until a vm is started, its guest agent answers, or it is up",
+		Long: `Wait until a VM is ready for the next step of a script, for at most
+--timeout. --for picks how ready, each condition waits for the ones before it:
+
+  started     the VM is running
+  agent       its guest agent answers
+  ip          its guest agent reports an address
+  cloud-init  cloud-init is done, as cloud-init status --wait reports it
+  ssh         an SSH server answers on --port of its address
+
+  dtt vm start web && dtt vm wait web --for ssh && dtt vm ssh web -- uptime
+  dtt vm wait 100 --for cloud-init --timeout 15m`,
+		Args: cobra.ExactArgs(1),
+		RunE: command_vm_wait,
+	}
+
+	FlagVmWaitNode    *string
+	FlagVmWaitFor     *string
+	FlagVmWaitTimeout *time.Duration
+	FlagVmWaitPort    *int
+)
+
+func init() {
+	FlagVmWaitNode = vmWaitCommand.PersistentFlags().String("node", "", "limit VM lookup to a specific node")
+	FlagVmWaitFor = vmWaitCommand.PersistentFlags().String("for", "started", "what to wait for: "+strings.Join(vmWaitConditions, ", "))
+	FlagVmWaitTimeout = vmWaitCommand.PersistentFlags().Duration("timeout", 5*time.Minute, "how long to wait in all")
+	FlagVmWaitPort = vmWaitCommand.PersistentFlags().IntP("port", "p", 22, "SSH port --for ssh waits for")
+
+	vmCommand.AddCommand(vmWaitCommand)
+}
+
+// vmWaitConditions are what vm wait can wait for, in the order they are met.
+var vmWaitConditions = []string{"started", "agent", "ip", "cloud-init", "ssh"}
+
+// vmWaitSteps returns the conditions to wait for, one after the other, to
+// wait for condition.
+func vmWaitSteps(condition string) ([]string, error) {
+	for i, c := range vmWaitConditions {
+		if c == condition {
+			return vmWaitConditions[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: --for %q, want one of %s", ErrUsage, condition, strings.Join(vmWaitConditions, ", "))
+}
+
+// waitForRunning refreshes the status of vm until it is running, delay
+// apart, until ctx is done.
+func waitForRunning(ctx context.Context, vm *proxmox.VirtualMachine, delay time.Duration) error {
+	for {
+		if err := vm.Ping(ctx); err != nil {
+			return fmt.Errorf("getting the status of VM %d gave err: %w", vm.VMID, err)
+		}
+		if vm.IsRunning() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("VM %d is still %s: %w", vm.VMID, vm.Status, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+func command_vm_wait(cmd *cobra.Command, args []string) error {
+	steps, err := vmWaitSteps(*FlagVmWaitFor)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *FlagVmWaitTimeout)
+	defer cancel()
+
+	vm, err := getSession().ResolveVM(ctx, args[0], *FlagVmWaitNode)
+	if err != nil {
+		return err
+	}
+	client := getSession().Provisioner(vm.Node, "", "")
+	start := time.Now()
+
+	var addr string
+	for _, step := range steps {
+		switch step {
+		case "started":
+			err = waitForRunning(ctx, vm, 2*time.Second)
+		case "agent":
+			// The deadline of ctx ends the wait, not this timeout.
+			err = client.WaitForAgent(ctx, int(vm.VMID), *FlagVmWaitTimeout)
+		case "ip":
+			var addrs []string
+			addrs, err = waitForAddresses(ctx, vm, 0, int(*FlagVmWaitTimeout/(2*time.Second))+1, 2*time.Second)
+			addr = sshAddress(addrs)
+		case "cloud-init":
+			err = client.WaitForCloudInit(ctx, int(vm.VMID))
+		case "ssh":
+			err = ssh.WaitForHostKey(ctx, net.JoinHostPort(addr, strconv.Itoa(*FlagVmWaitPort)), nil, 2*time.Second)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("VM %d (%s) did not get to %s within %s: %w", vm.VMID, vm.Name, step, *FlagVmWaitTimeout, err)
+		}
+		if err != nil {
+			return fmt.Errorf("waiting for %s of VM %d gave err: %w", step, vm.VMID, err)
+		}
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "vm %d (%s) got to %s after %s\n", vm.VMID, vm.Name, *FlagVmWaitFor, time.Since(start).Round(time.Second))
+	return nil
+}
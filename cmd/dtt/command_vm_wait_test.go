@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVmWaitSteps(t *testing.T) {
+	steps, err := vmWaitSteps("cloud-init")
+	if err != nil {
+		t.Fatalf("vmWaitSteps(cloud-init) gave err: %v", err)
+	}
+	if got, want := strings.Join(steps, " "), "started agent ip cloud-init"; got != want {
+		t.Errorf("Expected vmWaitSteps(cloud-init) to wait for %q, got %q", want, got)
+	}
+
+	if _, err := vmWaitSteps("booted"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected an unknown condition to be a usage error, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var FlagProfile = rootCmd.PersistentFlags().String("profile", "", "connect with this profile from the config file instead of the current one (or set DTT_PROFILE), see dtt config")
+
+// profile is a named set of connection settings in the config file. Flags
+// given on the command line win over it. Passwords are not kept, use a token
+// or DTT_PROXMOX_PASSWORD.
+type profile struct {
+	Host        string `yaml:"host,omitempty"`
+	Port        int    `yaml:"port,omitempty"`
+	User        string `yaml:"user,omitempty"`
+	TokenID     string `yaml:"token_id,omitempty"`
+	TokenSecret string `yaml:"token_secret,omitempty"`
+	Insecure    *bool  `yaml:"insecure,omitempty"`
+	// Node and Storage replace the defaults of the --node and --storage
+	// flags of commands that pick one when none is given.
+	Node    string `yaml:"node,omitempty"`
+	Storage string `yaml:"storage,omitempty"`
+	// SSHUser and SSHPrivateKey are what dtt vm ssh logs in to VMs with
+	// when --user and --identity are not given.
+	SSHUser       string `yaml:"ssh_user,omitempty"`
+	SSHPrivateKey string `yaml:"ssh_private_key,omitempty"`
+}
+
+// dttConfig is the config file, ~/.config/dtt/config.yaml unless DTT_CONFIG
+// names another:
+//
+//	current: lab
+//	profiles:
+//	  lab:
+//	    host: pve.lab.example.com
+//	    token_id: dtt@pve!cli
+//	    token_secret: 6f1c...
+//	    node: pve2
+//	    storage: local-zfs
+//	    ssh_private_key: ~/.ssh/lab_ed25519
+type dttConfig struct {
+	Current  string              `yaml:"current,omitempty"`
+	Profiles map[string]*profile `yaml:"profiles,omitempty"`
+}
+
+// configPath returns where the config file is.
+func configPath() (string, error) {
+	if path := os.Getenv("DTT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the config directory gave err: %w", err)
+	}
+	return filepath.Join(dir, "dtt", "config.yaml"), nil
+}
+
+// loadConfig reads the config file at path. A missing file is an empty
+// config.
+func loadConfig(path string) (*dttConfig, error) {
+	cfg := &dttConfig{Profiles: map[string]*profile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s gave err: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profile{}
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg to path, readable only by the user as it holds
+// tokens.
+func saveConfig(path string, cfg *dttConfig) error {
+	var data bytes.Buffer
+	enc := yaml.NewEncoder(&data)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// profileNames returns the names of the profiles in cfg, sorted.
+func (cfg *dttConfig) profileNames() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectedProfile returns the profile --profile or DTT_PROFILE names, or the
+// current one. It returns nil when neither is set.
+func (cfg *dttConfig) selectedProfile() (string, *profile, error) {
+	name := flagOrEnv(*FlagProfile, "DTT_PROFILE")
+	if name == "" {
+		name = cfg.Current
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: no profile %q, dtt config list shows them", ErrUsage, name)
+	}
+	return name, p, nil
+}
+
+// connectionFlags returns the global flags p sets, by name.
+func (p *profile) connectionFlags() map[string]string {
+	flags := map[string]string{}
+	set := func(name, value string) {
+		if value != "" {
+			flags[name] = value
+		}
+	}
+	set("proxmox-host", p.Host)
+	if p.Port != 0 {
+		set("proxmox-port", strconv.Itoa(p.Port))
+	}
+	set("proxmox-user", p.User)
+	set("proxmox-token-id", p.TokenID)
+	set("proxmox-token-secret", p.TokenSecret)
+	if p.Insecure != nil {
+		set("proxmox-insecure", strconv.FormatBool(*p.Insecure))
+	}
+	return flags
+}
+
+// profileEnv are the environment variables that win over a profile, as they
+// do over the empty flag.
+var profileEnv = map[string]string{
+	"proxmox-token-id":     "DTT_PROXMOX_TOKEN_ID",
+	"proxmox-token-secret": "DTT_PROXMOX_TOKEN_SECRET",
+}
+
+// applyProfile sets the connection flags in global, and the --node and
+// --storage flags in local, from p where they were not given on the command
+// line or in the environment. --node and
+// --storage are only set on commands where they default to a value, on
+// others leaving them empty means all nodes or storages.
+func applyProfile(p *profile, global, local *pflag.FlagSet) error {
+	for name, value := range p.connectionFlags() {
+		f := global.Lookup(name)
+		if f == nil || f.Changed || os.Getenv(profileEnv[name]) != "" {
+			continue
+		}
+		if err := global.Set(name, value); err != nil {
+			return fmt.Errorf("setting --%s from the profile gave err: %w", name, err)
+		}
+	}
+	for name, value := range map[string]string{"node": p.Node, "storage": p.Storage} {
+		f := local.Lookup(name)
+		if value == "" || f == nil || f.Changed || f.DefValue == "" {
+			continue
+		}
+		if err := local.Set(name, value); err != nil {
+			return fmt.Errorf("setting --%s from the profile gave err: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// loadProfile returns the selected profile of the config file, nil when
+// none is selected.
+func loadProfile() (*profile, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	_, p, err := cfg.selectedProfile()
+	return p, err
+}
+
+// applyConfig applies the selected profile of the config file to the flags
+// of cmd.
+func applyConfig(cmd *cobra.Command) error {
+	p, err := loadProfile()
+	if err != nil || p == nil {
+		return err
+	}
+	return applyProfile(p, rootCmd.PersistentFlags(), cmd.Flags())
+}
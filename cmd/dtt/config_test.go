@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dtt", "config.yaml")
+
+	cfg, err := loadConfig(path)
+	if err != nil || len(cfg.Profiles) != 0 {
+		t.Fatalf("Expected an empty config for a missing file, got %+v, %v", cfg, err)
+	}
+
+	insecure := false
+	cfg.Current = "lab"
+	cfg.Profiles["lab"] = &profile{Host: "pve.lab", TokenID: "dtt@pve!cli", TokenSecret: "secret", Insecure: &insecure, Node: "pve2"}
+	cfg.Profiles["home"] = &profile{Host: "pve.home", Port: 8007}
+	if err := saveConfig(path, cfg); err != nil {
+		t.Fatalf("saveConfig() gave err: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the config to be readable only by the user, got %v, %v", info, err)
+	}
+
+	got, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() gave err: %v", err)
+	}
+	if got.Current != "lab" || got.Profiles["lab"].TokenSecret != "secret" || *got.Profiles["lab"].Insecure || got.Profiles["home"].Port != 8007 {
+		t.Errorf("Expected the saved config back, got %+v", got)
+	}
+	if names := got.profileNames(); len(names) != 2 || names[0] != "home" {
+		t.Errorf("Expected sorted profile names, got %v", names)
+	}
+}
+
+func TestSelectedProfile(t *testing.T) {
+	saved := *FlagProfile
+	t.Cleanup(func() { *FlagProfile = saved })
+	t.Setenv("DTT_PROFILE", "")
+
+	cfg := &dttConfig{Current: "lab", Profiles: map[string]*profile{"lab": {Host: "pve.lab"}, "home": {Host: "pve.home"}}}
+	if name, p, err := cfg.selectedProfile(); err != nil || name != "lab" || p.Host != "pve.lab" {
+		t.Errorf("Expected the current profile, got %q, %+v, %v", name, p, err)
+	}
+
+	t.Setenv("DTT_PROFILE", "home")
+	if name, _, err := cfg.selectedProfile(); err != nil || name != "home" {
+		t.Errorf("Expected DTT_PROFILE to win over the current profile, got %q, %v", name, err)
+	}
+
+	*FlagProfile = "nope"
+	if _, _, err := cfg.selectedProfile(); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for an unknown profile, got %v", err)
+	}
+
+	*FlagProfile = ""
+	t.Setenv("DTT_PROFILE", "")
+	if _, p, err := (&dttConfig{}).selectedProfile(); err != nil || p != nil {
+		t.Errorf("Expected no profile without a current one, got %+v, %v", p, err)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	global := pflag.NewFlagSet("global", pflag.ContinueOnError)
+	host := global.String("proxmox-host", "", "")
+	port := global.Int("proxmox-port", 8006, "")
+	tokenID := global.String("proxmox-token-id", "", "")
+	insecure := global.Bool("proxmox-insecure", true, "")
+
+	local := pflag.NewFlagSet("local", pflag.ContinueOnError)
+	node := local.String("node", "pve", "")
+	storage := local.String("storage", "local", "")
+
+	if err := global.Parse([]string{"--proxmox-host", "given"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Parse([]string{"--storage", "fast"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DTT_PROXMOX_TOKEN_ID", "")
+	no := false
+	p := &profile{Host: "pve.lab", Port: 8007, TokenID: "dtt@pve!cli", Insecure: &no, Node: "pve2", Storage: "local-zfs"}
+	if err := applyProfile(p, global, local); err != nil {
+		t.Fatalf("applyProfile() gave err: %v", err)
+	}
+	if *host != "given" || *storage != "fast" {
+		t.Errorf("Expected flags given on the command line to win, got host %q and storage %q", *host, *storage)
+	}
+	if *port != 8007 || *tokenID != "dtt@pve!cli" || *insecure || *node != "pve2" {
+		t.Errorf("Expected the profile to fill in the rest, got port %d, token %q, insecure %t and node %q", *port, *tokenID, *insecure, *node)
+	}
+
+	// Secrets in the environment win over the profile too.
+	env := pflag.NewFlagSet("global", pflag.ContinueOnError)
+	envTokenID := env.String("proxmox-token-id", "", "")
+	t.Setenv("DTT_PROXMOX_TOKEN_ID", "from-env")
+	if err := applyProfile(p, env, local); err != nil || *envTokenID != "" {
+		t.Errorf("Expected DTT_PROXMOX_TOKEN_ID to win over the profile, got %q, %v", *envTokenID, err)
+	}
+
+	// An empty --node means all nodes, the profile must not narrow it.
+	anyNode := pflag.NewFlagSet("local", pflag.ContinueOnError)
+	all := anyNode.String("node", "", "")
+	if err := applyProfile(p, global, anyNode); err != nil || *all != "" {
+		t.Errorf("Expected --node defaulting to all nodes to stay empty, got %q, %v", *all, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// buildGo cross-compiles the Go package pkg, as go build takes it, for linux
+// on goarch, without cgo so it doesn't depend on the libc of the VM. It
+// returns the path of the binary in a temporary directory, which cleanup
+// removes. The output of go build goes to w.
+func buildGo(ctx context.Context, pkg, goarch string, w io.Writer) (path string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "dtt-go")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	// With -o naming a directory go build picks the name of the binary.
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", dir+string(filepath.Separator), pkg)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("go build %s for linux/%s gave err: %w", pkg, goarch, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if len(entries) != 1 {
+		cleanup()
+		return "", nil, fmt.Errorf("go build %s made %d files, is it a single main package?", pkg, len(entries))
+	}
+	return filepath.Join(dir, entries[0].Name()), cleanup, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/binary"
+)
+
+func TestBuildGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain")
+	}
+	// The package is outside the module of dtt.
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/hello\n\ngo 1.21\n",
+		"main.go": "package main\n\nfunc main() { println(\"hello\") }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+
+	path, cleanup, err := buildGo(context.Background(), ".", "arm64", io.Discard)
+	if err != nil {
+		t.Fatalf("buildGo() gave err: %v", err)
+	}
+	defer cleanup()
+	if filepath.Base(path) != "hello" {
+		t.Errorf("Expected the binary to be named after the module, got %s", path)
+	}
+	info, err := binary.InspectELF(path)
+	if err != nil {
+		t.Fatalf("InspectELF() gave err: %v", err)
+	}
+	if info.Arch != "arm64" || !info.Static {
+		t.Errorf("Expected a static arm64 binary, got %+v", info)
+	}
+
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected cleanup to remove the binary, got %v", err)
+	}
+
+	if _, _, err := buildGo(context.Background(), "./missing", "amd64", io.Discard); err == nil {
+		t.Error("Expected an error for a missing package")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+)
+
+// Diagnostics, such as what dtt is waiting for, go to stderr through slog,
+// so stdout only carries the results of commands.
+var (
+	FlagVerbose   = rootCmd.PersistentFlags().Bool("verbose", false, "also log debug details, such as the API requests retried")
+	FlagQuiet     = rootCmd.PersistentFlags().BoolP("quiet", "q", false, "only log warnings and errors, no progress")
+	FlagLogFormat = rootCmd.PersistentFlags().String("log-format", "text", "format of the logs on stderr: text or json")
+)
+
+// newLogger returns the logger writing to w that the flags ask for. The
+// flags are checked here rather than with cobra flag groups, which cobra
+// only checks after the PersistentPreRunE calling this.
+func newLogger(w io.Writer, verbose, quiet bool, format string) (*slog.Logger, error) {
+	if verbose && quiet {
+		return nil, fmt.Errorf("%w: --verbose and --quiet exclude each other", ErrUsage)
+	}
+	level := slog.LevelInfo
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("%w: --log-format %q, want text or json", ErrUsage, format)
+}
+
+// setupLogging makes the logger of the flags the default one, for slog and
+// the log package, in dtt and in pkg/proxmox.
+func setupLogging() error {
+	logger, err := newLogger(os.Stderr, *FlagVerbose, *FlagQuiet, *FlagLogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// progressOutput returns where long running operations report their
+// progress: a progress bar on stderr when dtt is interactive and stderr is a
+// terminal, plain lines on stderr otherwise, log records with --log-format
+// json and nowhere with --quiet.
+func progressOutput() dttproxmox.ProgressFunc {
+	switch {
+	case *FlagQuiet:
+		return nil
+	case *FlagLogFormat == "json":
+		return dttproxmox.LogProgress(slog.Default())
+	case interactive() && isTerminal(os.Stderr):
+		return dttproxmox.BarProgress(os.Stderr)
+	}
+	return dttproxmox.PrintProgress(os.Stderr)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, false, true, "text")
+	if err != nil {
+		t.Fatalf("newLogger(--quiet) gave err: %v", err)
+	}
+	logger.Info("waiting for SSH")
+	logger.Warn("VM is not stopped")
+	if out := buf.String(); strings.Contains(out, "waiting") || !strings.Contains(out, "VM is not stopped") {
+		t.Errorf("Expected --quiet to only log warnings, got %q", out)
+	}
+
+	buf.Reset()
+	logger, err = newLogger(&buf, true, false, "json")
+	if err != nil {
+		t.Fatalf("newLogger(--verbose --log-format json) gave err: %v", err)
+	}
+	logger.Debug("retrying", "attempt", 2)
+	var record struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		Attempt int    `json:"attempt"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON log record, got %q: %v", buf.String(), err)
+	}
+	if record.Level != "DEBUG" || record.Msg != "retrying" || record.Attempt != 2 {
+		t.Errorf("Unexpected log record %+v", record)
+	}
+
+	if _, err := newLogger(&buf, false, false, "xml"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected an unknown --log-format to be a usage error, got %v", err)
+	}
+	if _, err := newLogger(&buf, true, true, "text"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected --verbose with --quiet to be a usage error, got %v", err)
+	}
+}
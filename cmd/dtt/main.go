@@ -6,29 +6,50 @@ import (
 	"net/http"
 	"os"
 
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
 	px "github.com/luthermonson/go-proxmox"
 	"github.com/spf13/cobra"
 )
 
+// version identifies the dtt build. Release builds set it with
+// -ldflags "-X main.version=<version>".
+var version = "dev"
+
 var (
 	rootCmd = &cobra.Command{
 		Use:   "dtt",
 		Short: "DTT - Do The Thing: Run all sorts of stuff on Proxmox VMs. Linux binaries, docker images",
 		Long: `DTT is a CLI tool that helps you run Linux binaries, docker images on Proxmox VE.
-It handles image download, VM creation, cloud-init configuration, and binary execution.`,
+It handles image download, VM creation, cloud-init configuration, and binary execution.
+
+Without a terminal on stdout, in CI or with --non-interactive, dtt never
+prompts or animates and prints errors as one JSON line on stderr. The exit
+code tells the kind of failure: 2 invalid usage, 3 missing input, 4 Proxmox
+authentication, 5 VM not found, 6 timeout, 7 Proxmox task failed, 1 else.`,
+		SilenceErrors: true,
 	}
 
 	FlagHost         = rootCmd.PersistentFlags().String("proxmox-host", "", "Proxmox server hostname or IP")
 	FlagPort         = rootCmd.PersistentFlags().Int("proxmox-port", 8006, "Proxmox server port")
 	FlagUserName     = rootCmd.PersistentFlags().String("proxmox-user", "", "Proxmox API username")
 	FlagUserPassword = rootCmd.PersistentFlags().String("proxmox-password", "", "Proxmox API password (or set DTT_PROXMOX_PASSWORD, encouraged, or better yet use tokens)")
-	FlagTokenID      = rootCmd.PersistentFlags().String("proxmox-token-id", "", "Proxmox API Token ID")
-	FlagTokenSecret  = rootCmd.PersistentFlags().String("proxmox-token-secret", "", "Proxmox API Token secret")
+	FlagTokenID      = rootCmd.PersistentFlags().String("proxmox-token-id", "", "Proxmox API Token ID (or set DTT_PROXMOX_TOKEN_ID)")
+	FlagTokenSecret  = rootCmd.PersistentFlags().String("proxmox-token-secret", "", "Proxmox API Token secret (or set DTT_PROXMOX_TOKEN_SECRET)")
 	FlagInsecure     = rootCmd.PersistentFlags().Bool("proxmox-insecure", true, "Skip SSL certificate verification")
+	FlagRetries      = rootCmd.PersistentFlags().Int("proxmox-retries", dttproxmox.DefaultRetryAttempts, "tries per Proxmox API request for transient failures, 1 disables retries")
+	FlagRetryBackoff = rootCmd.PersistentFlags().Duration("proxmox-retry-backoff", dttproxmox.DefaultRetryBackoff, "wait before retrying a failed Proxmox API request, doubled on every retry")
+	FlagRateLimit    = rootCmd.PersistentFlags().Float64("proxmox-rate-limit", dttproxmox.DefaultRateLimit, "maximum Proxmox API requests per second, 0 disables the limit")
+	FlagRateBurst    = rootCmd.PersistentFlags().Int("proxmox-rate-burst", dttproxmox.DefaultRateBurst, "Proxmox API requests allowed in a burst before --proxmox-rate-limit applies")
 
 	vmCommand = &cobra.Command{
 		Use:   "vm",
 		Short: "vm commands",
+		Long: `vm commands.
+
+Commands taking a <name-or-id> accept a VMID, an exact name, name:<name> for
+names that look like a VMID, tag:<tag> for all VMs with a tag, or re:<regex>
+for all VMs whose name matches. Commands acting on a single VM fail if the
+query matches more than one, pass --node to narrow it down.`,
 	}
 
 	imageCommand = &cobra.Command{
@@ -40,27 +61,37 @@ It handles image download, VM creation, cloud-init configuration, and binary exe
 		Use:   "agent",
 		Short: "qemu agent commands",
 	}
+
+	nodeCommand = &cobra.Command{
+		Use:   "node",
+		Short: "node commands",
+	}
+
+	runnerCommand = &cobra.Command{
+		Use:   "runner",
+		Short: "GitHub Actions runner commands",
+	}
 )
 
-func getPACFromFlags() *px.Client {
+func getPACFromFlags() dttproxmox.ProxmoxAPI {
 	HTTPClient := http.Client{
-		Transport: &http.Transport{
+		Transport: dttproxmox.NewRetryTransport(dttproxmox.NewRateLimitTransport(&http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: *FlagInsecure,
 			},
-		},
+		}, *FlagRateLimit, *FlagRateBurst), *FlagRetries, *FlagRetryBackoff),
 	}
 
 	opts := []px.Option{
 		px.WithHTTPClient(&HTTPClient),
 	}
-	if *FlagTokenID != "" {
-		opts = append(opts, px.WithAPIToken(*FlagTokenID, *FlagTokenSecret))
+	if tokenID := flagOrEnv(*FlagTokenID, "DTT_PROXMOX_TOKEN_ID"); tokenID != "" {
+		opts = append(opts, px.WithAPIToken(tokenID, flagOrEnv(*FlagTokenSecret, "DTT_PROXMOX_TOKEN_SECRET")))
 	}
 	if *FlagUserName != "" {
 		opts = append(opts, px.WithCredentials(&px.Credentials{
 			Username: *FlagUserName,
-			Password: *FlagUserPassword,
+			Password: flagOrEnv(*FlagUserPassword, "DTT_PROXMOX_PASSWORD"),
 		}))
 	}
 
@@ -70,15 +101,55 @@ func getPACFromFlags() *px.Client {
 	return client
 }
 
+// flagOrEnv returns value, or the environment variable env if value is empty,
+// so secrets don't have to be passed on the command line.
+func flagOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
 func init() {
 	// Add subcommands
 	rootCmd.AddCommand(vmCommand)
 	rootCmd.AddCommand(imageCommand)
 	rootCmd.AddCommand(agentCommand)
+	rootCmd.AddCommand(nodeCommand)
+	rootCmd.AddCommand(runnerCommand)
+
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if !interactive() {
+			cmd.SilenceUsage = true
+		}
+		if err := setupLogging(); err != nil {
+			return err
+		}
+		if !needsProxmox(cmd) {
+			return nil
+		}
+		if err := applyConfig(cmd); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		if err := checkConnectionFlags(); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	}
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		if !interactive() {
+			cmd.SilenceUsage = true
+		}
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	})
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		reportError(os.Stderr, err, interactive())
+		_, code := classifyError(err)
+		os.Exit(code)
 	}
 }
\ No newline at end of file
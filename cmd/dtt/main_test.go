@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestCommandTree(t *testing.T) {
+	for _, path := range [][]string{
+		{"run"},
+		{"apply"},
+		{"destroy"},
+		{"api"},
+		{"ps"},
+		{"gc"},
+		{"inventory"},
+		{"parse-log"},
+		{"hostkeys", "export"},
+		{"docker", "run"},
+		{"ct", "create"},
+		{"ct", "list"},
+		{"ct", "exec"},
+		{"ct", "rm"},
+		{"ct", "template", "download"},
+		{"config", "set"},
+		{"config", "list"},
+		{"config", "use"},
+		{"runner", "create"},
+		{"runner", "rm"},
+		{"image", "list"},
+		{"image", "download"},
+		{"image", "upload"},
+		{"image", "refresh"},
+		{"image", "mirror"},
+		{"image", "import"},
+		{"image", "export"},
+		{"vm", "list"},
+		{"vm", "rm"},
+		{"vm", "delete"},
+		{"vm", "cloudinit"},
+		{"vm", "clone"},
+		{"vm", "template"},
+		{"vm", "ssh"},
+		{"vm", "ip"},
+		{"vm", "trust"},
+		{"vm", "wait"},
+		{"vm", "console"},
+		{"vm", "snapshot", "create"},
+		{"vm", "snapshot", "rollback"},
+		{"task", "list"},
+		{"task", "log"},
+		{"task", "stop"},
+		{"node", "list"},
+		{"node", "get"},
+		{"node", "tasks"},
+		{"storage", "list"},
+		{"storage", "get"},
+		{"storage", "content"},
+		{"preflight"},
+	} {
+		cmd, rest, err := rootCmd.Find(path)
+		if err != nil || len(rest) != 0 || cmd == rootCmd {
+			t.Errorf("Expected command %v to exist, got %v, %v, %v", path, cmd.Name(), rest, err)
+		}
+	}
+
+	for _, name := range []string{"proxmox-host", "proxmox-port", "proxmox-user", "proxmox-token-id", "task-log", "profile"} {
+		if rootCmd.PersistentFlags().Lookup(name) == nil {
+			t.Errorf("Expected global flag --%s to exist", name)
+		}
+	}
+}
+
+func TestFlagOrEnv(t *testing.T) {
+	t.Setenv("DTT_TEST_SECRET", "from-env")
+	if got := flagOrEnv("from-flag", "DTT_TEST_SECRET"); got != "from-flag" {
+		t.Errorf("Expected the flag to win, got %q", got)
+	}
+	if got := flagOrEnv("", "DTT_TEST_SECRET"); got != "from-env" {
+		t.Errorf("Expected the environment variable, got %q", got)
+	}
+}
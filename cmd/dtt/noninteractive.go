@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+var FlagNonInteractive = rootCmd.PersistentFlags().Bool("non-interactive", false, "never prompt or animate, print plain lines and errors as JSON on stderr (default: on when stdout is not a terminal, or CI or DTT_NON_INTERACTIVE is set)")
+
+// Errors classifying what went wrong, main maps them onto exit codes.
+var (
+	// ErrMissingInput is returned when a required flag or environment
+	// variable is not set. dtt fails with it instead of asking.
+	ErrMissingInput = errors.New("missing input")
+	// ErrUsage is returned for unknown flags and invalid flag values.
+	ErrUsage = errors.New("invalid usage")
+)
+
+// exitClasses maps error classes onto their name and exit code, checked in
+// order with errors.Is. Anything else exits with 1 as "error".
+var exitClasses = []struct {
+	err  error
+	name string
+	code int
+}{
+	{ErrUsage, "usage", 2},
+	{dttproxmox.ErrInvalidSpec, "usage", 2},
+	{ErrMissingInput, "missing_input", 3},
+	{dttproxmox.ErrAuth, "auth", 4},
+	{dttproxmox.ErrVMNotFound, "not_found", 5},
+	{dttproxmox.ErrAmbiguousName, "ambiguous", 5},
+	{dttproxmox.ErrTaskTimeout, "timeout", 6},
+	{context.DeadlineExceeded, "timeout", 6},
+	{dttproxmox.ErrTaskFailed, "task_failed", 7},
+}
+
+// exitStatusError makes dtt exit with the exit status of what it ran
+// remotely, for commands such as dtt run that stand in for it.
+type exitStatusError struct {
+	err  error
+	code int
+}
+
+func (e *exitStatusError) Error() string { return e.err.Error() }
+func (e *exitStatusError) Unwrap() error { return e.err }
+
+// classifyError returns the class name and exit code of err.
+func classifyError(err error) (string, int) {
+	var exitErr *exitStatusError
+	if errors.As(err, &exitErr) {
+		return "exit_status", exitErr.code
+	}
+	err = dttproxmox.WrapError(err)
+	for _, c := range exitClasses {
+		if errors.Is(err, c.err) {
+			return c.name, c.code
+		}
+	}
+	return "error", 1
+}
+
+// missingInput returns an ErrMissingInput naming what has to be set.
+func missingInput(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMissingInput, fmt.Sprintf(format, args...))
+}
+
+// interactive reports whether dtt may prompt and animate: --non-interactive
+// wins when given, otherwise CI and DTT_NON_INTERACTIVE turn it off, and so
+// does a stdout that is not a terminal.
+func interactive() bool {
+	if rootCmd.PersistentFlags().Changed("non-interactive") {
+		return !*FlagNonInteractive
+	}
+	for _, env := range []string{"DTT_NON_INTERACTIVE", "CI"} {
+		if v := os.Getenv(env); v != "" && v != "0" && !strings.EqualFold(v, "false") {
+			return false
+		}
+	}
+	return isTerminal(os.Stdout)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// checkConnectionFlags fails with ErrMissingInput when the Proxmox host or
+// credentials are not set, rather than letting the first API call fail on
+// them.
+func checkConnectionFlags() error {
+	if *FlagHost == "" {
+		return missingInput("--proxmox-host is required, or a profile from dtt config set")
+	}
+	if flagOrEnv(*FlagTokenID, "DTT_PROXMOX_TOKEN_ID") != "" {
+		if flagOrEnv(*FlagTokenSecret, "DTT_PROXMOX_TOKEN_SECRET") == "" {
+			return missingInput("--proxmox-token-secret (or DTT_PROXMOX_TOKEN_SECRET) is required with --proxmox-token-id")
+		}
+		return nil
+	}
+	if *FlagUserName == "" {
+		return missingInput("--proxmox-token-id and --proxmox-token-secret, or --proxmox-user and --proxmox-password, are required")
+	}
+	if flagOrEnv(*FlagUserPassword, "DTT_PROXMOX_PASSWORD") == "" {
+		return missingInput("--proxmox-password (or DTT_PROXMOX_PASSWORD) is required with --proxmox-user")
+	}
+	return nil
+}
+
+// annotationOffline marks commands that work without Proxmox, such as
+// parse-log, in cobra.Command.Annotations.
+const annotationOffline = "dtt.offline"
+
+// needsProxmox reports whether cmd talks to Proxmox, which all commands but
+// help, shell completion and those marked with annotationOffline do.
+func needsProxmox(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "help" || c.Name() == "completion" || strings.HasPrefix(c.Name(), "__") {
+			return false
+		}
+		if c.Annotations[annotationOffline] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// reportError writes err to w, as a JSON object on one line when not
+// interactive so CI systems can pick out the class.
+func reportError(w io.Writer, err error, interactive bool) {
+	class, code := classifyError(err)
+	if !interactive {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     err.Error(),
+			"class":     class,
+			"exit_code": code,
+		})
+		return
+	}
+	fmt.Fprintln(w, "Error:", err)
+	if class == "auth" {
+		fmt.Fprintln(w, "Proxmox rejected the credentials, check --proxmox-user and --proxmox-password or --proxmox-token-id and --proxmox-token-secret, and the permissions they have")
+	}
+}
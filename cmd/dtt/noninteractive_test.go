@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		class string
+		code  int
+	}{
+		{missingInput("--proxmox-host is required"), "missing_input", 3},
+		{fmt.Errorf("%w: unknown flag: --x", ErrUsage), "usage", 2},
+		{fmt.Errorf("listing VMs gave err: %w", dttproxmox.ErrAuth), "auth", 4},
+		{fmt.Errorf("finding VM gave err: %w", dttproxmox.ErrVMNotFound), "not_found", 5},
+		{errors.New("500 Configuration file 'nodes/pve/qemu-server/1.conf' does not exist"), "not_found", 5},
+		{dttproxmox.ErrTaskTimeout, "timeout", 6},
+		{&exitStatusError{err: errors.New("remote command exited with status 2"), code: 2}, "exit_status", 2},
+		{errors.New("something else"), "error", 1},
+	} {
+		if class, code := classifyError(tc.err); class != tc.class || code != tc.code {
+			t.Errorf("classifyError(%v) = %s, %d, want %s, %d", tc.err, class, code, tc.class, tc.code)
+		}
+	}
+}
+
+func TestReportErrorNonInteractive(t *testing.T) {
+	var buf bytes.Buffer
+	reportError(&buf, missingInput("--proxmox-host is required"), false)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	if got["class"] != "missing_input" || got["exit_code"] != float64(3) {
+		t.Errorf("Expected a missing_input error with exit code 3, got %v", got)
+	}
+}
+
+func TestCheckConnectionFlags(t *testing.T) {
+	for _, env := range []string{"DTT_PROXMOX_TOKEN_ID", "DTT_PROXMOX_TOKEN_SECRET", "DTT_PROXMOX_PASSWORD"} {
+		t.Setenv(env, "")
+	}
+	saved := []string{*FlagHost, *FlagUserName}
+	t.Cleanup(func() { *FlagHost, *FlagUserName = saved[0], saved[1] })
+
+	*FlagHost, *FlagUserName = "", ""
+	if err := checkConnectionFlags(); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("Expected a missing host to be missing input, got %v", err)
+	}
+	*FlagHost, *FlagUserName = "pve", "root@pam"
+	if err := checkConnectionFlags(); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("Expected a missing password to be missing input, got %v", err)
+	}
+	t.Setenv("DTT_PROXMOX_PASSWORD", "secret")
+	if err := checkConnectionFlags(); err != nil {
+		t.Errorf("Expected user and password to be enough, got %v", err)
+	}
+}
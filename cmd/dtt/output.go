@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// addOutputFlag adds the --output/-o flag read commands share to cmd, for
+// passing to writeOutput.
+func addOutputFlag(cmd *cobra.Command) *string {
+	return cmd.PersistentFlags().StringP("output", "o", "table", "output format: table, json or yaml")
+}
+
+// writeOutput writes v to w as JSON or YAML for scripting, or calls table
+// for the human readable output commands print by default.
+func writeOutput(w io.Writer, format string, v interface{}, table func(w io.Writer) error) error {
+	switch format {
+	case "table", "":
+		return table(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("%w: unknown output format %q, want table, json or yaml", ErrUsage, format)
+	}
+}
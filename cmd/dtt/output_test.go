@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteOutput(t *testing.T) {
+	rows := []statusVMRow{{Node: "pve", VMID: 101, Name: "dtt-101", Status: "running", MaxMem: 2 << 30}}
+	table := func(w io.Writer) error { return writeVMRows(w, rows) }
+
+	var out bytes.Buffer
+	if err := writeOutput(&out, "table", rows, table); err != nil {
+		t.Fatalf("writeOutput(table) gave err: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "NODE") || !strings.Contains(out.String(), "dtt-101") {
+		t.Errorf("Expected the VM table, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := writeOutput(&out, "json", rows, table); err != nil {
+		t.Fatalf("writeOutput(json) gave err: %v", err)
+	}
+	var fromJSON []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &fromJSON); err != nil {
+		t.Fatalf("Expected JSON, got %q: %v", out.String(), err)
+	}
+	if len(fromJSON) != 1 || fromJSON[0]["vmid"] != 101.0 || fromJSON[0]["max_mem"] != float64(2<<30) {
+		t.Errorf("Unexpected JSON %q", out.String())
+	}
+
+	out.Reset()
+	if err := writeOutput(&out, "yaml", rows, table); err != nil {
+		t.Fatalf("writeOutput(yaml) gave err: %v", err)
+	}
+	var fromYAML []statusVMRow
+	if err := yaml.Unmarshal(out.Bytes(), &fromYAML); err != nil {
+		t.Fatalf("Expected YAML, got %q: %v", out.String(), err)
+	}
+	if len(fromYAML) != 1 || fromYAML[0] != rows[0] || !strings.Contains(out.String(), "max_mem:") {
+		t.Errorf("Unexpected YAML %q", out.String())
+	}
+
+	if err := writeOutput(&out, "xml", rows, table); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected a usage error for an unknown format, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/spf13/cobra"
+)
+
+// autoNode is the --node value that lets dtt pick the node, see placeNode.
+const autoNode = "auto"
+
+// addPlacementFlag adds the --placement flag picking how --node auto places
+// VMs to cmd.
+func addPlacementFlag(cmd *cobra.Command) *string {
+	return cmd.PersistentFlags().String("placement", dttproxmox.PlaceSpread, "with --node auto, how to pick the node: "+strings.Join(dttproxmox.PlacementStrategies, ", "))
+}
+
+// placeNode replaces a --node of auto with the node strategy picks for VMs
+// needing memoryMB in all, and leaves any other node alone.
+func placeNode(ctx context.Context, sess *session, node *string, strategy string, memoryMB int) error {
+	if *node != autoNode {
+		return nil
+	}
+	picked, err := dttproxmox.PickNode(ctx, sess.pac, strategy, memoryMB)
+	if err != nil {
+		return fmt.Errorf("placing the VM with --node auto gave err: %w", err)
+	}
+	slog.Info("placing the VM", "node", picked, "placement", strategy)
+	*node = picked
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/luthermonson/go-proxmox"
+)
+
+var (
+	FlagNoCache  = rootCmd.PersistentFlags().Bool("no-cache", false, "always fetch nodes and VMs from Proxmox instead of reusing earlier lookups")
+	FlagCacheTTL = rootCmd.PersistentFlags().Duration("cache-ttl", 0, "how long cached nodes and VMs are reused, 0 means for the whole invocation")
+	FlagTaskLog  = rootCmd.PersistentFlags().Bool("task-log", false, "print the log of Proxmox tasks while waiting for them")
+)
+
+// session holds what one dtt invocation shares between its subcommands: the
+// Proxmox API client and a cache of the nodes and VMs looked up through it.
+type session struct {
+	pac   dttproxmox.ProxmoxAPI
+	cache *apiCache
+
+	// taskLog receives the log of the tasks waited on, nil unless --task-log.
+	taskLog dttproxmox.TaskLogFunc
+}
+
+var (
+	currentSession     *session
+	currentSessionOnce sync.Once
+)
+
+// getSession returns the session of this invocation, connecting with the
+// root flags on first use.
+func getSession() *session {
+	currentSessionOnce.Do(func() {
+		currentSession = newSession(getPACFromFlags(), newAPICache(*FlagCacheTTL, !*FlagNoCache))
+		if *FlagTaskLog {
+			currentSession.taskLog = dttproxmox.PrintTaskLog(os.Stderr)
+		}
+	})
+	return currentSession
+}
+
+func newSession(pac dttproxmox.ProxmoxAPI, cache *apiCache) *session {
+	return &session{pac: pac, cache: cache}
+}
+
+// Provisioner returns a dttproxmox.Client creating VMs on node, sharing the
+// connection of the session.
+func (s *session) Provisioner(node, imageStorage, diskStorage string) *dttproxmox.Client {
+	return dttproxmox.NewClientWithAPI(dttproxmox.ClientConfig{
+		Node:         node,
+		ImageStorage: imageStorage,
+		DiskStorage:  diskStorage,
+		Progress:     progressOutput(),
+		TaskLog:      s.taskLog,
+	}, s.pac)
+}
+
+// waitTask waits for task, printing its log as it goes with --task-log.
+func waitTask(ctx context.Context, task *proxmox.Task, interval, timeout time.Duration) error {
+	return dttproxmox.WaitTaskLog(ctx, task, interval, timeout, getSession().taskLog)
+}
+
+// Node returns the named node, from the cache if possible.
+func (s *session) Node(ctx context.Context, name string) (*proxmox.Node, error) {
+	if node, ok := s.cache.node(name); ok {
+		return node, nil
+	}
+	node, err := s.pac.Node(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.putNode(name, node)
+	return node, nil
+}
+
+// VM returns VM vmid on node, from the cache if possible.
+func (s *session) VM(ctx context.Context, node *proxmox.Node, vmid int) (*proxmox.VirtualMachine, error) {
+	if vm, ok := s.cache.vm(node.Name, vmid); ok {
+		return vm, nil
+	}
+	vm, err := node.VirtualMachine(ctx, vmid)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.putVM(node.Name, vmid, vm)
+	return vm, nil
+}
+
+// Resources returns the cluster resources, fetched once per invocation and
+// then shared by every lookup.
+func (s *session) Resources(ctx context.Context) ([]*proxmox.ClusterResource, error) {
+	if resources, ok := s.cache.resources(); ok {
+		return resources, nil
+	}
+	cluster, err := s.pac.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster gave err: %w", err)
+	}
+	resources, err := cluster.Resources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cluster resources gave err: %w", err)
+	}
+	s.cache.putResources(resources)
+	return resources, nil
+}
+
+// ResolveVMs resolves VM queries, see dttproxmox.MatchVMs, against a single
+// fetch of the cluster resources, limited to node unless it is empty. A name
+// matches every VM with that name. A VM matched by several queries is
+// returned once, in the order of its first match.
+func (s *session) ResolveVMs(ctx context.Context, queries []string, node string) ([]*proxmox.VirtualMachine, error) {
+	resources, err := s.Resources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := dttproxmox.MatchVMsAll(resources, queries, node)
+	if err != nil {
+		return nil, err
+	}
+
+	vms := make([]*proxmox.VirtualMachine, 0, len(matched))
+	for _, r := range matched {
+		vm, err := s.resourceVM(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// ResolveVMResource resolves a query that has to match exactly one VM.
+func (s *session) ResolveVMResource(ctx context.Context, query, node string) (*proxmox.ClusterResource, error) {
+	resources, err := s.Resources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dttproxmox.MatchVM(resources, query, node)
+}
+
+// ResolveVM is ResolveVMResource returning the VM itself.
+func (s *session) ResolveVM(ctx context.Context, query, node string) (*proxmox.VirtualMachine, error) {
+	r, err := s.ResolveVMResource(ctx, query, node)
+	if err != nil {
+		return nil, err
+	}
+	return s.resourceVM(ctx, r)
+}
+
+// ResolveContainers is ResolveVMs for LXC containers.
+func (s *session) ResolveContainers(ctx context.Context, queries []string, node string) ([]*proxmox.Container, error) {
+	resources, err := s.Resources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := dttproxmox.MatchContainersAll(resources, queries, node)
+	if err != nil {
+		return nil, err
+	}
+
+	cts := make([]*proxmox.Container, 0, len(matched))
+	for _, r := range matched {
+		ct, err := s.resourceContainer(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		cts = append(cts, ct)
+	}
+	return cts, nil
+}
+
+// ResolveContainer resolves a query that has to match exactly one container.
+func (s *session) ResolveContainer(ctx context.Context, query, node string) (*proxmox.Container, error) {
+	resources, err := s.Resources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := dttproxmox.MatchContainer(resources, query, node)
+	if err != nil {
+		return nil, err
+	}
+	return s.resourceContainer(ctx, r)
+}
+
+func (s *session) resourceContainer(ctx context.Context, r *proxmox.ClusterResource) (*proxmox.Container, error) {
+	node, err := s.Node(ctx, r.Node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the node for nodename %q: %w", r.Node, err)
+	}
+	ct, err := node.Container(ctx, int(r.VMID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the container for VMID %d: %w", r.VMID, err)
+	}
+	return ct, nil
+}
+
+func (s *session) resourceVM(ctx context.Context, r *proxmox.ClusterResource) (*proxmox.VirtualMachine, error) {
+	node, err := s.Node(ctx, r.Node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the node to for nodename %q: %w", r.Node, err)
+	}
+	vm, err := s.VM(ctx, node, int(r.VMID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the virtual machine for VMID %d: %w", r.VMID, err)
+	}
+	return vm, nil
+}
+
+// apiCache caches API objects for at most ttl, or forever if ttl is zero.
+// It is safe for concurrent use. A disabled cache never returns anything.
+type apiCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	enabled bool
+	now     func() time.Time
+
+	nodes            map[string]cacheEntry[*proxmox.Node]
+	vms              map[string]cacheEntry[*proxmox.VirtualMachine]
+	clusterResources *cacheEntry[[]*proxmox.ClusterResource]
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	fetched time.Time
+}
+
+func newAPICache(ttl time.Duration, enabled bool) *apiCache {
+	return &apiCache{
+		ttl:     ttl,
+		enabled: enabled,
+		now:     time.Now,
+		nodes:   map[string]cacheEntry[*proxmox.Node]{},
+		vms:     map[string]cacheEntry[*proxmox.VirtualMachine]{},
+	}
+}
+
+func vmCacheKey(node string, vmid int) string {
+	return fmt.Sprintf("%s:%d", node, vmid)
+}
+
+func (c *apiCache) fresh(fetched time.Time) bool {
+	return c.ttl <= 0 || c.now().Sub(fetched) < c.ttl
+}
+
+func (c *apiCache) node(name string) (*proxmox.Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.nodes[name]
+	if !ok || !c.enabled || !c.fresh(e.fetched) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *apiCache) putNode(name string, node *proxmox.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled {
+		c.nodes[name] = cacheEntry[*proxmox.Node]{value: node, fetched: c.now()}
+	}
+}
+
+func (c *apiCache) vm(node string, vmid int) (*proxmox.VirtualMachine, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.vms[vmCacheKey(node, vmid)]
+	if !ok || !c.enabled || !c.fresh(e.fetched) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *apiCache) putVM(node string, vmid int, vm *proxmox.VirtualMachine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled {
+		c.vms[vmCacheKey(node, vmid)] = cacheEntry[*proxmox.VirtualMachine]{value: vm, fetched: c.now()}
+	}
+}
+
+// forgetVM drops a cached VM, for example after it was deleted, along with
+// the cluster resources that list it.
+func (c *apiCache) forgetVM(node string, vmid int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.vms, vmCacheKey(node, vmid))
+	c.clusterResources = nil
+}
+
+func (c *apiCache) resources() ([]*proxmox.ClusterResource, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clusterResources == nil || !c.enabled || !c.fresh(c.clusterResources.fetched) {
+		return nil, false
+	}
+	return c.clusterResources.value, true
+}
+
+func (c *apiCache) putResources(resources []*proxmox.ClusterResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled {
+		c.clusterResources = &cacheEntry[[]*proxmox.ClusterResource]{value: resources, fetched: c.now()}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dttproxmox "github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func countVMStatusRequests(server *proxmoxtest.Server) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if r.Method == "GET" && strings.HasSuffix(r.Path, "/qemu/100/status/current") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSessionCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		enabled   bool
+		advance   time.Duration
+		wantFetch int
+	}{
+		{name: "cached for the invocation", enabled: true, advance: time.Hour, wantFetch: 1},
+		{name: "within ttl", ttl: time.Minute, enabled: true, advance: 30 * time.Second, wantFetch: 1},
+		{name: "expired", ttl: time.Minute, enabled: true, advance: 2 * time.Minute, wantFetch: 2},
+		{name: "no cache", enabled: false, wantFetch: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := proxmoxtest.NewServer(t)
+			server.AddNode("pve1")
+			server.AddVM(proxmoxtest.VM{Node: "pve1", VMID: 100, Name: "web"})
+
+			cache := newAPICache(tt.ttl, tt.enabled)
+			clock := now
+			cache.now = func() time.Time { return clock }
+			sess := newSession(server.Client(), cache)
+
+			ctx := context.Background()
+			for i := 0; i < 2; i++ {
+				node, err := sess.Node(ctx, "pve1")
+				if err != nil {
+					t.Fatalf("Node() gave err: %v", err)
+				}
+				if _, err := sess.VM(ctx, node, 100); err != nil {
+					t.Fatalf("VM() gave err: %v", err)
+				}
+				clock = clock.Add(tt.advance)
+			}
+
+			if got := countVMStatusRequests(server); got != tt.wantFetch {
+				t.Errorf("Expected %d VM fetches, got %d", tt.wantFetch, got)
+			}
+		})
+	}
+}
+
+func TestSessionResolveVMs(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve1")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve1", VMID: 100, Name: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve1", VMID: 101, Name: "db"})
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 102, Name: "db"})
+
+	sess := newSession(server.Client(), newAPICache(0, true))
+	ctx := context.Background()
+
+	vms, err := sess.ResolveVMs(ctx, []string{"db", "100", "web", "102"}, "")
+	if err != nil {
+		t.Fatalf("ResolveVMs() gave err: %v", err)
+	}
+	var got []uint64
+	for _, vm := range vms {
+		got = append(got, uint64(vm.VMID))
+	}
+	if want := []uint64{101, 102, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected VMIDs %v, got %v", want, got)
+	}
+
+	if _, err := sess.ResolveVMs(ctx, []string{"web", "nope"}, ""); !errors.Is(err, dttproxmox.ErrVMNotFound) {
+		t.Errorf("Expected ErrVMNotFound, got %v", err)
+	}
+	if vms, err := sess.ResolveVMs(ctx, []string{"db"}, "pve2"); err != nil || len(vms) != 1 || vms[0].VMID != 102 {
+		t.Errorf("Expected only VM 102 on pve2, got %v, %v", vms, err)
+	}
+	if _, err := sess.ResolveVM(ctx, "db", ""); !errors.Is(err, dttproxmox.ErrAmbiguousName) {
+		t.Errorf("Expected ErrAmbiguousName, got %v", err)
+	}
+
+	fetches := 0
+	for _, r := range server.Requests() {
+		if r.Path == "/cluster/resources" {
+			fetches++
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected cluster resources to be fetched once, got %d", fetches)
+	}
+}
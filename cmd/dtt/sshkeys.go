@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// vmKeysDir returns where the private keys --generate-sshkey made are kept,
+// $XDG_DATA_HOME/dtt/keys or ~/.local/share/dtt/keys.
+func vmKeysDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "dtt", "keys"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the home directory gave err: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "dtt", "keys"), nil
+}
+
+// vmKeyPath returns the path of the private key of VM vmid, with the public
+// key next to it with .pub appended.
+func vmKeyPath(vmid int) (string, error) {
+	dir, err := vmKeysDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(vmid)), nil
+}
+
+// storedVMKey returns the path of the private key kept for VM vmid, "" when
+// there is none.
+func storedVMKey(vmid int) string {
+	path, err := vmKeyPath(vmid)
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// storeVMKey keeps a copy of the private key at privateKeyPath and
+// publicKey as the keys of VM vmid, and returns the path of the copy.
+func storeVMKey(vmid int, privateKeyPath, publicKey string) (string, error) {
+	path, err := vmKeyPath(vmid)
+	if err != nil {
+		return "", err
+	}
+	key, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return "", fmt.Errorf("writing %s gave err: %w", path, err)
+	}
+	if err := os.WriteFile(path+".pub", []byte(publicKey+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("writing %s.pub gave err: %w", path, err)
+	}
+	return path, nil
+}
+
+// removeVMKey removes the keys kept for VM vmid, if any.
+func removeVMKey(vmid int) error {
+	path, err := vmKeyPath(vmid)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{path, path + ".pub"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
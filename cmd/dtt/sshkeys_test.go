@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreVMKey(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+
+	if path := storedVMKey(100); path != "" {
+		t.Fatalf("Expected no key for VM 100 yet, got %s", path)
+	}
+
+	publicKey, privateKeyPath, cleanup, err := generateSSHKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	path, err := storeVMKey(100, privateKeyPath, publicKey)
+	if err != nil {
+		t.Fatalf("storeVMKey gave err: %v", err)
+	}
+	if want := filepath.Join(data, "dtt", "keys", "100"); path != want || storedVMKey(100) != want {
+		t.Errorf("Expected the key at %s, got %s and %s", want, path, storedVMKey(100))
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the private key to be 0600, got %v, %v", info, err)
+	}
+	if pub, err := os.ReadFile(path + ".pub"); err != nil || string(pub) != publicKey+"\n" {
+		t.Errorf("Expected the public key next to it, got %q, %v", pub, err)
+	}
+
+	if err := removeVMKey(100); err != nil {
+		t.Fatalf("removeVMKey gave err: %v", err)
+	}
+	if storedVMKey(100) != "" {
+		t.Error("Expected the key to be removed")
+	}
+	if err := removeVMKey(100); err != nil {
+		t.Errorf("Expected removing a missing key to succeed, got %v", err)
+	}
+}
@@ -2,18 +2,24 @@ module github.com/cdevr/dtt
 
 go 1.24.0
 
-require github.com/spf13/cobra v1.7.0
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/luthermonson/go-proxmox v0.3.2
+	github.com/pkg/sftp v1.13.10
+	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.48.0
+	golang.org/x/time v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/buger/goterm v1.0.4 // indirect
 	github.com/diskfs/go-diskfs v1.7.0 // indirect
 	github.com/djherbis/times v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jinzhu/copier v0.4.0 // indirect
-	github.com/luthermonson/go-proxmox v0.3.2 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/magefile/mage v1.15.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/crypto v0.48.0 // indirect
 	golang.org/x/sys v0.41.0 // indirect
 )
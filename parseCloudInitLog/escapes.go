@@ -0,0 +1,78 @@
+package parseCloudInitLog
+
+import "strings"
+
+// StripEscapes removes the terminal escape sequences and control characters
+// serial consoles are full of, such as colors, cursor movement, window
+// titles and bells, from a line of output. Backspaces erase the character
+// before them and tabs are kept.
+func StripEscapes(line string) string {
+	if !strings.ContainsFunc(line, isControl) {
+		return line
+	}
+
+	var b strings.Builder
+	b.Grow(len(line))
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == 0x1b:
+			i = skipEscape(line, i)
+		case c == '\b':
+			s := b.String()
+			if len(s) > 0 {
+				// Drop the last byte, and the rest of a multi-byte rune.
+				n := len(s) - 1
+				for n > 0 && s[n]&0xc0 == 0x80 {
+					n--
+				}
+				b.Reset()
+				b.WriteString(s[:n])
+			}
+		case c == '\t' || !isControl(rune(c)):
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
+
+// skipEscape returns the index of the last byte of the escape sequence
+// starting at line[i], or of the last byte of line if it is cut off.
+func skipEscape(line string, i int) int {
+	if i+1 >= len(line) {
+		return i
+	}
+	switch line[i+1] {
+	case '[':
+		// CSI: parameters and intermediates up to a final byte in @ to ~.
+		for j := i + 2; j < len(line); j++ {
+			if line[j] >= 0x40 && line[j] <= 0x7e {
+				return j
+			}
+		}
+		return len(line) - 1
+	case ']', 'P', 'X', '^', '_':
+		// OSC and other strings, ended by BEL or ESC \.
+		for j := i + 2; j < len(line); j++ {
+			if line[j] == 0x07 {
+				return j
+			}
+			if line[j] == 0x1b && j+1 < len(line) && line[j+1] == '\\' {
+				return j + 1
+			}
+		}
+		return len(line) - 1
+	case '(', ')', '*', '+', '#', '%':
+		// Character set selection, one more byte.
+		if i+2 < len(line) {
+			return i + 2
+		}
+		return len(line) - 1
+	}
+	// Two byte sequences such as ESC c (reset) and ESC 7 (save cursor).
+	return i + 1
+}
@@ -0,0 +1,110 @@
+package parseCloudInitLog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripEscapes(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"plain", "ci-info: | eth0 | True |", "ci-info: | eth0 | True |"},
+		{"colors", "[  \x1b[0;32mOK  \x1b[0m] Started \x1b[0;1;39mssh.service\x1b[0m.", "[  OK  ] Started ssh.service."},
+		{"terminal reset", "\x1b[!p\x1b]104\x07\x1b[?7hDebian GNU/Linux 13 vm ttyS0", "Debian GNU/Linux 13 vm ttyS0"},
+		{"osc with st", "\x1b]0;title\x1b\\vm login: ", "vm login: "},
+		{"full reset", "\x1bcvm login: ", "vm login: "},
+		{"charset", "\x1b(Bvm", "vm"},
+		{"backspace", "vmm\b login: ", "vm login: "},
+		{"tab and bell", "a\tb\x07\x00c", "a\tbc"},
+		{"cut off", "vm login: \x1b[", "vm login: "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripEscapes(tc.in); got != tc.want {
+				t.Errorf("StripEscapes(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCloudInitLineEndings(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf := strings.ReplaceAll(string(content), "\r\n", "\n")
+	want := ParseCloudInit([]byte(lf))
+
+	for name, in := range map[string]string{
+		"crlf": strings.ReplaceAll(lf, "\n", "\r\n"),
+		"cr":   strings.ReplaceAll(lf, "\n", "\r"),
+	} {
+		got, err := ParseCloudInitStream(&chunkReader{[]byte(in), 13}, StreamCallbacks{})
+		if err != nil {
+			t.Fatalf("%s: ParseCloudInitStream gave err: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: parsed differently from LF line endings:\n%+v\n%+v", name, got, want)
+		}
+	}
+}
+
+func TestParseCloudInitRawConsolesHaveNoEscapes(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := ParseCloudInit(content)
+		fields := []string{data.Hostname, data.Version, data.Datasource, data.InstanceID}
+		fields = append(fields, data.IPs...)
+		fields = append(fields, data.HostKeys...)
+		for _, e := range append(data.Errors, data.Warnings...) {
+			fields = append(fields, e.Message)
+		}
+		for _, f := range fields {
+			if strings.ContainsFunc(f, isControl) {
+				t.Errorf("%s: control characters left in %q", file, f)
+			}
+		}
+	}
+}
+
+func TestParseCloudInitColoredConsoles(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ParseCloudInit(content)
+
+		// Color every line, as some Ubuntu consoles do, and end it with
+		// CR LF, with a redraw of the line before the last CR.
+		lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = "\x1b[0;1;39m" + line + "\x1b[0m\x1b[K"
+			}
+		}
+		colored := strings.Join(lines, "\r\n")
+
+		got, err := ParseCloudInitStream(&chunkReader{[]byte(colored), 29}, StreamCallbacks{})
+		if err != nil {
+			t.Fatalf("%s: ParseCloudInitStream gave err: %v", file, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: parsed differently with colors and CR LF:\n%+v\n%+v", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package parseCloudInitLog
+
+import (
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NetInterface is a network device of the VM as the ci-info tables show it.
+type NetInterface struct {
+	Name string `json:"name" yaml:"name"`
+	MAC  string `json:"mac" yaml:"mac"`
+	// Addresses have their prefix length, link-local ones included.
+	Addresses []string `json:"addresses" yaml:"addresses"`
+	// Gateways are the gateways of the default routes through the device.
+	Gateways []string `json:"gateways" yaml:"gateways"`
+}
+
+// Route is a row of the ci-info route tables.
+type Route struct {
+	// Destination has its prefix length, or is a name such as "local" or
+	// "multicast" as IPv6 routes may have.
+	Destination string `json:"destination" yaml:"destination"`
+	Gateway     string `json:"gateway" yaml:"gateway"` // "" for routes without one
+	Interface   string `json:"interface" yaml:"interface"`
+	Flags       string `json:"flags" yaml:"flags"`
+}
+
+// Default reports whether r is a default route.
+func (r Route) Default() bool {
+	return r.Destination == "0.0.0.0/0" || r.Destination == "::/0"
+}
+
+var (
+	ciTableTitle = regexp.MustCompile(`^ci-info:\s+\++([^+]+?)\++\s*$`)
+	ciTableRow   = regexp.MustCompile(`^ci-info:\s+\|(.*)\|\s*$`)
+)
+
+// Interface returns the network device called name.
+func (d CloudInitData) Interface(name string) (NetInterface, bool) {
+	for _, iface := range d.Interfaces {
+		if iface.Name == name {
+			return iface, true
+		}
+	}
+	return NetInterface{}, false
+}
+
+// parseNetwork records the rows of the ci-info device and route tables in
+// line, which has its log prefix stripped. Loopback is left out, as it is
+// from IPs. cloud-init may print the tables twice, to the console and to its
+// log, rows already seen are skipped.
+func (p *Parser) parseNetwork(line string) {
+	if matches := ciTableTitle.FindStringSubmatch(line); matches != nil {
+		p.ciTable = strings.TrimSpace(matches[1])
+		return
+	}
+	matches := ciTableRow.FindStringSubmatch(line)
+	if matches == nil {
+		return
+	}
+	var cols []string
+	for _, col := range strings.Split(matches[1], "|") {
+		cols = append(cols, strings.TrimSpace(col))
+	}
+
+	switch p.ciTable {
+	case "Net device info":
+		// Device, Up, Address, Mask, Scope, Hw-Address
+		if len(cols) < 6 || cols[0] == "Device" || cols[0] == "lo" {
+			return
+		}
+		addr := cols[2]
+		if cols[3] != "." && !strings.Contains(addr, "/") {
+			addr += "/" + prefixLength(cols[3])
+		}
+		iface := p.netInterface(cols[0])
+		if mac := cols[5]; mac != "." && iface.MAC == "" {
+			iface.MAC = mac
+		}
+		if addr != "." && !slices.Contains(iface.Addresses, addr) {
+			iface.Addresses = append(iface.Addresses, addr)
+		}
+	case "Route IPv4 info":
+		// Route, Destination, Gateway, Genmask, Interface, Flags
+		if len(cols) < 6 || cols[0] == "Route" {
+			return
+		}
+		p.addRoute(Route{
+			Destination: cols[1] + "/" + prefixLength(cols[3]),
+			Gateway:     routeGateway(cols[2]),
+			Interface:   cols[4],
+			Flags:       cols[5],
+		})
+	case "Route IPv6 info":
+		// Route, Destination, Gateway, Interface, Flags
+		if len(cols) < 5 || cols[0] == "Route" {
+			return
+		}
+		p.addRoute(Route{
+			Destination: cols[1],
+			Gateway:     routeGateway(cols[2]),
+			Interface:   cols[3],
+			Flags:       cols[4],
+		})
+	}
+}
+
+// netInterface returns the device called name, adding it when it is new.
+func (p *Parser) netInterface(name string) *NetInterface {
+	data := &p.data
+	for i := range data.Interfaces {
+		if data.Interfaces[i].Name == name {
+			return &data.Interfaces[i]
+		}
+	}
+	data.Interfaces = append(data.Interfaces, NetInterface{Name: name, Addresses: []string{}, Gateways: []string{}})
+	return &data.Interfaces[len(data.Interfaces)-1]
+}
+
+func (p *Parser) addRoute(r Route) {
+	if r.Interface == "lo" || slices.Contains(p.data.Routes, r) {
+		return
+	}
+	p.data.Routes = append(p.data.Routes, r)
+	if r.Default() && r.Gateway != "" {
+		iface := p.netInterface(r.Interface)
+		if !slices.Contains(iface.Gateways, r.Gateway) {
+			iface.Gateways = append(iface.Gateways, r.Gateway)
+		}
+	}
+}
+
+// prefixLength returns the prefix length of a dotted netmask such as
+// 255.255.255.0, or the netmask itself if it is not one.
+func prefixLength(mask string) string {
+	ip := net.ParseIP(mask).To4()
+	if ip == nil {
+		return mask
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if bits == 0 {
+		return mask
+	}
+	return strconv.Itoa(ones)
+}
+
+// routeGateway returns gateway, or "" for the unspecified address the route
+// tables show for routes without one.
+func routeGateway(gateway string) string {
+	if ip := net.ParseIP(gateway); ip != nil && ip.IsUnspecified() {
+		return ""
+	}
+	return gateway
+}
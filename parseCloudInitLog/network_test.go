@@ -0,0 +1,72 @@
+package parseCloudInitLog
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetwork(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ParseCloudInit(content)
+
+	wantIfaces := []NetInterface{{
+		Name: "eth0",
+		MAC:  "bc:24:11:b7:e9:c1",
+		Addresses: []string{
+			"192.168.1.191/24",
+			"2a02:aa14:4582:1100:be24:11ff:feb7:e9c1/64",
+			"fe80::be24:11ff:feb7:e9c1/64",
+		},
+		Gateways: []string{"192.168.1.1", "fe80::c6eb:39ff:fe3f:53a"},
+	}}
+	if !reflect.DeepEqual(data.Interfaces, wantIfaces) {
+		t.Errorf("Expected interfaces %+v, got %+v", wantIfaces, data.Interfaces)
+	}
+
+	if len(data.Routes) != 7 {
+		t.Fatalf("Expected 2 IPv4 and 5 IPv6 routes, got %+v", data.Routes)
+	}
+	if want := (Route{Destination: "0.0.0.0/0", Gateway: "192.168.1.1", Interface: "eth0", Flags: "UG"}); data.Routes[0] != want {
+		t.Errorf("Expected the IPv4 default route first, got %+v", data.Routes[0])
+	}
+	if want := (Route{Destination: "2a02:aa14:4582:1100::/64", Interface: "eth0", Flags: "Ue"}); data.Routes[2] != want {
+		t.Errorf("Expected an IPv6 route without gateway, got %+v", data.Routes[2])
+	}
+}
+
+func TestParseNetworkInterfaceNames(t *testing.T) {
+	log := `ci-info: ++++++++++++++++++++++++++++Net device info+++++++++++++++++++++++++++++
+ci-info: +----------+------+---------------+---------------+--------+-------------------+
+ci-info: |  Device  |  Up  |    Address    |      Mask     | Scope  |     Hw-Address    |
+ci-info: +----------+------+---------------+---------------+--------+-------------------+
+ci-info: |  ens18   | True | 192.168.1.178 | 255.255.255.0 | global | bc:24:11:5e:90:02 |
+ci-info: | eth1.100 | True |   10.0.100.5  |  255.255.0.0  | global | bc:24:11:5e:90:03 |
+ci-info: |    lo    | True |   127.0.0.1   |   255.0.0.0   |  host  |         .         |
+ci-info: +----------+------+---------------+---------------+--------+-------------------+
+ci-info: +++++++++++++++++++++++++++++Route IPv4 info+++++++++++++++++++++++++++++
+ci-info: +-------+-------------+-------------+---------------+-----------+-------+
+ci-info: | Route | Destination |   Gateway   |    Genmask    | Interface | Flags |
+ci-info: +-------+-------------+-------------+---------------+-----------+-------+
+ci-info: |   0   |   0.0.0.0   | 192.168.1.1 |    0.0.0.0    |   ens18   |   UG  |
+ci-info: |   1   |   10.0.0.0  |   0.0.0.0   |  255.255.0.0  |  eth1.100 |   U   |
+ci-info: +-------+-------------+-------------+---------------+-----------+-------+
+`
+	data := ParseCloudInit([]byte(log))
+	if len(data.Interfaces) != 2 {
+		t.Fatalf("Expected ens18 and eth1.100 without lo, got %+v", data.Interfaces)
+	}
+	vlan, ok := data.Interface("eth1.100")
+	if !ok || vlan.MAC != "bc:24:11:5e:90:03" || !reflect.DeepEqual(vlan.Addresses, []string{"10.0.100.5/16"}) || len(vlan.Gateways) != 0 {
+		t.Errorf("Unexpected VLAN device %+v", vlan)
+	}
+	if ens18, _ := data.Interface("ens18"); !reflect.DeepEqual(ens18.Gateways, []string{"192.168.1.1"}) {
+		t.Errorf("Expected the default gateway on ens18, got %+v", ens18)
+	}
+	if !reflect.DeepEqual(data.IPs, []string{"192.168.1.178", "10.0.100.5"}) {
+		t.Errorf("Expected the addresses of both devices in IPs, got %v", data.IPs)
+	}
+}
@@ -1,154 +1,459 @@
-package parseCloudInitLog
-
-import (
-	"bufio"
-	"bytes"
-	"regexp"
-	"strings"
-)
-
-// CloudInitData contains the parsed cloud-init information from a VM
-type CloudInitData struct {
-	Hostname      string
-	IPs           []string
-	HostKeyHashes []HostKeyHash
-	HostKeys      []string
-	SSHKeyData    map[string]SSHKeyData
-}
-
-// HostKeyHash represents an SSH host key fingerprint
-type HostKeyHash struct {
-	KeyType     string
-	Fingerprint string
-	Hostname    string
-	Algorithm   string
-}
-
-type SSHKeyData struct {
-	Keytype     string
-	FingerPrint string
-	Options     string
-	Comment     string
-}
-
+package parseCloudInitLog
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudInitData contains the parsed cloud-init information from a VM
+type CloudInitData struct {
+	Hostname      string                `json:"hostname" yaml:"hostname"`
+	IPs           []string              `json:"ips" yaml:"ips"`
+	HostKeyHashes []HostKeyHash         `json:"host_key_hashes" yaml:"host_key_hashes"`
+	HostKeys      []string              `json:"host_keys" yaml:"host_keys"`
+	SSHKeyData    map[string]SSHKeyData `json:"ssh_key_data" yaml:"ssh_key_data"`
+	// Interfaces and Routes are what the ci-info network tables show.
+	Interfaces []NetInterface `json:"interfaces" yaml:"interfaces"`
+	Routes     []Route        `json:"routes" yaml:"routes"`
+	// Errors are the failures cloud-init logged, a non-empty Errors means
+	// the VM is only partly set up.
+	Errors   []CloudInitError `json:"errors" yaml:"errors"`
+	Warnings []CloudInitError `json:"warnings" yaml:"warnings"`
+	// Timing is how long the kernel and the cloud-init stages took.
+	Timing BootTiming `json:"timing" yaml:"timing"`
+	// Version is the cloud-init version, such as "25.2-0ubuntu1~24.04.1".
+	Version string `json:"version" yaml:"version"`
+	// Datasource is where cloud-init found its configuration, such as
+	// "NoCloud" or "ConfigDrive", and DatasourceDetail the seed it used,
+	// such as "[seed=/dev/sr0][dsmode=net]". "None" means cloud-init found
+	// no configuration and applied no user-data.
+	Datasource       string `json:"datasource" yaml:"datasource"`
+	DatasourceDetail string `json:"datasource_detail" yaml:"datasource_detail"`
+	// InstanceID is only logged to the console with debug logging on.
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+}
+
+// BootTiming breaks down where the boot time of a VM went.
+type BootTiming struct {
+	// Stages are the boot stages seen, in order: "kernel" when the console
+	// has kernel timestamps, then the cloud-init stages "init-local",
+	// "init-network", "config" and "final".
+	Stages []BootStage `json:"stages" yaml:"stages"`
+	// Finished is the uptime cloud-init finished at, 0 if it had not.
+	Finished time.Duration `json:"finished" yaml:"finished"`
+	// Modules are the cloud-init modules that ran, in the order they
+	// finished. cloud-init only logs them to the console with debug logging
+	// on, they are always in /var/log/cloud-init.log.
+	Modules []ModuleTiming `json:"modules" yaml:"modules"`
+}
+
+// ModuleTiming is how long a cloud-init module took, timed by the
+// timestamps of the start and finish events cloud-init logged for it.
+type ModuleTiming struct {
+	Name     string        `json:"name" yaml:"name"`   // such as "scripts-user"
+	Stage    string        `json:"stage" yaml:"stage"` // the BootStage it ran in, such as "final"
+	Duration time.Duration `json:"duration" yaml:"duration"`
+	Result   string        `json:"result" yaml:"result"` // SUCCESS, WARN or FAIL
+}
+
+// BootStage is a stage of the boot, timed by the uptime the VM reported.
+type BootStage struct {
+	Name     string        `json:"name" yaml:"name"`
+	Start    time.Duration `json:"start" yaml:"start"`
+	Duration time.Duration `json:"duration" yaml:"duration"` // 0 while the stage had not ended
+}
+
+// Stage returns the stage called name.
+func (t BootTiming) Stage(name string) (BootStage, bool) {
+	for _, s := range t.Stages {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return BootStage{}, false
+}
+
+// Slowest returns the n modules that took longest, slowest first.
+func (t BootTiming) Slowest(n int) []ModuleTiming {
+	modules := slices.Clone(t.Modules)
+	slices.SortStableFunc(modules, func(a, b ModuleTiming) int { return cmp.Compare(b.Duration, a.Duration) })
+	return modules[:min(n, len(modules))]
+}
+
+// String formats the stages as "kernel 1.07s, init-local 1.93s, ...".
+func (t BootTiming) String() string {
+	var parts []string
+	for _, s := range t.Stages {
+		if s.Duration > 0 {
+			parts = append(parts, fmt.Sprintf("%s %.2fs", s.Name, s.Duration.Seconds()))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s (started at %.2fs)", s.Name, s.Start.Seconds()))
+		}
+	}
+	if t.Finished > 0 {
+		parts = append(parts, fmt.Sprintf("done at %.2fs", t.Finished.Seconds()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CloudInitError is an error or warning cloud-init logged to the console.
+type CloudInitError struct {
+	// Source is the failed module, such as "scripts-user", or the Python file
+	// that logged the message, such as "util.py".
+	Source    string   `json:"source" yaml:"source"`
+	Message   string   `json:"message" yaml:"message"`
+	Traceback []string `json:"traceback" yaml:"traceback"` // frames of the Python traceback, if one was logged
+}
+
+// HostKeyHash represents an SSH host key fingerprint
+type HostKeyHash struct {
+	KeyType     string `json:"key_type" yaml:"key_type"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	Hostname    string `json:"hostname" yaml:"hostname"`
+	Algorithm   string `json:"algorithm" yaml:"algorithm"`
+}
+
+type SSHKeyData struct {
+	Keytype     string `json:"key_type" yaml:"key_type"`
+	FingerPrint string `json:"fingerprint" yaml:"fingerprint"`
+	Options     string `json:"options" yaml:"options"`
+	Comment     string `json:"comment" yaml:"comment"`
+}
+
 var (
-	ipv4Regex     = regexp.MustCompile(`\|\s+eth0\s+\|\s+True\s+\|\s+(\d+\.\d+\.\d+\.\d+)\s+\|`)
-	ipv6Regex     = regexp.MustCompile(`\|\s+eth0\s+\|\s+True\s+\|\s+([0-9a-f:]+/\d+)\s+\|`)
-	hashRegex     = regexp.MustCompile(`(\d+)\s+(SHA256:[A-Za-z0-9+/]+)\s+root@(\S+)\s+\((\w+)\)`)
+	ipv4Regex     = regexp.MustCompile(`\|\s+([\w.@-]+)\s+\|\s+True\s+\|\s+(\d+\.\d+\.\d+\.\d+)\s+\|`)
+	ipv6Regex     = regexp.MustCompile(`\|\s+([\w.@-]+)\s+\|\s+True\s+\|\s+([0-9a-f:]+/\d+)\s+\|`)
+	udhcpcRegex   = regexp.MustCompile(`udhcpc: lease of (\d+\.\d+\.\d+\.\d+) obtained`)
+	hashRegex     = regexp.MustCompile(`(\d+)\s+(SHA256:[A-Za-z0-9+/]+)\s+(?:root@(\S+)|no comment)\s+\((\w+)\)`)
 	hostnameRegex = regexp.MustCompile(`(\S+)\s+login:\s*$`)
 	sshKeyRegex   = regexp.MustCompile(`^(ssh-\S+|ecdsa-\S+)\s+\S+\s+root@(\S+)`)
 	authKeyUser   = regexp.MustCompile(`^ci-info:\s+\+.*for user ([^+\s]+)\+`)
 	authKeyRow    = regexp.MustCompile(`^ci-info:\s+\|\s*([^|]+?)\s*\|\s*([^|]+?)\s*\|\s*([^|]+?)\s*\|\s*([^|]+?)\s*\|`)
+	ciLogPrefix   = regexp.MustCompile(`^(?:\[\s*\d+\.\d+\]\s+)?cloud-init\[\d+\]:\s?`)
+	logLevelRegex = regexp.MustCompile(`(\S+\.py)\[(WARNING|ERROR|CRITICAL)\]:\s*(.*)$`)
+	moduleFailed  = regexp.MustCompile(`(?:Running module (\S+) \(.*\) failed|Failed to run module (\S+))`)
+	statusError   = regexp.MustCompile(`^\s*status:\s+error\b`)
+	versionRegex  = regexp.MustCompile(`Cloud-init v\. (\S+) (?:running|finished)`)
+	sourceRegex   = regexp.MustCompile(`Datasource DataSource(\w+)\s*((?:\[[^\]]*\])*)`)
+	instanceRegex = regexp.MustCompile(`targeting instance id: (\S+?)\.? new=`)
+	stageRegex    = regexp.MustCompile(`Cloud-init v\. \S+ running '([^']+)' at .*Up (\d+(?:\.\d+)?) seconds`)
+	finishedRegex = regexp.MustCompile(`Cloud-init v\. \S+ finished at .*Up (\d+(?:\.\d+)?) seconds`)
+	initRegex     = regexp.MustCompile(`\[\s*(\d+\.\d+)\] Run \S+ as init process`)
+	unitFailed    = regexp.MustCompile(`FAILED.*Failed to start (.*[Cc]loud.*?)\.?\s*$`)
+	moduleEvent   = regexp.MustCompile(`(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d,\d{3}) - \S+\[\w+\]: (start|finish): ((init-local|init-network|modules-config|modules-final)/config-([\w-]+)): (?:(SUCCESS|WARN|FAIL):)?`)
 )
-
-// ParseCloudInit parses cloud-init serial output and extracts VM configuration
-func ParseCloudInit(content []byte) CloudInitData {
-	data := CloudInitData{
-		IPs:           []string{},
-		HostKeyHashes: []HostKeyHash{},
-		HostKeys:      []string{},
-		SSHKeyData:    map[string]SSHKeyData{},
-	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(content))
-	inHostKeys := false
-	currentAuthUser := ""
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Extract hostname from login prompt
-		if data.Hostname == "" {
-			if matches := hostnameRegex.FindStringSubmatch(line); matches != nil {
-				data.Hostname = matches[1]
-			}
-		}
-
-		// Extract IPv4 addresses
-		if matches := ipv4Regex.FindStringSubmatch(line); matches != nil {
-			ip := matches[1]
-			if !contains(data.IPs, ip) {
-				data.IPs = append(data.IPs, ip)
-			}
-		}
-
-		// Extract IPv6 addresses
-		if matches := ipv6Regex.FindStringSubmatch(line); matches != nil {
-			ip := matches[1]
-			if !contains(data.IPs, ip) {
-				data.IPs = append(data.IPs, ip)
-			}
-		}
-
-		// Extract host key fingerprints
-		if matches := hashRegex.FindStringSubmatch(line); matches != nil {
-			hash := HostKeyHash{
-				KeyType:     matches[4],
-				Fingerprint: matches[2],
-				Hostname:    matches[3],
-				Algorithm:   matches[1] + " bits",
-			}
-			data.HostKeyHashes = append(data.HostKeyHashes, hash)
-		}
-
-		// Extract actual SSH host keys
-		if strings.Contains(line, "-----BEGIN SSH HOST KEY KEYS-----") {
-			inHostKeys = true
-			continue
-		}
-		if strings.Contains(line, "-----END SSH HOST KEY KEYS-----") {
-			inHostKeys = false
-			continue
-		}
-		if inHostKeys {
-			trimmed := strings.TrimSpace(line)
-			if strings.HasPrefix(trimmed, "ssh-") || strings.HasPrefix(trimmed, "ecdsa-") {
-				data.HostKeys = append(data.HostKeys, trimmed)
-				// Extract hostname from key if we don't have it yet
-				if data.Hostname == "" {
-					if matches := sshKeyRegex.FindStringSubmatch(trimmed); matches != nil {
-						data.Hostname = matches[2]
-					}
-				}
+
+// ParseCloudInit parses cloud-init serial output and extracts VM configuration
+func ParseCloudInit(content []byte) CloudInitData {
+	p := NewParser(StreamCallbacks{})
+	p.Write(content)
+	p.Close()
+	return p.Data()
+}
+
+// parseLine extracts what a single line of serial output holds into p.data,
+// firing the callbacks of what it found.
+func (p *Parser) parseLine(line string) error {
+	data := &p.data
+	p.line++
+
+	if done, err := p.parseProblem(line); done || err != nil {
+		return err
+	}
+	if err := p.parseTiming(line); err != nil {
+		return err
+	}
+	p.parseMetadata(line)
+
+	// Extract hostname from login prompt
+	if matches := hostnameRegex.FindStringSubmatch(line); matches != nil {
+		if err := p.setHostname(matches[1]); err != nil {
+			return err
+		}
+		if !p.loginSeen {
+			p.loginSeen = true
+			if err := p.cb.loginPrompt(matches[1]); err != nil {
+				return err
 			}
 		}
+	}
+
+	// Extract IPv4 addresses. RHEL-family images may name the interface
+	// ens18 or the like instead of eth0.
+	if matches := ipv4Regex.FindStringSubmatch(line); matches != nil && matches[1] != "lo" {
+		if err := p.addIP(matches[2]); err != nil {
+			return err
+		}
+	}
+
+	// Extract IPv6 addresses
+	if matches := ipv6Regex.FindStringSubmatch(line); matches != nil && matches[1] != "lo" {
+		if err := p.addIP(matches[2]); err != nil {
+			return err
+		}
+	}
+
+	// Alpine prints the DHCP lease of busybox udhcpc rather than ci-info.
+	if matches := udhcpcRegex.FindStringSubmatch(line); matches != nil {
+		if err := p.addIP(matches[1]); err != nil {
+			return err
+		}
+	}
 
-		// Extract authorized SSH key metadata for cloud-init users.
-		if matches := authKeyUser.FindStringSubmatch(line); matches != nil {
-			currentAuthUser = matches[1]
-			continue
+	// Extract host key fingerprints. RHEL-family host keys have no comment,
+	// so their fingerprints carry no hostname.
+	if matches := hashRegex.FindStringSubmatch(line); matches != nil {
+		hash := HostKeyHash{
+			KeyType:     matches[4],
+			Fingerprint: matches[2],
+			Hostname:    matches[3],
+			Algorithm:   matches[1] + " bits",
 		}
-		if currentAuthUser != "" {
-			if strings.HasPrefix(line, "ci-info: +") {
-				continue
+		data.HostKeyHashes = append(data.HostKeyHashes, hash)
+	}
+
+	// Extract actual SSH host keys
+	if strings.Contains(line, "-----BEGIN SSH HOST KEY KEYS-----") {
+		p.inHostKeys = true
+		return nil
+	}
+	if strings.Contains(line, "-----END SSH HOST KEY KEYS-----") {
+		p.inHostKeys = false
+		return p.cb.hostKeys(data.HostKeys)
+	}
+	if p.inHostKeys {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "ssh-") || strings.HasPrefix(trimmed, "ecdsa-") {
+			data.HostKeys = append(data.HostKeys, trimmed)
+			// Extract hostname from key if we don't have it yet
+			if matches := sshKeyRegex.FindStringSubmatch(trimmed); matches != nil {
+				if err := p.setHostname(matches[2]); err != nil {
+					return err
+				}
 			}
-			if matches := authKeyRow.FindStringSubmatch(line); matches != nil {
-				keytype := strings.TrimSpace(matches[1])
-				if strings.HasPrefix(keytype, "ssh-") || strings.HasPrefix(keytype, "ecdsa-") {
-					options := strings.TrimSpace(matches[3])
-					if options == "-" {
-						options = ""
-					}
-					data.SSHKeyData[currentAuthUser] = SSHKeyData{
-						Keytype:     keytype,
-						FingerPrint: strings.TrimSpace(matches[2]),
-						Options:     options,
-						Comment:     strings.TrimSpace(matches[4]),
-					}
-					currentAuthUser = ""
+		}
+	}
+
+	// Extract authorized SSH key metadata for cloud-init users. RHEL-family
+	// consoles prefix the ci-info tables with the kernel time and process.
+	line = ciLogPrefix.ReplaceAllString(line, "")
+	p.parseNetwork(line)
+	if matches := authKeyUser.FindStringSubmatch(line); matches != nil {
+		p.currentAuthUser = matches[1]
+		return nil
+	}
+	if p.currentAuthUser != "" {
+		if strings.HasPrefix(line, "ci-info: +") {
+			return nil
+		}
+		if matches := authKeyRow.FindStringSubmatch(line); matches != nil {
+			keytype := strings.TrimSpace(matches[1])
+			if strings.HasPrefix(keytype, "ssh-") || strings.HasPrefix(keytype, "ecdsa-") {
+				options := strings.TrimSpace(matches[3])
+				if options == "-" {
+					options = ""
+				}
+				data.SSHKeyData[p.currentAuthUser] = SSHKeyData{
+					Keytype:     keytype,
+					FingerPrint: strings.TrimSpace(matches[2]),
+					Options:     options,
+					Comment:     strings.TrimSpace(matches[4]),
 				}
+				p.currentAuthUser = ""
+			}
+		}
+	}
+	return nil
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProblem collects the errors and warnings in line, and Python
+// tracebacks spanning several lines. It reports whether line was part of a
+// traceback and needs no further parsing.
+func (p *Parser) parseProblem(line string) (bool, error) {
+	data := &p.data
+	msg := ciLogPrefix.ReplaceAllString(line, "")
+
+	if p.traceback != nil {
+		if strings.HasPrefix(msg, " ") || strings.HasPrefix(msg, "\t") {
+			p.traceback = append(p.traceback, strings.TrimSpace(msg))
+			return true, nil
+		}
+		// The exception ends the traceback. It belongs to the error logged
+		// right before it, if there is one.
+		exception := strings.TrimSpace(msg)
+		if n := len(data.Errors); n > 0 && p.errorLine == p.tracebackLine-1 && data.Errors[n-1].Traceback == nil {
+			data.Errors[n-1].Traceback = p.traceback
+			if exception != "" {
+				data.Errors[n-1].Message += ": " + exception
 			}
+		} else {
+			p.addError(CloudInitError{Source: "traceback", Message: exception, Traceback: p.traceback})
+		}
+		p.traceback = nil
+		return true, nil
+	}
+	if strings.HasPrefix(strings.TrimSpace(msg), "Traceback (most recent call last):") {
+		p.traceback = []string{}
+		p.tracebackLine = p.line
+		return true, nil
+	}
+
+	if matches := logLevelRegex.FindStringSubmatch(msg); matches != nil {
+		problem := CloudInitError{Source: matches[1], Message: strings.TrimSpace(matches[3])}
+		if m := moduleFailed.FindStringSubmatch(problem.Message); m != nil {
+			problem.Source = m[1] + m[2]
+			p.addError(problem)
+		} else if matches[2] == "WARNING" {
+			data.Warnings = append(data.Warnings, problem)
+		} else {
+			p.addError(problem)
 		}
+		return false, nil
+	}
+	if statusError.MatchString(msg) {
+		p.addError(CloudInitError{Source: "status", Message: strings.TrimSpace(msg)})
+	} else if matches := unitFailed.FindStringSubmatch(msg); matches != nil {
+		p.addError(CloudInitError{Source: "systemd", Message: "failed to start " + matches[1]})
+	}
+	return false, nil
+}
+
+func (p *Parser) addError(e CloudInitError) {
+	p.data.Errors = append(p.data.Errors, e)
+	p.errorLine = p.line
+}
+
+// stageNames maps the cloud-init stage names in the log onto BootStage names.
+var stageNames = map[string]string{
+	"init-local":     "init-local",
+	"init":           "init-network",
+	"modules:config": "config",
+	"modules:final":  "final",
+}
+
+// parseTiming records when the kernel handed over to init and when the
+// cloud-init stages started and finished, from the uptimes in line. It
+// returns the error of the Finished callback.
+func (p *Parser) parseTiming(line string) error {
+	t := &p.data.Timing
+	if matches := moduleEvent.FindStringSubmatch(line); matches != nil {
+		p.parseModuleEvent(matches)
+		return nil
+	}
+	if matches := initRegex.FindStringSubmatch(line); matches != nil {
+		if len(t.Stages) == 0 {
+			t.Stages = append(t.Stages, BootStage{Name: "kernel", Duration: parseUptime(matches[1])})
+		}
+		return nil
+	}
+	if matches := stageRegex.FindStringSubmatch(line); matches != nil {
+		name, ok := stageNames[matches[1]]
+		if !ok {
+			return nil
+		}
+		if _, seen := t.Stage(name); seen {
+			return nil
+		}
+		start := parseUptime(matches[2])
+		t.endLastStage(start)
+		t.Stages = append(t.Stages, BootStage{Name: name, Start: start})
+		return nil
+	}
+	if matches := finishedRegex.FindStringSubmatch(line); matches != nil && t.Finished == 0 {
+		t.Finished = parseUptime(matches[1])
+		t.endLastStage(t.Finished)
+		return p.cb.finished(t.Finished)
+	}
+	return nil
+}
+
+// moduleStages maps the stage of cloud-init module events onto BootStage
+// names.
+var moduleStages = map[string]string{
+	"init-local":     "init-local",
+	"init-network":   "init-network",
+	"modules-config": "config",
+	"modules-final":  "final",
+}
+
+// parseModuleEvent times a module from the start and finish event
+// moduleEvent matched, such as
+// "2025-01-02 10:00:01,250 - handlers.py[DEBUG]: finish: modules-final/config-scripts-user: SUCCESS: config-scripts-user ran successfully".
+func (p *Parser) parseModuleEvent(matches []string) {
+	at, err := time.Parse("2006-01-02 15:04:05,000", matches[1])
+	if err != nil {
+		return
+	}
+	event := matches[3]
+	if matches[2] == "start" {
+		if p.moduleStarts == nil {
+			p.moduleStarts = map[string]time.Time{}
+		}
+		p.moduleStarts[event] = at
+		return
+	}
+	start, ok := p.moduleStarts[event]
+	if !ok {
+		return
+	}
+	delete(p.moduleStarts, event)
+	p.data.Timing.Modules = append(p.data.Timing.Modules, ModuleTiming{
+		Name:     matches[5],
+		Stage:    moduleStages[matches[4]],
+		Duration: at.Sub(start),
+		Result:   matches[6],
+	})
+}
+
+// endLastStage ends the running cloud-init stage at uptime end.
+func (t *BootTiming) endLastStage(end time.Duration) {
+	if n := len(t.Stages); n > 0 && t.Stages[n-1].Name != "kernel" && t.Stages[n-1].Duration == 0 {
+		t.Stages[n-1].Duration = end - t.Stages[n-1].Start
+	}
+}
+
+func parseUptime(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second)).Round(time.Millisecond)
+}
+
+// parseMetadata records the cloud-init version, the datasource and the
+// instance ID.
+func (p *Parser) parseMetadata(line string) {
+	data := &p.data
+	if data.Version == "" {
+		if matches := versionRegex.FindStringSubmatch(line); matches != nil {
+			data.Version = matches[1]
+		}
+	}
+	if matches := sourceRegex.FindStringSubmatch(line); matches != nil && data.Datasource == "" {
+		data.Datasource = matches[1]
+		data.DatasourceDetail = matches[2]
+		if data.Datasource == "None" {
+			data.Warnings = append(data.Warnings, CloudInitError{
+				Source:  "datasource",
+				Message: "no datasource found, user-data was not applied",
+			})
+		}
+	}
+	if matches := instanceRegex.FindStringSubmatch(line); matches != nil {
+		data.InstanceID = matches[1]
 	}
-
-	return data
-}
-
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
-}
+}
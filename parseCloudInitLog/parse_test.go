@@ -1,12 +1,14 @@
-package parseCloudInitLog
-
-import (
-	"os"
-	"strings"
-	"testing"
-)
-
-func TestParseCloudInit(t *testing.T) {
+package parseCloudInitLog
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCloudInit(t *testing.T) {
 	tests := []struct {
 		name         string
 		filepath     string
@@ -18,140 +20,181 @@ func TestParseCloudInit(t *testing.T) {
 		wantMinHash  int
 		skipComplete bool // files that are incomplete (no login prompt)
 	}{
-		{
-			name:       "Debian 11",
-			filepath:   "testdata/dtt-debian-11-104-cloudinit.serial.txt",
-			wantHost:   "dtt-debian-11-104",
-			wantMinIPs: 3,
-			wantIPs: []string{
-				"192.168.1.191",
-				"2a02:aa14:4582:1100:be24:11ff:feb7:e9c1/64",
-				"fe80::be24:11ff:feb7:e9c1/64 ",
-			},
-			wantMinKeys: 3,
-			wantMinHash: 4,
-		},
-		{
-			name:       "Ubuntu Bionic",
-			filepath:   "testdata/dtt-ubuntu-bionic-105-cloudinit.serial.txt",
-			wantHost:   "dtt-ubuntu-bionic-105",
-			wantMinIPs: 1,
-			wantIPs: []string{
-				"192.168.1.26",
-				"2a02:aa14:4582:1100:be24:11ff:fe9f:4b0f/64",
-				"fe80::be24:11ff:fe9f:4b0f/64",
-			},
-			wantMinKeys:  0,
-			wantMinHash:  0,
-			skipComplete: true, // incomplete file
-		},
-		{
-			name:       "Ubuntu Focal",
-			filepath:   "testdata/dtt-ubuntu-focal-106-cloudinit.serial.txt",
-			wantHost:   "dtt-ubuntu-focal-106",
-			wantMinIPs: 2,
-			wantIPs: []string{
-				"192.168.1.146",
-				"fe80::be24:11ff:fe0b:5334/64",
-			},
-			wantMinKeys:  0,
-			wantMinHash:  0,
-			skipComplete: true, // incomplete file
-		},
-		{
-			name:       "Ubuntu Jammy",
-			filepath:   "testdata/dtt-ubuntu-jammy-107-cloudinit.serial.txt",
-			wantHost:   "dtt-ubuntu-jammy-107",
-			wantMinIPs: 2,
-			wantIPs: []string{
-				"192.168.1.148",
-				"fe80::be24:11ff:fe8a:ee23/64",
-			},
-			wantMinKeys: 3,
-			wantMinHash: 3,
-		},
-		{
-			name:       "Ubuntu Noble",
-			filepath:   "testdata/dtt-ubuntu-noble-108-cloudinit.serial.txt",
-			wantHost:   "dtt-ubuntu-noble-108",
-			wantMinIPs: 2,
-			wantIPs: []string{
-				"192.168.1.164",
-				"fe80::be24:11ff:fe3c:caa5/64",
-			},
-			wantMinKeys: 3,
-			wantMinHash: 3,
-		},
-		{
-			name:       "Ubuntu Noble with ssh keys",
-			filepath:   "testdata/dtt-ubuntu-noble-cloudinit-with-sshkey.serial.txt",
-			wantHost:   "dtt-ubuntu-24",
-			wantMinIPs: 2,
-			wantIPs: []string{
-				"192.168.1.42",
-				"fe80::be24:11ff:fe47:b4f1/64",
-			},
+		{
+			name:       "Debian 11",
+			filepath:   "testdata/dtt-debian-11-104-cloudinit.serial.txt",
+			wantHost:   "dtt-debian-11-104",
+			wantMinIPs: 3,
+			wantIPs: []string{
+				"192.168.1.191",
+				"2a02:aa14:4582:1100:be24:11ff:feb7:e9c1/64",
+				"fe80::be24:11ff:feb7:e9c1/64 ",
+			},
+			wantMinKeys: 3,
+			wantMinHash: 4,
+		},
+		{
+			name:       "Ubuntu Bionic",
+			filepath:   "testdata/dtt-ubuntu-bionic-105-cloudinit.serial.txt",
+			wantHost:   "dtt-ubuntu-bionic-105",
+			wantMinIPs: 1,
+			wantIPs: []string{
+				"192.168.1.26",
+				"2a02:aa14:4582:1100:be24:11ff:fe9f:4b0f/64",
+				"fe80::be24:11ff:fe9f:4b0f/64",
+			},
+			wantMinKeys:  0,
+			wantMinHash:  0,
+			skipComplete: true, // incomplete file
+		},
+		{
+			name:       "Ubuntu Focal",
+			filepath:   "testdata/dtt-ubuntu-focal-106-cloudinit.serial.txt",
+			wantHost:   "dtt-ubuntu-focal-106",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.146",
+				"fe80::be24:11ff:fe0b:5334/64",
+			},
+			wantMinKeys:  0,
+			wantMinHash:  0,
+			skipComplete: true, // incomplete file
+		},
+		{
+			name:       "Ubuntu Jammy",
+			filepath:   "testdata/dtt-ubuntu-jammy-107-cloudinit.serial.txt",
+			wantHost:   "dtt-ubuntu-jammy-107",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.148",
+				"fe80::be24:11ff:fe8a:ee23/64",
+			},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+		{
+			name:       "Ubuntu Noble",
+			filepath:   "testdata/dtt-ubuntu-noble-108-cloudinit.serial.txt",
+			wantHost:   "dtt-ubuntu-noble-108",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.164",
+				"fe80::be24:11ff:fe3c:caa5/64",
+			},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+		{
+			name:       "Ubuntu Noble with ssh keys",
+			filepath:   "testdata/dtt-ubuntu-noble-cloudinit-with-sshkey.serial.txt",
+			wantHost:   "dtt-ubuntu-24",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.42",
+				"fe80::be24:11ff:fe47:b4f1/64",
+			},
 			wantSshKeys: map[string]SSHKeyData{
 				"dtt": {
 					Keytype:     "ssh-rsa",
 					FingerPrint: "0f:f4:bf:31:b8:42:b8:bd:ad:df:cb:c6:02:23:08:c8:93:be:0c:03:61:00:18:9a:6e:7c:7a:d0:2c:b2:5a:27",
-					Options:     "",
-					Comment:     "cde@shadow",
-				},
-			},
-			wantMinKeys: 3,
-			wantMinHash: 3,
-		},
-		{
-			name:       "Debian 13",
-			filepath:   "testdata/dtt-debian-13-109-cloudinit.serial.txt",
-			wantHost:   "dtt-debian-13-109",
-			wantMinIPs: 2,
-			wantIPs: []string{
-				"192.168.1.169",
-				"fe80::be24:11ff:fec1:62c4/64",
-			},
-			wantMinKeys:  0,
-			wantMinHash:  0,
-			skipComplete: true, // incomplete file
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			content, err := os.ReadFile(tt.filepath)
-			if err != nil {
-				t.Fatalf("Failed to read file %s: %v", tt.filepath, err)
-			}
-
-			data := ParseCloudInit(content)
-
-			if !tt.skipComplete && data.Hostname != tt.wantHost {
-				t.Errorf("Hostname = %q, want %q", data.Hostname, tt.wantHost)
-			}
-			if tt.skipComplete && data.Hostname != "" && data.Hostname != tt.wantHost {
-				t.Errorf("Hostname = %q, want %q", data.Hostname, tt.wantHost)
-			}
-
-			if len(data.IPs) < tt.wantMinIPs {
-				t.Errorf("Got %d IPs, want at least %d. IPs: %v", len(data.IPs), tt.wantMinIPs, data.IPs)
-			}
-			if len(tt.wantIPs) > 0 {
-				gotIPs := make(map[string]struct{}, len(data.IPs))
-				for _, ip := range data.IPs {
-					gotIPs[strings.TrimSpace(ip)] = struct{}{}
-				}
-				for _, wantIP := range tt.wantIPs {
-					if _, ok := gotIPs[strings.TrimSpace(wantIP)]; !ok {
-						t.Errorf("Expected IP %q not found in IPs: %v", strings.TrimSpace(wantIP), data.IPs)
-					}
-				}
-			}
-
-			if len(data.HostKeys) < tt.wantMinKeys {
-				t.Errorf("Got %d host keys, want at least %d", len(data.HostKeys), tt.wantMinKeys)
-			}
-
+					Options:     "",
+					Comment:     "cde@shadow",
+				},
+			},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+		{
+			name:       "Debian 13",
+			filepath:   "testdata/dtt-debian-13-109-cloudinit.serial.txt",
+			wantHost:   "dtt-debian-13-109",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.169",
+				"fe80::be24:11ff:fec1:62c4/64",
+			},
+			wantMinKeys:  0,
+			wantMinHash:  0,
+			skipComplete: true, // incomplete file
+		},
+		{
+			name:       "Rocky 9",
+			filepath:   "testdata/dtt-rocky-9-110-cloudinit.serial.txt",
+			wantHost:   "dtt-rocky-9-110",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.177",
+				"fe80::be24:11ff:fe3a:7d01/64",
+			},
+			wantSshKeys: map[string]SSHKeyData{
+				"dtt": {
+					Keytype:     "ssh-ed25519",
+					FingerPrint: "4a:09:8e:1c:77:5b:f1:2d:39:c0:6e:aa:51:93:0d:b2:7f:e8:21:64:c3:58:9a:0f:de:42:b7:16:85:3c:e9:70",
+					Options:     "",
+					Comment:     "ops@build",
+				},
+			},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+		{
+			name:       "Fedora 40",
+			filepath:   "testdata/dtt-fedora-40-111-cloudinit.serial.txt",
+			wantHost:   "dtt-fedora-40-111",
+			wantMinIPs: 2,
+			wantIPs: []string{
+				"192.168.1.178",
+				"fe80::be24:11ff:fe5e:9002/64",
+			},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+		{
+			name:        "Alpine 3.20",
+			filepath:    "testdata/dtt-alpine-3.20-112-cloudinit.serial.txt",
+			wantHost:    "dtt-alpine-320-112",
+			wantMinIPs:  1,
+			wantIPs:     []string{"192.168.1.179"},
+			wantMinKeys: 3,
+			wantMinHash: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := os.ReadFile(tt.filepath)
+			if err != nil {
+				t.Fatalf("Failed to read file %s: %v", tt.filepath, err)
+			}
+
+			data := ParseCloudInit(content)
+
+			if !tt.skipComplete && data.Hostname != tt.wantHost {
+				t.Errorf("Hostname = %q, want %q", data.Hostname, tt.wantHost)
+			}
+			if tt.skipComplete && data.Hostname != "" && data.Hostname != tt.wantHost {
+				t.Errorf("Hostname = %q, want %q", data.Hostname, tt.wantHost)
+			}
+
+			if len(data.IPs) < tt.wantMinIPs {
+				t.Errorf("Got %d IPs, want at least %d. IPs: %v", len(data.IPs), tt.wantMinIPs, data.IPs)
+			}
+			if len(tt.wantIPs) > 0 {
+				gotIPs := make(map[string]struct{}, len(data.IPs))
+				for _, ip := range data.IPs {
+					gotIPs[strings.TrimSpace(ip)] = struct{}{}
+				}
+				for _, wantIP := range tt.wantIPs {
+					if _, ok := gotIPs[strings.TrimSpace(wantIP)]; !ok {
+						t.Errorf("Expected IP %q not found in IPs: %v", strings.TrimSpace(wantIP), data.IPs)
+					}
+				}
+			}
+
+			if len(data.HostKeys) < tt.wantMinKeys {
+				t.Errorf("Got %d host keys, want at least %d", len(data.HostKeys), tt.wantMinKeys)
+			}
+
 			if len(data.HostKeyHashes) < tt.wantMinHash {
 				t.Errorf("Got %d host key hashes, want at least %d", len(data.HostKeyHashes), tt.wantMinHash)
 			}
@@ -174,79 +217,222 @@ func TestParseCloudInit(t *testing.T) {
 			// Verify at least one IPv4 address
 			if len(data.IPs) > 0 {
 				hasIPv4 := false
-				for _, ip := range data.IPs {
-					if !strings.Contains(ip, ":") {
-						hasIPv4 = true
-						break
-					}
-				}
-				if !hasIPv4 {
-					t.Error("Expected at least one IPv4 address")
-				}
-			}
-
-			// Verify host keys are in the expected format
-			for _, key := range data.HostKeys {
-				if !strings.HasPrefix(key, "ssh-") && !strings.HasPrefix(key, "ecdsa-") {
-					t.Errorf("Invalid host key format: %s", key)
-				}
-			}
-
-			// Verify host key hashes
-			for _, hash := range data.HostKeyHashes {
-				if hash.Hostname != tt.wantHost {
-					t.Errorf("Hash hostname = %q, want %q", hash.Hostname, tt.wantHost)
-				}
-				if !strings.HasPrefix(hash.Fingerprint, "SHA256:") {
-					t.Errorf("Invalid fingerprint format: %s", hash.Fingerprint)
-				}
-			}
-		})
-	}
-}
-
-func TestParseCloudInitDebian11Detailed(t *testing.T) {
-	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
-	if err != nil {
-		t.Fatalf("Failed to read file: %v", err)
-	}
-
-	data := ParseCloudInit(content)
-
-	// Check specific values
-	if data.Hostname != "dtt-debian-11-104" {
-		t.Errorf("Hostname = %q, want %q", data.Hostname, "dtt-debian-11-104")
-	}
-
-	// Check that we have the expected IPv4 address
-	expectedIP := "192.168.1.191"
-	found := false
-	for _, ip := range data.IPs {
-		if ip == expectedIP {
-			found = true
-			break
-		}
-	}
-	if !found {
-		t.Errorf("Expected IP %s not found in IPs: %v", expectedIP, data.IPs)
-	}
-
-	// Check we have RSA, ECDSA, and ED25519 keys
-	keyTypes := make(map[string]bool)
-	for _, key := range data.HostKeys {
-		if strings.HasPrefix(key, "ssh-rsa") {
-			keyTypes["rsa"] = true
-		} else if strings.HasPrefix(key, "ssh-ed25519") {
-			keyTypes["ed25519"] = true
-		} else if strings.HasPrefix(key, "ecdsa-") {
-			keyTypes["ecdsa"] = true
-		}
-	}
-
-	expectedTypes := []string{"rsa", "ed25519", "ecdsa"}
-	for _, keyType := range expectedTypes {
-		if !keyTypes[keyType] {
-			t.Errorf("Missing %s key type", keyType)
-		}
-	}
-}
+				for _, ip := range data.IPs {
+					if !strings.Contains(ip, ":") {
+						hasIPv4 = true
+						break
+					}
+				}
+				if !hasIPv4 {
+					t.Error("Expected at least one IPv4 address")
+				}
+			}
+
+			// Verify host keys are in the expected format
+			for _, key := range data.HostKeys {
+				if !strings.HasPrefix(key, "ssh-") && !strings.HasPrefix(key, "ecdsa-") {
+					t.Errorf("Invalid host key format: %s", key)
+				}
+			}
+
+			// Verify host key hashes
+			for _, hash := range data.HostKeyHashes {
+				if hash.Hostname != tt.wantHost {
+					t.Errorf("Hash hostname = %q, want %q", hash.Hostname, tt.wantHost)
+				}
+				if !strings.HasPrefix(hash.Fingerprint, "SHA256:") {
+					t.Errorf("Invalid fingerprint format: %s", hash.Fingerprint)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCloudInitDebian11Detailed(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	data := ParseCloudInit(content)
+
+	// Check specific values
+	if data.Hostname != "dtt-debian-11-104" {
+		t.Errorf("Hostname = %q, want %q", data.Hostname, "dtt-debian-11-104")
+	}
+
+	// Check that we have the expected IPv4 address
+	expectedIP := "192.168.1.191"
+	found := false
+	for _, ip := range data.IPs {
+		if ip == expectedIP {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Errorf("Expected IP %s not found in IPs: %v", expectedIP, data.IPs)
+	}
+
+	// Check we have RSA, ECDSA, and ED25519 keys
+	keyTypes := make(map[string]bool)
+	for _, key := range data.HostKeys {
+		if strings.HasPrefix(key, "ssh-rsa") {
+			keyTypes["rsa"] = true
+		} else if strings.HasPrefix(key, "ssh-ed25519") {
+			keyTypes["ed25519"] = true
+		} else if strings.HasPrefix(key, "ecdsa-") {
+			keyTypes["ecdsa"] = true
+		}
+	}
+
+	expectedTypes := []string{"rsa", "ed25519", "ecdsa"}
+	for _, keyType := range expectedTypes {
+		if !keyTypes[keyType] {
+			t.Errorf("Missing %s key type", keyType)
+		}
+	}
+}
+
+func TestParseCloudInitErrors(t *testing.T) {
+	content := []byte(`[   12.345678] cloud-init[812]: 2026-02-21 21:43:54,443 - ug_util.py[WARNING]: DEPRECATED: 'user' of type string is deprecated.
+[   20.100000] cloud-init[990]: 2026-02-21 21:44:02,101 - util.py[WARNING]: Running module scripts-user (<module 'cloudinit.config.cc_scripts_user' from '/usr/lib/python3/dist-packages/cloudinit/config/cc_scripts_user.py'>) failed
+[   20.100100] cloud-init[990]: Traceback (most recent call last):
+[   20.100200] cloud-init[990]:   File "/usr/lib/python3/dist-packages/cloudinit/config/cc_scripts_user.py", line 38, in handle
+[   20.100300] cloud-init[990]:     subp.runparts(runparts_path)
+[   20.100400] cloud-init[990]: RuntimeError: Runparts: 1 failures (part-001) in 1 attempted commands
+Traceback (most recent call last):
+  File "/usr/bin/cloud-init", line 33, in <module>
+KeyError: 'users'
+[FAILED] Failed to start Cloud-init: Final Stage.
+status: error
+`)
+
+	data := ParseCloudInit(content)
+	if len(data.Warnings) != 1 || data.Warnings[0].Source != "ug_util.py" {
+		t.Errorf("Expected the deprecation warning, got %+v", data.Warnings)
+	}
+	if len(data.Errors) != 4 {
+		t.Fatalf("Expected 4 errors, got %d: %+v", len(data.Errors), data.Errors)
+	}
+	module := data.Errors[0]
+	if module.Source != "scripts-user" || len(module.Traceback) != 2 || !strings.HasSuffix(module.Message, "RuntimeError: Runparts: 1 failures (part-001) in 1 attempted commands") {
+		t.Errorf("Expected the failed module with its traceback, got %+v", module)
+	}
+	if tb := data.Errors[1]; tb.Source != "traceback" || tb.Message != "KeyError: 'users'" || len(tb.Traceback) != 1 {
+		t.Errorf("Expected a bare traceback, got %+v", tb)
+	}
+	if unit := data.Errors[2]; unit.Source != "systemd" || unit.Message != "failed to start Cloud-init: Final Stage" {
+		t.Errorf("Expected the failed unit, got %+v", unit)
+	}
+	if status := data.Errors[3]; status.Source != "status" {
+		t.Errorf("Expected the error status, got %+v", status)
+	}
+
+	clean, err := os.ReadFile("testdata/dtt-ubuntu-noble-108-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := ParseCloudInit(clean); len(data.Errors) != 0 {
+		t.Errorf("Expected no errors in a clean boot, got %+v", data.Errors)
+	}
+}
+
+func TestParseCloudInitTiming(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timing := ParseCloudInit(content).Timing
+	want := []BootStage{
+		{Name: "kernel", Duration: 1068 * time.Millisecond},
+		{Name: "init-local", Start: 2470 * time.Millisecond, Duration: 1930 * time.Millisecond},
+		{Name: "init-network", Start: 4400 * time.Millisecond, Duration: 510 * time.Millisecond},
+		{Name: "config", Start: 4910 * time.Millisecond, Duration: 250 * time.Millisecond},
+		{Name: "final", Start: 5160 * time.Millisecond, Duration: 4410 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(timing.Stages, want) {
+		t.Errorf("Stages = %+v, want %+v", timing.Stages, want)
+	}
+	if timing.Finished != 9570*time.Millisecond {
+		t.Errorf("Finished = %v, want 9.57s", timing.Finished)
+	}
+
+	// Focal has no kernel timestamps and was cut off in the config stage.
+	content, err = os.ReadFile("testdata/dtt-ubuntu-focal-106-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timing = ParseCloudInit(content).Timing
+	if _, ok := timing.Stage("kernel"); ok {
+		t.Errorf("Expected no kernel stage without timestamps, got %+v", timing.Stages)
+	}
+	if config, ok := timing.Stage("config"); !ok || config.Duration != 0 || timing.Finished != 0 {
+		t.Errorf("Expected an unfinished config stage, got %+v", timing)
+	}
+	if got := timing.String(); got != "init-local 1.85s, init-network 1.56s, config (started at 5.59s)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestParseCloudInitMetadata(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ParseCloudInit(content)
+	if data.Version != "20.4.1" || data.Datasource != "NoCloud" || data.DatasourceDetail != "[seed=/dev/sr0][dsmode=net]" {
+		t.Errorf("Got version %q, datasource %q %q", data.Version, data.Datasource, data.DatasourceDetail)
+	}
+
+	data = ParseCloudInit([]byte(`[    3.1] cloud-init[401]: 2026-03-02 10:12:01,100 - main.py[DEBUG]: [local] init will now be targeting instance id: 6b3c9f0e2a7d4c1e8f5a0b9d3e2c1f4a5b6c7d8e. new=True
+[    9.5] cloud-init[660]: Cloud-init v. 24.4 finished at Mon, 02 Mar 2026 10:12:09 +0000. Datasource DataSourceNone.  Up 9.50 seconds
+`))
+	if data.InstanceID != "6b3c9f0e2a7d4c1e8f5a0b9d3e2c1f4a5b6c7d8e" {
+		t.Errorf("InstanceID = %q", data.InstanceID)
+	}
+	if data.Datasource != "None" || len(data.Warnings) != 1 || data.Warnings[0].Source != "datasource" {
+		t.Errorf("Expected a warning about the missing datasource, got %q %+v", data.Datasource, data.Warnings)
+	}
+}
+
+func TestParseCloudInitModuleTiming(t *testing.T) {
+	// As /var/log/cloud-init.log has them, and the console with debug logging.
+	content := []byte(`2025-01-02 10:00:01,000 - handlers.py[DEBUG]: start: init-network/config-ssh: running config-ssh with frequency once-per-instance
+2025-01-02 10:00:01,420 - handlers.py[DEBUG]: finish: init-network/config-ssh: SUCCESS: config-ssh ran successfully
+[   12.345678] cloud-init[611]: 2025-01-02 10:00:02,000 - handlers.py[DEBUG]: start: modules-config/config-locale: running config-locale with frequency once-per-instance
+[   12.412345] cloud-init[611]: 2025-01-02 10:00:02,050 - handlers.py[DEBUG]: finish: modules-config/config-locale: SUCCESS: config-locale ran successfully
+2025-01-02 10:00:03,000 - handlers.py[DEBUG]: start: modules-final/config-scripts-user: running config-scripts-user with frequency once-per-instance
+2025-01-02 10:00:03,100 - handlers.py[DEBUG]: start: modules-final/config-ssh-authkey-fingerprints: running config-ssh-authkey-fingerprints with frequency once-per-instance
+2025-01-02 10:00:06,200 - handlers.py[DEBUG]: finish: modules-final/config-scripts-user: FAIL: running config-scripts-user with frequency once-per-instance
+2025-01-02 10:00:06,300 - handlers.py[DEBUG]: finish: modules-final/config-ssh-authkey-fingerprints: SUCCESS: config-ssh-authkey-fingerprints ran successfully
+2025-01-02 10:00:07,000 - handlers.py[DEBUG]: finish: modules-final/config-phone-home: SUCCESS: config-phone-home ran successfully
+`)
+	timing := ParseCloudInit(content).Timing
+	want := []ModuleTiming{
+		{Name: "ssh", Stage: "init-network", Duration: 420 * time.Millisecond, Result: "SUCCESS"},
+		{Name: "locale", Stage: "config", Duration: 50 * time.Millisecond, Result: "SUCCESS"},
+		{Name: "scripts-user", Stage: "final", Duration: 3200 * time.Millisecond, Result: "FAIL"},
+		{Name: "ssh-authkey-fingerprints", Stage: "final", Duration: 3200 * time.Millisecond, Result: "SUCCESS"},
+	}
+	if !reflect.DeepEqual(timing.Modules, want) {
+		t.Errorf("Modules = %+v, want %+v", timing.Modules, want)
+	}
+	slowest := timing.Slowest(2)
+	if len(slowest) != 2 || slowest[0].Name != "scripts-user" || slowest[1].Name != "ssh-authkey-fingerprints" {
+		t.Errorf("Slowest(2) = %+v", slowest)
+	}
+	if len(timing.Slowest(10)) != len(want) {
+		t.Errorf("Expected Slowest to return every module when asked for more")
+	}
+
+	// The consoles in testdata don't have debug logging.
+	plain, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modules := ParseCloudInit(plain).Timing.Modules; len(modules) != 0 {
+		t.Errorf("Expected no module timings without debug logging, got %+v", modules)
+	}
+}
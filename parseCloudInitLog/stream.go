@@ -0,0 +1,211 @@
+package parseCloudInitLog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrStop can be returned by a StreamCallbacks function to stop parsing.
+// ParseCloudInitStream then returns the data found so far and no error.
+var ErrStop = errors.New("stop parsing")
+
+// StreamCallbacks are called as soon as the serial output shows a piece of
+// data, from the goroutine feeding the parser. Any of them may be nil.
+// Returning an error stops parsing, return ErrStop once the data needed has
+// appeared.
+type StreamCallbacks struct {
+	// Hostname is called once, with the first hostname found.
+	Hostname func(hostname string) error
+	// IP is called for every new address, IPv6 ones with their prefix length.
+	IP func(ip string) error
+	// HostKeys is called with all host keys when the host key block ends.
+	HostKeys func(keys []string) error
+	// LoginPrompt is called once, when the console shows a login prompt.
+	// cloud-init has mostly finished by then, but may still be running the
+	// final modules.
+	LoginPrompt func(hostname string) error
+	// Finished is called once, with the uptime cloud-init reports when it
+	// has run all its stages.
+	Finished func(uptime time.Duration) error
+}
+
+func (cb StreamCallbacks) hostname(hostname string) error {
+	if cb.Hostname == nil {
+		return nil
+	}
+	return cb.Hostname(hostname)
+}
+
+func (cb StreamCallbacks) ip(ip string) error {
+	if cb.IP == nil {
+		return nil
+	}
+	return cb.IP(ip)
+}
+
+func (cb StreamCallbacks) hostKeys(keys []string) error {
+	if cb.HostKeys == nil {
+		return nil
+	}
+	return cb.HostKeys(keys)
+}
+
+func (cb StreamCallbacks) loginPrompt(hostname string) error {
+	if cb.LoginPrompt == nil {
+		return nil
+	}
+	return cb.LoginPrompt(hostname)
+}
+
+func (cb StreamCallbacks) finished(uptime time.Duration) error {
+	if cb.Finished == nil {
+		return nil
+	}
+	return cb.Finished(uptime)
+}
+
+// Parser parses serial output incrementally. Write it the output as it
+// arrives, in chunks of any size, and Close it at the end.
+type Parser struct {
+	cb   StreamCallbacks
+	data CloudInitData
+
+	partial         []byte // output after the last newline
+	inHostKeys      bool
+	currentAuthUser string
+	ciTable         string // title of the ci-info table being parsed
+	loginSeen       bool
+	err             error
+
+	line          int      // number of the line being parsed
+	traceback     []string // frames of the traceback being parsed, nil outside one
+	tracebackLine int      // line of the traceback header
+	errorLine     int      // line of the last error
+
+	moduleStarts map[string]time.Time // start of the module events not finished yet
+}
+
+// NewParser returns a Parser calling cb.
+func NewParser(cb StreamCallbacks) *Parser {
+	return &Parser{
+		cb: cb,
+		data: CloudInitData{
+			IPs:           []string{},
+			HostKeyHashes: []HostKeyHash{},
+			HostKeys:      []string{},
+			SSHKeyData:    map[string]SSHKeyData{},
+			Interfaces:    []NetInterface{},
+			Routes:        []Route{},
+			Errors:        []CloudInitError{},
+			Warnings:      []CloudInitError{},
+		},
+	}
+}
+
+// Write parses the complete lines in b and keeps the rest for the next
+// Write. Lines end in LF, CR LF or a lone CR, and are stripped of escape
+// sequences before parsing. The login prompt has no newline after it, so an
+// incomplete line is checked for it too. Write returns the error of a
+// callback, and keeps returning it once one failed.
+func (p *Parser) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		next := i + 1
+		if p.partial[i] == '\r' {
+			if next == len(p.partial) {
+				// Wait for what follows, CR LF is a single line end.
+				break
+			}
+			if p.partial[next] == '\n' {
+				next++
+			}
+		}
+		line := StripEscapes(string(p.partial[:i]))
+		p.partial = p.partial[next:]
+		if p.err = p.parseLine(line); p.err != nil {
+			return len(b), p.err
+		}
+	}
+	if !p.loginSeen && len(p.partial) > 0 {
+		line := StripEscapes(strings.TrimSuffix(string(p.partial), "\r"))
+		if hostnameRegex.MatchString(line) {
+			p.err = p.parseLine(line)
+		}
+	}
+	return len(b), p.err
+}
+
+// Feed parses the next chunk of serial output, as Write does, for callers
+// that don't need an io.Writer. It returns the error of a callback.
+func (p *Parser) Feed(b []byte) error {
+	_, err := p.Write(b)
+	return err
+}
+
+// Close parses what is left of an incomplete last line.
+func (p *Parser) Close() error {
+	if p.err != nil || len(p.partial) == 0 {
+		return p.err
+	}
+	line := StripEscapes(string(p.partial))
+	p.partial = nil
+	p.err = p.parseLine(line)
+	return p.err
+}
+
+// LoginSeen reports whether the console has shown a login prompt.
+func (p *Parser) LoginSeen() bool {
+	return p.loginSeen
+}
+
+// Data returns what was parsed so far. Fingerprints of host keys without a
+// comment get the hostname of the VM.
+func (p *Parser) Data() CloudInitData {
+	for i := range p.data.HostKeyHashes {
+		if p.data.HostKeyHashes[i].Hostname == "" {
+			p.data.HostKeyHashes[i].Hostname = p.data.Hostname
+		}
+	}
+	return p.data
+}
+
+// ParseCloudInitStream parses serial output from r as it is read, calling
+// cb as data appears. It returns when r is exhausted or a callback returns
+// an error, ErrStop is not passed on.
+func ParseCloudInitStream(r io.Reader, cb StreamCallbacks) (CloudInitData, error) {
+	p := NewParser(cb)
+	_, err := io.Copy(p, r)
+	if err == nil {
+		err = p.Close()
+	}
+	if errors.Is(err, ErrStop) {
+		err = nil
+	}
+	return p.Data(), err
+}
+
+func (p *Parser) setHostname(hostname string) error {
+	if p.data.Hostname != "" {
+		return nil
+	}
+	p.data.Hostname = hostname
+	return p.cb.hostname(hostname)
+}
+
+func (p *Parser) addIP(ip string) error {
+	if contains(p.data.IPs, ip) {
+		return nil
+	}
+	p.data.IPs = append(p.data.IPs, ip)
+	return p.cb.ip(ip)
+}
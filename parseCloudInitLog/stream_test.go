@@ -0,0 +1,142 @@
+package parseCloudInitLog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chunkReader returns at most n bytes per Read, like a serial console.
+type chunkReader struct {
+	b []byte
+	n int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+func TestParseCloudInitStreamMatchesParseCloudInit(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hostnames, ips []string
+	var keys []string
+	var prompt string
+	data, err := ParseCloudInitStream(&chunkReader{content, 7}, StreamCallbacks{
+		Hostname:    func(h string) error { hostnames = append(hostnames, h); return nil },
+		IP:          func(ip string) error { ips = append(ips, ip); return nil },
+		HostKeys:    func(k []string) error { keys = k; return nil },
+		LoginPrompt: func(h string) error { prompt = h; return nil },
+	})
+	if err != nil {
+		t.Fatalf("ParseCloudInitStream gave err: %v", err)
+	}
+
+	want := ParseCloudInit(content)
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("Streamed data differs from ParseCloudInit:\n%+v\n%+v", data, want)
+	}
+	if len(hostnames) != 1 || hostnames[0] != "dtt-debian-11-104" {
+		t.Errorf("Expected the hostname callback once, got %v", hostnames)
+	}
+	if !reflect.DeepEqual(ips, want.IPs) {
+		t.Errorf("Expected an IP callback per address, got %v, want %v", ips, want.IPs)
+	}
+	if len(keys) != len(want.HostKeys) || len(keys) == 0 {
+		t.Errorf("Expected the host keys callback with %d keys, got %d", len(want.HostKeys), len(keys))
+	}
+	if prompt != "dtt-debian-11-104" {
+		t.Errorf("Expected the login prompt callback, got %q", prompt)
+	}
+}
+
+func TestParseCloudInitStreamStop(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-ubuntu-jammy-107-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ParseCloudInitStream(&chunkReader{content, 512}, StreamCallbacks{
+		IP: func(string) error { return ErrStop },
+	})
+	if err != nil {
+		t.Fatalf("Expected ErrStop not to be returned, got %v", err)
+	}
+	if len(data.IPs) != 1 || len(data.HostKeys) != 0 {
+		t.Errorf("Expected parsing to stop at the first IP, got %+v", data)
+	}
+
+	boom := errors.New("boom")
+	if _, err := ParseCloudInitStream(&chunkReader{content, 512}, StreamCallbacks{
+		HostKeys: func([]string) error { return boom },
+	}); !errors.Is(err, boom) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+}
+
+func TestParserLoginPromptWithoutNewline(t *testing.T) {
+	var prompt string
+	p := NewParser(StreamCallbacks{LoginPrompt: func(h string) error { prompt = h; return nil }})
+	p.Write([]byte("Debian GNU/Linux 12 vm ttyS0\r\n\r\nvm log"))
+	if prompt != "" {
+		t.Fatalf("Expected no prompt yet, got %q", prompt)
+	}
+	p.Write([]byte("in: "))
+	if prompt != "vm" || p.Data().Hostname != "vm" {
+		t.Errorf("Expected the unterminated prompt to be seen, got %q, %+v", prompt, p.Data())
+	}
+}
+
+func TestParserFeed(t *testing.T) {
+	content, err := os.ReadFile("testdata/dtt-debian-11-104-cloudinit.serial.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var finished time.Duration
+	p := NewParser(StreamCallbacks{Finished: func(uptime time.Duration) error { finished = uptime; return nil }})
+	for rest := content; len(rest) > 0; {
+		n := min(13, len(rest))
+		if err := p.Feed(rest[:n]); err != nil {
+			t.Fatalf("Feed gave err: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close gave err: %v", err)
+	}
+
+	want := ParseCloudInit(content)
+	if !reflect.DeepEqual(p.Data(), want) {
+		t.Errorf("Fed data differs from ParseCloudInit:\n%+v\n%+v", p.Data(), want)
+	}
+	if !p.LoginSeen() {
+		t.Error("Expected the login prompt to be seen")
+	}
+	if finished == 0 || finished != want.Timing.Finished {
+		t.Errorf("Expected the Finished callback with %s, got %s", want.Timing.Finished, finished)
+	}
+
+	boom := errors.New("boom")
+	p = NewParser(StreamCallbacks{Hostname: func(string) error { return boom }})
+	if err := p.Feed([]byte("vm login: ")); !errors.Is(err, boom) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+	if err := p.Feed([]byte("more\n")); !errors.Is(err, boom) {
+		t.Errorf("Expected Feed to keep returning the callback error, got %v", err)
+	}
+}
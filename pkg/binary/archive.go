@@ -0,0 +1,132 @@
+package binary
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsTarball reports whether path names a gzipped tar, by its extension.
+func IsTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// PackDir writes the directory dir as a gzipped tar to w, with paths
+// relative to dir, keeping modes and symlinks. Other special files are left
+// out.
+func PackDir(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !info.IsDir() && !info.Mode().IsRegular():
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// The VM user owns what it extracts.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("packing %s: %w", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// CheckEntrypoint checks that entrypoint, a path relative to the top of the
+// directory or gzipped tar at p, is an executable file in it.
+func CheckEntrypoint(p, entrypoint string) error {
+	if entrypoint == "" {
+		return errors.New("an entrypoint is required")
+	}
+	clean := path.Clean(strings.TrimPrefix(entrypoint, "./"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("entrypoint %q is not a path inside %s", entrypoint, p)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ValidateBinary(filepath.Join(p, filepath.FromSlash(clean)))
+	}
+	if !IsTarball(p) {
+		return fmt.Errorf("%s is not a directory or a .tar.gz", p)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", p, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("entrypoint %q not found in %s", entrypoint, p)
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		if path.Clean(strings.TrimPrefix(hdr.Name, "./")) != clean {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("entrypoint %q in %s is not a regular file", entrypoint, p)
+		}
+		if hdr.Mode&0o111 == 0 {
+			return fmt.Errorf("entrypoint %q in %s is not executable", entrypoint, p)
+		}
+		return nil
+	}
+}
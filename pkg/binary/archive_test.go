@@ -0,0 +1,95 @@
+package binary
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProgram(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "server"), []byte("#!/bin/sh\necho hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server.yaml"), []byte("port: 80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin/server", filepath.Join(dir, "run")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPackDir(t *testing.T) {
+	dir := writeProgram(t)
+	var buf bytes.Buffer
+	if err := PackDir(dir, &buf); err != nil {
+		t.Fatalf("PackDir() gave err: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*tar.Header{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = hdr
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected bin/, bin/server, run and server.yaml, got %v", got)
+	}
+	if hdr := got["bin/"]; hdr == nil || hdr.Typeflag != tar.TypeDir {
+		t.Errorf("Expected bin/ as a directory, got %+v", hdr)
+	}
+	if hdr := got["bin/server"]; hdr == nil || hdr.Mode&0o111 == 0 || hdr.Uname != "" {
+		t.Errorf("Expected bin/server executable without an owner, got %+v", hdr)
+	}
+	if hdr := got["run"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "bin/server" {
+		t.Errorf("Expected run as a symlink to bin/server, got %+v", hdr)
+	}
+}
+
+func TestCheckEntrypoint(t *testing.T) {
+	dir := writeProgram(t)
+	tarball := filepath.Join(t.TempDir(), "program.tar.gz")
+	f, err := os.Create(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PackDir(dir, f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, p := range []string{dir, tarball} {
+		if err := CheckEntrypoint(p, "bin/server"); err != nil {
+			t.Errorf("CheckEntrypoint(%s, bin/server) gave err: %v", p, err)
+		}
+		if err := CheckEntrypoint(p, "./bin/server"); err != nil {
+			t.Errorf("CheckEntrypoint(%s, ./bin/server) gave err: %v", p, err)
+		}
+		for _, entrypoint := range []string{"", "server.yaml", "missing", "../bin/server", "/bin/sh"} {
+			if err := CheckEntrypoint(p, entrypoint); err == nil {
+				t.Errorf("Expected an error for CheckEntrypoint(%s, %q)", p, entrypoint)
+			}
+		}
+	}
+	if err := CheckEntrypoint(filepath.Join(dir, "server.yaml"), "server"); err == nil {
+		t.Error("Expected an error for a file that is not a directory or tarball")
+	}
+}
@@ -3,7 +3,6 @@ package binary
 import (
 	"os"
 	"testing"
-	"io"
 )
 
 func TestGetBinaryInfo(t *testing.T) {
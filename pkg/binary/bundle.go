@@ -0,0 +1,212 @@
+package binary
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Bundle is a set of files, such as a binary with its configuration and
+// data, to lay out on a VM. It is described by a YAML manifest:
+//
+//	files:
+//	  - source: ./server
+//	    destination: /usr/local/bin/server
+//	    mode: "0755"
+//	  - source: ./server.yaml
+//	    destination: /etc/server/server.yaml
+//	    owner: server
+//	    group: server
+//	    mode: "0640"
+//	run: /usr/local/bin/server --config /etc/server/server.yaml
+type Bundle struct {
+	Files []BundleFile `yaml:"files"`
+	// Run is the command to run once the files are in place, if any.
+	Run string `yaml:"run,omitempty"`
+}
+
+// BundleFile is a local file of a Bundle and where it goes on the VM.
+type BundleFile struct {
+	Source      string `yaml:"source"`          // relative to the manifest
+	Destination string `yaml:"destination"`     // absolute
+	Owner       string `yaml:"owner,omitempty"` // root unless set
+	Group       string `yaml:"group,omitempty"` // root unless set
+	Mode        string `yaml:"mode,omitempty"`  // octal, e.g. "0755", the mode of the source unless set
+	// SHA256 is checked against the source when set, to catch a stale build.
+	SHA256 string `yaml:"sha256,omitempty"`
+}
+
+// LoadBundle reads and checks the bundle manifest at path. Relative sources
+// are resolved against the directory of the manifest.
+func LoadBundle(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ParseBundle(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i, f := range b.Files {
+		if f.Source != "" && !filepath.IsAbs(f.Source) {
+			b.Files[i].Source = filepath.Join(dir, f.Source)
+		}
+	}
+	if err := b.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// ParseBundle parses a YAML bundle manifest. Unknown fields are errors so
+// typos don't go unnoticed. The sources aren't looked at, see Validate.
+func ParseBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("parsing bundle: %w", err)
+	}
+	return &b, nil
+}
+
+// Validate checks the manifest and that every source is a regular file
+// matching its SHA256, reporting every problem at once.
+func (b *Bundle) Validate() error {
+	var errs []error
+	if len(b.Files) == 0 {
+		errs = append(errs, errors.New("bundle has no files"))
+	}
+	destinations := map[string]bool{}
+	for i, f := range b.Files {
+		where := fmt.Sprintf("file %d", i+1)
+		if f.Source != "" {
+			where = fmt.Sprintf("file %s", f.Source)
+		}
+		if f.Source == "" || f.Destination == "" {
+			errs = append(errs, fmt.Errorf("%s: a source and a destination are required", where))
+			continue
+		}
+		if !path.IsAbs(f.Destination) {
+			errs = append(errs, fmt.Errorf("%s: destination %q is not absolute", where, f.Destination))
+		} else if dest := path.Clean(f.Destination); destinations[dest] {
+			errs = append(errs, fmt.Errorf("%s: destination %q is used more than once", where, f.Destination))
+		} else {
+			destinations[dest] = true
+		}
+		if _, err := f.mode(0); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", where, err))
+		}
+
+		info, err := os.Stat(f.Source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			errs = append(errs, fmt.Errorf("%s: not a regular file", where))
+			continue
+		}
+		if f.SHA256 != "" {
+			_, sum, err := calculateHashes(f.Source)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			} else if !strings.EqualFold(sum, f.SHA256) {
+				errs = append(errs, fmt.Errorf("%s: SHA256 hash mismatch: expected %s, got %s", where, f.SHA256, sum))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mode returns the mode to give the file, fallback when none is set.
+func (f BundleFile) mode(fallback os.FileMode) (os.FileMode, error) {
+	if f.Mode == "" {
+		return fallback, nil
+	}
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q, want octal like \"0644\"", f.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func orRoot(s string) string {
+	if s == "" {
+		return "root"
+	}
+	return s
+}
+
+// Pack writes the files as a gzipped tar to w, with paths relative to / and
+// the owners and modes of the manifest, for extracting as root with
+// tar -xzpf - -C /. Validate the bundle first.
+func (b *Bundle) Pack(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range b.Files {
+		if err := f.pack(tw); err != nil {
+			return fmt.Errorf("packing %s: %w", f.Source, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (f BundleFile) pack(tw *tar.Writer) error {
+	file, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	mode, err := f.mode(info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     strings.TrimPrefix(path.Clean(f.Destination), "/"),
+		Mode:     int64(mode),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Uname:    orRoot(f.Owner),
+		Gname:    orRoot(f.Group),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// VerifyCommand returns a shell command checking the SHA256 of every file
+// laid out on the VM, failing when one differs.
+func (b *Bundle) VerifyCommand() (string, error) {
+	var sums strings.Builder
+	for _, f := range b.Files {
+		_, sum, err := calculateHashes(f.Source)
+		if err != nil {
+			return "", fmt.Errorf("hashing %s: %w", f.Source, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, path.Clean(f.Destination))
+	}
+	return fmt.Sprintf("sha256sum --quiet -c - <<'EOF'\n%sEOF", sums.String()), nil
+}
@@ -0,0 +1,115 @@
+package binary
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeBundle(t *testing.T, manifest string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "server"), []byte("#!/bin/sh\necho hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server.yaml"), []byte("port: 80\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bundle.yaml")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBundle(t *testing.T) {
+	path := writeBundle(t, `
+files:
+  - source: server
+    destination: /usr/local/bin/server
+  - source: ./server.yaml
+    destination: /etc/server/server.yaml
+    owner: server
+    group: adm
+    mode: "0640"
+run: /usr/local/bin/server
+`)
+	b, err := LoadBundle(path)
+	if err != nil {
+		t.Fatalf("LoadBundle gave err: %v", err)
+	}
+	if b.Run != "/usr/local/bin/server" || len(b.Files) != 2 {
+		t.Fatalf("Unexpected bundle %+v", b)
+	}
+	if want := filepath.Join(filepath.Dir(path), "server.yaml"); b.Files[1].Source != want {
+		t.Errorf("Expected the source relative to the manifest, got %q, want %q", b.Files[1].Source, want)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Pack(&buf); err != nil {
+		t.Fatalf("Pack gave err: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var got []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name+" "+hdr.Uname+":"+hdr.Gname+" "+os.FileMode(hdr.Mode).String())
+	}
+	want := []string{
+		"usr/local/bin/server root:root -rwxr-xr-x",
+		"etc/server/server.yaml server:adm -rw-r-----",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Packed %q, want %q", got, want)
+	}
+
+	verify, err := b.VerifyCommand()
+	if err != nil {
+		t.Fatalf("VerifyCommand gave err: %v", err)
+	}
+	info, _ := GetBinaryInfo(b.Files[0].Source)
+	if !strings.Contains(verify, info.SHA256Hash+"  /usr/local/bin/server\n") || !strings.HasPrefix(verify, "sha256sum") {
+		t.Errorf("Unexpected verify command %q", verify)
+	}
+}
+
+func TestLoadBundleReportsEveryProblem(t *testing.T) {
+	path := writeBundle(t, `
+files:
+  - source: server
+    destination: usr/local/bin/server
+    sha256: "00"
+  - source: missing
+    destination: /etc/missing
+  - source: server.yaml
+    destination: /etc/missing/
+    mode: "999"
+`)
+	_, err := LoadBundle(path)
+	if err == nil {
+		t.Fatal("Expected LoadBundle to fail")
+	}
+	for _, want := range []string{"not absolute", "SHA256 hash mismatch", "no such file", "used more than once", "invalid mode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+
+	if _, err := ParseBundle([]byte("files: []\nrun_command: x\n")); err == nil {
+		t.Error("Expected unknown fields to be rejected")
+	}
+}
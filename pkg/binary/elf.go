@@ -0,0 +1,146 @@
+package binary
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrNotELF is returned by InspectELF for files that aren't ELF binaries,
+// such as scripts.
+var ErrNotELF = errors.New("not an ELF binary")
+
+// ELFInfo is what InspectELF found out about a binary.
+type ELFInfo struct {
+	// Arch is the architecture by its GOARCH name, such as "amd64" or
+	// "arm64", or the ELF machine for others.
+	Arch string
+	// Static is set for binaries without a dynamic loader or shared
+	// libraries.
+	Static bool
+	// Interpreter is the dynamic loader, such as
+	// /lib64/ld-linux-x86-64.so.2.
+	Interpreter string
+	// Libraries are the shared libraries the binary needs.
+	Libraries []string
+	// GLIBC is the newest glibc symbol version the binary needs, such as
+	// "2.34", "" when it needs none.
+	GLIBC string
+}
+
+// elfArches maps ELF machines onto GOARCH names.
+var elfArches = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_386:     "386",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390x",
+}
+
+// InspectELF reads the architecture, linking and library requirements of
+// the ELF binary at p.
+func InspectELF(p string) (*ELFInfo, error) {
+	f, err := elf.Open(p)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotELF)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &ELFInfo{Arch: elfArches[f.Machine]}
+	if info.Arch == "" {
+		info.Arch = strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+	if f.Machine == elf.EM_PPC64 && f.Data == elf.ELFDATA2LSB {
+		info.Arch = "ppc64le"
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return nil, fmt.Errorf("reading the interpreter of %s: %w", p, err)
+		}
+		info.Interpreter = strings.TrimRight(string(data), "\x00")
+	}
+	// Static binaries have no dynamic section to read these from.
+	info.Libraries, _ = f.ImportedLibraries()
+	symbols, _ := f.ImportedSymbols()
+	for _, sym := range symbols {
+		if v, ok := strings.CutPrefix(sym.Version, "GLIBC_"); ok && compareVersions(v, info.GLIBC) > 0 {
+			info.GLIBC = v
+		}
+	}
+	info.Static = info.Interpreter == "" && len(info.Libraries) == 0
+	return info, nil
+}
+
+// Musl reports whether the binary is linked against musl rather than glibc.
+func (i *ELFInfo) Musl() bool {
+	return strings.HasPrefix(path.Base(i.Interpreter), "ld-musl")
+}
+
+// CheckTarget checks that the binary can run on a machine of arch with glibc
+// version glibc, "" when that is unknown, reporting why it can't.
+func (i *ELFInfo) CheckTarget(arch, glibc string) error {
+	// amd64 kernels run 386 binaries too.
+	if arch != "" && i.Arch != arch && !(i.Arch == "386" && arch == "amd64") {
+		return fmt.Errorf("the binary is built for %s, the VM is %s", i.Arch, arch)
+	}
+	if i.Static || glibc == "" {
+		return nil
+	}
+	if i.Musl() {
+		return fmt.Errorf("the binary is linked against musl (%s), the VM has glibc %s", i.Interpreter, glibc)
+	}
+	if i.GLIBC != "" && compareVersions(i.GLIBC, glibc) > 0 {
+		return fmt.Errorf("the binary needs glibc %s, the VM has glibc %s", i.GLIBC, glibc)
+	}
+	return nil
+}
+
+// compareVersions compares dotted versions such as "2.34", returning -1, 0
+// or 1. "" is older than any version.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	if a == "" {
+		as = nil
+	}
+	if b == "" {
+		bs = nil
+	}
+	for n := 0; n < len(as) || n < len(bs); n++ {
+		var x, y int
+		if n < len(as) {
+			x, _ = strconv.Atoi(as[n])
+		}
+		if n < len(bs) {
+			y, _ = strconv.Atoi(bs[n])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	if len(as) != len(bs) {
+		// "2" and "2.0" are the same, "" and "0" aren't.
+		if len(as) == 0 {
+			return -1
+		}
+		if len(bs) == 0 {
+			return 1
+		}
+	}
+	return 0
+}
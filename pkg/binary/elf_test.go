@@ -0,0 +1,92 @@
+package binary
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestInspectELF(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs ELF binaries to inspect")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := InspectELF(self)
+	if err != nil {
+		t.Fatalf("InspectELF(%s) gave err: %v", self, err)
+	}
+	if info.Arch != runtime.GOARCH {
+		t.Errorf("Expected arch %s, got %s", runtime.GOARCH, info.Arch)
+	}
+	if err := info.CheckTarget(runtime.GOARCH, ""); err != nil {
+		t.Errorf("Expected the test binary to run here, got %v", err)
+	}
+
+	script := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InspectELF(script); !errors.Is(err, ErrNotELF) {
+		t.Errorf("Expected ErrNotELF for a script, got %v", err)
+	}
+
+	ls, err := InspectELF("/bin/ls")
+	if err != nil {
+		t.Skipf("no /bin/ls to inspect: %v", err)
+	}
+	if ls.Static || len(ls.Libraries) == 0 || ls.Interpreter == "" {
+		t.Errorf("Expected /bin/ls to be dynamically linked, got %+v", ls)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	glibc := &ELFInfo{Arch: "amd64", Interpreter: "/lib64/ld-linux-x86-64.so.2", Libraries: []string{"libc.so.6"}, GLIBC: "2.34"}
+	musl := &ELFInfo{Arch: "amd64", Interpreter: "/lib/ld-musl-x86_64.so.1", Libraries: []string{"libc.musl-x86_64.so.1"}}
+	static := &ELFInfo{Arch: "arm64", Static: true}
+	i386 := &ELFInfo{Arch: "386", Static: true}
+
+	for _, tc := range []struct {
+		info        *ELFInfo
+		arch, glibc string
+		ok          bool
+	}{
+		{glibc, "amd64", "2.39", true},
+		{glibc, "amd64", "2.34", true},
+		{glibc, "amd64", "2.31", false},
+		{glibc, "amd64", "", true},
+		{glibc, "arm64", "2.39", false},
+		{musl, "amd64", "2.39", false},
+		{musl, "amd64", "", true},
+		{static, "arm64", "2.31", true},
+		{static, "amd64", "2.31", false},
+		{i386, "amd64", "2.31", true},
+	} {
+		err := tc.info.CheckTarget(tc.arch, tc.glibc)
+		if (err == nil) != tc.ok {
+			t.Errorf("CheckTarget(%s, %q) for %+v gave %v, expected ok %v", tc.arch, tc.glibc, tc.info, err, tc.ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"2.34", "2.31", 1},
+		{"2.9", "2.31", -1},
+		{"2.31", "2.31", 0},
+		{"2", "2.0", 0},
+		{"", "2.2.5", -1},
+		{"2.2.5", "", 1},
+	} {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
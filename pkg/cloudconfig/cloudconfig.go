@@ -1,77 +1,218 @@
 package cloudconfig
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"path"
+	"regexp"
 	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
 )
 
 // CloudInitConfig represents cloud-init user-data configuration
 type CloudInitConfig struct {
-	Hostname    string
-	Username    string
-	Password    string
-	PublicKeys  []string
-	Packages    []string
-	RunCommands []string
-	Environment map[string]string
+	Hostname        string    `yaml:"hostname,omitempty"`
+	Timezone        string    `yaml:"timezone,omitempty"` // like "Europe/Amsterdam"
+	Locale          string    `yaml:"locale,omitempty"`   // like "en_US.UTF-8"
+	Users           []User    `yaml:"users,omitempty"`
+	ChPasswd        *ChPasswd `yaml:"chpasswd,omitempty"`
+	SSHPasswordAuth *bool     `yaml:"ssh_pwauth,omitempty"` // nil keeps the default of the image
+	NTP             *NTP      `yaml:"ntp,omitempty"`
+	PackageUpdate   bool      `yaml:"package_update,omitempty"`
+	PackageUpgrade  bool      `yaml:"package_upgrade,omitempty"`
+	Packages        []string  `yaml:"packages,omitempty"`
+	RunCommands     []string  `yaml:"runcmd,omitempty"`
+	WriteFiles      []File    `yaml:"write_files,omitempty"`
+
+	Growpart  *Growpart            `yaml:"growpart,omitempty"`
+	DiskSetup map[string]DiskSetup `yaml:"disk_setup,omitempty"` // by device
+	FSSetup   []FSSetup            `yaml:"fs_setup,omitempty"`
+	Mounts    [][]string           `yaml:"mounts,omitempty"` // fstab entries: device, mount point, type, options, dump, pass
 }
 
-// Generate generates cloud-init user-data YAML
-func (c *CloudInitConfig) Generate() string {
-	var sb strings.Builder
+// Growpart grows partitions to the size of their disk on boot.
+type Growpart struct {
+	Mode    string   `yaml:"mode"` // "auto", "growpart" or "off"
+	Devices []string `yaml:"devices"`
+}
 
-	sb.WriteString("#cloud-config\n")
+// DiskSetup partitions a disk.
+type DiskSetup struct {
+	TableType string `yaml:"table_type"` // "gpt" or "mbr"
+	Layout    bool   `yaml:"layout"`     // one partition over the whole disk
+	Overwrite bool   `yaml:"overwrite"`  // also when the disk has a partition table already
+}
 
-	if c.Hostname != "" {
-		sb.WriteString(fmt.Sprintf("hostname: %s\n", c.Hostname))
-	}
+// FSSetup makes a filesystem.
+type FSSetup struct {
+	Label      string `yaml:"label,omitempty"`
+	Filesystem string `yaml:"filesystem"`
+	Device     string `yaml:"device"`
+	Partition  string `yaml:"partition,omitempty"` // like "auto", "any", "none" or "1"
+	Overwrite  bool   `yaml:"overwrite,omitempty"`
+}
+
+// ChPasswd sets the passwords of existing users.
+type ChPasswd struct {
+	Users  []Password `yaml:"users"`
+	Expire *bool      `yaml:"expire,omitempty"` // nil expires the passwords, cloud-init's default
+}
 
-	if c.Username != "" {
-		sb.WriteString("users:\n")
-		sb.WriteString(fmt.Sprintf("  - name: %s\n", c.Username))
+// Password is the password of one user for chpasswd.
+type Password struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	Type     string `yaml:"type"` // "text" or "hash"
+}
 
-		if c.Password != "" {
-			sb.WriteString(fmt.Sprintf("    passwd: %s\n", c.Password))
-		}
+// NTP configures the time servers.
+type NTP struct {
+	Enabled bool     `yaml:"enabled"`
+	Servers []string `yaml:"servers,omitempty"`
+}
+
+// User is a user cloud-init creates.
+type User struct {
+	Name              string   `yaml:"name"`
+	Password          string   `yaml:"passwd,omitempty"` // a crypt(3) hash
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	Sudo              []string `yaml:"sudo,omitempty"`
+	Shell             string   `yaml:"shell,omitempty"`
+}
+
+// File is a file cloud-init writes on first boot.
+type File struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Encoding    string `yaml:"encoding,omitempty"`    // "b64" for base64 encoded content
+	Owner       string `yaml:"owner,omitempty"`       // user:group, cloud-init's default root:root when empty
+	Permissions string `yaml:"permissions,omitempty"` // octal, like "0644", cloud-init's default when empty
+}
+
+var (
+	hostnameRe    = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+	permissionsRe = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+	ownerRe       = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*(:[a-z_][a-z0-9_.-]*)?$`)
+	timezoneRe    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+	localeRe      = regexp.MustCompile(`^([a-z]{2,3}(_[A-Z]{2})?|C)(\.[A-Za-z0-9-]+)?(@[a-z]+)?$`)
+	fsLabelRe     = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+)
 
-		if len(c.PublicKeys) > 0 {
-			sb.WriteString("    ssh_authorized_keys:\n")
-			for _, key := range c.PublicKeys {
-				sb.WriteString(fmt.Sprintf("      - %s\n", key))
+// Validate returns the problems of the configuration that cloud-init would
+// reject or misread, joined, or nil.
+func (c *CloudInitConfig) Validate() error {
+	var errs []error
+	if c.Hostname != "" && !hostnameRe.MatchString(c.Hostname) {
+		errs = append(errs, fmt.Errorf("hostname %q is not a valid host name", c.Hostname))
+	}
+	if c.Timezone != "" && !timezoneRe.MatchString(c.Timezone) {
+		errs = append(errs, fmt.Errorf("timezone %q is not a zone like Europe/Amsterdam", c.Timezone))
+	}
+	if c.Locale != "" && !localeRe.MatchString(c.Locale) {
+		errs = append(errs, fmt.Errorf("locale %q is not a locale like en_US.UTF-8", c.Locale))
+	}
+	if c.ChPasswd != nil {
+		for _, p := range c.ChPasswd.Users {
+			switch {
+			case p.Name == "":
+				errs = append(errs, fmt.Errorf("chpasswd has a password without a user"))
+			case p.Type != "text" && p.Type != "hash":
+				errs = append(errs, fmt.Errorf("password type %q of %s is not text or hash", p.Type, p.Name))
+			case p.Type == "hash" && !strings.HasPrefix(p.Password, "$"):
+				errs = append(errs, fmt.Errorf("password of %s is not a crypt(3) hash like $6$...", p.Name))
 			}
 		}
-
-		sb.WriteString("    sudo: ['ALL=(ALL) NOPASSWD:ALL']\n")
-		sb.WriteString("    shell: /bin/bash\n")
 	}
-
-	if len(c.Packages) > 0 {
-		sb.WriteString("packages:\n")
-		for _, pkg := range c.Packages {
-			sb.WriteString(fmt.Sprintf("  - %s\n", pkg))
+	if c.NTP != nil {
+		for _, server := range c.NTP.Servers {
+			if server == "" || strings.ContainsAny(server, " \t\n/") {
+				errs = append(errs, fmt.Errorf("NTP server %q is not a host name or address", server))
+			}
 		}
 	}
-
-	if len(c.RunCommands) > 0 {
-		sb.WriteString("runcmd:\n")
-		for _, cmd := range c.RunCommands {
-			sb.WriteString(fmt.Sprintf("  - %s\n", cmd))
+	for i, u := range c.Users {
+		if u.Name == "" {
+			errs = append(errs, fmt.Errorf("user %d has no name", i))
 		}
 	}
-
-	if len(c.Environment) > 0 {
-		sb.WriteString("write_files:\n")
-		for key, value := range c.Environment {
-			sb.WriteString(fmt.Sprintf("  - path: /etc/environment.d/%s.conf\n", key))
-			sb.WriteString("    content: |\n")
-			lines := strings.Split(value, "\n")
-			for _, line := range lines {
-				sb.WriteString(fmt.Sprintf("      %s\n", line))
-			}
+	for _, p := range c.Packages {
+		if strings.TrimSpace(p) == "" || strings.ContainsAny(p, " \t\n") {
+			errs = append(errs, fmt.Errorf("package %q is not a package name", p))
+		}
+	}
+	for i, cmd := range c.RunCommands {
+		if strings.TrimSpace(cmd) == "" {
+			errs = append(errs, fmt.Errorf("run command %d is empty", i))
+		}
+	}
+	for _, f := range c.WriteFiles {
+		if !path.IsAbs(f.Path) {
+			errs = append(errs, fmt.Errorf("file path %q is not absolute", f.Path))
+		}
+		if f.Permissions != "" && !permissionsRe.MatchString(f.Permissions) {
+			errs = append(errs, fmt.Errorf("permissions %q of %s are not octal like 0644", f.Permissions, f.Path))
+		}
+		if f.Owner != "" && !ownerRe.MatchString(f.Owner) {
+			errs = append(errs, fmt.Errorf("owner %q of %s is not user or user:group", f.Owner, f.Path))
+		}
+		if f.Encoding != "" && f.Encoding != "b64" {
+			errs = append(errs, fmt.Errorf("encoding %q of %s is not b64", f.Encoding, f.Path))
+		}
+	}
+	if c.Growpart != nil {
+		switch c.Growpart.Mode {
+		case "auto", "growpart", "off":
+		default:
+			errs = append(errs, fmt.Errorf("growpart mode %q is not auto, growpart or off", c.Growpart.Mode))
+		}
+	}
+	for device, d := range c.DiskSetup {
+		if !path.IsAbs(device) {
+			errs = append(errs, fmt.Errorf("disk_setup device %q is not a path like /dev/sdb", device))
+		}
+		if d.TableType != "gpt" && d.TableType != "mbr" {
+			errs = append(errs, fmt.Errorf("partition table %q of %s is not gpt or mbr", d.TableType, device))
+		}
+	}
+	for _, fs := range c.FSSetup {
+		if !path.IsAbs(fs.Device) {
+			errs = append(errs, fmt.Errorf("fs_setup device %q is not a path like /dev/sdb", fs.Device))
+		}
+		if fs.Filesystem == "" {
+			errs = append(errs, fmt.Errorf("fs_setup of %s has no filesystem", fs.Device))
+		}
+		if fs.Label != "" && !fsLabelRe.MatchString(fs.Label) {
+			errs = append(errs, fmt.Errorf("filesystem label %q of %s is not up to 16 letters, digits, - or _", fs.Label, fs.Device))
+		}
+	}
+	for _, m := range c.Mounts {
+		if len(m) < 2 || m[0] == "" || !path.IsAbs(m[1]) {
+			errs = append(errs, fmt.Errorf("mount %q has no device and absolute mount point", m))
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	return sb.String()
+// Generate validates the configuration and returns it as cloud-init
+// user-data YAML.
+func (c *CloudInitConfig) Generate() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("#cloud-config\n")
+	enc := yaml.NewEncoder(&sb)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(sb.String(), "{}\n"), nil
 }
 
 // Builder provides a fluent interface for building cloud-init configurations
@@ -81,14 +222,15 @@ type Builder struct {
 
 // NewBuilder creates a new cloud-init configuration builder
 func NewBuilder() *Builder {
-	return &Builder{
-		config: &CloudInitConfig{
-			PublicKeys:  []string{},
-			Packages:    []string{},
-			RunCommands: []string{},
-			Environment: make(map[string]string),
-		},
+	return &Builder{config: &CloudInitConfig{}}
+}
+
+// user returns the default user, the first one, creating it when needed.
+func (b *Builder) user() *User {
+	if len(b.config.Users) == 0 {
+		b.config.Users = []User{{Sudo: []string{"ALL=(ALL) NOPASSWD:ALL"}, Shell: "/bin/bash"}}
 	}
+	return &b.config.Users[0]
 }
 
 // WithHostname sets the hostname
@@ -97,21 +239,89 @@ func (b *Builder) WithHostname(hostname string) *Builder {
 	return b
 }
 
-// WithUsername sets the default user
+// WithUsername sets the default user, who may sudo without a password
 func (b *Builder) WithUsername(username string) *Builder {
-	b.config.Username = username
+	b.user().Name = username
 	return b
 }
 
-// WithPassword sets the user password
+// WithPassword sets the password hash of the default user
 func (b *Builder) WithPassword(password string) *Builder {
-	b.config.Password = password
+	b.user().Password = password
 	return b
 }
 
-// WithPublicKey adds a public SSH key
+// WithPublicKey adds a public SSH key of the default user
 func (b *Builder) WithPublicKey(key string) *Builder {
-	b.config.PublicKeys = append(b.config.PublicKeys, key)
+	u := b.user()
+	u.SSHAuthorizedKeys = append(u.SSHAuthorizedKeys, key)
+	return b
+}
+
+// WithTimezone sets the timezone, like "Europe/Amsterdam"
+func (b *Builder) WithTimezone(timezone string) *Builder {
+	b.config.Timezone = timezone
+	return b
+}
+
+// WithLocale sets the locale, like "en_US.UTF-8"
+func (b *Builder) WithLocale(locale string) *Builder {
+	b.config.Locale = locale
+	return b
+}
+
+// WithSSHPasswordAuth enables or disables logging in to SSH with a password
+func (b *Builder) WithSSHPasswordAuth(enabled bool) *Builder {
+	b.config.SSHPasswordAuth = &enabled
+	return b
+}
+
+// WithPlainPassword sets the password of user with chpasswd
+func (b *Builder) WithPlainPassword(user, password string) *Builder {
+	return b.chpasswd(Password{Name: user, Password: password, Type: "text"})
+}
+
+// WithHashedPassword sets the password of user to a crypt(3) hash with chpasswd
+func (b *Builder) WithHashedPassword(user, hash string) *Builder {
+	return b.chpasswd(Password{Name: user, Password: hash, Type: "hash"})
+}
+
+// WithPasswordExpiry sets whether the chpasswd passwords must be changed on
+// first login
+func (b *Builder) WithPasswordExpiry(expire bool) *Builder {
+	if b.config.ChPasswd == nil {
+		b.config.ChPasswd = &ChPasswd{}
+	}
+	b.config.ChPasswd.Expire = &expire
+	return b
+}
+
+func (b *Builder) chpasswd(p Password) *Builder {
+	if b.config.ChPasswd == nil {
+		b.config.ChPasswd = &ChPasswd{}
+	}
+	b.config.ChPasswd.Users = append(b.config.ChPasswd.Users, p)
+	return b
+}
+
+// WithNTPServer enables NTP and adds a server to sync the time with
+func (b *Builder) WithNTPServer(server string) *Builder {
+	if b.config.NTP == nil {
+		b.config.NTP = &NTP{Enabled: true}
+	}
+	b.config.NTP.Servers = append(b.config.NTP.Servers, server)
+	return b
+}
+
+// WithPackageUpdate updates the package lists on first boot
+func (b *Builder) WithPackageUpdate() *Builder {
+	b.config.PackageUpdate = true
+	return b
+}
+
+// WithPackageUpgrade upgrades the installed packages on first boot
+func (b *Builder) WithPackageUpgrade() *Builder {
+	b.config.PackageUpgrade = true
 	return b
 }
 
@@ -127,9 +337,39 @@ func (b *Builder) WithRunCommand(cmd string) *Builder {
 	return b
 }
 
-// WithEnvironment adds an environment variable configuration
+// WithEnvironment writes value to /etc/environment.d/<key>.conf
 func (b *Builder) WithEnvironment(key, value string) *Builder {
-	b.config.Environment[key] = value
+	return b.WithFile(File{Path: fmt.Sprintf("/etc/environment.d/%s.conf", key), Content: value})
+}
+
+// WithFile adds a file to write during boot. Content that is not UTF-8 is
+// base64 encoded, as YAML can't hold it.
+func (b *Builder) WithFile(f File) *Builder {
+	if f.Encoding == "" && !utf8.ValidString(f.Content) {
+		f.Content = base64.StdEncoding.EncodeToString([]byte(f.Content))
+		f.Encoding = "b64"
+	}
+	b.config.WriteFiles = append(b.config.WriteFiles, f)
+	return b
+}
+
+// WithGrowpart grows the partitions of devices, like "/", to their disk
+func (b *Builder) WithGrowpart(devices ...string) *Builder {
+	b.config.Growpart = &Growpart{Mode: "auto", Devices: devices}
+	return b
+}
+
+// WithDataDisk gives device one partition with a filesystem labeled label,
+// and mounts it on mountPoint. A disk that has a partition table already is
+// left as it is, so its data survives cloud-init running again.
+func (b *Builder) WithDataDisk(device, filesystem, label, mountPoint string) *Builder {
+	if b.config.DiskSetup == nil {
+		b.config.DiskSetup = map[string]DiskSetup{}
+	}
+	b.config.DiskSetup[device] = DiskSetup{TableType: "gpt", Layout: true}
+	b.config.FSSetup = append(b.config.FSSetup, FSSetup{Label: label, Filesystem: filesystem, Device: device, Partition: "auto"})
+	// nofail keeps the VM booting when the disk is gone.
+	b.config.Mounts = append(b.config.Mounts, []string{"LABEL=" + label, mountPoint, filesystem, "defaults,nofail", "0", "2"})
 	return b
 }
 
@@ -137,4 +377,3 @@ func (b *Builder) WithEnvironment(key, value string) *Builder {
 func (b *Builder) Build() *CloudInitConfig {
 	return b.config
 }
-
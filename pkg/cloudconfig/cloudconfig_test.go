@@ -1,17 +1,23 @@
 package cloudconfig
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestGenerateBasic(t *testing.T) {
 	config := &CloudInitConfig{
 		Hostname: "test-vm",
-		Username: "ubuntu",
+		Users:    []User{{Name: "ubuntu"}},
 	}
 
-	output := config.Generate()
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
 
 	if !strings.Contains(output, "#cloud-config") {
 		t.Error("Expected cloud-config header")
@@ -31,7 +37,10 @@ func TestGenerateWithPackages(t *testing.T) {
 		Packages: []string{"curl", "wget", "git"},
 	}
 
-	output := config.Generate()
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
 
 	if !strings.Contains(output, "packages:") {
 		t.Error("Expected packages section")
@@ -50,7 +59,10 @@ func TestGenerateWithRunCommands(t *testing.T) {
 		},
 	}
 
-	output := config.Generate()
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
 
 	if !strings.Contains(output, "runcmd:") {
 		t.Error("Expected runcmd section")
@@ -70,7 +82,10 @@ func TestBuilder(t *testing.T) {
 		WithRunCommand("echo 'Hello World'").
 		Build()
 
-	output := config.Generate()
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
 
 	if !strings.Contains(output, "hostname: my-vm") {
 		t.Error("Expected hostname from builder")
@@ -91,9 +106,175 @@ func TestBuilder(t *testing.T) {
 
 func TestBuilderEmptyConfig(t *testing.T) {
 	config := NewBuilder().Build()
-	output := config.Generate()
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
 
 	if !strings.Contains(output, "#cloud-config") {
 		t.Error("Expected cloud-config header even for empty config")
 	}
-}
\ No newline at end of file
+}
+
+func TestGenerateQuoting(t *testing.T) {
+	config := NewBuilder().
+		WithUsername("dtt").
+		WithPublicKey("ssh-ed25519 AAAA dtt@host").
+		WithRunCommand("echo a: b # not a comment").
+		WithRunCommand("[ -f /x ] && rm /x").
+		WithFile(File{Path: "/etc/motd", Content: "  indented\nsecond: line\n", Owner: "www-data:www-data", Permissions: "0640"}).
+		WithFile(File{Path: "/opt/blob", Content: "\xff\x00"}).
+		Build()
+
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
+
+	var got CloudInitConfig
+	if err := yaml.Unmarshal([]byte(output), &got); err != nil {
+		t.Fatalf("Generated YAML does not parse: %v\n%s", err, output)
+	}
+	if !reflect.DeepEqual(&got, config) {
+		t.Errorf("Generated YAML reads back as\n%+v\nwant\n%+v", got, *config)
+	}
+	if got.WriteFiles[1].Encoding != "b64" || got.WriteFiles[1].Content != "/wA=" {
+		t.Errorf("Expected the binary file base64 encoded, got %+v", got.WriteFiles[1])
+	}
+	if !strings.Contains(output, "permissions: \"0640\"") {
+		t.Errorf("Expected the permissions as a string, got:\n%s", output)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  CloudInitConfig
+		wantErr string
+	}{
+		{name: "valid", config: CloudInitConfig{Hostname: "web-1.lab", WriteFiles: []File{{Path: "/etc/x", Owner: "root", Permissions: "644"}}}},
+		{name: "hostname", config: CloudInitConfig{Hostname: "web_1"}, wantErr: "not a valid host name"},
+		{name: "user", config: CloudInitConfig{Users: []User{{}}}, wantErr: "user 0 has no name"},
+		{name: "package", config: CloudInitConfig{Packages: []string{"curl wget"}}, wantErr: "not a package name"},
+		{name: "runcmd", config: CloudInitConfig{RunCommands: []string{" "}}, wantErr: "run command 0 is empty"},
+		{name: "path", config: CloudInitConfig{WriteFiles: []File{{Path: "etc/x"}}}, wantErr: "not absolute"},
+		{name: "permissions", config: CloudInitConfig{WriteFiles: []File{{Path: "/x", Permissions: "rw-r--r--"}}}, wantErr: "not octal"},
+		{name: "owner", config: CloudInitConfig{WriteFiles: []File{{Path: "/x", Owner: "root:"}}}, wantErr: "not user or user:group"},
+		{name: "encoding", config: CloudInitConfig{WriteFiles: []File{{Path: "/x", Encoding: "gzip"}}}, wantErr: "not b64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() gave err: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() gave err %v, want %q", err, tt.wantErr)
+			}
+			if _, err := tt.config.Generate(); err == nil {
+				t.Error("Expected Generate() to refuse an invalid config")
+			}
+		})
+	}
+}
+
+func TestBuilderProvisioning(t *testing.T) {
+	config := NewBuilder().
+		WithUsername("dtt").
+		WithTimezone("Europe/Amsterdam").
+		WithLocale("en_US.UTF-8").
+		WithSSHPasswordAuth(true).
+		WithPlainPassword("dtt", "correct-horse").
+		WithHashedPassword("root", "$6$salt$hash").
+		WithPasswordExpiry(false).
+		WithNTPServer("ntp1.example").
+		WithNTPServer("10.0.0.1").
+		WithPackageUpdate().
+		WithPackageUpgrade().
+		Build()
+
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
+
+	for _, want := range []string{
+		"timezone: Europe/Amsterdam\n",
+		"locale: en_US.UTF-8\n",
+		"chpasswd:\n  users:\n    - name: dtt\n      password: correct-horse\n      type: text\n",
+		"  expire: false\n",
+		"ssh_pwauth: true\n",
+		"ntp:\n  enabled: true\n  servers:\n    - ntp1.example\n    - 10.0.0.1\n",
+		"package_update: true\npackage_upgrade: true\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+
+	if output, _ := NewBuilder().WithPackageUpdate().Build().Generate(); strings.Contains(output, "ssh_pwauth") {
+		t.Error("Expected no ssh_pwauth unless set")
+	}
+}
+
+func TestValidateProvisioning(t *testing.T) {
+	for _, tt := range []struct {
+		config  *CloudInitConfig
+		wantErr string
+	}{
+		{NewBuilder().WithTimezone("Europe/Amster dam").Build(), "timezone"},
+		{NewBuilder().WithLocale("english").Build(), "locale"},
+		{NewBuilder().WithHashedPassword("root", "secret").Build(), "not a crypt(3) hash"},
+		{NewBuilder().WithPlainPassword("", "secret").Build(), "without a user"},
+		{&CloudInitConfig{ChPasswd: &ChPasswd{Users: []Password{{Name: "root", Type: "RANDOM"}}}}, "not text or hash"},
+		{NewBuilder().WithNTPServer("").Build(), "NTP server"},
+	} {
+		if err := tt.config.Validate(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate() gave err %v, want %q", err, tt.wantErr)
+		}
+	}
+}
+
+func TestBuilderDataDisk(t *testing.T) {
+	config := NewBuilder().
+		WithGrowpart("/").
+		WithDataDisk("/dev/sdb", "ext4", "data1", "/data").
+		Build()
+
+	output, err := config.Generate()
+	if err != nil {
+		t.Fatalf("Generate() gave err: %v", err)
+	}
+
+	for _, want := range []string{
+		"growpart:\n  mode: auto\n  devices:\n    - /\n",
+		"disk_setup:\n  /dev/sdb:\n    table_type: gpt\n    layout: true\n    overwrite: false\n",
+		"fs_setup:\n  - label: data1\n    filesystem: ext4\n    device: /dev/sdb\n    partition: auto\n",
+		"mounts:\n  - - LABEL=data1\n    - /data\n    - ext4\n    - defaults,nofail\n    - \"0\"\n    - \"2\"\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestValidateDisks(t *testing.T) {
+	for _, tt := range []struct {
+		config  *CloudInitConfig
+		wantErr string
+	}{
+		{NewBuilder().WithDataDisk("sdb", "ext4", "data", "/data").Build(), "not a path"},
+		{NewBuilder().WithDataDisk("/dev/sdb", "", "data", "/data").Build(), "no filesystem"},
+		{NewBuilder().WithDataDisk("/dev/sdb", "ext4", "a label too long", "/data").Build(), "filesystem label"},
+		{NewBuilder().WithDataDisk("/dev/sdb", "ext4", "data", "data").Build(), "absolute mount point"},
+		{&CloudInitConfig{Growpart: &Growpart{Mode: "grow"}}, "growpart mode"},
+		{&CloudInitConfig{DiskSetup: map[string]DiskSetup{"/dev/sdb": {TableType: "dos"}}}, "not gpt or mbr"},
+	} {
+		if err := tt.config.Validate(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate() gave err %v, want %q", err, tt.wantErr)
+		}
+	}
+}
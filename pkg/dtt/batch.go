@@ -0,0 +1,88 @@
+package dtt
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BatchResult is the outcome of RunBatch on one VM.
+type BatchResult struct {
+	Result
+	// Err is why the binary failed on the VM, or could not be run on it.
+	Err      error
+	Duration time.Duration
+}
+
+// RunBatch runs the local binary on the existing VMs vms, as VM and CreateVM
+// return them, at most parallel at once, or all at once when parallel is 0.
+// The VMs may come from Clients of different nodes. It returns a result for
+// every VM, in the order of vms, and an error only when the binary or opts
+// are invalid, before anything ran.
+//
+// The VMs are reached and the binary run as Run does, but the VMs are not
+// created or removed: the VMOptions, IP and Keep of opts are not used, and
+// the binary is only checked against opts.Image when that is set. The
+// output of every VM is in its result, opts.Stdout and opts.Stderr are not
+// used. The files of opts.Collect are downloaded into a directory per VM
+// below Local, named by the VM ID. The progress of the VMs is reported
+// concurrently.
+func RunBatch(ctx context.Context, vms []*VM, binaryPath string, opts RunOptions, parallel int) ([]BatchResult, error) {
+	opts.IP, opts.Stdout, opts.Stderr = "", nil, nil
+	if err := opts.setRunDefaults(); err != nil {
+		return nil, err
+	}
+	run, err := binaryRun(ctx, binaryPath, opts, opts.Image)
+	if err != nil {
+		return nil, err
+	}
+	// Clients connect and look up their node on first use, do that before
+	// their VMs share them.
+	connected := map[*Client]bool{}
+	for _, vm := range vms {
+		if connected[vm.client] {
+			continue
+		}
+		if err := vm.client.proxmox.Connect(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := vm.client.proxmox.GetNode(ctx); err != nil {
+			return nil, err
+		}
+		connected[vm.client] = true
+	}
+
+	if parallel <= 0 || parallel > len(vms) {
+		parallel = len(vms)
+	}
+	results := make([]BatchResult, len(vms))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, vm := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] = BatchResult{Result: Result{VM: vm, ExitCode: -1}, Err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+
+			vmOpts := opts
+			vmOpts.Collect = nil
+			for _, c := range opts.Collect {
+				c.Local = filepath.Join(c.Local, strconv.Itoa(vm.ID))
+				vmOpts.Collect = append(vmOpts.Collect, c)
+			}
+			start := time.Now()
+			result, err := vm.runOn(ctx, vmOpts, run)
+			results[i] = BatchResult{Result: *result, Err: err, Duration: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -0,0 +1,87 @@
+package dtt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestRunBatch(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "web-1", Status: "running"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "web-2", Status: "running"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 103, Name: "web-3", Status: "stopped"})
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	binaryPath := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\necho ran\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	server.HandleAgentExec(func(vm *proxmoxtest.VM, command []string) (int, string, string) {
+		if command[0] == "sh" && strings.Contains(command[2], `exec "$@" >`) {
+			// sh -c <script> sh <stdout> <stderr> <binary>
+			vm.Files[command[4]] = []byte("ran on " + vm.Name + "\n")
+			vm.Files[command[5]] = nil
+			return int(vm.VMID) - 101, "", ""
+		}
+		return 0, "", ""
+	})
+
+	vms := []*VM{client.VM(101, "web-1", "", ""), client.VM(102, "web-2", "", ""), client.VM(103, "web-3", "", "")}
+	results, err := RunBatch(context.Background(), vms, binaryPath, RunOptions{Via: ViaAgent, IPTimeout: time.Millisecond}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected a result for every VM, got %d", len(results))
+	}
+	if r := results[0]; r.Err != nil || r.ExitCode != 0 || r.Output != "ran on web-1\n" || r.VM != vms[0] {
+		t.Errorf("Expected web-1 to succeed, got %q, exit code %d, %v", r.Output, r.ExitCode, r.Err)
+	}
+	if r := results[1]; r.Err == nil || r.ExitCode != 1 || r.Output != "ran on web-2\n" {
+		t.Errorf("Expected web-2 to exit with 1, got %q, exit code %d, %v", r.Output, r.ExitCode, r.Err)
+	}
+	if r := results[2]; r.Err == nil || r.ExitCode != -1 {
+		t.Errorf("Expected web-3 to fail to run, got exit code %d, %v", r.ExitCode, r.Err)
+	}
+	for _, vm := range vms {
+		if server.VM(uint64(vm.ID)) == nil {
+			t.Errorf("Expected VM %d to be kept", vm.ID)
+		}
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected no VMs to be created or removed")
+	}
+}
+
+func TestRunBatchChecksOptions(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "web-1", Status: "running"})
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	binaryPath := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	vms := []*VM{client.VM(101, "web-1", "", "")}
+	if _, err := RunBatch(context.Background(), vms, binaryPath, RunOptions{Via: "telnet"}, 0); err == nil {
+		t.Error("Expected an error for an unknown Via")
+	}
+	if _, err := RunBatch(context.Background(), vms, binaryPath, RunOptions{Env: []string{"=x"}}, 0); err == nil {
+		t.Error("Expected an error for an invalid environment variable")
+	}
+	if _, err := RunBatch(context.Background(), vms, "/nonexistent/binary", RunOptions{}, 0); err == nil {
+		t.Error("Expected an error for a missing binary")
+	}
+	if len(server.Requests()) != 0 {
+		t.Error("Expected no requests for invalid options")
+	}
+}
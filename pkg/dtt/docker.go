@@ -0,0 +1,117 @@
+package dtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// DefaultDockerMemory is the memory RunContainer gives VMs unless
+// VMOptions.Memory is set, in MB. Pulling and unpacking images needs more
+// than DefaultMemory.
+const DefaultDockerMemory = 1024
+
+// DockerTag is the Proxmox tag of the VMs RunContainer creates, next to
+// proxmox.ManagedTag.
+const DockerTag = "dtt-docker"
+
+// ContainerName is the name RunContainer gives the container, for docker logs
+// and docker stop on the VM.
+const ContainerName = "dtt"
+
+// DockerOptions describe a container to run with RunContainer.
+type DockerOptions struct {
+	RunOptions
+
+	Ports   []string // published ports as docker run -p takes them, e.g. "8080:80"
+	Env     []string // KEY=VALUE
+	Volumes []string // bind mounts as docker run -v takes them, the host side is on the VM
+	Args    []string // command and arguments, the default of the image unless set
+	// Detach leaves the container running in the background instead of
+	// waiting for it to exit.
+	Detach bool
+	// Logs receives the output of the container as it comes. Without it the
+	// output is collected in Result.Output.
+	Logs io.Writer
+}
+
+// RunContainer creates a VM, installs docker on it and runs the container
+// image. Unless opts.Detach is set it waits for the container to exit, which
+// fails when the container exits with an error. The VM is removed afterwards
+// unless opts.Keep is set, like with Run.
+func (c *Client) RunContainer(ctx context.Context, image string, opts DockerOptions) (*Result, error) {
+	if image == "" {
+		return nil, errors.New("a docker image is required")
+	}
+	for _, env := range opts.Env {
+		if !strings.Contains(env, "=") {
+			return nil, fmt.Errorf("invalid environment variable %q, want KEY=VALUE", env)
+		}
+	}
+	if opts.Memory == 0 {
+		opts.Memory = DefaultDockerMemory
+	}
+	if opts.Purpose == "" {
+		opts.Purpose = "docker " + image
+	}
+	opts.Tags = append(opts.Tags, DockerTag)
+
+	return c.runOnVM(ctx, opts.RunOptions, func(vm *VM) (string, error) {
+		c.report("docker", "installing docker on VM %d", vm.ID)
+		if output, err := vm.Exec(ctx, dockerInstallScript()); err != nil {
+			return "", fmt.Errorf("installing docker: %w\n%s", err, output)
+		}
+		c.report("docker", "pulling %s", image)
+		if output, err := vm.Exec(ctx, "sudo docker pull "+sshpkg.ShellQuote(image)); err != nil {
+			return "", fmt.Errorf("pulling %s: %w\n%s", image, err, output)
+		}
+
+		c.report("run", "running %s on VM %d", image, vm.ID)
+		run := dockerRunCommand(image, opts)
+		if opts.Logs != nil && !opts.Detach {
+			return "", vm.ExecStream(ctx, run, opts.Logs)
+		}
+		return vm.Exec(ctx, run)
+	})
+}
+
+// dockerInstallScript installs docker from the distribution packages, unless
+// the image already has it, and starts it.
+func dockerInstallScript() string {
+	return strings.Join([]string{
+		"set -e",
+		// Package installs of cloud-init hold the dpkg lock until it is done.
+		"cloud-init status --wait >/dev/null 2>&1 || true",
+		"if ! command -v docker >/dev/null; then",
+		"  sudo apt-get update -q",
+		"  sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -q docker.io",
+		"fi",
+		"sudo systemctl enable --now docker",
+	}, "\n")
+}
+
+// dockerRunCommand returns the docker run command for image and opts.
+func dockerRunCommand(image string, opts DockerOptions) string {
+	run := []string{"sudo", "docker", "run", "--name", ContainerName}
+	if opts.Detach {
+		run = append(run, "--detach", "--restart", "unless-stopped")
+	}
+	for _, port := range opts.Ports {
+		run = append(run, "--publish", sshpkg.ShellQuote(port))
+	}
+	for _, env := range opts.Env {
+		run = append(run, "--env", sshpkg.ShellQuote(env))
+	}
+	for _, volume := range opts.Volumes {
+		run = append(run, "--volume", sshpkg.ShellQuote(volume))
+	}
+	run = append(run, sshpkg.ShellQuote(image))
+	for _, arg := range opts.Args {
+		run = append(run, sshpkg.ShellQuote(arg))
+	}
+	return strings.Join(run, " ")
+}
@@ -0,0 +1,48 @@
+package dtt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestDockerRunCommand(t *testing.T) {
+	run := dockerRunCommand("nginx:1.27", DockerOptions{
+		Ports:   []string{"8080:80"},
+		Env:     []string{"GREETING=it's me"},
+		Volumes: []string{"/srv/www:/usr/share/nginx/html:ro"},
+		Args:    []string{"nginx", "-g", "daemon off;"},
+	})
+	want := `sudo docker run --name dtt --publish '8080:80' --env 'GREETING=it'\''s me' --volume '/srv/www:/usr/share/nginx/html:ro' 'nginx:1.27' 'nginx' '-g' 'daemon off;'`
+	if run != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, run)
+	}
+
+	if run := dockerRunCommand("redis", DockerOptions{Detach: true}); !strings.Contains(run, "--detach --restart unless-stopped 'redis'") {
+		t.Errorf("Expected a detached container to be restarted, got %s", run)
+	}
+	if script := dockerInstallScript(); !strings.Contains(script, "apt-get install -y -q docker.io") || !strings.Contains(script, "cloud-init status --wait") {
+		t.Errorf("Expected the install script to wait for cloud-init and install docker.io, got:\n%s", script)
+	}
+}
+
+func TestRunContainerChecksOptions(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	for image, opts := range map[string]DockerOptions{
+		"":      {},
+		"nginx": {Env: []string{"NOVALUE"}},
+	} {
+		if _, err := client.RunContainer(context.Background(), image, opts); err == nil {
+			t.Errorf("Expected RunContainer(%q, %+v) to fail", image, opts)
+		}
+	}
+	if server.VM(100) != nil {
+		t.Error("Expected no VM to be created for invalid options")
+	}
+}
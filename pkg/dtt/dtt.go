@@ -0,0 +1,891 @@
+// Package dtt runs Linux binaries on throwaway Proxmox VE virtual machines.
+//
+// It is the workflow behind the dtt command line as a library: pick a cloud
+// image, create a VM from it with cloud-init, wait for the VM to get an IP
+// address and accept SSH, upload and run a binary, and remove the VM again.
+//
+// Run does all of it in one call:
+//
+//	client := dtt.New(proxmox.ClientConfig{
+//		Host:        "pve.example.com",
+//		Port:        8006,
+//		TokenID:     "root@pam!dtt",
+//		TokenSecret: os.Getenv("DTT_PROXMOX_TOKEN_SECRET"),
+//		Node:        "pve",
+//	})
+//	result, err := client.Run(ctx, "./mytool", dtt.RunOptions{})
+//
+// CreateVM and the methods of VM run the steps one at a time, for programs
+// that want to keep a VM around or run several binaries on it.
+package dtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/binary"
+	"github.com/cdevr/dtt/pkg/proxmox"
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// Defaults of VMOptions and RunOptions.
+const (
+	DefaultImage      = "debian-11"
+	DefaultMemory     = 512 // MB
+	DefaultUsername   = "dtt"
+	DefaultPassword   = "dtt"
+	DefaultRemotePath = "/tmp/binary"
+	DefaultRemoteDir  = "/tmp/dtt-run"
+	DefaultIPTimeout  = 5 * time.Minute
+	DefaultSSHTimeout = 5 * time.Minute
+)
+
+// RunTag is the Proxmox tag of the VMs Run and RunBundle create, next to
+// proxmox.ManagedTag, so a batch of them can be cleaned up at once.
+const RunTag = "dtt-run"
+
+// How Run reaches the VM, see RunOptions.Via.
+const (
+	ViaSSH   = "ssh"
+	ViaAgent = "agent"
+)
+
+// Images returns the cloud images VMOptions.Image can name.
+func Images() map[string]proxmox.Image {
+	images := proxmox.DefaultImages()
+	return map[string]proxmox.Image{
+		"debian-11":    images[0],
+		"debian-13":    images[1],
+		"ubuntu-24.04": images[2],
+		"debian":       images[0],
+		"ubuntu":       images[2],
+	}
+}
+
+// ResolveImage returns the image called name, see Images.
+func ResolveImage(name string) (proxmox.Image, error) {
+	if image, ok := Images()[name]; ok {
+		return image, nil
+	}
+	var names []string
+	for n := range Images() {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return proxmox.Image{}, fmt.Errorf("unknown image %q, use one of %s", name, strings.Join(names, ", "))
+}
+
+// Client provisions VMs on one Proxmox node. It is safe to use for one
+// workflow at a time.
+type Client struct {
+	config  proxmox.ClientConfig
+	proxmox *proxmox.Client
+}
+
+// New returns a client connecting with config on first use. config.Progress
+// also receives the steps of the workflow, with the phases "create", "ip",
+// "ssh", "upload", "run", "update", "runner", "docker" and "destroy".
+func New(config proxmox.ClientConfig) *Client {
+	return &Client{config: config, proxmox: proxmox.NewClient(config)}
+}
+
+// NewWithAPI returns a client using api instead of connecting itself.
+func NewWithAPI(config proxmox.ClientConfig, api proxmox.ProxmoxAPI) *Client {
+	return &Client{config: config, proxmox: proxmox.NewClientWithAPI(config, api)}
+}
+
+// Proxmox returns the underlying Proxmox client, for what this package does
+// not cover.
+func (c *Client) Proxmox() *proxmox.Client {
+	return c.proxmox
+}
+
+func (c *Client) report(phase, format string, args ...interface{}) {
+	if c.config.Progress != nil {
+		c.config.Progress(proxmox.Progress{Phase: phase, Message: fmt.Sprintf(format, args...), Percent: -1})
+	}
+}
+
+// VMOptions describe the VM to create. The zero value creates a Debian 11 VM
+// with the next free VMID, 512 MB of memory, one core and the user dtt with
+// password dtt.
+type VMOptions struct {
+	Name     string `json:"name,omitempty"`   // hostname, "dtt-<vmid>" unless set
+	VMID     int    `json:"vmid,omitempty"`   // 0 picks the next free VMID
+	Image    string `json:"image,omitempty"`  // see Images, DefaultImage unless set
+	Memory   int    `json:"memory,omitempty"` // MB
+	Sockets  int    `json:"sockets,omitempty"`
+	Cores    int    `json:"cores,omitempty"`
+	DiskSize int    `json:"disk_size,omitempty"` // GB, the size of the image plus 10 GB unless set
+	Network  string `json:"network,omitempty"`   // network device, "virtio,bridge=vmbr0" unless set
+	Purpose  string `json:"purpose,omitempty"`   // recorded in the VM description, see proxmox.Provenance
+	// TTL, unless 0, lets proxmox.Client.CollectGarbage delete the VM once
+	// it is this old.
+	TTL time.Duration `json:"-"`
+	// Tags are Proxmox tags to set next to proxmox.ManagedTag.
+	Tags []string `json:"tags,omitempty"`
+	// ExtraNetworks are network devices net1 and up, after Network.
+	ExtraNetworks []string `json:"extra_networks,omitempty"`
+
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	SSHPublicKey string `json:"ssh_public_key,omitempty"`
+}
+
+func (o *VMOptions) setDefaults() {
+	if o.Image == "" {
+		o.Image = DefaultImage
+	}
+	if o.Memory == 0 {
+		o.Memory = DefaultMemory
+	}
+	if o.Sockets == 0 {
+		o.Sockets = 1
+	}
+	if o.Cores == 0 {
+		o.Cores = 1
+	}
+	if o.Username == "" {
+		o.Username = DefaultUsername
+	}
+	if o.Password == "" {
+		o.Password = DefaultPassword
+	}
+}
+
+// VM is a VM created by a Client.
+type VM struct {
+	ID   int
+	Name string
+	// IP is the address used for SSH, set by WaitForIP.
+	IP string
+
+	client   *Client
+	username string
+	password string
+	// via is how Collect reaches the VM, ViaSSH unless set.
+	via string
+}
+
+// CreateVM creates and starts a VM. It returns once the VM runs, use
+// WaitForIP and WaitForSSH before running anything on it.
+func (c *Client) CreateVM(ctx context.Context, opts VMOptions) (*VM, error) {
+	opts.setDefaults()
+	image, err := ResolveImage(opts.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.VMID == 0 {
+		if opts.VMID, err = c.nextVMID(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Name == "" {
+		opts.Name = fmt.Sprintf("dtt-%d", opts.VMID)
+	}
+
+	c.report("create", "creating VM %s (ID %d) from %s", opts.Name, opts.VMID, image.Name)
+	created, err := c.proxmox.CreateVM(ctx, proxmox.VMSpec{
+		Name:          opts.Name,
+		VMID:          opts.VMID,
+		Image:         image,
+		Memory:        opts.Memory,
+		CPU:           opts.Sockets,
+		Cores:         opts.Cores,
+		DiskSize:      opts.DiskSize,
+		Network:       opts.Network,
+		ExtraNetworks: opts.ExtraNetworks,
+		CloudInit:     true,
+		Username:      opts.Username,
+		Password:      opts.Password,
+		SSHPublicKey:  opts.SSHPublicKey,
+		Description:   proxmox.NewProvenance("", image.URL, opts.Purpose, opts.Username).WithTTL(opts.TTL).String(),
+		Tags:          append([]string{proxmox.ManagedTag}, opts.Tags...),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating VM %d: %w", opts.VMID, err)
+	}
+	return &VM{ID: created.ID, Name: created.Name, client: c, username: opts.Username, password: opts.Password}, nil
+}
+
+func (c *Client) nextVMID(ctx context.Context) (int, error) {
+	if err := c.proxmox.Connect(ctx); err != nil {
+		return 0, err
+	}
+	cluster, err := c.proxmox.APIClient().Cluster(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting cluster: %w", proxmox.WrapError(err))
+	}
+	vmid, err := cluster.NextID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting next VM ID: %w", proxmox.WrapError(err))
+	}
+	return vmid, nil
+}
+
+// VM returns a handle on the existing VM vmid of the node, logging in with
+// username and password, DefaultUsername and DefaultPassword when empty.
+func (c *Client) VM(vmid int, name, username, password string) *VM {
+	if username == "" {
+		username = DefaultUsername
+	}
+	if password == "" {
+		password = DefaultPassword
+	}
+	return &VM{ID: vmid, Name: name, client: c, username: username, password: password}
+}
+
+// WaitForIP waits until the qemu guest agent of the VM reports an IPv4
+// address, and sets vm.IP to it.
+func (vm *VM) WaitForIP(ctx context.Context, timeout time.Duration) (string, error) {
+	vm.client.report("ip", "waiting for VM %d to get an IP address", vm.ID)
+	deadline := time.Now().Add(timeout)
+	for {
+		ip, err := vm.client.proxmox.GetVMIPAddress(ctx, vm.ID)
+		if err == nil && ip != "" {
+			vm.IP = ip
+			return ip, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("VM %d got no IP address within %s: %w", vm.ID, timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// WaitForSSH waits until the VM accepts SSH logins on vm.IP.
+func (vm *VM) WaitForSSH(ctx context.Context, timeout time.Duration) error {
+	if vm.IP == "" {
+		return errors.New("VM has no IP address, call WaitForIP or set IP first")
+	}
+	vm.client.report("ssh", "waiting for SSH on %s", vm.IP)
+	// WaitForVMReady tries every 10 seconds.
+	attempts := int(timeout / (10 * time.Second))
+	if attempts < 1 {
+		attempts = 1
+	}
+	return vm.client.proxmox.WaitForVMReady(ctx, vm.IP, vm.username, vm.password, attempts)
+}
+
+// RunBinary uploads the local binary to remotePath on the VM and runs it,
+// returning its combined output.
+func (vm *VM) RunBinary(ctx context.Context, localPath, remotePath string) (string, error) {
+	return vm.runBinary(ctx, localPath, binaryCommand{Path: remotePath})
+}
+
+// RunBinaryStream is RunBinary copying the output of the binary to stdout
+// and stderr as it comes instead of returning it. sshpkg.ExitCode gets the
+// exit status of the binary from the error.
+func (vm *VM) RunBinaryStream(ctx context.Context, localPath, remotePath string, stdout, stderr io.Writer) error {
+	return vm.runBinaryStream(ctx, localPath, binaryCommand{Path: remotePath}, stdout, stderr)
+}
+
+// WaitForAgent waits until the qemu guest agent of the VM answers, which
+// RunBinaryAgent needs.
+func (vm *VM) WaitForAgent(ctx context.Context, timeout time.Duration) error {
+	vm.client.report("agent", "waiting for the guest agent of VM %d", vm.ID)
+	return vm.client.proxmox.WaitForAgent(ctx, vm.ID, timeout)
+}
+
+// RunBinaryAgent is RunBinaryStream through the qemu guest agent instead of
+// SSH, for VMs without a network path to here or before SSH works. It needs
+// sh and cat in the guest.
+func (vm *VM) RunBinaryAgent(ctx context.Context, localPath, remotePath string, stdout, stderr io.Writer) error {
+	return vm.runBinaryAgent(ctx, localPath, binaryCommand{Path: remotePath}, stdout, stderr)
+}
+
+// binaryCommand is how a binary uploaded to Path runs: with Args, the
+// KEY=VALUE pairs of Env added to its environment, in Workdir and with its
+// standard input read from the local file StdinFile, uploaded next to it.
+type binaryCommand struct {
+	Path      string
+	Args      []string
+	Env       []string
+	Workdir   string
+	StdinFile string
+}
+
+// stdinPath is where the standard input of the binary is uploaded to.
+func (b binaryCommand) stdinPath() string {
+	return b.Path + ".stdin"
+}
+
+// shell returns b as a command line for a POSIX shell.
+func (b binaryCommand) shell() string {
+	var parts []string
+	if b.Workdir != "" {
+		parts = append(parts, "cd", sshpkg.ShellQuote(b.Workdir), "&&")
+	}
+	if len(b.Env) > 0 {
+		parts = append(parts, "env")
+		for _, env := range b.Env {
+			parts = append(parts, sshpkg.ShellQuote(env))
+		}
+	}
+	parts = append(parts, sshpkg.ShellQuote(b.Path))
+	for _, arg := range b.Args {
+		parts = append(parts, sshpkg.ShellQuote(arg))
+	}
+	if b.StdinFile != "" {
+		parts = append(parts, "<", sshpkg.ShellQuote(b.stdinPath()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// argv returns b as the command the guest agent runs, only going through sh
+// when it needs one.
+func (b binaryCommand) argv() []string {
+	if len(b.Env) == 0 && b.Workdir == "" && b.StdinFile == "" {
+		return append([]string{b.Path}, b.Args...)
+	}
+	return []string{"sh", "-c", b.shell()}
+}
+
+// uploadBinary uploads the local binary, and the standard input of cmd if it
+// has one, over SSH.
+func (vm *VM) uploadBinary(ctx context.Context, localPath string, cmd binaryCommand) error {
+	vm.client.report("upload", "uploading %s to %s:%s", localPath, vm.IP, cmd.Path)
+	if err := vm.client.proxmox.UploadBinary(ctx, vm.IP, vm.username, vm.password, localPath, cmd.Path); err != nil {
+		return err
+	}
+	return vm.uploadStdin(ctx, cmd)
+}
+
+// uploadStdin uploads the standard input of cmd over SSH, if it has one.
+func (vm *VM) uploadStdin(ctx context.Context, cmd binaryCommand) error {
+	if cmd.StdinFile == "" {
+		return nil
+	}
+	if err := vm.client.proxmox.UploadFile(ctx, vm.IP, vm.username, vm.password, cmd.StdinFile, cmd.stdinPath(), 0o600); err != nil {
+		return fmt.Errorf("uploading %s gave err: %w", cmd.StdinFile, err)
+	}
+	return nil
+}
+
+// extractArchive uploads the local gzipped tar archive and extracts it into
+// dir on the VM.
+func (vm *VM) extractArchive(ctx context.Context, archive, dir string) error {
+	remote := dir + ".tar.gz"
+	vm.client.report("upload", "uploading %s to %s:%s", archive, vm.IP, dir)
+	if err := vm.client.proxmox.UploadFile(ctx, vm.IP, vm.username, vm.password, archive, remote, 0o600); err != nil {
+		return err
+	}
+	extract := fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s; status=$?; rm -f %s; exit $status",
+		sshpkg.ShellQuote(dir), sshpkg.ShellQuote(remote), sshpkg.ShellQuote(dir), sshpkg.ShellQuote(remote))
+	if output, err := vm.Exec(ctx, extract); err != nil {
+		return fmt.Errorf("extracting %s gave err: %w\n%s", archive, err, output)
+	}
+	return nil
+}
+
+func (vm *VM) runBinary(ctx context.Context, localPath string, cmd binaryCommand) (string, error) {
+	if err := vm.uploadBinary(ctx, localPath, cmd); err != nil {
+		return "", err
+	}
+	return vm.execBinary(ctx, cmd)
+}
+
+func (vm *VM) runBinaryStream(ctx context.Context, localPath string, cmd binaryCommand, stdout, stderr io.Writer) error {
+	if err := vm.uploadBinary(ctx, localPath, cmd); err != nil {
+		return err
+	}
+	return vm.execBinaryStream(ctx, cmd, stdout, stderr)
+}
+
+// execBinary runs cmd, already on the VM, over SSH, returning its combined
+// output.
+func (vm *VM) execBinary(ctx context.Context, cmd binaryCommand) (string, error) {
+	vm.client.report("run", "running %s on VM %d", cmd.Path, vm.ID)
+	return vm.client.proxmox.ExecuteBinary(ctx, vm.IP, vm.username, vm.password, cmd.shell())
+}
+
+// execBinaryStream is execBinary copying the output to stdout and stderr as
+// it comes.
+func (vm *VM) execBinaryStream(ctx context.Context, cmd binaryCommand, stdout, stderr io.Writer) error {
+	vm.client.report("run", "running %s on VM %d", cmd.Path, vm.ID)
+	return vm.client.proxmox.ExecuteStream(ctx, vm.IP, vm.username, vm.password, cmd.shell(), stdout, stderr)
+}
+
+func (vm *VM) runBinaryAgent(ctx context.Context, localPath string, cmd binaryCommand, stdout, stderr io.Writer) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	vm.client.report("upload", "uploading %s to VM %d:%s through the guest agent", localPath, vm.ID, cmd.Path)
+	if err := vm.client.proxmox.AgentWriteFile(ctx, vm.ID, cmd.Path, data, 0o755); err != nil {
+		return err
+	}
+	if cmd.StdinFile != "" {
+		stdin, err := os.ReadFile(cmd.StdinFile)
+		if err != nil {
+			return err
+		}
+		if err := vm.client.proxmox.AgentWriteFile(ctx, vm.ID, cmd.stdinPath(), stdin, 0o600); err != nil {
+			return fmt.Errorf("uploading %s gave err: %w", cmd.StdinFile, err)
+		}
+	}
+	vm.client.report("run", "running %s on VM %d", cmd.Path, vm.ID)
+	return vm.client.proxmox.AgentStream(ctx, vm.ID, cmd.argv(), "", stdout, stderr)
+}
+
+// Collect downloads the files on the VM matching the shell glob pattern into
+// localDir and returns their local paths.
+func (vm *VM) Collect(ctx context.Context, pattern, localDir string) ([]string, error) {
+	if vm.via == ViaAgent {
+		vm.client.report("collect", "downloading VM %d:%s to %s through the guest agent", vm.ID, pattern, localDir)
+		return vm.client.proxmox.AgentDownloadFiles(ctx, vm.ID, pattern, localDir)
+	}
+	vm.client.report("collect", "downloading %s:%s to %s", vm.IP, pattern, localDir)
+	return vm.client.proxmox.DownloadFiles(ctx, vm.IP, vm.username, vm.password, pattern, localDir)
+}
+
+// InstallBundle uploads the files of bundle to the VM as one archive,
+// unpacks it as root to lay them out with their owners and modes, and checks
+// their SHA256 on the VM.
+func (vm *VM) InstallBundle(ctx context.Context, bundle *binary.Bundle) error {
+	archive, err := os.CreateTemp("", "dtt-bundle-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	if err := bundle.Pack(archive); err != nil {
+		archive.Close()
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	verify, err := bundle.VerifyCommand()
+	if err != nil {
+		return err
+	}
+
+	remote := "/tmp/" + filepath.Base(archive.Name())
+	vm.client.report("upload", "uploading %d files to %s", len(bundle.Files), vm.IP)
+	if err := vm.client.proxmox.UploadFile(ctx, vm.IP, vm.username, vm.password, archive.Name(), remote, 0o600); err != nil {
+		return err
+	}
+	sudo := "sudo "
+	if vm.username == "root" {
+		sudo = ""
+	}
+	unpack := fmt.Sprintf("%star -xzpf %s -C /; status=$?; rm -f %s; exit $status", sudo, remote, remote)
+	if output, err := vm.Exec(ctx, unpack); err != nil {
+		return fmt.Errorf("unpacking the bundle gave err: %w\n%s", err, output)
+	}
+	if output, err := vm.Exec(ctx, verify); err != nil {
+		return fmt.Errorf("verifying the bundle gave err: %w\n%s", err, output)
+	}
+	return nil
+}
+
+// Exec runs a shell command on the VM, returning its combined output.
+func (vm *VM) Exec(ctx context.Context, command string) (string, error) {
+	if vm.IP == "" {
+		return "", errors.New("VM has no IP address, call WaitForIP or set IP first")
+	}
+	return vm.client.proxmox.ExecuteCommand(ctx, vm.IP, vm.username, vm.password, command)
+}
+
+// ExecStream runs a shell command on the VM, copying its combined output to
+// w as it comes.
+func (vm *VM) ExecStream(ctx context.Context, command string, w io.Writer) error {
+	if vm.IP == "" {
+		return errors.New("VM has no IP address, call WaitForIP or set IP first")
+	}
+	return vm.client.proxmox.StreamCommand(ctx, vm.IP, vm.username, vm.password, command, w)
+}
+
+// Destroy stops and deletes the VM.
+func (vm *VM) Destroy(ctx context.Context) error {
+	vm.client.report("destroy", "removing VM %d", vm.ID)
+	stopErr := vm.client.proxmox.StopVM(ctx, vm.ID)
+	if err := vm.client.proxmox.DeleteVM(ctx, vm.ID); err != nil {
+		return errors.Join(err, stopErr)
+	}
+	return nil
+}
+
+// RunOptions tune Run.
+type RunOptions struct {
+	VMOptions
+
+	// RemotePath is where to put the binary, DefaultRemotePath unless set,
+	// or for RunArchive the directory to extract into.
+	RemotePath string `json:"remote_path,omitempty"`
+	// IP skips asking the guest agent for the address of the VM. It is not
+	// taken from JSON, where it would send the password and the binary to
+	// any host.
+	IP         string        `json:"-"`
+	IPTimeout  time.Duration `json:"-"`
+	SSHTimeout time.Duration `json:"-"`
+	// Keep leaves the VM running afterwards instead of removing it.
+	Keep bool `json:"keep,omitempty"`
+	// Via is how to reach the VM: ViaSSH, the default, or ViaAgent to upload
+	// and run the binary through the qemu guest agent, for VMs without a
+	// network path to here or before SSH works. With ViaAgent IPTimeout is
+	// how long to wait for the agent, and IP and SSHTimeout are not used.
+	// RunBundle needs SSH.
+	Via string `json:"via,omitempty"`
+	// Stdout and Stderr, when Stdout is set, receive the output of the
+	// binary as it comes instead of Result.Output. A nil Stderr goes to
+	// Stdout.
+	Stdout io.Writer `json:"-"`
+	Stderr io.Writer `json:"-"`
+	// Args, Env, as KEY=VALUE, and Workdir are what Run runs the binary
+	// with, and StdinFile the local file its standard input is read from.
+	// RunBundle and RunContainer don't use them. StdinFile is not taken from
+	// JSON, where it would read from the disk of the server.
+	Args      []string `json:"args,omitempty"`
+	Env       []string `json:"env,omitempty"`
+	Workdir   string   `json:"workdir,omitempty"`
+	StdinFile string   `json:"-"`
+	// SkipBinaryCheck runs binaries InspectELF finds won't run on the image,
+	// for an architecture, glibc version or shared libraries it lacks,
+	// instead of failing with ErrIncompatibleBinary.
+	SkipBinaryCheck bool `json:"skip_binary_check,omitempty"`
+	// Collect lists files to download from the VM once the binary exited,
+	// also when it failed, before the VM is removed. It is not taken from
+	// JSON, where it would write to the disk of the server.
+	Collect []Collect `json:"-"`
+}
+
+// Collect downloads the files on the VM matching the shell glob Remote into
+// the local directory Local.
+type Collect struct {
+	Remote string
+	Local  string
+}
+
+// Result is the outcome of Run.
+type Result struct {
+	VM     *VM
+	Output string
+	// ExitCode is the exit status of what ran, -1 when it didn't get to run
+	// or was killed.
+	ExitCode int
+	// Collected are the local paths of the files RunOptions.Collect
+	// downloaded.
+	Collected []string
+}
+
+// Run creates a VM, runs the local binary on it and, unless opts.Keep is
+// set, removes the VM again, also when something failed. The result holds
+// the VM as soon as it exists, so it is there for inspection on errors with
+// opts.Keep.
+func (c *Client) Run(ctx context.Context, binaryPath string, opts RunOptions) (*Result, error) {
+	imageName := opts.Image
+	if imageName == "" {
+		imageName = DefaultImage
+	}
+	run, err := binaryRun(ctx, binaryPath, opts, imageName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Purpose == "" {
+		opts.Purpose = "run " + filepath.Base(binaryPath)
+	}
+	opts.Tags = append(opts.Tags, RunTag)
+	return c.runOnVM(ctx, opts, run)
+}
+
+// binaryRun checks the local binary and opts, and returns how Run runs it on
+// a VM. The binary is checked against the image called imageName unless that
+// is "".
+func binaryRun(ctx context.Context, binaryPath string, opts RunOptions, imageName string) (func(vm *VM) (string, error), error) {
+	if err := binary.ValidateBinary(binaryPath); err != nil {
+		return nil, err
+	}
+	if err := opts.checkCommand(); err != nil {
+		return nil, err
+	}
+	var elfInfo *binary.ELFInfo
+	if !opts.SkipBinaryCheck {
+		var err error
+		if elfInfo, err = checkBinary(binaryPath, imageName); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RemotePath == "" {
+		opts.RemotePath = DefaultRemotePath
+	}
+	cmd := opts.binaryCommand(opts.RemotePath)
+	return func(vm *VM) (string, error) {
+		stdout, stderr := opts.Stdout, opts.Stderr
+		if stderr == nil {
+			stderr = stdout
+		}
+		if opts.Via == ViaAgent {
+			// The agent has no combined output, collect both instead.
+			var output strings.Builder
+			if stdout == nil {
+				stdout, stderr = &output, &output
+			}
+			err := vm.runBinaryAgent(ctx, binaryPath, cmd, stdout, stderr)
+			return output.String(), err
+		}
+		if err := vm.uploadBinary(ctx, binaryPath, cmd); err != nil {
+			return "", err
+		}
+		if elfInfo != nil && !elfInfo.Static {
+			if missing := vm.missingLibraries(ctx, cmd.Path); len(missing) > 0 {
+				return "", fmt.Errorf("%w: VM %d lacks the shared libraries %s", ErrIncompatibleBinary, vm.ID, strings.Join(missing, ", "))
+			}
+		}
+		if stdout != nil {
+			return "", vm.execBinaryStream(ctx, cmd, stdout, stderr)
+		}
+		return vm.execBinary(ctx, cmd)
+	}, nil
+}
+
+// ErrIncompatibleBinary is returned by Run for a binary that can't run on the
+// VM, unless RunOptions.SkipBinaryCheck is set.
+var ErrIncompatibleBinary = errors.New("the binary can't run on the VM")
+
+// checkBinary checks that the ELF binary at binaryPath can run on a VM of
+// the image called imageName, if not "", and returns what InspectELF found.
+// Scripts and other files that aren't ELF binaries pass with a nil
+// *binary.ELFInfo.
+func checkBinary(binaryPath, imageName string) (*binary.ELFInfo, error) {
+	info, err := binary.InspectELF(binaryPath)
+	if errors.Is(err, binary.ErrNotELF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// An unknown image is reported by CreateVM.
+	if image, err := ResolveImage(imageName); imageName != "" && err == nil {
+		if err := info.CheckTarget(image.Arch, image.GLIBC); err != nil {
+			return nil, fmt.Errorf("%w: %v, with %s", ErrIncompatibleBinary, err, imageName)
+		}
+	}
+	return info, nil
+}
+
+// missingLibraries returns the shared libraries ldd can't find for the
+// binary at remotePath, none when ldd can't tell.
+func (vm *VM) missingLibraries(ctx context.Context, remotePath string) []string {
+	output, err := vm.Exec(ctx, "ldd "+sshpkg.ShellQuote(remotePath))
+	if err != nil {
+		return nil
+	}
+	var missing []string
+	for _, line := range strings.Split(output, "\n") {
+		if lib, _, ok := strings.Cut(strings.TrimSpace(line), " => not found"); ok {
+			missing = append(missing, lib)
+		}
+	}
+	return missing
+}
+
+// checkCommand checks the environment and standard input of opts.
+func (opts RunOptions) checkCommand() error {
+	for _, env := range opts.Env {
+		if key, _, ok := strings.Cut(env, "="); !ok || key == "" {
+			return fmt.Errorf("invalid environment variable %q, want KEY=VALUE", env)
+		}
+	}
+	if opts.StdinFile != "" {
+		if _, err := os.Stat(opts.StdinFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// binaryCommand returns how to run the binary at remotePath with opts.
+func (opts RunOptions) binaryCommand(remotePath string) binaryCommand {
+	return binaryCommand{
+		Path:      remotePath,
+		Args:      opts.Args,
+		Env:       opts.Env,
+		Workdir:   opts.Workdir,
+		StdinFile: opts.StdinFile,
+	}
+}
+
+// RunArchive is Run for a program of several files, such as a binary with
+// its configuration, assets or shared libraries. program is a directory, packed
+// here, or a .tar.gz, which is extracted into opts.RemotePath on the VM,
+// DefaultRemoteDir unless set. entrypoint, relative to the top of it, is run
+// there unless opts.Workdir is set. Like RunBundle it needs SSH.
+func (c *Client) RunArchive(ctx context.Context, program, entrypoint string, opts RunOptions) (*Result, error) {
+	if err := binary.CheckEntrypoint(program, entrypoint); err != nil {
+		return nil, err
+	}
+	if opts.Via == ViaAgent {
+		return nil, errors.New("running a directory or archive needs SSH, RunOptions.Via can't be ViaAgent")
+	}
+	if err := opts.checkCommand(); err != nil {
+		return nil, err
+	}
+	if opts.RemotePath == "" {
+		opts.RemotePath = DefaultRemoteDir
+	}
+	if opts.Purpose == "" {
+		opts.Purpose = "run " + filepath.Base(program)
+	}
+	opts.Tags = append(opts.Tags, RunTag)
+	cmd := opts.binaryCommand(path.Join(opts.RemotePath, strings.TrimPrefix(entrypoint, "./")))
+	if cmd.Workdir == "" {
+		cmd.Workdir = opts.RemotePath
+	}
+
+	archive := program
+	if !binary.IsTarball(program) {
+		f, err := os.CreateTemp("", "dtt-run-*.tar.gz")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		if err := binary.PackDir(program, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		archive = f.Name()
+	}
+
+	return c.runOnVM(ctx, opts, func(vm *VM) (string, error) {
+		if err := vm.extractArchive(ctx, archive, opts.RemotePath); err != nil {
+			return "", err
+		}
+		if err := vm.uploadStdin(ctx, cmd); err != nil {
+			return "", err
+		}
+		if opts.Stdout != nil {
+			stderr := opts.Stderr
+			if stderr == nil {
+				stderr = opts.Stdout
+			}
+			return "", vm.execBinaryStream(ctx, cmd, opts.Stdout, stderr)
+		}
+		return vm.execBinary(ctx, cmd)
+	})
+}
+
+// RunBundle is Run for a bundle: it creates a VM, lays out the files of
+// bundle on it and runs bundle.Run, if set.
+func (c *Client) RunBundle(ctx context.Context, bundle *binary.Bundle, opts RunOptions) (*Result, error) {
+	if err := bundle.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Via == ViaAgent {
+		return nil, errors.New("running bundles needs SSH, RunOptions.Via can't be ViaAgent")
+	}
+	if opts.Purpose == "" {
+		opts.Purpose = "run bundle"
+		if bundle.Run != "" {
+			opts.Purpose = "run " + bundle.Run
+		}
+	}
+	opts.Tags = append(opts.Tags, RunTag)
+	return c.runOnVM(ctx, opts, func(vm *VM) (string, error) {
+		if err := vm.InstallBundle(ctx, bundle); err != nil {
+			return "", err
+		}
+		if bundle.Run == "" {
+			return "", nil
+		}
+		vm.client.report("run", "running %s on VM %d", bundle.Run, vm.ID)
+		return vm.Exec(ctx, bundle.Run)
+	})
+}
+
+// runOnVM creates the VM of opts, waits until it accepts SSH logins, or its
+// guest agent answers with ViaAgent, and calls run on it, removing the VM
+// afterwards unless opts.Keep is set.
+func (c *Client) runOnVM(ctx context.Context, opts RunOptions, run func(vm *VM) (string, error)) (result *Result, err error) {
+	if err := opts.setRunDefaults(); err != nil {
+		return nil, err
+	}
+
+	vm, err := c.CreateVM(ctx, opts.VMOptions)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Keep {
+		defer func() {
+			// Clean up even when ctx is why we stopped.
+			if destroyErr := vm.Destroy(context.WithoutCancel(ctx)); destroyErr != nil {
+				err = errors.Join(err, fmt.Errorf("removing VM %d: %w", vm.ID, destroyErr))
+			}
+		}()
+	}
+	return vm.runOn(ctx, opts, run)
+}
+
+// setRunDefaults checks opts.Via and fills in the defaults of how to reach
+// the VM.
+func (opts *RunOptions) setRunDefaults() error {
+	switch opts.Via {
+	case "":
+		opts.Via = ViaSSH
+	case ViaSSH, ViaAgent:
+	default:
+		return fmt.Errorf("unknown RunOptions.Via %q, use %q or %q", opts.Via, ViaSSH, ViaAgent)
+	}
+	if opts.IPTimeout == 0 {
+		opts.IPTimeout = DefaultIPTimeout
+	}
+	if opts.SSHTimeout == 0 {
+		opts.SSHTimeout = DefaultSSHTimeout
+	}
+	return nil
+}
+
+// runOn waits until the VM accepts SSH logins, or its guest agent answers
+// with ViaAgent, calls run on it and downloads the files of opts.Collect.
+// The result is there also on errors.
+func (vm *VM) runOn(ctx context.Context, opts RunOptions, run func(vm *VM) (string, error)) (*Result, error) {
+	result := &Result{VM: vm, ExitCode: -1}
+	vm.via = opts.Via
+	if opts.Via == ViaAgent {
+		if err := vm.WaitForAgent(ctx, opts.IPTimeout); err != nil {
+			return result, fmt.Errorf("VM %d did not become ready: %w", vm.ID, err)
+		}
+	} else {
+		if opts.IP != "" {
+			vm.IP = opts.IP
+		}
+		if vm.IP == "" {
+			if _, err := vm.WaitForIP(ctx, opts.IPTimeout); err != nil {
+				return result, err
+			}
+		}
+		if err := vm.WaitForSSH(ctx, opts.SSHTimeout); err != nil {
+			return result, fmt.Errorf("VM %d did not become ready: %w", vm.ID, err)
+		}
+	}
+	var err error
+	result.Output, err = run(vm)
+	if code, ok := sshpkg.ExitCode(err); ok {
+		result.ExitCode = code
+	}
+	// Reports and logs are most wanted when the binary failed.
+	for _, c := range opts.Collect {
+		files, collectErr := vm.Collect(ctx, c.Remote, c.Local)
+		result.Collected = append(result.Collected, files...)
+		if collectErr != nil {
+			err = errors.Join(err, fmt.Errorf("collecting %s: %w", c.Remote, collectErr))
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,305 @@
+package dtt
+
+import (
+	"context"
+	"debug/elf"
+	binarypkg "encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/binary"
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+func TestResolveImage(t *testing.T) {
+	if image, err := ResolveImage("ubuntu"); err != nil || image.Name != proxmox.DefaultImages()[2].Name {
+		t.Errorf("ResolveImage(ubuntu) = %v, %v", image.Name, err)
+	}
+	if _, err := ResolveImage("windows"); err == nil {
+		t.Error("Expected an error for an unknown image")
+	}
+}
+
+func TestCreateAndDestroyVM(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+
+	var phases []string
+	client := NewWithAPI(proxmox.ClientConfig{
+		Node:     "pve",
+		Progress: func(p proxmox.Progress) { phases = append(phases, p.Phase) },
+	}, server.Client())
+	ctx := context.Background()
+
+	vm, err := client.CreateVM(ctx, VMOptions{Image: "ubuntu-24.04", Memory: 1024})
+	if err != nil {
+		t.Fatalf("CreateVM() gave err: %v", err)
+	}
+	if vm.ID != 100 || vm.Name != "dtt-100" {
+		t.Errorf("Expected VM 100 named dtt-100, got %d %q", vm.ID, vm.Name)
+	}
+	created := server.VM(100)
+	if created == nil || created.Status != "running" || created.Config["ciuser"] != DefaultUsername {
+		t.Fatalf("Expected a running cloud-init VM, got %+v", created)
+	}
+	if p, ok := proxmox.ParseProvenance(created.Config["description"].(string)); created.Tags != proxmox.ManagedTag || !ok || p.User != DefaultUsername {
+		t.Errorf("Expected the VM to be tagged and to record its provenance, got tags %q and %+v", created.Tags, created.Config["description"])
+	}
+
+	if err := vm.Destroy(ctx); err != nil {
+		t.Fatalf("Destroy() gave err: %v", err)
+	}
+	if server.VM(100) != nil {
+		t.Error("Expected the VM to be deleted")
+	}
+	if phases[0] != "create" || phases[len(phases)-1] != "destroy" {
+		t.Errorf("Expected create and destroy progress, got %v", phases)
+	}
+}
+
+func TestCreateVMWithTTL(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+	ctx := context.Background()
+
+	if _, err := client.CreateVM(ctx, VMOptions{Image: "ubuntu-24.04", TTL: 2 * time.Hour}); err != nil {
+		t.Fatalf("CreateVM() gave err: %v", err)
+	}
+	if _, err := client.CreateVM(ctx, VMOptions{Image: "ubuntu-24.04"}); err != nil {
+		t.Fatalf("CreateVM() gave err: %v", err)
+	}
+
+	expired, err := client.Proxmox().CollectGarbage(ctx, time.Now().Add(time.Hour), false)
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("Expected nothing to expire within the TTL, got %v, %v", expired, err)
+	}
+	expired, err = client.Proxmox().CollectGarbage(ctx, time.Now().Add(3*time.Hour), false)
+	if err != nil || len(expired) != 1 || expired[0].VMID != 100 {
+		t.Fatalf("Expected VM 100 to expire after its TTL, got %v, %v", expired, err)
+	}
+	if server.VM(100) != nil || server.VM(101) == nil {
+		t.Error("Expected dtt gc to delete the VM with the TTL and keep the one without")
+	}
+}
+
+func TestRunChecksBinary(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	if _, err := client.Run(context.Background(), "/nonexistent/binary", RunOptions{}); err == nil {
+		t.Fatal("Expected an error for a missing binary")
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for a missing binary")
+	}
+}
+
+func TestRunBundleChecksBundle(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	bundle := &binary.Bundle{Files: []binary.BundleFile{{Source: "/nonexistent/server", Destination: "/usr/local/bin/server"}}}
+	if _, err := client.RunBundle(context.Background(), bundle, RunOptions{}); err == nil {
+		t.Fatal("Expected an error for a missing bundle file")
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for an invalid bundle")
+	}
+}
+
+func TestRunViaAgent(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	binaryPath := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\necho ran\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploaded []byte
+	var chmodded bool
+	var tags string
+	server.HandleAgentExec(func(vm *proxmoxtest.VM, command []string) (int, string, string) {
+		tags = vm.Tags
+		if command[0] != "sh" {
+			return 0, "", ""
+		}
+		switch script := command[2]; {
+		case strings.HasPrefix(script, "chmod "):
+			chmodded = command[4] == DefaultRemotePath && command[5] == "755"
+		case strings.Contains(script, `exec "$@" >`):
+			// sh -c <script> sh <stdout> <stderr> <binary>
+			uploaded = vm.Files[command[6]]
+			vm.Files[command[4]] = []byte("ran\n")
+			vm.Files[command[5]] = []byte("warning\n")
+			return 3, "", ""
+		}
+		return 0, "", ""
+	})
+
+	result, err := client.Run(context.Background(), binaryPath, RunOptions{Via: ViaAgent})
+	if code, ok := sshpkg.ExitCode(err); !ok || code != 3 {
+		t.Fatalf("Expected Run() to fail with the exit status of the binary, got %v", err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Output != "ran\nwarning\n" {
+		t.Errorf("Expected the output of the binary, got %q", result.Output)
+	}
+	if string(uploaded) != "#!/bin/sh\necho ran\n" || !chmodded {
+		t.Errorf("Expected the binary to be uploaded executable, got %q (chmod %v)", uploaded, chmodded)
+	}
+	if tags != "dtt;dtt-run" {
+		t.Errorf("Expected the VM to be tagged dtt and dtt-run, got %q", tags)
+	}
+	if server.VM(uint64(result.VM.ID)) != nil {
+		t.Error("Expected the VM to be removed")
+	}
+}
+
+func TestRunUnknownVia(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	binaryPath := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Run(context.Background(), binaryPath, RunOptions{Via: "telnet"}); err == nil {
+		t.Fatal("Expected an error for an unknown Via")
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for an unknown Via")
+	}
+}
+
+func TestBinaryCommand(t *testing.T) {
+	plain := binaryCommand{Path: "/tmp/binary", Args: []string{"-v", "it's"}}
+	if got, want := plain.shell(), `'/tmp/binary' '-v' 'it'\''s'`; got != want {
+		t.Errorf("Expected shell() %s, got %s", want, got)
+	}
+	if got := plain.argv(); !reflect.DeepEqual(got, []string{"/tmp/binary", "-v", "it's"}) {
+		t.Errorf("Expected the agent to run the binary directly, got %q", got)
+	}
+
+	full := binaryCommand{
+		Path:      "/tmp/binary",
+		Args:      []string{"a b"},
+		Env:       []string{"MODE=fast", "GREETING=hello world"},
+		Workdir:   "/srv/data",
+		StdinFile: "input.txt",
+	}
+	want := `cd '/srv/data' && env 'MODE=fast' 'GREETING=hello world' '/tmp/binary' 'a b' < '/tmp/binary.stdin'`
+	if got := full.shell(); got != want {
+		t.Errorf("Expected shell() %s, got %s", want, got)
+	}
+	if got := full.argv(); !reflect.DeepEqual(got, []string{"sh", "-c", want}) {
+		t.Errorf("Expected the agent to run it through sh, got %q", got)
+	}
+}
+
+func TestRunChecksEnvAndStdin(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	binaryPath := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(binaryPath, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []RunOptions{
+		{Env: []string{"NOVALUE"}},
+		{Env: []string{"=value"}},
+		{StdinFile: "/nonexistent/input"},
+	} {
+		if _, err := client.Run(context.Background(), binaryPath, opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for invalid options")
+	}
+}
+
+func TestRunArchiveChecks(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, run := range map[string]func() error{
+		"a missing entrypoint": func() error {
+			_, err := client.RunArchive(context.Background(), dir, "missing", RunOptions{})
+			return err
+		},
+		"ViaAgent": func() error {
+			_, err := client.RunArchive(context.Background(), dir, "mytool", RunOptions{Via: ViaAgent})
+			return err
+		},
+		"an invalid environment": func() error {
+			_, err := client.RunArchive(context.Background(), dir, "mytool", RunOptions{Env: []string{"NOVALUE"}})
+			return err
+		},
+	} {
+		if run() == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for invalid archives")
+	}
+}
+
+// writeELF writes an ELF64 header for machine, without program headers or
+// sections, which makes a static binary for InspectELF.
+func writeELF(t *testing.T, machine elf.Machine) string {
+	header := make([]byte, 64)
+	copy(header, elf.ELFMAG)
+	header[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binarypkg.LittleEndian.PutUint16(header[16:], uint16(elf.ET_EXEC))
+	binarypkg.LittleEndian.PutUint16(header[18:], uint16(machine))
+	binarypkg.LittleEndian.PutUint32(header[20:], uint32(elf.EV_CURRENT))
+	binarypkg.LittleEndian.PutUint16(header[52:], 64) // e_ehsize
+	path := filepath.Join(t.TempDir(), "mytool")
+	if err := os.WriteFile(path, header, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunChecksArch(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	_, err := client.Run(context.Background(), writeELF(t, elf.EM_AARCH64), RunOptions{})
+	if !errors.Is(err, ErrIncompatibleBinary) || !strings.Contains(err.Error(), "arm64") {
+		t.Fatalf("Expected ErrIncompatibleBinary for an arm64 binary, got %v", err)
+	}
+	if len(server.Tasks()) != 0 {
+		t.Error("Expected nothing to be created for an incompatible binary")
+	}
+
+	info, err := checkBinary(writeELF(t, elf.EM_X86_64), "")
+	if err != nil || info == nil || !info.Static {
+		t.Errorf("Expected a static amd64 binary to pass, got %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,62 @@
+package dtt_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/dtt"
+	"github.com/cdevr/dtt/pkg/proxmox"
+)
+
+func ExampleClient_Run() {
+	client := dtt.New(proxmox.ClientConfig{
+		Host:        "pve.example.com",
+		Port:        8006,
+		TokenID:     "root@pam!dtt",
+		TokenSecret: os.Getenv("DTT_PROXMOX_TOKEN_SECRET"),
+		Node:        "pve",
+	})
+
+	result, err := client.Run(context.Background(), "./mytool", dtt.RunOptions{
+		VMOptions: dtt.VMOptions{Image: "ubuntu-24.04", Memory: 2048, Cores: 2},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(result.Output)
+}
+
+func ExampleClient_CreateVM() {
+	client := dtt.New(proxmox.ClientConfig{
+		Host:     "pve.example.com",
+		Port:     8006,
+		Username: "root@pam",
+		Password: os.Getenv("DTT_PROXMOX_PASSWORD"),
+		Node:     "pve",
+		Progress: proxmox.PrintProgress(os.Stderr),
+	})
+	ctx := context.Background()
+
+	vm, err := client.CreateVM(ctx, dtt.VMOptions{Name: "build-box"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer vm.Destroy(ctx)
+
+	if _, err := vm.WaitForIP(ctx, 5*time.Minute); err != nil {
+		log.Fatal(err)
+	}
+	if err := vm.WaitForSSH(ctx, 5*time.Minute); err != nil {
+		log.Fatal(err)
+	}
+	for _, tool := range []string{"./lint", "./test"} {
+		output, err := vm.RunBinary(ctx, tool, "/tmp/"+tool[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(output)
+	}
+}
@@ -0,0 +1,402 @@
+package dtt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest declares the VMs that should exist, see Client.Apply.
+//
+//	machines:
+//	  - name: web
+//	    release: ubuntu-24.04
+//	    memory: 2048
+//	    cores: 2
+//	    ttl: 8h
+//	    networks:
+//	      - virtio,bridge=vmbr0
+//	      - virtio,bridge=vmbr1,tag=20
+//	    cloud_init:
+//	      user: admin
+//	      ssh_public_key: ssh-ed25519 AAAA...
+//	    files:
+//	      - source: ./web.service
+//	        destination: /tmp/web.service
+//	        mode: "0644"
+//	    commands:
+//	      - sudo mv /tmp/web.service /etc/systemd/system/
+type Manifest struct {
+	Machines []Machine `yaml:"machines"`
+}
+
+// Machine is a VM in a Manifest. Machines are matched to VMs by name, or by
+// VMID when set.
+type Machine struct {
+	Name     string `yaml:"name"`
+	VMID     int    `yaml:"vmid,omitempty"`
+	Release  string `yaml:"release,omitempty"` // see Images, DefaultImage unless set
+	Memory   int    `yaml:"memory,omitempty"`  // MB
+	Sockets  int    `yaml:"sockets,omitempty"`
+	Cores    int    `yaml:"cores,omitempty"`
+	DiskSize int    `yaml:"disk_size,omitempty"` // GB
+	Network  string `yaml:"network,omitempty"`
+	// Networks are the network devices net0 and up, instead of Network.
+	Networks []string `yaml:"networks,omitempty"`
+	// TTL, e.g. "2h", lets dtt gc delete the VM once it is this old.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	CloudInit MachineCloudInit `yaml:"cloud_init,omitempty"`
+
+	// Files are uploaded and Commands run, in order, once after the VM is
+	// created.
+	Files    []MachineFile `yaml:"files,omitempty"`
+	Commands []string      `yaml:"commands,omitempty"`
+}
+
+// MachineCloudInit is the cloud-init user of a Machine.
+type MachineCloudInit struct {
+	User         string `yaml:"user,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	SSHPublicKey string `yaml:"ssh_public_key,omitempty"`
+}
+
+// MachineFile is a local file to upload to a Machine.
+type MachineFile struct {
+	Source      string `yaml:"source"` // relative to the manifest
+	Destination string `yaml:"destination"`
+	Mode        string `yaml:"mode,omitempty"` // octal, e.g. "0755"
+}
+
+// LoadManifest reads and checks the manifest at path. Relative file sources
+// are resolved against the directory of the manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range m.Machines {
+		for j, f := range m.Machines[i].Files {
+			if !filepath.IsAbs(f.Source) {
+				m.Machines[i].Files[j].Source = filepath.Join(dir, f.Source)
+			}
+		}
+	}
+	return m, nil
+}
+
+// ParseManifest parses and checks a YAML manifest. Unknown fields are errors
+// so typos don't go unnoticed.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate reports every problem of the manifest at once.
+func (m *Manifest) Validate() error {
+	var v proxmox.Validation
+	names := map[string]bool{}
+	vmids := map[int]bool{}
+	for i, machine := range m.Machines {
+		where := fmt.Sprintf("machine %d", i+1)
+		if machine.Name != "" {
+			where = fmt.Sprintf("machine %q", machine.Name)
+		}
+		if machine.Name == "" {
+			v.Addf("%s: name is required", where)
+		} else if names[machine.Name] {
+			v.Addf("%s: name is used more than once", where)
+		}
+		v.Name(machine.Name)
+		names[machine.Name] = true
+		if machine.VMID != 0 {
+			if vmids[machine.VMID] {
+				v.Addf("%s: VMID %d is used more than once", where, machine.VMID)
+			}
+			vmids[machine.VMID] = true
+		}
+		if machine.Network != "" && len(machine.Networks) > 0 {
+			v.Addf("%s: set network or networks, not both", where)
+		}
+		for _, netdev := range machine.Networks {
+			if proxmox.NetworkBridge(netdev) == "" {
+				v.Addf("%s: network device %q has no bridge, add one like bridge=vmbr0", where, netdev)
+			}
+		}
+		if machine.Release != "" {
+			if _, err := ResolveImage(machine.Release); err != nil {
+				v.Addf("%s: %v", where, err)
+			}
+		}
+		for _, f := range machine.Files {
+			if f.Source == "" || f.Destination == "" {
+				v.Addf("%s: files need a source and a destination", where)
+			}
+			if _, err := f.mode(); err != nil {
+				v.Addf("%s: file %s: %v", where, f.Source, err)
+			}
+		}
+	}
+	if len(m.Machines) == 0 {
+		v.Addf("manifest declares no machines")
+	}
+	return v.Err()
+}
+
+func (f MachineFile) mode() (os.FileMode, error) {
+	if f.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q, want octal like \"0644\"", f.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ApplyTag is the Proxmox tag of the VMs Apply creates, next to
+// proxmox.ManagedTag.
+const ApplyTag = "dtt-apply"
+
+// vmOptions returns the VMOptions creating machine, with defaults set.
+func (machine Machine) vmOptions() VMOptions {
+	opts := VMOptions{
+		Name:         machine.Name,
+		VMID:         machine.VMID,
+		Image:        machine.Release,
+		Memory:       machine.Memory,
+		Sockets:      machine.Sockets,
+		Cores:        machine.Cores,
+		DiskSize:     machine.DiskSize,
+		Network:      machine.Network,
+		Purpose:      "apply " + machine.Name,
+		TTL:          machine.TTL,
+		Tags:         []string{ApplyTag},
+		Username:     machine.CloudInit.User,
+		Password:     machine.CloudInit.Password,
+		SSHPublicKey: machine.CloudInit.SSHPublicKey,
+	}
+	if len(machine.Networks) > 0 {
+		opts.Network, opts.ExtraNetworks = machine.Networks[0], machine.Networks[1:]
+	}
+	opts.setDefaults()
+	return opts
+}
+
+// ActionKind is what Apply or Destroy does to a Machine.
+type ActionKind string
+
+const (
+	ActionCreate    ActionKind = "create"
+	ActionUpdate    ActionKind = "update"
+	ActionUnchanged ActionKind = "unchanged"
+	ActionDestroy   ActionKind = "destroy"
+	ActionAbsent    ActionKind = "absent" // nothing to destroy
+)
+
+// Action is the change to one Machine.
+type Action struct {
+	Kind    ActionKind
+	Machine Machine
+	VMID    int      // 0 for a VM still to be created without a VMID
+	Changes []string // the drifted settings of an update, e.g. "memory 512 -> 1024"
+}
+
+func (a Action) String() string {
+	s := fmt.Sprintf("%s %s", a.Kind, a.Machine.Name)
+	if a.VMID != 0 {
+		s += fmt.Sprintf(" (VM %d)", a.VMID)
+	}
+	if len(a.Changes) > 0 {
+		s += ": " + strings.Join(a.Changes, ", ")
+	}
+	return s
+}
+
+// Plan compares the manifest with the VMs on the node and returns what Apply
+// would do, without changing anything.
+func (c *Client) Plan(ctx context.Context, m *Manifest) ([]Action, error) {
+	existing, err := c.findMachines(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]Action, len(m.Machines))
+	for i, machine := range m.Machines {
+		vmid, ok := existing[machine.Name]
+		if !ok {
+			actions[i] = Action{Kind: ActionCreate, Machine: machine, VMID: machine.VMID}
+			continue
+		}
+
+		current, err := c.proxmox.GetVMSpec(ctx, vmid)
+		if err != nil {
+			return nil, fmt.Errorf("reading VM %d of %s: %w", vmid, machine.Name, err)
+		}
+		want := machine.vmOptions()
+		var changes []string
+		if current.Memory != want.Memory {
+			changes = append(changes, fmt.Sprintf("memory %d -> %d", current.Memory, want.Memory))
+		}
+		if current.CPU != want.Sockets {
+			changes = append(changes, fmt.Sprintf("sockets %d -> %d", current.CPU, want.Sockets))
+		}
+		if current.Cores != want.Cores {
+			changes = append(changes, fmt.Sprintf("cores %d -> %d", current.Cores, want.Cores))
+		}
+		kind := ActionUnchanged
+		if len(changes) > 0 {
+			kind = ActionUpdate
+		}
+		actions[i] = Action{Kind: kind, Machine: machine, VMID: vmid, Changes: changes}
+	}
+	return actions, nil
+}
+
+// Apply reconciles the node with the manifest: missing machines are created,
+// provisioned with their files and commands, and machines whose memory,
+// sockets or cores drifted are updated. Updates to running VMs take effect
+// on their next boot. Apply carries on past a failing machine and returns
+// the actions it took together with every error.
+func (c *Client) Apply(ctx context.Context, m *Manifest) ([]Action, error) {
+	actions, err := c.Plan(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i, action := range actions {
+		switch action.Kind {
+		case ActionCreate:
+			vmid, err := c.createMachine(ctx, action.Machine)
+			actions[i].VMID = vmid
+			if err != nil {
+				errs = append(errs, fmt.Errorf("creating %s: %w", action.Machine.Name, err))
+			}
+		case ActionUpdate:
+			want := action.Machine.vmOptions()
+			c.report("update", "updating VM %d (%s): %s", action.VMID, action.Machine.Name, strings.Join(action.Changes, ", "))
+			err := c.proxmox.UpdateVM(ctx, action.VMID, proxmox.VMSpec{Memory: want.Memory, CPU: want.Sockets, Cores: want.Cores})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("updating %s: %w", action.Machine.Name, err))
+			}
+		}
+	}
+	return actions, errors.Join(errs...)
+}
+
+func (c *Client) createMachine(ctx context.Context, machine Machine) (int, error) {
+	vm, err := c.CreateVM(ctx, machine.vmOptions())
+	if err != nil {
+		return 0, err
+	}
+	if len(machine.Files) == 0 && len(machine.Commands) == 0 {
+		return vm.ID, nil
+	}
+
+	if _, err := vm.WaitForIP(ctx, DefaultIPTimeout); err != nil {
+		return vm.ID, err
+	}
+	if err := vm.WaitForSSH(ctx, DefaultSSHTimeout); err != nil {
+		return vm.ID, err
+	}
+	for _, f := range machine.Files {
+		mode, _ := f.mode()
+		c.report("upload", "uploading %s to %s:%s", f.Source, vm.Name, f.Destination)
+		if err := c.proxmox.UploadFile(ctx, vm.IP, vm.username, vm.password, f.Source, f.Destination, mode); err != nil {
+			return vm.ID, err
+		}
+	}
+	for _, command := range machine.Commands {
+		c.report("run", "running %q on %s", command, vm.Name)
+		if output, err := c.proxmox.ExecuteCommand(ctx, vm.IP, vm.username, vm.password, command); err != nil {
+			return vm.ID, fmt.Errorf("%w\n%s", err, output)
+		}
+	}
+	return vm.ID, nil
+}
+
+// Destroy stops and deletes the VMs of the manifest that exist, carrying on
+// past failures.
+func (c *Client) Destroy(ctx context.Context, m *Manifest) ([]Action, error) {
+	existing, err := c.findMachines(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]Action, len(m.Machines))
+	var errs []error
+	for i, machine := range m.Machines {
+		vmid, ok := existing[machine.Name]
+		if !ok {
+			actions[i] = Action{Kind: ActionAbsent, Machine: machine, VMID: machine.VMID}
+			continue
+		}
+		actions[i] = Action{Kind: ActionDestroy, Machine: machine, VMID: vmid}
+		vm := &VM{ID: vmid, Name: machine.Name, client: c}
+		if err := vm.Destroy(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("destroying %s: %w", machine.Name, err))
+		}
+	}
+	return actions, errors.Join(errs...)
+}
+
+// findMachines returns the VMIDs of the machines of m that exist on the node.
+// A machine with a VMID matches the VM with that ID, whose name has to be
+// the machine name. Other machines match the one VM with their name.
+func (c *Client) findMachines(ctx context.Context, m *Manifest) (map[string]int, error) {
+	vms, err := c.proxmox.ListVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]int{}
+	var v proxmox.Validation
+	for _, machine := range m.Machines {
+		var ids []int
+		for _, vm := range vms {
+			if machine.VMID != 0 && vm.ID == machine.VMID {
+				if vm.Name != machine.Name {
+					v.Addf("machine %q: VM %d is named %q", machine.Name, vm.ID, vm.Name)
+				}
+				ids = []int{vm.ID}
+				break
+			}
+			if machine.VMID == 0 && vm.Name == machine.Name {
+				ids = append(ids, vm.ID)
+			}
+		}
+		switch {
+		case len(ids) > 1:
+			v.Addf("machine %q: several VMs have its name (%v), set a vmid", machine.Name, ids)
+		case len(ids) == 1:
+			found[machine.Name] = ids[0]
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
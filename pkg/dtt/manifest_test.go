@@ -0,0 +1,145 @@
+package dtt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest([]byte(`
+machines:
+  - name: web
+    release: ubuntu
+    memory: 1024
+    files:
+      - source: web.service
+        destination: /tmp/web.service
+        mode: "0644"
+    commands: [uptime]
+  - name: db
+`))
+	if err != nil {
+		t.Fatalf("ParseManifest() gave err: %v", err)
+	}
+	if len(m.Machines) != 2 || m.Machines[0].Memory != 1024 || m.Machines[0].Files[0].Mode != "0644" {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	if mode, _ := m.Machines[0].Files[0].mode(); mode != 0o644 {
+		t.Errorf("Expected mode 0644, got %o", mode)
+	}
+
+	tests := []struct {
+		name, manifest, want string
+	}{
+		{"unknown field", "machines:\n  - name: a\n    colour: red\n", "colour"},
+		{"no machines", "machines: []\n", "no machines"},
+		{"missing name", "machines:\n  - memory: 512\n", "name is required"},
+		{"duplicate name", "machines:\n  - name: a\n  - name: a\n", "more than once"},
+		{"bad release", "machines:\n  - name: a\n    release: windows\n", "windows"},
+		{"network and networks", "machines:\n  - name: a\n    network: virtio,bridge=vmbr0\n    networks: [\"virtio,bridge=vmbr1\"]\n", "not both"},
+		{"network without bridge", "machines:\n  - name: a\n    networks: [virtio]\n", "has no bridge"},
+		{"bad mode", "machines:\n  - name: a\n    files:\n      - {source: x, destination: /x, mode: rwx}\n", "invalid mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(tt.manifest))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadManifestResolvesSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "machines.yaml")
+	manifest := "machines:\n  - name: a\n    files:\n      - {source: app.conf, destination: /etc/app.conf}\n"
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest() gave err: %v", err)
+	}
+	if got := m.Machines[0].Files[0].Source; got != filepath.Join(dir, "app.conf") {
+		t.Errorf("Expected the source next to the manifest, got %s", got)
+	}
+}
+
+func TestApplyAndDestroy(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve").Bridges = []string{"vmbr0", "vmbr1"}
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+	ctx := context.Background()
+
+	m, err := ParseManifest([]byte("machines:\n  - name: web\n    memory: 1024\n    networks: [\"virtio,bridge=vmbr0\", \"virtio,bridge=vmbr1\"]\n  - name: db\n    vmid: 200\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := client.Apply(ctx, m)
+	if err != nil {
+		t.Fatalf("Apply() gave err: %v", err)
+	}
+	if actions[0].Kind != ActionCreate || actions[0].VMID != 100 || actions[1].Kind != ActionCreate || actions[1].VMID != 200 {
+		t.Fatalf("Expected both machines to be created, got %v", actions)
+	}
+	if got := server.VM(100).Config["net1"]; got != "virtio,bridge=vmbr1" {
+		t.Errorf("Expected web to get a second network device on vmbr1, got %v", got)
+	}
+
+	// A second apply finds nothing to do.
+	actions, err = client.Plan(ctx, m)
+	if err != nil {
+		t.Fatalf("Plan() gave err: %v", err)
+	}
+	for _, a := range actions {
+		if a.Kind != ActionUnchanged {
+			t.Errorf("Expected no changes, got %v", a)
+		}
+	}
+
+	// Drift is detected and reconciled.
+	m.Machines[0].Memory = 2048
+	m.Machines[0].Cores = 2
+	actions, err = client.Apply(ctx, m)
+	if err != nil {
+		t.Fatalf("Apply() gave err: %v", err)
+	}
+	if actions[0].Kind != ActionUpdate || len(actions[0].Changes) != 2 || actions[1].Kind != ActionUnchanged {
+		t.Errorf("Expected web to be updated, got %v", actions)
+	}
+	if got := server.VM(100).Config["memory"]; got != "2048" && got != float64(2048) {
+		t.Errorf("Expected memory 2048, got %v", got)
+	}
+
+	actions, err = client.Destroy(ctx, m)
+	if err != nil {
+		t.Fatalf("Destroy() gave err: %v", err)
+	}
+	if actions[0].Kind != ActionDestroy || server.VM(100) != nil || server.VM(200) != nil {
+		t.Errorf("Expected both VMs destroyed, got %v", actions)
+	}
+	if actions, _ = client.Destroy(ctx, m); actions[0].Kind != ActionAbsent {
+		t.Errorf("Expected nothing left to destroy, got %v", actions)
+	}
+}
+
+func TestApplyRejectsAmbiguousNames(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web"})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 101, Name: "web"})
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	m, _ := ParseManifest([]byte("machines:\n  - name: web\n"))
+	if _, err := client.Plan(context.Background(), m); err == nil || !strings.Contains(err.Error(), "set a vmid") {
+		t.Errorf("Expected an ambiguity error, got %v", err)
+	}
+}
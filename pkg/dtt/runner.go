@@ -0,0 +1,148 @@
+package dtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// DefaultRunnerVersion is the GitHub Actions runner release CreateRunner
+// installs unless RunnerOptions.Version is set.
+const DefaultRunnerVersion = "2.321.0"
+
+// RunnerTag is the Proxmox tag of the VMs CreateRunner creates, next to
+// proxmox.ManagedTag.
+const RunnerTag = "dtt-runner"
+
+// RunnerOptions describe a GitHub Actions runner VM. VMOptions.Image defaults
+// to ubuntu-24.04 and VMOptions.Name to "<NamePrefix>-<vmid>", which is also
+// the runner name.
+type RunnerOptions struct {
+	VMOptions
+
+	Repo       string   // "org/repo", or "org" for an organization runner
+	Token      string   // registration token from the runner settings of Repo
+	Labels     []string // added to the default labels self-hosted, linux and x64
+	Ephemeral  bool     // take one job only, then unregister
+	Version    string   // DefaultRunnerVersion unless set
+	NamePrefix string   // "gh-runner" unless set
+}
+
+// CreateRunner creates a VM and installs and registers the GitHub Actions
+// runner on it as a service. The VM is removed again when that fails.
+func (c *Client) CreateRunner(ctx context.Context, opts RunnerOptions) (vm *VM, err error) {
+	if opts.Repo == "" || strings.Count(opts.Repo, "/") > 1 {
+		return nil, fmt.Errorf("invalid repository %q, want org/repo or org", opts.Repo)
+	}
+	if opts.Token == "" {
+		return nil, errors.New("a runner registration token is required")
+	}
+	if opts.Version == "" {
+		opts.Version = DefaultRunnerVersion
+	}
+	if opts.NamePrefix == "" {
+		opts.NamePrefix = "gh-runner"
+	}
+	if opts.Image == "" {
+		opts.Image = "ubuntu-24.04"
+	}
+	if opts.Purpose == "" {
+		opts.Purpose = "runner " + opts.Repo
+	}
+	opts.Tags = append(opts.Tags, RunnerTag)
+	if opts.VMID == 0 {
+		if opts.VMID, err = c.nextVMID(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Name == "" {
+		opts.Name = fmt.Sprintf("%s-%d", opts.NamePrefix, opts.VMID)
+	}
+
+	vm, err = c.CreateVM(ctx, opts.VMOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if destroyErr := vm.Destroy(context.WithoutCancel(ctx)); destroyErr != nil {
+				err = errors.Join(err, fmt.Errorf("removing VM %d: %w", vm.ID, destroyErr))
+			}
+		}
+	}()
+
+	if _, err := vm.WaitForIP(ctx, DefaultIPTimeout); err != nil {
+		return vm, err
+	}
+	if err := vm.WaitForSSH(ctx, DefaultSSHTimeout); err != nil {
+		return vm, err
+	}
+	c.report("runner", "installing runner %s for %s", vm.Name, opts.Repo)
+	if output, err := vm.Exec(ctx, runnerInstallScript(opts, vm.Name)); err != nil {
+		return vm, fmt.Errorf("installing the runner: %w\n%s", err, output)
+	}
+	return vm, nil
+}
+
+// RemoveRunner unregisters the runner on the VM with removeToken, unless it
+// is empty, and destroys the VM. Without a token the runner stays listed as
+// offline on GitHub until it is removed there.
+func (vm *VM) RemoveRunner(ctx context.Context, removeToken string) error {
+	if removeToken != "" {
+		if vm.IP == "" {
+			if _, err := vm.WaitForIP(ctx, time.Minute); err != nil {
+				return fmt.Errorf("unregistering the runner: %w", err)
+			}
+		}
+		vm.client.report("runner", "unregistering runner %s", vm.Name)
+		if output, err := vm.Exec(ctx, runnerRemoveScript(removeToken)); err != nil {
+			return fmt.Errorf("unregistering the runner: %w\n%s", err, output)
+		}
+	}
+	return vm.Destroy(ctx)
+}
+
+// runnerInstallScript downloads, configures and starts the runner as name.
+func runnerInstallScript(opts RunnerOptions, name string) string {
+	tarball := fmt.Sprintf("https://github.com/actions/runner/releases/download/v%s/actions-runner-linux-x64-%s.tar.gz", opts.Version, opts.Version)
+	config := []string{
+		"./config.sh", "--unattended", "--replace",
+		"--url", sshpkg.ShellQuote("https://github.com/" + opts.Repo),
+		"--token", sshpkg.ShellQuote(opts.Token),
+		"--name", sshpkg.ShellQuote(name),
+	}
+	if len(opts.Labels) > 0 {
+		config = append(config, "--labels", sshpkg.ShellQuote(strings.Join(opts.Labels, ",")))
+	}
+	if opts.Ephemeral {
+		config = append(config, "--ephemeral")
+	}
+
+	return strings.Join([]string{
+		"set -e",
+		// Package installs of cloud-init hold the dpkg lock until it is done.
+		"cloud-init status --wait >/dev/null 2>&1 || true",
+		"mkdir -p ~/actions-runner",
+		"cd ~/actions-runner",
+		"curl -fsSL -o runner.tar.gz " + sshpkg.ShellQuote(tarball),
+		"tar xzf runner.tar.gz",
+		"sudo ./bin/installdependencies.sh",
+		strings.Join(config, " "),
+		"sudo ./svc.sh install",
+		"sudo ./svc.sh start",
+	}, "\n")
+}
+
+// runnerRemoveScript stops the runner service and unregisters the runner.
+func runnerRemoveScript(token string) string {
+	return strings.Join([]string{
+		"cd ~/actions-runner",
+		"sudo ./svc.sh stop || true",
+		"sudo ./svc.sh uninstall || true",
+		"./config.sh remove --token " + sshpkg.ShellQuote(token),
+	}, "\n")
+}
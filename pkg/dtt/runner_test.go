@@ -0,0 +1,60 @@
+package dtt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestRunnerInstallScript(t *testing.T) {
+	script := runnerInstallScript(RunnerOptions{
+		Repo:      "acme/widgets",
+		Token:     "AB'CD",
+		Labels:    []string{"proxmox", "big"},
+		Ephemeral: true,
+		Version:   "2.300.0",
+	}, "gh-runner-100")
+
+	for _, want := range []string{
+		"releases/download/v2.300.0/actions-runner-linux-x64-2.300.0.tar.gz",
+		"--url 'https://github.com/acme/widgets'",
+		`--token 'AB'\''CD'`,
+		"--name 'gh-runner-100'",
+		"--labels 'proxmox,big'",
+		"--ephemeral",
+		"sudo ./svc.sh start",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected the install script to contain %q, got:\n%s", want, script)
+		}
+	}
+
+	if script := runnerInstallScript(RunnerOptions{Repo: "acme", Token: "x", Version: "1"}, "r"); strings.Contains(script, "--ephemeral") || strings.Contains(script, "--labels") {
+		t.Errorf("Expected no --ephemeral or --labels by default, got:\n%s", script)
+	}
+	if script := runnerRemoveScript("T"); !strings.Contains(script, "./config.sh remove --token 'T'") {
+		t.Errorf("Expected the remove script to unregister the runner, got:\n%s", script)
+	}
+}
+
+func TestCreateRunnerChecksOptions(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	client := NewWithAPI(proxmox.ClientConfig{Node: "pve"}, server.Client())
+
+	for _, opts := range []RunnerOptions{
+		{Token: "x"},
+		{Repo: "a/b/c", Token: "x"},
+		{Repo: "acme/widgets"},
+	} {
+		if _, err := client.CreateRunner(context.Background(), opts); err == nil {
+			t.Errorf("Expected CreateRunner(%+v) to fail", opts)
+		}
+	}
+	if server.VM(100) != nil {
+		t.Error("Expected no VM to be created for invalid options")
+	}
+}
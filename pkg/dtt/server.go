@@ -0,0 +1,508 @@
+package dtt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	px "github.com/luthermonson/go-proxmox"
+)
+
+// JobStatus is where a Job is in its life.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Job is an operation the server runs in the background.
+type Job struct {
+	ID     string    `json:"id"`
+	Kind   string    `json:"kind"` // "run", "create", "destroy" or "gc"
+	Status JobStatus `json:"status"`
+
+	VMID   int    `json:"vmid,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Log holds the last jobLogLines progress messages of the job.
+	Log []string `json:"log,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Proxmox configures the clients of the jobs, its Progress and TaskLog
+	// are replaced by the log of each job.
+	Proxmox proxmox.ClientConfig
+	// API, if set, is used instead of connecting with Proxmox.
+	API proxmox.ProxmoxAPI
+	// Token, if set, has to be sent as "Authorization: Bearer <token>".
+	Token string
+	// Parallel is how many jobs run at the same time (default 4).
+	Parallel int
+	// MaxUpload is the largest binary accepted, in bytes (default 1 GiB).
+	MaxUpload int64
+	// WorkDir holds uploaded binaries until their job ends (default the
+	// system temporary directory).
+	WorkDir string
+	// GCInterval, unless 0, runs a "gc" job deleting expired VMs this often,
+	// see proxmox.Client.CollectGarbage.
+	GCInterval time.Duration
+	// JobTTL is how long finished jobs are kept for clients to poll
+	// (default 24h).
+	JobTTL time.Duration
+}
+
+// Server exposes the workflow of this package over a JSON REST API. Every
+// request that changes something starts a job and returns it right away
+// with status 202, clients poll the job for the outcome:
+//
+//	POST   /v1/runs             run a binary, a multipart form with the file
+//	                            "binary" and the RunOptions as JSON in "options"
+//	POST   /v1/vms              create a VM, the body is the VMOptions as JSON
+//	DELETE /v1/vms/{vmid}       destroy a VM tagged proxmox.ManagedTag
+//	POST   /v1/gc               delete the VMs whose TTL ran out
+//	GET    /v1/jobs             list the jobs
+//	GET    /v1/jobs/{id}        get a job
+//	POST   /v1/jobs/{id}/cancel cancel a queued or running job
+//	GET    /v1/images           list the images VMOptions.Image can name
+//
+// The VM creating requests take the TTL of the VM as a query parameter, e.g.
+// "?ttl=2h". Jobs are kept in memory until ServerConfig.JobTTL after they
+// finished.
+type Server struct {
+	config ServerConfig
+	mux    *http.ServeMux
+	sem    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewServer returns a server for config. Call Close to stop its jobs.
+func NewServer(config ServerConfig) *Server {
+	if config.Parallel < 1 {
+		config.Parallel = 4
+	}
+	if config.MaxUpload <= 0 {
+		config.MaxUpload = 1 << 30
+	}
+	if config.JobTTL <= 0 {
+		config.JobTTL = 24 * time.Hour
+	}
+	s := &Server{
+		config: config,
+		mux:    http.NewServeMux(),
+		sem:    make(chan struct{}, config.Parallel),
+		jobs:   map[string]*Job{},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.mux.HandleFunc("POST /v1/runs", s.handleRun)
+	s.mux.HandleFunc("POST /v1/vms", s.handleCreate)
+	s.mux.HandleFunc("DELETE /v1/vms/{vmid}", s.handleDestroy)
+	s.mux.HandleFunc("GET /v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("POST /v1/jobs/{id}/cancel", s.handleCancel)
+	s.mux.HandleFunc("POST /v1/gc", s.handleGC)
+	s.mux.HandleFunc("GET /v1/images", s.handleImages)
+
+	if config.GCInterval > 0 {
+		s.wg.Add(1)
+		go s.collectGarbage(config.GCInterval)
+	}
+	return s
+}
+
+func (s *Server) collectGarbage(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.start("gc", s.gc)
+		}
+	}
+}
+
+func (s *Server) gc(ctx context.Context, client *Client, job *Job) error {
+	expired, err := client.Proxmox().CollectGarbage(ctx, time.Now(), false)
+	for _, vm := range expired {
+		s.update(job, func(j *Job) { j.log(fmt.Sprintf("deleted expired %s", vm)) })
+	}
+	return err
+}
+
+// Close cancels the jobs still running and waits for them to end.
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.Token != "" {
+		want := "Bearer " + s.config.Token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUpload)
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading form: %w", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var opts RunOptions
+	if o := r.FormValue("options"); o != "" {
+		if err := decodeStrict([]byte(o), &opts); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing options: %w", err))
+			return
+		}
+	}
+	if opts.TTL, err = queryTTL(r); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Image != "" {
+		if _, err := ResolveImage(opts.Image); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	file, _, err := r.FormFile("binary")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading binary: %w", err))
+		return
+	}
+	defer file.Close()
+	path, err := s.saveBinary(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	job := s.start("run", func(ctx context.Context, client *Client, job *Job) error {
+		defer os.Remove(path)
+		result, err := client.Run(ctx, path, opts)
+		if result != nil {
+			s.update(job, func(j *Job) {
+				j.VMID, j.IP, j.Output = result.VM.ID, result.VM.IP, result.Output
+			})
+		}
+		return err
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) saveBinary(src io.Reader) (string, error) {
+	f, err := os.CreateTemp(s.config.WorkDir, "dtt-binary-*")
+	if err != nil {
+		return "", fmt.Errorf("storing binary: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, src); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("storing binary: %w", err)
+	}
+	if err := f.Chmod(0o755); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("storing binary: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var opts VMOptions
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err == nil && len(body) > 0 {
+		err = decodeStrict(body, &opts)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing VM options: %w", err))
+		return
+	}
+	if opts.TTL, err = queryTTL(r); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Image != "" {
+		if _, err := ResolveImage(opts.Image); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job := s.start("create", func(ctx context.Context, client *Client, job *Job) error {
+		vm, err := client.CreateVM(ctx, opts)
+		if err != nil {
+			return err
+		}
+		s.update(job, func(j *Job) { j.VMID = vm.ID })
+		return nil
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	vmid, err := strconv.Atoi(r.PathValue("vmid"))
+	if err != nil || vmid <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid VMID %q", r.PathValue("vmid")))
+		return
+	}
+
+	// Only the VMs dtt created can be destroyed, not every VM of the cluster.
+	managed, err := s.newClient(s.config.Proxmox).Proxmox().ManagedVMs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("listing the dtt VMs: %w", err))
+		return
+	}
+	if !slices.ContainsFunc(managed, func(vm proxmox.ManagedVM) bool { return vm.VMID == vmid }) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no VM %d tagged %s", vmid, proxmox.ManagedTag))
+		return
+	}
+
+	job := s.start("destroy", func(ctx context.Context, client *Client, job *Job) error {
+		s.update(job, func(j *Job) { j.VMID = vmid })
+		vm := &VM{ID: vmid, client: client}
+		return vm.Destroy(ctx)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusAccepted, s.start("gc", s.gc))
+}
+
+// queryTTL returns the "ttl" query parameter of r, 0 when it is missing.
+func queryTTL(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("ttl")
+	if v == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid ttl %q, want a duration like 2h", v)
+	}
+	return ttl, nil
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	if ok {
+		j.cancel()
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	job, _ := s.Job(r.PathValue("id"))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	type image struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	var images []image
+	for name, img := range Images() {
+		images = append(images, image{Name: name, URL: img.URL})
+	}
+	sort.Slice(images, func(i, k int) bool { return images[i].Name < images[k].Name })
+	writeJSON(w, http.StatusOK, images)
+}
+
+// Job returns a copy of the job with id.
+func (s *Server) Job(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.snapshot(), true
+}
+
+// start queues fn as a new job and returns a copy of the job.
+func (s *Server) start(kind string, fn func(ctx context.Context, client *Client, job *Job) error) Job {
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := &Job{ID: newJobID(), Kind: kind, Status: JobQueued, Created: time.Now(), cancel: cancel}
+
+	config := s.config.Proxmox
+	config.Progress = func(p proxmox.Progress) {
+		if p.Message != "" {
+			s.update(job, func(j *Job) { j.log(p.Message) })
+		}
+	}
+	config.TaskLog = func(task *px.Task, line string) {
+		s.update(job, func(j *Job) { j.log(string(task.UPID) + ": " + line) })
+	}
+	client := s.newClient(config)
+
+	s.mu.Lock()
+	s.evictJobs(job.Created)
+	s.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		select {
+		case s.sem <- struct{}{}:
+			defer func() { <-s.sem }()
+		case <-ctx.Done():
+			s.finish(job, ctx.Err())
+			return
+		}
+
+		now := time.Now()
+		s.update(job, func(j *Job) { j.Status, j.Started = JobRunning, &now })
+		s.finish(job, fn(ctx, client, job))
+	}()
+	return snapshot
+}
+
+func (s *Server) newClient(config proxmox.ClientConfig) *Client {
+	if s.config.API != nil {
+		return NewWithAPI(config, s.config.API)
+	}
+	return New(config)
+}
+
+func (s *Server) finish(job *Job, err error) {
+	now := time.Now()
+	s.update(job, func(j *Job) {
+		j.Finished = &now
+		switch {
+		case err == nil:
+			j.Status = JobSucceeded
+		case errors.Is(err, context.Canceled):
+			j.Status, j.Error = JobCanceled, err.Error()
+		default:
+			j.Status, j.Error = JobFailed, err.Error()
+		}
+	})
+}
+
+// evictJobs forgets the jobs that finished JobTTL before now, the caller
+// holds the server lock.
+func (s *Server) evictJobs(now time.Time) {
+	for id, j := range s.jobs {
+		if j.Finished != nil && now.Sub(*j.Finished) > s.config.JobTTL {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *Server) update(job *Job, fn func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(job)
+}
+
+// jobLogLines is how many lines of its log a job keeps.
+const jobLogLines = 1000
+
+// log adds line to the log of j, dropping the oldest line when it is full.
+// The caller holds the server lock.
+func (j *Job) log(line string) {
+	if len(j.Log) >= jobLogLines {
+		j.Log = append(j.Log[:0], j.Log[len(j.Log)-jobLogLines+1:]...)
+	}
+	j.Log = append(j.Log, line)
+}
+
+// snapshot copies j, the caller holds the server lock.
+func (j *Job) snapshot() Job {
+	c := *j
+	c.Log = append([]string(nil), j.Log...)
+	c.cancel = nil
+	return c
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
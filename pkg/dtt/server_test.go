@@ -0,0 +1,208 @@
+package dtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox"
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *proxmoxtest.Server) {
+	t.Helper()
+	pve := proxmoxtest.NewServer(t)
+	pve.AddNode("pve")
+	s := NewServer(ServerConfig{
+		Proxmox: proxmox.ClientConfig{Node: "pve"},
+		API:     pve.Client(),
+		Token:   "secret",
+	})
+	t.Cleanup(s.Close)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv, pve
+}
+
+func do(t *testing.T, method, url, contentType string, body []byte, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s gave err: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decoding %s %s gave err: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func waitJob(t *testing.T, base, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var job Job
+		if code := do(t, "GET", base+"/v1/jobs/"+id, "", nil, &job); code != http.StatusOK {
+			t.Fatalf("GET job %s gave status %d", id, code)
+		}
+		if job.Finished != nil {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not finish, last state %+v", id, job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerCreateAndDestroyVM(t *testing.T) {
+	srv, pve := newTestServer(t)
+
+	var job Job
+	code := do(t, "POST", srv.URL+"/v1/vms", "application/json", []byte(`{"image":"ubuntu","memory":1024}`), &job)
+	if code != http.StatusAccepted || job.Kind != "create" {
+		t.Fatalf("POST /v1/vms = %d %+v", code, job)
+	}
+	job = waitJob(t, srv.URL, job.ID)
+	if job.Status != JobSucceeded || job.VMID != 100 || len(job.Log) == 0 {
+		t.Fatalf("Expected create to succeed with VM 100 and a log, got %+v", job)
+	}
+	if pve.VM(100) == nil {
+		t.Fatal("Expected VM 100 to exist")
+	}
+
+	code = do(t, "DELETE", srv.URL+"/v1/vms/100", "", nil, &job)
+	if code != http.StatusAccepted {
+		t.Fatalf("DELETE /v1/vms/100 = %d", code)
+	}
+	if job = waitJob(t, srv.URL, job.ID); job.Status != JobSucceeded {
+		t.Fatalf("Expected destroy to succeed, got %+v", job)
+	}
+	if pve.VM(100) != nil {
+		t.Error("Expected VM 100 to be deleted")
+	}
+
+	var jobs []Job
+	if do(t, "GET", srv.URL+"/v1/jobs", "", nil, &jobs); len(jobs) != 2 || jobs[0].Kind != "create" {
+		t.Errorf("Expected the create and destroy jobs, got %+v", jobs)
+	}
+}
+
+func TestServerRejectsBadRequests(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/v1/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", resp.StatusCode)
+	}
+
+	if code := do(t, "POST", srv.URL+"/v1/vms", "application/json", []byte(`{"image":"windows"}`), nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown image, got %d", code)
+	}
+	if code := do(t, "POST", srv.URL+"/v1/vms", "application/json", []byte(`{"colour":"red"}`), nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", code)
+	}
+	if code := do(t, "POST", srv.URL+"/v1/vms?ttl=soon", "application/json", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid ttl, got %d", code)
+	}
+	if code := do(t, "DELETE", srv.URL+"/v1/vms/abc", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid VMID, got %d", code)
+	}
+	if code := do(t, "GET", srv.URL+"/v1/jobs/nope", "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", code)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("options", `{"image":"debian"}`)
+	form.Close()
+	var e map[string]string
+	if code := do(t, "POST", srv.URL+"/v1/runs", form.FormDataContentType(), body.Bytes(), &e); code != http.StatusBadRequest || !strings.Contains(e["error"], "binary") {
+		t.Errorf("Expected 400 for a run without a binary, got %d %v", code, e)
+	}
+}
+
+func TestServerEvictsFinishedJobs(t *testing.T) {
+	s := NewServer(ServerConfig{JobTTL: time.Millisecond})
+	t.Cleanup(s.Close)
+
+	noop := func(ctx context.Context, client *Client, job *Job) error { return nil }
+	first := s.start("gc", noop)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if job, _ := s.Job(first.ID); job.Finished != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first job did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	second := s.start("gc", noop)
+	if _, ok := s.Job(first.ID); ok {
+		t.Error("Expected the finished job to be evicted")
+	}
+	if _, ok := s.Job(second.ID); !ok {
+		t.Error("Expected the new job to be kept")
+	}
+}
+
+func TestServerDestroysOnlyDttVMs(t *testing.T) {
+	srv, pve := newTestServer(t)
+	pve.AddVM(proxmoxtest.VM{Node: "pve", VMID: 200, Name: "prod-db", Status: "running"})
+
+	var e map[string]string
+	if code := do(t, "DELETE", srv.URL+"/v1/vms/200", "", nil, &e); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a VM without the dtt tag, got %d %v", code, e)
+	}
+	if pve.VM(200) == nil {
+		t.Error("Expected VM 200 to be left alone")
+	}
+}
+
+func TestServerRejectsRunIP(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("options", `{"ip":"203.0.113.7"}`)
+	part, _ := form.CreateFormFile("binary", "app")
+	part.Write([]byte("#!/bin/sh\n"))
+	form.Close()
+	if code := do(t, "POST", srv.URL+"/v1/runs", form.FormDataContentType(), body.Bytes(), nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a run with an ip, got %d", code)
+	}
+}
+
+func TestJobLogKeepsTheLastLines(t *testing.T) {
+	var j Job
+	for i := 0; i < jobLogLines+10; i++ {
+		j.log(strconv.Itoa(i))
+	}
+	if len(j.Log) != jobLogLines || j.Log[0] != "10" || j.Log[len(j.Log)-1] != strconv.Itoa(jobLogLines+9) {
+		t.Errorf("Expected lines 10 to %d, got %d lines from %s to %s", jobLogLines+9, len(j.Log), j.Log[0], j.Log[len(j.Log)-1])
+	}
+}
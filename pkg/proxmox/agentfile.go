@@ -0,0 +1,371 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// The guest agent API of Proxmox writes at most 60 KiB of base64, and reads
+// at most 16 MiB of a file at once. Larger files go in chunks, put together
+// and taken apart in the guest with agent exec.
+const agentFileWriteChunk = 45 << 10
+
+var agentFileReadMax = 16 << 20
+
+// agentPollInterval is how often agentExec asks whether a command exited.
+var agentPollInterval = 250 * time.Millisecond
+
+// AgentWriteFile writes data to path in VM vmID through its guest agent, so
+// without SSH or a network path to the VM, and sets its mode unless mode is
+// 0. Files of more than 45 KiB, and setting the mode, need sh, cat and chmod
+// in the guest.
+func (c *Client) AgentWriteFile(ctx context.Context, vmID int, path string, data []byte, mode os.FileMode) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	var script []string
+	if len(data) <= agentFileWriteChunk {
+		if err := c.agentFileWrite(ctx, vmID, path, data); err != nil {
+			return err
+		}
+	} else {
+		// Parts left by a write that failed would end up in the file.
+		removeParts := []string{"sh", "-c", `rm -f "$1".dtt-part-*`, "sh", path}
+		if _, err := c.agentExec(ctx, vmID, removeParts...); err != nil {
+			return fmt.Errorf("writing %s on vm %d: %w", path, vmID, err)
+		}
+		for i := 0; len(data) > 0; i++ {
+			chunk := data[:min(len(data), agentFileWriteChunk)]
+			data = data[len(chunk):]
+			if err := c.agentFileWrite(ctx, vmID, fmt.Sprintf("%s.dtt-part-%06d", path, i), chunk); err != nil {
+				c.agentExec(ctx, vmID, removeParts...)
+				return err
+			}
+		}
+		script = append(script, `cat "$1".dtt-part-* > "$1"`, `rm -f "$1".dtt-part-*`)
+	}
+	if mode != 0 {
+		script = append(script, `chmod "$2" "$1"`)
+	}
+	if len(script) == 0 {
+		return nil
+	}
+	if _, err := c.agentExec(ctx, vmID, "sh", "-c", strings.Join(script, " && "), "sh", path, strconv.FormatUint(uint64(mode.Perm()), 8)); err != nil {
+		return fmt.Errorf("writing %s on vm %d: %w", path, vmID, err)
+	}
+	return nil
+}
+
+func (c *Client) agentFileWrite(ctx context.Context, vmID int, path string, data []byte) error {
+	body := map[string]interface{}{
+		"file":    path,
+		"content": base64.StdEncoding.EncodeToString(data),
+		// The content is base64 already.
+		"encode": 0,
+	}
+	if err := c.apiClient.Post(ctx, c.vmPath(vmID, "agent/file-write"), body, nil); err != nil {
+		return fmt.Errorf("writing %s on vm %d: %w", path, vmID, WrapError(err))
+	}
+	return nil
+}
+
+// AgentReadFile reads path in VM vmID through its guest agent. Files of more
+// than 16 MiB need dd in the guest.
+func (c *Client) AgentReadFile(ctx context.Context, vmID int, path string) ([]byte, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	data, truncated, err := c.agentFileRead(ctx, vmID, path)
+	if err != nil || !truncated {
+		return data, err
+	}
+
+	// Copy the rest a block at a time to a file small enough to read.
+	part := fmt.Sprintf("/tmp/dtt-read-%d", time.Now().UnixNano())
+	defer c.agentExec(ctx, vmID, "rm", "-f", part)
+	for block := 1; ; block++ {
+		if _, err := c.agentExec(ctx, vmID, "dd", "if="+path, "of="+part, "bs="+strconv.Itoa(agentFileReadMax), "skip="+strconv.Itoa(block), "count=1"); err != nil {
+			return nil, fmt.Errorf("reading %s on vm %d: %w", path, vmID, err)
+		}
+		chunk, _, err := c.agentFileRead(ctx, vmID, part)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if len(chunk) < agentFileReadMax {
+			return data, nil
+		}
+	}
+}
+
+// agentFileRead reads at most agentFileReadMax bytes of path, and reports
+// whether there is more.
+func (c *Client) agentFileRead(ctx context.Context, vmID int, path string) ([]byte, bool, error) {
+	var result struct {
+		Content   string `json:"content"`
+		Truncated int    `json:"truncated"`
+	}
+	if err := c.apiClient.Get(ctx, c.vmPath(vmID, "agent/file-read?file="+url.QueryEscape(path)), &result); err != nil {
+		return nil, false, fmt.Errorf("reading %s on vm %d: %w", path, vmID, WrapError(err))
+	}
+	data, err := latin1Bytes(result.Content)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s on vm %d: %w", path, vmID, err)
+	}
+	return data, result.Truncated != 0, nil
+}
+
+// latin1Bytes returns the bytes s holds as latin1 characters, which is how
+// Proxmox returns the content of files the guest agent read.
+func latin1Bytes(s string) ([]byte, error) {
+	data := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, fmt.Errorf("unexpected character %q in file content", r)
+		}
+		data = append(data, byte(r))
+	}
+	return data, nil
+}
+
+// agentExecStatus is what agent/exec-status reports about a command.
+type agentExecStatus struct {
+	Exited   int    `json:"exited"`
+	ExitCode int    `json:"exitcode"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+	// Signal is the signal that killed the command, a number or a bool
+	// depending on the Proxmox version.
+	Signal json.RawMessage `json:"signal"`
+}
+
+// exitError returns the error of a command that exited with status, nil when
+// it exited with 0.
+func (status agentExecStatus) exitError() error {
+	if signal := string(status.Signal); signal != "" && signal != "0" && signal != "false" && signal != "null" {
+		return &sshpkg.ExitError{Code: -1, Signal: signal}
+	}
+	if status.ExitCode != 0 {
+		return &sshpkg.ExitError{Code: status.ExitCode}
+	}
+	return nil
+}
+
+// agentStart starts command in VM vmID through its guest agent, with input
+// on its stdin, and returns its pid.
+func (c *Client) agentStart(ctx context.Context, vmID int, command []string, input string) (int, error) {
+	body := map[string]interface{}{"command": command}
+	if input != "" {
+		body["input-data"] = input
+	}
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := c.apiClient.Post(ctx, c.vmPath(vmID, "agent/exec"), body, &started); err != nil {
+		return 0, fmt.Errorf("running %s on vm %d: %w", command[0], vmID, WrapError(err))
+	}
+	return started.PID, nil
+}
+
+func (c *Client) agentStatus(ctx context.Context, vmID, pid int) (agentExecStatus, error) {
+	var status agentExecStatus
+	if err := c.apiClient.Get(ctx, c.vmPath(vmID, fmt.Sprintf("agent/exec-status?pid=%d", pid)), &status); err != nil {
+		return status, fmt.Errorf("getting the status of pid %d on vm %d: %w", pid, vmID, WrapError(err))
+	}
+	return status, nil
+}
+
+// agentExec runs command in VM vmID through its guest agent and waits for it,
+// for at most 5 minutes. It returns the output of command, and an error with
+// its stderr when it fails.
+func (c *Client) agentExec(ctx context.Context, vmID int, command ...string) (string, error) {
+	pid, err := c.agentStart(ctx, vmID, command, "")
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	for {
+		status, err := c.agentStatus(ctx, vmID, pid)
+		if err != nil {
+			return "", err
+		}
+		if status.Exited != 0 {
+			if err := status.exitError(); err != nil {
+				return status.OutData, fmt.Errorf("%s: %w: %s", command[0], err, strings.TrimSpace(status.ErrData))
+			}
+			return status.OutData, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for %s on vm %d: %w", command[0], vmID, ctx.Err())
+		case <-time.After(agentPollInterval):
+		}
+	}
+}
+
+// agentStreamInterval is how often AgentStream copies new output.
+var agentStreamInterval = time.Second
+
+// AgentStream runs command in VM vmID through its guest agent, with input on
+// its stdin, copying its output to stdout and stderr while it runs, until it
+// exits or ctx is done. A command exiting with a non-zero status returns an
+// *sshpkg.ExitError, like ExecuteStream.
+//
+// The guest agent only hands out the output of exited commands, so it goes
+// to files in /tmp of the guest, read as they grow. That needs sh in the
+// guest.
+func (c *Client) AgentStream(ctx context.Context, vmID int, command []string, input string, stdout, stderr io.Writer) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	base := fmt.Sprintf("/tmp/dtt-exec-%d", time.Now().UnixNano())
+	outputs := []struct {
+		path    string
+		w       io.Writer
+		written int
+	}{{path: base + ".out", w: stdout}, {path: base + ".err", w: stderr}}
+	wrapped := append([]string{"sh", "-c", `out=$1 err=$2 && shift 2 && exec "$@" >"$out" 2>"$err"`, "sh", outputs[0].path, outputs[1].path}, command...)
+
+	pid, err := c.agentStart(ctx, vmID, wrapped, input)
+	if err != nil {
+		return err
+	}
+	defer c.agentStart(context.WithoutCancel(ctx), vmID, []string{"rm", "-f", outputs[0].path, outputs[1].path}, "")
+
+	// copyOutput copies what was added to the output files, which may not
+	// exist yet until the command exited.
+	copyOutput := func(exited bool) error {
+		for i := range outputs {
+			o := &outputs[i]
+			data, err := c.AgentReadFile(ctx, vmID, o.path)
+			if err != nil {
+				if exited {
+					return err
+				}
+				continue
+			}
+			if len(data) > o.written {
+				if _, err := o.w.Write(data[o.written:]); err != nil {
+					return err
+				}
+				o.written = len(data)
+			}
+		}
+		return nil
+	}
+
+	for {
+		status, err := c.agentStatus(ctx, vmID, pid)
+		if err != nil {
+			return err
+		}
+		if err := copyOutput(status.Exited != 0); err != nil {
+			return err
+		}
+		if status.Exited != 0 {
+			return status.exitError()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pid %d on vm %d still runs: %w", pid, vmID, ctx.Err())
+		case <-time.After(agentStreamInterval):
+		}
+	}
+}
+
+// WaitForAgent waits until the guest agent of VM vmID answers, for at most
+// timeout.
+func (c *Client) WaitForAgent(ctx context.Context, vmID int, timeout time.Duration) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := c.apiClient.Post(ctx, c.vmPath(vmID, "agent/ping"), nil, nil)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the guest agent of vm %d did not answer within %s: %w", vmID, timeout, WrapError(err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// WaitForCloudInit waits until cloud-init in VM vmID is done, running
+// cloud-init status --wait through its guest agent, until ctx is done. It
+// returns an error with what cloud-init printed when it failed. Exit status
+// 2, done with recoverable errors such as deprecated keys, counts as done.
+func (c *Client) WaitForCloudInit(ctx context.Context, vmID int) error {
+	var output bytes.Buffer
+	err := c.AgentStream(ctx, vmID, []string{"cloud-init", "status", "--wait", "--long"}, "", &output, &output)
+	var exitErr *sshpkg.ExitError
+	if errors.As(err, &exitErr) && exitErr.Code == 2 {
+		return nil
+	}
+	if err != nil && output.Len() > 0 {
+		return fmt.Errorf("cloud-init status: %w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return err
+}
+
+// AgentDownloadFiles is DownloadFiles through the guest agent of VM vmID.
+// Expanding pattern needs sh in the guest.
+func (c *Client) AgentDownloadFiles(ctx context.Context, vmID int, pattern string, localDir string) ([]string, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	output, err := c.agentExec(ctx, vmID, "sh", "-c", globCommand(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", pattern, err)
+	}
+	var remotes []string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			remotes = append(remotes, line)
+		}
+	}
+	if len(remotes) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var locals []string
+	for _, remote := range remotes {
+		data, err := c.AgentReadFile(ctx, vmID, remote)
+		if err != nil {
+			return locals, fmt.Errorf("failed to download %s: %w", remote, err)
+		}
+		local := filepath.Join(localDir, path.Base(remote))
+		if err := os.WriteFile(local, data, 0o644); err != nil {
+			return locals, err
+		}
+		locals = append(locals, local)
+	}
+	return locals, nil
+}
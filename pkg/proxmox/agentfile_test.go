@@ -0,0 +1,235 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// fakeGuest runs the commands the agent file and exec functions run in the
+// guest on the files of the fake VM, recording the modes set in modes.
+func fakeGuest(modes map[string]string) func(vm *proxmoxtest.VM, command []string) (int, string, string) {
+	return func(vm *proxmoxtest.VM, command []string) (int, string, string) {
+		switch command[0] {
+		case "sh":
+			script := command[2]
+			if pattern, ok := strings.CutPrefix(script, "for f in "); ok {
+				pattern, _, _ = strings.Cut(pattern, ";")
+				var matches []string
+				for name := range vm.Files {
+					if ok, _ := path.Match(pattern, name); ok {
+						matches = append(matches, name+"\n")
+					}
+				}
+				sort.Strings(matches)
+				return 0, strings.Join(matches, ""), ""
+			}
+			if strings.Contains(script, `exec "$@" >`) {
+				// AgentStream runs echo, false and cloud-init with their
+				// output in files. cloud-init reads its status from the
+				// cloud-init-status file.
+				stdout, stderr, args := command[4], command[5], command[6:]
+				switch args[0] {
+				case "cloud-init":
+					code, _ := strconv.Atoi(string(vm.Files["cloud-init-status"]))
+					vm.Files[stdout] = []byte("status: done\n")
+					vm.Files[stderr] = nil
+					return code, "", ""
+				case "echo":
+					vm.Files[stdout] = []byte(strings.Join(args[1:], " ") + "\n")
+					vm.Files[stderr] = nil
+					return 0, "", ""
+				case "false":
+					vm.Files[stdout] = nil
+					vm.Files[stderr] = []byte("failed\n")
+					return 1, "", ""
+				}
+				return 127, "", ""
+			}
+			path := command[4]
+			var parts []string
+			for name := range vm.Files {
+				if strings.HasPrefix(name, path+".dtt-part-") {
+					parts = append(parts, name)
+				}
+			}
+			sort.Strings(parts)
+			if strings.Contains(script, "cat ") {
+				var data []byte
+				for _, part := range parts {
+					data = append(data, vm.Files[part]...)
+				}
+				vm.Files[path] = data
+			}
+			if strings.Contains(script, "rm -f ") {
+				for _, part := range parts {
+					delete(vm.Files, part)
+				}
+			}
+			if strings.Contains(script, "chmod ") {
+				modes[path] = command[5]
+			}
+		case "dd":
+			args := map[string]string{}
+			for _, arg := range command[1:] {
+				k, v, _ := strings.Cut(arg, "=")
+				args[k] = v
+			}
+			data, ok := vm.Files[args["if"]]
+			if !ok {
+				return 1, "", "dd: can't open '" + args["if"] + "': No such file or directory"
+			}
+			bs, _ := strconv.Atoi(args["bs"])
+			skip, _ := strconv.Atoi(args["skip"])
+			start := min(len(data), bs*skip)
+			vm.Files[args["of"]] = bytes.Clone(data[start:min(len(data), start+bs)])
+		case "rm":
+			for _, name := range command[2:] {
+				delete(vm.Files, name)
+			}
+		default:
+			return 127, "", command[0] + ": not found"
+		}
+		return 0, "", ""
+	}
+}
+
+func TestAgentFiles(t *testing.T) {
+	ctx := context.Background()
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 140, Name: "dtt-agent", Status: "running"})
+	modes := map[string]string{}
+	server.HandleAgentExec(fakeGuest(modes))
+
+	if err := client.AgentWriteFile(ctx, 140, "/tmp/small", []byte("hello\n"), 0o755); err != nil {
+		t.Fatalf("AgentWriteFile() of a small file gave err: %v", err)
+	}
+	if got := string(server.VM(140).Files["/tmp/small"]); got != "hello\n" {
+		t.Errorf("Expected /tmp/small to hold %q, got %q", "hello\n", got)
+	}
+	if modes["/tmp/small"] != "755" {
+		t.Errorf("Expected /tmp/small to get mode 755, got %q", modes["/tmp/small"])
+	}
+
+	// Every byte value, in more chunks than fit in one write or read.
+	large := make([]byte, 3*agentFileWriteChunk+1000)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := client.AgentWriteFile(ctx, 140, "/tmp/large", large, 0); err != nil {
+		t.Fatalf("AgentWriteFile() of a large file gave err: %v", err)
+	}
+	files := server.VM(140).Files
+	if !bytes.Equal(files["/tmp/large"], large) {
+		t.Errorf("Expected /tmp/large to hold the %d bytes written, got %d bytes", len(large), len(files["/tmp/large"]))
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected only the written files to be left, got %d files", len(files))
+	}
+
+	defer func(max int) { agentFileReadMax = max }(agentFileReadMax)
+	agentFileReadMax = 40000
+	server.SetAgentFileReadMax(agentFileReadMax)
+
+	for path, want := range map[string][]byte{"/tmp/small": []byte("hello\n"), "/tmp/large": large} {
+		got, err := client.AgentReadFile(ctx, 140, path)
+		if err != nil {
+			t.Fatalf("AgentReadFile(%s) gave err: %v", path, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected AgentReadFile(%s) to return the %d bytes written, got %d bytes", path, len(want), len(got))
+		}
+	}
+	if files := server.VM(140).Files; len(files) != 2 {
+		t.Errorf("Expected reading to leave no files behind, got %d files", len(files))
+	}
+
+	if _, err := client.AgentReadFile(ctx, 140, "/tmp/missing"); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("Expected reading a missing file to fail, got %v", err)
+	}
+}
+
+func TestAgentFilesStoppedVM(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 141, Name: "dtt-stopped"})
+
+	if err := client.AgentWriteFile(context.Background(), 141, "/tmp/x", []byte("x"), 0); err == nil {
+		t.Error("Expected writing to a stopped VM to fail")
+	}
+}
+
+func TestAgentStream(t *testing.T) {
+	ctx := context.Background()
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 142, Name: "dtt-stream", Status: "running"})
+	server.HandleAgentExec(fakeGuest(map[string]string{}))
+
+	var stdout, stderr bytes.Buffer
+	if err := client.AgentStream(ctx, 142, []string{"echo", "hello", "world"}, "", &stdout, &stderr); err != nil {
+		t.Fatalf("AgentStream() gave err: %v", err)
+	}
+	if stdout.String() != "hello world\n" || stderr.Len() != 0 {
+		t.Errorf("Expected the output of echo, got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	err := client.AgentStream(ctx, 142, []string{"false"}, "", &stdout, &stderr)
+	var exitErr *sshpkg.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Errorf("Expected false to exit with 1, got %v", err)
+	}
+	if stderr.String() != "failed\n" {
+		t.Errorf("Expected the stderr of false, got %q", stderr.String())
+	}
+	if files := server.VM(142).Files; len(files) != 0 {
+		t.Errorf("Expected the output files to be removed, got %d files", len(files))
+	}
+}
+
+func TestAgentDownloadFiles(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 143, Name: "dtt-collect", Status: "running", Files: map[string][]byte{
+		"/tmp/report.xml": []byte("<testsuites/>"),
+		"/tmp/cover.out":  []byte("mode: set"),
+	}})
+	server.HandleAgentExec(fakeGuest(map[string]string{}))
+
+	dir := t.TempDir()
+	locals, err := client.AgentDownloadFiles(context.Background(), 143, "/tmp/*.xml", dir)
+	if err != nil {
+		t.Fatalf("AgentDownloadFiles() gave err: %v", err)
+	}
+	if len(locals) != 1 || locals[0] != filepath.Join(dir, "report.xml") {
+		t.Fatalf("Expected report.xml to be downloaded, got %v", locals)
+	}
+	if data, _ := os.ReadFile(locals[0]); string(data) != "<testsuites/>" {
+		t.Errorf("Expected the content of report.xml, got %q", data)
+	}
+}
+
+func TestWaitForCloudInit(t *testing.T) {
+	ctx := context.Background()
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 144, Name: "dtt-ci", Status: "running", Files: map[string][]byte{}})
+	server.HandleAgentExec(fakeGuest(map[string]string{}))
+
+	for code, wantErr := range map[string]bool{"0": false, "2": false, "1": true} {
+		server.VM(144).Files["cloud-init-status"] = []byte(code)
+		err := client.WaitForCloudInit(ctx, 144)
+		if (err != nil) != wantErr {
+			t.Errorf("Expected WaitForCloudInit() with cloud-init exiting %s to fail: %v, got %v", code, wantErr, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "status: done") {
+			t.Errorf("Expected the error to hold what cloud-init printed, got %v", err)
+		}
+	}
+}
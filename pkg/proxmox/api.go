@@ -0,0 +1,38 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// ProxmoxAPI is the part of the go-proxmox client dtt depends on. It is
+// implemented by *proxmox.Client; tests use a client pointed at a
+// proxmoxtest.Server.
+type ProxmoxAPI interface {
+	Version(ctx context.Context) (*proxmox.Version, error)
+	Nodes(ctx context.Context) (proxmox.NodeStatuses, error)
+	Node(ctx context.Context, name string) (*proxmox.Node, error)
+	Cluster(ctx context.Context) (*proxmox.Cluster, error)
+
+	Get(ctx context.Context, p string, v interface{}) error
+	Post(ctx context.Context, p string, d interface{}, v interface{}) error
+	Put(ctx context.Context, p string, d interface{}, v interface{}) error
+	Delete(ctx context.Context, p string, v interface{}) error
+	Upload(path string, fields map[string]string, file *os.File, v interface{}) error
+}
+
+var _ ProxmoxAPI = (*proxmox.Client)(nil)
+
+// NewTask returns the task with upid, for the calls go-proxmox returns a UPID
+// for instead of a task, like downloading an appliance template. api has to
+// be a *proxmox.Client.
+func NewTask(api ProxmoxAPI, upid string) (*proxmox.Task, error) {
+	client, ok := api.(*proxmox.Client)
+	if !ok {
+		return nil, fmt.Errorf("waiting for task %s needs a *proxmox.Client, got %T", upid, api)
+	}
+	return proxmox.NewTask(proxmox.UPID(upid), client), nil
+}
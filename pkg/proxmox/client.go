@@ -4,13 +4,21 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	sshpkg "github.com/cdevr/dtt/pkg/ssh"
 	proxmox "github.com/luthermonson/go-proxmox"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // ClientConfig contains configuration for Proxmox API client
@@ -24,45 +32,98 @@ type ClientConfig struct {
 	Realm       string
 	Node        string
 	Insecure    bool
-	SSHUser     string // SSH username for Proxmox host (for image operations)
+	SSHUser     string // SSH username for Proxmox host (only for ImportOverSSH and the deprecated SSH helpers)
 	SSHPassword string // SSH password for Proxmox host
 	SSHPort     int    // SSH port (default 22)
+	// SSHPrivateKey is the path of a private key to log in to the Proxmox host
+	// with, as most hosts don't allow root to log in with a password.
+	// SSHPassphrase decrypts it. Without a key or password the keys of the
+	// running ssh-agent are used.
+	SSHPrivateKey string
+	SSHPassphrase string
+	// SSHHostKeyCallback checks the host key of the Proxmox host, such as
+	// ssh.TrustOnFirstUse from pkg/ssh. Without one the SSH helpers refuse
+	// to connect.
+	SSHHostKeyCallback gossh.HostKeyCallback
+
+	// VMSSHPrivateKey is the path of a private key to log in to VMs with,
+	// tried before their password, such as the key of VMSpec.SSHPublicKey.
+	VMSSHPrivateKey string
+	// VMSSHHostKeyCallback checks the host keys of VMs, such as
+	// ssh.PinnedHostKeys with the keys their serial console shows, or
+	// ssh.TrustOnFirstUse. Without one ssh.TrustFirstKey is used, so a VM
+	// answers with the same key on every connection of the client.
+	VMSSHHostKeyCallback gossh.HostKeyCallback
+
+	// ImportOverSSH makes CreateVM download the cloud image on the Proxmox
+	// host and import it with qm over SSH, instead of through the import
+	// content of ImageStorage, for storages or Proxmox versions without it.
+	ImportOverSSH bool
+
+	ImageStorage string // storage with import content for cloud images and the cloud-init drive (default "local")
+	DiskStorage  string // storage for VM disks (default "local-lvm")
+
+	RetryAttempts int           // tries per API request for transient failures (default 3, 1 disables retries)
+	RetryBackoff  time.Duration // wait before the first retry, doubled for every next one (default 500ms)
+
+	RateLimit float64 // API requests per second, 0 means no limit
+	RateBurst int     // requests allowed at once before RateLimit applies (default 1)
+
+	Progress ProgressFunc // receives image download progress, nil discards it
+	TaskLog  TaskLogFunc  // receives the log lines of the tasks the client waits on, nil discards them
 }
 
 // Client represents a Proxmox API client
 type Client struct {
 	config    ClientConfig
-	apiClient *proxmox.Client
+	apiClient ProxmoxAPI
 	node      *proxmox.Node
 }
 
 // NewClient creates a new Proxmox client
 func NewClient(config ClientConfig) *Client {
+	if config.VMSSHHostKeyCallback == nil {
+		config.VMSSHHostKeyCallback = sshpkg.TrustFirstKey()
+	}
 	return &Client{
 		config: config,
 	}
 }
 
+// NewClientWithAPI creates a client that uses api instead of connecting
+// itself, for example to talk to a proxmoxtest.Server.
+func NewClientWithAPI(config ClientConfig, api ProxmoxAPI) *Client {
+	if config.VMSSHHostKeyCallback == nil {
+		config.VMSSHHostKeyCallback = sshpkg.TrustFirstKey()
+	}
+	return &Client{
+		config:    config,
+		apiClient: api,
+	}
+}
+
 // APIClient returns the APIClient
-func (c *Client) APIClient() *proxmox.Client {
+func (c *Client) APIClient() ProxmoxAPI {
 	return c.apiClient
 }
 
 // Connect establishes a connection to the Proxmox server
-func (c *Client) Connect() error {
+func (c *Client) Connect(ctx context.Context) error {
 	if c.apiClient != nil {
 		return nil // Already connected
 	}
 
-	ctx := context.Background()
-
 	// Create HTTP client with optional insecure TLS
+	attempts := c.config.RetryAttempts
+	if attempts == 0 {
+		attempts = DefaultRetryAttempts
+	}
 	httpClient := &http.Client{
-		Transport: &http.Transport{
+		Transport: NewRetryTransport(NewRateLimitTransport(&http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: c.config.Insecure,
 			},
-		},
+		}, c.config.RateLimit, c.config.RateBurst), attempts, c.config.RetryBackoff),
 	}
 
 	// Build Proxmox server URL
@@ -91,7 +152,7 @@ func (c *Client) Connect() error {
 		client = proxmox.NewClient(serverURL, proxmox.WithHTTPClient(httpClient))
 		err = client.Login(ctx, c.config.Username, c.config.Password)
 		if err != nil {
-			return fmt.Errorf("failed to login to Proxmox: %w", err)
+			return fmt.Errorf("failed to login to Proxmox: %w", WrapError(err))
 		}
 	} else {
 		return fmt.Errorf("no authentication credentials provided")
@@ -103,27 +164,32 @@ func (c *Client) Connect() error {
 	// Test the connection by getting the version
 	version, err := client.Version(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get Proxmox version (connection test failed): %w", err)
+		return fmt.Errorf("failed to get Proxmox version (connection test failed): %w", WrapError(err))
 	}
 	slog.Debug("Connected to Proxmox", "version", version.Version)
 
 	return nil
 }
 
+// waitTask waits for a task started by the client, passing its log to the
+// configured TaskLog.
+func (c *Client) waitTask(ctx context.Context, task *proxmox.Task, timeout time.Duration) error {
+	return WaitTaskLog(ctx, task, time.Second, timeout, c.config.TaskLog)
+}
+
 // GetNode gets the Proxmox node, fetching it if necessary
-func (c *Client) GetNode() (*proxmox.Node, error) {
+func (c *Client) GetNode(ctx context.Context) (*proxmox.Node, error) {
 	if c.node != nil {
 		return c.node, nil
 	}
 
 	if c.apiClient == nil {
-		return nil, fmt.Errorf("client not connected")
+		return nil, ErrNotConnected
 	}
 
-	ctx := context.Background()
 	node, err := c.apiClient.Node(ctx, c.config.Node)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get node '%s': %w", c.config.Node, err)
+		return nil, fmt.Errorf("failed to get node '%s': %w", c.config.Node, WrapError(err))
 	}
 
 	c.node = node
@@ -132,13 +198,17 @@ func (c *Client) GetNode() (*proxmox.Node, error) {
 
 // Image represents a VM image available on the Proxmox server
 type Image struct {
-	Name     string
-	OS       string
-	Version  string
-	LocalID  string // Storage location ID in Proxmox
-	URL      string // Download URL if not present
-	Size     uint64 // Size in bytes
-	Checksum string
+	Name     string `json:"name" yaml:"name"`
+	OS       string `json:"os" yaml:"os"`
+	Version  string `json:"version" yaml:"version"`
+	LocalID  string `json:"local_id,omitempty" yaml:"local_id,omitempty"` // Storage location ID in Proxmox
+	URL      string `json:"url" yaml:"url"`                               // Download URL if not present
+	Size     uint64 `json:"size,omitempty" yaml:"size,omitempty"`         // Size in bytes
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	// Arch, by its GOARCH name, and GLIBC, the glibc version of the image,
+	// tell which binaries run on it.
+	Arch  string `json:"arch,omitempty" yaml:"arch,omitempty"`
+	GLIBC string `json:"glibc,omitempty" yaml:"glibc,omitempty"`
 }
 
 // DefaultImages returns common image options
@@ -149,6 +219,8 @@ func DefaultImages() []Image {
 			OS:      "debian",
 			Version: "11",
 			URL:     "https://cloud.debian.org/images/cloud/bullseye/latest/debian-11-generic-amd64.qcow2",
+			Arch:    "amd64",
+			GLIBC:   "2.31",
 			Size:    0, // Will be fetched during download
 		},
 		{
@@ -156,6 +228,8 @@ func DefaultImages() []Image {
 			OS:      "debian",
 			Version: "13",
 			URL:     "https://cloud.debian.org/images/cloud/trixie/latest/debian-trixie-generic-amd64.qcow2",
+			Arch:    "amd64",
+			GLIBC:   "2.41",
 			Size:    0,
 		},
 		{
@@ -163,11 +237,16 @@ func DefaultImages() []Image {
 			OS:      "ubuntu",
 			Version: "24.04",
 			URL:     "https://cloud-images.ubuntu.com/noble/current/noble-server-cloudimg-amd64.img",
+			Arch:    "amd64",
+			GLIBC:   "2.39",
 			Size:    0,
 		},
 	}
 }
 
+// defaultNetwork is the network device of VMs without VMSpec.Network.
+const defaultNetwork = "virtio,bridge=vmbr0"
+
 // VMSpec defines the virtual machine specification
 type VMSpec struct {
 	Name      string
@@ -180,6 +259,16 @@ type VMSpec struct {
 	DiskSize  int // Size in GB
 	CloudInit bool
 	Network   string // Network configuration
+	// ExtraNetworks are network devices net1 and up, after Network.
+	ExtraNetworks []string
+
+	Description string   // notes, see Provenance
+	Tags        []string // Proxmox tags, e.g. ManagedTag
+
+	// Cloud-init credentials, Username and Password default to "dtt".
+	Username     string
+	Password     string
+	SSHPublicKey string
 }
 
 // VM represents a virtual machine on Proxmox
@@ -194,24 +283,22 @@ type VM struct {
 	Modified time.Time
 }
 
-// CreateVM creates a new virtual machine with the given specification
-func (c *Client) CreateVM(vmSpec VMSpec) (*VM, error) {
-	if vmSpec.VMID <= 0 {
-		return nil, fmt.Errorf("invalid VM ID: must be greater than 0")
-	}
-
-	// Ensure we're connected
-	if err := c.Connect(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Proxmox: %w", err)
+// CreateVM creates and starts a new virtual machine with the given
+// specification using only the Proxmox API. The cloud image is downloaded into
+// the import content of ImageStorage by the node itself and attached with
+// import-from, so no SSH access to the hypervisor is needed, unless
+// ImportOverSSH is set.
+func (c *Client) CreateVM(ctx context.Context, vmSpec VMSpec) (*VM, error) {
+	// Catch bad parameters before anything is created.
+	if err := c.ValidateVMSpec(ctx, vmSpec); err != nil {
+		return nil, err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
-
 	// Check if VM already exists
 	existingVM, err := node.VirtualMachine(ctx, vmSpec.VMID)
 	if err == nil && existingVM != nil {
@@ -228,128 +315,131 @@ func (c *Client) CreateVM(vmSpec VMSpec) (*VM, error) {
 		}, nil
 	}
 
-	// Create VM with cloud-init configuration
-	fmt.Printf("Creating VM with cloud-init...\n")
-
-	// Step 1: Download the cloud image if we have SSH access to Proxmox host
-	var imagePath string
-	storage := "local-lvm" // Default storage
+	imageStorage := c.imageStorage()
+	diskStorage := c.diskStorage()
 
-	if c.config.SSHUser != "" && c.config.SSHPassword != "" && vmSpec.Image.URL != "" {
-		var downloadErr error
-		imagePath, downloadErr = c.DownloadImageToNode(vmSpec.Image, c.config.SSHUser, c.config.SSHPassword)
-		if downloadErr != nil {
-			fmt.Printf("Warning: Failed to download image: %v\n", downloadErr)
-			fmt.Printf("VM will be created without a boot disk\n")
+	// Step 1: Make sure the cloud image is available for import on the node
+	importVolID, imagePath := "", ""
+	if vmSpec.Image.URL != "" && c.config.ImportOverSSH {
+		imagePath, err = c.DownloadImageToNode(ctx, vmSpec.Image, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare image: %w", err)
+		}
+	} else if vmSpec.Image.URL != "" {
+		importVolID, err = c.EnsureImage(ctx, vmSpec.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare image: %w", WrapError(err))
 		}
 	}
 
-	// Create the VM using SSH and qm commands instead of Proxmox API
-	// The API seems to have issues with VM creation
-	fmt.Printf("Creating VM using qm command...\n")
+	// Step 2: Create the VM
+	slog.Info("creating VM", "vmid", vmSpec.VMID)
 
-	if c.config.SSHUser == "" || c.config.SSHPassword == "" {
-		return nil, fmt.Errorf("SSH credentials required to create VM")
+	network := vmSpec.Network
+	if network == "" {
+		network = defaultNetwork
+	}
+	sockets := vmSpec.CPU
+	if sockets <= 0 {
+		sockets = 1
+	}
+	opts := []proxmox.VirtualMachineOption{
+		{Name: "name", Value: vmSpec.Name},
+		{Name: "memory", Value: vmSpec.Memory},
+		{Name: "cores", Value: vmSpec.Cores},
+		{Name: "sockets", Value: sockets},
+		{Name: "ostype", Value: "l26"},
+		{Name: "scsihw", Value: "virtio-scsi-pci"},
+		{Name: "net0", Value: network},
+		{Name: "serial0", Value: "socket"},
+		{Name: "vga", Value: "serial0"},
+		{Name: "agent", Value: "enabled=1"},
+	}
+	for i, netdev := range vmSpec.ExtraNetworks {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: fmt.Sprintf("net%d", i+1), Value: netdev})
+	}
+	if vmSpec.Description != "" {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "description", Value: vmSpec.Description})
+	}
+	if len(vmSpec.Tags) > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "tags", Value: strings.Join(vmSpec.Tags, ";")})
 	}
 
-	sshConfig := sshpkg.Config{
-		Host:     c.config.Host,
-		Port:     22,
-		Username: c.config.SSHUser,
-		Password: c.config.SSHPassword,
-		Timeout:  30 * time.Second,
+	task, err := node.NewVirtualMachine(ctx, vmSpec.VMID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM: %w", WrapError(err))
+	}
+	if err := c.waitTask(ctx, task, 2*time.Minute); err != nil {
+		return nil, fmt.Errorf("failed waiting for VM creation: %w", err)
 	}
 
-	sshClient := sshpkg.NewClient(sshConfig)
-	if err := sshClient.Connect(); err != nil {
-		return nil, fmt.Errorf("failed to SSH to Proxmox host: %w", err)
+	vm, err := node.VirtualMachine(ctx, vmSpec.VMID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get created VM: %w", WrapError(err))
 	}
-	defer sshClient.Close()
 
-	// Create VM with qm create
-	createCmd := fmt.Sprintf("qm create %d --name %s --memory %d --cores %d --sockets %d --ostype l26 --scsihw virtio-scsi-pci --net0 virtio,bridge=vmbr0 --serial0 socket --vga serial0 --agent enabled=1",
-		vmSpec.VMID, vmSpec.Name, vmSpec.Memory, vmSpec.Cores, vmSpec.CPU)
-
-	fmt.Printf("Running: %s\n", createCmd)
-	output, err := sshClient.Execute(createCmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create VM: %w\nOutput: %s", err, output)
-	}
-	fmt.Printf("VM created successfully\n")
-
-	// Step 2: Import and attach the boot disk if we downloaded an image
-	if imagePath != "" && c.config.SSHUser != "" {
-		fmt.Printf("\nImporting cloud image as boot disk...\n")
-
-		// Import the disk
-		if err := c.ImportDiskToVM(vmSpec.VMID, imagePath, storage, c.config.SSHUser, c.config.SSHPassword); err != nil {
-			fmt.Printf("Warning: Failed to import disk: %v\n", err)
-			fmt.Printf("VM created but may not have a boot disk\n")
-		} else {
-			// Attach the disk
-			if err := c.AttachDiskToVM(vmSpec.VMID, storage, c.config.SSHUser, c.config.SSHPassword); err != nil {
-				fmt.Printf("Warning: Failed to attach disk: %v\n", err)
-			} else {
-				// Step 3: Add cloud-init configuration now that disk is attached
-				if vmSpec.CloudInit {
-					fmt.Printf("\nConfiguring cloud-init...\n")
-					if err := c.ConfigureCloudInit(vmSpec.VMID, c.config.SSHUser, c.config.SSHPassword); err != nil {
-						fmt.Printf("Warning: Failed to configure cloud-init: %v\n", err)
-					}
-				}
-			}
+	// Step 3: Import the boot disk and add the cloud-init drive
+	var configOpts []proxmox.VirtualMachineOption
+	if importVolID != "" {
+		configOpts = append(configOpts,
+			proxmox.VirtualMachineOption{Name: "scsi0", Value: fmt.Sprintf("%s:0,import-from=%s", diskStorage, importVolID)},
+			proxmox.VirtualMachineOption{Name: "boot", Value: "order=scsi0"},
+		)
+	}
+	if imagePath != "" {
+		diskVolID, err := c.importDiskOverSSH(ctx, vmSpec.VMID, imagePath, diskStorage)
+		if err != nil {
+			return nil, err
 		}
+		configOpts = append(configOpts,
+			proxmox.VirtualMachineOption{Name: "scsi0", Value: diskVolID},
+			proxmox.VirtualMachineOption{Name: "boot", Value: "order=scsi0"},
+		)
 	}
-
-	// Step 3: Start the VM using SSH command (more reliable than API)
-	fmt.Printf("\nStarting VM %d...\n", vmSpec.VMID)
-
-	if c.config.SSHUser != "" && c.config.SSHPassword != "" {
-		sshConfig := sshpkg.Config{
-			Host:     c.config.Host,
-			Port:     22,
-			Username: c.config.SSHUser,
-			Password: c.config.SSHPassword,
-			Timeout:  30 * time.Second,
+	if vmSpec.CloudInit {
+		configOpts = append(configOpts, cloudInitOptions(imageStorage, vmSpec)...)
+	}
+	if len(configOpts) > 0 {
+		slog.Info("configuring boot disk and cloud-init", "vmid", vmSpec.VMID)
+		task, err := vm.Config(ctx, configOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure VM: %w", WrapError(err))
 		}
-
-		sshClient := sshpkg.NewClient(sshConfig)
-		if err := sshClient.Connect(); err == nil {
-			defer sshClient.Close()
-
-			// Start the VM
-			startCmd := fmt.Sprintf("qm start %d", vmSpec.VMID)
-			fmt.Printf("Running: %s\n", startCmd)
-			startOutput, startErr := sshClient.Execute(startCmd)
-			if startErr != nil {
-				fmt.Printf("Warning: Failed to start VM via qm: %v\nOutput: %s\n", startErr, startOutput)
-			} else {
-				fmt.Printf("VM start command executed successfully\n")
-			}
+		// Importing the disk copies the whole image, give it time.
+		if err := c.waitTask(ctx, task, 15*time.Minute); err != nil {
+			return nil, fmt.Errorf("failed waiting for VM configuration: %w", err)
 		}
 	}
 
-	// Give the VM a moment to start
-	time.Sleep(3 * time.Second)
+	if importVolID != "" || imagePath != "" {
+		diskSize := "+10G"
+		if vmSpec.DiskSize > 0 {
+			diskSize = fmt.Sprintf("%dG", vmSpec.DiskSize)
+		}
+		task, err := vm.ResizeDisk(ctx, "scsi0", diskSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resize boot disk: %w", WrapError(err))
+		}
+		if err := c.waitTask(ctx, task, 2*time.Minute); err != nil {
+			return nil, fmt.Errorf("failed waiting for boot disk resize: %w", err)
+		}
+	}
 
-	// Get the created VM
-	vm, err := node.VirtualMachine(ctx, vmSpec.VMID)
+	// Step 4: Start the VM
+	slog.Info("starting VM", "vmid", vmSpec.VMID)
+	startTask, err := vm.Start(ctx)
 	if err != nil {
-		// VM might still be starting, return success anyway
-		fmt.Printf("Note: VM created but status check failed: %v\n", err)
-		return &VM{
-			ID:       vmSpec.VMID,
-			Name:     vmSpec.Name,
-			Status:   "created",
-			Memory:   vmSpec.Memory,
-			CPU:      vmSpec.CPU,
-			Node:     c.config.Node,
-			Created:  time.Now(),
-			Modified: time.Now(),
-		}, nil
+		return nil, fmt.Errorf("failed to start VM: %w", WrapError(err))
+	}
+	if err := c.waitTask(ctx, startTask, 2*time.Minute); err != nil {
+		return nil, fmt.Errorf("failed waiting for VM start: %w", err)
 	}
 
-	fmt.Printf("VM is now running\n")
+	if err := vm.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to refresh VM status: %w", WrapError(err))
+	}
+
+	slog.Info("VM is running", "vmid", vmSpec.VMID)
 
 	return &VM{
 		ID:       vmSpec.VMID,
@@ -363,25 +453,152 @@ func (c *Client) CreateVM(vmSpec VMSpec) (*VM, error) {
 	}, nil
 }
 
+// cloudInitOptions returns the cloud-init drive and settings for vmSpec,
+// defaulting the user and password to dtt.
+func cloudInitOptions(storage string, vmSpec VMSpec) []proxmox.VirtualMachineOption {
+	user := vmSpec.Username
+	if user == "" {
+		user = "dtt"
+	}
+	password := vmSpec.Password
+	if password == "" {
+		password = "dtt"
+	}
+
+	opts := []proxmox.VirtualMachineOption{
+		{Name: "ide2", Value: fmt.Sprintf("%s:cloudinit", storage)},
+		{Name: "ipconfig0", Value: "ip=dhcp"},
+		{Name: "ciuser", Value: user},
+		{Name: "cipassword", Value: password},
+	}
+	if key := strings.TrimSpace(vmSpec.SSHPublicKey); key != "" {
+		// The API wants the keys URL encoded, with spaces as %20.
+		enc := strings.ReplaceAll(url.QueryEscape(key), "+", "%20")
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "sshkeys", Value: enc})
+	}
+	return opts
+}
+
+// imageFilename returns the name image is stored under in the import content
+// of a storage. Proxmox only imports files with a known disk image extension,
+// so Ubuntu's .img cloud images are stored as .qcow2.
+func imageFilename(image Image) (string, error) {
+	parsed, err := url.Parse(image.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid image URL %q: %w", image.URL, err)
+	}
+	filename := path.Base(parsed.Path)
+	if filename == "" || filename == "." || filename == "/" {
+		return "", fmt.Errorf("no filename in image URL %q", image.URL)
+	}
+	return strings.ReplaceAll(filename, ".img", ".qcow2"), nil
+}
+
+// ImageVolID returns the volume ID of image in the import content of
+// storageID, as EnsureImage returns it.
+func ImageVolID(image Image, storageID string) (string, error) {
+	filename, err := imageFilename(image)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:import/%s", storageID, filename), nil
+}
+
+// EnsureImage makes sure image is present in the import content of the image
+// storage, letting the node download it when missing. It returns the volume
+// ID to use with import-from.
+func (c *Client) EnsureImage(ctx context.Context, image Image) (string, error) {
+	return c.ensureImageOn(ctx, image, c.imageStorage())
+}
+
+func (c *Client) ensureImageOn(ctx context.Context, image Image, storageID string) (string, error) {
+	if image.URL == "" {
+		return "", fmt.Errorf("image URL is required for download")
+	}
+
+	filename, err := imageFilename(image)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.Connect(ctx); err != nil {
+		return "", err
+	}
+
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	storage, err := node.Storage(ctx, storageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get storage %q: %w", storageID, err)
+	}
+
+	volID := fmt.Sprintf("%s:import/%s", storageID, filename)
+	progress := c.config.Progress
+
+	content, err := storage.GetContent(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list storage %q content: %w", storageID, err)
+	}
+	for _, item := range content {
+		if item.Volid == volID {
+			progress.report(Progress{Phase: "done", Message: fmt.Sprintf("Image %s already present on %s", filename, storageID), Percent: 100})
+			return volID, nil
+		}
+	}
+
+	progress.report(Progress{
+		Phase:   "download",
+		Message: fmt.Sprintf("Downloading cloud image to %s on node %s...\n  URL: %s", storageID, c.config.Node, image.URL),
+		Percent: -1,
+	})
+
+	task, err := storage.DownloadURL(ctx, "import", filename, image.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to start image download: %w", WrapError(err))
+	}
+	if err := WaitTaskLog(ctx, task, time.Second, 30*time.Minute, MultiTaskLog(c.config.TaskLog, TaskLogProgress("download", progress))); err != nil {
+		return "", fmt.Errorf("failed waiting for image download: %w", err)
+	}
+
+	progress.report(Progress{Phase: "done", Message: fmt.Sprintf("Downloaded %s", filename), Percent: 100})
+	return volID, nil
+}
+
+func (c *Client) imageStorage() string {
+	if c.config.ImageStorage != "" {
+		return c.config.ImageStorage
+	}
+	return "local"
+}
+
+func (c *Client) diskStorage() string {
+	if c.config.DiskStorage != "" {
+		return c.config.DiskStorage
+	}
+	return "local-lvm"
+}
+
 // GetVM retrieves a virtual machine by ID
-func (c *Client) GetVM(vmID int) (*VM, error) {
+func (c *Client) GetVM(ctx context.Context, vmID int) (*VM, error) {
 	if vmID <= 0 {
 		return nil, fmt.Errorf("invalid VM ID: must be greater than 0")
 	}
 
-	if err := c.Connect(); err != nil {
+	if err := c.Connect(ctx); err != nil {
 		return nil, err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
 	vm, err := node.VirtualMachine(ctx, vmID)
 	if err != nil {
-		return nil, fmt.Errorf("VM not found: %w", err)
+		return nil, vmLookupError(vmID, err)
 	}
 
 	return &VM{
@@ -396,108 +613,190 @@ func (c *Client) GetVM(vmID int) (*VM, error) {
 	}, nil
 }
 
+// GetVMSpec returns the configured name, memory, sockets, cores and
+// cloud-init user of a VM. The image and disk fields are left empty.
+func (c *Client) GetVMSpec(ctx context.Context, vmID int) (*VMSpec, error) {
+	if vmID <= 0 {
+		return nil, fmt.Errorf("invalid VM ID: must be greater than 0")
+	}
+
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := node.VirtualMachine(ctx, vmID)
+	if err != nil {
+		return nil, vmLookupError(vmID, err)
+	}
+
+	config := vm.VirtualMachineConfig
+	if config == nil {
+		return nil, fmt.Errorf("VM %d has no configuration", vmID)
+	}
+	// Proxmox leaves defaults out of the configuration.
+	spec := &VMSpec{
+		Name:      config.Name,
+		VMID:      vmID,
+		Memory:    512,
+		CPU:       1,
+		Cores:     1,
+		CloudInit: config.CIUser != "",
+		Username:  config.CIUser,
+	}
+	if config.Memory > 0 {
+		spec.Memory = int(config.Memory)
+	}
+	if config.Sockets > 0 {
+		spec.CPU = config.Sockets
+	}
+	if config.Cores > 0 {
+		spec.Cores = config.Cores
+	}
+	return spec, nil
+}
+
+// UpdateVM sets the memory, sockets and cores of a VM to those of vmSpec,
+// zero fields are left alone. A running VM applies them on its next boot.
+func (c *Client) UpdateVM(ctx context.Context, vmID int, vmSpec VMSpec) error {
+	var opts []proxmox.VirtualMachineOption
+	if vmSpec.Memory > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "memory", Value: vmSpec.Memory})
+	}
+	if vmSpec.CPU > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "sockets", Value: vmSpec.CPU})
+	}
+	if vmSpec.Cores > 0 {
+		opts = append(opts, proxmox.VirtualMachineOption{Name: "cores", Value: vmSpec.Cores})
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	vm, err := node.VirtualMachine(ctx, vmID)
+	if err != nil {
+		return vmLookupError(vmID, err)
+	}
+
+	task, err := vm.Config(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to update VM: %w", WrapError(err))
+	}
+
+	return c.waitTask(ctx, task, time.Minute)
+}
+
 // StartVM starts a stopped virtual machine
-func (c *Client) StartVM(vmID int) error {
+func (c *Client) StartVM(ctx context.Context, vmID int) error {
 	if vmID <= 0 {
 		return fmt.Errorf("invalid VM ID: must be greater than 0")
 	}
 
-	if err := c.Connect(); err != nil {
+	if err := c.Connect(ctx); err != nil {
 		return err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
 	vm, err := node.VirtualMachine(ctx, vmID)
 	if err != nil {
-		return fmt.Errorf("VM not found: %w", err)
+		return vmLookupError(vmID, err)
 	}
 
 	task, err := vm.Start(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start VM: %w", err)
+		return fmt.Errorf("failed to start VM: %w", WrapError(err))
 	}
 
-	return task.Wait(ctx, 5, 60)
+	return c.waitTask(ctx, task, time.Minute)
 }
 
 // StopVM stops a running virtual machine
-func (c *Client) StopVM(vmID int) error {
+func (c *Client) StopVM(ctx context.Context, vmID int) error {
 	if vmID <= 0 {
 		return fmt.Errorf("invalid VM ID: must be greater than 0")
 	}
 
-	if err := c.Connect(); err != nil {
+	if err := c.Connect(ctx); err != nil {
 		return err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
 	vm, err := node.VirtualMachine(ctx, vmID)
 	if err != nil {
-		return fmt.Errorf("VM not found: %w", err)
+		return vmLookupError(vmID, err)
 	}
 
 	task, err := vm.Stop(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to stop VM: %w", err)
+		return fmt.Errorf("failed to stop VM: %w", WrapError(err))
 	}
 
-	return task.Wait(ctx, 5, 60)
+	return c.waitTask(ctx, task, time.Minute)
 }
 
 // DeleteVM deletes a virtual machine
-func (c *Client) DeleteVM(vmID int) error {
+func (c *Client) DeleteVM(ctx context.Context, vmID int) error {
 	if vmID <= 0 {
 		return fmt.Errorf("invalid VM ID: must be greater than 0")
 	}
 
-	if err := c.Connect(); err != nil {
+	if err := c.Connect(ctx); err != nil {
 		return err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
 	vm, err := node.VirtualMachine(ctx, vmID)
 	if err != nil {
-		return fmt.Errorf("VM not found: %w", err)
+		return vmLookupError(vmID, err)
 	}
 
 	task, err := vm.Delete(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to delete VM: %w", err)
+		return fmt.Errorf("failed to delete VM: %w", WrapError(err))
 	}
 
-	return task.Wait(ctx, 5, 60)
+	return c.waitTask(ctx, task, time.Minute)
 }
 
 // ListVMs lists all virtual machines on the node
-func (c *Client) ListVMs() ([]VM, error) {
-	if err := c.Connect(); err != nil {
+func (c *Client) ListVMs(ctx context.Context) ([]VM, error) {
+	if err := c.Connect(ctx); err != nil {
 		return nil, err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
 	vms, err := node.VirtualMachines(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list VMs: %w", err)
+		return nil, fmt.Errorf("failed to list VMs: %w", WrapError(err))
 	}
 
 	result := make([]VM, len(vms))
@@ -517,8 +816,40 @@ func (c *Client) ListVMs() ([]VM, error) {
 	return result, nil
 }
 
-// DownloadImageToNode downloads a cloud image to the Proxmox node via SSH
-func (c *Client) DownloadImageToNode(image Image, sshUser, sshPassword string) (string, error) {
+// hostSSHConfig returns the SSH config to log in to the Proxmox host with.
+// sshUser and sshPassword win over the SSHUser and SSHPassword of the client
+// config, the private key of the client config is used with both.
+func (c *Client) hostSSHConfig(sshUser, sshPassword string) sshpkg.Config {
+	if sshUser == "" {
+		sshUser = c.config.SSHUser
+	}
+	if sshUser == "" {
+		sshUser = "root"
+	}
+	if sshPassword == "" {
+		sshPassword = c.config.SSHPassword
+	}
+	port := c.config.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	return sshpkg.Config{
+		Host:       c.config.Host,
+		Port:       port,
+		Username:   sshUser,
+		Password:   sshPassword,
+		PrivateKey: c.config.SSHPrivateKey,
+		Passphrase: c.config.SSHPassphrase,
+		Timeout:    30 * time.Second,
+
+		HostKeyCallback: c.config.SSHHostKeyCallback,
+	}
+}
+
+// DownloadImageToNode downloads a cloud image to the Proxmox node via SSH and
+// returns its path there. CreateVM uses it with ImportOverSSH, use
+// EnsureImage to download through the API.
+func (c *Client) DownloadImageToNode(ctx context.Context, image Image, sshUser, sshPassword string) (string, error) {
 	if image.URL == "" {
 		return "", fmt.Errorf("image URL is required for download")
 	}
@@ -528,141 +859,152 @@ func (c *Client) DownloadImageToNode(image Image, sshUser, sshPassword string) (
 	filename := parts[len(parts)-1]
 	downloadPath := fmt.Sprintf("/tmp/%s", filename)
 
-	fmt.Printf("Downloading cloud image to Proxmox node...\n")
-	fmt.Printf("  URL: %s\n", image.URL)
-	fmt.Printf("  Destination: %s\n", downloadPath)
+	progress := c.config.Progress
+	progress.report(Progress{
+		Phase:   "check",
+		Message: fmt.Sprintf("Downloading cloud image to Proxmox node...\n  URL: %s\n  Destination: %s", image.URL, downloadPath),
+		Percent: -1,
+	})
 
 	// Connect via SSH to the Proxmox host
-	sshConfig := sshpkg.Config{
-		Host:     c.config.Host,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
-		Timeout:  30 * time.Second,
-	}
-
-	sshClient := sshpkg.NewClient(sshConfig)
-	if err := sshClient.Connect(); err != nil {
+	sshClient := sshpkg.NewClient(c.hostSSHConfig(sshUser, sshPassword))
+	if err := sshClient.ConnectContext(ctx); err != nil {
 		return "", fmt.Errorf("failed to SSH to Proxmox host: %w", err)
 	}
 	defer sshClient.Close()
 
 	// Check if image already exists on Proxmox host and is valid
-	fmt.Printf("Checking for existing image...\n")
-	checkOutput, _ := sshClient.Execute(fmt.Sprintf("test -f %s && echo 'EXISTS' || echo 'NOT_EXISTS'", downloadPath))
+	progress.report(Progress{Phase: "check", Message: "Checking for existing image...", Percent: -1})
+	checkOutput, _ := executeContext(ctx, sshClient, fmt.Sprintf("test -f %s && echo 'EXISTS' || echo 'NOT_EXISTS'", downloadPath))
 	fileExists := strings.Contains(checkOutput, "EXISTS")
 
 	if fileExists {
-		fmt.Printf("Image file found, verifying integrity...\n")
-		verifyOutput, verifyErr := sshClient.Execute(fmt.Sprintf("qemu-img info %s 2>&1", downloadPath))
+		progress.report(Progress{Phase: "verify", Message: "Image file found, verifying integrity...", Percent: -1})
+		verifyOutput, verifyErr := executeContext(ctx, sshClient, fmt.Sprintf("qemu-img info %s 2>&1", downloadPath))
 		if verifyErr == nil && strings.Contains(verifyOutput, "virtual size") {
-			fmt.Printf("Valid image already exists on Proxmox host, skipping download\n")
-			sizeOutput, _ := sshClient.Execute(fmt.Sprintf("ls -lh %s | awk '{print $5}'", downloadPath))
-			fmt.Printf("Using existing image (%s)\n", strings.TrimSpace(sizeOutput))
+			progress.report(Progress{Phase: "verify", Message: "Valid image already exists on Proxmox host, skipping download", Percent: -1})
+			sizeOutput, _ := executeContext(ctx, sshClient, fmt.Sprintf("ls -lh %s | awk '{print $5}'", downloadPath))
+			progress.report(Progress{Phase: "done", Message: fmt.Sprintf("Using existing image (%s)", strings.TrimSpace(sizeOutput)), Percent: 100})
 			return downloadPath, nil
 		}
 		// File exists but is invalid, delete it
-		fmt.Printf("Existing image is invalid, removing...\n")
-		sshClient.Execute(fmt.Sprintf("rm -f %s", downloadPath))
+		progress.report(Progress{Phase: "check", Message: "Existing image is invalid, removing...", Percent: -1})
+		executeContext(ctx, sshClient, fmt.Sprintf("rm -f %s", downloadPath))
 	}
 
 	// Download the image using curl (should work now that DNS is fixed)
-	fmt.Printf("Downloading cloud image (this may take several minutes for ~600MB file)...\n")
+	progress.report(Progress{Phase: "download", Message: "Downloading cloud image (this may take several minutes for ~600MB file)...", Percent: 0})
 	downloadCmd := fmt.Sprintf("curl -L --insecure --progress-bar -o %s %s 2>&1", downloadPath, image.URL)
-	fmt.Printf("Running: %s\n", downloadCmd)
+	progress.report(Progress{Phase: "download", Message: fmt.Sprintf("Running: %s", downloadCmd), Percent: -1})
 
-	output, err := sshClient.Execute(downloadCmd)
+	output, err := executeContext(ctx, sshClient, downloadCmd)
 	if err != nil {
-		sshClient.Execute(fmt.Sprintf("rm -f %s", downloadPath))
+		executeContext(ctx, sshClient, fmt.Sprintf("rm -f %s", downloadPath))
 		return "", fmt.Errorf("failed to download image with curl: %w\nOutput: %s\nPlease ensure Proxmox host has internet access and DNS resolution", err, output)
 	}
 
 	// Show download output
 	if output != "" {
-		fmt.Printf("Download output: %s\n", output)
+		progress.report(Progress{Phase: "download", Message: fmt.Sprintf("Download output: %s", output), Percent: 100})
 	}
 
 	// Verify the downloaded file is a valid qcow2 image
-	fmt.Printf("Verifying downloaded image...\n")
-	verifyOutput, err := sshClient.Execute(fmt.Sprintf("qemu-img info %s", downloadPath))
+	progress.report(Progress{Phase: "verify", Message: "Verifying downloaded image...", Percent: -1})
+	verifyOutput, err := executeContext(ctx, sshClient, fmt.Sprintf("qemu-img info %s", downloadPath))
 	if err != nil {
-		sshClient.Execute(fmt.Sprintf("rm -f %s", downloadPath))
+		executeContext(ctx, sshClient, fmt.Sprintf("rm -f %s", downloadPath))
 		return "", fmt.Errorf("downloaded image is invalid: %w\nOutput: %s", err, verifyOutput)
 	}
 
 	// Check if we got the virtual size
 	if !strings.Contains(verifyOutput, "virtual size") {
-		sshClient.Execute(fmt.Sprintf("rm -f %s", downloadPath))
+		executeContext(ctx, sshClient, fmt.Sprintf("rm -f %s", downloadPath))
 		return "", fmt.Errorf("downloaded image appears to be corrupted (no virtual size)")
 	}
 
 	// Get file size for confirmation
-	sizeOutput, _ := sshClient.Execute(fmt.Sprintf("ls -lh %s | awk '{print $5}'", downloadPath))
-	fmt.Printf("Downloaded and verified successfully (%s)\n", strings.TrimSpace(sizeOutput))
+	sizeOutput, _ := executeContext(ctx, sshClient, fmt.Sprintf("ls -lh %s | awk '{print $5}'", downloadPath))
+	progress.report(Progress{Phase: "done", Message: fmt.Sprintf("Downloaded and verified successfully (%s)", strings.TrimSpace(sizeOutput)), Percent: 100})
 	return downloadPath, nil
 }
 
-// ImportDiskToVM imports a disk image to a VM
-func (c *Client) ImportDiskToVM(vmID int, imagePath string, storage string, sshUser, sshPassword string) error {
-	fmt.Printf("Importing disk to VM %d...\n", vmID)
+// importedDiskRe matches the volume qm importdisk reports the disk under.
+var importedDiskRe = regexp.MustCompile(`successfully imported disk '([^']+)'`)
 
-	// Connect via SSH to the Proxmox host
-	sshConfig := sshpkg.Config{
-		Host:     c.config.Host,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
-		Timeout:  30 * time.Second,
+// importDiskOverSSH imports the disk image at imagePath on the Proxmox host
+// into storage as an unused disk of VM vmID with qm, and returns its volume ID.
+func (c *Client) importDiskOverSSH(ctx context.Context, vmID int, imagePath string, storage string) (string, error) {
+	sshClient := sshpkg.NewClient(c.hostSSHConfig("", ""))
+	if err := sshClient.ConnectContext(ctx); err != nil {
+		return "", fmt.Errorf("failed to SSH to Proxmox host: %w", err)
+	}
+	defer sshClient.Close()
+
+	c.config.Progress.report(Progress{Phase: "import", Message: fmt.Sprintf("Importing %s into VM %d...", imagePath, vmID), Percent: -1})
+	output, err := executeContext(ctx, sshClient, fmt.Sprintf("qm importdisk %d %s %s", vmID, imagePath, storage))
+	if err != nil {
+		return "", fmt.Errorf("failed to import disk: %w\nOutput: %s", err, output)
+	}
+	return importedVolume(output)
+}
+
+// importedVolume returns the volume ID in the output of qm importdisk.
+func importedVolume(output string) (string, error) {
+	m := importedDiskRe.FindAllStringSubmatch(output, -1)
+	if len(m) == 0 {
+		return "", fmt.Errorf("no imported disk in the output of qm importdisk: %s", strings.TrimSpace(output))
 	}
+	return m[len(m)-1][1], nil
+}
 
-	sshClient := sshpkg.NewClient(sshConfig)
-	if err := sshClient.Connect(); err != nil {
+// ImportDiskToVM imports a disk image to a VM
+//
+// Deprecated: CreateVM imports the disk through the API with import-from.
+func (c *Client) ImportDiskToVM(ctx context.Context, vmID int, imagePath string, storage string, sshUser, sshPassword string) error {
+	slog.Info("importing disk", "vmid", vmID)
+
+	// Connect via SSH to the Proxmox host
+	sshClient := sshpkg.NewClient(c.hostSSHConfig(sshUser, sshPassword))
+	if err := sshClient.ConnectContext(ctx); err != nil {
 		return fmt.Errorf("failed to SSH to Proxmox host: %w", err)
 	}
 	defer sshClient.Close()
 
 	// Convert qcow2 to raw format for more reliable import
 	rawPath := strings.Replace(imagePath, ".qcow2", ".raw", 1)
-	fmt.Printf("Converting qcow2 to raw format...\n")
+	slog.Info("converting qcow2 to raw format", "vmid", vmID)
 	convertCmd := fmt.Sprintf("qemu-img convert -f qcow2 -O raw %s %s", imagePath, rawPath)
-	convertOutput, convertErr := sshClient.Execute(convertCmd)
+	convertOutput, convertErr := executeContext(ctx, sshClient, convertCmd)
 	if convertErr != nil {
 		return fmt.Errorf("failed to convert image: %w\nOutput: %s", convertErr, convertOutput)
 	}
-	fmt.Printf("Image converted to raw format\n")
+	slog.Debug("image converted to raw format", "vmid", vmID)
 
 	// Import the raw disk
 	importCmd := fmt.Sprintf("qm importdisk %d %s %s", vmID, rawPath, storage)
-	fmt.Printf("Running: %s\n", importCmd)
-	output, err := sshClient.Execute(importCmd)
+	slog.Debug("running on the Proxmox host", "command", importCmd)
+	output, err := executeContext(ctx, sshClient, importCmd)
 	if err != nil {
 		return fmt.Errorf("failed to import disk: %w\nOutput: %s", err, output)
 	}
 
-	fmt.Printf("Disk imported successfully\n")
-	fmt.Printf("Import output: %s\n", output)
+	slog.Debug("disk imported", "vmid", vmID, "output", output)
 
 	// Clean up raw file after import
-	sshClient.Execute(fmt.Sprintf("rm -f %s", rawPath))
+	executeContext(ctx, sshClient, fmt.Sprintf("rm -f %s", rawPath))
 
 	return nil
 }
 
 // AttachDiskToVM attaches an imported disk to a VM as the boot drive
-func (c *Client) AttachDiskToVM(vmID int, storage string, sshUser, sshPassword string) error {
-	fmt.Printf("Attaching disk to VM %d...\n", vmID)
+//
+// Deprecated: CreateVM attaches the disk through the API.
+func (c *Client) AttachDiskToVM(ctx context.Context, vmID int, storage string, sshUser, sshPassword string) error {
+	slog.Info("attaching disk", "vmid", vmID)
 
 	// Connect via SSH to the Proxmox host
-	sshConfig := sshpkg.Config{
-		Host:     c.config.Host,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
-		Timeout:  30 * time.Second,
-	}
-
-	sshClient := sshpkg.NewClient(sshConfig)
-	if err := sshClient.Connect(); err != nil {
+	sshClient := sshpkg.NewClient(c.hostSSHConfig(sshUser, sshPassword))
+	if err := sshClient.ConnectContext(ctx); err != nil {
 		return fmt.Errorf("failed to SSH to Proxmox host: %w", err)
 	}
 	defer sshClient.Close()
@@ -676,31 +1018,25 @@ func (c *Client) AttachDiskToVM(vmID int, storage string, sshUser, sshPassword s
 	}
 
 	for _, cmd := range commands {
-		fmt.Printf("Running: %s\n", cmd)
-		output, err := sshClient.Execute(cmd)
+		slog.Debug("running on the Proxmox host", "command", cmd)
+		output, err := executeContext(ctx, sshClient, cmd)
 		if err != nil {
 			// Try to continue even if some commands fail
-			fmt.Printf("Warning: command failed: %v\nOutput: %s\n", err, output)
+			slog.Warn("command on the Proxmox host failed", "command", cmd, "err", err, "output", output)
 		}
 	}
 
-	fmt.Printf("Disk attached successfully\n")
+	slog.Debug("disk attached", "vmid", vmID)
 	return nil
 }
 
 // ConfigureCloudInit adds cloud-init configuration to a VM
-func (c *Client) ConfigureCloudInit(vmID int, sshUser, sshPassword string) error {
+//
+// Deprecated: CreateVM configures cloud-init through the API.
+func (c *Client) ConfigureCloudInit(ctx context.Context, vmID int, sshUser, sshPassword string) error {
 	// Connect via SSH to the Proxmox host
-	sshConfig := sshpkg.Config{
-		Host:     c.config.Host,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
-		Timeout:  30 * time.Second,
-	}
-
-	sshClient := sshpkg.NewClient(sshConfig)
-	if err := sshClient.Connect(); err != nil {
+	sshClient := sshpkg.NewClient(c.hostSSHConfig(sshUser, sshPassword))
+	if err := sshClient.ConnectContext(ctx); err != nil {
 		return fmt.Errorf("failed to SSH to Proxmox host: %w", err)
 	}
 	defer sshClient.Close()
@@ -714,53 +1050,90 @@ func (c *Client) ConfigureCloudInit(vmID int, sshUser, sshPassword string) error
 	}
 
 	for _, cmd := range commands {
-		fmt.Printf("Running: %s\n", cmd)
-		output, err := sshClient.Execute(cmd)
+		slog.Debug("running on the Proxmox host", "command", cmd)
+		output, err := executeContext(ctx, sshClient, cmd)
 		if err != nil {
 			return fmt.Errorf("failed to configure cloud-init: %w\nCommand: %s\nOutput: %s", err, cmd, output)
 		}
 	}
 
-	fmt.Printf("Cloud-init configured successfully\n")
+	slog.Debug("cloud-init configured", "vmid", vmID)
 	return nil
 }
 
-// DownloadImage downloads an image to Proxmox local storage (legacy method)
-func (c *Client) DownloadImage(image Image, storageID string) error {
-	// This is a legacy method - use DownloadImageToNode instead
-	return fmt.Errorf("use DownloadImageToNode instead")
+// DownloadImage downloads an image into the import content of storageID on
+// the node.
+func (c *Client) DownloadImage(ctx context.Context, image Image, storageID string) error {
+	if image.URL == "" {
+		return fmt.Errorf("image URL is required for download")
+	}
+	if storageID == "" {
+		return fmt.Errorf("storage ID is required")
+	}
+
+	_, err := c.ensureImageOn(ctx, image, storageID)
+	return err
 }
 
-// GetAvailableImages lists images available on the Proxmox server
-func (c *Client) GetAvailableImages(storageID string) ([]Image, error) {
+// GetAvailableImages lists the disk images in the import content of storageID.
+func (c *Client) GetAvailableImages(ctx context.Context, storageID string) ([]Image, error) {
 	if storageID == "" {
 		return nil, fmt.Errorf("storage ID is required")
 	}
 
-	// TODO: Implement actual Proxmox API call to list images in storage
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
 
-	return []Image{}, nil
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := node.Storage(ctx, storageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage %q: %w", storageID, err)
+	}
+
+	content, err := storage.GetContent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage %q content: %w", storageID, err)
+	}
+
+	images := []Image{}
+	prefix := storageID + ":import/"
+	for _, item := range content {
+		if !strings.HasPrefix(item.Volid, prefix) {
+			continue
+		}
+		images = append(images, Image{
+			Name:    strings.TrimPrefix(item.Volid, prefix),
+			LocalID: item.Volid,
+			Size:    item.Size,
+		})
+	}
+
+	return images, nil
 }
 
 // GetVMIPAddress retrieves the IP address of a VM
-func (c *Client) GetVMIPAddress(vmID int) (string, error) {
+func (c *Client) GetVMIPAddress(ctx context.Context, vmID int) (string, error) {
 	if vmID <= 0 {
 		return "", fmt.Errorf("invalid VM ID: must be greater than 0")
 	}
 
-	if err := c.Connect(); err != nil {
+	if err := c.Connect(ctx); err != nil {
 		return "", err
 	}
 
-	node, err := c.GetNode()
+	node, err := c.GetNode(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	ctx := context.Background()
 	vm, err := node.VirtualMachine(ctx, vmID)
 	if err != nil {
-		return "", fmt.Errorf("VM not found: %w", err)
+		return "", vmLookupError(vmID, err)
 	}
 
 	// Try to get IP from QEMU agent
@@ -785,45 +1158,82 @@ func (c *Client) GetVMIPAddress(vmID int) (string, error) {
 	return "", fmt.Errorf("no valid IP address found for VM")
 }
 
+// vmSSHConfig returns the SSH config to log in to the VM at vmIP with, with
+// sshPassword and the VMSSHPrivateKey of the client config, if set, checking
+// its host key with VMSSHHostKeyCallback.
+func (c *Client) vmSSHConfig(vmIP, sshUser, sshPassword string) sshpkg.Config {
+	return sshpkg.Config{
+		Host:       vmIP,
+		Port:       22,
+		Username:   sshUser,
+		Password:   sshPassword,
+		PrivateKey: c.config.VMSSHPrivateKey,
+
+		HostKeyCallback: c.config.VMSSHHostKeyCallback,
+	}
+}
+
 // WaitForVMReady waits for a VM to be accessible via SSH
-func (c *Client) WaitForVMReady(vmIP string, sshUser string, sshPassword string, maxRetries int) error {
+func (c *Client) WaitForVMReady(ctx context.Context, vmIP string, sshUser string, sshPassword string, maxRetries int) error {
 	if maxRetries == 0 {
 		maxRetries = 30 // Default to 30 retries (5 minutes with 10s delay)
 	}
 
-	sshConfig := sshpkg.Config{
-		Host:     vmIP,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
-		Timeout:  10 * time.Second,
-	}
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+	sshConfig.Timeout = 10 * time.Second
 
 	client := sshpkg.NewClient(sshConfig)
-	return client.WaitForConnection(maxRetries, 10*time.Second)
+	for i := 0; i < maxRetries; i++ {
+		if err := client.ConnectContext(ctx); err == nil {
+			return client.Close()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if i < maxRetries-1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}
+
+	return fmt.Errorf("failed to establish SSH connection after %d attempts", maxRetries)
 }
 
-// UploadBinary uploads a binary to a VM via SSH/SCP
-func (c *Client) UploadBinary(vmIP string, sshUser string, sshPassword string, localPath string, remotePath string) error {
-	sshConfig := sshpkg.Config{
-		Host:     vmIP,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
+// transferProgress returns the TransferFunc reporting the progress of SFTP
+// transfers to the Progress of the client, labeled with phase, or nil
+// without one.
+func (c *Client) transferProgress(phase string) sshpkg.TransferFunc {
+	if c.config.Progress == nil {
+		return nil
 	}
+	return func(path string, done, total int64) {
+		c.config.Progress(Progress{Phase: phase, Bytes: done, Total: total, Percent: percentOf(done, total)})
+	}
+}
+
+// UploadBinary uploads a binary to a VM over SFTP
+func (c *Client) UploadBinary(ctx context.Context, vmIP string, sshUser string, sshPassword string, localPath string, remotePath string) error {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
 
 	client := sshpkg.NewClient(sshConfig)
-	if err := client.Connect(); err != nil {
+	if err := client.ConnectContext(ctx); err != nil {
 		return fmt.Errorf("failed to connect to VM: %w", err)
 	}
 	defer client.Close()
 
-	if err := client.UploadFile(localPath, remotePath); err != nil {
+	err := withSSHContext(ctx, client, func() error {
+		return client.Upload(localPath, remotePath, sshpkg.TransferOptions{Progress: c.transferProgress("upload")})
+	})
+	if err != nil {
 		return fmt.Errorf("failed to upload binary: %w", err)
 	}
 
 	// Make the binary executable
-	_, err := client.Execute(fmt.Sprintf("chmod +x %s", remotePath))
+	_, err = executeContext(ctx, client, "chmod +x "+sshpkg.ShellQuote(remotePath))
 	if err != nil {
 		return fmt.Errorf("failed to make binary executable: %w", err)
 	}
@@ -831,25 +1241,177 @@ func (c *Client) UploadBinary(vmIP string, sshUser string, sshPassword string, l
 	return nil
 }
 
-// ExecuteBinary executes a binary on a VM via SSH
-func (c *Client) ExecuteBinary(vmIP string, sshUser string, sshPassword string, remotePath string) (string, error) {
-	sshConfig := sshpkg.Config{
-		Host:     vmIP,
-		Port:     22,
-		Username: sshUser,
-		Password: sshPassword,
+// UploadFile uploads a file to a VM over SFTP and, unless mode is 0, sets
+// its permissions to mode.
+func (c *Client) UploadFile(ctx context.Context, vmIP string, sshUser string, sshPassword string, localPath string, remotePath string, mode os.FileMode) error {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+
+	client := sshpkg.NewClient(sshConfig)
+	if err := client.ConnectContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	defer client.Close()
+
+	err := withSSHContext(ctx, client, func() error {
+		return client.Upload(localPath, remotePath, sshpkg.TransferOptions{Mode: mode, Progress: c.transferProgress("upload")})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
 	}
 
+	return nil
+}
+
+// DownloadFiles downloads the regular files on a VM matching the shell glob
+// pattern over SFTP into localDir, creating it, and returns their local paths.
+// Files keep their base name. A pattern matching nothing downloads nothing.
+func (c *Client) DownloadFiles(ctx context.Context, vmIP string, sshUser string, sshPassword string, pattern string, localDir string) ([]string, error) {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+
 	client := sshpkg.NewClient(sshConfig)
-	if err := client.Connect(); err != nil {
+	if err := client.ConnectContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	defer client.Close()
+
+	output, err := executeContext(ctx, client, globCommand(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", pattern, err)
+	}
+	var remotes []string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			remotes = append(remotes, line)
+		}
+	}
+	if len(remotes) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var locals []string
+	for _, remote := range remotes {
+		local := filepath.Join(localDir, path.Base(remote))
+		err := withSSHContext(ctx, client, func() error {
+			return client.Download(remote, local, sshpkg.TransferOptions{Progress: c.transferProgress("collect")})
+		})
+		if err != nil {
+			return locals, fmt.Errorf("failed to download %s: %w", remote, err)
+		}
+		locals = append(locals, local)
+	}
+	return locals, nil
+}
+
+// globCommand returns a shell command printing the regular files matching
+// pattern, one per line. The pattern is left unquoted for the shell to expand.
+func globCommand(pattern string) string {
+	return fmt.Sprintf(`for f in %s; do if [ -f "$f" ]; then printf '%%s\n' "$f"; fi; done`, pattern)
+}
+
+// ExecuteCommand runs a shell command on a VM via SSH, returning its output.
+func (c *Client) ExecuteCommand(ctx context.Context, vmIP string, sshUser string, sshPassword string, command string) (string, error) {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+
+	client := sshpkg.NewClient(sshConfig)
+	if err := client.ConnectContext(ctx); err != nil {
 		return "", fmt.Errorf("failed to connect to VM: %w", err)
 	}
 	defer client.Close()
 
-	output, err := client.Execute(remotePath)
+	output, err := executeContext(ctx, client, command)
+	if err != nil {
+		return output, fmt.Errorf("failed to execute %q: %w", command, err)
+	}
+
+	return output, nil
+}
+
+// StreamCommand runs a shell command on a VM via SSH, copying its combined
+// output to w as it comes.
+func (c *Client) StreamCommand(ctx context.Context, vmIP string, sshUser string, sshPassword string, command string, w io.Writer) error {
+	// Both streams go to w, keep their writes from interleaving mid-line.
+	out := &lockedWriter{w: w}
+	return c.ExecuteStream(ctx, vmIP, sshUser, sshPassword, command, out, out)
+}
+
+// ExecuteStream runs a shell command on a VM via SSH, copying its output to
+// stdout and stderr as it comes. A command exiting with a non-zero status
+// returns an error wrapping *ssh.ExitError from pkg/ssh, ssh.ExitCode gets
+// the status from it. The command is killed when ctx is done.
+func (c *Client) ExecuteStream(ctx context.Context, vmIP string, sshUser string, sshPassword string, command string, stdout, stderr io.Writer) error {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+
+	client := sshpkg.NewClient(sshConfig)
+	if err := client.ConnectContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.ExecuteStream(ctx, command, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to execute %q: %w", command, err)
+	}
+	return nil
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// ExecuteBinary executes a binary on a VM via SSH
+func (c *Client) ExecuteBinary(ctx context.Context, vmIP string, sshUser string, sshPassword string, remotePath string) (string, error) {
+	sshConfig := c.vmSSHConfig(vmIP, sshUser, sshPassword)
+
+	client := sshpkg.NewClient(sshConfig)
+	if err := client.ConnectContext(ctx); err != nil {
+		return "", fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	defer client.Close()
+
+	output, err := executeContext(ctx, client, remotePath)
 	if err != nil {
 		return output, fmt.Errorf("failed to execute binary: %w", err)
 	}
 
 	return output, nil
-}
\ No newline at end of file
+}
+
+// withSSHContext runs fn, which uses client, and closes the connection when
+// ctx is done first so a hung remote command can't outlive the caller.
+func withSSHContext(ctx context.Context, client *sshpkg.Client, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		client.Close()
+		return ctx.Err()
+	}
+}
+
+// executeContext runs command over client, giving up when ctx is done.
+func executeContext(ctx context.Context, client *sshpkg.Client, command string) (string, error) {
+	var output string
+	err := withSSHContext(ctx, client, func() error {
+		var err error
+		output, err = client.Execute(command)
+		return err
+	})
+	return output, err
+}
@@ -1,7 +1,18 @@
 package proxmox
 
 import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func TestNewClient(t *testing.T) {
@@ -22,6 +33,68 @@ func TestNewClient(t *testing.T) {
 	if client.config.Host != config.Host {
 		t.Errorf("Expected host %s, got %s", config.Host, client.config.Host)
 	}
+	if client.vmSSHConfig("192.168.1.50", "dtt", "dtt").HostKeyCallback == nil {
+		t.Error("Expected the host keys of VMs to be checked without a VMSSHHostKeyCallback")
+	}
+}
+
+func TestHostSSHConfig(t *testing.T) {
+	client := NewClient(ClientConfig{
+		Host:          "pve.example.com",
+		SSHPort:       2222,
+		SSHPrivateKey: "/home/me/.ssh/id_ed25519",
+		SSHPassphrase: "secret",
+	})
+	got := client.hostSSHConfig("", "")
+	if got.Host != "pve.example.com" || got.Port != 2222 || got.Username != "root" {
+		t.Errorf("Expected root on pve.example.com:2222, got %+v", got)
+	}
+	if got.PrivateKey != "/home/me/.ssh/id_ed25519" || got.Passphrase != "secret" || got.Password != "" {
+		t.Errorf("Expected the private key of the config, got %+v", got)
+	}
+
+	client = NewClient(ClientConfig{Host: "pve", SSHUser: "admin", SSHPassword: "pw"})
+	if got := client.hostSSHConfig("", ""); got.Username != "admin" || got.Password != "pw" || got.Port != 22 {
+		t.Errorf("Expected the SSH user and password of the config, got %+v", got)
+	}
+	if got := client.hostSSHConfig("ops", "other"); got.Username != "ops" || got.Password != "other" {
+		t.Errorf("Expected the arguments to win, got %+v", got)
+	}
+}
+
+func TestSSHMethodsCanceled(t *testing.T) {
+	// A Proxmox host that accepts SSH connections and never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	client := NewClient(ClientConfig{
+		Host:               "127.0.0.1",
+		SSHPort:            l.Addr().(*net.TCPAddr).Port,
+		SSHPassword:        "secret",
+		SSHHostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = client.DownloadImageToNode(ctx, Image{Name: "debian-12", URL: "https://example.com/debian-12.qcow2"}, "", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the download to stop when canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected to give up when canceled, took %s", elapsed)
+	}
 }
 
 func TestDefaultImages(t *testing.T) {
@@ -56,7 +129,7 @@ func TestVMSpecValidation(t *testing.T) {
 		CPU:    1,
 	}
 
-	_, err := client.CreateVM(spec)
+	_, err := client.CreateVM(context.Background(), spec)
 	if err == nil {
 		t.Error("Expected error for invalid VMID")
 	}
@@ -68,7 +141,7 @@ func TestGetVMValidation(t *testing.T) {
 		Node: "pve",
 	})
 
-	_, err := client.GetVM(0) // Invalid VMID
+	_, err := client.GetVM(context.Background(), 0) // Invalid VMID
 	if err == nil {
 		t.Error("Expected error for invalid VMID")
 	}
@@ -85,14 +158,183 @@ func TestDownloadImageValidation(t *testing.T) {
 		URL:  "",
 	}
 
-	err := client.DownloadImage(image, "local")
+	err := client.DownloadImage(context.Background(), image, "local")
 	if err == nil {
 		t.Error("Expected error for missing image URL")
 	}
 
 	image.URL = "https://example.com/image.iso"
-	err = client.DownloadImage(image, "")
+	err = client.DownloadImage(context.Background(), image, "")
 	if err == nil {
 		t.Error("Expected error for missing storage ID")
 	}
-}
\ No newline at end of file
+}
+func newFakeClient(t *testing.T) (*Client, *proxmoxtest.Server) {
+	t.Helper()
+
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+
+	config := ClientConfig{
+		Host: "localhost",
+		Node: "pve",
+	}
+	return NewClientWithAPI(config, server.Client()), server
+}
+
+func TestCreateVMUsesAPI(t *testing.T) {
+	client, server := newFakeClient(t)
+
+	spec := VMSpec{
+		Name:      "dtt-test",
+		VMID:      120,
+		Image:     DefaultImages()[2],
+		Memory:    1024,
+		CPU:       1,
+		Cores:     2,
+		CloudInit: true,
+	}
+
+	vm, err := client.CreateVM(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("CreateVM() gave err: %v", err)
+	}
+	if vm.Status != "running" {
+		t.Errorf("Expected VM status running, got %q", vm.Status)
+	}
+
+	created := server.VM(120)
+	if created == nil {
+		t.Fatal("Expected VM 120 to exist on the server")
+	}
+
+	wantImport := "local-lvm:0,import-from=local:import/noble-server-cloudimg-amd64.qcow2"
+	if got := created.Config["scsi0"]; got != wantImport {
+		t.Errorf("Expected scsi0 %q, got %v", wantImport, got)
+	}
+	if got := created.Config["ide2"]; got != "local:cloudinit" {
+		t.Errorf("Expected cloud-init drive on local, got %v", got)
+	}
+	if got := created.Config["ciuser"]; got != "dtt" {
+		t.Errorf("Expected default ciuser dtt, got %v", got)
+	}
+
+	var types []string
+	for _, task := range server.Tasks() {
+		types = append(types, task.Type)
+	}
+	want := []string{"download", "qmcreate", "qmconfig", "resize", "qmstart"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected tasks %v, got %v", want, types)
+	}
+}
+
+func TestCreateVMSkipsExistingImage(t *testing.T) {
+	client, server := newFakeClient(t)
+
+	image := DefaultImages()[2]
+	if err := client.DownloadImage(context.Background(), image, "local"); err != nil {
+		t.Fatalf("DownloadImage() gave err: %v", err)
+	}
+
+	if _, err := client.CreateVM(context.Background(), VMSpec{Name: "dtt-test", VMID: 121, Image: image, Memory: 512, CPU: 1, Cores: 1}); err != nil {
+		t.Fatalf("CreateVM() gave err: %v", err)
+	}
+
+	downloads := 0
+	for _, task := range server.Tasks() {
+		if task.Type == "download" {
+			downloads++
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("Expected the image to be downloaded once, got %d downloads", downloads)
+	}
+}
+
+func TestCreateVMCreateError(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.Fail("POST", "/nodes/pve/qemu", 500, "storage full")
+
+	_, err := client.CreateVM(context.Background(), VMSpec{Name: "dtt-test", VMID: 122, Memory: 512, CPU: 1, Cores: 1})
+	if err == nil {
+		t.Fatal("Expected error when VM creation fails")
+	}
+	if server.VM(122) != nil {
+		t.Error("Expected no VM to be created")
+	}
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 130, Name: "dtt-stopped", Status: "stopped"})
+
+	if _, err := client.GetVM(context.Background(), 999); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("GetVM() of a missing VM: expected ErrVMNotFound, got %v", err)
+	}
+	if err := client.StopVM(context.Background(), 998); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("StopVM() of a missing VM: expected ErrVMNotFound, got %v", err)
+	}
+
+	server.FailTasks("qmstart", "start failed: can't lock file")
+	err := client.StartVM(context.Background(), 130)
+	if !errors.Is(err, ErrTaskFailed) {
+		t.Errorf("StartVM() with a failing task: expected ErrTaskFailed, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "can't lock file") {
+		t.Errorf("Expected the task exit status in the error, got %v", err)
+	}
+}
+
+func TestErrorTaxonomyAuth(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.Fail("GET", "/nodes/pve/qemu/100/status/current", 403, "Permission check failed (/vms/100, VM.Audit)")
+
+	if _, err := client.GetVM(context.Background(), 100); !errors.Is(err, ErrAuth) {
+		t.Errorf("Expected ErrAuth, got %v", err)
+	}
+}
+
+func TestGetNodeNotConnected(t *testing.T) {
+	client := NewClient(ClientConfig{Host: "localhost", Node: "pve"})
+
+	if _, err := client.GetNode(context.Background()); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestGlobCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b c.log", "d.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "e.log"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("sh", "-c", globCommand(dir+"/*.log")).Output()
+	if err != nil {
+		t.Fatalf("running the glob command gave err: %v", err)
+	}
+	want := filepath.Join(dir, "a.log") + "\n" + filepath.Join(dir, "b c.log") + "\n"
+	if string(out) != want {
+		t.Errorf("Expected the regular files matching, got %q", out)
+	}
+
+	out, err = exec.Command("sh", "-c", globCommand(dir+"/*.none")).Output()
+	if err != nil || len(out) != 0 {
+		t.Errorf("Expected no files for a pattern matching nothing, got %q, %v", out, err)
+	}
+}
+
+func TestImportedVolume(t *testing.T) {
+	output := "importing disk '/tmp/debian-12.qcow2' to VM 120 ...\ntransferred 2.0 GiB of 2.0 GiB (100.00%)\nunused0: successfully imported disk 'local-lvm:vm-120-disk-0'\n"
+	if got, err := importedVolume(output); err != nil || got != "local-lvm:vm-120-disk-0" {
+		t.Errorf("importedVolume() = %q, %v, want local-lvm:vm-120-disk-0", got, err)
+	}
+	if _, err := importedVolume("storage 'nope' does not exist\n"); err == nil {
+		t.Error("Expected an error without an imported disk")
+	}
+}
@@ -0,0 +1,92 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// Errors returned by this package. They are wrapped with more context, check
+// for them with errors.Is.
+var (
+	// ErrVMNotFound is returned when a VM name or ID does not exist.
+	ErrVMNotFound = errors.New("vm not found")
+	// ErrContainerNotFound is returned when a container name or ID does not
+	// exist.
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrAmbiguousName is returned when a VM name matches more than one VM.
+	ErrAmbiguousName = errors.New("vm name is ambiguous")
+	// ErrNotConnected is returned when the client is used before Connect.
+	ErrNotConnected = errors.New("client not connected")
+	// ErrTaskTimeout is returned when a Proxmox task does not finish in time.
+	ErrTaskTimeout = errors.New("timed out waiting for task")
+	// ErrTaskFailed is returned when a Proxmox task finishes with an error.
+	ErrTaskFailed = errors.New("task failed")
+	// ErrAuth is returned when Proxmox rejects the credentials or the
+	// credentials lack a permission.
+	ErrAuth = errors.New("proxmox authentication failed")
+	// ErrInvalidSpec is returned when VM parameters fail validation, the
+	// error is a *ValidationError listing every problem.
+	ErrInvalidSpec = errors.New("invalid vm parameters")
+)
+
+// WrapError maps errors from the go-proxmox library onto the errors of this
+// package. Errors it does not recognize are returned as is.
+//
+// Proxmox reports most failures as a 500 with the reason in the status line,
+// which go-proxmox turns into a plain string error, so missing VMs are
+// recognized by their message.
+func WrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, ErrVMNotFound), errors.Is(err, ErrAuth), errors.Is(err, ErrTaskTimeout):
+		return err
+	case proxmox.IsNotAuthorized(err):
+		return fmt.Errorf("%w: %w", ErrAuth, err)
+	case proxmox.IsTimeout(err):
+		return fmt.Errorf("%w: %w", ErrTaskTimeout, err)
+	case isMissingVMMessage(err.Error()):
+		return fmt.Errorf("%w: %w", ErrVMNotFound, err)
+	}
+	return err
+}
+
+func isMissingVMMessage(msg string) bool {
+	return strings.Contains(msg, "qemu-server/") && strings.Contains(msg, "does not exist")
+}
+
+// WaitTask waits for task to finish, polling every interval for at most
+// timeout. Unlike Task.Wait it reports a task that finished unsuccessfully,
+// as ErrTaskFailed, and a timeout as ErrTaskTimeout.
+func WaitTask(ctx context.Context, task *proxmox.Task, interval, timeout time.Duration) error {
+	if task == nil {
+		// Some calls return no UPID when there was nothing to do.
+		return nil
+	}
+	if err := task.Wait(ctx, interval, timeout); err != nil {
+		if proxmox.IsTimeout(err) {
+			return fmt.Errorf("%w: %s after %s", ErrTaskTimeout, task.UPID, timeout)
+		}
+		return WrapError(err)
+	}
+	if task.IsFailed {
+		return fmt.Errorf("%w: %s: %s", ErrTaskFailed, task.UPID, task.ExitStatus)
+	}
+	return nil
+}
+
+// vmLookupError describes a failed lookup of vmID, as ErrVMNotFound when the
+// VM does not exist.
+func vmLookupError(vmID int, err error) error {
+	err = WrapError(err)
+	if errors.Is(err, ErrVMNotFound) {
+		return fmt.Errorf("vm %d: %w", vmID, err)
+	}
+	return fmt.Errorf("failed to get VM %d: %w", vmID, err)
+}
@@ -0,0 +1,99 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	sshpkg "github.com/cdevr/dtt/pkg/ssh"
+)
+
+// ExportOptions tune ExportVolume.
+type ExportOptions struct {
+	// Format is the format of the local file, one of ImportFormats,
+	// FormatQcow2 unless set.
+	Format string
+	// TmpDir is the directory on the node the image is converted into
+	// before the download, /var/tmp unless set. It needs room for it.
+	TmpDir string
+	// Sudo runs pvesm and qemu-img with sudo, for users other than root.
+	Sudo     bool
+	Progress ProgressFunc
+}
+
+// ExportVolume downloads the volume volID, a disk image or VM disk, from the
+// node client is logged in to over SSH into the local file localPath.
+// Proxmox has no API to download volumes, so pvesm finds the volume and
+// qemu-img converts it into a file on the node first, which is removed
+// afterwards. That also reads volumes that aren't files, such as LVM.
+func ExportVolume(ctx context.Context, client *sshpkg.Client, volID, localPath string, opts ExportOptions) error {
+	if opts.Format == "" {
+		opts.Format = FormatQcow2
+	}
+	if !slices.Contains(ImportFormats, opts.Format) {
+		return fmt.Errorf("unknown export format %q, use one of %s", opts.Format, strings.Join(ImportFormats, ", "))
+	}
+	if opts.TmpDir == "" {
+		opts.TmpDir = "/var/tmp"
+	}
+	sudo := ""
+	if opts.Sudo {
+		sudo = "sudo "
+	}
+
+	output, err := executeContext(ctx, client, sudo+"pvesm path "+sshpkg.ShellQuote(volID))
+	if err != nil {
+		return fmt.Errorf("finding volume %s gave err: %w\n%s", volID, err, output)
+	}
+	source := strings.TrimSpace(output)
+
+	output, err = executeContext(ctx, client, fmt.Sprintf("mktemp -p %s dtt-export-XXXXXX", sshpkg.ShellQuote(opts.TmpDir)))
+	if err != nil {
+		return fmt.Errorf("creating a file in %s gave err: %w\n%s", opts.TmpDir, err, output)
+	}
+	tmp := strings.TrimSpace(output)
+	defer func() {
+		// Clean up even when ctx is why we stopped.
+		executeContext(context.WithoutCancel(ctx), client, sudo+"rm -f "+sshpkg.ShellQuote(tmp))
+	}()
+
+	opts.Progress.report(Progress{Phase: "convert", Message: fmt.Sprintf("converting %s to %s on the node", volID, opts.Format), Percent: -1})
+	if output, err := executeContext(ctx, client, convertCommand(source, tmp, opts.Format, opts.Sudo)); err != nil {
+		return fmt.Errorf("converting %s gave err: %w\n%s", volID, err, output)
+	}
+
+	progress := func(_ string, done, total int64) {
+		opts.Progress.report(Progress{Phase: "download", Bytes: done, Total: total, Percent: percentOf(done, total)})
+	}
+	err = withSSHContext(ctx, client, func() error {
+		return client.Download(tmp, localPath, sshpkg.TransferOptions{Mode: 0o644, Progress: progress})
+	})
+	if err != nil {
+		return fmt.Errorf("downloading %s gave err: %w", volID, err)
+	}
+	opts.Progress.report(Progress{Phase: "done", Message: fmt.Sprintf("exported %s to %s", volID, localPath), Percent: 100})
+	return nil
+}
+
+// convertCommand returns the shell command converting the disk image at
+// source to format in dst on the node, readable by the user logged in.
+func convertCommand(source, dst, format string, sudo bool) string {
+	convert := fmt.Sprintf("qemu-img convert -O %s %s %s", format, sshpkg.ShellQuote(source), sshpkg.ShellQuote(dst))
+	if !sudo {
+		return convert
+	}
+	return fmt.Sprintf("sudo %s && sudo chown \"$(id -u)\" %s", convert, sshpkg.ShellQuote(dst))
+}
+
+// ExportFilename returns the local file name for volume volID in format,
+// the name of the volume with the extension of format.
+func ExportFilename(volID, format string) string {
+	_, name, _ := strings.Cut(volID, ":")
+	name = path.Base(name)
+	if ext := path.Ext(name); slices.Contains([]string{".qcow2", ".raw", ".vmdk", ".img"}, ext) {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name + "." + format
+}
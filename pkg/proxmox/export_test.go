@@ -0,0 +1,39 @@
+package proxmox
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConvertCommand(t *testing.T) {
+	if got, want := convertCommand("/dev/pve/vm-100-disk-0", "/var/tmp/dtt-export-abc", FormatQcow2, false), `qemu-img convert -O qcow2 '/dev/pve/vm-100-disk-0' '/var/tmp/dtt-export-abc'`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	want := `sudo qemu-img convert -O raw '/mnt/it'\''s.qcow2' '/tmp/x' && sudo chown "$(id -u)" '/tmp/x'`
+	if got := convertCommand("/mnt/it's.qcow2", "/tmp/x", FormatRaw, true); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestExportFilename(t *testing.T) {
+	for _, tc := range []struct {
+		volID, format, want string
+	}{
+		{"local-lvm:vm-100-disk-0", FormatQcow2, "vm-100-disk-0.qcow2"},
+		{"local:import/debian-12.qcow2", FormatRaw, "debian-12.raw"},
+		{"local:100/vm-100-disk-1.qcow2", FormatQcow2, "vm-100-disk-1.qcow2"},
+		{"local:iso/noble.img", FormatQcow2, "noble.qcow2"},
+		{"ceph:base-9000-disk-0", FormatVMDK, "base-9000-disk-0.vmdk"},
+	} {
+		if got := ExportFilename(tc.volID, tc.format); got != tc.want {
+			t.Errorf("ExportFilename(%q, %q) = %q, want %q", tc.volID, tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestExportVolumeChecksFormat(t *testing.T) {
+	// The format is checked before the node is talked to.
+	if err := ExportVolume(context.Background(), nil, "local-lvm:vm-100-disk-0", "disk.vdi", ExportOptions{Format: FormatVDI}); err == nil {
+		t.Error("Expected an error for a format other than qcow2, raw or vmdk")
+	}
+}
@@ -0,0 +1,122 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ManagedVM is a VM of the cluster tagged ManagedTag.
+type ManagedVM struct {
+	Node       string
+	VMID       int
+	Name       string
+	Status     string
+	Provenance Provenance
+}
+
+func (m ManagedVM) String() string {
+	return fmt.Sprintf("vm %d (%s) on %s", m.VMID, m.Name, m.Node)
+}
+
+// ManagedVMs returns the VMs of the whole cluster tagged ManagedTag with the
+// provenance from their descriptions, sorted by node and VMID.
+func (c *Client) ManagedVMs(ctx context.Context) ([]ManagedVM, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	cluster, err := c.apiClient.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", WrapError(err))
+	}
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster resources: %w", WrapError(err))
+	}
+
+	var vms []ManagedVM
+	for _, r := range resources {
+		if r.Type != "qemu" || r.Template == 1 || !HasTag(r.Tags, ManagedTag) {
+			continue
+		}
+		node, err := c.apiClient.Node(ctx, r.Node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node '%s': %w", r.Node, WrapError(err))
+		}
+		vm, err := node.VirtualMachine(ctx, int(r.VMID))
+		if err != nil {
+			return nil, vmLookupError(int(r.VMID), err)
+		}
+		m := ManagedVM{Node: r.Node, VMID: int(r.VMID), Name: r.Name, Status: vm.Status}
+		if vm.VirtualMachineConfig != nil {
+			m.Provenance, _ = ParseProvenance(vm.VirtualMachineConfig.Description)
+		}
+		vms = append(vms, m)
+	}
+
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].Node == vms[j].Node {
+			return vms[i].VMID < vms[j].VMID
+		}
+		return vms[i].Node < vms[j].Node
+	})
+	return vms, nil
+}
+
+// CollectGarbage stops and deletes the managed VMs of the cluster whose TTL
+// ran out at now, see Provenance.WithTTL. With dryRun it only returns them.
+// It carries on past VMs it fails to delete and returns the ones it deleted
+// together with every error.
+func (c *Client) CollectGarbage(ctx context.Context, now time.Time, dryRun bool) ([]ManagedVM, error) {
+	vms, err := c.ManagedVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ManagedVM
+	var errs []error
+	for _, m := range vms {
+		if !m.Provenance.Expired(now) {
+			continue
+		}
+		if !dryRun {
+			if err := c.destroyVMOn(ctx, m.Node, m.VMID); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", m, err))
+				continue
+			}
+		}
+		expired = append(expired, m)
+	}
+	return expired, errors.Join(errs...)
+}
+
+// destroyVMOn stops the VM on node if it runs, and deletes it.
+func (c *Client) destroyVMOn(ctx context.Context, nodeName string, vmID int) error {
+	node, err := c.apiClient.Node(ctx, nodeName)
+	if err != nil {
+		return fmt.Errorf("failed to get node '%s': %w", nodeName, WrapError(err))
+	}
+	vm, err := node.VirtualMachine(ctx, vmID)
+	if err != nil {
+		return vmLookupError(vmID, err)
+	}
+
+	if vm.Status == "running" {
+		task, err := vm.Stop(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to stop VM: %w", WrapError(err))
+		}
+		if err := c.waitTask(ctx, task, time.Minute); err != nil {
+			return err
+		}
+	}
+
+	task, err := vm.Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete VM: %w", WrapError(err))
+	}
+	return c.waitTask(ctx, task, time.Minute)
+}
@@ -0,0 +1,49 @@
+package proxmox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	description := func(ttl time.Duration) map[string]interface{} {
+		p := Provenance{Creator: "test", Created: now.Add(-time.Hour)}.WithTTL(ttl)
+		return map[string]interface{}{"description": p.String()}
+	}
+
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddNode("pve2")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "expired", Status: "running", Tags: "dtt", Config: description(30 * time.Minute)})
+	server.AddVM(proxmoxtest.VM{Node: "pve2", VMID: 101, Name: "expired-too", Status: "stopped", Tags: "dtt", Config: description(time.Hour)})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 102, Name: "fresh", Status: "running", Tags: "dtt", Config: description(2 * time.Hour)})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 103, Name: "forever", Status: "running", Tags: "dtt", Config: description(0)})
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 104, Name: "not-ours", Status: "running", Config: description(time.Minute)})
+
+	client := NewClientWithAPI(ClientConfig{Node: "pve"}, server.Client())
+	ctx := context.Background()
+
+	expired, err := client.CollectGarbage(ctx, now, true)
+	if err != nil {
+		t.Fatalf("CollectGarbage(dry run) gave err: %v", err)
+	}
+	if len(expired) != 2 || expired[0].VMID != 100 || expired[1].VMID != 101 {
+		t.Fatalf("Expected VMs 100 and 101 to be expired, got %v", expired)
+	}
+	if server.VM(100) == nil {
+		t.Fatal("Expected a dry run to keep the VMs")
+	}
+
+	if _, err := client.CollectGarbage(ctx, now, false); err != nil {
+		t.Fatalf("CollectGarbage() gave err: %v", err)
+	}
+	for vmid, kept := range map[uint64]bool{100: false, 101: false, 102: true, 103: true, 104: true} {
+		if got := server.VM(vmid) != nil; got != kept {
+			t.Errorf("VM %d kept = %v, want %v", vmid, got, kept)
+		}
+	}
+}
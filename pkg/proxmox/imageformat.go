@@ -0,0 +1,99 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Disk image formats, as qemu-img names them.
+const (
+	FormatRaw   = "raw"
+	FormatQcow2 = "qcow2"
+	FormatVMDK  = "vmdk"
+	FormatVHDX  = "vhdx"
+	FormatVDI   = "vdi"
+)
+
+// ImportFormats are the disk image formats the import content of a storage
+// takes. Others have to be converted first.
+var ImportFormats = []string{FormatQcow2, FormatRaw, FormatVMDK}
+
+// ErrCompressed is returned by DetectImageFormat for compressed files, such
+// as .qcow2.xz downloads, which have to be decompressed first.
+var ErrCompressed = errors.New("the image is compressed")
+
+// imageMagic are the signatures of the formats at the start of the file.
+var imageMagic = []struct {
+	offset int
+	magic  []byte
+	format string
+}{
+	{0, []byte("QFI\xfb"), FormatQcow2},
+	{0, []byte("KDMV"), FormatVMDK},
+	{0, []byte("# Disk DescriptorFile"), FormatVMDK},
+	{0, []byte("vhdxfile"), FormatVHDX},
+	{0x40, []byte("\x7f\x10\xda\xbe"), FormatVDI},
+}
+
+// compressedMagic are the signatures of compressed files.
+var compressedMagic = [][]byte{
+	[]byte("\x1f\x8b"),           // gzip
+	[]byte("\xfd7zXZ\x00"),       // xz
+	[]byte("BZh"),                // bzip2
+	[]byte("\x28\xb5\x2f\xfd"),   // zstd
+	[]byte("PK\x03\x04"),         // zip
+	[]byte("7z\xbc\xaf\x27\x1c"), // 7z
+}
+
+// DetectImageFormat returns the disk image format of the file at path by its
+// header. Files of no known format are raw images.
+func DetectImageFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	for _, m := range imageMagic {
+		if len(header) >= m.offset+len(m.magic) && bytes.Equal(header[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format, nil
+		}
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(header, magic) {
+			return "", fmt.Errorf("%s: %w, decompress it first", path, ErrCompressed)
+		}
+	}
+	return FormatRaw, nil
+}
+
+// ConvertImage converts the disk image src of format from into dst of format
+// to with qemu-img, which has to be installed. Its output goes to w.
+func ConvertImage(ctx context.Context, src, from, dst, to string, w io.Writer) error {
+	if !slices.Contains(ImportFormats, to) {
+		return fmt.Errorf("can't convert to %s, use one of %s", to, strings.Join(ImportFormats, ", "))
+	}
+	qemuImg, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return fmt.Errorf("converting %s from %s to %s needs qemu-img: %w", src, from, to, err)
+	}
+	cmd := exec.CommandContext(ctx, qemuImg, "convert", "-f", from, "-O", to, src, dst)
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("qemu-img convert %s gave err: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectImageFormat(t *testing.T) {
+	vdi := make([]byte, 0x50)
+	copy(vdi, "<<< Oracle VM VirtualBox Disk Image >>>\n")
+	copy(vdi[0x40:], "\x7f\x10\xda\xbe")
+	for _, tc := range []struct {
+		header string
+		want   string
+	}{
+		{"QFI\xfb\x00\x00\x00\x03", FormatQcow2},
+		{"KDMV\x01\x00\x00\x00", FormatVMDK},
+		{"# Disk DescriptorFile\nversion=1\n", FormatVMDK},
+		{"vhdxfile", FormatVHDX},
+		{string(vdi), FormatVDI},
+		{"\xeb\x63\x90 boot sector", FormatRaw},
+		{"", FormatRaw},
+	} {
+		path := filepath.Join(t.TempDir(), "disk")
+		if err := os.WriteFile(path, []byte(tc.header), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := DetectImageFormat(path); err != nil || got != tc.want {
+			t.Errorf("DetectImageFormat(%q) = %q, %v, want %q", tc.header, got, err, tc.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "disk.qcow2.xz")
+	if err := os.WriteFile(path, []byte("\xfd7zXZ\x00\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DetectImageFormat(path); !errors.Is(err, ErrCompressed) {
+		t.Errorf("Expected ErrCompressed for an xz file, got %v", err)
+	}
+}
+
+// fakeQemuImg puts a qemu-img on PATH that copies its source to its
+// destination, prefixed with its arguments.
+func fakeQemuImg(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\n{ echo \"$@\"; cat \"$6\"; } > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestConvertImage(t *testing.T) {
+	fakeQemuImg(t)
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "disk.vmdk"), filepath.Join(dir, "disk.qcow2")
+	if err := os.WriteFile(src, []byte("KDMV"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConvertImage(context.Background(), src, FormatVMDK, dst, FormatQcow2, io.Discard); err != nil {
+		t.Fatalf("ConvertImage() gave err: %v", err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "convert -f vmdk -O qcow2 " + src + " " + dst + "\nKDMV"; string(got) != want {
+		t.Errorf("Expected qemu-img convert to run, got %q, want %q", got, want)
+	}
+	if err := ConvertImage(context.Background(), src, FormatVMDK, dst, FormatVDI, io.Discard); err == nil {
+		t.Error("Expected an error converting to a format import doesn't take")
+	}
+}
@@ -0,0 +1,89 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// Placement strategies for PickNode.
+const (
+	// PlaceSpread picks the node with the most free memory, then CPU, to
+	// spread VMs over the cluster.
+	PlaceSpread = "spread"
+	// PlaceBinpack picks the node with the least free memory the VM fits
+	// on, to keep other nodes free for big VMs.
+	PlaceBinpack = "binpack"
+	// PlaceRandom picks any node the VM fits on.
+	PlaceRandom = "random"
+)
+
+// PlacementStrategies are the strategies PickNode takes.
+var PlacementStrategies = []string{PlaceSpread, PlaceBinpack, PlaceRandom}
+
+// PickNode returns the name of the online node to create a VM needing
+// memoryMB on, chosen by strategy from the memory and CPU the nodes have
+// free.
+func PickNode(ctx context.Context, api ProxmoxAPI, strategy string, memoryMB int) (string, error) {
+	if !slices.Contains(PlacementStrategies, strategy) {
+		return "", fmt.Errorf("unknown placement strategy %q, want one of %s", strategy, strings.Join(PlacementStrategies, ", "))
+	}
+	nodes, err := api.Nodes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting nodes gave err: %w", WrapError(err))
+	}
+
+	need := uint64(memoryMB) << 20
+	var fits []*proxmox.NodeStatus
+	for _, n := range nodes {
+		if n.Status == "online" && freeMemory(n) >= need {
+			fits = append(fits, n)
+		}
+	}
+	if len(fits) == 0 {
+		return "", fmt.Errorf("no online node has %s of memory free", formatBytes(int64(need)))
+	}
+
+	// Sorted by name first, so equal nodes are picked the same way each time.
+	slices.SortFunc(fits, func(a, b *proxmox.NodeStatus) int { return strings.Compare(a.Node, b.Node) })
+	switch strategy {
+	case PlaceRandom:
+		return fits[rand.IntN(len(fits))].Node, nil
+	case PlaceBinpack:
+		slices.SortStableFunc(fits, func(a, b *proxmox.NodeStatus) int { return compareFree(b, a) })
+	default:
+		slices.SortStableFunc(fits, compareFree)
+	}
+	return fits[0].Node, nil
+}
+
+// freeMemory returns the bytes of memory node has free.
+func freeMemory(node *proxmox.NodeStatus) uint64 {
+	if node.Mem >= node.MaxMem {
+		return 0
+	}
+	return node.MaxMem - node.Mem
+}
+
+// compareFree orders the node with the most free memory, then free CPUs,
+// first.
+func compareFree(a, b *proxmox.NodeStatus) int {
+	if fa, fb := freeMemory(a), freeMemory(b); fa != fb {
+		if fa > fb {
+			return -1
+		}
+		return 1
+	}
+	ca, cb := float64(a.MaxCPU)*(1-a.CPU), float64(b.MaxCPU)*(1-b.CPU)
+	switch {
+	case ca > cb:
+		return -1
+	case ca < cb:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package proxmox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestPickNode(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	busy := server.AddNode("busy")
+	busy.Mem = 30 << 30
+	roomy := server.AddNode("roomy")
+	roomy.Mem = 4 << 30
+	half := server.AddNode("half")
+	half.Mem = 16 << 30
+	twin := server.AddNode("twin")
+	twin.Mem, twin.CPU = 4<<30, 0.5
+	server.AddNode("down").Status = "offline"
+
+	ctx := context.Background()
+	tests := []struct {
+		strategy string
+		memoryMB int
+		want     string
+	}{
+		{PlaceSpread, 1024, "roomy"},
+		{PlaceBinpack, 1024, "busy"},
+		{PlaceBinpack, 4096, "half"},
+		{PlaceSpread, 28 << 10, "roomy"},
+	}
+	for _, tt := range tests {
+		got, err := PickNode(ctx, server.Client(), tt.strategy, tt.memoryMB)
+		if err != nil || got != tt.want {
+			t.Errorf("PickNode(%s, %d MB) = %q, %v, want %q", tt.strategy, tt.memoryMB, got, err, tt.want)
+		}
+	}
+
+	for range 10 {
+		if got, err := PickNode(ctx, server.Client(), PlaceRandom, 20<<10); err != nil || (got != "roomy" && got != "twin") {
+			t.Fatalf("PickNode(random, 20 GiB) = %q, %v, want roomy or twin", got, err)
+		}
+	}
+
+	if _, err := PickNode(ctx, server.Client(), PlaceSpread, 64<<10); err == nil {
+		t.Error("Expected an error when no node has 64 GiB free")
+	}
+	if _, err := PickNode(ctx, server.Client(), "first", 1024); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
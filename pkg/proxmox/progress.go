@@ -0,0 +1,165 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// Progress describes how far a long running operation, such as an image
+// download, has come.
+type Progress struct {
+	// Phase is the step the operation is in, e.g. "check", "download",
+	// "verify" or "done".
+	Phase string
+	// Message is a human readable description, may be empty.
+	Message string
+	// Bytes and Total are the bytes done and expected, 0 when unknown.
+	Bytes int64
+	Total int64
+	// Percent is between 0 and 100, or negative when unknown.
+	Percent float64
+}
+
+// ProgressFunc receives progress updates. It is called from the goroutine
+// doing the work and should return quickly.
+type ProgressFunc func(Progress)
+
+func (f ProgressFunc) report(p Progress) {
+	if f != nil {
+		f(p)
+	}
+}
+
+// LogProgress returns a ProgressFunc that logs messages to logger at info
+// level, and the percentage in steps of 10% at debug level, for structured
+// logs where PrintProgress would mix in plain lines.
+func LogProgress(logger *slog.Logger) ProgressFunc {
+	phase := ""
+	lastStep := -1
+	return func(p Progress) {
+		if p.Phase != phase {
+			phase = p.Phase
+			lastStep = -1
+		}
+		if p.Message != "" {
+			logger.Info(p.Message, "phase", p.Phase)
+		}
+		if p.Percent < 0 || int(p.Percent)/10 <= lastStep {
+			return
+		}
+		lastStep = int(p.Percent) / 10
+		logger.Debug("progress", "phase", p.Phase, "percent", math.Round(p.Percent), "bytes", p.Bytes, "total", p.Total)
+	}
+}
+
+// PrintProgress returns a ProgressFunc that writes messages and phase changes
+// to w, and the percentage in steps of 10% with the rate and time left, as
+// plain lines for logs. BarProgress draws a bar on terminals instead.
+func PrintProgress(w io.Writer) ProgressFunc {
+	phase := ""
+	lastStep := -1
+	var rate progressRate
+	return func(p Progress) {
+		if p.Phase != phase {
+			phase = p.Phase
+			lastStep = -1
+		}
+		if p.Message != "" {
+			fmt.Fprintln(w, p.Message)
+		}
+		if p.Percent < 0 {
+			return
+		}
+		bytesPerSecond, left := rate.update(p)
+		step := int(p.Percent) / 10
+		if step <= lastStep {
+			return
+		}
+		lastStep = step
+		if p.Total > 0 {
+			fmt.Fprintf(w, "  %s: %.0f%% (%s of %s%s)\n", p.Phase, p.Percent, formatBytes(p.Bytes), formatBytes(p.Total), formatProgressRate(bytesPerSecond, left))
+			return
+		}
+		fmt.Fprintf(w, "  %s: %.0f%%%s\n", p.Phase, p.Percent, formatProgressRate(bytesPerSecond, left))
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var (
+	taskLogPercent = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	taskLogBytes   = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([KMGT]i?B|B)\s+of\s+(\d+(?:\.\d+)?)\s*([KMGT]i?B|B)`)
+)
+
+// parseTaskLogProgress extracts a percentage, and the transferred and total
+// size if present, from a task log line such as
+// "18.52% (105.00 MiB of 567.00 MiB) in 2s, speed 52.50 MiB/s".
+func parseTaskLogProgress(line string) (Progress, bool) {
+	m := taskLogPercent.FindStringSubmatch(line)
+	if m == nil {
+		return Progress{}, false
+	}
+	percent, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || percent > 100 {
+		return Progress{}, false
+	}
+	p := Progress{Percent: percent}
+	if b := taskLogBytes.FindStringSubmatch(line); b != nil {
+		p.Bytes = parseSize(b[1], b[2])
+		p.Total = parseSize(b[3], b[4])
+	}
+	return p, true
+}
+
+func parseSize(num, unit string) int64 {
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	exp := strings.Index("BKMGT", unit[:1])
+	if exp < 0 {
+		return 0
+	}
+	return int64(f * math.Pow(1024, float64(exp)))
+}
+
+// WaitTaskProgress waits like WaitTask, and reports the progress the task
+// writes to its log to progress, labeled with phase.
+func WaitTaskProgress(ctx context.Context, task *proxmox.Task, interval, timeout time.Duration, phase string, progress ProgressFunc) error {
+	return WaitTaskLog(ctx, task, interval, timeout, TaskLogProgress(phase, progress))
+}
+
+// TaskLogProgress returns a TaskLogFunc passing the percentages found in
+// task log lines to progress, labeled with phase. It returns nil for a nil
+// progress.
+func TaskLogProgress(phase string, progress ProgressFunc) TaskLogFunc {
+	if progress == nil {
+		return nil
+	}
+	return func(task *proxmox.Task, line string) {
+		if p, ok := parseTaskLogProgress(line); ok {
+			p.Phase = phase
+			progress(p)
+		}
+	}
+}
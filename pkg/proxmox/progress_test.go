@@ -0,0 +1,67 @@
+package proxmox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestParseTaskLogProgress(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantOK    bool
+		wantPct   float64
+		wantBytes int64
+		wantTotal int64
+	}{
+		{line: "downloading https://example.com/noble.img to /var/lib/vz/import/noble.qcow2", wantOK: false},
+		{line: "18.52% (105.00 MiB of 567.00 MiB) in 2s, speed 52.50 MiB/s", wantOK: true, wantPct: 18.52, wantBytes: 105 << 20, wantTotal: 567 << 20},
+		{line: "  51200K ........ ........ 45% 10.2M 3s", wantOK: true, wantPct: 45},
+		{line: "TASK OK", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		p, ok := parseTaskLogProgress(tt.line)
+		if ok != tt.wantOK {
+			t.Errorf("parseTaskLogProgress(%q) ok = %v, want %v", tt.line, ok, tt.wantOK)
+			continue
+		}
+		if p.Percent != tt.wantPct || p.Bytes != tt.wantBytes || p.Total != tt.wantTotal {
+			t.Errorf("parseTaskLogProgress(%q) = %+v, want %.2f%% %d of %d", tt.line, p, tt.wantPct, tt.wantBytes, tt.wantTotal)
+		}
+	}
+}
+
+func TestEnsureImageReportsProgress(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.TaskLog("download",
+		"downloading https://cloud-images.ubuntu.com/noble.img",
+		"50.00% (10.00 MiB of 20.00 MiB) in 1s, speed 10.00 MiB/s",
+		"100.00% (20.00 MiB of 20.00 MiB) in 2s, speed 10.00 MiB/s",
+		"TASK OK")
+
+	var updates []Progress
+	client := NewClientWithAPI(ClientConfig{
+		Node:     "pve",
+		Progress: func(p Progress) { updates = append(updates, p) },
+	}, server.Client())
+
+	if _, err := client.EnsureImage(context.Background(), DefaultImages()[2]); err != nil {
+		t.Fatalf("EnsureImage() gave err: %v", err)
+	}
+
+	var percents []float64
+	for _, p := range updates {
+		if p.Phase == "download" && p.Percent >= 0 {
+			percents = append(percents, p.Percent)
+		}
+	}
+	if len(percents) != 2 || percents[0] != 50 || percents[1] != 100 {
+		t.Errorf("Expected download progress 50%% and 100%%, got %v", percents)
+	}
+	if last := updates[len(updates)-1]; last.Phase != "done" {
+		t.Errorf("Expected the last update to be done, got %+v", last)
+	}
+}
@@ -0,0 +1,104 @@
+package proxmox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressNow is the clock of the ETA estimates, replaced in tests.
+var progressNow = time.Now
+
+// progressRate estimates how fast a phase progresses from its first update
+// on, and when it will be done.
+type progressRate struct {
+	phase   string
+	start   time.Time
+	percent float64
+	bytes   int64
+}
+
+// update starts measuring again on a new phase and returns the bytes per
+// second, 0 when unknown, and the time left, 0 when unknown.
+func (r *progressRate) update(p Progress) (float64, time.Duration) {
+	now := progressNow()
+	if p.Phase != r.phase || r.start.IsZero() || p.Percent < r.percent {
+		*r = progressRate{phase: p.Phase, start: now, percent: p.Percent, bytes: p.Bytes}
+		return 0, 0
+	}
+	elapsed := now.Sub(r.start)
+	if elapsed < time.Second || p.Percent <= r.percent {
+		return 0, 0
+	}
+
+	var rate float64
+	if p.Bytes > r.bytes {
+		rate = float64(p.Bytes-r.bytes) / elapsed.Seconds()
+	}
+	left := time.Duration(float64(elapsed) * (100 - p.Percent) / (p.Percent - r.percent))
+	return rate, left.Round(time.Second)
+}
+
+// formatProgressRate returns ", 52.5 MiB/s, ETA 8s" for the rate and time
+// left, leaving out what is unknown.
+func formatProgressRate(rate float64, left time.Duration) string {
+	var s string
+	if rate > 0 {
+		s += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+	}
+	if left > 0 {
+		s += fmt.Sprintf(", ETA %s", left)
+	}
+	return s
+}
+
+// progressBarWidth is the number of cells of the bar BarProgress draws.
+const progressBarWidth = 30
+
+// progressBarInterval is how often BarProgress redraws at most.
+var progressBarInterval = 100 * time.Millisecond
+
+// BarProgress returns a ProgressFunc that draws a progress bar with the
+// rate and time left on w, redrawing the line in place, for terminals. Messages
+// are printed above the bar. Use PrintProgress where w is no terminal.
+func BarProgress(w io.Writer) ProgressFunc {
+	var rate progressRate
+	var drawn time.Time
+	bar := "" // the bar on the current line, "" when there is none
+	return func(p Progress) {
+		if bar != "" && p.Phase != rate.phase {
+			fmt.Fprintln(w)
+			bar = ""
+		}
+		if p.Message != "" {
+			fmt.Fprintf(w, "\r\x1b[K%s\n", p.Message)
+			if bar != "" {
+				fmt.Fprint(w, bar)
+			}
+		}
+		// A message saying a phase is done right away needs no bar.
+		if p.Percent < 0 || (bar == "" && p.Message != "" && p.Percent >= 100) {
+			return
+		}
+
+		bytesPerSecond, left := rate.update(p)
+		now := progressNow()
+		if p.Percent < 100 && bar != "" && now.Sub(drawn) < progressBarInterval {
+			return
+		}
+		drawn = now
+
+		filled := min(progressBarWidth, int(p.Percent*progressBarWidth/100))
+		bar = fmt.Sprintf("\r\x1b[K%s [%s%s] %3.0f%%", p.Phase, strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), p.Percent)
+		if p.Total > 0 {
+			bar += fmt.Sprintf(" %s of %s", formatBytes(p.Bytes), formatBytes(p.Total))
+		}
+		bar += formatProgressRate(bytesPerSecond, left)
+		fmt.Fprint(w, bar)
+		if p.Percent >= 100 {
+			fmt.Fprintln(w)
+			bar = ""
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package proxmox
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProgressClock makes progressNow return start plus the returned step
+// each call, and restores it at the end of the test.
+func fakeProgressClock(t *testing.T, step time.Duration) {
+	t.Helper()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := progressNow
+	progressNow = func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+	t.Cleanup(func() { progressNow = old })
+}
+
+func TestPrintProgressRate(t *testing.T) {
+	fakeProgressClock(t, 2*time.Second)
+
+	var out strings.Builder
+	progress := PrintProgress(&out)
+	const mib = 1 << 20
+	for _, done := range []int64{0, 10, 20} {
+		progress(Progress{Phase: "download", Bytes: done * mib, Total: 40 * mib, Percent: percentOf(done, 40)})
+	}
+
+	want := "  download: 0% (0 B of 40.0 MiB)\n" +
+		"  download: 25% (10.0 MiB of 40.0 MiB, 5.0 MiB/s, ETA 6s)\n" +
+		"  download: 50% (20.0 MiB of 40.0 MiB, 5.0 MiB/s, ETA 4s)\n"
+	if out.String() != want {
+		t.Errorf("PrintProgress wrote\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestBarProgress(t *testing.T) {
+	fakeProgressClock(t, time.Second)
+
+	var out strings.Builder
+	progress := BarProgress(&out)
+	progress(Progress{Phase: "upload", Percent: 0, Bytes: 0, Total: 100})
+	progress(Progress{Phase: "upload", Message: "uploading disk.img", Percent: -1})
+	progress(Progress{Phase: "upload", Percent: 50, Bytes: 50, Total: 100})
+	progress(Progress{Phase: "upload", Percent: 100, Bytes: 100, Total: 100})
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 3 || lines[2] != "" {
+		t.Fatalf("BarProgress wrote %q, want the message and the finished bar on their own lines", out.String())
+	}
+	if !strings.HasSuffix(lines[0], "uploading disk.img") {
+		t.Errorf("first line %q, want the message", lines[0])
+	}
+	bars := strings.Split(lines[1], "\r\x1b[K")
+	last := bars[len(bars)-1]
+	if want := "upload [" + strings.Repeat("=", progressBarWidth) + "] 100% 100 B of 100 B, 25 B/s"; last != want {
+		t.Errorf("last bar %q, want %q", last, want)
+	}
+	if !strings.Contains(lines[1], "]  50% 50 B of 100 B, 25 B/s, ETA 2s") {
+		t.Errorf("bars %q, want the half way bar with rate and ETA", lines[1])
+	}
+}
@@ -0,0 +1,163 @@
+package proxmox
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// ManagedTag is the Proxmox tag of the VMs dtt creates, it tells them apart
+// from the other VMs of a cluster.
+const ManagedTag = "dtt"
+
+// ProvenanceMarker starts the block dtt appends to the description of the VMs
+// it creates. Everything after it is provenance, everything before it is the
+// user's own description.
+const ProvenanceMarker = "---\ncreated by dtt"
+
+// Provenance records where a dtt created VM came from and what it is for. It
+// is kept in the VM description, the credentials are referenced by user name
+// only.
+type Provenance struct {
+	Version string    `json:"version,omitempty" yaml:"version,omitempty"`
+	Image   string    `json:"image,omitempty" yaml:"image,omitempty"`     // URL of the cloud image, empty for VMs without one
+	Purpose string    `json:"purpose,omitempty" yaml:"purpose,omitempty"` // e.g. "run", "cloudinit" or a manifest machine
+	User    string    `json:"user,omitempty" yaml:"user,omitempty"`       // cloud-init user
+	Creator string    `json:"creator,omitempty" yaml:"creator,omitempty"`
+	Created time.Time `json:"created,omitzero" yaml:"created,omitempty"`
+	Expires time.Time `json:"expires,omitzero" yaml:"expires,omitempty"` // zero for VMs without a TTL, see CollectGarbage
+}
+
+// NewProvenance describes a VM created now by LocalCreator from image.
+func NewProvenance(version, image, purpose, user string) Provenance {
+	return Provenance{
+		Version: version,
+		Image:   image,
+		Purpose: purpose,
+		User:    user,
+		Creator: LocalCreator(),
+		Created: time.Now(),
+	}
+}
+
+// WithTTL returns p expiring ttl after its creation, a ttl of 0 never
+// expires.
+func (p Provenance) WithTTL(ttl time.Duration) Provenance {
+	if ttl > 0 {
+		p.Expires = p.Created.Add(ttl)
+	} else {
+		p.Expires = time.Time{}
+	}
+	return p
+}
+
+// Expired reports whether the TTL of the VM ran out at now.
+func (p Provenance) Expired(now time.Time) bool {
+	return !p.Expires.IsZero() && !now.Before(p.Expires)
+}
+
+// LocalCreator returns local-user@host.
+func LocalCreator() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		name += "@" + host
+	}
+	return name
+}
+
+func (p Provenance) String() string {
+	var sb strings.Builder
+	sb.WriteString(ProvenanceMarker)
+	if p.Version != "" {
+		sb.WriteString(" " + p.Version)
+	}
+	sb.WriteString("\n\n")
+	if p.Image != "" {
+		fmt.Fprintf(&sb, "- image: %s\n", p.Image)
+	}
+	if p.Purpose != "" {
+		fmt.Fprintf(&sb, "- purpose: %s\n", p.Purpose)
+	}
+	if p.User != "" {
+		fmt.Fprintf(&sb, "- user: %s\n", p.User)
+	}
+	fmt.Fprintf(&sb, "- creator: %s\n", p.Creator)
+	fmt.Fprintf(&sb, "- created: %s\n", p.Created.UTC().Format(time.RFC3339))
+	if !p.Expires.IsZero() {
+		fmt.Fprintf(&sb, "- expires: %s\n", p.Expires.UTC().Format(time.RFC3339))
+	}
+	return sb.String()
+}
+
+// ParseProvenance reads the provenance block of a VM description, it reports
+// false for VMs dtt did not create.
+func ParseProvenance(description string) (Provenance, bool) {
+	_, block := SplitDescription(description)
+	if block == "" {
+		return Provenance{}, false
+	}
+
+	var p Provenance
+	lines := strings.Split(block, "\n")
+	p.Version = strings.TrimSpace(strings.TrimPrefix(lines[1], "created by dtt"))
+	for _, line := range lines[2:] {
+		key, value, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "- "), ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "image":
+			p.Image = value
+		case "purpose":
+			p.Purpose = value
+		case "user":
+			p.User = value
+		case "creator":
+			p.Creator = value
+		case "created":
+			p.Created, _ = time.Parse(time.RFC3339, value)
+		case "expires":
+			p.Expires, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return p, true
+}
+
+// JoinDescription joins a user supplied description and a provenance block.
+func JoinDescription(text string, provenance string) string {
+	text = strings.TrimSpace(text)
+	provenance = strings.TrimSpace(provenance)
+	switch {
+	case text == "":
+		return provenance
+	case provenance == "":
+		return text
+	default:
+		return text + "\n\n" + provenance
+	}
+}
+
+// SplitDescription separates the user's description from the provenance
+// block dtt added when creating the VM.
+func SplitDescription(description string) (text string, provenance string) {
+	idx := strings.Index(description, ProvenanceMarker)
+	if idx < 0 {
+		return strings.TrimSpace(description), ""
+	}
+	return strings.TrimSpace(description[:idx]), strings.TrimSpace(description[idx:])
+}
+
+// HasTag reports whether the Proxmox tags, see ResourceTags, contain tag.
+func HasTag(tags string, tag string) bool {
+	for _, t := range ResourceTags(tags) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
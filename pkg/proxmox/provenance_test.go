@@ -0,0 +1,46 @@
+package proxmox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProvenanceRoundTrip(t *testing.T) {
+	p := Provenance{
+		Version: "v1.2.3",
+		Image:   "https://example.com/noble.img",
+		Purpose: "run hello",
+		User:    "dtt",
+		Creator: "alice@laptop",
+		Created: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}.WithTTL(2 * time.Hour)
+	description := JoinDescription("my notes", p.String())
+
+	text, _ := SplitDescription(description)
+	if text != "my notes" {
+		t.Errorf("Expected the user's notes, got %q", text)
+	}
+	got, ok := ParseProvenance(description)
+	if !ok || got != p {
+		t.Errorf("ParseProvenance() = %+v, %v, want %+v", got, ok, p)
+	}
+
+	if p.Expired(p.Created.Add(time.Hour)) || !p.Expired(p.Created.Add(2*time.Hour)) {
+		t.Errorf("Expected the VM to expire 2h after its creation, at %v", p.Expires)
+	}
+	if p.WithTTL(0).Expired(p.Created.Add(1000 * time.Hour)) {
+		t.Error("Expected a VM without a TTL never to expire")
+	}
+
+	if _, ok := ParseProvenance("just notes"); ok {
+		t.Error("Expected no provenance in a description dtt did not write")
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	for tags, want := range map[string]bool{"dtt": true, "prod;dtt": true, "dtt-old,web": false, "": false} {
+		if got := HasTag(tags, ManagedTag); got != want {
+			t.Errorf("HasTag(%q) = %v, want %v", tags, got, want)
+		}
+	}
+}
@@ -0,0 +1,1136 @@
+// Package proxmoxtest provides an in-memory fake of the Proxmox VE API for
+// tests. It implements the endpoints dtt uses closely enough for the
+// go-proxmox client: nodes, VMs and LXC containers, their configs and VM
+// snapshots, cluster resources, storage content, appliance templates, the
+// file and exec commands of guest agents, and tasks. Tasks complete
+// immediately, unless RunTasks keeps them running until they are stopped.
+package proxmoxtest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// Node is a fake cluster node.
+type Node struct {
+	Name    string
+	Status  string // "online" unless set
+	MaxCPU  int
+	CPU     float64 // the fraction of the CPUs in use
+	MaxMem  uint64
+	Mem     uint64
+	Storage map[string][]string // storage name to volume IDs
+	// StorageContent overrides the content types of a storage, which are
+	// "images,import,iso" unless set.
+	StorageContent map[string]string
+	Bridges        []string // "vmbr0" unless set
+	// Appliances are the container templates aplinfo offers for download.
+	Appliances []string
+}
+
+// VM is a fake qemu VM, or an LXC container if LXC is set.
+type VM struct {
+	Node     string
+	VMID     uint64
+	LXC      bool
+	Name     string
+	Status   string // "stopped" unless set
+	Lock     string
+	Tags     string
+	Pool     string
+	Template bool
+	MaxMem   uint64
+	CPUs     int
+	// Config holds the VM config as returned by /config, options set through
+	// the API are stored here.
+	Config map[string]interface{}
+	// Snapshots are the snapshots of the VM, oldest first, and
+	// CurrentSnapshot the one it runs on top of.
+	Snapshots       []Snapshot
+	CurrentSnapshot string
+	// Files are the files in the guest, as its agent reads and writes them
+	// while the VM runs.
+	Files map[string][]byte
+}
+
+// Snapshot is a snapshot of a fake VM.
+type Snapshot struct {
+	Name        string
+	Description string
+	Parent      string
+	VMState     bool
+	Time        time.Time
+}
+
+// Request is a request the server received, with the /api2/json prefix
+// removed from the path.
+type Request struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// Task is a task the server started.
+type Task struct {
+	UPID       string
+	Node       string
+	Type       string
+	ID         string
+	ExitStatus string
+	StartTime  time.Time
+	EndTime    time.Time
+	Log        []string
+}
+
+type failure struct {
+	method string
+	path   string
+	status int
+	msg    string
+}
+
+// Server is a fake Proxmox API server.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	nodes     []*Node
+	vms       map[uint64]*VM
+	tasks     []*Task
+	requests  []Request
+	failures  []failure
+	taskFails map[string]string
+	taskRuns  map[string]bool
+	taskLogs  map[string][]string
+	// volumeSizes holds the size of uploaded volumes, others are 1 MiB.
+	volumeSizes map[string]int64
+	// volumeTimes holds when volumes were uploaded or downloaded.
+	volumeTimes map[string]time.Time
+	nextID      uint64
+	pid         int
+	// agentExec runs the commands of agent/exec, see HandleAgentExec.
+	agentExec func(vm *VM, command []string) (int, string, string)
+	execs     map[int]map[string]interface{}
+	// agentReadMax is the most agent/file-read returns at once.
+	agentReadMax int
+}
+
+// NewServer starts a fake server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		vms:       map[uint64]*VM{},
+		taskFails: map[string]string{},
+		taskRuns:  map[string]bool{},
+		taskLogs:  map[string][]string{},
+
+		volumeSizes:  map[string]int64{},
+		volumeTimes:  map[string]time.Time{},
+		execs:        map[int]map[string]interface{}{},
+		agentReadMax: 16 << 20,
+		nextID:       100,
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a go-proxmox client talking to the server with an API token.
+func (s *Server) Client() *proxmox.Client {
+	return proxmox.NewClient(s.URL+"/api2/json",
+		proxmox.WithHTTPClient(s.Server.Client()),
+		proxmox.WithAPIToken("test@pve!dtt", "secret"))
+}
+
+// AddNode adds an online node.
+func (s *Server) AddNode(name string) *Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := &Node{Name: name, Status: "online", MaxCPU: 8, MaxMem: 32 << 30, Storage: map[string][]string{"local": nil, "local-lvm": nil}, StorageContent: map[string]string{}, Bridges: []string{"vmbr0"}}
+	s.nodes = append(s.nodes, n)
+	return n
+}
+
+// AddVM adds a VM. Its node must exist.
+func (s *Server) AddVM(vm VM) *VM {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if vm.Status == "" {
+		vm.Status = "stopped"
+	}
+	if vm.Config == nil {
+		vm.Config = map[string]interface{}{}
+	}
+	if vm.Name != "" {
+		vm.Config["name"] = vm.Name
+	}
+	if vm.VMID >= s.nextID {
+		s.nextID = vm.VMID + 1
+	}
+	v := vm
+	s.vms[vm.VMID] = &v
+	return &v
+}
+
+// VM returns a copy of the VM with vmid, or nil.
+func (s *Server) VM(vmid uint64) *VM {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vm, ok := s.vms[vmid]
+	if !ok {
+		return nil
+	}
+	c := *vm
+	c.Config = map[string]interface{}{}
+	for k, v := range vm.Config {
+		c.Config[k] = v
+	}
+	c.Snapshots = append([]Snapshot(nil), vm.Snapshots...)
+	return &c
+}
+
+// SetVolumeTime sets when the volume volID was created, as storage content
+// reports it.
+func (s *Server) SetVolumeTime(volID string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumeTimes[volID] = t
+}
+
+// HandleAgentExec makes the guest agents run the commands of agent/exec with
+// handle, which returns the exit code, stdout and stderr of command. handle
+// is called with the server locked and may change vm.Files. Without it every
+// command exits 0.
+func (s *Server) HandleAgentExec(handle func(vm *VM, command []string) (exitCode int, stdout, stderr string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentExec = handle
+}
+
+// SetAgentFileReadMax sets the most agent/file-read returns at once, 16 MiB
+// like Proxmox unless set.
+func (s *Server) SetAgentFileReadMax(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentReadMax = n
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Tasks returns the tasks started so far.
+func (s *Server) Tasks() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, *t)
+	}
+	return tasks
+}
+
+// Fail makes requests with method to path (without /api2/json) fail with
+// status and msg. An empty method matches every method.
+func (s *Server) Fail(method, path string, status int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{method: method, path: path, status: status, msg: msg})
+}
+
+// TaskLog makes tasks of taskType (e.g. "download") log lines.
+func (s *Server) TaskLog(taskType string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskLogs[taskType] = lines
+}
+
+// FailTasks makes tasks of taskType (e.g. "qmstart") finish with exitStatus
+// instead of "OK".
+func (s *Server) FailTasks(taskType, exitStatus string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskFails[taskType] = exitStatus
+}
+
+// RunTasks makes tasks of taskType keep running until they are stopped,
+// which ends them with exit status "interrupted by signal".
+func (s *Server) RunTasks(taskType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskRuns[taskType] = true
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api2/json")
+
+	body := map[string]interface{}{}
+	var upload *uploadedFile
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var err error
+		if upload, err = readUpload(r, body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Body: body})
+
+	for _, f := range s.failures {
+		if (f.method == "" || f.method == r.Method) && f.path == path {
+			writeError(w, f.status, f.msg)
+			return
+		}
+	}
+
+	var data interface{}
+	var status int
+	var err error
+	if upload != nil {
+		data, status, err = s.upload(strings.Split(strings.Trim(path, "/"), "/"), body, upload)
+	} else {
+		data, status, err = s.route(r.Method, strings.Split(strings.Trim(path, "/"), "/"), r, body)
+	}
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// uploadedFile is the file part of a storage upload.
+type uploadedFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// readUpload reads a multipart upload, putting the form fields in body.
+func readUpload(r *http.Request, body map[string]interface{}) (*uploadedFile, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var upload *uploadedFile
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() == "" {
+			value, _ := io.ReadAll(part)
+			body[part.FormName()] = string(value)
+			continue
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, part)
+		if err != nil {
+			return nil, err
+		}
+		upload = &uploadedFile{Name: part.FileName(), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+	if upload == nil {
+		return nil, fmt.Errorf("no file in upload")
+	}
+	return upload, nil
+}
+
+// upload stores an uploaded file as a storage volume, checking the checksum
+// like Proxmox does when one is given.
+func (s *Server) upload(p []string, body map[string]interface{}, upload *uploadedFile) (interface{}, int, error) {
+	if len(p) != 5 || p[0] != "nodes" || p[2] != "storage" || p[4] != "upload" {
+		return nil, http.StatusNotImplemented, errNotFound
+	}
+	node := s.node(p[1])
+	if node == nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("hostname lookup '%s' failed - failed to get address info", p[1])
+	}
+	if sum, ok := body["checksum"]; ok && sum != upload.SHA256 {
+		return nil, http.StatusInternalServerError, fmt.Errorf("checksum mismatch: got '%s' != expected '%s'", upload.SHA256, sum)
+	}
+	volID := fmt.Sprintf("%s:%s/%s", p[3], body["content"], upload.Name)
+	node.Storage[p[3]] = append(node.Storage[p[3]], volID)
+	s.volumeSizes[volID] = upload.Size
+	s.volumeTimes[volID] = time.Now()
+	return s.newTask(node.Name, "imgcopy", ""), 0, nil
+}
+
+// writeError replies with status and msg as the reason phrase of the status
+// line, which is where pveproxy puts error messages. net/http only writes the
+// standard reason phrases, so the connection is taken over to write it.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	body := fmt.Sprintf(`{"data":null,"message":%q}`, msg+"\n")
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, body, status)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, body, status)
+		return
+	}
+	defer conn.Close()
+	reason := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", status, reason, len(body), body)
+	_ = rw.Flush()
+}
+
+var errNotFound = fmt.Errorf("no such endpoint")
+
+func (s *Server) route(method string, p []string, r *http.Request, body map[string]interface{}) (interface{}, int, error) {
+	get := method == http.MethodGet
+
+	switch {
+	case get && match(p, "version"):
+		return map[string]string{"version": "8.2.4", "release": "8.2", "repoid": "fake"}, 0, nil
+	case get && match(p, "nodes"):
+		return s.nodeList(), 0, nil
+	case get && match(p, "cluster", "status"):
+		return s.clusterStatus(), 0, nil
+	case get && match(p, "cluster", "resources"):
+		return s.resources(r.URL.Query().Get("type")), 0, nil
+	case get && match(p, "cluster", "tasks"):
+		return s.taskList(), 0, nil
+	case get && match(p, "cluster", "nextid"):
+		return strconv.FormatUint(s.nextID, 10), 0, nil
+	case len(p) >= 2 && p[0] == "nodes":
+		node := s.node(p[1])
+		if node == nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("hostname lookup '%s' failed - failed to get address info", p[1])
+		}
+		return s.routeNode(method, node, p[2:], r.URL.Query(), body)
+	}
+	return nil, http.StatusNotImplemented, errNotFound
+}
+
+func (s *Server) routeNode(method string, node *Node, p []string, query url.Values, body map[string]interface{}) (interface{}, int, error) {
+	get := method == http.MethodGet
+	post := method == http.MethodPost || method == http.MethodPut
+
+	switch {
+	case get && match(p, "status"):
+		return map[string]interface{}{
+			"uptime":     1000,
+			"cpu":        0.1,
+			"kversion":   "Linux 6.8.12-4-pve #1 SMP PREEMPT_DYNAMIC PMX 6.8.12-4",
+			"pveversion": "pve-manager/8.2.4/faa83925c9641325",
+			"cpuinfo":    map[string]interface{}{"model": "Fake CPU @ 3.00GHz", "cpus": node.MaxCPU, "cores": node.MaxCPU, "sockets": 1},
+			"memory":     map[string]interface{}{"used": node.Mem, "total": node.MaxMem, "free": node.MaxMem - node.Mem},
+		}, 0, nil
+	case get && match(p, "subscription"):
+		return map[string]interface{}{"status": "notfound", "message": "There is no subscription key"}, 0, nil
+	case get && match(p, "qemu"):
+		var vms []map[string]interface{}
+		for _, vm := range s.sortedVMs() {
+			if vm.Node == node.Name && !vm.LXC {
+				vms = append(vms, vmStatus(vm))
+			}
+		}
+		return vms, 0, nil
+	case post && match(p, "qemu"):
+		return s.createVM(node, body, false)
+	case get && match(p, "lxc"):
+		var cts []map[string]interface{}
+		for _, vm := range s.sortedVMs() {
+			if vm.Node == node.Name && vm.LXC {
+				cts = append(cts, vmStatus(vm))
+			}
+		}
+		return cts, 0, nil
+	case post && match(p, "lxc"):
+		return s.createVM(node, body, true)
+	case len(p) >= 2 && (p[0] == "qemu" || p[0] == "lxc"):
+		lxc := p[0] == "lxc"
+		vmid, err := strconv.ParseUint(p[1], 10, 64)
+		vm, ok := s.vms[vmid]
+		if err != nil || !ok || vm.Node != node.Name || vm.LXC != lxc {
+			if lxc {
+				return nil, http.StatusInternalServerError, fmt.Errorf("Configuration file 'nodes/%s/lxc/%s.conf' does not exist", node.Name, p[1])
+			}
+			return nil, http.StatusInternalServerError, fmt.Errorf("Configuration file 'nodes/%s/qemu-server/%s.conf' does not exist", node.Name, p[1])
+		}
+		return s.routeVM(method, vm, p[2:], query, body)
+	case get && match(p, "aplinfo"):
+		appliances := []map[string]interface{}{}
+		for _, template := range node.Appliances {
+			appliances = append(appliances, map[string]interface{}{"template": template, "package": strings.SplitN(template, "_", 2)[0], "type": "lxc", "section": "system", "headline": template})
+		}
+		return appliances, 0, nil
+	case post && match(p, "aplinfo"):
+		template, storage := fmt.Sprint(body["template"]), fmt.Sprint(body["storage"])
+		if !slices.Contains(node.Appliances, template) {
+			return nil, http.StatusInternalServerError, fmt.Errorf("no such template '%s'", template)
+		}
+		node.Storage[storage] = append(node.Storage[storage], fmt.Sprintf("%s:vztmpl/%s", storage, template))
+		return s.newTask(node.Name, "download", template), 0, nil
+	case get && len(p) == 3 && p[0] == "tasks" && p[2] == "status":
+		return s.taskStatus(p[1])
+	case get && len(p) == 3 && p[0] == "tasks" && p[2] == "log":
+		start, _ := strconv.Atoi(query.Get("start"))
+		return s.taskLog(p[1], start)
+	case method == http.MethodDelete && len(p) == 2 && p[0] == "tasks":
+		return s.stopTask(p[1])
+	case get && match(p, "storage"):
+		names := make([]string, 0, len(node.Storage))
+		for name := range node.Storage {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := make([]map[string]interface{}, 0, len(names))
+		for _, name := range names {
+			list = append(list, s.storageStatus(node, name))
+		}
+		return list, 0, nil
+	case get && len(p) == 3 && p[0] == "storage" && p[2] == "status":
+		if _, ok := node.Storage[p[1]]; !ok {
+			return nil, http.StatusInternalServerError, fmt.Errorf("storage '%s' does not exist", p[1])
+		}
+		return s.storageStatus(node, p[1]), 0, nil
+	case get && match(p, "network"):
+		var networks []map[string]interface{}
+		for _, bridge := range node.Bridges {
+			networks = append(networks, map[string]interface{}{"iface": bridge, "type": "bridge", "active": 1})
+		}
+		return networks, 0, nil
+	case get && len(p) == 3 && p[0] == "storage" && p[2] == "content":
+		var content []map[string]interface{}
+		for _, volid := range node.Storage[p[1]] {
+			size, ok := s.volumeSizes[volid]
+			if !ok {
+				size = 1 << 20
+			}
+			kind := volumeContent(volid)
+			if c := query.Get("content"); c != "" && c != kind {
+				continue
+			}
+			item := map[string]interface{}{"volid": volid, "format": "qcow2", "size": size, "content": kind}
+			if t, ok := s.volumeTimes[volid]; ok {
+				item["ctime"] = t.Unix()
+			}
+			content = append(content, item)
+		}
+		return content, 0, nil
+	case post && len(p) == 3 && p[0] == "storage" && p[2] == "download-url":
+		filename := fmt.Sprint(body["filename"])
+		volID := fmt.Sprintf("%s:%s/%s", p[1], body["content"], filename)
+		for _, v := range node.Storage[p[1]] {
+			if v == volID {
+				return nil, http.StatusInternalServerError, fmt.Errorf("refusing to override existing file '%s'", filename)
+			}
+		}
+		node.Storage[p[1]] = append(node.Storage[p[1]], volID)
+		s.volumeTimes[volID] = time.Now()
+		return s.newTask(node.Name, "download", filename), 0, nil
+	case method == http.MethodDelete && len(p) >= 4 && p[0] == "storage" && p[2] == "content":
+		volID := strings.Join(p[3:], "/")
+		volumes := node.Storage[p[1]]
+		for i, v := range volumes {
+			if v == volID {
+				node.Storage[p[1]] = append(volumes[:i:i], volumes[i+1:]...)
+				delete(s.volumeSizes, volID)
+				delete(s.volumeTimes, volID)
+				return s.newTask(node.Name, "imgdel", ""), 0, nil
+			}
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("volume '%s' does not exist", volID)
+	}
+	return nil, http.StatusNotImplemented, errNotFound
+}
+
+func (s *Server) routeVM(method string, vm *VM, p []string, query url.Values, body map[string]interface{}) (interface{}, int, error) {
+	get := method == http.MethodGet
+	post := method == http.MethodPost || method == http.MethodPut
+	id := strconv.FormatUint(vm.VMID, 10)
+	prefix := taskPrefix(vm)
+
+	switch {
+	case get && match(p, "status", "current"):
+		return vmStatus(vm), 0, nil
+	case get && match(p, "config"):
+		return vm.Config, 0, nil
+	case post && match(p, "config"):
+		for k, v := range body {
+			if k == "delete" {
+				for _, d := range strings.Split(fmt.Sprint(v), ",") {
+					delete(vm.Config, strings.TrimSpace(d))
+				}
+				continue
+			}
+			vm.Config[k] = v
+			if k == "name" {
+				vm.Name = fmt.Sprint(v)
+			}
+			if k == "tags" {
+				vm.Tags = fmt.Sprint(v)
+			}
+		}
+		return s.newTask(vm.Node, prefix+"config", id), 0, nil
+	case get && match(p, "snapshot"):
+		return vmSnapshots(vm), 0, nil
+	case post && match(p, "snapshot"):
+		return s.snapshot(vm, body)
+	case post && len(p) == 3 && p[0] == "snapshot" && p[2] == "rollback":
+		return s.rollback(vm, p[1], body)
+	case method == http.MethodDelete && len(p) == 2 && p[0] == "snapshot":
+		return s.deleteSnapshot(vm, p[1])
+	case post && match(p, "clone"):
+		return s.clone(vm, body)
+	case post && match(p, "template"):
+		if vm.Template {
+			return nil, http.StatusInternalServerError, fmt.Errorf("you can't convert a template to a template")
+		}
+		if vm.Status == "running" {
+			return nil, http.StatusInternalServerError, fmt.Errorf("you can't convert a VM to template if VM is running")
+		}
+		vm.Template = true
+		return s.newTask(vm.Node, taskPrefix(vm)+"template", id), 0, nil
+	case post && match(p, "resize"):
+		return s.newTask(vm.Node, "resize", id), 0, nil
+	case len(p) == 2 && p[0] == "agent":
+		return s.agent(method, vm, p[1], query, body)
+	case post && len(p) == 2 && p[0] == "status":
+		return s.changeStatus(vm, p[1], body)
+	case method == http.MethodDelete && len(p) == 0:
+		if vm.Status == "running" {
+			return nil, http.StatusInternalServerError, fmt.Errorf("VM %d is running - destroy failed", vm.VMID)
+		}
+		delete(s.vms, vm.VMID)
+		return s.newTask(vm.Node, prefix+"destroy", id), 0, nil
+	}
+	return nil, http.StatusNotImplemented, errNotFound
+}
+
+// agent answers the guest agent command of vm, for the file and exec
+// commands dtt uses.
+func (s *Server) agent(method string, vm *VM, command string, query url.Values, body map[string]interface{}) (interface{}, int, error) {
+	if vm.Status != "running" {
+		return nil, http.StatusInternalServerError, fmt.Errorf("VM %d is not running", vm.VMID)
+	}
+	if vm.Files == nil {
+		vm.Files = map[string][]byte{}
+	}
+
+	switch {
+	case method == http.MethodPost && command == "ping":
+		return nil, 0, nil
+	case method == http.MethodPost && command == "file-write":
+		content := []byte(fmt.Sprint(body["content"]))
+		if fmt.Sprint(body["encode"]) == "0" {
+			var err error
+			if content, err = base64.StdEncoding.DecodeString(string(content)); err != nil {
+				return nil, http.StatusInternalServerError, fmt.Errorf("content is not base64: %v", err)
+			}
+		}
+		if len(content) > 45<<10 {
+			return nil, http.StatusBadRequest, fmt.Errorf("content: value may only be 61440 characters long")
+		}
+		vm.Files[fmt.Sprint(body["file"])] = content
+		return nil, 0, nil
+	case method == http.MethodGet && command == "file-read":
+		content, ok := vm.Files[query.Get("file")]
+		if !ok {
+			return nil, http.StatusInternalServerError, fmt.Errorf("Agent error: Failed to open file '%s': No such file or directory", query.Get("file"))
+		}
+		result := map[string]interface{}{}
+		if len(content) > s.agentReadMax {
+			content = content[:s.agentReadMax]
+			result["truncated"] = 1
+		}
+		// Proxmox returns the bytes of the file as latin1 characters.
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		result["content"] = string(runes)
+		result["bytes-read"] = len(content)
+		return result, 0, nil
+	case method == http.MethodPost && command == "exec":
+		var args []string
+		if list, ok := body["command"].([]interface{}); ok {
+			for _, arg := range list {
+				args = append(args, fmt.Sprint(arg))
+			}
+		}
+		code, stdout, stderr := 0, "", ""
+		if s.agentExec != nil {
+			code, stdout, stderr = s.agentExec(vm, args)
+		}
+		s.pid++
+		s.execs[s.pid] = map[string]interface{}{
+			"exited":   1,
+			"exitcode": code,
+			"out-data": stdout,
+			"err-data": stderr,
+		}
+		return map[string]interface{}{"pid": s.pid}, 0, nil
+	case method == http.MethodGet && command == "exec-status":
+		pid, _ := strconv.Atoi(query.Get("pid"))
+		status, ok := s.execs[pid]
+		if !ok {
+			return nil, http.StatusInternalServerError, fmt.Errorf("Agent error: Invalid parameter 'pid'")
+		}
+		return status, 0, nil
+	}
+	return nil, http.StatusNotImplemented, errNotFound
+}
+
+// createVM creates a VM, or a container if lxc is set. Containers are named
+// by their hostname and started right away with start=1, like Proxmox does.
+func (s *Server) createVM(node *Node, body map[string]interface{}, lxc bool) (interface{}, int, error) {
+	vmid, err := strconv.ParseUint(fmt.Sprint(body["vmid"]), 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid vmid %v", body["vmid"])
+	}
+	if _, exists := s.vms[vmid]; exists {
+		return nil, http.StatusInternalServerError, fmt.Errorf("unable to create VM %d - VM %d already exists", vmid, vmid)
+	}
+
+	vm := &VM{Node: node.Name, VMID: vmid, LXC: lxc, Status: "stopped", Config: map[string]interface{}{}}
+	for k, v := range body {
+		if k == "vmid" {
+			continue
+		}
+		vm.Config[k] = v
+	}
+	if name, ok := body["name"]; ok {
+		vm.Name = fmt.Sprint(name)
+	}
+	if tags, ok := body["tags"]; ok {
+		vm.Tags = fmt.Sprint(tags)
+	}
+	if lxc {
+		if hostname, ok := body["hostname"]; ok {
+			vm.Name = fmt.Sprint(hostname)
+		}
+		if fmt.Sprint(body["start"]) == "1" || body["start"] == true {
+			vm.Status = "running"
+		}
+	}
+	s.vms[vmid] = vm
+	if vmid >= s.nextID {
+		s.nextID = vmid + 1
+	}
+	return s.newTask(node.Name, taskPrefix(vm)+"create", strconv.FormatUint(vmid, 10)), 0, nil
+}
+
+func (s *Server) changeStatus(vm *VM, action string, body map[string]interface{}) (interface{}, int, error) {
+	id := strconv.FormatUint(vm.VMID, 10)
+	switch action {
+	case "start":
+		if vm.Status == "running" {
+			return nil, http.StatusInternalServerError, fmt.Errorf("VM %d already running", vm.VMID)
+		}
+		vm.Status, vm.Lock = "running", ""
+	case "stop", "shutdown":
+		vm.Status = "stopped"
+	case "reboot", "reset":
+		if vm.Status != "running" {
+			return nil, http.StatusInternalServerError, fmt.Errorf("VM %d not running", vm.VMID)
+		}
+	case "suspend":
+		if fmt.Sprint(body["todisk"]) == "1" {
+			vm.Status, vm.Lock = "stopped", "suspended"
+			action = "hibernate"
+		}
+	case "resume":
+	default:
+		return nil, http.StatusNotImplemented, errNotFound
+	}
+	return s.newTask(vm.Node, taskPrefix(vm)+action, id), 0, nil
+}
+
+// taskPrefix returns how the task types of vm start, "vz" for containers and
+// "qm" for VMs.
+func taskPrefix(vm *VM) string {
+	if vm.LXC {
+		return "vz"
+	}
+	return "qm"
+}
+
+// clone copies vm to the VM newid in body, onto the target node if given. Like
+// Proxmox it makes full clones of VMs and linked ones of templates unless
+// full is passed, and refuses linked clones of VMs.
+func (s *Server) clone(vm *VM, body map[string]interface{}) (interface{}, int, error) {
+	newid, err := strconv.ParseUint(fmt.Sprint(body["newid"]), 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid newid %v", body["newid"])
+	}
+	if _, exists := s.vms[newid]; exists {
+		return nil, http.StatusInternalServerError, fmt.Errorf("unable to create VM %d: config file already exists", newid)
+	}
+	if full, ok := body["full"]; ok && fmt.Sprint(full) == "0" && !vm.Template {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Linked clone feature is not supported for drive 'scsi0'")
+	}
+
+	c := &VM{Node: vm.Node, VMID: newid, Name: fmt.Sprintf("Copy-of-VM-%s", vm.Name), Status: "stopped", Tags: vm.Tags, Pool: vm.Pool, MaxMem: vm.MaxMem, CPUs: vm.CPUs, Config: map[string]interface{}{}}
+	for k, v := range vm.Config {
+		c.Config[k] = v
+	}
+	if name, ok := body["name"]; ok {
+		c.Name = fmt.Sprint(name)
+	}
+	c.Config["name"] = c.Name
+	if target, ok := body["target"]; ok && target != "" {
+		if s.node(fmt.Sprint(target)) == nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("no such cluster node '%s'", target)
+		}
+		c.Node = fmt.Sprint(target)
+	}
+	s.vms[newid] = c
+	if newid >= s.nextID {
+		s.nextID = newid + 1
+	}
+	return s.newTask(vm.Node, "qmclone", strconv.FormatUint(vm.VMID, 10)), 0, nil
+}
+
+func vmSnapshots(vm *VM) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(vm.Snapshots)+1)
+	for _, snap := range vm.Snapshots {
+		vmstate := 0
+		if snap.VMState {
+			vmstate = 1
+		}
+		entry := map[string]interface{}{"name": snap.Name, "description": snap.Description, "snaptime": snap.Time.Unix(), "vmstate": vmstate}
+		if snap.Parent != "" {
+			entry["parent"] = snap.Parent
+		}
+		list = append(list, entry)
+	}
+	current := map[string]interface{}{"name": "current", "description": "You are here!", "running": 0}
+	if vm.CurrentSnapshot != "" {
+		current["parent"] = vm.CurrentSnapshot
+	}
+	return append(list, current)
+}
+
+func findSnapshot(vm *VM, name string) int {
+	for i, snap := range vm.Snapshots {
+		if snap.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) snapshot(vm *VM, body map[string]interface{}) (interface{}, int, error) {
+	name := fmt.Sprint(body["snapname"])
+	if findSnapshot(vm, name) >= 0 {
+		return nil, http.StatusInternalServerError, fmt.Errorf("snapshot name '%s' already used", name)
+	}
+	snap := Snapshot{Name: name, Parent: vm.CurrentSnapshot, VMState: fmt.Sprint(body["vmstate"]) == "1", Time: time.Now()}
+	if d, ok := body["description"]; ok {
+		snap.Description = fmt.Sprint(d)
+	}
+	vm.Snapshots = append(vm.Snapshots, snap)
+	vm.CurrentSnapshot = name
+	return s.newTask(vm.Node, "qmsnapshot", strconv.FormatUint(vm.VMID, 10)), 0, nil
+}
+
+// rollback rolls vm back to the snapshot name. Like Proxmox it leaves the VM
+// running if the snapshot has the VM state or start=1 is passed, and stopped
+// otherwise.
+func (s *Server) rollback(vm *VM, name string, body map[string]interface{}) (interface{}, int, error) {
+	i := findSnapshot(vm, name)
+	if i < 0 {
+		return nil, http.StatusInternalServerError, fmt.Errorf("snapshot '%s' does not exist", name)
+	}
+	vm.Status = "stopped"
+	if vm.Snapshots[i].VMState || fmt.Sprint(body["start"]) == "1" {
+		vm.Status = "running"
+	}
+	vm.CurrentSnapshot = name
+	return s.newTask(vm.Node, "qmrollback", strconv.FormatUint(vm.VMID, 10)), 0, nil
+}
+
+func (s *Server) deleteSnapshot(vm *VM, name string) (interface{}, int, error) {
+	i := findSnapshot(vm, name)
+	if i < 0 {
+		return nil, http.StatusInternalServerError, fmt.Errorf("snapshot '%s' does not exist", name)
+	}
+	parent := vm.Snapshots[i].Parent
+	vm.Snapshots = append(vm.Snapshots[:i], vm.Snapshots[i+1:]...)
+	for j := range vm.Snapshots {
+		if vm.Snapshots[j].Parent == name {
+			vm.Snapshots[j].Parent = parent
+		}
+	}
+	if vm.CurrentSnapshot == name {
+		vm.CurrentSnapshot = parent
+	}
+	return s.newTask(vm.Node, "qmdelsnapshot", strconv.FormatUint(vm.VMID, 10)), 0, nil
+}
+
+// storageStatus returns the status of storage name of node, a 100 GiB
+// directory storage.
+func (s *Server) storageStatus(node *Node, name string) map[string]interface{} {
+	content, ok := node.StorageContent[name]
+	if !ok {
+		content = "images,import,iso"
+	}
+	var used int64
+	for _, volid := range node.Storage[name] {
+		size, ok := s.volumeSizes[volid]
+		if !ok {
+			size = 1 << 20
+		}
+		used += size
+	}
+	const total = 100 << 30
+	return map[string]interface{}{"storage": name, "type": "dir", "active": 1, "enabled": 1, "shared": 0, "content": content, "total": total, "used": used, "avail": total - used}
+}
+
+// volumeContent returns the content type of volid, from its path like
+// "local:import/noble.img", or "images" for disks like "local-lvm:vm-100-disk-0".
+func volumeContent(volid string) string {
+	_, path, _ := strings.Cut(volid, ":")
+	if dir, _, ok := strings.Cut(path, "/"); ok {
+		return dir
+	}
+	return "images"
+}
+
+// newTask records a finished task and returns its UPID.
+func (s *Server) newTask(node, taskType, id string) string {
+	s.pid++
+	now := time.Now()
+	upid := fmt.Sprintf("UPID:%s:%08X:%08X:%08X:%s:%s:test@pve!dtt:", node, s.pid, s.pid, now.Unix(), taskType, id)
+
+	exit := "OK"
+	if e, ok := s.taskFails[taskType]; ok {
+		exit = e
+	}
+	task := &Task{UPID: upid, Node: node, Type: taskType, ID: id, ExitStatus: exit, StartTime: now, EndTime: now, Log: s.taskLogs[taskType]}
+	if s.taskRuns[taskType] {
+		task.ExitStatus, task.EndTime = "", time.Time{}
+	}
+	s.tasks = append(s.tasks, task)
+	return upid
+}
+
+func (s *Server) stopTask(upid string) (interface{}, int, error) {
+	for _, t := range s.tasks {
+		if t.UPID == upid {
+			if t.EndTime.IsZero() {
+				t.ExitStatus, t.EndTime = "interrupted by signal", time.Now()
+			}
+			return nil, 0, nil
+		}
+	}
+	return nil, http.StatusInternalServerError, fmt.Errorf("no such task")
+}
+
+func (s *Server) taskStatus(upid string) (interface{}, int, error) {
+	for _, t := range s.tasks {
+		if t.UPID == upid {
+			status := map[string]interface{}{
+				"upid":      t.UPID,
+				"node":      t.Node,
+				"type":      t.Type,
+				"id":        t.ID,
+				"user":      "test@pve!dtt",
+				"status":    "running",
+				"starttime": t.StartTime.Unix(),
+			}
+			if !t.EndTime.IsZero() {
+				status["status"], status["exitstatus"] = "stopped", t.ExitStatus
+			}
+			return status, 0, nil
+		}
+	}
+	return nil, http.StatusInternalServerError, fmt.Errorf("no such task")
+}
+
+// taskLog returns the log lines of a task after line start, numbered from 1
+// like Proxmox does.
+func (s *Server) taskLog(upid string, start int) (interface{}, int, error) {
+	for _, t := range s.tasks {
+		if t.UPID == upid {
+			lines := []map[string]interface{}{}
+			for i, line := range t.Log {
+				if i+1 > start {
+					lines = append(lines, map[string]interface{}{"n": i + 1, "t": line})
+				}
+			}
+			return lines, 0, nil
+		}
+	}
+	return nil, http.StatusInternalServerError, fmt.Errorf("no such task")
+}
+
+func (s *Server) taskList() []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(s.tasks))
+	for i := len(s.tasks) - 1; i >= 0; i-- {
+		t := s.tasks[i]
+		task := map[string]interface{}{
+			"upid":      t.UPID,
+			"node":      t.Node,
+			"type":      t.Type,
+			"id":        t.ID,
+			"user":      "test@pve!dtt",
+			"starttime": t.StartTime.Unix(),
+		}
+		if !t.EndTime.IsZero() {
+			task["status"], task["endtime"] = t.ExitStatus, t.EndTime.Unix()
+		}
+		list = append(list, task)
+	}
+	return list
+}
+
+func (s *Server) nodeList() []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		list = append(list, map[string]interface{}{
+			"node":   n.Name,
+			"id":     "node/" + n.Name,
+			"type":   "node",
+			"status": n.Status,
+			"maxcpu": n.MaxCPU,
+			"maxmem": n.MaxMem,
+			"mem":    n.Mem,
+			"cpu":    n.CPU,
+		})
+	}
+	return list
+}
+
+func (s *Server) clusterStatus() []map[string]interface{} {
+	list := []map[string]interface{}{{"type": "cluster", "id": "cluster", "name": "fake", "version": 1, "quorate": 1}}
+	for i, n := range s.nodes {
+		online := 0
+		if n.Status == "online" {
+			online = 1
+		}
+		list = append(list, map[string]interface{}{"type": "node", "id": "node/" + n.Name, "name": n.Name, "nodeid": i + 1, "online": online})
+	}
+	return list
+}
+
+func (s *Server) resources(typeFilter string) []map[string]interface{} {
+	var list []map[string]interface{}
+	if typeFilter == "" || typeFilter == "node" {
+		for _, n := range s.nodes {
+			list = append(list, map[string]interface{}{"id": "node/" + n.Name, "type": "node", "node": n.Name, "status": n.Status})
+		}
+	}
+	if typeFilter == "" || typeFilter == "vm" {
+		for _, vm := range s.sortedVMs() {
+			template := 0
+			if vm.Template {
+				template = 1
+			}
+			guestType := "qemu"
+			if vm.LXC {
+				guestType = "lxc"
+			}
+			list = append(list, map[string]interface{}{
+				"id":       fmt.Sprintf("%s/%d", guestType, vm.VMID),
+				"type":     guestType,
+				"node":     vm.Node,
+				"vmid":     vm.VMID,
+				"name":     vm.Name,
+				"status":   vm.Status,
+				"tags":     vm.Tags,
+				"pool":     vm.Pool,
+				"template": template,
+				"maxmem":   vm.MaxMem,
+			})
+		}
+	}
+	if typeFilter == "" || typeFilter == "storage" {
+		for _, n := range s.nodes {
+			names := make([]string, 0, len(n.Storage))
+			for name := range n.Storage {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				list = append(list, map[string]interface{}{"id": fmt.Sprintf("storage/%s/%s", n.Name, name), "type": "storage", "node": n.Name, "storage": name, "status": "available", "plugintype": "dir"})
+			}
+		}
+	}
+	return list
+}
+
+func (s *Server) node(name string) *Node {
+	for _, n := range s.nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+func (s *Server) sortedVMs() []*VM {
+	vms := make([]*VM, 0, len(s.vms))
+	for _, vm := range s.vms {
+		vms = append(vms, vm)
+	}
+	sort.Slice(vms, func(i, j int) bool { return vms[i].VMID < vms[j].VMID })
+	return vms
+}
+
+func vmStatus(vm *VM) map[string]interface{} {
+	status := map[string]interface{}{
+		"vmid":      vm.VMID,
+		"name":      vm.Name,
+		"status":    vm.Status,
+		"qmpstatus": vm.Status,
+		"maxmem":    vm.MaxMem,
+		"cpus":      vm.CPUs,
+		"tags":      vm.Tags,
+	}
+	if vm.Lock != "" {
+		status["lock"] = vm.Lock
+	}
+	if vm.Template {
+		status["template"] = 1
+	}
+	return status
+}
+
+func match(p []string, want ...string) bool {
+	if len(p) != len(want) {
+		return false
+	}
+	for i := range p {
+		if p[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package proxmox
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// Defaults the dtt command line uses for the rate limit.
+const (
+	DefaultRateLimit = 10.0
+	DefaultRateBurst = 20
+)
+
+// RateLimitTransport spaces out Proxmox API requests so bulk operations stay
+// under pveproxy's connection limits. Requests wait for a token, or until
+// their context is done.
+type RateLimitTransport struct {
+	Base    http.RoundTripper
+	Limiter *rate.Limiter
+}
+
+// NewRateLimitTransport wraps base, or http.DefaultTransport if base is nil,
+// allowing perSecond requests per second with bursts of burst requests. A
+// perSecond of zero or less disables limiting and returns base as is.
+func NewRateLimitTransport(base http.RoundTripper, perSecond float64, burst int) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if perSecond <= 0 {
+		return base
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitTransport{Base: base, Limiter: rate.NewLimiter(rate.Limit(perSecond), burst)}
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,39 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	calls := 0
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return response("200 OK"), nil
+	})
+
+	if rt := NewRateLimitTransport(base, 0, 0); rt == nil {
+		t.Fatal("Expected the base transport when limiting is disabled")
+	} else if _, ok := rt.(*RateLimitTransport); ok {
+		t.Error("Expected no rate limiting with a limit of 0")
+	}
+
+	// One request per hour: the burst goes through, the next request waits
+	// until its context is canceled.
+	rt := NewRateLimitTransport(base, 1.0/3600, 1)
+
+	req, _ := http.NewRequest("GET", "https://pve:8006/api2/json/version", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("First request gave err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rt.RoundTrip(req.WithContext(ctx)); err == nil {
+		t.Error("Expected the rate limited request to fail once its context is canceled")
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 request to reach the server, got %d", calls)
+	}
+}
@@ -0,0 +1,124 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ImageCheck is how an image in the import content of a storage compares to
+// the one at its URL.
+type ImageCheck struct {
+	Image   Image
+	VolID   string
+	Present bool
+	// Stale is set when the image at the URL is newer or of another size,
+	// Reason tells which.
+	Stale  bool
+	Reason string
+}
+
+// upstreamClient asks the servers of the image URLs about their images.
+var upstreamClient = &http.Client{Timeout: 30 * time.Second}
+
+// upstreamStale reports whether the file at url differs from a copy of size
+// bytes downloaded at fetched: it is stale when the server says it was
+// modified after fetched, or has another size. A zero fetched or size is not
+// compared, nor is what the server doesn't say.
+func upstreamStale(ctx context.Context, url string, fetched time.Time, size uint64) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, "", err
+	}
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("HEAD %s gave %s", url, resp.Status)
+	}
+
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && !fetched.IsZero() && modified.After(fetched) {
+		return true, fmt.Sprintf("upstream modified %s, downloaded %s", modified.UTC().Format(time.RFC3339), fetched.UTC().Format(time.RFC3339)), nil
+	}
+	if length, err := strconv.ParseUint(resp.Header.Get("Content-Length"), 10, 64); err == nil && size != 0 && length != size {
+		return true, fmt.Sprintf("upstream has %d bytes, the storage %d", length, size), nil
+	}
+	return false, "", nil
+}
+
+// CheckImage compares image in the import content of storageID with the one
+// at its URL.
+func (c *Client) CheckImage(ctx context.Context, image Image, storageID string) (ImageCheck, error) {
+	check := ImageCheck{Image: image}
+	filename, err := imageFilename(image)
+	if err != nil {
+		return check, err
+	}
+	check.VolID = fmt.Sprintf("%s:import/%s", storageID, filename)
+
+	if err := c.Connect(ctx); err != nil {
+		return check, err
+	}
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return check, err
+	}
+	storage, err := node.Storage(ctx, storageID)
+	if err != nil {
+		return check, fmt.Errorf("failed to get storage %q: %w", storageID, WrapError(err))
+	}
+	content, err := storage.GetContent(ctx)
+	if err != nil {
+		return check, fmt.Errorf("failed to list storage %q content: %w", storageID, WrapError(err))
+	}
+	for _, item := range content {
+		if item.Volid != check.VolID {
+			continue
+		}
+		check.Present = true
+		var fetched time.Time
+		if item.Ctime != 0 {
+			fetched = time.Unix(int64(item.Ctime), 0)
+		}
+		check.Stale, check.Reason, err = upstreamStale(ctx, image.URL, fetched, item.Size)
+		if err != nil {
+			return check, fmt.Errorf("checking %s gave err: %w", image.URL, err)
+		}
+	}
+	return check, nil
+}
+
+// RefreshImage downloads image into the import content of storageID again
+// when the one at its URL is newer, see CheckImage, and downloads it when
+// missing. It returns the check it acted on.
+func (c *Client) RefreshImage(ctx context.Context, image Image, storageID string) (ImageCheck, error) {
+	check, err := c.CheckImage(ctx, image, storageID)
+	if err != nil || (check.Present && !check.Stale) {
+		return check, err
+	}
+
+	if check.Present {
+		c.config.Progress.report(Progress{Phase: "check", Message: fmt.Sprintf("Removing stale %s: %s", check.VolID, check.Reason), Percent: -1})
+		node, err := c.GetNode(ctx)
+		if err != nil {
+			return check, err
+		}
+		storage, err := node.Storage(ctx, storageID)
+		if err != nil {
+			return check, fmt.Errorf("failed to get storage %q: %w", storageID, WrapError(err))
+		}
+		task, err := storage.DeleteContent(ctx, check.VolID)
+		if err != nil {
+			return check, fmt.Errorf("failed to remove %s: %w", check.VolID, WrapError(err))
+		}
+		if err := c.waitTask(ctx, task, 2*time.Minute); err != nil {
+			return check, fmt.Errorf("failed waiting for the removal of %s: %w", check.VolID, err)
+		}
+	}
+	_, err = c.ensureImageOn(ctx, image, storageID)
+	return check, err
+}
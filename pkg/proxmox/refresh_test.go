@@ -0,0 +1,88 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// upstreamImage serves a HEAD of a cloud image modified at modified.
+func upstreamImage(t *testing.T, modified time.Time) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRefreshImage(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	upstream := upstreamImage(t, now.Add(-time.Hour))
+	image := Image{Name: "test", URL: upstream.URL + "/noble-server-cloudimg-amd64.img"}
+
+	client, server := newFakeClient(t)
+
+	check, err := client.RefreshImage(ctx, image, "local")
+	if err != nil {
+		t.Fatalf("RefreshImage() of a missing image gave err: %v", err)
+	}
+	if check.Present {
+		t.Error("Expected a missing image to not be present")
+	}
+	if check.VolID != "local:import/noble-server-cloudimg-amd64.qcow2" {
+		t.Errorf("Expected volume local:import/noble-server-cloudimg-amd64.qcow2, got %s", check.VolID)
+	}
+
+	check, err = client.RefreshImage(ctx, image, "local")
+	if err != nil {
+		t.Fatalf("RefreshImage() of a fresh image gave err: %v", err)
+	}
+	if !check.Present || check.Stale {
+		t.Errorf("Expected the image downloaded after upstream changed to be fresh, got %+v", check)
+	}
+
+	server.SetVolumeTime(check.VolID, now.Add(-24*time.Hour))
+	check, err = client.RefreshImage(ctx, image, "local")
+	if err != nil {
+		t.Fatalf("RefreshImage() of a stale image gave err: %v", err)
+	}
+	if !check.Stale || check.Reason == "" {
+		t.Errorf("Expected the image downloaded before upstream changed to be stale, got %+v", check)
+	}
+
+	var types []string
+	for _, task := range server.Tasks() {
+		types = append(types, task.Type)
+	}
+	want := []string{"download", "imgdel", "download"}
+	if len(types) != len(want) {
+		t.Fatalf("Expected tasks %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("Expected tasks %v, got %v", want, types)
+			break
+		}
+	}
+}
+
+func TestUpstreamStaleSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2048")
+	}))
+	defer server.Close()
+
+	stale, _, err := upstreamStale(context.Background(), server.URL, time.Time{}, 2048)
+	if err != nil || stale {
+		t.Errorf("upstreamStale() of the same size = %v, %v, want false", stale, err)
+	}
+	stale, _, err = upstreamStale(context.Background(), server.URL, time.Time{}, 1024)
+	if err != nil || !stale {
+		t.Errorf("upstreamStale() of another size = %v, %v, want true", stale, err)
+	}
+}
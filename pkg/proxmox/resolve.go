@@ -0,0 +1,163 @@
+package proxmox
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// VM queries select VMs, or containers, among the cluster resources:
+//
+//	123           the VM with VMID 123
+//	web           VMs named exactly web
+//	name:123      VMs named 123, for names that look like a VMID
+//	tag:prod      VMs tagged prod
+//	re:^web-\d+$  VMs whose name matches the regular expression
+//	glob:web-*    VMs whose name matches the shell pattern, see path.Match
+const (
+	queryName  = "name:"
+	queryTag   = "tag:"
+	queryRegex = "re:"
+	queryGlob  = "glob:"
+)
+
+// MatchVMs returns the qemu VMs among resources that match query, limited to
+// node unless node is empty. It returns ErrVMNotFound if none match.
+func MatchVMs(resources []*proxmox.ClusterResource, query, node string) ([]*proxmox.ClusterResource, error) {
+	return matchGuests(resources, "qemu", ErrVMNotFound, query, node)
+}
+
+// MatchVM is MatchVMs for commands acting on a single VM. It returns
+// ErrAmbiguousName, listing the candidates, if query matches more than one.
+func MatchVM(resources []*proxmox.ClusterResource, query, node string) (*proxmox.ClusterResource, error) {
+	return matchGuest(resources, "qemu", ErrVMNotFound, query, node)
+}
+
+// MatchContainers is MatchVMs for LXC containers. It returns
+// ErrContainerNotFound if none match.
+func MatchContainers(resources []*proxmox.ClusterResource, query, node string) ([]*proxmox.ClusterResource, error) {
+	return matchGuests(resources, "lxc", ErrContainerNotFound, query, node)
+}
+
+// MatchContainer is MatchVM for LXC containers.
+func MatchContainer(resources []*proxmox.ClusterResource, query, node string) (*proxmox.ClusterResource, error) {
+	return matchGuest(resources, "lxc", ErrContainerNotFound, query, node)
+}
+
+// matchGuests returns the resources of guestType, "qemu" or "lxc", that
+// match query, or notFound.
+func matchGuests(resources []*proxmox.ClusterResource, guestType string, notFound error, query, node string) ([]*proxmox.ClusterResource, error) {
+	match, err := vmMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+	node = strings.TrimSpace(node)
+
+	var matched []*proxmox.ClusterResource
+	for _, r := range resources {
+		if r.Type != guestType || (node != "" && r.Node != node) {
+			continue
+		}
+		if match(r) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		if node != "" {
+			return nil, fmt.Errorf("%w: %q on node %q", notFound, query, node)
+		}
+		return nil, fmt.Errorf("%w: %q", notFound, query)
+	}
+	return matched, nil
+}
+
+func matchGuest(resources []*proxmox.ClusterResource, guestType string, notFound error, query, node string) (*proxmox.ClusterResource, error) {
+	matched, err := matchGuests(resources, guestType, notFound, query, node)
+	if err != nil {
+		return nil, err
+	}
+	if len(matched) > 1 {
+		what := "VMs"
+		if guestType == "lxc" {
+			what = "containers"
+		}
+		conflicts := make([]string, 0, len(matched))
+		for _, r := range matched {
+			conflicts = append(conflicts, fmt.Sprintf("%s/%d(%s)", r.Node, r.VMID, r.Name))
+		}
+		return nil, fmt.Errorf("%w: multiple %s matched %q: %s; pass VMID or --node", ErrAmbiguousName, what, query, strings.Join(conflicts, ", "))
+	}
+	return matched[0], nil
+}
+
+// MatchVMsAll resolves several queries, returning every VM matched once, in
+// the order of its first match. Every query has to match at least one VM.
+func MatchVMsAll(resources []*proxmox.ClusterResource, queries []string, node string) ([]*proxmox.ClusterResource, error) {
+	return matchGuestsAll(resources, "qemu", ErrVMNotFound, queries, node)
+}
+
+// MatchContainersAll is MatchVMsAll for LXC containers.
+func MatchContainersAll(resources []*proxmox.ClusterResource, queries []string, node string) ([]*proxmox.ClusterResource, error) {
+	return matchGuestsAll(resources, "lxc", ErrContainerNotFound, queries, node)
+}
+
+func matchGuestsAll(resources []*proxmox.ClusterResource, guestType string, notFound error, queries []string, node string) ([]*proxmox.ClusterResource, error) {
+	var matched []*proxmox.ClusterResource
+	seen := map[uint64]bool{}
+	for _, query := range queries {
+		rs, err := matchGuests(resources, guestType, notFound, query, node)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			if !seen[r.VMID] {
+				seen[r.VMID] = true
+				matched = append(matched, r)
+			}
+		}
+	}
+	return matched, nil
+}
+
+func vmMatcher(query string) (func(*proxmox.ClusterResource) bool, error) {
+	switch {
+	case strings.HasPrefix(query, queryName):
+		name := strings.TrimPrefix(query, queryName)
+		return func(r *proxmox.ClusterResource) bool { return r.Name == name }, nil
+	case strings.HasPrefix(query, queryTag):
+		tag := strings.TrimPrefix(query, queryTag)
+		return func(r *proxmox.ClusterResource) bool { return slices.Contains(ResourceTags(r.Tags), tag) }, nil
+	case strings.HasPrefix(query, queryRegex):
+		re, err := regexp.Compile(strings.TrimPrefix(query, queryRegex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid VM name pattern %q: %w", query, err)
+		}
+		return func(r *proxmox.ClusterResource) bool { return re.MatchString(r.Name) }, nil
+	case strings.HasPrefix(query, queryGlob):
+		pattern := strings.TrimPrefix(query, queryGlob)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid VM name pattern %q: %w", query, err)
+		}
+		return func(r *proxmox.ClusterResource) bool {
+			ok, _ := path.Match(pattern, r.Name)
+			return ok
+		}, nil
+	}
+	if vmid, err := strconv.ParseUint(query, 10, 64); err == nil {
+		return func(r *proxmox.ClusterResource) bool { return r.VMID == vmid }, nil
+	}
+	return func(r *proxmox.ClusterResource) bool { return r.Name == query }, nil
+}
+
+// ResourceTags splits the tags of a cluster resource. Proxmox separates them
+// with semicolons, older versions also with commas or spaces.
+func ResourceTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
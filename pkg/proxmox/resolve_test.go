@@ -0,0 +1,104 @@
+package proxmox
+
+import (
+	"errors"
+	"testing"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+func TestMatchVMs(t *testing.T) {
+	resources := []*proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "web-1", Tags: "prod;web"},
+		{Type: "qemu", Node: "pve1", VMID: 101, Name: "db", Tags: "prod"},
+		{Type: "qemu", Node: "pve2", VMID: 102, Name: "db"},
+		{Type: "qemu", Node: "pve2", VMID: 103, Name: "100"},
+		{Type: "lxc", Node: "pve2", VMID: 104, Name: "web-2", Tags: "web"},
+		{Type: "node", Node: "pve1", Name: "pve1"},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		node    string
+		want    []uint64
+		wantErr error
+	}{
+		{name: "vmid", query: "102", want: []uint64{102}},
+		{name: "name", query: "web-1", want: []uint64{100}},
+		{name: "name matching several", query: "db", want: []uint64{101, 102}},
+		{name: "name on node", query: "db", node: "pve2", want: []uint64{102}},
+		{name: "numeric name", query: "name:100", want: []uint64{103}},
+		{name: "tag", query: "tag:prod", want: []uint64{100, 101}},
+		{name: "tag skips containers", query: "tag:web", want: []uint64{100}},
+		{name: "regex", query: "re:^(web|db)", want: []uint64{100, 101, 102}},
+		{name: "glob", query: "glob:web-*", want: []uint64{100}},
+		{name: "not found", query: "nope", wantErr: ErrVMNotFound},
+		{name: "not on node", query: "web-1", node: "pve2", wantErr: ErrVMNotFound},
+		{name: "container is no VM", query: "104", wantErr: ErrVMNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := MatchVMs(resources, tt.query, tt.node)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("MatchVMs(%q) = %v, want %v", tt.query, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MatchVMs(%q) gave err: %v", tt.query, err)
+			}
+			var got []uint64
+			for _, r := range matched {
+				got = append(got, r.VMID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("MatchVMs(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("MatchVMs(%q) = %v, want %v", tt.query, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := MatchVMs(resources, "re:(", ""); err == nil {
+		t.Error("Expected an error for an invalid regex")
+	}
+	if _, err := MatchVMs(resources, "glob:[", ""); err == nil {
+		t.Error("Expected an error for an invalid glob")
+	}
+	if _, err := MatchVM(resources, "db", ""); !errors.Is(err, ErrAmbiguousName) {
+		t.Errorf("MatchVM(db) = %v, want %v", err, ErrAmbiguousName)
+	}
+	if r, err := MatchVM(resources, "db", "pve1"); err != nil || r.VMID != 101 {
+		t.Errorf("MatchVM(db, pve1) = %v, %v, want VM 101", r, err)
+	}
+	all, err := MatchVMsAll(resources, []string{"tag:prod", "100", "db"}, "")
+	if err != nil || len(all) != 3 {
+		t.Errorf("MatchVMsAll() = %d VMs, %v, want 3", len(all), err)
+	}
+}
+
+func TestMatchContainers(t *testing.T) {
+	resources := []*proxmox.ClusterResource{
+		{Type: "qemu", Node: "pve1", VMID: 100, Name: "web"},
+		{Type: "lxc", Node: "pve1", VMID: 101, Name: "web", Tags: "dtt"},
+		{Type: "lxc", Node: "pve2", VMID: 102, Name: "cache", Tags: "dtt"},
+	}
+
+	if r, err := MatchContainer(resources, "web", ""); err != nil || r.VMID != 101 {
+		t.Errorf("MatchContainer(web) = %v, %v, want container 101", r, err)
+	}
+	if _, err := MatchContainer(resources, "100", ""); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("MatchContainer(100) = %v, want %v", err, ErrContainerNotFound)
+	}
+	if _, err := MatchContainer(resources, "tag:dtt", ""); !errors.Is(err, ErrAmbiguousName) {
+		t.Errorf("MatchContainer(tag:dtt) = %v, want %v", err, ErrAmbiguousName)
+	}
+	if matched, err := MatchContainers(resources, "tag:dtt", "pve2"); err != nil || len(matched) != 1 || matched[0].VMID != 102 {
+		t.Errorf("MatchContainers(tag:dtt, pve2) = %v, %v, want container 102", matched, err)
+	}
+}
@@ -0,0 +1,139 @@
+package proxmox
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Defaults for RetryTransport.
+const (
+	DefaultRetryAttempts = 3
+	DefaultRetryBackoff  = 500 * time.Millisecond
+	maxRetryBackoff      = 10 * time.Second
+)
+
+// RetryTransport retries Proxmox API requests that failed for reasons that
+// usually go away on their own: timeouts and dropped connections, 502, 503
+// and 504 responses, and 500 responses caused by a busy lock. Only requests
+// that read, such as GET, are retried for all of them. A POST, PUT or DELETE
+// may have had an effect before the failure, such as a clone, so those are
+// retried only when they never reached Proxmox, as when the connection was
+// refused, or when Proxmox refused them for a busy lock.
+//
+// Attempts is the total number of tries, the wait between them starts at
+// Backoff and doubles up to 10 seconds. Requests whose body can't be replayed
+// are sent once.
+type RetryTransport struct {
+	Base     http.RoundTripper
+	Attempts int
+	Backoff  time.Duration
+}
+
+// NewRetryTransport wraps base, or http.DefaultTransport if base is nil.
+func NewRetryTransport(base http.RoundTripper, attempts int, backoff time.Duration) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryTransport{Base: base, Attempts: attempts, Backoff: backoff}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.Attempts
+	if attempts < 1 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		attempts = 1
+	}
+	backoff := t.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.Base.RoundTrip(req)
+		if attempt >= attempts || !retryable(req, res, err) {
+			return res, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = res.Status
+			// Drain so the connection can be reused.
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+		}
+		slog.Debug("retrying Proxmox API request", "method", req.Method, "path", req.URL.Path, "attempt", attempt, "reason", reason, "backoff", backoff)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether a request that gave res or err is worth trying
+// again.
+func retryable(req *http.Request, res *http.Response, err error) bool {
+	reads := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+		if notSent(err) {
+			return true
+		}
+		if !reads {
+			return false
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true
+		}
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), "connection reset")
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return reads
+	case http.StatusInternalServerError:
+		// pveproxy puts the error message in the status line, e.g.
+		// "500 can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout".
+		// Without the lock nothing was done.
+		if isLockError(res.Status) {
+			return true
+		}
+		return reads
+	}
+	return false
+}
+
+// notSent reports whether err means the request never reached the server,
+// because connecting to it failed.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection refused")
+}
+
+func isLockError(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "got timeout") || strings.Contains(msg, "can't lock") || strings.Contains(msg, "got lock")
+}
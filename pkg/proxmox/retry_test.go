@@ -0,0 +1,117 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func response(status string) *http.Response {
+	var code int
+	for _, c := range status[:3] {
+		code = code*10 + int(c-'0')
+	}
+	return &http.Response{StatusCode: code, Status: status, Body: io.NopCloser(strings.NewReader("{}"))}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		responses []string
+		wantCalls int
+		wantCode  int
+	}{
+		{name: "success", method: "GET", responses: []string{"200 OK"}, wantCalls: 1, wantCode: 200},
+		{name: "unavailable then ok", method: "GET", responses: []string{"503 Service Unavailable", "502 Bad Gateway", "200 OK"}, wantCalls: 3, wantCode: 200},
+		{name: "budget exhausted", method: "GET", responses: []string{"503 Service Unavailable", "503 Service Unavailable", "503 Service Unavailable", "200 OK"}, wantCalls: 3, wantCode: 503},
+		{name: "get error retried", method: "GET", responses: []string{"500 Internal Server Error", "200 OK"}, wantCalls: 2, wantCode: 200},
+		{name: "post error not retried", method: "POST", responses: []string{"500 storage full", "200 OK"}, wantCalls: 1, wantCode: 500},
+		{name: "post bad gateway not retried", method: "POST", responses: []string{"502 Bad Gateway", "200 OK"}, wantCalls: 1, wantCode: 502},
+		{name: "delete unavailable not retried", method: "DELETE", responses: []string{"503 Service Unavailable", "200 OK"}, wantCalls: 1, wantCode: 503},
+		{name: "post lock retried", method: "POST", responses: []string{"500 can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout", "200 OK"}, wantCalls: 2, wantCode: 200},
+		{name: "not authorized", method: "GET", responses: []string{"401 Unauthorized", "200 OK"}, wantCalls: 1, wantCode: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var bodies []string
+			base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					bodies = append(bodies, string(b))
+				}
+				res := response(tt.responses[calls])
+				calls++
+				return res, nil
+			})
+
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://pve:8006/api2/json/nodes", strings.NewReader("vmid=100"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := NewRetryTransport(base, 3, time.Millisecond).RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() gave err: %v", err)
+			}
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, res.StatusCode)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			for _, b := range bodies {
+				if b != "vmid=100" {
+					t.Errorf("Expected every attempt to send the body, got %q", b)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		err       error
+		wantCalls int
+	}{
+		{name: "get reset retried", method: "GET", err: errors.New("read tcp: connection reset by peer"), wantCalls: 2},
+		{name: "get eof retried", method: "GET", err: io.EOF, wantCalls: 2},
+		{name: "post reset not retried", method: "POST", err: errors.New("read tcp: connection reset by peer"), wantCalls: 1},
+		{name: "post eof not retried", method: "POST", err: io.ErrUnexpectedEOF, wantCalls: 1},
+		{name: "post refused retried", method: "POST", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				if calls == 1 {
+					return nil, tt.err
+				}
+				return response("200 OK"), nil
+			})
+
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://pve:8006/api2/json/nodes/pve/qemu/100/clone", strings.NewReader("newid=101"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			NewRetryTransport(base, 3, time.Millisecond).RoundTrip(req)
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
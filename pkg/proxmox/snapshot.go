@@ -0,0 +1,154 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// currentSnapshot is the entry Proxmox lists for the running state of a VM,
+// below its newest snapshot.
+const currentSnapshot = "current"
+
+var snapshotName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// Snapshot is a snapshot of a VM.
+type Snapshot struct {
+	Name        string    `json:"name" yaml:"name"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	Parent      string    `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Time        time.Time `json:"time" yaml:"time"`
+	// VMState is set when the snapshot includes the memory of the VM, rolling
+	// back to it resumes the VM where it was.
+	VMState bool `json:"vmstate" yaml:"vmstate"`
+	// Current is set on the snapshot the VM runs on top of, the one a
+	// rollback without a name returns to.
+	Current bool `json:"current" yaml:"current"`
+}
+
+// SnapshotOptions describe a snapshot to take with CreateSnapshot.
+type SnapshotOptions struct {
+	Name        string
+	Description string
+	// VMState includes the memory of a running VM, so that rolling back
+	// resumes it instead of booting it.
+	VMState bool
+}
+
+// ValidateSnapshotName checks name against what Proxmox accepts: a letter
+// followed by letters, digits, underscores and hyphens, 2 to 40 in all.
+func ValidateSnapshotName(name string) error {
+	if name == currentSnapshot || !snapshotName.MatchString(name) {
+		return fmt.Errorf("%w: snapshot name %q must start with a letter and have 2 to 40 letters, digits, underscores and hyphens", ErrInvalidSpec, name)
+	}
+	return nil
+}
+
+// CreateSnapshot takes a snapshot of VM vmID and waits for it.
+func (c *Client) CreateSnapshot(ctx context.Context, vmID int, opts SnapshotOptions) error {
+	if err := ValidateSnapshotName(opts.Name); err != nil {
+		return err
+	}
+	body := map[string]interface{}{"snapname": opts.Name}
+	if opts.Description != "" {
+		body["description"] = opts.Description
+	}
+	if opts.VMState {
+		body["vmstate"] = 1
+	}
+	// Saving the memory of a large VM takes a while.
+	return c.snapshotTask(ctx, vmID, 30*time.Minute, func(upid *string) error {
+		return c.apiClient.Post(ctx, c.vmPath(vmID, "snapshot"), body, upid)
+	})
+}
+
+// ListSnapshots returns the snapshots of VM vmID, oldest first.
+func (c *Client) ListSnapshots(ctx context.Context, vmID int) ([]Snapshot, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Parent      string `json:"parent"`
+		SnapTime    int64  `json:"snaptime"`
+		VMState     int    `json:"vmstate"`
+	}
+	if err := c.apiClient.Get(ctx, c.vmPath(vmID, "snapshot"), &entries); err != nil {
+		return nil, vmLookupError(vmID, err)
+	}
+
+	current := ""
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		if e.Name == currentSnapshot {
+			current = e.Parent
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:        e.Name,
+			Description: e.Description,
+			Parent:      e.Parent,
+			Time:        time.Unix(e.SnapTime, 0),
+			VMState:     e.VMState == 1,
+		})
+	}
+	for i := range snapshots {
+		snapshots[i].Current = snapshots[i].Name == current
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// RollbackSnapshot rolls VM vmID back to the snapshot name and waits for it.
+// A VM rolled back to a snapshot without VMState is stopped afterwards,
+// unless start is set.
+func (c *Client) RollbackSnapshot(ctx context.Context, vmID int, name string, start bool) error {
+	body := map[string]interface{}{}
+	if start {
+		body["start"] = 1
+	}
+	return c.snapshotTask(ctx, vmID, 30*time.Minute, func(upid *string) error {
+		return c.apiClient.Post(ctx, c.vmPath(vmID, "snapshot/"+url.PathEscape(name)+"/rollback"), body, upid)
+	})
+}
+
+// DeleteSnapshot deletes the snapshot name of VM vmID and waits for it. force
+// removes it from the config even when removing its disk state fails.
+func (c *Client) DeleteSnapshot(ctx context.Context, vmID int, name string, force bool) error {
+	p := c.vmPath(vmID, "snapshot/"+url.PathEscape(name))
+	if force {
+		p += "?force=1"
+	}
+	return c.snapshotTask(ctx, vmID, 10*time.Minute, func(upid *string) error {
+		return c.apiClient.Delete(ctx, p, upid)
+	})
+}
+
+// snapshotTask starts a snapshot task of VM vmID with start, which stores the
+// UPID of the task, and waits for the task.
+func (c *Client) snapshotTask(ctx context.Context, vmID int, timeout time.Duration, start func(upid *string) error) error {
+	if vmID <= 0 {
+		return fmt.Errorf("invalid VM ID: must be greater than 0")
+	}
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	var upid string
+	if err := start(&upid); err != nil {
+		return fmt.Errorf("vm %d: %w", vmID, WrapError(err))
+	}
+	task, err := NewTask(c.apiClient, upid)
+	if err != nil {
+		return err
+	}
+	return c.waitTask(ctx, task, timeout)
+}
+
+func (c *Client) vmPath(vmID int, p string) string {
+	return fmt.Sprintf("/nodes/%s/qemu/%d/%s", c.config.Node, vmID, p)
+}
@@ -0,0 +1,56 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestSnapshots(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web", Status: "running"})
+
+	client := NewClientWithAPI(ClientConfig{Node: "pve"}, server.Client())
+	ctx := context.Background()
+
+	if err := client.CreateSnapshot(ctx, 100, SnapshotOptions{Name: "clean", Description: "before the test"}); err != nil {
+		t.Fatalf("CreateSnapshot(clean) gave err: %v", err)
+	}
+	if err := client.CreateSnapshot(ctx, 100, SnapshotOptions{Name: "warm", VMState: true}); err != nil {
+		t.Fatalf("CreateSnapshot(warm) gave err: %v", err)
+	}
+	if err := client.CreateSnapshot(ctx, 100, SnapshotOptions{Name: "1st"}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Expected an invalid snapshot name to be rejected, got %v", err)
+	}
+
+	snapshots, err := client.ListSnapshots(ctx, 100)
+	if err != nil {
+		t.Fatalf("ListSnapshots() gave err: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "clean" || snapshots[0].Description != "before the test" || !snapshots[1].VMState || !snapshots[1].Current || snapshots[1].Parent != "clean" {
+		t.Fatalf("Expected snapshots clean and warm, warm current, got %+v", snapshots)
+	}
+
+	if err := client.RollbackSnapshot(ctx, 100, "clean", false); err != nil {
+		t.Fatalf("RollbackSnapshot(clean) gave err: %v", err)
+	}
+	if vm := server.VM(100); vm.Status != "stopped" || vm.CurrentSnapshot != "clean" {
+		t.Errorf("Expected the VM stopped on top of clean, got %s on %q", vm.Status, vm.CurrentSnapshot)
+	}
+	if err := client.RollbackSnapshot(ctx, 100, "clean", true); err != nil || server.VM(100).Status != "running" {
+		t.Errorf("Expected the VM running after a rollback with start, got %v", err)
+	}
+
+	if err := client.DeleteSnapshot(ctx, 100, "warm", false); err != nil {
+		t.Fatalf("DeleteSnapshot(warm) gave err: %v", err)
+	}
+	if err := client.DeleteSnapshot(ctx, 100, "warm", false); err == nil {
+		t.Error("Expected deleting a missing snapshot to fail")
+	}
+	if snapshots, err := client.ListSnapshots(ctx, 100); err != nil || len(snapshots) != 1 || !snapshots[0].Current {
+		t.Errorf("Expected only clean to be left, got %+v, %v", snapshots, err)
+	}
+}
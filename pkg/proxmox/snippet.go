@@ -0,0 +1,63 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// UploadSnippet uploads the local file path as a snippet to storage on node,
+// for cicustom, and returns its volume ID. The snippet is named after the
+// checksum of the file too, so a file already uploaded is reused and
+// different files with the same name don't replace each other under VMs
+// still using them.
+func UploadSnippet(ctx context.Context, api ProxmoxAPI, node, storage, path string, opts UploadOptions) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := fmt.Sprintf("dtt-%s-%s", hex.EncodeToString(sum[:6]), filepath.Base(path))
+	volID := fmt.Sprintf("%s:snippets/%s", storage, name)
+
+	var status struct {
+		Content string `json:"content"`
+	}
+	if err := api.Get(ctx, fmt.Sprintf("/nodes/%s/storage/%s/status", node, storage), &status); err != nil {
+		return "", fmt.Errorf("getting storage %s on node %s gave err: %w", storage, node, WrapError(err))
+	}
+	if !slices.Contains(strings.Split(status.Content, ","), "snippets") {
+		return "", fmt.Errorf("storage %s on node %s has no snippets content, add it with pvesm set %s --content %s,snippets", storage, node, storage, status.Content)
+	}
+
+	var content []struct {
+		Volid string `json:"volid"`
+	}
+	if err := api.Get(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage), &content); err != nil {
+		return "", fmt.Errorf("listing storage %s content gave err: %w", storage, WrapError(err))
+	}
+	for _, c := range content {
+		if c.Volid == volID {
+			opts.Progress.report(Progress{Phase: "done", Message: fmt.Sprintf("reusing %s", volID), Percent: 100})
+			return volID, nil
+		}
+	}
+
+	// Proxmox names the volume after the uploaded file.
+	dir, err := os.MkdirTemp("", "dtt-snippet")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	named := filepath.Join(dir, name)
+	if err := os.WriteFile(named, data, 0o600); err != nil {
+		return "", err
+	}
+	opts.Content = "snippets"
+	return UploadImage(ctx, api, node, storage, named, opts)
+}
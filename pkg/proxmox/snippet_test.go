@@ -0,0 +1,55 @@
+package proxmox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestUploadSnippet(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	pve := server.AddNode("pve")
+	path := filepath.Join(t.TempDir(), "user-data.yaml")
+	if err := os.WriteFile(path, []byte("#cloud-config\npackages: [make]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := UploadSnippet(ctx, server.Client(), "pve", "local", path, UploadOptions{}); err == nil || !strings.Contains(err.Error(), "no snippets content") {
+		t.Fatalf("Expected a storage without snippets content to be refused, got %v", err)
+	}
+
+	pve.StorageContent["local"] = "iso,snippets"
+	volID, err := UploadSnippet(ctx, server.Client(), "pve", "local", path, UploadOptions{})
+	if err != nil {
+		t.Fatalf("UploadSnippet() gave err: %v", err)
+	}
+	if !strings.HasPrefix(volID, "local:snippets/dtt-") || !strings.HasSuffix(volID, "-user-data.yaml") {
+		t.Errorf("Expected a snippet named after the file and its checksum, got %q", volID)
+	}
+
+	again, err := UploadSnippet(ctx, server.Client(), "pve", "local", path, UploadOptions{})
+	if err != nil || again != volID {
+		t.Fatalf("Expected the same file to reuse %s, got %q, %v", volID, again, err)
+	}
+	uploads := 0
+	for _, r := range server.Requests() {
+		if r.Path == "/nodes/pve/storage/local/upload" {
+			uploads++
+		}
+	}
+	if uploads != 1 {
+		t.Errorf("Expected 1 upload, got %d", uploads)
+	}
+
+	if err := os.WriteFile(path, []byte("#cloud-config\npackages: [git]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := UploadSnippet(ctx, server.Client(), "pve", "local", path, UploadOptions{}); err != nil || changed == volID {
+		t.Errorf("Expected a changed file to get a new snippet, got %q, %v", changed, err)
+	}
+}
@@ -0,0 +1,154 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// TaskLogFunc receives the log lines of a task as they appear while the task
+// is waited on.
+type TaskLogFunc func(task *proxmox.Task, line string)
+
+// MultiTaskLog returns a TaskLogFunc calling every non-nil fn, or nil if
+// there are none.
+func MultiTaskLog(fns ...TaskLogFunc) TaskLogFunc {
+	var set []TaskLogFunc
+	for _, fn := range fns {
+		if fn != nil {
+			set = append(set, fn)
+		}
+	}
+	switch len(set) {
+	case 0:
+		return nil
+	case 1:
+		return set[0]
+	}
+	return func(task *proxmox.Task, line string) {
+		for _, fn := range set {
+			fn(task, line)
+		}
+	}
+}
+
+// PrintTaskLog returns a TaskLogFunc writing each line to w, prefixed with
+// the task type and ID so the logs of concurrent tasks stay apart. It is
+// safe for concurrent use.
+func PrintTaskLog(w io.Writer) TaskLogFunc {
+	var mu sync.Mutex
+	return func(task *proxmox.Task, line string) {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return
+		}
+		label := task.Type
+		if task.ID != "" {
+			label += " " + task.ID
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "  [%s] %s\n", label, line)
+	}
+}
+
+// taskLogPage is how many lines of a task log are fetched at once.
+const taskLogPage = 500
+
+// readTaskLog passes the lines of the task log from line start on, numbered
+// from 0, to logFn and returns the number of the line to read next.
+func readTaskLog(ctx context.Context, task *proxmox.Task, start int, logFn TaskLogFunc) (int, error) {
+	for {
+		lines, err := task.Log(ctx, start, taskLogPage)
+		if err != nil {
+			return start, WrapError(err)
+		}
+		if len(lines) == 0 {
+			return start, nil
+		}
+		numbers := make([]int, 0, len(lines))
+		for n := range lines {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		for _, n := range numbers {
+			logFn(task, lines[n])
+		}
+		start = numbers[len(numbers)-1] + 1
+		if len(lines) < taskLogPage {
+			return start, nil
+		}
+	}
+}
+
+// ReadTaskLog passes the log of task so far to logFn.
+func ReadTaskLog(ctx context.Context, task *proxmox.Task, logFn TaskLogFunc) error {
+	_, err := readTaskLog(ctx, task, 0, logFn)
+	return err
+}
+
+// WaitTaskLog waits like WaitTask, passing new lines of the task log to
+// logFn while it does. A nil logFn doesn't fetch the log at all.
+func WaitTaskLog(ctx context.Context, task *proxmox.Task, interval, timeout time.Duration, logFn TaskLogFunc) error {
+	if logFn == nil {
+		return WaitTask(ctx, task, interval, timeout)
+	}
+	if task == nil {
+		return nil
+	}
+	err := followTaskLog(ctx, task, interval, time.Now().Add(timeout), logFn)
+	if errors.Is(err, errTaskDeadline) {
+		return fmt.Errorf("%w: %s after %s", ErrTaskTimeout, task.UPID, timeout)
+	}
+	return err
+}
+
+// FollowTaskLog passes the log of task to logFn, from the start and then as
+// it grows, polling every interval until the task is done or ctx is. Like
+// WaitTask it reports a task that finished unsuccessfully as ErrTaskFailed.
+func FollowTaskLog(ctx context.Context, task *proxmox.Task, interval time.Duration, logFn TaskLogFunc) error {
+	return followTaskLog(ctx, task, interval, time.Time{}, logFn)
+}
+
+// errTaskDeadline ends followTaskLog at its deadline.
+var errTaskDeadline = errors.New("task deadline passed")
+
+// followTaskLog implements FollowTaskLog and WaitTaskLog, giving up with
+// errTaskDeadline after deadline unless it is zero.
+func followTaskLog(ctx context.Context, task *proxmox.Task, interval time.Duration, deadline time.Time, logFn TaskLogFunc) error {
+	start := 0
+	for {
+		if err := task.Ping(ctx); err != nil {
+			return WrapError(err)
+		}
+
+		// The log is read after the status, so the last poll of a finished
+		// task sees all of it. A failed read is tried again next poll.
+		start, _ = readTaskLog(ctx, task, start, logFn)
+
+		if task.IsCompleted || (task.Status != "" && task.Status != proxmox.TaskRunning) {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return errTaskDeadline
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	if task.IsFailed {
+		return fmt.Errorf("%w: %s: %s", ErrTaskFailed, task.UPID, task.ExitStatus)
+	}
+	return nil
+}
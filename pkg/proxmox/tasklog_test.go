@@ -0,0 +1,83 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestTaskLogWhileWaiting(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.TaskLog("download",
+		"downloading https://cloud-images.ubuntu.com/noble.img",
+		"100.00% (20.00 MiB of 20.00 MiB) in 2s, speed 10.00 MiB/s",
+		"TASK OK")
+
+	var lines []string
+	client := NewClientWithAPI(ClientConfig{
+		Node:    "pve",
+		TaskLog: func(task *proxmox.Task, line string) { lines = append(lines, line) },
+	}, server.Client())
+
+	if _, err := client.EnsureImage(context.Background(), DefaultImages()[2]); err != nil {
+		t.Fatalf("EnsureImage() gave err: %v", err)
+	}
+	if len(lines) != 3 || lines[0] != "downloading https://cloud-images.ubuntu.com/noble.img" || lines[2] != "TASK OK" {
+		t.Errorf("Expected the download task log, got %q", lines)
+	}
+}
+
+func TestPrintTaskLog(t *testing.T) {
+	var buf bytes.Buffer
+	fn := MultiTaskLog(nil, PrintTaskLog(&buf))
+	fn(&proxmox.Task{Type: "qmcreate", ID: "100"}, "TASK OK")
+	if got := buf.String(); !strings.Contains(got, "qmcreate 100") || !strings.Contains(got, "TASK OK") {
+		t.Errorf("PrintTaskLog wrote %q", got)
+	}
+	if MultiTaskLog(nil, nil) != nil {
+		t.Errorf("Expected MultiTaskLog of only nils to be nil")
+	}
+}
+
+func TestFollowTaskLog(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.AddVM(proxmoxtest.VM{Node: "pve", VMID: 100, Name: "web"})
+	server.TaskLog("qmstart", "starting VM 100", "TASK ERROR: start failed")
+	server.FailTasks("qmstart", "start failed")
+
+	ctx := context.Background()
+	var upid string
+	if err := server.Client().Post(ctx, "/nodes/pve/qemu/100/status/start", nil, &upid); err != nil {
+		t.Fatalf("starting VM 100 gave err: %v", err)
+	}
+	task, err := NewTask(server.Client(), upid)
+	if err != nil {
+		t.Fatalf("NewTask() gave err: %v", err)
+	}
+
+	var lines []string
+	logFn := func(task *proxmox.Task, line string) { lines = append(lines, line) }
+	if err := ReadTaskLog(ctx, task, logFn); err != nil {
+		t.Fatalf("ReadTaskLog() gave err: %v", err)
+	}
+	if len(lines) != 2 || lines[1] != "TASK ERROR: start failed" {
+		t.Errorf("Expected the 2 lines of the log, got %q", lines)
+	}
+
+	lines = nil
+	if err := FollowTaskLog(ctx, task, time.Millisecond, logFn); !errors.Is(err, ErrTaskFailed) {
+		t.Errorf("FollowTaskLog() gave err %v, want ErrTaskFailed", err)
+	}
+	if len(lines) != 2 {
+		t.Errorf("Expected every line once, got %q", lines)
+	}
+}
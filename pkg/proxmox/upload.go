@@ -0,0 +1,294 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// UploadOptions tune UploadImage. The zero value uploads to import content
+// with three attempts and checksum verification.
+type UploadOptions struct {
+	Content  string        // storage content type, "import" unless set
+	Attempts int           // tries before giving up (default 3)
+	Backoff  time.Duration // wait before the first retry, doubled for every next one (default 5s)
+	NoVerify bool          // skip the checksum and size checks
+	Progress ProgressFunc
+	TaskLog  TaskLogFunc // receives the log of the task moving the upload into place
+}
+
+// UploadImage uploads the local file path to storage on node and waits for
+// Proxmox to move it into place.
+//
+// The Proxmox upload endpoint takes the whole file in one request and drops
+// partial uploads, so there are no chunks to resume: a failed upload is
+// retried from the start. Unless disabled, Proxmox verifies the SHA-256
+// checksum of the received file, and the size of the stored volume is
+// compared with the local file afterwards.
+func UploadImage(ctx context.Context, api ProxmoxAPI, node, storage, path string, opts UploadOptions) (string, error) {
+	if opts.Content == "" {
+		opts.Content = "import"
+	}
+	if opts.Attempts < 1 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 5 * time.Second
+	}
+	progress := opts.Progress
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	// Proxmox names the volume after the uploaded file.
+	fields := map[string]string{
+		"content": opts.Content,
+	}
+	if !opts.NoVerify {
+		sum, err := fileSHA256(ctx, path, info.Size(), progress)
+		if err != nil {
+			return "", fmt.Errorf("computing checksum of %s: %w", path, err)
+		}
+		fields["checksum"] = sum
+		fields["checksum-algorithm"] = "sha256"
+	}
+
+	uploadPath := fmt.Sprintf("/nodes/%s/storage/%s/upload", node, storage)
+	backoff := opts.Backoff
+	var upid proxmox.UPID
+	for attempt := 1; ; attempt++ {
+		upid, err = uploadOnce(ctx, api, uploadPath, fields, path, info.Size(), progress)
+		if err == nil {
+			break
+		}
+		err = WrapError(err)
+		if attempt >= opts.Attempts || ctx.Err() != nil || errors.Is(err, ErrAuth) {
+			return "", fmt.Errorf("uploading %s gave err: %w", path, err)
+		}
+		progress.report(Progress{Phase: "upload", Message: fmt.Sprintf("upload attempt %d failed: %v, retrying in %s", attempt, err, backoff), Percent: -1})
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	client, ok := api.(*proxmox.Client)
+	if ok && upid != "" {
+		task := proxmox.NewTask(upid, client)
+		if err := WaitTaskLog(ctx, task, time.Second, 30*time.Minute, opts.TaskLog); err != nil {
+			return "", fmt.Errorf("waiting for upload task gave err: %w", err)
+		}
+	}
+
+	volID := fmt.Sprintf("%s:%s/%s", storage, opts.Content, filepath.Base(path))
+	if !opts.NoVerify {
+		progress.report(Progress{Phase: "verify", Message: fmt.Sprintf("verifying %s", volID), Percent: -1})
+		if err := verifyUploadedVolume(ctx, api, node, storage, volID, info.Size()); err != nil {
+			return "", err
+		}
+	}
+	progress.report(Progress{Phase: "done", Message: fmt.Sprintf("uploaded %s", volID), Bytes: info.Size(), Total: info.Size(), Percent: 100})
+	return volID, nil
+}
+
+// uploadOnce sends the file in one request, reporting progress by watching
+// the read offset of the file while the client streams it. The API client
+// takes no context, closing the file under it when ctx is done ends the
+// request.
+func uploadOnce(ctx context.Context, api ProxmoxAPI, uploadPath string, fields map[string]string, path string, size int64, progress ProgressFunc) (proxmox.UPID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var tick <-chan time.Time
+		if progress != nil {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				f.Close()
+				return
+			case <-tick:
+				if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+					progress(Progress{Phase: "upload", Bytes: pos, Total: size, Percent: percentOf(pos, size)})
+				}
+			}
+		}
+	}()
+
+	var upid proxmox.UPID
+	err = api.Upload(uploadPath, fields, f, &upid)
+	close(done)
+	<-stopped
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return "", ctxErr
+	}
+	if err == nil {
+		progress.report(Progress{Phase: "upload", Bytes: size, Total: size, Percent: 100})
+	}
+	return upid, err
+}
+
+func verifyUploadedVolume(ctx context.Context, api ProxmoxAPI, node, storage, volID string, size int64) error {
+	var content []struct {
+		Volid string `json:"volid"`
+		Size  int64  `json:"size"`
+	}
+	if err := api.Get(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content", node, storage), &content); err != nil {
+		return fmt.Errorf("listing storage %s content gave err: %w", storage, WrapError(err))
+	}
+	for _, c := range content {
+		if c.Volid != volID {
+			continue
+		}
+		if c.Size != 0 && c.Size != size {
+			return fmt.Errorf("uploaded %s has %d bytes, the local file %d", volID, c.Size, size)
+		}
+		return nil
+	}
+	return fmt.Errorf("uploaded volume %s not found on storage %s", volID, storage)
+}
+
+func fileSHA256(ctx context.Context, path string, size int64, progress ProgressFunc) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	var done int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			done += int64(n)
+			progress.report(Progress{Phase: "checksum", Bytes: done, Total: size, Percent: percentOf(done, size)})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func percentOf(done, total int64) float64 {
+	if total <= 0 {
+		return -1
+	}
+	return float64(done) * 100 / float64(total)
+}
+
+// ImportOptions tune ImportImage.
+type ImportOptions struct {
+	UploadOptions
+	// Format is the format to store the image in, one of ImportFormats,
+	// FormatQcow2 unless set.
+	Format string
+	// Name is the file name of the volume, the name of the local file with
+	// the extension of Format unless set.
+	Name string
+	// SHA256, when set, is the checksum the local file must have.
+	SHA256 string
+	// ConvertOutput receives the output of qemu-img.
+	ConvertOutput io.Writer
+}
+
+// ImportImage uploads the local disk image path to the import content of
+// storage on node, converting it to opts.Format with ConvertImage first when
+// it is in another format, and returns its volume ID.
+func ImportImage(ctx context.Context, api ProxmoxAPI, node, storage, path string, opts ImportOptions) (string, error) {
+	if opts.Format == "" {
+		opts.Format = FormatQcow2
+	}
+	if !slices.Contains(ImportFormats, opts.Format) {
+		return "", fmt.Errorf("unknown import format %q, use one of %s", opts.Format, strings.Join(ImportFormats, ", "))
+	}
+	from, err := DetectImageFormat(path)
+	if err != nil {
+		return "", err
+	}
+	if opts.SHA256 != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		sum, err := fileSHA256(ctx, path, info.Size(), opts.Progress)
+		if err != nil {
+			return "", fmt.Errorf("computing checksum of %s: %w", path, err)
+		}
+		if !strings.EqualFold(sum, opts.SHA256) {
+			return "", fmt.Errorf("%s has SHA-256 %s, want %s", path, sum, opts.SHA256)
+		}
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	name = strings.TrimSuffix(name, "."+opts.Format) + "." + opts.Format
+
+	// UploadImage names the volume after the file, so upload a link to it or
+	// the converted image under name.
+	dir, err := os.MkdirTemp("", "dtt-import")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	upload := filepath.Join(dir, name)
+	if from == opts.Format {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Symlink(abs, upload); err != nil {
+			return "", err
+		}
+	} else {
+		opts.Progress.report(Progress{Phase: "convert", Message: fmt.Sprintf("converting %s from %s to %s", path, from, opts.Format), Percent: -1})
+		output := opts.ConvertOutput
+		if output == nil {
+			output = io.Discard
+		}
+		if err := ConvertImage(ctx, path, from, upload, opts.Format, output); err != nil {
+			return "", err
+		}
+	}
+	opts.Content = "import"
+	return UploadImage(ctx, api, node, storage, upload, opts.UploadOptions)
+}
@@ -0,0 +1,137 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func writeTestImage(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.qcow2")
+	if err := os.WriteFile(path, make([]byte, 3<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadImage(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	path := writeTestImage(t)
+
+	var last Progress
+	volID, err := UploadImage(context.Background(), server.Client(), "pve", "local", path, UploadOptions{
+		Progress: func(p Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatalf("UploadImage() gave err: %v", err)
+	}
+	if volID != "local:import/test.qcow2" {
+		t.Errorf("Expected volume local:import/test.qcow2, got %q", volID)
+	}
+	if last.Phase != "done" || last.Bytes != 3<<20 {
+		t.Errorf("Expected a final done update for 3 MiB, got %+v", last)
+	}
+}
+
+func TestUploadImageRetries(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	server.Fail("POST", "/nodes/pve/storage/local/upload", 500, "connection reset")
+	path := writeTestImage(t)
+
+	_, err := UploadImage(context.Background(), server.Client(), "pve", "local", path, UploadOptions{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+
+	uploads := 0
+	for _, r := range server.Requests() {
+		if r.Path == "/nodes/pve/storage/local/upload" {
+			uploads++
+		}
+	}
+	if uploads != 3 {
+		t.Errorf("Expected 3 upload attempts, got %d", uploads)
+	}
+}
+
+// slowUploadAPI reads the uploaded file a little at a time until reading
+// fails, as an upload over a slow link would.
+type slowUploadAPI struct {
+	ProxmoxAPI
+	started chan struct{}
+}
+
+func (a slowUploadAPI) Upload(path string, fields map[string]string, file *os.File, v interface{}) error {
+	close(a.started)
+	buf := make([]byte, 1024)
+	for {
+		if _, err := file.Read(buf); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUploadImageCancel(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	path := writeTestImage(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	api := slowUploadAPI{ProxmoxAPI: server.Client(), started: make(chan struct{})}
+	go func() {
+		<-api.started
+		cancel()
+	}()
+	_, err := UploadImage(ctx, api, "pve", "local", path, UploadOptions{NoVerify: true})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the upload to stop on cancel, got %v", err)
+	}
+}
+
+func TestImportImage(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "appliance.img")
+	if err := os.WriteFile(raw, make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	volID, err := ImportImage(context.Background(), server.Client(), "pve", "local", raw, ImportOptions{Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("ImportImage() gave err: %v", err)
+	}
+	if volID != "local:import/appliance.raw" {
+		t.Errorf("Expected the raw image to be uploaded as appliance.raw, got %q", volID)
+	}
+
+	fakeQemuImg(t)
+	volID, err = ImportImage(context.Background(), server.Client(), "pve", "local", raw, ImportOptions{Name: "web.qcow2"})
+	if err != nil {
+		t.Fatalf("ImportImage() gave err: %v", err)
+	}
+	if volID != "local:import/web.qcow2" {
+		t.Errorf("Expected the converted image to be uploaded as web.qcow2, got %q", volID)
+	}
+
+	_, err = ImportImage(context.Background(), server.Client(), "pve", "local", raw, ImportOptions{Format: FormatRaw, Name: "other", SHA256: strings.Repeat("0", 64)})
+	if err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	if _, err := ImportImage(context.Background(), server.Client(), "pve", "local", raw, ImportOptions{Format: FormatVDI}); err == nil {
+		t.Error("Expected an error for a format import doesn't take")
+	}
+}
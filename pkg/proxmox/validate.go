@@ -0,0 +1,264 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	proxmox "github.com/luthermonson/go-proxmox"
+)
+
+// Bounds Proxmox accepts for VM parameters.
+const (
+	MinMemoryMB = 16
+	MaxMemoryMB = 4 << 20 // 4 TiB
+	MaxCores    = 512
+	MaxSockets  = 16
+)
+
+var (
+	hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	diskSize      = regexp.MustCompile(`^\+?\d+(\.\d+)?[KMGT]?$`)
+)
+
+// ValidationError lists every problem found with a set of VM parameters, so
+// they can all be fixed in one go. It matches ErrInvalidSpec.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("%v: %s", ErrInvalidSpec, e.Problems[0])
+	}
+	return fmt.Sprintf("%v:\n  - %s", ErrInvalidSpec, strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidSpec
+}
+
+// Validation collects problems with VM parameters. The zero value is ready to
+// use. Checks that need the API only query it, so all of them can run before
+// anything is created.
+type Validation struct {
+	problems []string
+}
+
+// Addf records a problem.
+func (v *Validation) Addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Err returns a *ValidationError with the problems found, or nil.
+func (v *Validation) Err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: slices.Clone(v.problems)}
+}
+
+// Name checks that name can be used as the hostname of the VM, which is what
+// Proxmox requires. An empty name is left to Proxmox to fill in.
+func (v *Validation) Name(name string) {
+	if name == "" {
+		return
+	}
+	if len(name) > 253 {
+		v.Addf("name %q is longer than 253 characters", name)
+		return
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			v.Addf("name %q is not a valid hostname: use letters, digits and hyphens, at most 63 per dot separated part, not starting or ending with a hyphen", name)
+			return
+		}
+	}
+}
+
+// Memory checks the memory size in MB.
+func (v *Validation) Memory(mb int) {
+	if mb < MinMemoryMB || mb > MaxMemoryMB {
+		v.Addf("memory %d MB is out of range, use between %d and %d MB", mb, MinMemoryMB, MaxMemoryMB)
+	}
+}
+
+// Cores checks the number of cores per socket.
+func (v *Validation) Cores(n int) {
+	if n < 1 || n > MaxCores {
+		v.Addf("%d cores is out of range, use between 1 and %d", n, MaxCores)
+	}
+}
+
+// Sockets checks the number of CPU sockets, 0 means the default of 1.
+func (v *Validation) Sockets(n int) {
+	if n < 0 || n > MaxSockets {
+		v.Addf("%d sockets is out of range, use between 1 and %d", n, MaxSockets)
+	}
+}
+
+// DiskSize checks a disk size as the resize API takes it: a number with an
+// optional K, M, G or T suffix, prefixed with + to grow by rather than to.
+func (v *Validation) DiskSize(size string) {
+	if !diskSize.MatchString(size) {
+		v.Addf("disk size %q is not valid, use a size like 32G, or +10G to grow the disk by 10 GiB", size)
+	}
+}
+
+// DiskSizeBytes returns the bytes of a disk size DiskSize accepts, with or
+// without the + for growing the disk. K, M, G and T are powers of 1024.
+func DiskSizeBytes(size string) (uint64, error) {
+	if !diskSize.MatchString(size) {
+		return 0, fmt.Errorf("disk size %q is not valid, use a size like 32G or +10G", size)
+	}
+	number := strings.TrimPrefix(size, "+")
+	unit := 1.0
+	if i := strings.IndexAny(number, "KMGT"); i >= 0 {
+		unit = float64(uint64(1) << (10 * (strings.IndexByte("KMGT", number[i]) + 1)))
+		number = number[:i]
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("disk size %q is not valid: %w", size, err)
+	}
+	return uint64(n * unit), nil
+}
+
+// Network checks a network device such as "virtio,bridge=vmbr0,tag=10".
+func (v *Validation) Network(netdev string) {
+	if NetworkBridge(netdev) == "" {
+		v.Addf("network device %q has no bridge, add one like bridge=vmbr0", netdev)
+	}
+}
+
+// Storage checks that storage exists on node and allows every content type
+// in content, e.g. "images" for disks or "import" for cloud images.
+func (v *Validation) Storage(ctx context.Context, node *proxmox.Node, storage string, content ...string) {
+	s, err := node.Storage(ctx, storage)
+	if err != nil {
+		v.Addf("storage %q is not available on node %s: %v", storage, node.Name, WrapError(err))
+		return
+	}
+	allowed := strings.Split(s.Content, ",")
+	for _, c := range content {
+		if !slices.Contains(allowed, c) {
+			v.Addf("storage %q does not allow %s content (it allows %s), enable it in the storage configuration or use another storage", storage, c, s.Content)
+		}
+	}
+}
+
+// FreeSpace checks that storage on node has at least need bytes free. A
+// storage that is not available is left to Storage to report.
+func (v *Validation) FreeSpace(ctx context.Context, node *proxmox.Node, storage string, need uint64) {
+	s, err := node.Storage(ctx, storage)
+	if err != nil {
+		return
+	}
+	if s.Avail < need {
+		v.Addf("storage %q on node %s has %s free, the disks need at least %s, free up space or use another storage", storage, node.Name, formatBytes(int64(s.Avail)), formatBytes(int64(need)))
+	}
+}
+
+// NextVMID checks that the cluster has a free VM ID and returns it, or 0
+// when it has none.
+func (v *Validation) NextVMID(ctx context.Context, api ProxmoxAPI) int {
+	cluster, err := api.Cluster(ctx)
+	if err != nil {
+		v.Addf("getting the cluster gave err: %v", WrapError(err))
+		return 0
+	}
+	id, err := cluster.NextID(ctx)
+	if err != nil {
+		v.Addf("no free VM ID in the cluster: %v", WrapError(err))
+		return 0
+	}
+	return id
+}
+
+// Bridges checks that the bridges the network devices connect to exist on
+// node.
+func (v *Validation) Bridges(ctx context.Context, node *proxmox.Node, netdevs ...string) {
+	networks, err := node.Networks(ctx, "any_bridge")
+	if err != nil {
+		v.Addf("listing bridges on node %s gave err: %v", node.Name, WrapError(err))
+		return
+	}
+	var bridges []string
+	for _, n := range networks {
+		bridges = append(bridges, n.Iface)
+	}
+	for _, netdev := range netdevs {
+		bridge := NetworkBridge(netdev)
+		if bridge != "" && !slices.Contains(bridges, bridge) {
+			v.Addf("bridge %q does not exist on node %s, it has %s", bridge, node.Name, strings.Join(bridges, ", "))
+		}
+	}
+}
+
+// NetworkBridge returns the bridge of a network device such as
+// "virtio,bridge=vmbr0", or "" if it has none.
+func NetworkBridge(netdev string) string {
+	for _, opt := range strings.Split(netdev, ",") {
+		if bridge, ok := strings.CutPrefix(strings.TrimSpace(opt), "bridge="); ok {
+			return bridge
+		}
+	}
+	return ""
+}
+
+// Validate checks the parameters of vmSpec that need no API access.
+func (vmSpec VMSpec) Validate() error {
+	var v Validation
+	vmSpec.validate(&v)
+	return v.Err()
+}
+
+func (vmSpec VMSpec) validate(v *Validation) {
+	if vmSpec.VMID <= 0 {
+		v.Addf("VM ID %d is not valid, it must be greater than 0", vmSpec.VMID)
+	}
+	v.Name(vmSpec.Name)
+	v.Memory(vmSpec.Memory)
+	v.Cores(vmSpec.Cores)
+	v.Sockets(vmSpec.CPU)
+	if vmSpec.DiskSize < 0 {
+		v.Addf("disk size %d GB is negative", vmSpec.DiskSize)
+	}
+	if vmSpec.Network != "" {
+		v.Network(vmSpec.Network)
+	}
+	for _, netdev := range vmSpec.ExtraNetworks {
+		v.Network(netdev)
+	}
+}
+
+// ValidateVMSpec checks vmSpec, and that the storages and bridge it needs
+// exist on the node of the client. It reports every problem at once and
+// creates nothing.
+func (c *Client) ValidateVMSpec(ctx context.Context, vmSpec VMSpec) error {
+	var v Validation
+	vmSpec.validate(&v)
+
+	if err := c.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to Proxmox: %w", WrapError(err))
+	}
+	node, err := c.GetNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	if vmSpec.Image.URL != "" {
+		v.Storage(ctx, node, c.imageStorage(), "import")
+		v.Storage(ctx, node, c.diskStorage(), "images")
+		v.FreeSpace(ctx, node, c.diskStorage(), uint64(vmSpec.DiskSize)<<30)
+	}
+	network := vmSpec.Network
+	if network == "" {
+		network = defaultNetwork
+	}
+	v.Bridges(ctx, node, append([]string{network}, vmSpec.ExtraNetworks...)...)
+	return v.Err()
+}
@@ -0,0 +1,126 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cdevr/dtt/pkg/proxmox/proxmoxtest"
+)
+
+func TestValidateVMSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec VMSpec
+		want []string // substrings of the expected problems, in order
+	}{
+		{"valid", VMSpec{Name: "web-1.lab", VMID: 100, Memory: 1024, Cores: 2}, nil},
+		{"unnamed", VMSpec{VMID: 100, Memory: 1024, Cores: 2, CPU: 1}, nil},
+		{"underscore", VMSpec{Name: "web_1", VMID: 100, Memory: 1024, Cores: 2}, []string{"not a valid hostname"}},
+		{"leading hyphen", VMSpec{Name: "-web", VMID: 100, Memory: 1024, Cores: 2}, []string{"not a valid hostname"}},
+		{"everything wrong", VMSpec{Name: "a b", VMID: 0, Memory: 8, Cores: 0, CPU: 99, DiskSize: -1, Network: "virtio"}, []string{
+			"VM ID 0", "not a valid hostname", "memory 8 MB", "0 cores", "99 sockets", "disk size -1", "has no bridge",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() gave err: %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || !errors.Is(err, ErrInvalidSpec) {
+				t.Fatalf("Validate() = %v, want a ValidationError", err)
+			}
+			if len(verr.Problems) != len(tt.want) {
+				t.Fatalf("Validate() found %q, want %d problems", verr.Problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(verr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want it to mention %q", i, verr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDiskSize(t *testing.T) {
+	for size, valid := range map[string]bool{
+		"+10G": true, "32G": true, "1.5T": true, "2048": true, "+512M": true,
+		"": false, "10GB": false, "-5G": false, "+G": false, "ten": false,
+	} {
+		var v Validation
+		v.DiskSize(size)
+		if got := v.Err() == nil; got != valid {
+			t.Errorf("DiskSize(%q) valid = %v, want %v", size, got, valid)
+		}
+	}
+}
+
+func TestDiskSizeBytes(t *testing.T) {
+	for size, want := range map[string]uint64{"+10G": 10 << 30, "32G": 32 << 30, "1.5T": 3 << 39, "2048": 2048, "+512M": 512 << 20} {
+		if got, err := DiskSizeBytes(size); err != nil || got != want {
+			t.Errorf("DiskSizeBytes(%q) = %d, %v, want %d", size, got, err, want)
+		}
+	}
+	if _, err := DiskSizeBytes("10GB"); err == nil {
+		t.Error("Expected an error for 10GB")
+	}
+}
+
+func TestValidateFreeSpaceAndVMID(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	server.AddNode("pve")
+	ctx := context.Background()
+	node, err := server.Client().Node(ctx, "pve")
+	if err != nil {
+		t.Fatalf("Node() gave err: %v", err)
+	}
+
+	var v Validation
+	v.FreeSpace(ctx, node, "local", 10<<30)
+	v.FreeSpace(ctx, node, "missing", 10<<30)
+	if id := v.NextVMID(ctx, server.Client()); id != 100 {
+		t.Errorf("NextVMID() = %d, want 100", id)
+	}
+	if err := v.Err(); err != nil {
+		t.Fatalf("Expected room for 10 GiB on local, and the missing storage left to Storage, got %v", err)
+	}
+
+	v.FreeSpace(ctx, node, "local", 200<<30)
+	var verr *ValidationError
+	if !errors.As(v.Err(), &verr) || len(verr.Problems) != 1 || !strings.Contains(verr.Problems[0], "need at least 200.0 GiB") {
+		t.Errorf("Expected the 100 GiB storage to be too small for 200 GiB, got %v", v.Err())
+	}
+}
+
+func TestValidateVMSpecAgainstNode(t *testing.T) {
+	server := proxmoxtest.NewServer(t)
+	node := server.AddNode("pve")
+	node.StorageContent["local"] = "iso,vztmpl"
+
+	client := NewClientWithAPI(ClientConfig{Node: "pve", ImageStorage: "local", DiskStorage: "missing"}, server.Client())
+	spec := VMSpec{Name: "dtt-test", VMID: 120, Image: DefaultImages()[2], Memory: 1024, Cores: 2, Network: "virtio,bridge=vmbr9"}
+
+	_, err := client.CreateVM(context.Background(), spec)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("CreateVM() = %v, want a ValidationError", err)
+	}
+	want := []string{`storage "local" does not allow import`, `storage "missing" is not available`, `bridge "vmbr9" does not exist`}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("CreateVM() found %q, want %d problems", verr.Problems, len(want))
+	}
+	for i, w := range want {
+		if !strings.Contains(verr.Problems[i], w) {
+			t.Errorf("problem %d = %q, want it to mention %q", i, verr.Problems[i], w)
+		}
+	}
+	if server.VM(120) != nil {
+		t.Error("Expected no VM to be created")
+	}
+}
@@ -0,0 +1,252 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHostsLines returns a known_hosts line for each of keys, in the
+// authorized_keys format cloud-init prints host keys in, valid for all of
+// hosts.
+func KnownHostsLines(hosts, keys []string) ([]string, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts to trust the keys for")
+	}
+	var lines []string
+	for _, k := range keys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("parsing host key %q gave err: %w", k, err)
+		}
+		lines = append(lines, knownhosts.Line(hosts, key))
+	}
+	return lines, nil
+}
+
+// AppendKnownHosts appends lines to the known_hosts file at path, skipping
+// those it already has. The file and its directory are created when missing.
+func AppendKnownHosts(path string, lines []string) error {
+	existing := map[string]bool{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			existing[strings.TrimSpace(scanner.Text())] = true
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading %s gave err: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if existing[line] {
+			continue
+		}
+		existing[line] = true
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// KnownHosts returns a host key callback accepting only the host keys in the
+// known_hosts files at paths.
+func KnownHosts(paths ...string) (ssh.HostKeyCallback, error) {
+	callback, err := knownhosts.New(paths...)
+	if err != nil {
+		return nil, fmt.Errorf("reading known_hosts gave err: %w", err)
+	}
+	return callback, nil
+}
+
+// tofuMu keeps TrustOnFirstUse callbacks from appending to a file at once.
+var tofuMu sync.Mutex
+
+// TrustOnFirstUse returns a host key callback checking host keys against the
+// known_hosts file at path, which is created when missing, and adding the key
+// of a host it has none for. A host answering with another key than the one
+// in the file is refused.
+func TrustOnFirstUse(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		tofuMu.Lock()
+		defer tofuMu.Unlock()
+
+		if err := AppendKnownHosts(path, nil); err != nil {
+			return fmt.Errorf("creating %s gave err: %w", path, err)
+		}
+		callback, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("reading %s gave err: %w", path, err)
+		}
+		err = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key of %s is %s, not the one %s has for it, it may have been reinstalled or someone is in the middle: %w", hostname, ssh.FingerprintSHA256(key), path, err)
+		}
+		return AppendKnownHosts(path, []string{knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)})
+	}
+}
+
+// TrustFirstKey returns a host key callback remembering the first host key
+// every host answers with, in memory, and refusing any other key from that
+// host afterwards. It is for new VMs, whose keys can't be known beforehand:
+// it keeps the VM from being swapped between the connections of an upload
+// and a run.
+func TrustFirstKey() ssh.HostKeyCallback {
+	var mu sync.Mutex
+	seen := map[string]string{}
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fp := ssh.FingerprintSHA256(key)
+		want, ok := seen[hostname]
+		if !ok {
+			seen[hostname] = fp
+			return nil
+		}
+		if fp != want {
+			return fmt.Errorf("%s answered with host key %s, not %s as before, someone may be in the middle", hostname, fp, want)
+		}
+		return nil
+	}
+}
+
+// PinnedHostKeys returns a host key callback accepting only the host keys
+// pins name, in authorized_keys format or as SHA256 fingerprints such as
+// "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU".
+func PinnedHostKeys(pins []string) (ssh.HostKeyCallback, error) {
+	if len(pins) == 0 {
+		return nil, errors.New("no host keys to pin")
+	}
+	want := map[string]bool{}
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if strings.HasPrefix(pin, "SHA256:") {
+			want[pin] = true
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pin))
+		if err != nil {
+			return nil, fmt.Errorf("parsing host key %q gave err: %w", pin, err)
+		}
+		want[ssh.FingerprintSHA256(key)] = true
+	}
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		if fp := ssh.FingerprintSHA256(key); !want[fp] {
+			return fmt.Errorf("%s answered with host key %s, not a pinned one", hostname, fp)
+		}
+		return nil
+	}, nil
+}
+
+// WaitForHostKey dials the SSH server at addr until it answers with one of
+// keys, in authorized_keys format, or ctx is done. With no keys any host key
+// will do. A server answering with another key is an error straight away,
+// retrying won't change it. Logging in is not needed, the host key is checked
+// before authentication.
+func WaitForHostKey(ctx context.Context, addr string, keys []string, retryDelay time.Duration) error {
+	want := map[string]bool{}
+	for _, k := range keys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			return fmt.Errorf("parsing host key %q gave err: %w", k, err)
+		}
+		want[string(key.Marshal())] = true
+	}
+	_, err := waitForHostKey(ctx, addr, func(key ssh.PublicKey) bool {
+		return len(want) == 0 || want[string(key.Marshal())]
+	}, retryDelay)
+	return err
+}
+
+// WaitForFingerprint is WaitForHostKey for when only the SHA256 fingerprints
+// of the host keys are known, as cloud-init prints them, such as
+// "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU". It returns the host key
+// the server answered with, in authorized_keys format.
+func WaitForFingerprint(ctx context.Context, addr string, fingerprints []string, retryDelay time.Duration) (string, error) {
+	if len(fingerprints) == 0 {
+		return "", errors.New("no fingerprints to check the host key against")
+	}
+	want := map[string]bool{}
+	for _, f := range fingerprints {
+		want[f] = true
+	}
+	key, err := waitForHostKey(ctx, addr, func(key ssh.PublicKey) bool {
+		return want[ssh.FingerprintSHA256(key)]
+	}, retryDelay)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), nil
+}
+
+// waitForHostKey dials the SSH server at addr until it answers with a host
+// key, returning it when match accepts it and an error when not.
+func waitForHostKey(ctx context.Context, addr string, match func(ssh.PublicKey) bool, retryDelay time.Duration) (ssh.PublicKey, error) {
+	var dialer net.Dialer
+	for {
+		var matched, wrong ssh.PublicKey
+		config := &ssh.ClientConfig{
+			User: "dtt",
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				if !match(key) {
+					wrong = key
+					return errors.New("unexpected host key")
+				}
+				matched = key
+				return nil
+			},
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			var c ssh.Conn
+			c, _, _, err = ssh.NewClientConn(conn, addr, config)
+			if err == nil {
+				c.Close()
+			} else {
+				conn.Close()
+			}
+		}
+		if matched != nil {
+			return matched, nil
+		}
+		if wrong != nil {
+			return nil, fmt.Errorf("%s answered with host key %s, not one the VM printed", addr, ssh.FingerprintSHA256(wrong))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for SSH on %s gave err: %w (last attempt: %v)", addr, ctx.Err(), err)
+		case <-time.After(retryDelay):
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKhhEBYpS0z2sShZ3jpE7SRmaDoKUyrpUXDmv51vteYF root@dtt-debian-11-104"
+
+func TestKnownHosts(t *testing.T) {
+	lines, err := KnownHostsLines([]string{"vm", "192.168.1.191", "2a02:aa14::1"}, []string{testHostKey})
+	if err != nil {
+		t.Fatalf("KnownHostsLines gave err: %v", err)
+	}
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "vm,192.168.1.191,2a02:aa14::1 ssh-ed25519 ") {
+		t.Fatalf("Unexpected known_hosts lines %q", lines)
+	}
+	if _, err := KnownHostsLines(nil, []string{testHostKey}); err == nil {
+		t.Error("Expected an error without hosts")
+	}
+	if _, err := KnownHostsLines([]string{"vm"}, []string{"ssh-ed25519 garbage"}); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	for i := 0; i < 2; i++ {
+		if err := AppendKnownHosts(path, lines); err != nil {
+			t.Fatalf("AppendKnownHosts gave err: %v", err)
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(content), "\n") != 1 {
+		t.Errorf("Expected the line to be written once, got %q", content)
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatalf("knownhosts.New gave err: %v", err)
+	}
+	key, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(testHostKey))
+	if err := callback("192.168.1.191:22", &net.TCPAddr{IP: net.ParseIP("192.168.1.191"), Port: 22}, key); err != nil {
+		t.Errorf("Expected the written file to verify the key, got %v", err)
+	}
+}
+
+// serveSSH accepts SSH connections on a local port with a new host key,
+// rejecting every login. It returns the address and the host key in
+// authorized_keys format.
+func serveSSH(t *testing.T) (string, string) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func TestWaitForHostKey(t *testing.T) {
+	addr, hostKey := serveSSH(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := WaitForHostKey(ctx, addr, []string{hostKey}, 10*time.Millisecond); err != nil {
+		t.Errorf("Expected the host key to be seen, got %v", err)
+	}
+	if err := WaitForHostKey(ctx, addr, nil, 10*time.Millisecond); err != nil {
+		t.Errorf("Expected any host key to do without keys, got %v", err)
+	}
+	if err := WaitForHostKey(ctx, addr, []string{testHostKey}, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "not one the VM printed") {
+		t.Errorf("Expected a host key mismatch, got %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := WaitForHostKey(short, closed, []string{hostKey}, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected to time out without a server, got %v", err)
+	}
+}
+
+func TestWaitForFingerprint(t *testing.T) {
+	addr, hostKey := serveSSH(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := WaitForFingerprint(ctx, addr, []string{"SHA256:other", ssh.FingerprintSHA256(key)}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected the fingerprint to match, got %v", err)
+	}
+	if got != strings.TrimSpace(hostKey) {
+		t.Errorf("Expected host key %q, got %q", strings.TrimSpace(hostKey), got)
+	}
+	if _, err := WaitForFingerprint(ctx, addr, []string{"SHA256:other"}, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "not one the VM printed") {
+		t.Errorf("Expected a fingerprint mismatch, got %v", err)
+	}
+}
+
+func TestHostKeyCallbacks(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testHostKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(otherPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := otherSigner.PublicKey()
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.191"), Port: 22}
+
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	tofu := TrustOnFirstUse(path)
+	if err := tofu("192.168.1.191:22", remote, key); err != nil {
+		t.Fatalf("Expected the first key to be trusted, got %v", err)
+	}
+	if err := tofu("192.168.1.191:22", remote, key); err != nil {
+		t.Errorf("Expected the same key to be trusted again, got %v", err)
+	}
+	if err := tofu("192.168.1.191:22", remote, other); err == nil || !strings.Contains(err.Error(), "in the middle") {
+		t.Errorf("Expected a changed key to be refused, got %v", err)
+	}
+
+	known, err := KnownHosts(path)
+	if err != nil {
+		t.Fatalf("KnownHosts gave err: %v", err)
+	}
+	if err := known("192.168.1.191:22", remote, key); err != nil {
+		t.Errorf("Expected the key trusted on first use to be known, got %v", err)
+	}
+	if err := known("192.168.1.192:22", &net.TCPAddr{IP: net.ParseIP("192.168.1.192"), Port: 22}, key); err == nil {
+		t.Error("Expected an unknown host to be refused")
+	}
+	if _, err := KnownHosts(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing known_hosts file")
+	}
+
+	for _, pin := range []string{testHostKey, ssh.FingerprintSHA256(key)} {
+		pinned, err := PinnedHostKeys([]string{pin})
+		if err != nil {
+			t.Fatalf("PinnedHostKeys(%q) gave err: %v", pin, err)
+		}
+		if err := pinned("vm:22", remote, key); err != nil {
+			t.Errorf("Expected the pinned key to be accepted with %q, got %v", pin, err)
+		}
+		if err := pinned("vm:22", remote, other); err == nil {
+			t.Errorf("Expected another key to be refused with %q", pin)
+		}
+	}
+	if _, err := PinnedHostKeys(nil); err == nil {
+		t.Error("Expected an error without pins")
+	}
+
+	first := TrustFirstKey()
+	if err := first("vm:22", remote, key); err != nil {
+		t.Fatalf("Expected the first key to be trusted, got %v", err)
+	}
+	if err := first("vm:22", remote, key); err != nil {
+		t.Errorf("Expected the same key to be trusted again, got %v", err)
+	}
+	if err := first("vm:22", remote, other); err == nil {
+		t.Error("Expected another key from the same host to be refused")
+	}
+	if err := first("vm2:22", remote, other); err != nil {
+		t.Errorf("Expected the first key of another host to be trusted, got %v", err)
+	}
+}
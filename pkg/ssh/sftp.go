@@ -0,0 +1,288 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// partSuffix is appended to the name a file is transferred to, it is
+	// renamed to the real one once complete.
+	partSuffix = ".dtt-part"
+	// posixRename is the OpenSSH extension renaming over an existing file.
+	posixRename = "posix-rename@openssh.com"
+)
+
+// TransferFunc receives the progress of a transfer: done of total bytes of
+// the file at path. It is called with done 0 when a transfer starts and
+// with done equal to total once it is complete.
+type TransferFunc func(path string, done, total int64)
+
+// TransferOptions change how Upload, Download and UploadDir transfer files.
+type TransferOptions struct {
+	// Progress receives the progress of every file, nil discards it.
+	Progress TransferFunc
+	// Resume continues a transfer that was interrupted before, from the
+	// partial file it left next to the target. The partial file is trusted
+	// to hold the start of the source, it isn't compared.
+	Resume bool
+	// Mode replaces the permissions of the transferred files, 0 keeps those
+	// of the source.
+	Mode os.FileMode
+}
+
+func (o TransferOptions) report(path string, done, total int64) {
+	if o.Progress != nil {
+		o.Progress(path, done, total)
+	}
+}
+
+// progress counts the bytes read or written through it for a TransferFunc.
+type progress struct {
+	r      io.Reader
+	w      io.Writer
+	done   int64
+	report func(int64)
+}
+
+func (p *progress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	p.report(p.done)
+	return n, err
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.report(p.done)
+	return n, err
+}
+
+// sftp starts an SFTP session on the connection of c.
+func (c *Client) sftp() (*sftp.Client, error) {
+	if !c.connected {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+	}
+	s, err := sftp.NewClient(c.sshClient, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		return nil, fmt.Errorf("starting sftp on the server gave err: %w", err)
+	}
+	return s, nil
+}
+
+// Upload copies the local file at localPath to remotePath over SFTP, with
+// the permissions of the local file unless opts.Mode is set. The file is
+// written next to remotePath first and renamed once complete, so remotePath
+// never holds half a file.
+func (c *Client) Upload(localPath, remotePath string, opts TransferOptions) error {
+	s, err := c.sftp()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return upload(s, localPath, remotePath, opts)
+}
+
+func upload(s *sftp.Client, localPath, remotePath string, opts TransferOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = info.Mode()
+	}
+
+	part := remotePath + partSuffix
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.Resume {
+		if attrs, err := s.Stat(part); err == nil && attrs.Size() <= info.Size() {
+			offset = attrs.Size()
+			flags = os.O_WRONLY | os.O_CREATE
+		}
+	}
+	remote, err := s.OpenFile(part, flags)
+	if err != nil {
+		return fmt.Errorf("opening %s gave err: %w", part, err)
+	}
+	// Until it is complete only the user may read the file.
+	err = remote.Chmod(0o600)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = remote.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		opts.report(remotePath, offset, info.Size())
+		report := func(done int64) { opts.report(remotePath, done, info.Size()) }
+		_, err = remote.ReadFromWithConcurrency(&progress{r: f, done: offset, report: report}, 0)
+	}
+	if err == nil {
+		err = remote.Chmod(mode.Perm())
+	}
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("uploading %s to %s gave err: %w", localPath, remotePath, err)
+	}
+	if err := rename(s, part, remotePath); err != nil {
+		return fmt.Errorf("renaming %s to %s gave err: %w", part, remotePath, err)
+	}
+	return nil
+}
+
+// rename renames from to to, replacing to when it exists.
+func rename(s *sftp.Client, from, to string) error {
+	if _, ok := s.HasExtension(posixRename); ok {
+		return s.PosixRename(from, to)
+	}
+	// Plain renames don't replace files.
+	if err := s.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.Rename(from, to)
+}
+
+// Download copies the remote file at remotePath to localPath over SFTP,
+// with the permissions of the remote file unless opts.Mode is set. Like
+// Upload it writes next to localPath first.
+func (c *Client) Download(remotePath, localPath string, opts TransferOptions) error {
+	s, err := c.sftp()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	remote, err := s.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	attrs, err := remote.Stat()
+	if err != nil {
+		return fmt.Errorf("stat of %s gave err: %w", remotePath, err)
+	}
+	if attrs.IsDir() {
+		return fmt.Errorf("%s is a directory", remotePath)
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = attrs.Mode()
+	}
+
+	part := localPath + partSuffix
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.Resume {
+		if info, err := os.Stat(part); err == nil && info.Size() <= attrs.Size() {
+			offset = info.Size()
+			flags = os.O_WRONLY | os.O_CREATE
+		}
+	}
+	f, err := os.OpenFile(part, flags, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err == nil {
+		_, err = remote.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		opts.report(remotePath, offset, attrs.Size())
+		report := func(done int64) { opts.report(remotePath, done, attrs.Size()) }
+		_, err = remote.WriteTo(&progress{w: f, done: offset, report: report})
+	}
+	// Servers not reporting permissions leave the 0600 of the part file.
+	if err == nil && mode.Perm() != 0 {
+		err = f.Chmod(mode.Perm())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s to %s gave err: %w", remotePath, localPath, err)
+	}
+	return os.Rename(part, localPath)
+}
+
+// UploadDir copies the local directory localDir to remoteDir over SFTP,
+// creating remoteDir and the directories below it as needed. Files keep
+// their permissions unless opts.Mode is set, which doesn't apply to
+// directories. Symlinks and other special files are skipped.
+func (c *Client) UploadDir(localDir, remoteDir string, opts TransferOptions) error {
+	s, err := c.sftp()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		target := remoteDir
+		if rel != "." {
+			target = path.Join(remoteDir, filepath.ToSlash(rel))
+		}
+
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return ensureDir(s, target, info.Mode())
+		case d.Type().IsRegular():
+			if strings.HasSuffix(p, partSuffix) {
+				return nil
+			}
+			return upload(s, p, target, opts)
+		}
+		return nil
+	})
+}
+
+// ensureDir creates the directory name unless it exists.
+func ensureDir(s *sftp.Client, name string, mode os.FileMode) error {
+	attrs, err := s.Stat(name)
+	if err == nil {
+		if !attrs.IsDir() {
+			return fmt.Errorf("%s exists and is no directory", name)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := s.Mkdir(name); err != nil {
+		return fmt.Errorf("creating %s gave err: %w", name, err)
+	}
+	if err := s.Chmod(name, mode.Perm()); err != nil {
+		return fmt.Errorf("creating %s gave err: %w", name, err)
+	}
+	return nil
+}
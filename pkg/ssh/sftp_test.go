@@ -0,0 +1,237 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveSFTP serves the local file system over SFTP on a local port,
+// accepting any password, and returns a client for it.
+func serveSFTP(t *testing.T) *Client {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newCh := range chans {
+					ch, reqs, err := newCh.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range reqs {
+							ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+							req.Reply(ok, nil)
+							if ok {
+								if server, err := sftp.NewServer(ch); err == nil {
+									server.Serve()
+								}
+								ch.Close()
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client := NewClient(Config{Host: host, Port: p, Username: "dtt", Password: "dtt", InsecureIgnoreHostKey: true})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func randomFile(t *testing.T, path string, size int, mode os.FileMode) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	if err := os.WriteFile(path, data, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkFile(t *testing.T, path string, want []byte, mode os.FileMode) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s has %d bytes that differ from the %d expected", path, len(got), len(want))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode().Perm(), mode)
+	}
+	if _, err := os.Stat(path + partSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the partial file of %s to be gone, got %v", path, err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	remote, local := t.TempDir(), t.TempDir()
+	client := serveSFTP(t)
+
+	// Big enough for many concurrent writes.
+	src := filepath.Join(local, "app")
+	dst := filepath.Join(remote, "app")
+	data := randomFile(t, src, 5<<20+123, 0o750)
+	var last, calls int64
+	progress := func(path string, done, total int64) {
+		if path != dst || total != int64(len(data)) || done < last {
+			t.Errorf("Unexpected progress %s %d/%d after %d", path, done, total, last)
+		}
+		last = done
+		calls++
+	}
+	if err := client.Upload(src, dst, TransferOptions{Progress: progress}); err != nil {
+		t.Fatalf("Upload gave err: %v", err)
+	}
+	checkFile(t, filepath.Join(remote, "app"), data, 0o750)
+	if last != int64(len(data)) || calls < 3 {
+		t.Errorf("Expected progress up to %d in several calls, got %d in %d", len(data), last, calls)
+	}
+
+	// The existing file is replaced, and UploadFile keeps the mode as well.
+	data = randomFile(t, src, 1000, 0o600)
+	if err := client.UploadFile(src, dst); err != nil {
+		t.Fatalf("UploadFile gave err: %v", err)
+	}
+	checkFile(t, filepath.Join(remote, "app"), data, 0o600)
+
+	if err := client.Upload(src, dst, TransferOptions{Mode: 0o755}); err != nil {
+		t.Fatalf("Upload gave err: %v", err)
+	}
+	checkFile(t, filepath.Join(remote, "app"), data, 0o755)
+}
+
+func TestUploadResume(t *testing.T) {
+	remote, local := t.TempDir(), t.TempDir()
+	client := serveSFTP(t)
+
+	src := filepath.Join(local, "big")
+	data := randomFile(t, src, 320<<10+7, 0o644)
+	half := len(data) / 2
+	if err := os.WriteFile(filepath.Join(remote, "big"+partSuffix), data[:half], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first := int64(-1)
+	progress := func(_ string, done, _ int64) {
+		if first < 0 {
+			first = done
+		}
+	}
+	if err := client.Upload(src, filepath.Join(remote, "big"), TransferOptions{Resume: true, Progress: progress}); err != nil {
+		t.Fatalf("Upload gave err: %v", err)
+	}
+	checkFile(t, filepath.Join(remote, "big"), data, 0o644)
+	if first != int64(half) {
+		t.Errorf("Expected the upload to resume at %d, started at %d", half, first)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	remote, local := t.TempDir(), t.TempDir()
+	client := serveSFTP(t)
+
+	data := randomFile(t, filepath.Join(remote, "result"), 3<<20+99, 0o640)
+	dst := filepath.Join(local, "result")
+	var last int64
+	progress := func(_ string, done, _ int64) { last = done }
+	if err := client.Download(filepath.Join(remote, "result"), dst, TransferOptions{Progress: progress}); err != nil {
+		t.Fatalf("Download gave err: %v", err)
+	}
+	checkFile(t, dst, data, 0o640)
+	if last != int64(len(data)) {
+		t.Errorf("Expected progress up to %d, got %d", len(data), last)
+	}
+
+	// A partial download is continued.
+	os.Remove(dst)
+	if err := os.WriteFile(dst+partSuffix, data[:1000], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Download(filepath.Join(remote, "result"), dst, TransferOptions{Resume: true}); err != nil {
+		t.Fatalf("Download gave err: %v", err)
+	}
+	checkFile(t, dst, data, 0o640)
+
+	if err := client.Download(filepath.Join(remote, "missing"), dst, TransferOptions{}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing file to be fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	remote, local := t.TempDir(), t.TempDir()
+	client := serveSFTP(t)
+
+	if err := os.MkdirAll(filepath.Join(local, "site", "static", "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	index := randomFile(t, filepath.Join(local, "site", "index.html"), 100, 0o644)
+	css := randomFile(t, filepath.Join(local, "site", "static", "css", "main.css"), 64<<10, 0o644)
+	run := randomFile(t, filepath.Join(local, "site", "run.sh"), 10, 0o755)
+	if err := os.Symlink("index.html", filepath.Join(local, "site", "link.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.UploadDir(filepath.Join(local, "site"), filepath.Join(remote, "srv"), TransferOptions{}); err != nil {
+		t.Fatalf("UploadDir gave err: %v", err)
+	}
+	checkFile(t, filepath.Join(remote, "srv", "index.html"), index, 0o644)
+	checkFile(t, filepath.Join(remote, "srv", "static", "css", "main.css"), css, 0o644)
+	checkFile(t, filepath.Join(remote, "srv", "run.sh"), run, 0o755)
+	if _, err := os.Lstat(filepath.Join(remote, "srv", "link.html")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the symlink to be skipped, got %v", err)
+	}
+
+	// Uploading again goes over the existing directories.
+	if err := client.UploadDir(filepath.Join(local, "site"), filepath.Join(remote, "srv"), TransferOptions{}); err != nil {
+		t.Fatalf("UploadDir gave err the second time: %v", err)
+	}
+}
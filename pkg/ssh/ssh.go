@@ -1,30 +1,50 @@
 package ssh
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-// Config contains SSH connection configuration
+// Config contains SSH connection configuration. Connect logs in with the
+// private key when given, then the password, and falls back to the keys of
+// the ssh-agent SSH_AUTH_SOCK points at when given neither.
 type Config struct {
 	Host       string
 	Port       int
 	Username   string
 	Password   string
-	PrivateKey string
+	PrivateKey string // path of the private key file
+	Passphrase string // passphrase of an encrypted private key
 	Timeout    time.Duration
+	// HostKeyCallback checks the host key of the server, see KnownHosts,
+	// TrustOnFirstUse, TrustFirstKey and PinnedHostKeys. Connect fails
+	// without one, unless InsecureIgnoreHostKey is set.
+	HostKeyCallback ssh.HostKeyCallback
+	// InsecureIgnoreHostKey accepts any host key when there is no
+	// HostKeyCallback, which leaves the connection open to a man in the
+	// middle.
+	InsecureIgnoreHostKey bool
 }
 
+// ErrNoHostKeyCallback is returned by Connect when Config has neither a
+// HostKeyCallback nor InsecureIgnoreHostKey.
+var ErrNoHostKeyCallback = errors.New("no way to check the host key, set a HostKeyCallback or InsecureIgnoreHostKey")
+
 // Client represents an SSH client connection
 type Client struct {
-	config     Config
-	sshClient  *ssh.Client
-	connected  bool
+	config    Config
+	sshClient *ssh.Client
+	connected bool
+	agentConn net.Conn
 }
 
 // NewClient creates a new SSH client
@@ -42,50 +62,122 @@ func NewClient(config Config) *Client {
 
 // Connect establishes an SSH connection
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect giving up on connecting and logging in when ctx
+// is done.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	if c.connected {
 		return nil
 	}
 
-	var authMethod ssh.AuthMethod
-
-	if c.config.PrivateKey != "" {
-		// Use private key authentication
-		key, err := os.ReadFile(c.config.PrivateKey)
-		if err != nil {
-			return fmt.Errorf("unable to read private key: %w", err)
-		}
+	auth, err := c.authMethods()
+	if err != nil {
+		return err
+	}
 
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return fmt.Errorf("unable to parse private key: %w", err)
+	hostKeyCallback := c.config.HostKeyCallback
+	if hostKeyCallback == nil {
+		if !c.config.InsecureIgnoreHostKey {
+			return ErrNoHostKeyCallback
 		}
-
-		authMethod = ssh.PublicKeys(signer)
-	} else {
-		// Use password authentication
-		authMethod = ssh.Password(c.config.Password)
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
 	}
-
 	sshConfig := &ssh.ClientConfig{
-		User: c.config.Username,
-		Auth: []ssh.AuthMethod{authMethod},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
+		User:            c.config.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         c.config.Timeout,
 	}
 
 	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
-	client, err := ssh.Dial("tcp", addr, sshConfig)
+	dialer := net.Dialer{Timeout: c.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to SSH server: %w", err)
 	}
+	// Closing the connection stops a handshake that hangs.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if !stop() {
+		if err == nil {
+			sshConn.Close()
+		}
+		return ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SSH server: %w", err)
+	}
 
-	c.sshClient = client
+	c.sshClient = ssh.NewClient(sshConn, chans, reqs)
 	c.connected = true
 	return nil
 }
 
+// authMethods returns how Connect logs in, as Config describes.
+func (c *Client) authMethods() ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	if c.config.PrivateKey != "" {
+		signer, err := loadPrivateKey(c.config.PrivateKey, c.config.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.config.Password != "" {
+		auth = append(auth, ssh.Password(c.config.Password))
+	}
+	if len(auth) > 0 {
+		return auth, nil
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if c.agentConn == nil {
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				return nil, fmt.Errorf("connecting to ssh-agent at %s gave err: %w", sock, err)
+			}
+			c.agentConn = conn
+		}
+		return []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(c.agentConn).Signers)}, nil
+	}
+	// Servers allowing empty passwords still need the method offered.
+	return []ssh.AuthMethod{ssh.Password("")}, nil
+}
+
+// loadPrivateKey reads the private key at path, decrypting it with
+// passphrase when it is encrypted.
+func loadPrivateKey(path, passphrase string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %w", err)
+	}
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("private key %s is encrypted, a passphrase is needed", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
 // Close closes the SSH connection
 func (c *Client) Close() error {
+	if c.agentConn != nil {
+		c.agentConn.Close()
+		c.agentConn = nil
+	}
 	if c.sshClient != nil {
 		c.connected = false
 		return c.sshClient.Close()
@@ -115,75 +207,130 @@ func (c *Client) Execute(command string) (string, error) {
 	return string(output), nil
 }
 
-// UploadFile uploads a local file to the remote server using SCP
-func (c *Client) UploadFile(localPath, remotePath string) error {
+// Stream runs a command on the remote server, copying its output to stdout
+// and stderr as it comes instead of collecting it.
+func (c *Client) Stream(command string, stdout, stderr io.Writer) error {
+	return c.ExecuteStream(context.Background(), command, stdout, stderr)
+}
+
+// ExitError is returned for a remote command that exited with a non-zero
+// status or was killed by a signal.
+type ExitError struct {
+	Code   int    // exit status, -1 when killed by a signal
+	Signal string // the signal that killed the command, such as "KILL"
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("remote command killed by signal %s", e.Signal)
+	}
+	return fmt.Sprintf("remote command exited with status %d", e.Code)
+}
+
+// ExitCode returns the exit status of the remote command err reports, from
+// ExecuteStream or Execute, and whether err reports one. It is 0 for a nil
+// err.
+func ExitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code, true
+	}
+	var sshErr *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		if sshErr.Signal() != "" {
+			return -1, true
+		}
+		return sshErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// ExecuteStream runs a command on the remote server, copying its output to
+// stdout and stderr as it comes. A command exiting with a non-zero status
+// returns an *ExitError. When ctx is done first the server is asked to kill
+// the command, the session is closed and ctx.Err() returned.
+func (c *Client) ExecuteStream(ctx context.Context, command string, stdout, stderr io.Writer) error {
 	if !c.connected {
 		if err := c.Connect(); err != nil {
 			return err
 		}
 	}
-
-	// Open local file
-	localFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	defer localFile.Close()
 
-	// Get file info
-	fileInfo, err := localFile.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat local file: %w", err)
-	}
-
-	// Create SCP session
 	session, err := c.sshClient.NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	// Get stdin pipe
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("command execution failed: %w", err)
 	}
 
-	// Start SCP receive command on remote
-	go func() {
-		defer stdin.Close()
-
-		// Send file header
-		fmt.Fprintf(stdin, "C%04o %d %s\n", fileInfo.Mode().Perm(), fileInfo.Size(), filepath.Base(remotePath))
-
-		// Send file content
-		io.Copy(stdin, localFile)
-
-		// Send termination byte
-		fmt.Fprint(stdin, "\x00")
-	}()
-
-	// Execute SCP command
-	remoteDir := filepath.Dir(remotePath)
-	if err := session.Run(fmt.Sprintf("scp -t %s", remoteDir)); err != nil {
-		return fmt.Errorf("scp command failed: %w", err)
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Not every server honours signals, closing the session ends the
+		// command's output either way.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
 	}
 
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Signal() != "" {
+			return &ExitError{Code: -1, Signal: exitErr.Signal()}
+		}
+		return &ExitError{Code: exitErr.ExitStatus()}
+	}
+	if err != nil {
+		return fmt.Errorf("command execution failed: %w", err)
+	}
 	return nil
 }
 
-// WaitForConnection retries SSH connection until successful or timeout
-func (c *Client) WaitForConnection(maxRetries int, retryDelay time.Duration) error {
+// UploadFile uploads a local file to the remote server over SFTP, keeping
+// its permissions. See Upload for progress reporting and resuming.
+func (c *Client) UploadFile(localPath, remotePath string) error {
+	return c.Upload(localPath, remotePath, TransferOptions{})
+}
+
+// WaitForConnection retries SSH connection until successful, maxRetries
+// tries were made or ctx is done.
+func (c *Client) WaitForConnection(ctx context.Context, maxRetries int, retryDelay time.Duration) error {
 	for i := 0; i < maxRetries; i++ {
-		err := c.Connect()
+		err := c.ConnectContext(ctx)
 		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		if i < maxRetries-1 {
-			time.Sleep(retryDelay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay):
+			}
 		}
 	}
 
 	return fmt.Errorf("failed to establish SSH connection after %d attempts", maxRetries)
-}
\ No newline at end of file
+}
+
+// ShellQuote quotes s as a single word for the POSIX shell commands such as
+// Execute runs.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,305 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveKeyLogin accepts SSH logins with the key of signer on a local port.
+// It returns the host and port.
+func serveKeyLogin(t *testing.T, user ssh.Signer) (string, int) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(user.PublicKey().Marshal())
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != want {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, chans, reqs, err := ssh.NewServerConn(conn, config); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "no sessions")
+					}
+					sc.Close()
+				}
+				conn.Close()
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func TestConnectWithPrivateKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port := serveKeyLogin(t, signer)
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	client := NewClient(Config{Host: host, Port: port, Username: "root", PrivateKey: path, InsecureIgnoreHostKey: true})
+	if err := client.Connect(); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("Expected an error asking for the passphrase, got %v", err)
+	}
+	client = NewClient(Config{Host: host, Port: port, Username: "root", PrivateKey: path, Passphrase: "wrong", InsecureIgnoreHostKey: true})
+	if err := client.Connect(); err == nil {
+		t.Error("Expected a wrong passphrase to fail")
+	}
+	client = NewClient(Config{Host: host, Port: port, Username: "root", PrivateKey: path, Passphrase: "secret"})
+	if err := client.Connect(); !errors.Is(err, ErrNoHostKeyCallback) {
+		t.Errorf("Expected to refuse to connect without a way to check the host key, got %v", err)
+	}
+	client = NewClient(Config{Host: host, Port: port, Username: "root", PrivateKey: path, Passphrase: "secret", InsecureIgnoreHostKey: true})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected to log in with the key, got %v", err)
+	}
+	client.Close()
+}
+
+func TestConnectWithAgent(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port := serveKeyLogin(t, signer)
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	client := NewClient(Config{Host: host, Port: port, Username: "root", InsecureIgnoreHostKey: true})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Expected to log in with the key of the agent, got %v", err)
+	}
+	client.Close()
+}
+
+// serveExec runs the commands "out", which writes to stdout and stderr, "fail",
+// which exits with status 3, and "hang", which waits for the session to
+// close, on a local port accepting any password. It returns a client for it.
+func serveExec(t *testing.T) *Client {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newCh := range chans {
+					ch, reqs, err := newCh.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						defer ch.Close()
+						for req := range reqs {
+							if req.Type != "exec" {
+								req.Reply(false, nil)
+								continue
+							}
+							req.Reply(true, nil)
+							status := 0
+							switch string(req.Payload[4:]) {
+							case "out":
+								ch.Write([]byte("hello\n"))
+								ch.Stderr().Write([]byte("warn\n"))
+							case "fail":
+								status = 3
+							case "hang":
+								for range reqs {
+								}
+								return
+							}
+							ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+							return
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client := NewClient(Config{Host: host, Port: p, Username: "dtt", Password: "dtt", InsecureIgnoreHostKey: true})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestExecuteStream(t *testing.T) {
+	client := serveExec(t)
+
+	var stdout, stderr strings.Builder
+	if err := client.ExecuteStream(context.Background(), "out", &stdout, &stderr); err != nil {
+		t.Fatalf("ExecuteStream gave err: %v", err)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "warn\n" {
+		t.Errorf("Expected the streams apart, got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+
+	err := client.ExecuteStream(context.Background(), "fail", io.Discard, io.Discard)
+	if code, ok := ExitCode(err); !ok || code != 3 {
+		t.Errorf("Expected exit status 3, got %d, %v from %v", code, ok, err)
+	}
+	_, err = client.Execute("fail")
+	if code, ok := ExitCode(err); !ok || code != 3 {
+		t.Errorf("Expected exit status 3 from Execute, got %d, %v from %v", code, ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.ExecuteStream(ctx, "hang", io.Discard, io.Discard); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the command to be given up on, got %v", err)
+	}
+	if code, ok := ExitCode(nil); !ok || code != 0 {
+		t.Errorf("Expected status 0 without an error, got %d, %v", code, ok)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"/usr/local/bin/tool": `'/usr/local/bin/tool'`,
+		"/opt/my tools/bin":   `'/opt/my tools/bin'`,
+		"it's":                `'it'\''s'`,
+		"":                    `''`,
+	} {
+		if got := ShellQuote(in); got != want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestConnectContextCanceled(t *testing.T) {
+	// A server that accepts connections and never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	client := NewClient(Config{Host: host, Port: p, Username: "dtt", Password: "dtt", InsecureIgnoreHostKey: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.ConnectContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline of the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected to give up when the context is done, took %s", elapsed)
+	}
+	if err := client.WaitForConnection(ctx, 3, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected WaitForConnection to stop with the context, got %v", err)
+	}
+}